@@ -11,13 +11,16 @@ const (
 	StatusProcessing  = "processing"
 	StatusReady       = "ready"
 	StatusDeactivated = "deactivated"
+	StatusCanceled    = "canceled"
+	StatusRevoked     = "revoked"
 
 	IdentifierDNS = "dns"
 	IdentifierIP  = "ip"
 
-	ChallengeHTTP01    = "http-01"
-	ChallengeTLSALPN01 = "tls-alpn-01"
-	ChallengeDNS01     = "dns-01"
+	ChallengeHTTP01     = "http-01"
+	ChallengeTLSALPN01  = "tls-alpn-01"
+	ChallengeDNS01      = "dns-01"
+	ChallengeOnionCSR01 = "onion-csr-01"
 
 	HTTP01BaseURL = ".well-known/acme-challenge/"
 
@@ -27,6 +30,9 @@ const (
 type Identifier struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
+	// AncestorDomain requests that the authorization for a DNS identifier is
+	// created for the given ancestor domain instead. See RFC 9444.
+	AncestorDomain string `json:"ancestorDomain,omitempty"`
 }
 
 func (ident Identifier) Equals(other Identifier) bool {
@@ -43,6 +49,9 @@ type Account struct {
 	Status  string   `json:"status"`
 	Contact []string `json:"contact,omitempty"`
 	Orders  string   `json:"orders,omitempty"`
+	// Delegations is the URL of the account's list of STAR delegation objects
+	// (RFC 9115). It is only present for accounts with delegations.
+	Delegations string `json:"delegations,omitempty"`
 
 	ExternalAccountBinding *JSONSigned `json:"externalAccountBinding,omitempty"`
 }
@@ -58,6 +67,31 @@ type Order struct {
 	NotAfter       string          `json:"notAfter,omitempty"`
 	Authorizations []string        `json:"authorizations"`
 	Certificate    string          `json:"certificate,omitempty"`
+	// AutoRenewal and StarCertificate are only present for ACME STAR recurrent
+	// orders. See RFC 8739 Section 3.1.1.
+	AutoRenewal     *AutoRenewal `json:"auto-renewal,omitempty"`
+	StarCertificate string       `json:"star-certificate,omitempty"`
+	// Delegation is the URL of the STAR delegation object (RFC 9115) an order
+	// was placed for. AllowCertificateGet allows the third party the
+	// certificate is delegated to to fetch a non-recurrent order's certificate
+	// with an unauthenticated GET request.
+	Delegation          string `json:"delegation,omitempty"`
+	AllowCertificateGet bool   `json:"allow-certificate-get,omitempty"`
+	// Profile is the name of the certificate profile selected for the order.
+	Profile string `json:"profile,omitempty"`
+	// Replaces is the ARI certificate identifier of the certificate the order
+	// replaces. See RFC 9773 Section 5.
+	Replaces string `json:"replaces,omitempty"`
+}
+
+// AutoRenewal is the "auto-renewal" object of an ACME STAR (RFC 8739)
+// recurrent order. Dates are RFC 3339 strings and durations are in seconds.
+type AutoRenewal struct {
+	StartDate           string `json:"start-date,omitempty"`
+	EndDate             string `json:"end-date"`
+	Lifetime            int    `json:"lifetime"`
+	LifetimeAdjust      int    `json:"lifetime-adjust,omitempty"`
+	AllowCertificateGet bool   `json:"allow-certificate-get,omitempty"`
 }
 
 // An Authorization is created for each identifier in an order
@@ -72,6 +106,9 @@ type Authorization struct {
 	// Authorization with the identifier `example.com` and one DNS-01 challenge
 	// corresponds to a name `*.example.com` from an associated order.
 	Wildcard bool `json:"wildcard,omitempty"`
+	// SubdomainAuthAllowed indicates that the authorization also authorizes
+	// subdomains of its identifier. See RFC 9444.
+	SubdomainAuthAllowed bool `json:"subdomainAuthAllowed,omitempty"`
 }
 
 // A Challenge is used to validate an Authorization
@@ -82,4 +119,81 @@ type Challenge struct {
 	Status    string          `json:"status"`
 	Validated string          `json:"validated,omitempty"`
 	Error     *ProblemDetails `json:"error,omitempty"`
+	// Nonce is the base64url encoded CA signing nonce of an onion-csr-01
+	// challenge.
+	Nonce string `json:"nonce,omitempty"`
+	// ValidationRecord describes the requests the last validation of the
+	// challenge made, like the validationRecord of Boulder challenges
+	ValidationRecord []ValidationRecord `json:"validationRecord,omitempty"`
+}
+
+// A ValidationRecord describes a request made to validate a challenge: an
+// HTTP-01 request, of which there is one per redirect followed, a TLS-ALPN-01
+// connection or a DNS-01 TXT query.
+type ValidationRecord struct {
+	// URL requested by an HTTP-01 validation
+	URL string `json:"url,omitempty"`
+	// Hostname connected to, or the name whose TXT records were queried
+	Hostname string `json:"hostname"`
+	Port     string `json:"port,omitempty"`
+	// Addresses the hostname resolved to, the addresses connecting to failed
+	// and the address connected to
+	AddressesResolved []string `json:"addressesResolved,omitempty"`
+	AddressesTried    []string `json:"addressesTried,omitempty"`
+	AddressUsed       string   `json:"addressUsed,omitempty"`
+	// TXT record values observed by a DNS-01 validation
+	TXTRecords []string `json:"txtRecords,omitempty"`
+	// Beginning of the response to a failed HTTP-01 request
+	ResponseSnippet string `json:"responseSnippet,omitempty"`
+}
+
+// A Delegation is a STAR delegation configuration object (RFC 9115 Section
+// 2.3.1) that allows an identifier owner to obtain certificates on behalf of
+// a third party.
+type Delegation struct {
+	CSRTemplate CSRTemplate       `json:"csr-template"`
+	CNAMEMap    map[string]string `json:"cname-map,omitempty"`
+}
+
+// CSRTemplate constrains the CSRs that may be finalized for a delegated order.
+// See RFC 9115 Section 4.
+//
+// Template values of "*" match any value (including none) and values of "**"
+// match any non-empty value.
+type CSRTemplate struct {
+	KeyTypes   []CSRTemplateKeyType  `json:"keyTypes"`
+	Subject    map[string]string     `json:"subject,omitempty"`
+	Extensions CSRTemplateExtensions `json:"extensions"`
+}
+
+// CSRTemplateKeyType describes a public key type allowed by a CSRTemplate.
+type CSRTemplateKeyType struct {
+	PublicKeyType   string `json:"PublicKeyType"`
+	PublicKeyLength int    `json:"PublicKeyLength,omitempty"`
+	NamedCurve      string `json:"namedCurve,omitempty"`
+	SignatureType   string `json:"SignatureType,omitempty"`
+}
+
+// CSRTemplateExtensions describes the extensions allowed by a CSRTemplate.
+type CSRTemplateExtensions struct {
+	SubjectAltName struct {
+		DNS []string `json:"DNS"`
+	} `json:"subjectAltName"`
+	KeyUsage         []string `json:"keyUsage,omitempty"`
+	ExtendedKeyUsage []string `json:"extendedKeyUsage,omitempty"`
+}
+
+// RenewalInfo is the renewalInfo object of the ACME Renewal Information (ARI)
+// extension, suggesting when a certificate should be renewed. See RFC 9773
+// Section 4.2.
+type RenewalInfo struct {
+	SuggestedWindow SuggestedWindow `json:"suggestedWindow"`
+	ExplanationURL  string          `json:"explanationURL,omitempty"`
+}
+
+// SuggestedWindow is the renewal window of a RenewalInfo object. Start and End
+// are RFC 3339 timestamps.
+type SuggestedWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
@@ -3,6 +3,7 @@ package acme
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -12,6 +13,7 @@ const (
 	badNonceErr            = errNS + "badNonce"
 	badCSRErr              = errNS + "badCSR"
 	agreementReqErr        = errNS + "agreementRequired"
+	userActionReqErr       = errNS + "userActionRequired"
 	externalAccountReqErr  = errNS + "externalAccountRequired"
 	connectionErr          = errNS + "connection"
 	unauthorizedErr        = errNS + "unauthorized"
@@ -22,18 +24,90 @@ const (
 	alreadyRevokedErr      = errNS + "alreadyRevoked"
 	orderNotReadyErr       = errNS + "orderNotReady"
 	badPublicKeyErr        = errNS + "badPublicKey"
+	badSignatureAlgErr     = errNS + "badSignatureAlgorithm"
+	invalidProfileErr      = errNS + "invalidProfile"
+	rejectedIdentifierErr  = errNS + "rejectedIdentifier"
+	rateLimitedErr         = errNS + "rateLimited"
+	caaErr                 = errNS + "caa"
+	dnsErr                 = errNS + "dns"
+
+	// ACME Renewal Information (RFC 9773) error types
+	alreadyReplacedErr = errNS + "alreadyReplaced"
+
+	// ACME STAR (RFC 8739) error types
+	autoRenewalCanceledErr               = errNS + "autoRenewalCanceled"
+	autoRenewalExpiredErr                = errNS + "autoRenewalExpired"
+	autoRenewalCancellationInvalidErr    = errNS + "autoRenewalCancellationInvalid"
+	autoRenewalRevocationNotSupportedErr = errNS + "autoRenewalRevocationNotSupported"
 )
 
 type ProblemDetails struct {
-	Type       string `json:"type,omitempty"`
-	Detail     string `json:"detail,omitempty"`
-	HTTPStatus int    `json:"status,omitempty"`
+	Type        string              `json:"type,omitempty"`
+	Detail      string              `json:"detail,omitempty"`
+	HTTPStatus  int                 `json:"status,omitempty"`
+	Subproblems []SubProblemDetails `json:"subproblems,omitempty"`
+	// Algorithms lists the acceptable JWS algorithms of a
+	// badSignatureAlgorithm problem. See RFC 8555 Section 6.2.
+	Algorithms []string `json:"algorithms,omitempty"`
+	// RequestID is the ID of the ACME request that failed, a Pebble specific
+	// extension member for correlating problems with log lines
+	RequestID string `json:"requestId,omitempty"`
+	// Instance is a URL of a page the user should be directed to, like the
+	// terms of service of a userActionRequired problem
+	Instance string `json:"instance,omitempty"`
 }
 
 func (pd *ProblemDetails) Error() string {
 	return fmt.Sprintf("%s :: %s", pd.Type, pd.Detail)
 }
 
+// IsRejectedIdentifier returns whether the problem is a rejectedIdentifier
+// problem.
+func (pd *ProblemDetails) IsRejectedIdentifier() bool {
+	return pd.Type == rejectedIdentifierErr
+}
+
+// SubProblemDetails is the problem of a single identifier of a request that
+// failed because of several identifiers. See RFC 8555 Section 6.7.1.
+type SubProblemDetails struct {
+	ProblemDetails
+	Identifier Identifier `json:"identifier"`
+}
+
+// SubProblem returns a subproblem for the given identifier.
+func SubProblem(ident Identifier, prob *ProblemDetails) SubProblemDetails {
+	return SubProblemDetails{
+		ProblemDetails: *prob,
+		Identifier: Identifier{
+			Type:  ident.Type,
+			Value: ident.Value,
+		},
+	}
+}
+
+// IdentifiersProblem combines the problems of the identifiers of a request
+// into a single problem with subproblems. If only one identifier has
+// a problem, its type and detail are used for the top-level problem,
+// otherwise the problem is created with the constructor and detail given.
+// It returns nil if there are no subproblems.
+func IdentifiersProblem(
+	constructor func(detail string) *ProblemDetails,
+	detail string,
+	subproblems []SubProblemDetails) *ProblemDetails {
+	if len(subproblems) == 0 {
+		return nil
+	}
+	var prob *ProblemDetails
+	if len(subproblems) == 1 {
+		first := subproblems[0].ProblemDetails
+		prob = &first
+	} else {
+		prob = constructor(fmt.Sprintf("%s (%d identifiers)", detail, len(subproblems)))
+	}
+	prob.Subproblems = subproblems
+	return prob
+}
+
 func InternalErrorProblem(detail string) *ProblemDetails {
 	return &ProblemDetails{
 		Type:       serverInternalErr,
@@ -98,6 +172,16 @@ func AgreementRequiredProblem(detail string) *ProblemDetails {
 	}
 }
 
+// UserActionRequiredProblem is the problem of requests of accounts that must
+// agree to changed terms of service. See RFC 8555 Section 7.3.3.
+func UserActionRequiredProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       userActionReqErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
 func ExternalAccountRequiredProblem(detail string) *ProblemDetails {
 	return &ProblemDetails{
 		Type:       externalAccountReqErr,
@@ -185,3 +269,131 @@ func BadPublicKeyProblem(detail string) *ProblemDetails {
 		HTTPStatus: http.StatusBadRequest,
 	}
 }
+
+func BadSignatureAlgorithmProblem(detail string, algorithms []string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       badSignatureAlgErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+		Algorithms: algorithms,
+	}
+}
+
+func AutoRenewalCanceledProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       autoRenewalCanceledErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
+func AutoRenewalExpiredProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       autoRenewalExpiredErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
+func AutoRenewalCancellationInvalidProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       autoRenewalCancellationInvalidErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func AutoRenewalRevocationNotSupportedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       autoRenewalRevocationNotSupportedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func RejectedIdentifierProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       rejectedIdentifierErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func RateLimitedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       rateLimitedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+func CAAProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       caaErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
+func DNSProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       dnsErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func InvalidProfileProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       invalidProfileErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func AlreadyReplacedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       alreadyReplacedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusConflict,
+	}
+}
+
+// PausedProblem is the rateLimited problem of the new orders of a paused
+// account, like Boulder's.
+func PausedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       rateLimitedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// problemsByType are the constructors of the problems of the types that
+// validations and orders may fail with.
+var problemsByType = map[string]func(string) *ProblemDetails{
+	serverInternalErr:     InternalErrorProblem,
+	malformedErr:          MalformedProblem,
+	badCSRErr:             BadCSRProblem,
+	connectionErr:         ConnectionProblem,
+	unauthorizedErr:       UnauthorizedProblem,
+	rejectedIdentifierErr: RejectedIdentifierProblem,
+	rateLimitedErr:        RateLimitedProblem,
+	caaErr:                CAAProblem,
+	dnsErr:                DNSProblem,
+}
+
+// ProblemOfType returns a problem with the detail of a type that validations
+// and orders may fail with, given by its URN, e.g.
+// "urn:ietf:params:acme:error:dns", or the part after the ACME error
+// namespace, e.g. "dns". It returns false if the type isn't one of them.
+func ProblemOfType(typ, detail string) (*ProblemDetails, bool) {
+	if !strings.HasPrefix(typ, errNS) {
+		typ = errNS + typ
+	}
+	newProblem, ok := problemsByType[typ]
+	if !ok {
+		return nil, false
+	}
+	return newProblem(detail), true
+}
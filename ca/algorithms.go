@@ -0,0 +1,99 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// defaultKeyAlgorithm is the key algorithm of generated CA hierarchies.
+const defaultKeyAlgorithm = "rsa2048"
+
+// keyAlgorithms maps the supported CA key algorithm names to functions
+// generating a key of that algorithm.
+var keyAlgorithms = map[string]func() (crypto.Signer, error){
+	"rsa2048": func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	},
+	"rsa3072": func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 3072)
+	},
+	"rsa4096": func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 4096)
+	},
+	"ecdsa-p256": func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	},
+	"ecdsa-p384": func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	},
+	"ed25519": func() (crypto.Signer, error) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	},
+}
+
+// signatureAlgorithms lists the signature algorithms that leaf certificates
+// can be signed with, by the name used in crypto/x509 (e.g. "ECDSA-SHA384").
+var signatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.SHA256WithRSA,
+	x509.SHA384WithRSA,
+	x509.SHA512WithRSA,
+	x509.SHA256WithRSAPSS,
+	x509.SHA384WithRSAPSS,
+	x509.SHA512WithRSAPSS,
+	x509.ECDSAWithSHA256,
+	x509.ECDSAWithSHA384,
+	x509.ECDSAWithSHA512,
+	x509.PureEd25519,
+}
+
+// signatureAlgorithmFits returns true if a signature algorithm can be used
+// with the given signing key.
+func signatureAlgorithmFits(alg x509.SignatureAlgorithm, key crypto.Signer) bool {
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+			x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		switch alg {
+		case x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+			return true
+		}
+	case ed25519.PublicKey:
+		return alg == x509.PureEd25519
+	}
+	return false
+}
+
+// SetLeafSignatureAlgorithm overrides the signature algorithm leaf
+// certificates are signed with. By default the algorithm is chosen based on
+// the issuing key. The algorithm must fit the key of every issuing
+// intermediate. It must be called before the CA starts issuing certificates.
+func (ca *CAImpl) SetLeafSignatureAlgorithm(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, alg := range signatureAlgorithms {
+		if alg.String() != name {
+			continue
+		}
+		for _, c := range ca.getChains() {
+			if !signatureAlgorithmFits(alg, c.intermediates[0].key) {
+				return fmt.Errorf("leaf signature algorithm %q does not fit the issuing key of chain %s", name, c)
+			}
+		}
+		ca.leafSignatureAlgorithm = alg
+		ca.log.Printf("Signing leaf certificates with %s", alg)
+		return nil
+	}
+	return fmt.Errorf("unsupported leaf signature algorithm %q", name)
+}
@@ -1,9 +1,9 @@
 package ca
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -15,24 +15,60 @@ import (
 	"math/big"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
 	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/ct"
 	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/random"
+	"github.com/letsencrypt/pebble/trace"
+	"github.com/letsencrypt/pebble/webhook"
 )
 
 const (
 	rootCAPrefix         = "Pebble Root CA "
 	intermediateCAPrefix = "Pebble Intermediate CA "
+
+	// DefaultProfile is the name of the profile used for orders that don't
+	// select a profile.
+	DefaultProfile = "default"
 )
 
 type CAImpl struct {
 	log              *log.Logger
+	clk              clock.Clock
 	db               *db.MemoryStore
 	ocspResponderURL string
+	urls             CertificateURLConfig
+
+	// chains can be rotated and extended at runtime, chainsMu protects them.
+	// The first chain is the default chain.
+	chainsMu    sync.RWMutex
+	chains      []*chain
+	chainLength int
+
+	// The profiles and the issuance delay can be reconfigured while the CA
+	// issues certificates, configMu protects them. Profiles are replaced
+	// rather than modified.
+	configMu           sync.RWMutex
+	profiles           map[string]*core.Profile
+	shortLivedValidity int
+	issuanceDelay      IssuanceDelay
+
+	keyAlgorithm           string
+	leafSignatureAlgorithm x509.SignatureAlgorithm
+	// The pool new CA keys are taken from, nil if there is none
+	keyPool *KeyPool
 
-	chains []*chain
+	ctLogs []*ct.Log
+
+	serials *serialGenerator
+
+	webhooks *webhook.Notifier
+	tracer   *trace.Tracer
 }
 
 type chain struct {
@@ -57,7 +93,7 @@ type issuer struct {
 }
 
 func makeSerial() *big.Int {
-	serial, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	serial, err := rand.Int(random.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
 		panic(fmt.Sprintf("unable to create random serial number: %s", err.Error()))
 	}
@@ -90,9 +126,14 @@ func makeSubjectKeyID(key crypto.PublicKey) ([]byte, error) {
 // makeKey and makeRootCert are adapted from MiniCA:
 // https://github.com/jsha/minica/blob/3a621c05b61fa1c24bcb42fbde4b261db504a74f/main.go
 
-// makeKey creates a new 2048 bit RSA private key and a Subject Key Identifier
-func makeKey() (*rsa.PrivateKey, []byte, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+// makeKey creates a new private key of the given algorithm (see keyAlgorithms)
+// and a Subject Key Identifier
+func makeKey(algorithm string) (crypto.Signer, []byte, error) {
+	generate, ok := keyAlgorithms[algorithm]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+	}
+	key, err := generate()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -113,8 +154,8 @@ func (ca *CAImpl) makeRootCert(
 	template := &x509.Certificate{
 		Subject:      subject,
 		SerialNumber: serial,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(30, 0, 0),
+		NotBefore:    ca.clk.Now(),
+		NotAfter:     ca.clk.Now().AddDate(30, 0, 0),
 
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
@@ -128,6 +169,7 @@ func (ca *CAImpl) makeRootCert(
 	if signer != nil && signer.key != nil && signer.cert != nil && signer.cert.Cert != nil {
 		signerKey = signer.key
 		parent = signer.cert.Cert
+		ca.urls.Intermediate.apply(template)
 	} else {
 		signerKey = subjectKey
 		parent = template
@@ -162,7 +204,7 @@ func (ca *CAImpl) makeRootCert(
 
 func (ca *CAImpl) newRootIssuer(name string) (*issuer, error) {
 	// Make a root private key
-	rk, subjectKeyID, err := makeKey()
+	rk, subjectKeyID, err := ca.newKey()
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +260,7 @@ func (ca *CAImpl) newChain(intermediateKey crypto.Signer, intermediateSubject pk
 	prev := root
 	intermediates := make([]*issuer, numIntermediates)
 	for i := numIntermediates - 1; i > 0; i-- {
-		k, ski, err := makeKey()
+		k, ski, err := ca.newKey()
 		if err != nil {
 			panic(fmt.Sprintf("Error creating new intermediate issuer: %v", err))
 		}
@@ -248,7 +290,14 @@ func (ca *CAImpl) newChain(intermediateKey crypto.Signer, intermediateSubject pk
 	return c
 }
 
-func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.PublicKey, accountID string) (*core.Certificate, error) {
+func (ca *CAImpl) newCertificate(
+	domains []string,
+	ips []net.IP,
+	key crypto.PublicKey,
+	accountID string,
+	notBefore, notAfter time.Time,
+	recurrent bool,
+	profile *core.Profile) (*core.Certificate, error) {
 	var cn string
 	if len(domains) > 0 {
 		cn = domains[0]
@@ -258,7 +307,8 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 		return nil, fmt.Errorf("must specify at least one domain name or IP address")
 	}
 
-	defaultChain := ca.chains[0].intermediates
+	chains := ca.getChains()
+	defaultChain := chains[0].intermediates
 	if len(defaultChain) == 0 || defaultChain[0].cert == nil {
 		return nil, fmt.Errorf("cannot sign certificate - nil issuer")
 	}
@@ -269,7 +319,10 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 		return nil, fmt.Errorf("cannot create subject key ID: %s", err.Error())
 	}
 
-	serial := makeSerial()
+	serial, err := ca.newLeafSerial()
+	if err != nil {
+		return nil, err
+	}
 	template := &x509.Certificate{
 		DNSNames:    domains,
 		IPAddresses: ips,
@@ -277,19 +330,31 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 			CommonName: cn,
 		},
 		SerialNumber: serial,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(5, 0, 0),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
 
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		SignatureAlgorithm:    ca.leafSignatureAlgorithm,
 		SubjectKeyId:          subjectKeyID,
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 	}
+	applyProfile(template, profile)
+	if core.MLDSAAlgorithm(key) != "" {
+		// ML-DSA keys can only be used for signatures
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment |
+			x509.KeyUsageKeyAgreement
+	}
 
 	if ca.ocspResponderURL != "" {
 		template.OCSPServer = []string{ca.ocspResponderURL}
 	}
+	ca.urls.Leaf.apply(template)
+
+	if profile != nil && profile.IncludeSCTs && len(ca.ctLogs) > 0 {
+		if err := ca.addSCTs(template, key, issuer); err != nil {
+			return nil, err
+		}
+	}
 
 	der, err := x509.CreateCertificate(rand.Reader, template, issuer.cert.Cert, key, issuer.key)
 	if err != nil {
@@ -300,10 +365,10 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 		return nil, err
 	}
 
-	issuers := make([][]*core.Certificate, len(ca.chains))
-	for i := 0; i < len(ca.chains); i++ {
-		issuerChain := make([]*core.Certificate, len(ca.chains[i].intermediates))
-		for j, cert := range ca.chains[i].intermediates {
+	issuers := make([][]*core.Certificate, len(chains))
+	for i := 0; i < len(chains); i++ {
+		issuerChain := make([]*core.Certificate, len(chains[i].intermediates))
+		for j, cert := range chains[i].intermediates {
 			issuerChain[j] = cert.cert
 		}
 		issuers[i] = issuerChain
@@ -316,6 +381,7 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 		Cert:         cert,
 		DER:          der,
 		IssuerChains: issuers,
+		Recurrent:    recurrent,
 	}
 	_, err = ca.db.AddCertificate(newCert)
 	if err != nil {
@@ -324,10 +390,52 @@ func (ca *CAImpl) newCertificate(domains []string, ips []net.IP, key crypto.Publ
 	return newCert, nil
 }
 
-func New(log *log.Logger, db *db.MemoryStore, ocspResponderURL string, alternateRoots int, chainLength int) *CAImpl {
+// SetTracer configures the tracer of certificate issuance. It must be called
+// before the CA issues certificates.
+func (ca *CAImpl) SetTracer(tracer *trace.Tracer) {
+	ca.tracer = tracer
+}
+
+// SetWebhooks configures the webhooks notified of issued certificates. It must
+// be called before the CA issues certificates.
+func (ca *CAImpl) SetWebhooks(webhooks *webhook.Notifier) {
+	ca.webhooks = webhooks
+}
+
+// New creates a CA. If existing is not nil the CA's hierarchy is loaded from
+// the given files, otherwise a new hierarchy is generated with keys of the
+// given algorithm (see keyAlgorithms, defaults to RSA 2048). Alternate roots
+// always use newly generated roots cross-signing the issuing intermediate's
+// key. The configured URLs are embedded in generated intermediates and in
+// leaf certificates. If keyPool is not nil and of the same algorithm the keys
+// are taken from it.
+func New(
+	log *log.Logger,
+	clk clock.Clock,
+	db *db.MemoryStore,
+	ocspResponderURL string,
+	alternateRoots int,
+	chainLength int,
+	existing *ExistingHierarchy,
+	keyAlgorithm string,
+	keyPool *KeyPool,
+	urls CertificateURLConfig) (*CAImpl, error) {
+	if keyAlgorithm == "" {
+		keyAlgorithm = defaultKeyAlgorithm
+	}
+	if _, ok := keyAlgorithms[keyAlgorithm]; !ok {
+		return nil, fmt.Errorf("unsupported CA key algorithm %q", keyAlgorithm)
+	}
 	ca := &CAImpl{
-		log: log,
-		db:  db,
+		log:          log,
+		clk:          clk,
+		db:           db,
+		keyAlgorithm: keyAlgorithm,
+		keyPool:      keyPool,
+		chainLength:  chainLength,
+		urls:         urls,
+		serials:      &serialGenerator{length: defaultSerialLength},
+		profiles:     defaultProfiles(),
 	}
 
 	if ocspResponderURL != "" {
@@ -335,70 +443,194 @@ func New(log *log.Logger, db *db.MemoryStore, ocspResponderURL string, alternate
 		ca.log.Printf("Setting OCSP responder URL for issued certificates to %q", ca.ocspResponderURL)
 	}
 
-	intermediateSubject := pkix.Name{
-		CommonName: intermediateCAPrefix + hex.EncodeToString(makeSerial().Bytes()[:3]),
-	}
-	intermediateKey, subjectKeyID, err := makeKey()
-	if err != nil {
-		panic(fmt.Sprintf("Error creating new intermediate private key: %s", err.Error()))
-	}
 	ca.chains = make([]*chain, 1+alternateRoots)
-	for i := 0; i < len(ca.chains); i++ {
+
+	var intermediateKey crypto.Signer
+	var intermediateSubject pkix.Name
+	var subjectKeyID []byte
+	first := 0
+	if existing != nil {
+		loaded, err := ca.loadChain(*existing)
+		if err != nil {
+			return nil, fmt.Errorf("loading existing CA hierarchy: %s", err)
+		}
+		ca.chains[0] = loaded
+		first = 1
+
+		issuingCert := loaded.intermediates[0].cert.Cert
+		intermediateKey = loaded.intermediates[0].key
+		intermediateSubject = issuingCert.Subject
+		subjectKeyID = issuingCert.SubjectKeyId
+	} else {
+		intermediateSubject = pkix.Name{
+			CommonName: intermediateCAPrefix + hex.EncodeToString(makeSerial().Bytes()[:3]),
+		}
+		key, ski, err := ca.newKey()
+		if err != nil {
+			panic(fmt.Sprintf("Error creating new intermediate private key: %s", err.Error()))
+		}
+		intermediateKey, subjectKeyID = key, ski
+	}
+
+	for i := first; i < len(ca.chains); i++ {
 		ca.chains[i] = ca.newChain(intermediateKey, intermediateSubject, subjectKeyID, chainLength)
 	}
-	return ca
+	return ca, nil
 }
 
-func (ca *CAImpl) CompleteOrder(order *core.Order) {
-	// Lock the order for reading
-	order.RLock()
-	// If the order isn't set as beganProcessing produce an error and immediately unlock
-	if !order.BeganProcessing {
-		ca.log.Printf("Error: Asked to complete order %s which had false beganProcessing.",
-			order.ID)
-		order.RUnlock()
+func (ca *CAImpl) CompleteOrder(ctx context.Context, order *core.Order) {
+	ctx, span := ca.tracer.Start(ctx, "ca.CompleteOrder", trace.KindInternal)
+	defer span.End()
+
+	snapshot := order.Snapshot()
+	span.SetAttribute("pebble.order.id", snapshot.ID)
+	prefix := core.RequestLogPrefix(ctx)
+	// If the order isn't set as beganProcessing produce an error
+	if !snapshot.BeganProcessing {
+		ca.log.Printf("%sError: Asked to complete order %s which had false beganProcessing.",
+			prefix, snapshot.ID)
 		return
 	}
-	// Unlock the order again
-	order.RUnlock()
 
 	// Check the authorizations - this is done by the VA before calling
 	// CompleteOrder but we do it again for robustness sake.
-	for _, authz := range order.AuthorizationObjects {
-		// Lock the authorization for reading
-		authz.RLock()
-		if authz.Status != acme.StatusValid {
+	for _, authz := range snapshot.AuthorizationObjects {
+		if authz.Snapshot().Status != acme.StatusValid {
 			return
 		}
-		authz.RUnlock()
 	}
 
-	// issue a certificate for the csr
-	csr := order.ParsedCSR
-	cert, err := ca.newCertificate(csr.DNSNames, csr.IPAddresses, csr.PublicKey, order.AccountID)
+	// ACME STAR recurrent orders are issued on a schedule until their end date
+	if snapshot.IsRecurrent() {
+		go ca.renewRecurrentOrder(order)
+		return
+	}
+
+	// Keep the order processing for a while, if configured
+	ca.waitIssuanceDelay()
+
+	// issue a certificate for the csr using the order's profile
+	csr := snapshot.ParsedCSR
+	profile := profileForCSR(ca.GetProfile(snapshot.Profile), csr)
+	notBefore := ca.clk.Now()
+	notAfter := notBefore.Add(ValidityPeriod(profile))
+	// Use the validity period requested by the order, if any
+	if !snapshot.NotBeforeDate.IsZero() {
+		notBefore, notAfter = snapshot.NotBeforeDate, snapshot.NotAfterDate
+	}
+	_, signSpan := ca.tracer.Start(ctx, "ca.sign", trace.KindInternal)
+	cert, err := ca.newCertificate(csr.DNSNames, csr.IPAddresses, csr.PublicKey, snapshot.AccountID, notBefore, notAfter, false, profile)
 	if err != nil {
-		ca.log.Printf("Error: unable to issue order: %s", err.Error())
+		ca.log.Printf("%sError: unable to issue order: %s", prefix, err.Error())
+		signSpan.SetError(err.Error())
+		signSpan.End()
+		span.SetError(err.Error())
 		return
 	}
-	ca.log.Printf("Issued certificate serial %s for order %s\n", cert.ID, order.ID)
+	signSpan.SetAttribute("pebble.certificate.serial", cert.ID)
+	signSpan.End()
+	ca.log.Printf("%sIssued certificate serial %s for order %s\n", prefix, cert.ID, snapshot.ID)
+	ca.webhooks.Notify(webhook.CertificateIssued, webhook.NewCertificateData(cert, snapshot.ID))
+
+	// Update the order to store the issued certificate. Delegated orders may
+	// allow the third party to fetch the certificate without authentication.
+	order.Update(func(order *core.Order) {
+		cert.AllowGet = order.AllowCertificateGet
+		order.CertificateObject = cert
+	})
+}
+
+// renewRecurrentOrder issues certificates for an ACME STAR (RFC 8739)
+// recurrent order. A new certificate with a validity period of
+// lifetime + lifetime-adjust is issued every lifetime, starting at the order's
+// start date, until the order's end date is reached or the order is canceled.
+func (ca *CAImpl) renewRecurrentOrder(order *core.Order) {
+	snapshot := order.Snapshot()
+	csr := snapshot.ParsedCSR
+	accountID := snapshot.AccountID
+	start := snapshot.AutoRenewalStart
+	end := snapshot.AutoRenewalEnd
+	lifetime := snapshot.AutoRenewalLifetime
+	lifetimeAdjust := snapshot.AutoRenewalLifetimeAdjust
+	// The lifetime of STAR certificates overrides the validity period of the
+	// order's profile
+	profile := profileForCSR(ca.GetProfile(snapshot.Profile), csr)
+
+	notBefore := start
+	if now := ca.clk.Now(); notBefore.Before(now) {
+		notBefore = now
+	}
+
+	for notBefore.Before(end) {
+		if order.Snapshot().Canceled {
+			ca.log.Printf("Recurrent order %s was canceled. Stopping renewals", order.ID)
+			return
+		}
+
+		notAfter := notBefore.Add(lifetime + lifetimeAdjust)
+		cert, err := ca.newCertificate(csr.DNSNames, csr.IPAddresses, csr.PublicKey, accountID, notBefore, notAfter, true, profile)
+		if err != nil {
+			ca.log.Printf("Error: unable to issue recurrent order %s: %s", order.ID, err.Error())
+			return
+		}
+		ca.log.Printf("Issued STAR certificate serial %s for recurrent order %s (valid until %s)\n",
+			cert.ID, order.ID, notAfter.UTC().Format(time.RFC3339))
+		ca.webhooks.Notify(webhook.CertificateIssued, webhook.NewCertificateData(cert, order.ID))
+
+		// Update the order to store the latest issued certificate
+		order.Update(func(order *core.Order) {
+			order.CertificateObject = cert
+		})
 
-	// Lock and update the order to store the issued certificate
-	order.Lock()
-	order.CertificateObject = cert
-	order.Unlock()
+		notBefore = notBefore.Add(lifetime)
+		clock.SleepUntil(ca.clk, notBefore)
+	}
+	ca.log.Printf("Recurrent order %s reached its end date. Stopping renewals", order.ID)
 }
 
 func (ca *CAImpl) GetNumberOfRootCerts() int {
+	ca.chainsMu.RLock()
+	defer ca.chainsMu.RUnlock()
 	return len(ca.chains)
 }
 
+// Ready returns an error if the CA can't issue certificates, because it has no
+// default chain, or the issuing intermediate of the default chain has no
+// private key or isn't valid at the current time.
+func (ca *CAImpl) Ready() error {
+	c := ca.getChain(0)
+	if c == nil || len(c.intermediates) == 0 {
+		return fmt.Errorf("no issuance chain")
+	}
+	issuer := c.intermediates[0]
+	if issuer.key == nil || issuer.cert == nil || issuer.cert.Cert == nil {
+		return fmt.Errorf("issuing intermediate of chain %s has no private key", c)
+	}
+	now := ca.clk.Now()
+	if now.Before(issuer.cert.Cert.NotBefore) || now.After(issuer.cert.Cert.NotAfter) {
+		return fmt.Errorf("issuing intermediate %s is not valid at %s",
+			issuer.cert.Cert.Subject, now.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
 func (ca *CAImpl) getChain(no int) *chain {
+	ca.chainsMu.RLock()
+	defer ca.chainsMu.RUnlock()
 	if 0 <= no && no < len(ca.chains) {
 		return ca.chains[no]
 	}
 	return nil
 }
 
+// getChains returns a snapshot of the CA's chains, starting with the default
+// chain.
+func (ca *CAImpl) getChains() []*chain {
+	ca.chainsMu.RLock()
+	defer ca.chainsMu.RUnlock()
+	return append([]*chain(nil), ca.chains...)
+}
+
 func (ca *CAImpl) GetRootCert(no int) *core.Certificate {
 	chain := ca.getChain(no)
 	if chain == nil {
@@ -407,17 +639,12 @@ func (ca *CAImpl) GetRootCert(no int) *core.Certificate {
 	return chain.root.cert
 }
 
-func (ca *CAImpl) GetRootKey(no int) *rsa.PrivateKey {
+func (ca *CAImpl) GetRootKey(no int) crypto.Signer {
 	chain := ca.getChain(no)
 	if chain == nil {
 		return nil
 	}
-
-	switch key := chain.root.key.(type) {
-	case *rsa.PrivateKey:
-		return key
-	}
-	return nil
+	return chain.root.key
 }
 
 // GetIntermediateCert returns the first (closest the the leaf) issuer certificate
@@ -430,15 +657,10 @@ func (ca *CAImpl) GetIntermediateCert(no int) *core.Certificate {
 	return chain.intermediates[0].cert
 }
 
-func (ca *CAImpl) GetIntermediateKey(no int) *rsa.PrivateKey {
+func (ca *CAImpl) GetIntermediateKey(no int) crypto.Signer {
 	chain := ca.getChain(no)
 	if chain == nil {
 		return nil
 	}
-
-	switch key := chain.intermediates[0].key.(type) {
-	case *rsa.PrivateKey:
-		return key
-	}
-	return nil
+	return chain.intermediates[0].key
 }
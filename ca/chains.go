@@ -0,0 +1,121 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// AlternateChain describes an alternate issuance chain that is added to the
+// CA in addition to the default chain.
+type AlternateChain struct {
+	// ChainLength is the number of intermediates between the leaf and a new
+	// root that cross-signs the issuing intermediate. Zero selects the CA's
+	// chain length.
+	ChainLength int
+	// CrossSignRoot makes the chain end in the default chain's root
+	// cross-signed by a new root, e.g. to simulate a root transition like the
+	// ISRG Root X1 cross-sign by DST Root CA X3. ChainLength is ignored.
+	CrossSignRoot bool
+}
+
+// ChainInfo summarizes an issuance chain for display on the management
+// interface.
+type ChainInfo struct {
+	Index int
+	Chain string
+}
+
+// AddAlternateChain generates a new issuance chain that certifies the key of
+// the default chain's issuing intermediate and returns its index. Certificates
+// issued afterwards link to the new chain as an alternate.
+func (ca *CAImpl) AddAlternateChain(alt AlternateChain) (int, error) {
+	defaultChain := ca.getChain(0)
+	issuing := defaultChain.intermediates[0]
+
+	var c *chain
+	if alt.CrossSignRoot {
+		if defaultChain.root.key == nil {
+			return 0, errors.New("cannot cross-sign a root without its private key")
+		}
+		newRoot, err := ca.newRootIssuer(fmt.Sprintf("%x", makeSerial().Bytes()[:3]))
+		if err != nil {
+			return 0, err
+		}
+		rootCert := defaultChain.root.cert.Cert
+		crossSigned, err := ca.newIntermediateIssuer(
+			newRoot, defaultChain.root.key, rootCert.Subject, rootCert.SubjectKeyId)
+		if err != nil {
+			return 0, err
+		}
+		intermediates := append([]*issuer(nil), defaultChain.intermediates...)
+		c = &chain{
+			root:          newRoot,
+			intermediates: append(intermediates, crossSigned),
+		}
+	} else {
+		chainLength := alt.ChainLength
+		if chainLength <= 0 {
+			chainLength = ca.chainLength
+		}
+		cert := issuing.cert.Cert
+		c = ca.newChain(issuing.key, cert.Subject, cert.SubjectKeyId, chainLength)
+	}
+
+	ca.chainsMu.Lock()
+	defer ca.chainsMu.Unlock()
+	ca.chains = append(ca.chains, c)
+	ca.log.Printf("Added alternate issuance chain %d: %s", len(ca.chains)-1, c)
+	return len(ca.chains) - 1, nil
+}
+
+// SetDefaultChain makes the chain with the given index the default chain.
+// The former default chain takes the chain's place. Certificates issued
+// afterwards use the new order of chains.
+func (ca *CAImpl) SetDefaultChain(no int) error {
+	ca.chainsMu.Lock()
+	defer ca.chainsMu.Unlock()
+	if no < 0 || no >= len(ca.chains) {
+		return fmt.Errorf("chain %d does not exist", no)
+	}
+	ca.chains[0], ca.chains[no] = ca.chains[no], ca.chains[0]
+	ca.log.Printf("Default issuance chain is now: %s", ca.chains[0])
+	return nil
+}
+
+// GetChains describes the CA's issuance chains, starting with the default
+// chain.
+func (ca *CAImpl) GetChains() []ChainInfo {
+	chains := ca.getChains()
+	infos := make([]ChainInfo, len(chains))
+	for i, c := range chains {
+		infos[i] = ChainInfo{
+			Index: i,
+			Chain: c.String(),
+		}
+	}
+	return infos
+}
+
+// GetTrustBundle returns the certificates of the CA's current chains, the
+// roots first and then the intermediates, without duplicates. Intermediates
+// that were rotated out aren't included.
+func (ca *CAImpl) GetTrustBundle() []*core.Certificate {
+	chains := ca.getChains()
+	var roots, intermediates []*core.Certificate
+	seen := make(map[string]bool)
+	for _, c := range chains {
+		if !seen[string(c.root.cert.DER)] {
+			seen[string(c.root.cert.DER)] = true
+			roots = append(roots, c.root.cert)
+		}
+		for _, intermediate := range c.intermediates {
+			if !seen[string(intermediate.cert.DER)] {
+				seen[string(intermediate.cert.DER)] = true
+				intermediates = append(intermediates, intermediate.cert)
+			}
+		}
+	}
+	return append(roots, intermediates...)
+}
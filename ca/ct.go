@@ -0,0 +1,68 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/ct"
+)
+
+// SetCTLogs creates the mock CT logs that precertificates are submitted to.
+// Certificates issued with a profile that includes SCTs embed one SCT per
+// log. It must be called before the CA starts issuing certificates.
+func (ca *CAImpl) SetCTLogs(configs []ct.LogConfig) error {
+	logs := make([]*ct.Log, len(configs))
+	for i, config := range configs {
+		log, err := ct.New(config, ca.clk)
+		if err != nil {
+			return fmt.Errorf("creating CT log %d: %s", i, err)
+		}
+		logs[i] = log
+		ca.log.Printf("Created CT log %d (%s) with log ID %x", i, config.Name, log.LogID())
+	}
+	ca.ctLogs = logs
+	return nil
+}
+
+// GetCTLogs returns the mock CT logs of the CA.
+func (ca *CAImpl) GetCTLogs() []*ct.Log {
+	return ca.ctLogs
+}
+
+// addSCTs issues a precertificate for a leaf certificate template, submits it
+// to every CT log and adds the SCT list extension to the template. See RFC 6962
+// Section 3.1.
+func (ca *CAImpl) addSCTs(template *x509.Certificate, key crypto.PublicKey, issuer *issuer) error {
+	precertTemplate := *template
+	precertTemplate.ExtraExtensions = append(
+		append([]pkix.Extension(nil), template.ExtraExtensions...),
+		pkix.Extension{
+			Id:       ct.OIDPrecertificatePoison,
+			Critical: true,
+			// ASN.1 NULL
+			Value: []byte{0x05, 0x00},
+		})
+	precert, err := x509.CreateCertificate(rand.Reader, &precertTemplate, issuer.cert.Cert, key, issuer.key)
+	if err != nil {
+		return fmt.Errorf("cannot create precertificate: %s", err)
+	}
+
+	chain := [][]byte{precert, issuer.cert.DER}
+	scts := make([]*ct.SCT, len(ca.ctLogs))
+	for i, log := range ca.ctLogs {
+		scts[i], err = log.AddPreChain(chain)
+		if err != nil {
+			return fmt.Errorf("cannot submit precertificate to CT log %d: %s", i, err)
+		}
+	}
+
+	ext, err := ct.SCTListExtension(scts)
+	if err != nil {
+		return err
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	return nil
+}
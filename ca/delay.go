@@ -0,0 +1,48 @@
+package ca
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/random"
+)
+
+// IssuanceDelay delays the issuance of certificates after an order is
+// finalized by a random number of seconds between Min and Max, so that
+// orders stay in the "processing" state. Equal values give a fixed delay.
+type IssuanceDelay struct {
+	Min int
+	Max int
+}
+
+// SetIssuanceDelay configures the delay between finalization and issuance. It
+// may be called while the CA issues certificates.
+func (ca *CAImpl) SetIssuanceDelay(delay IssuanceDelay) error {
+	if delay.Min < 0 || delay.Max < delay.Min {
+		return fmt.Errorf("issuance delay must satisfy 0 <= min <= max")
+	}
+	ca.configMu.Lock()
+	ca.issuanceDelay = delay
+	ca.configMu.Unlock()
+	if delay.Max > 0 {
+		ca.log.Printf("Configured to delay issuance by %d to %d seconds", delay.Min, delay.Max)
+	}
+	return nil
+}
+
+// GetIssuanceDelay returns the delay between finalization and issuance.
+func (ca *CAImpl) GetIssuanceDelay() IssuanceDelay {
+	ca.configMu.RLock()
+	defer ca.configMu.RUnlock()
+	return ca.issuanceDelay
+}
+
+// waitIssuanceDelay sleeps for the configured issuance delay.
+func (ca *CAImpl) waitIssuanceDelay() {
+	delay := ca.GetIssuanceDelay()
+	if delay.Max == 0 {
+		return
+	}
+	seconds := delay.Min + random.Intn(delay.Max-delay.Min+1)
+	time.Sleep(time.Duration(seconds) * time.Second)
+}
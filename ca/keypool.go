@@ -0,0 +1,82 @@
+package ca
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+)
+
+// pooledKey is a pre-generated CA key with its Subject Key Identifier.
+type pooledKey struct {
+	key          crypto.Signer
+	subjectKeyID []byte
+}
+
+// KeyPool generates CA keys of one algorithm in the background, so that
+// creating the CA hierarchies of tenants and rotating intermediates don't
+// wait for key generation, which takes long for RSA keys. A pool can be
+// shared by several CAs.
+type KeyPool struct {
+	algorithm string
+	keys      chan pooledKey
+}
+
+// NewKeyPool starts generating up to size keys of the given algorithm (see
+// keyAlgorithms), or of the default algorithm if it's empty. Keys taken from
+// the pool are replaced in the background.
+func NewKeyPool(log *log.Logger, algorithm string, size int) (*KeyPool, error) {
+	if algorithm == "" {
+		algorithm = defaultKeyAlgorithm
+	}
+	if _, ok := keyAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported CA key algorithm %q", algorithm)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("key pool size must be positive")
+	}
+	pool := &KeyPool{
+		algorithm: algorithm,
+		keys:      make(chan pooledKey, size),
+	}
+	log.Printf("Pre-generating a pool of %d %s CA keys", size, algorithm)
+
+	go func() {
+		for {
+			key, subjectKeyID, err := makeKey(algorithm)
+			if err != nil {
+				log.Printf("Error: generating pooled CA key: %s", err)
+				return
+			}
+			pool.keys <- pooledKey{key: key, subjectKeyID: subjectKeyID}
+		}
+	}()
+	return pool, nil
+}
+
+// Generates returns true if the pool isn't nil and has keys of the given
+// algorithm, or of the default algorithm if it's empty.
+func (p *KeyPool) Generates(algorithm string) bool {
+	if algorithm == "" {
+		algorithm = defaultKeyAlgorithm
+	}
+	return p != nil && p.algorithm == algorithm
+}
+
+// take returns a key of the pool, or generates one if the pool is empty.
+func (p *KeyPool) take() (crypto.Signer, []byte, error) {
+	select {
+	case k := <-p.keys:
+		return k.key, k.subjectKeyID, nil
+	default:
+		return makeKey(p.algorithm)
+	}
+}
+
+// newKey returns a new key of the CA's key algorithm, taken from its key pool
+// if it has one of that algorithm.
+func (ca *CAImpl) newKey() (crypto.Signer, []byte, error) {
+	if ca.keyPool.Generates(ca.keyAlgorithm) {
+		return ca.keyPool.take()
+	}
+	return makeKey(ca.keyAlgorithm)
+}
@@ -0,0 +1,200 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ExistingHierarchy locates the PEM files of an existing CA hierarchy that is
+// loaded at startup instead of generating a new one.
+type ExistingHierarchy struct {
+	// RootCertificate is the self-signed root certificate.
+	RootCertificate string
+	// RootKey is the root's private key. It is optional.
+	RootKey string
+	// IntermediateCertificates contains one or more intermediate certificates,
+	// starting with the one issuing leaf certificates and ending with the one
+	// issued by the root.
+	IntermediateCertificates string
+	// IntermediateKey is the private key of the intermediate issuing leaf
+	// certificates.
+	IntermediateKey string
+}
+
+// readCertificates reads all PEM encoded certificates from a file.
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no PEM certificates found", filename)
+	}
+	return certs, nil
+}
+
+// readPrivateKey reads a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key from
+// a file.
+func readPrivateKey(filename string) (crypto.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM private key found", filename)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block type %q", filename, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported private key type %T", filename, key)
+}
+
+// keyMatches returns true if the signer's public key is the certificate's
+// public key.
+func keyMatches(signer crypto.Signer, cert *x509.Certificate) bool {
+	signerPub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return false
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(signerPub, certPub)
+}
+
+// addIssuerCertificate stores a CA certificate loaded from disk, chained to
+// its issuer (if any).
+func (ca *CAImpl) addIssuerCertificate(cert *x509.Certificate, signer *issuer) (*core.Certificate, error) {
+	newCert := &core.Certificate{
+		ID:   hex.EncodeToString(cert.SerialNumber.Bytes()),
+		Cert: cert,
+		DER:  cert.Raw,
+	}
+	if signer != nil {
+		newCert.IssuerChains = [][]*core.Certificate{{signer.cert}}
+	}
+	if _, err := ca.db.AddCertificate(newCert); err != nil {
+		return nil, err
+	}
+	return newCert, nil
+}
+
+// loadChain loads an existing CA hierarchy from disk and verifies that the
+// certificates chain to the root and that the keys match their certificates.
+func (ca *CAImpl) loadChain(files ExistingHierarchy) (*chain, error) {
+	roots, err := readCertificates(files.RootCertificate)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) != 1 {
+		return nil, fmt.Errorf("%s: expected exactly one root certificate", files.RootCertificate)
+	}
+	rootCert := roots[0]
+	if err := rootCert.CheckSignatureFrom(rootCert); err != nil {
+		return nil, fmt.Errorf("root certificate is not self-signed: %s", err)
+	}
+
+	var rootKey crypto.Signer
+	if files.RootKey != "" {
+		if rootKey, err = readPrivateKey(files.RootKey); err != nil {
+			return nil, err
+		}
+		if !keyMatches(rootKey, rootCert) {
+			return nil, errors.New("root key does not match the root certificate")
+		}
+	}
+
+	intermediateCerts, err := readCertificates(files.IntermediateCertificates)
+	if err != nil {
+		return nil, err
+	}
+	intermediateKey, err := readPrivateKey(files.IntermediateKey)
+	if err != nil {
+		return nil, err
+	}
+	if !keyMatches(intermediateKey, intermediateCerts[0]) {
+		return nil, errors.New("intermediate key does not match the first intermediate certificate")
+	}
+
+	rootCoreCert, err := ca.addIssuerCertificate(rootCert, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &chain{
+		root: &issuer{
+			key:  rootKey,
+			cert: rootCoreCert,
+		},
+		intermediates: make([]*issuer, len(intermediateCerts)),
+	}
+
+	// Walk the intermediates from the root towards the leaf issuer
+	parent := c.root
+	for i := len(intermediateCerts) - 1; i >= 0; i-- {
+		cert := intermediateCerts[i]
+		if err := cert.CheckSignatureFrom(parent.cert.Cert); err != nil {
+			return nil, fmt.Errorf("intermediate %q is not issued by %q: %s",
+				cert.Subject, parent.cert.Cert.Subject, err)
+		}
+		coreCert, err := ca.addIssuerCertificate(cert, parent)
+		if err != nil {
+			return nil, err
+		}
+		c.intermediates[i] = &issuer{cert: coreCert}
+		parent = c.intermediates[i]
+	}
+	c.intermediates[0].key = intermediateKey
+
+	ca.log.Printf("Loaded issuance chain: %s", c)
+	return c, nil
+}
@@ -0,0 +1,223 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+const (
+	// defaultValidityPeriod is the validity period of leaf certificates issued
+	// with a profile that doesn't configure one.
+	defaultValidityPeriod = 5 * 365 * 24 * time.Hour
+
+	// Values of the CSRMustStaple profile field. CSRs requesting OCSP
+	// must-staple are ignored, honored by including the TLS feature extension
+	// in the certificate, or rejected at finalization.
+	CSRMustStapleIgnore = "ignore"
+	CSRMustStapleHonor  = "honor"
+	CSRMustStapleReject = "reject"
+
+	// statusRequest is the TLS feature of OCSP must-staple (RFC 6066 status_request)
+	statusRequest = 5
+)
+
+var (
+	extKeyUsages = map[string]x509.ExtKeyUsage{
+		"serverAuth":      x509.ExtKeyUsageServerAuth,
+		"clientAuth":      x509.ExtKeyUsageClientAuth,
+		"codeSigning":     x509.ExtKeyUsageCodeSigning,
+		"emailProtection": x509.ExtKeyUsageEmailProtection,
+		"timeStamping":    x509.ExtKeyUsageTimeStamping,
+		"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+	}
+
+	keyUsages = map[string]x509.KeyUsage{
+		"digitalSignature":  x509.KeyUsageDigitalSignature,
+		"contentCommitment": x509.KeyUsageContentCommitment,
+		"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+		"dataEncipherment":  x509.KeyUsageDataEncipherment,
+		"keyAgreement":      x509.KeyUsageKeyAgreement,
+	}
+
+	defaultExtKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	defaultKeyUsage     = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+
+	// The TLS feature extension (RFC 7633) with the status_request feature,
+	// better known as OCSP must-staple.
+	oidTLSFeature       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	mustStapleExtension = pkix.Extension{
+		Id:    oidTLSFeature,
+		Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+	}
+)
+
+// defaultProfiles returns the profiles offered by a CA without configured
+// profiles.
+func defaultProfiles() map[string]*core.Profile {
+	return map[string]*core.Profile{
+		DefaultProfile: {Description: "The default profile", IncludeSCTs: true},
+	}
+}
+
+// SetProfiles replaces the certificate profiles offered by the CA. If no
+// profiles are given the CA offers only the default profile. It may be called
+// while the CA issues certificates.
+func (ca *CAImpl) SetProfiles(profiles map[string]core.Profile) error {
+	parsed := defaultProfiles()
+	if len(profiles) > 0 {
+		parsed = make(map[string]*core.Profile, len(profiles))
+	}
+	for name, profile := range profiles {
+		profile := profile
+		if profile.ValidityPeriod < 0 {
+			return fmt.Errorf("profile %q has a negative validity period", name)
+		}
+		for _, eku := range profile.ExtKeyUsages {
+			if _, ok := extKeyUsages[eku]; !ok {
+				return fmt.Errorf("profile %q has unknown extended key usage %q", name, eku)
+			}
+		}
+		for _, ku := range profile.KeyUsages {
+			if _, ok := keyUsages[ku]; !ok {
+				return fmt.Errorf("profile %q has unknown key usage %q", name, ku)
+			}
+		}
+		switch profile.CSRMustStaple {
+		case "", CSRMustStapleIgnore, CSRMustStapleHonor, CSRMustStapleReject:
+		default:
+			return fmt.Errorf("profile %q has unknown csrMustStaple value %q", name, profile.CSRMustStaple)
+		}
+		parsed[name] = &profile
+		ca.log.Printf("Configured certificate profile %q", name)
+	}
+
+	ca.configMu.Lock()
+	defer ca.configMu.Unlock()
+	if ca.shortLivedValidity > 0 {
+		for _, profile := range parsed {
+			profile.ValidityPeriod = ca.shortLivedValidity
+		}
+	}
+	ca.profiles = parsed
+	return nil
+}
+
+// SetShortLivedValidityPeriod puts the CA in short-lived mode: every profile
+// issues certificates valid for the given number of seconds, overriding the
+// profiles' configured validity periods, including those of profiles set
+// later. A period of zero ends short-lived mode for profiles set later. It may
+// be called while the CA issues certificates.
+func (ca *CAImpl) SetShortLivedValidityPeriod(seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("short-lived validity period must not be negative")
+	}
+	ca.configMu.Lock()
+	defer ca.configMu.Unlock()
+	ca.shortLivedValidity = seconds
+	if seconds == 0 {
+		return nil
+	}
+	profiles := make(map[string]*core.Profile, len(ca.profiles))
+	for name, profile := range ca.profiles {
+		shortLived := *profile
+		shortLived.ValidityPeriod = seconds
+		profiles[name] = &shortLived
+	}
+	ca.profiles = profiles
+	ca.log.Printf("Short-lived mode: certificates are valid for %d seconds", seconds)
+	return nil
+}
+
+// GetProfile returns the profile with the given name, or nil if the CA
+// doesn't offer such a profile.
+func (ca *CAImpl) GetProfile(name string) *core.Profile {
+	ca.configMu.RLock()
+	defer ca.configMu.RUnlock()
+	return ca.profiles[name]
+}
+
+// GetProfileDescriptions returns the descriptions of all profiles offered by
+// the CA, keyed by profile name.
+func (ca *CAImpl) GetProfileDescriptions() map[string]string {
+	ca.configMu.RLock()
+	defer ca.configMu.RUnlock()
+	descriptions := make(map[string]string, len(ca.profiles))
+	for name, profile := range ca.profiles {
+		descriptions[name] = profile.Description
+	}
+	return descriptions
+}
+
+// RequestsMustStaple returns true if a CSR requests a TLS feature extension
+// with the status_request feature.
+func RequestsMustStaple(csr *x509.CertificateRequest) bool {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == statusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// profileForCSR returns the profile to issue a certificate for a CSR with. If
+// the profile honors must-staple requests and the CSR requests must-staple, a
+// copy of the profile with MustStaple set is returned. The profile may be nil.
+func profileForCSR(profile *core.Profile, csr *x509.CertificateRequest) *core.Profile {
+	if profile == nil || profile.MustStaple || profile.CSRMustStaple != CSRMustStapleHonor ||
+		!RequestsMustStaple(csr) {
+		return profile
+	}
+	withMustStaple := *profile
+	withMustStaple.MustStaple = true
+	return &withMustStaple
+}
+
+// ValidityPeriod returns the leaf certificate validity period of a profile.
+// The profile may be nil.
+func ValidityPeriod(profile *core.Profile) time.Duration {
+	if profile == nil || profile.ValidityPeriod == 0 {
+		return defaultValidityPeriod
+	}
+	return time.Duration(profile.ValidityPeriod) * time.Second
+}
+
+// applyProfile sets the key usages and extensions of a profile on a leaf
+// certificate template. The profile may be nil, in which case the defaults are
+// used.
+func applyProfile(template *x509.Certificate, profile *core.Profile) {
+	template.KeyUsage = defaultKeyUsage
+	template.ExtKeyUsage = defaultExtKeyUsages
+	if profile == nil {
+		return
+	}
+
+	if len(profile.KeyUsages) > 0 {
+		template.KeyUsage = 0
+		for _, ku := range profile.KeyUsages {
+			template.KeyUsage |= keyUsages[ku]
+		}
+	}
+	if len(profile.ExtKeyUsages) > 0 {
+		template.ExtKeyUsage = nil
+		for _, eku := range profile.ExtKeyUsages {
+			template.ExtKeyUsage = append(template.ExtKeyUsage, extKeyUsages[eku])
+		}
+	}
+	if profile.MustStaple {
+		template.ExtraExtensions = append(template.ExtraExtensions, mustStapleExtension)
+	}
+}
@@ -0,0 +1,75 @@
+package ca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/webhook"
+)
+
+// keyCompromiseReason is the RFC 5280 CRL reason code for a compromised key.
+const keyCompromiseReason uint = 1
+
+// RotateIntermediate replaces the issuing intermediate of every chain with an
+// intermediate using a newly generated key, e.g. to simulate the response to
+// an intermediate compromise. If revoke is true the replaced intermediate
+// certificates are revoked for key compromise. Certificates issued before the
+// rotation keep being served with their original chains.
+func (ca *CAImpl) RotateIntermediate(revoke bool) error {
+	key, subjectKeyID, err := ca.newKey()
+	if err != nil {
+		return fmt.Errorf("creating new intermediate private key: %s", err)
+	}
+	subject := pkix.Name{
+		CommonName: intermediateCAPrefix + hex.EncodeToString(makeSerial().Bytes()[:3]),
+	}
+
+	ca.chainsMu.Lock()
+	defer ca.chainsMu.Unlock()
+
+	rotated := make([]*chain, len(ca.chains))
+	for i, c := range ca.chains {
+		// The new intermediate is issued by the same issuer as the one it
+		// replaces
+		signer := c.root
+		if len(c.intermediates) > 1 {
+			signer = c.intermediates[1]
+		}
+		if signer.key == nil {
+			return fmt.Errorf("cannot issue a new intermediate for chain %s without the issuer's private key", c)
+		}
+		intermediate, err := ca.newIntermediateIssuer(signer, key, subject, subjectKeyID)
+		if err != nil {
+			return fmt.Errorf("creating new intermediate: %s", err)
+		}
+
+		intermediates := append([]*issuer{intermediate}, c.intermediates[1:]...)
+		rotated[i] = &chain{
+			root:          c.root,
+			intermediates: intermediates,
+		}
+	}
+
+	if revoke {
+		now := ca.clk.Now()
+		for _, c := range ca.chains {
+			reason := keyCompromiseReason
+			ca.db.RevokeCertificate(&core.RevokedCertificate{
+				Certificate: c.intermediates[0].cert,
+				RevokedAt:   now,
+				Reason:      &reason,
+			})
+			ca.log.Printf("Revoked intermediate %s with serial %s",
+				c.intermediates[0].cert.Cert.Subject, c.intermediates[0].cert.ID)
+			data := webhook.NewCertificateData(c.intermediates[0].cert, "")
+			data.Reason = &reason
+			ca.webhooks.Notify(webhook.CertificateRevoked, data)
+		}
+	}
+
+	ca.chains = rotated
+	ca.log.Printf("Rotated issuing intermediate, default issuance chain is now: %s", ca.chains[0])
+	return nil
+}
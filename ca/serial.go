@@ -0,0 +1,145 @@
+package ca
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/letsencrypt/pebble/random"
+)
+
+const (
+	// defaultSerialLength is the length in bytes of leaf certificate serial
+	// numbers unless configured otherwise.
+	defaultSerialLength = 8
+	// maxSerialLength is the maximum length of serial numbers allowed by RFC
+	// 5280 Section 4.1.2.2.
+	maxSerialLength = 20
+	// maxSerialAttempts bounds the number of serials generated while looking
+	// for one that isn't in use.
+	maxSerialAttempts = 100
+)
+
+// SerialConfig configures how the serial numbers of leaf certificates are
+// generated.
+type SerialConfig struct {
+	// Length is the length of serial numbers in bytes, including the prefix.
+	// Defaults to 8, at most 20.
+	Length int
+	// Prefix is a hex encoded prefix of every serial number. Its first byte
+	// must be between 01 and 7f so that serials remain positive and keep their
+	// length.
+	Prefix string
+	// Monotonic makes every serial the previous serial plus one. The first
+	// serial is random.
+	Monotonic bool
+	// NearCollisionRate is the fraction of serials, between 0 and 1, that
+	// differ from the previous serial only in their last byte.
+	NearCollisionRate float64
+}
+
+// serialGenerator generates serial numbers according to a SerialConfig.
+type serialGenerator struct {
+	length            int
+	prefix            []byte
+	monotonic         bool
+	nearCollisionRate float64
+
+	sync.Mutex
+	last []byte
+}
+
+func newSerialGenerator(config SerialConfig) (*serialGenerator, error) {
+	length := config.Length
+	if length == 0 {
+		length = defaultSerialLength
+	}
+	prefix, err := hex.DecodeString(config.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("serial prefix %q is not hex encoded", config.Prefix)
+	}
+	if len(prefix) > 0 && (prefix[0] == 0 || prefix[0] > 0x7f) {
+		return nil, fmt.Errorf("serial prefix %q must start with a byte between 01 and 7f", config.Prefix)
+	}
+	if length < len(prefix)+1 || length > maxSerialLength {
+		return nil, fmt.Errorf("serial length must be between %d and %d bytes", len(prefix)+1, maxSerialLength)
+	}
+	if config.NearCollisionRate < 0 || config.NearCollisionRate > 1 {
+		return nil, fmt.Errorf("serial near collision rate must be between 0 and 1")
+	}
+	return &serialGenerator{
+		length:            length,
+		prefix:            prefix,
+		monotonic:         config.Monotonic,
+		nearCollisionRate: config.NearCollisionRate,
+	}, nil
+}
+
+// random returns a random serial with the configured prefix.
+func (g *serialGenerator) random() []byte {
+	serial := make([]byte, g.length)
+	if _, err := random.Reader.Read(serial); err != nil {
+		panic(fmt.Sprintf("unable to create random serial number: %s", err.Error()))
+	}
+	if len(g.prefix) > 0 {
+		copy(serial, g.prefix)
+		return serial
+	}
+	// Keep the serial positive and its length fixed
+	serial[0] &= 0x7f
+	if serial[0] == 0 {
+		serial[0] = 1
+	}
+	return serial
+}
+
+// next returns a new serial number.
+func (g *serialGenerator) next() *big.Int {
+	g.Lock()
+	defer g.Unlock()
+
+	var serial []byte
+	switch {
+	case g.last != nil && g.nearCollisionRate > 0 && random.Float64() < g.nearCollisionRate:
+		serial = append([]byte(nil), g.last...)
+		serial[len(serial)-1] ^= byte(1 + random.Intn(255))
+	case g.last != nil && g.monotonic:
+		incremented := new(big.Int).Add(new(big.Int).SetBytes(g.last), big.NewInt(1)).Bytes()
+		if len(incremented) == g.length && incremented[0] <= 0x7f &&
+			(len(g.prefix) == 0 || string(incremented[:len(g.prefix)]) == string(g.prefix)) {
+			serial = incremented
+		} else {
+			// The counter overflowed, start over
+			serial = g.random()
+		}
+	default:
+		serial = g.random()
+	}
+	g.last = serial
+	return new(big.Int).SetBytes(serial)
+}
+
+// SetSerialConfig configures how the serial numbers of leaf certificates are
+// generated. It must be called before the CA starts issuing certificates.
+func (ca *CAImpl) SetSerialConfig(config SerialConfig) error {
+	serials, err := newSerialGenerator(config)
+	if err != nil {
+		return err
+	}
+	ca.serials = serials
+	return nil
+}
+
+// newLeafSerial returns a serial number for a leaf certificate that isn't in
+// use by another certificate.
+func (ca *CAImpl) newLeafSerial() (*big.Int, error) {
+	for i := 0; i < maxSerialAttempts; i++ {
+		serial := ca.serials.next()
+		if ca.db.GetCertificateByID(hex.EncodeToString(serial.Bytes())) == nil &&
+			ca.db.GetRevokedCertificateBySerial(serial) == nil {
+			return serial, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to find an unused serial number after %d attempts", maxSerialAttempts)
+}
@@ -0,0 +1,61 @@
+package ca
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// managementPlaceholder is replaced with the base URL of Pebble's management
+// interface in configured certificate URLs.
+const managementPlaceholder = "{management}"
+
+// CertificateURLs are the URLs embedded in a certificate's authority
+// information access (caIssuers and OCSP) and CRL distribution points
+// extensions.
+type CertificateURLs struct {
+	IssuingCertificateURLs []string
+	OCSPServers            []string
+	CRLDistributionPoints  []string
+}
+
+// CertificateURLConfig configures the URLs embedded in leaf and intermediate
+// certificates.
+type CertificateURLConfig struct {
+	Leaf         CertificateURLs
+	Intermediate CertificateURLs
+}
+
+// WithManagementURL returns a copy of the config with the "{management}"
+// placeholder replaced by the given management interface base URL, e.g.
+// "{management}/intermediates/0".
+func (c CertificateURLConfig) WithManagementURL(baseURL string) CertificateURLConfig {
+	return CertificateURLConfig{
+		Leaf:         c.Leaf.withManagementURL(baseURL),
+		Intermediate: c.Intermediate.withManagementURL(baseURL),
+	}
+}
+
+func (u CertificateURLs) withManagementURL(baseURL string) CertificateURLs {
+	replace := func(urls []string) []string {
+		if urls == nil {
+			return nil
+		}
+		replaced := make([]string, len(urls))
+		for i, url := range urls {
+			replaced[i] = strings.Replace(url, managementPlaceholder, baseURL, -1)
+		}
+		return replaced
+	}
+	return CertificateURLs{
+		IssuingCertificateURLs: replace(u.IssuingCertificateURLs),
+		OCSPServers:            replace(u.OCSPServers),
+		CRLDistributionPoints:  replace(u.CRLDistributionPoints),
+	}
+}
+
+// apply adds the URLs to a certificate template.
+func (u CertificateURLs) apply(template *x509.Certificate) {
+	template.IssuingCertificateURL = append(template.IssuingCertificateURL, u.IssuingCertificateURLs...)
+	template.OCSPServer = append(template.OCSPServer, u.OCSPServers...)
+	template.CRLDistributionPoints = append(template.CRLDistributionPoints, u.CRLDistributionPoints...)
+}
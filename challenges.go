@@ -0,0 +1,49 @@
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+// ChallengeType registers an additional challenge type, offered in
+// authorizations as the WFE challenge type configures and validated by its
+// Validate function, to iterate on drafts of new ACME challenge types without
+// changing Pebble.
+type ChallengeType struct {
+	wfe.ChallengeType
+	// Validate validates the challenges of the type. Challenges of types
+	// without a Validate function, like the types of the config file, are
+	// delegated to the external validator configured for the type.
+	Validate va.ChallengeValidator `json:"-"`
+}
+
+// registerChallengeTypes registers the challenge types of a config with the
+// VA.
+func registerChallengeTypes(vaImpl *va.VAImpl, config Config) error {
+	delegated := make(map[string]bool)
+	for _, v := range config.ExternalValidators {
+		for _, challengeType := range v.ChallengeTypes {
+			delegated[challengeType] = true
+		}
+	}
+	for _, t := range config.ChallengeTypes {
+		if t.Validate == nil && !delegated[t.Name] {
+			return fmt.Errorf("challenge type %q has neither a Validate function nor an external validator", t.Name)
+		}
+		if err := vaImpl.RegisterChallengeType(t.Name, t.Validate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wfeChallengeTypes returns the WFE challenge types of a config.
+func wfeChallengeTypes(config Config) []wfe.ChallengeType {
+	types := make([]wfe.ChallengeType, 0, len(config.ChallengeTypes))
+	for _, t := range config.ChallengeTypes {
+		types = append(types, t.ChallengeType)
+	}
+	return types
+}
@@ -0,0 +1,82 @@
+// Package clock provides the time source of Pebble's components. The fake
+// clock can be moved forward to make orders, authorizations and certificates
+// expire without waiting.
+package clock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is a Clock returning the system time.
+type systemClock struct{}
+
+// New returns a Clock returning the system time.
+func New() Clock {
+	return systemClock{}
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock returning the system time moved forward by an offset.
+type FakeClock struct {
+	sync.RWMutex
+	offset time.Duration
+}
+
+// NewFake returns a FakeClock without offset.
+func NewFake() *FakeClock {
+	return &FakeClock{}
+}
+
+// Now returns the system time moved forward by the offset of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Now().Add(c.offset)
+}
+
+// Offset returns the duration the clock has been moved forward by.
+func (c *FakeClock) Offset() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.offset
+}
+
+// Advance moves the clock forward. The clock can't be moved backward.
+func (c *FakeClock) Advance(d time.Duration) error {
+	if d < 0 {
+		return errors.New("the clock can't be moved backward")
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.offset += d
+	return nil
+}
+
+// sleepInterval is how often SleepUntil checks whether the clock has
+// reached the wake up time, so that moving a fake clock forward wakes up
+// sleepers.
+const sleepInterval = time.Second
+
+// SleepUntil sleeps until the clock reaches t.
+func SleepUntil(c Clock, t time.Time) {
+	for {
+		remaining := t.Sub(c.Now())
+		if remaining <= 0 {
+			return
+		}
+		if remaining > sleepInterval {
+			remaining = sleepInterval
+		}
+		time.Sleep(remaining)
+	}
+}
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,7 +11,9 @@ import (
 
 // ReadConfigFile takes a file path as an argument and attempts to
 // unmarshal the content of the file into a struct containing a
-// configuration of a Pebble component.
+// configuration of a Pebble component. Files with a ".yaml", ".yml" or
+// ".toml" extension are read as YAML or TOML, all others as JSON. Keys that
+// don't match a field of the configuration are an error.
 //
 // Lifted from
 //   https://raw.githubusercontent.com/letsencrypt/boulder/master/cmd/shell.go
@@ -21,7 +22,11 @@ func ReadConfigFile(filename string, out interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(configData, out)
+	configData, err = configToJSON(filename, configData)
+	if err != nil {
+		return err
+	}
+	return UnmarshalConfig(configData, out)
 }
 
 // FailOnError exits and prints an error message if we encountered a problem
@@ -41,6 +46,15 @@ var signalToName = map[os.Signal]string{
 	syscall.SIGHUP:  "SIGHUP",
 }
 
+// SignalName returns the name of a signal caught by CatchSignals, e.g.
+// "SIGTERM".
+func SignalName(sig os.Signal) string {
+	if name, ok := signalToName[sig]; ok {
+		return name
+	}
+	return sig.String()
+}
+
 // CatchSignals catches SIGTERM, SIGINT, SIGHUP and executes a callback
 // method before exiting
 func CatchSignals(callback func()) {
@@ -50,7 +64,7 @@ func CatchSignals(callback func()) {
 	signal.Notify(sigChan, syscall.SIGHUP)
 
 	sig := <-sigChan
-	log.Printf("Caught %s", signalToName[sig])
+	log.Printf("Caught %s", SignalName(sig))
 
 	if callback != nil {
 		callback()
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configToJSON converts YAML and TOML configuration files, recognized by the
+// extension of the filename, to JSON. Other files are returned unchanged.
+func configToJSON(filename string, data []byte) ([]byte, error) {
+	var config interface{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return nil, err
+		}
+		config = table
+	default:
+		return data, nil
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("converting %s to JSON: %s", filename, err)
+	}
+	return data, nil
+}
+
+// UnmarshalConfig unmarshals a JSON configuration into out, like
+// json.Unmarshal, but returns an error naming the key and suggesting the
+// intended one if a key doesn't match a field of the configuration, instead of
+// ignoring it.
+func UnmarshalConfig(data []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var config interface{}
+	if err := decoder.Decode(&config); err != nil {
+		return err
+	}
+	if err := checkConfigKeys(config, reflect.TypeOf(out), ""); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkConfigKeys checks that the keys of the objects of a decoded JSON value
+// match fields of the type it is unmarshaled into. Mismatching types are left
+// to json.Unmarshal to report.
+func checkConfigKeys(value interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := configFields(t)
+		for _, key := range sortedKeys(object) {
+			field, ok := matchConfigField(fields, key)
+			if !ok {
+				return unknownConfigKeyError(fields, path, key)
+			}
+			if err := checkConfigKeys(object[key], field.Type, joinConfigPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(object) {
+			if err := checkConfigKeys(object[key], t.Elem(), joinConfigPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		array, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, element := range array {
+			if err := checkConfigKeys(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configFields returns the fields of a struct that json.Unmarshal sets, keyed
+// by their JSON name, including the fields of embedded structs.
+func configFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			for embeddedName, embedded := range configFields(fieldType) {
+				if _, ok := fields[embeddedName]; !ok {
+					embedded.Index = append([]int{i}, embedded.Index...)
+					fields[embeddedName] = embedded
+				}
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// matchConfigField returns the field a key is unmarshaled into, matching the
+// JSON names case-insensitively like json.Unmarshal.
+func matchConfigField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if field, ok := fields[key]; ok {
+		return field, true
+	}
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func unknownConfigKeyError(fields map[string]reflect.StructField, path, key string) error {
+	err := fmt.Sprintf("unknown config key %q", joinConfigPath(path, key))
+	if suggestion := suggestConfigKey(fields, key); suggestion != "" {
+		err += fmt.Sprintf(", did you mean %q?", joinConfigPath(path, suggestion))
+	}
+	return fmt.Errorf("%s", err)
+}
+
+// suggestConfigKey returns the name of the field closest to a misspelled key,
+// ignoring case, dashes and underscores, or "" if no field is close.
+func suggestConfigKey(fields map[string]reflect.StructField, key string) string {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if distance := editDistance(normalize(name), normalize(key)); distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+	if best == "" || fields[best].Name != best {
+		return best
+	}
+	return lowerCamelCase(best)
+}
+
+// lowerCamelCase returns the spelling of a Go field name used by the example
+// configs, e.g. "httpPort" for "HTTPPort".
+func lowerCamelCase(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// editDistance returns the Levenshtein distance of two strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// EnvAlias is a deprecated environment variable that is read as the override
+// it was replaced by, if that isn't set.
+type EnvAlias struct {
+	// Deprecated name, e.g. PEBBLE_VA_NOSLEEP
+	Name string
+	// Name of the override replacing it, e.g. PEBBLE_NOSLEEP
+	Replacement string
+	// Converts the deprecated value to a value of the override, or returns
+	// false if it's ignored. The value is used as is if nil.
+	Convert func(value string) (string, bool)
+}
+
+// ApplyEnvOverrides overrides fields of a configuration with environment
+// variables named after the prefix and the field's key, upper-cased and joined
+// by underscores, e.g. PEBBLE_LISTENADDRESS for the listenAddress key of the
+// prefix PEBBLE. The fields of nested objects are overridden the same way, e.g.
+// PEBBLE_RATELIMITS_NEWORDERS. String fields take the value as is, all other
+// fields, including whole objects and lists, a JSON value. The deprecated
+// aliases that are set are logged.
+func ApplyEnvOverrides(prefix string, out interface{}, aliases []EnvAlias, logger *log.Logger) error {
+	for _, alias := range aliases {
+		if _, ok := os.LookupEnv(alias.Name); ok {
+			logger.Printf("The %s environment variable is deprecated, use %s instead",
+				alias.Name, alias.Replacement)
+		}
+	}
+	lookup := func(name string) (string, bool) {
+		return lookupEnv(name, aliases)
+	}
+	_, err := applyEnvOverrides(prefix, reflect.ValueOf(out).Elem(), lookup)
+	return err
+}
+
+// lookupEnv returns the value of the environment variable of the name, or of
+// the first deprecated alias of it that is set.
+func lookupEnv(name string, aliases []EnvAlias) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	for _, alias := range aliases {
+		if alias.Replacement != name {
+			continue
+		}
+		value, ok := os.LookupEnv(alias.Name)
+		if ok && alias.Convert != nil {
+			value, ok = alias.Convert(value)
+		}
+		if ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// applyEnvOverrides overrides the value with the environment variable of the
+// name, or the fields of a struct value with the variables of their names. It
+// returns whether a variable was applied.
+func applyEnvOverrides(name string, v reflect.Value, lookup func(string) (string, bool)) (bool, error) {
+	if value, ok := lookup(name); ok {
+		if v.Kind() == reflect.String {
+			v.SetString(value)
+			return true, nil
+		}
+		if err := UnmarshalConfig([]byte(value), v.Addr().Interface()); err != nil {
+			return false, fmt.Errorf("invalid value of %s: %s", name, err)
+		}
+		return true, nil
+	}
+
+	t := v.Type()
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		// A nil struct is only allocated if one of its fields is overridden
+		elem := reflect.New(t.Elem())
+		if !v.IsNil() {
+			elem = v
+		}
+		applied, err := applyEnvOverrides(name, elem.Elem(), lookup)
+		if applied {
+			v.Set(elem)
+		}
+		return applied, err
+	}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return false, nil
+	}
+
+	applied := false
+	for key, field := range configFields(t) {
+		fieldName := name + "_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+		fieldApplied, err := applyEnvOverrides(fieldName, v.FieldByIndex(field.Index), lookup)
+		if err != nil {
+			return false, err
+		}
+		applied = applied || fieldApplied
+	}
+	return applied, nil
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testLimits struct {
+	Count  int `json:"count"`
+	Period int `json:"period"`
+}
+
+type testEmbedded struct {
+	Profile string
+}
+
+type testConfig struct {
+	testEmbedded
+	ListenAddress string
+	HTTPPort      int
+	OCSPURL       string `json:"ocspResponderURL"`
+	NoSleep       bool
+	Limits        *testLimits
+	Chains        []testLimits
+	Tenants       map[string]testLimits
+	Ignored       string `json:"-"`
+}
+
+func TestUnmarshalConfig(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  string
+		want    testConfig
+		wantErr string
+	}{
+		{
+			name:   "known keys",
+			config: `{"listenAddress": "0.0.0.0:14000", "httpPort": 5002, "ocspResponderURL": "http://ocsp"}`,
+			want:   testConfig{ListenAddress: "0.0.0.0:14000", HTTPPort: 5002, OCSPURL: "http://ocsp"},
+		},
+		{
+			name:   "case-insensitive keys",
+			config: `{"LISTENADDRESS": "0.0.0.0:14000", "OcspResponderUrl": "http://ocsp"}`,
+			want:   testConfig{ListenAddress: "0.0.0.0:14000", OCSPURL: "http://ocsp"},
+		},
+		{
+			name:   "embedded and nested keys",
+			config: `{"profile": "default", "limits": {"count": 1}, "chains": [{"period": 2}], "tenants": {"a": {"count": 3}}}`,
+			want: testConfig{
+				testEmbedded: testEmbedded{Profile: "default"},
+				Limits:       &testLimits{Count: 1},
+				Chains:       []testLimits{{Period: 2}},
+				Tenants:      map[string]testLimits{"a": {Count: 3}},
+			},
+		},
+		{
+			name:    "misspelled key",
+			config:  `{"listenAdress": "0.0.0.0:14000"}`,
+			wantErr: `unknown config key "listenAdress", did you mean "listenAddress"?`,
+		},
+		{
+			name:    "misspelled initialism",
+			config:  `{"htpPort": 5002}`,
+			wantErr: `unknown config key "htpPort", did you mean "httpPort"?`,
+		},
+		{
+			name:    "misspelled tagged key",
+			config:  `{"ocsp_responder_url": "http://ocsp"}`,
+			wantErr: `unknown config key "ocsp_responder_url", did you mean "ocspResponderURL"?`,
+		},
+		{
+			name:    "unknown key without suggestion",
+			config:  `{"certificateAuthority": true}`,
+			wantErr: `unknown config key "certificateAuthority"`,
+		},
+		{
+			name:    "ignored field",
+			config:  `{"ignored": "x"}`,
+			wantErr: `unknown config key "ignored"`,
+		},
+		{
+			name:    "nested key",
+			config:  `{"limits": {"cuont": 1}}`,
+			wantErr: `unknown config key "limits.cuont", did you mean "limits.count"?`,
+		},
+		{
+			name:    "key of list element",
+			config:  `{"chains": [{"period": 1}, {"perod": 2}]}`,
+			wantErr: `unknown config key "chains[1].perod", did you mean "chains[1].period"?`,
+		},
+		{
+			name:    "key of map value",
+			config:  `{"tenants": {"a": {"counts": 1}}}`,
+			wantErr: `unknown config key "tenants.a.counts", did you mean "tenants.a.count"?`,
+		},
+		{
+			name:    "mismatching type",
+			config:  `{"httpPort": "5002"}`,
+			wantErr: "cannot unmarshal string",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got testConfig
+			err := UnmarshalConfig([]byte(tc.config), &got)
+			if tc.wantErr != "" {
+				// Unknown key errors are matched exactly, to check the suggestion
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) ||
+					strings.HasPrefix(tc.wantErr, "unknown") && err.Error() != tc.wantErr {
+					t.Fatalf("UnmarshalConfig(%s) returned error %v, want %q", tc.config, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalConfig(%s) returned error: %s", tc.config, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("UnmarshalConfig(%s) = %+v, want %+v", tc.config, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigToJSON(t *testing.T) {
+	testCases := []struct {
+		filename string
+		data     string
+	}{
+		{
+			filename: "config.json",
+			data:     `{"listenAddress": "0.0.0.0:14000", "httpPort": 5002, "limits": {"count": 1}}`,
+		},
+		{
+			filename: "config.yaml",
+			data:     "listenAddress: 0.0.0.0:14000\nhttpPort: 5002\nlimits:\n  count: 1\n",
+		},
+		{
+			filename: "config.YML",
+			data:     "listenAddress: 0.0.0.0:14000\nhttpPort: 5002\nlimits: {count: 1}\n",
+		},
+		{
+			filename: "config.toml",
+			data:     "listenAddress = \"0.0.0.0:14000\"\nhttpPort = 5002\n[limits]\ncount = 1\n",
+		},
+	}
+	want := testConfig{ListenAddress: "0.0.0.0:14000", HTTPPort: 5002, Limits: &testLimits{Count: 1}}
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			data, err := configToJSON(tc.filename, []byte(tc.data))
+			if err != nil {
+				t.Fatalf("configToJSON(%s) returned error: %s", tc.filename, err)
+			}
+			var got testConfig
+			if err := UnmarshalConfig(data, &got); err != nil {
+				t.Fatalf("unmarshaling %s: %s", data, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("config of %s = %+v, want %+v", tc.filename, got, want)
+			}
+		})
+	}
+}
+
+func TestLowerCamelCase(t *testing.T) {
+	testCases := map[string]string{
+		"HTTPPort":         "httpPort",
+		"ListenAddress":    "listenAddress",
+		"OCSPResponderURL": "ocspResponderURL",
+		"DNS":              "dns",
+		"ARI":              "ari",
+		"Strict":           "strict",
+	}
+	for name, want := range testCases {
+		if got := lowerCamelCase(name); got != want {
+			t.Errorf("lowerCamelCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	toTrue := func(value string) (string, bool) { return "true", value == "1" }
+	aliases := []EnvAlias{
+		{Name: "TEST_OLD_PORT", Replacement: "TEST_HTTPPORT"},
+		{Name: "TEST_OLD_NOSLEEP", Replacement: "TEST_NOSLEEP", Convert: toTrue},
+	}
+	testCases := []struct {
+		name    string
+		env     map[string]string
+		want    testConfig
+		wantLog string
+		wantErr string
+	}{
+		{
+			name: "no overrides",
+			want: testConfig{ListenAddress: "file", HTTPPort: 5002},
+		},
+		{
+			name: "string and number",
+			env:  map[string]string{"TEST_LISTENADDRESS": "0.0.0.0:443", "TEST_HTTPPORT": "80"},
+			want: testConfig{ListenAddress: "0.0.0.0:443", HTTPPort: 80},
+		},
+		{
+			name: "tagged and embedded keys",
+			env:  map[string]string{"TEST_OCSPRESPONDERURL": "http://ocsp", "TEST_PROFILE": "short"},
+			want: testConfig{
+				testEmbedded:  testEmbedded{Profile: "short"},
+				ListenAddress: "file",
+				HTTPPort:      5002,
+				OCSPURL:       "http://ocsp",
+			},
+		},
+		{
+			name: "nested field allocates the object",
+			env:  map[string]string{"TEST_LIMITS_COUNT": "10"},
+			want: testConfig{ListenAddress: "file", HTTPPort: 5002, Limits: &testLimits{Count: 10}},
+		},
+		{
+			name: "whole object and list",
+			env: map[string]string{
+				"TEST_LIMITS": `{"count": 10, "period": 3600}`,
+				"TEST_CHAINS": `[{"count": 1}]`,
+			},
+			want: testConfig{
+				ListenAddress: "file",
+				HTTPPort:      5002,
+				Limits:        &testLimits{Count: 10, Period: 3600},
+				Chains:        []testLimits{{Count: 1}},
+			},
+		},
+		{
+			name:    "invalid JSON",
+			env:     map[string]string{"TEST_HTTPPORT": "eighty"},
+			wantErr: "invalid value of TEST_HTTPPORT",
+		},
+		{
+			name:    "unknown key in object",
+			env:     map[string]string{"TEST_LIMITS": `{"cuont": 10}`},
+			wantErr: `unknown config key "cuont"`,
+		},
+		{
+			name:    "alias",
+			env:     map[string]string{"TEST_OLD_PORT": "80"},
+			want:    testConfig{ListenAddress: "file", HTTPPort: 80},
+			wantLog: "TEST_OLD_PORT environment variable is deprecated, use TEST_HTTPPORT instead",
+		},
+		{
+			name:    "override takes precedence over alias",
+			env:     map[string]string{"TEST_OLD_PORT": "80", "TEST_HTTPPORT": "443"},
+			want:    testConfig{ListenAddress: "file", HTTPPort: 443},
+			wantLog: "TEST_OLD_PORT environment variable is deprecated",
+		},
+		{
+			name:    "converted alias",
+			env:     map[string]string{"TEST_OLD_NOSLEEP": "1"},
+			want:    testConfig{ListenAddress: "file", HTTPPort: 5002, NoSleep: true},
+			wantLog: "TEST_OLD_NOSLEEP environment variable is deprecated",
+		},
+		{
+			name:    "ignored alias",
+			env:     map[string]string{"TEST_OLD_NOSLEEP": "0"},
+			want:    testConfig{ListenAddress: "file", HTTPPort: 5002},
+			wantLog: "TEST_OLD_NOSLEEP environment variable is deprecated",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			var logged bytes.Buffer
+			got := testConfig{ListenAddress: "file", HTTPPort: 5002}
+			err := ApplyEnvOverrides("TEST", &got, aliases, log.New(&logged, "", 0))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ApplyEnvOverrides returned error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnvOverrides returned error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ApplyEnvOverrides set %+v, want %+v", got, tc.want)
+			}
+			if !strings.Contains(logged.String(), tc.wantLog) || tc.wantLog == "" && logged.Len() > 0 {
+				t.Errorf("ApplyEnvOverrides logged %q, want %q", logged.String(), tc.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxAliases is the most CNAME and DNAME aliases followed to answer a
+// question. Longer chains are answered with SERVFAIL like loops.
+const maxAliases = 16
+
+// resolve returns the answers and response code of a question. Starting at
+// the question name it follows, hop by hop:
+//
+//   - a DNAME of an ancestor of the name, answering the DNAME and a CNAME
+//     synthesized for the name, and continuing at the rewritten name
+//   - a CNAME of the name, unless CNAMEs are queried, answering it and
+//     continuing at its target
+//
+// until it reaches a name answered with the mock records of the question type.
+// The records of a name without mock records of its own are those of the
+// wildcard matching it, if any. A SERVFAIL mock of any name of the chain, a
+// loop, or a chain of more than maxAliases aliases results in SERVFAIL.
+func (s *dnsServer) resolve(q dns.Question) ([]dns.RR, int) {
+	var answers []dns.RR
+	seen := map[string]bool{canonicalName(q.Name): true}
+	name := q.Name
+	for {
+		if s.challSrv.GetDNSServFailRecord(name) {
+			return answers, dns.RcodeServerFailure
+		}
+
+		var target string
+		owner := s.recordName(name)
+		if dnameOwner, dnameTarget := s.findDNAME(name); dnameOwner != "" {
+			target = strings.TrimSuffix(name, dnameOwner) + dnameTarget
+			answers = append(answers, dnameRecord(dnameOwner, dnameTarget), cnameRecord(name, target))
+			if _, ok := dns.IsDomainName(target); !ok {
+				// The rewritten name is too long to be a domain name
+				return answers, dns.RcodeYXDomain
+			}
+		} else if cname := s.challSrv.GetDNSCNAMERecord(owner); cname != "" && q.Qtype != dns.TypeCNAME {
+			target = cname
+			answers = append(answers, cnameRecord(name, target))
+		} else {
+			for _, rr := range s.answerFunc(q.Qtype)(dns.Question{Name: owner, Qtype: q.Qtype, Qclass: q.Qclass}) {
+				rr.Header().Name = name
+				answers = append(answers, rr)
+			}
+			return answers, dns.RcodeSuccess
+		}
+
+		if seen[canonicalName(target)] || len(seen) > maxAliases {
+			return answers, dns.RcodeServerFailure
+		}
+		seen[canonicalName(target)] = true
+		name = target
+	}
+}
+
+// recordName returns the name whose mock records answer questions for a name:
+// the name itself if it has any mock records, or else the wildcard like
+// "*.example.com." of its nearest ancestor that has one. Wildcards of the
+// ancestors above the nearest ancestor with mock records of its own don't
+// match.
+func (s *dnsServer) recordName(name string) string {
+	if s.hasRecords(name) {
+		return name
+	}
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		ancestor := dns.Fqdn(strings.Join(labels[i:], "."))
+		if wildcard := "*." + ancestor; s.hasRecords(wildcard) {
+			return wildcard
+		}
+		if s.hasRecords(ancestor) {
+			break
+		}
+	}
+	return name
+}
+
+// hasRecords returns true if there are mock records of any type for a name.
+func (s *dnsServer) hasRecords(name string) bool {
+	if len(s.challSrv.GetDNSARecord(name)) > 0 ||
+		len(s.challSrv.GetDNSAAAARecord(name)) > 0 ||
+		len(s.challSrv.GetDNSOneChallenge(name)) > 0 ||
+		s.challSrv.GetDNSCNAMERecord(name) != "" {
+		return true
+	}
+	if s.dnssec != nil && canonicalName(name) == s.dnssec.zone {
+		return true
+	}
+	name = dns.Fqdn(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.caaRecords[name]) > 0 ||
+		len(s.svcbRecords[typeSVCB][name]) > 0 ||
+		len(s.svcbRecords[typeHTTPS][name]) > 0 ||
+		s.dnameRecords[name] != ""
+}
+
+// findDNAME returns the owner and target of the DNAME record of the nearest
+// ancestor of a name that has one, or empty strings if there is none. A DNAME
+// doesn't apply to its owner name itself.
+func (s *dnsServer) findDNAME(name string) (string, string) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		ancestor := dns.Fqdn(strings.Join(labels[i:], "."))
+		if target := s.GetDNAMERecord(ancestor); target != "" {
+			return ancestor, target
+		}
+	}
+	return "", ""
+}
+
+// AddDNAMERecord adds a mock DNAME record redirecting the names below the
+// given host to the same names below the target, replacing any DNAME record
+// already added for the host.
+func (s *dnsServer) AddDNAMERecord(host, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dnameRecords[dns.Fqdn(host)] = dns.Fqdn(target)
+}
+
+// DeleteDNAMERecord deletes the mock DNAME record of the given host.
+func (s *dnsServer) DeleteDNAMERecord(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dnameRecords, dns.Fqdn(host))
+}
+
+// GetDNAMERecord returns the target of the mock DNAME record of the given
+// host, or an empty string if there is none.
+func (s *dnsServer) GetDNAMERecord(host string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dnameRecords[dns.Fqdn(host)]
+}
+
+// dnameAnswers creates the DNAME RR for the given question using the mock
+// DNAME records. If there is no mock DNAME for the hostname no RRs will be
+// returned.
+func (s *dnsServer) dnameAnswers(q dns.Question) []dns.RR {
+	target := s.GetDNAMERecord(q.Name)
+	if target == "" {
+		return nil
+	}
+	return []dns.RR{dnameRecord(q.Name, target)}
+}
+
+// cnameRecord returns a CNAME RR aliasing a name to a target.
+func cnameRecord(name, target string) *dns.CNAME {
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+		},
+		Target: target,
+	}
+}
+
+// dnameRecord returns a DNAME RR redirecting the names below an owner name to
+// a target.
+func dnameRecord(owner, target string) *dns.DNAME {
+	return &dns.DNAME{
+		Hdr: dns.RR_Header{
+			Name:   owner,
+			Rrtype: dns.TypeDNAME,
+			Class:  dns.ClassINET,
+		},
+		Target: target,
+	}
+}
+
+// addDNSDNAMERecord handles an HTTP POST request to add a mock DNAME record
+// for a host, redirecting the names below the host to the names below the
+// target.
+//
+// The POST body is expected to have two non-empty parameters:
+// "host" - the hostname owning the DNAME record
+// "target" - the hostname the names below the host are redirected to
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) addDNSDNAMERecord(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host   string
+		Target string
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// If the request has no host or no target it's a bad request
+	if request.Host == "" || request.Target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	srv.dnsSrv.AddDNAMERecord(request.Host, request.Target)
+	srv.log.Printf("Added DNS DNAME record for %q targeting %q", request.Host, request.Target)
+	w.WriteHeader(http.StatusOK)
+}
+
+// delDNSDNAMERecord handles an HTTP POST request to delete an existing mock
+// DNAME record for a host.
+//
+// The POST body is expected to have one non-empty parameter:
+// "host" - the hostname to remove the mock DNAME record for.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) delDNSDNAMERecord(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host string
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// If the request has an empty host it's a bad request
+	if request.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	srv.dnsSrv.DeleteDNAMERecord(request.Host)
+	srv.log.Printf("Removed DNS DNAME record for %q", request.Host)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// batchAddressRecords are the mock A or AAAA records of a host in a batch.
+type batchAddressRecords struct {
+	Host      string
+	Addresses []string
+}
+
+// batchTXTRecord is a mock DNS-01 TXT record in a batch.
+type batchTXTRecord struct {
+	Host  string
+	Value string
+}
+
+// batchCAARecords are the mock CAA records of a host in a batch.
+type batchCAARecords struct {
+	Host     string
+	Policies []mockCAARecord
+}
+
+// batchCNAMERecord is a mock CNAME record in a batch.
+type batchCNAMERecord struct {
+	Host   string
+	Target string
+}
+
+// batchHTTP01 is a HTTP-01 challenge response in a batch.
+type batchHTTP01 struct {
+	Token   string
+	Content string
+}
+
+// batchTLSALPN01 is a TLS-ALPN-01 challenge response in a batch.
+type batchTLSALPN01 struct {
+	Host    string
+	Content string
+	tlsALPNCertOptions
+}
+
+// batch is a JSON document of mock DNS records and challenge responses that
+// are added together. The items of each list are like the POST bodies of the
+// endpoints adding them one by one, e.g. /add-a for A.
+type batch struct {
+	A         []batchAddressRecords
+	AAAA      []batchAddressRecords
+	TXT       []batchTXTRecord
+	CAA       []batchCAARecords
+	CNAME     []batchCNAMERecord
+	HTTP01    []batchHTTP01
+	TLSALPN01 []batchTLSALPN01
+}
+
+// batchError is the error of an item of a batch, e.g. "a[2]" for the third A
+// record.
+type batchError struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+// validateAddresses returns an error if the mock A or AAAA records of a batch
+// have no host or an address that isn't an IPv4 or IPv6 address respectively.
+func validateAddresses(rec batchAddressRecords, ipv6 bool) error {
+	if rec.Host == "" {
+		return errors.New("host must not be empty")
+	}
+	if len(rec.Addresses) == 0 {
+		return errors.New("addresses must not be empty")
+	}
+	for _, address := range rec.Addresses {
+		ip := net.ParseIP(address)
+		if ip == nil || (ip.To4() == nil) != ipv6 {
+			return fmt.Errorf("invalid address %q", address)
+		}
+	}
+	return nil
+}
+
+// validate returns the errors of the invalid items of the batch.
+func (b batch) validate() []batchError {
+	var errs []batchError
+	add := func(kind string, i int, err error) {
+		if err != nil {
+			errs = append(errs, batchError{Item: fmt.Sprintf("%s[%d]", kind, i), Error: err.Error()})
+		}
+	}
+	for i, rec := range b.A {
+		add("a", i, validateAddresses(rec, false))
+	}
+	for i, rec := range b.AAAA {
+		add("aaaa", i, validateAddresses(rec, true))
+	}
+	for i, rec := range b.TXT {
+		if rec.Host == "" || rec.Value == "" {
+			add("txt", i, errors.New("host and value must not be empty"))
+		}
+	}
+	for i, rec := range b.CAA {
+		if rec.Host == "" || len(rec.Policies) == 0 {
+			add("caa", i, errors.New("host and policies must not be empty"))
+			continue
+		}
+		for _, policy := range rec.Policies {
+			if policy.Tag == "" {
+				add("caa", i, errors.New("policy tags must not be empty"))
+				break
+			}
+		}
+	}
+	for i, rec := range b.CNAME {
+		if rec.Host == "" || rec.Target == "" {
+			add("cname", i, errors.New("host and target must not be empty"))
+		}
+	}
+	for i, chall := range b.HTTP01 {
+		if chall.Token == "" || chall.Content == "" {
+			add("http01", i, errors.New("token and content must not be empty"))
+		}
+	}
+	for i, chall := range b.TLSALPN01 {
+		if chall.Host == "" || chall.Content == "" {
+			add("tlsalpn01", i, errors.New("host and content must not be empty"))
+			continue
+		}
+		add("tlsalpn01", i, chall.tlsALPNCertOptions.validate())
+	}
+	return errs
+}
+
+// addBatch handles an HTTP POST request to add many mock DNS records and
+// challenge responses at once. The batch is validated as a whole first: if
+// any item is invalid, none of them are added.
+//
+// The POST body is expected to be a JSON object with any of the lists "a",
+// "aaaa", "txt", "caa", "cname", "http01" and "tlsalpn01", whose items are
+// objects like the POST bodies of /add-a, /add-aaaa, /set-txt, /add-caa,
+// /set-cname, /add-http01 and /add-tlsalpn01 respectively.
+//
+// A successful POST will write http.StatusOK to the client. If items are
+// invalid http.StatusBadRequest is written with a JSON object with the list
+// "errors" of the items, e.g. "a[2]", and their errors.
+func (srv *managementServer) addBatch(w http.ResponseWriter, r *http.Request) {
+	var request batch
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errs := request.validate(); len(errs) > 0 {
+		jsonErrs, err := json.MarshalIndent(struct {
+			Errors []batchError `json:"errors"`
+		}{errs}, "", "   ")
+		if err != nil {
+			srv.log.Printf("Error marshaling batch errors: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(jsonErrs)
+		return
+	}
+
+	for _, rec := range request.A {
+		srv.challSrv.AddDNSARecord(rec.Host, rec.Addresses)
+	}
+	for _, rec := range request.AAAA {
+		srv.challSrv.AddDNSAAAARecord(rec.Host, rec.Addresses)
+	}
+	for _, rec := range request.TXT {
+		srv.challSrv.AddDNSOneChallenge(rec.Host, rec.Value)
+	}
+	for _, rec := range request.CAA {
+		srv.dnsSrv.AddCAARecords(rec.Host, rec.Policies)
+	}
+	for _, rec := range request.CNAME {
+		srv.challSrv.AddDNSCNAMERecord(rec.Host, rec.Target)
+	}
+	for _, chall := range request.HTTP01 {
+		srv.challSrv.AddHTTPOneChallenge(chall.Token, chall.Content)
+	}
+	for _, chall := range request.TLSALPN01 {
+		srv.challSrv.AddTLSALPNChallenge(chall.Host, chall.Content)
+		srv.tlsALPNCerts.SetOptions(chall.Host, chall.tlsALPNCertOptions)
+	}
+
+	counts := []string{
+		fmt.Sprintf("%d A", len(request.A)),
+		fmt.Sprintf("%d AAAA", len(request.AAAA)),
+		fmt.Sprintf("%d TXT", len(request.TXT)),
+		fmt.Sprintf("%d CAA", len(request.CAA)),
+		fmt.Sprintf("%d CNAME", len(request.CNAME)),
+		fmt.Sprintf("%d HTTP-01", len(request.HTTP01)),
+		fmt.Sprintf("%d TLS-ALPN-01", len(request.TLSALPN01)),
+	}
+	srv.log.Printf("Added batch of %s responses\n", strings.Join(counts, ", "))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// mockCAARecord holds the flags, tag and value of a mock CAA record. See
+// https://www.rfc-editor.org/rfc/rfc8659
+type mockCAARecord struct {
+	// Flags of the record, e.g. 128 for the issuer critical flag
+	Flag uint8
+	// Property tag of the record, e.g. "issue"
+	Tag string
+	// Property value of the record, e.g. "letsencrypt.org" or a full value
+	// with parameters like "letsencrypt.org; validationmethods=dns-01"
+	Value string
+	// Parameters appended to the value, separated by semicolons, e.g.
+	// "accounturi=https://localhost:14000/my-account/1"
+	Parameters []string
+}
+
+// value returns the property value of the record with the parameters.
+func (rec mockCAARecord) value() string {
+	if len(rec.Parameters) == 0 {
+		return rec.Value
+	}
+	return strings.Join(append([]string{rec.Value}, rec.Parameters...), "; ")
+}
+
+// AddCAARecords adds mock CAA records for the given host, in addition to the
+// ones added before.
+func (s *dnsServer) AddCAARecords(host string, records []mockCAARecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = dns.Fqdn(host)
+	s.caaRecords[host] = append(s.caaRecords[host], records...)
+}
+
+// DeleteCAARecords deletes the mock CAA records of the given host.
+func (s *dnsServer) DeleteCAARecords(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.caaRecords, dns.Fqdn(host))
+}
+
+// GetCAARecords returns the mock CAA records of the given host.
+func (s *dnsServer) GetCAARecords(host string) []mockCAARecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.caaRecords[dns.Fqdn(host)]
+}
+
+// caaAnswers creates CAA RRs for the given question using the mock CAA
+// records. If there are no mock records for the hostname no RRs will be
+// returned.
+func (s *dnsServer) caaAnswers(q dns.Question) []dns.RR {
+	var records []dns.RR
+	for _, rec := range s.GetCAARecords(q.Name) {
+		records = append(records, &dns.CAA{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeCAA,
+				Class:  dns.ClassINET,
+			},
+			Flag:  rec.Flag,
+			Tag:   rec.Tag,
+			Value: rec.value(),
+		})
+	}
+	return records
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/letsencrypt/challtestsrv"
+	"github.com/miekg/dns"
+)
+
+// dnsServer answers the mock DNS queries of record types the challtestsrv
+// package doesn't support, or doesn't support all features of, and passes all
+// other queries to the DNS handler of the package. It shares the mock A, AAAA,
+// TXT, CNAME and SERVFAIL data of the challenge server, and records the
+// queries in the request history.
+type dnsServer struct {
+	challSrv *challtestsrv.ChallSrv
+	// The DNS handler of the challtestsrv package
+	next dns.Handler
+
+	// mu is a RWMutex used to control concurrent updates to the mock data
+	// below.
+	mu sync.RWMutex
+	// A map of record type (SVCB or HTTPS) to a map of host to mock records.
+	svcbRecords map[uint16]map[string][]mockSVCBRecord
+	// A map of host to mock CAA records.
+	caaRecords map[string][]mockCAARecord
+	// A map of host to the target of its mock DNAME record.
+	dnameRecords map[string]string
+	// A map of host to a map of query type to the failure of its queries. The
+	// failure of all query types has the type dns.TypeNone.
+	failures map[string]map[uint16]string
+
+	// The signer of DNSSEC signed responses, nil if DNSSEC is disabled
+	dnssec *dnssecSigner
+	// The delays of answers for hosts
+	latencies *latencies
+	// The request history the queries are recorded in
+	history *requestHistory
+	// The metrics the queries are counted in
+	metrics *challSrvMetrics
+}
+
+// newDNSServer creates a dnsServer for a challenge server delaying its answers
+// by the DNS latencies of the hosts, and recording and counting the queries in
+// the request history and metrics. It doesn't answer any queries until it is
+// installed.
+func newDNSServer(
+	challSrv *challtestsrv.ChallSrv,
+	latencies *latencies,
+	history *requestHistory,
+	metrics *challSrvMetrics) *dnsServer {
+	return &dnsServer{
+		challSrv:  challSrv,
+		latencies: latencies,
+		history:   history,
+		metrics:   metrics,
+		svcbRecords: map[uint16]map[string][]mockSVCBRecord{
+			typeSVCB:  make(map[string][]mockSVCBRecord),
+			typeHTTPS: make(map[string][]mockSVCBRecord),
+		},
+		caaRecords:   make(map[string][]mockCAARecord),
+		dnameRecords: make(map[string]string),
+		failures:     make(map[string]map[uint16]string),
+	}
+}
+
+// install puts the dnsServer in front of the DNS handler of the challtestsrv
+// package, which the package registers with the default miekg/dns ServeMux.
+// It must be called after the challenge server is constructed and before the
+// DNS servers run.
+func (s *dnsServer) install() {
+	s.next = dns.DefaultServeMux
+}
+
+// enableDNSSEC signs the responses of the dnsServer with the signer. It must
+// be called before the challenge server runs.
+func (s *dnsServer) enableDNSSEC(signer *dnssecSigner) {
+	s.dnssec = signer
+}
+
+// ServeDNS answers queries with a single question of a record type the
+// dnsServer supports: SERVFAIL mocks override all answers, CNAME and DNAME
+// aliases are followed and wildcard records are matched as described by
+// resolve. The responses to all queries are signed if DNSSEC is enabled, mock
+// failures override all answers, and the answers are delayed by the DNS
+// latency of the host.
+func (s *dnsServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	for _, q := range r.Question {
+		s.history.Add(challtestsrv.DNSRequestEvent{
+			Question: q,
+		})
+	}
+	rw := &rcodeResponseWriter{ResponseWriter: w}
+	defer s.metrics.countDNS(r, rw)
+	w = rw
+	if len(r.Question) > 0 {
+		s.latencies.wait(latencyDNS, r.Question[0].Name)
+	}
+	if s.dnssec != nil {
+		w = &signingResponseWriter{ResponseWriter: w, signer: s.dnssec, request: r}
+	}
+	if s.serveDNSFailure(w, r) {
+		return
+	}
+	if len(r.Question) != 1 || s.answerFunc(r.Question[0].Qtype) == nil {
+		s.next.ServeDNS(w, r)
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = false
+
+	m.Answer, m.Rcode = s.resolve(r.Question[0])
+
+	m.Ns = append(m.Ns, mockSOA())
+	_ = w.WriteMsg(m)
+}
+
+// answerFunc returns the function answering questions of a record type, or
+// nil if the challtestsrv package answers them.
+func (s *dnsServer) answerFunc(qtype uint16) func(dns.Question) []dns.RR {
+	switch qtype {
+	case dns.TypeA:
+		return s.aAnswers
+	case dns.TypeAAAA:
+		return s.aaaaAnswers
+	case dns.TypeTXT:
+		return s.txtAnswers
+	case dns.TypeCNAME:
+		return s.cnameAnswers
+	case dns.TypeDNAME:
+		return s.dnameAnswers
+	case typeSVCB, typeHTTPS:
+		return s.svcbAnswers
+	case dns.TypeCAA:
+		return s.caaAnswers
+	case dns.TypeDNSKEY:
+		if s.dnssec != nil {
+			return s.dnssec.dnskeyAnswers
+		}
+	}
+	return nil
+}
+
+// aAnswers creates A RRs for the given question using the mock data of the
+// challenge server like the challtestsrv package does. If there are no mock
+// IPv4 addresses for the hostname the default IPv4 address is used.
+func (s *dnsServer) aAnswers(q dns.Question) []dns.RR {
+	var records []dns.RR
+	// Don't answer any questions for IP addresses with a fakeDNS response.
+	// These queries are invalid!
+	if ip := net.ParseIP(q.Name); ip != nil {
+		return records
+	}
+	values := s.challSrv.GetDNSARecord(q.Name)
+	if defaultIPv4 := s.challSrv.GetDefaultDNSIPv4(); len(values) == 0 && defaultIPv4 != "" {
+		values = []string{defaultIPv4}
+	}
+	for _, resp := range values {
+		ipAddr := net.ParseIP(resp)
+		if ipAddr == nil || ipAddr.To4() == nil {
+			continue
+		}
+		records = append(records, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+			},
+			A: ipAddr,
+		})
+	}
+	return records
+}
+
+// aaaaAnswers creates AAAA RRs for the given question using the mock data of
+// the challenge server like the challtestsrv package does. If there are no
+// mock IPv6 addresses for the hostname the default IPv6 address is used.
+func (s *dnsServer) aaaaAnswers(q dns.Question) []dns.RR {
+	var records []dns.RR
+	values := s.challSrv.GetDNSAAAARecord(q.Name)
+	if defaultIPv6 := s.challSrv.GetDefaultDNSIPv6(); len(values) == 0 && defaultIPv6 != "" {
+		values = []string{defaultIPv6}
+	}
+	for _, resp := range values {
+		ipAddr := net.ParseIP(resp)
+		if ipAddr == nil || ipAddr.To4() != nil {
+			continue
+		}
+		records = append(records, &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+			},
+			AAAA: ipAddr,
+		})
+	}
+	return records
+}
+
+// txtAnswers creates TXT RRs for the given question using the mock DNS-01
+// challenge responses of the challenge server. If there are none for the
+// hostname no RRs will be returned.
+func (s *dnsServer) txtAnswers(q dns.Question) []dns.RR {
+	var records []dns.RR
+	for _, resp := range s.challSrv.GetDNSOneChallenge(q.Name) {
+		records = append(records, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+			},
+			Txt: []string{resp},
+		})
+	}
+	return records
+}
+
+// cnameAnswers creates the CNAME RR for the given question using the mock
+// CNAME data of the challenge server. If there is no mock CNAME for the
+// hostname no RRs will be returned.
+func (s *dnsServer) cnameAnswers(q dns.Question) []dns.RR {
+	target := s.challSrv.GetDNSCNAMERecord(q.Name)
+	if target == "" {
+		return nil
+	}
+	return []dns.RR{cnameRecord(q.Name, target)}
+}
+
+// mockSOA returns a mock DNS SOA record with the fake data of the challtestsrv
+// package.
+func mockSOA() *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "challtestsrv.invalid.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+		},
+		Ns:      "ns.challtestsrv.invalid.",
+		Mbox:    "master.challtestsrv.invalid.",
+		Serial:  1,
+		Refresh: 1,
+		Retry:   1,
+		Expire:  1,
+		Minttl:  1,
+	}
+}
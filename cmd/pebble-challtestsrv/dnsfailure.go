@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/letsencrypt/challtestsrv"
+	"github.com/miekg/dns"
+)
+
+// Failures of mock DNS queries
+const (
+	// Answer with a SERVFAIL response code
+	dnsFailureServFail = "servfail"
+	// Answer with a REFUSED response code
+	dnsFailureRefused = "refused"
+	// Don't answer at all, so that the query times out
+	dnsFailureTimeout = "timeout"
+	// Answer queries over UDP with the TC bit set and no records, so that they
+	// are retried over TCP
+	dnsFailureTruncate = "truncate"
+)
+
+var dnsFailures = map[string]bool{
+	dnsFailureServFail: true,
+	dnsFailureRefused:  true,
+	dnsFailureTimeout:  true,
+	dnsFailureTruncate: true,
+}
+
+// parseQueryTypes returns the record types with the given names, e.g. "TXT".
+func parseQueryTypes(names []string) ([]uint16, error) {
+	var types []uint16
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if rrtype, ok := dns.StringToType[name]; ok {
+			types = append(types, rrtype)
+			continue
+		}
+		known := false
+		for rrtype, typeName := range svcbTypeNames {
+			if name == typeName {
+				types = append(types, rrtype)
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown query type %q", name)
+		}
+	}
+	return types, nil
+}
+
+// queryTypesString returns the names of query types for log messages, e.g.
+// "A, TXT", or "all" if there are none.
+func queryTypesString(names []string) string {
+	if len(names) == 0 {
+		return "all"
+	}
+	return strings.ToUpper(strings.Join(names, ", "))
+}
+
+// AddDNSFailure makes queries of the given types, or of all types if none
+// are given, for the host fail.
+func (s *dnsServer) AddDNSFailure(host string, types []uint16, failure string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = canonicalName(host)
+	if s.failures[host] == nil {
+		s.failures[host] = make(map[uint16]string)
+	}
+	if len(types) == 0 {
+		types = []uint16{dns.TypeNone}
+	}
+	for _, rrtype := range types {
+		s.failures[host][rrtype] = failure
+	}
+}
+
+// DeleteDNSFailure deletes the failures of queries of the given types, or of
+// all failures if no types are given, for the host.
+func (s *dnsServer) DeleteDNSFailure(host string, types []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = canonicalName(host)
+	if len(types) == 0 {
+		delete(s.failures, host)
+		return
+	}
+	for _, rrtype := range types {
+		delete(s.failures[host], rrtype)
+	}
+}
+
+// GetDNSFailure returns the failure of a question, or an empty string if it
+// should be answered. A failure of the query type takes precedence over a
+// failure of all types.
+func (s *dnsServer) GetDNSFailure(q dns.Question) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	failures := s.failures[canonicalName(q.Name)]
+	if failure, ok := failures[q.Qtype]; ok {
+		return failure
+	}
+	return failures[dns.TypeNone]
+}
+
+// serveDNSFailure answers a query with the failure of its question, if any,
+// and returns whether it did.
+func (s *dnsServer) serveDNSFailure(w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) != 1 {
+		return false
+	}
+	q := r.Question[0]
+	failure := s.GetDNSFailure(q)
+	if failure == dnsFailureTruncate {
+		// Only UDP responses are truncated
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+			return false
+		}
+	}
+	if failure == "" {
+		return false
+	}
+
+	s.challSrv.AddRequestEvent(challtestsrv.DNSRequestEvent{
+		Question: q,
+	})
+	m := new(dns.Msg)
+	m.SetReply(r)
+	switch failure {
+	case dnsFailureServFail:
+		m.SetRcode(r, dns.RcodeServerFailure)
+	case dnsFailureRefused:
+		m.SetRcode(r, dns.RcodeRefused)
+	case dnsFailureTruncate:
+		m.Truncated = true
+	case dnsFailureTimeout:
+		return true
+	}
+	_ = w.WriteMsg(m)
+	return true
+}
+
+// addDNSFailure handles an HTTP POST request to make mock DNS queries for a
+// host fail.
+//
+// The POST body is expected to have three parameters:
+// "host" - the hostname whose queries should fail.
+// "failure" - how the queries fail. May be "servfail", "refused", "timeout"
+// or "truncate".
+// "types" - an array of the query types that should fail, e.g. "TXT". All
+// types fail if it is empty.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) addDNSFailure(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host    string
+		Failure string
+		Types   []string
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// If the request has an empty host or an unknown failure it's a bad request
+	if request.Host == "" || !dnsFailures[request.Failure] {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	types, err := parseQueryTypes(request.Types)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.dnsSrv.AddDNSFailure(request.Host, types, request.Failure)
+	srv.log.Printf("Added %s failure for DNS %s queries to %q",
+		request.Failure, queryTypesString(request.Types), request.Host)
+	w.WriteHeader(http.StatusOK)
+}
+
+// delDNSFailure handles an HTTP POST request to delete the failures of mock
+// DNS queries for a host.
+//
+// The POST body is expected to have two parameters:
+// "host" - the hostname to remove the failures for.
+// "types" - an array of the query types to remove the failures for. All
+// failures of the host are removed if it is empty.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) delDNSFailure(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host  string
+		Types []string
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// If the request has an empty host it's a bad request
+	if request.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	types, err := parseQueryTypes(request.Types)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.dnsSrv.DeleteDNSFailure(request.Host, types)
+	srv.log.Printf("Removed failures for DNS %s queries to %q",
+		queryTypesString(request.Types), request.Host)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnssecSignatureValidity is how long the RRSIGs of signed responses are
+// valid. They are valid from an hour before the response to allow for clock
+// skew.
+const dnssecSignatureValidity = 7 * 24 * time.Hour
+
+// dnssecSigner signs the answers of the fake DNS data with a single key that
+// is both the key signing key and the zone signing key of the zone.
+type dnssecSigner struct {
+	// The zone whose names are signed, e.g. "." to sign all names
+	zone string
+	key  crypto.Signer
+	// The DNSKEY record of the key at the zone apex
+	dnskey *dns.DNSKEY
+
+	// mu is a RWMutex used to control concurrent updates to the broken
+	// signature settings below.
+	mu sync.RWMutex
+	// Whether the signatures of all names are broken
+	broken bool
+	// A map of hostnames whose signatures are broken
+	brokenHosts map[string]bool
+}
+
+// newDNSSECSigner creates a signer for a zone with the ECDSA P-256, ECDSA
+// P-384 or Ed25519 private key of the PEM file. A new ECDSA P-256 key is
+// generated if the file is empty.
+func newDNSSECSigner(zone, keyFile string) (*dnssecSigner, error) {
+	var key crypto.Signer
+	if keyFile == "" {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	} else {
+		pemBytes, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = parseDNSSECKey(pemBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	zone = canonicalName(zone)
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		// A secure entry point zone key, see RFC 4034 Section 2.1.1
+		Flags:    dns.ZONE | dns.SEP,
+		Protocol: 3,
+	}
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		switch pub.Curve {
+		case elliptic.P256():
+			dnskey.Algorithm = dns.ECDSAP256SHA256
+		case elliptic.P384():
+			dnskey.Algorithm = dns.ECDSAP384SHA384
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", pub.Curve.Params().Name)
+		}
+		point := make([]byte, 2*size)
+		pub.X.FillBytes(point[:size])
+		pub.Y.FillBytes(point[size:])
+		dnskey.PublicKey = base64.StdEncoding.EncodeToString(point)
+	case ed25519.PublicKey:
+		dnskey.Algorithm = dns.ED25519
+		dnskey.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	return &dnssecSigner{
+		zone:        zone,
+		key:         key,
+		dnskey:      dnskey,
+		brokenHosts: make(map[string]bool),
+	}, nil
+}
+
+// canonicalName returns the lower case fully qualified form of a name.
+func canonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// parseDNSSECKey parses a PEM encoded ECDSA or Ed25519 private key.
+func parseDNSSECKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// SetBroken sets whether the signatures of the given host, or of all names if
+// the host is empty, are broken.
+func (s *dnssecSigner) SetBroken(host string, broken bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if host == "" {
+		s.broken = broken
+		if !broken {
+			s.brokenHosts = make(map[string]bool)
+		}
+		return
+	}
+	if broken {
+		s.brokenHosts[canonicalName(host)] = true
+	} else {
+		delete(s.brokenHosts, canonicalName(host))
+	}
+}
+
+// isBroken returns whether the signatures of the given host are broken.
+func (s *dnssecSigner) isBroken(host string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.broken || s.brokenHosts[canonicalName(host)]
+}
+
+// dnskeyAnswers is a dnsAnswerFunc that answers DNSKEY questions for the zone
+// apex with the DNSKEY of the signer.
+func (s *dnssecSigner) dnskeyAnswers(q dns.Question) []dns.RR {
+	if canonicalName(q.Name) != s.zone {
+		return nil
+	}
+	return []dns.RR{dns.Copy(s.dnskey)}
+}
+
+// sign adds the RRSIGs of the RRsets of a reply to a request with the DNSSEC
+// OK bit set, like an authoritative server of the zone. The SOA record of
+// replies without answers is moved to the zone apex, and an NSEC record
+// proves that the name has no records of the queried type.
+func (s *dnssecSigner) sign(request, reply *dns.Msg) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return
+	}
+	reply.SetEdns0(opt.UDPSize(), opt.Do())
+	if !opt.Do() || reply.Rcode != dns.RcodeSuccess || len(request.Question) != 1 {
+		return
+	}
+
+	if len(reply.Answer) == 0 {
+		q := request.Question[0]
+		var ns []dns.RR
+		for _, rr := range reply.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soa = dns.Copy(soa).(*dns.SOA)
+				soa.Hdr.Name = s.zone
+				rr = soa
+			}
+			ns = append(ns, rr)
+		}
+		// A minimally covering NSEC record, see RFC 4470
+		ns = append(ns, &dns.NSEC{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeNSEC,
+				Class:  dns.ClassINET,
+				Ttl:    1,
+			},
+			NextDomain: "\\000." + q.Name,
+			TypeBitMap: []uint16{dns.TypeRRSIG, dns.TypeNSEC},
+		})
+		reply.Ns = ns
+	}
+	reply.Answer = s.signRRs(reply.Answer)
+	reply.Ns = s.signRRs(reply.Ns)
+}
+
+// signRRs returns RRs with the RRSIGs of the RRsets of the zone appended.
+func (s *dnssecSigner) signRRs(rrs []dns.RR) []dns.RR {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var keys []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range rrs {
+		key := rrsetKey{canonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if !dns.IsSubDomain(s.zone, key.name) {
+			continue
+		}
+		if _, ok := rrsets[key]; !ok {
+			keys = append(keys, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	now := time.Now()
+	signed := rrs
+	for _, key := range keys {
+		rrset := rrsets[key]
+		rrsig := &dns.RRSIG{
+			Hdr: dns.RR_Header{
+				Ttl: rrset[0].Header().Ttl,
+			},
+			Algorithm:  s.dnskey.Algorithm,
+			Inception:  uint32(now.Add(-time.Hour).Unix()),
+			Expiration: uint32(now.Add(dnssecSignatureValidity).Unix()),
+			KeyTag:     s.dnskey.KeyTag(),
+			SignerName: s.zone,
+		}
+		if err := rrsig.Sign(s.key, rrset); err != nil {
+			continue
+		}
+		if s.isBroken(key.name) {
+			// Flip a bit of the signature so that it doesn't verify
+			sig, err := base64.StdEncoding.DecodeString(rrsig.Signature)
+			if err == nil && len(sig) > 0 {
+				sig[0] ^= 1
+				rrsig.Signature = base64.StdEncoding.EncodeToString(sig)
+			}
+		}
+		signed = append(signed, rrsig)
+	}
+	return signed
+}
+
+// signingResponseWriter is a dns.ResponseWriter signing the replies to a
+// request before writing them.
+type signingResponseWriter struct {
+	dns.ResponseWriter
+	signer  *dnssecSigner
+	request *dns.Msg
+}
+
+func (w *signingResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.signer.sign(w.request, m)
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// getDNSSECTrustAnchor handles an HTTP request for the trust anchor of the
+// signed zone, i.e. the DNSKEY of the zone and the SHA-256 DS record of it, in
+// presentation format.
+func (srv *managementServer) getDNSSECTrustAnchor(w http.ResponseWriter, r *http.Request) {
+	signer := srv.dnsSrv.dnssec
+	ds := signer.dnskey.ToDS(dns.SHA256)
+	if ds == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp := struct {
+		Zone   string `json:"zone"`
+		KeyTag uint16 `json:"keyTag"`
+		DNSKEY string `json:"dnskey"`
+		DS     string `json:"ds"`
+	}{
+		Zone:   signer.zone,
+		KeyTag: signer.dnskey.KeyTag(),
+		DNSKEY: strings.ReplaceAll(signer.dnskey.String(), "\t", " "),
+		DS:     strings.ReplaceAll(ds.String(), "\t", " "),
+	}
+	jsonResp, err := json.MarshalIndent(resp, "", "   ")
+	if err != nil {
+		srv.log.Printf("Error marshaling trust anchor: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(jsonResp)
+}
+
+// setDNSSECBroken returns a handler for HTTP POST requests to make the
+// signatures of a host deliberately broken, or to fix them.
+//
+// The POST body is expected to have one parameter:
+// "host" - the hostname whose signatures are broken or fixed, all names if
+// empty.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) setDNSSECBroken(broken bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Host string
+		}
+		if err := mustParsePOST(&request, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		srv.dnsSrv.dnssec.SetBroken(request.Host, broken)
+		names := "all names"
+		if request.Host != "" {
+			names = fmt.Sprintf("%q", request.Host)
+		}
+		if broken {
+			srv.log.Printf("Serving broken DNSSEC signatures for %s", names)
+		} else {
+			srv.log.Printf("Serving valid DNSSEC signatures for %s", names)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
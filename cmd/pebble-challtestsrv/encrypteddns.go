@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohPath is the path of the DNS-over-HTTPS endpoint, see RFC 8484 Section 3.
+const dohPath = "/dns-query"
+
+// dnsMessageType is the media type of DNS-over-HTTPS requests and responses.
+const dnsMessageType = "application/dns-message"
+
+// dnsOverTLSServer creates a DNS-over-TLS (RFC 7858) server answering queries
+// with the given handler.
+func dnsOverTLSServer(address string, tlsConfig *tls.Config, handler dns.Handler) challengeServer {
+	return &dns.Server{
+		Addr:         address,
+		Net:          "tcp-tls",
+		TLSConfig:    tlsConfig,
+		Handler:      handler,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	}
+}
+
+// dohServer is a DNS-over-HTTPS (RFC 8484) server answering the queries of
+// GET and POST requests to dohPath with a DNS handler.
+type dohServer struct {
+	*http.Server
+	handler dns.Handler
+}
+
+// dnsOverHTTPSServer creates a DNS-over-HTTPS server answering queries with
+// the given handler.
+func dnsOverHTTPSServer(address string, tlsConfig *tls.Config, handler dns.Handler) challengeServer {
+	srv := &dohServer{
+		Server: &http.Server{
+			Addr:      address,
+			TLSConfig: tlsConfig,
+		},
+		handler: handler,
+	}
+	srv.Server.Handler = srv
+	return srv
+}
+
+func (s *dohServer) ListenAndServe() error {
+	// The certificate is in the TLSConfig
+	err := s.Server.ListenAndServeTLS("", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *dohServer) Shutdown() error {
+	return s.Server.Shutdown(context.Background())
+}
+
+// ServeHTTP answers the DNS query of a DNS-over-HTTPS request: the base64url
+// encoded "dns" parameter of a GET request or the body of a POST request.
+func (s *dohServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != dohPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	var query []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		query, err = base64.RawURLEncoding.DecodeString(
+			strings.TrimRight(r.URL.Query().Get("dns"), "="))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "Content-Type must be "+dnsMessageType, http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw := &dohResponseWriter{request: r}
+	s.handler.ServeDNS(rw, msg)
+	if rw.reply == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	_, _ = w.Write(rw.reply)
+}
+
+// dohResponseWriter is a dns.ResponseWriter keeping the reply to the query
+// of a DNS-over-HTTPS request.
+type dohResponseWriter struct {
+	request *http.Request
+	reply   []byte
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	if addr, ok := w.request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", w.request.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	reply, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	w.reply = reply
+	return nil
+}
+
+func (w *dohResponseWriter) Write(reply []byte) (int, error) {
+	w.reply = append([]byte{}, reply...)
+	return len(reply), nil
+}
+
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
+
+// encryptedDNSTLSConfig returns the TLS configuration of the DNS-over-TLS and
+// DNS-over-HTTPS servers with the PEM certificate and key in the given files.
+// If no files are given a self-signed certificate for localhost is generated.
+func encryptedDNSTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+
+	cert, err := selfSignedCertificate("pebble-challtestsrv encrypted DNS")
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
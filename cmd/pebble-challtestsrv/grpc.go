@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/letsencrypt/challtestsrv"
+)
+
+// grpcService is the full name of the gRPC management service of
+// management.proto
+const grpcService = "pebble.challtestsrv.Management"
+
+// grpcMaxMessageSize is the size of the largest request message accepted by
+// the gRPC management service
+const grpcMaxMessageSize = 4 << 20
+
+// grpcHistoryPollInterval is how often the request history is polled for new
+// events by StreamRequestHistory calls
+const grpcHistoryPollInterval = 100 * time.Millisecond
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+)
+
+// grpcError is an error with a gRPC status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// fieldKind is the type of the value of a message field
+type fieldKind int
+
+const (
+	fieldString fieldKind = iota
+	fieldBool
+	fieldUint
+	fieldMessage
+)
+
+// grpcField describes a field of a message of management.proto with the key
+// of its value in the JSON of the HTTP management interface.
+type grpcField struct {
+	number   protowire.Number
+	key      string
+	kind     fieldKind
+	repeated bool
+	// The fields of the message of fieldMessage fields
+	fields []grpcField
+}
+
+// The messages of management.proto, except Empty and RequestEvent
+var (
+	hostRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+	}
+	http01RequestFields = []grpcField{
+		{1, "Token", fieldString, false, nil},
+		{2, "Content", fieldString, false, nil},
+	}
+	redirectRequestFields = []grpcField{
+		{1, "Path", fieldString, false, nil},
+		{2, "TargetURL", fieldString, false, nil},
+	}
+	defaultIPRequestFields = []grpcField{
+		{1, "IP", fieldString, false, nil},
+	}
+	txtRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Value", fieldString, false, nil},
+	}
+	addressRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Addresses", fieldString, true, nil},
+	}
+	caaPolicyFields = []grpcField{
+		{1, "Flag", fieldUint, false, nil},
+		{2, "Tag", fieldString, false, nil},
+		{3, "Value", fieldString, false, nil},
+		{4, "Parameters", fieldString, true, nil},
+	}
+	caaRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Policies", fieldMessage, true, caaPolicyFields},
+	}
+	cnameRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Target", fieldString, false, nil},
+	}
+	svcbRecordFields = []grpcField{
+		{1, "Priority", fieldUint, false, nil},
+		{2, "Target", fieldString, false, nil},
+		{3, "ALPN", fieldString, true, nil},
+		{4, "NoDefaultALPN", fieldBool, false, nil},
+		{5, "Port", fieldUint, false, nil},
+		{6, "IPv4Hint", fieldString, true, nil},
+		{7, "IPv6Hint", fieldString, true, nil},
+	}
+	svcbRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Records", fieldMessage, true, svcbRecordFields},
+	}
+	dnsFailureRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Failure", fieldString, false, nil},
+		{3, "Types", fieldString, true, nil},
+	}
+	dnssecTrustAnchorFields = []grpcField{
+		{1, "zone", fieldString, false, nil},
+		{2, "keyTag", fieldUint, false, nil},
+		{3, "dnskey", fieldString, false, nil},
+		{4, "ds", fieldString, false, nil},
+	}
+	tlsALPN01RequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Content", fieldString, false, nil},
+		{3, "KeyType", fieldString, false, nil},
+		{4, "ExtraNames", fieldString, true, nil},
+		{5, "ACMEIdentifier", fieldString, false, nil},
+	}
+	latencyRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "type", fieldString, false, nil},
+		{3, "Delay", fieldString, false, nil},
+	}
+	historyRequestFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "type", fieldString, false, nil},
+		{3, "Since", fieldString, false, nil},
+		{4, "Until", fieldString, false, nil},
+	}
+	historyQueryFields = []grpcField{
+		{1, "Host", fieldString, false, nil},
+		{2, "Since", fieldString, false, nil},
+		{3, "Until", fieldString, false, nil},
+	}
+	batchFields = []grpcField{
+		{1, "A", fieldMessage, true, addressRequestFields},
+		{2, "AAAA", fieldMessage, true, addressRequestFields},
+		{3, "TXT", fieldMessage, true, txtRequestFields},
+		{4, "CAA", fieldMessage, true, caaRequestFields},
+		{5, "CNAME", fieldMessage, true, cnameRequestFields},
+		{6, "HTTP01", fieldMessage, true, http01RequestFields},
+		{7, "TLSALPN01", fieldMessage, true, tlsALPN01RequestFields},
+	}
+	httpRequestEventFields = []grpcField{
+		{1, "URL", fieldString, false, nil},
+		{2, "Host", fieldString, false, nil},
+		{3, "HTTPS", fieldBool, false, nil},
+		{4, "ServerName", fieldString, false, nil},
+		{5, "Time", fieldString, false, nil},
+	}
+	dnsQuestionFields = []grpcField{
+		{1, "Name", fieldString, false, nil},
+		{2, "Qtype", fieldUint, false, nil},
+		{3, "Qclass", fieldUint, false, nil},
+	}
+	dnsRequestEventFields = []grpcField{
+		{1, "Question", fieldMessage, false, dnsQuestionFields},
+		{2, "Time", fieldString, false, nil},
+	}
+	tlsALPNRequestEventFields = []grpcField{
+		{1, "ServerName", fieldString, false, nil},
+		{2, "SupportedProtos", fieldString, true, nil},
+		{3, "Time", fieldString, false, nil},
+	}
+	httpRequestHistoryFields = []grpcField{
+		{1, "Events", fieldMessage, true, httpRequestEventFields},
+	}
+	dnsRequestHistoryFields = []grpcField{
+		{1, "Events", fieldMessage, true, dnsRequestEventFields},
+	}
+	tlsALPNRequestHistoryFields = []grpcField{
+		{1, "Events", fieldMessage, true, tlsALPNRequestEventFields},
+	}
+)
+
+// grpcMethod is a unary method of the gRPC management service. Its request is
+// POSTed in JSON form to the path of the HTTP management interface, and the
+// JSON response, if any, is its response. A JSON list is the value of the
+// first field of the response.
+type grpcMethod struct {
+	path     string
+	request  []grpcField
+	response []grpcField
+}
+
+// grpcMethods are the unary methods of the gRPC management service by name
+var grpcMethods = map[string]grpcMethod{
+	"AddHTTP01":                {"/add-http01", http01RequestFields, nil},
+	"DeleteHTTP01":             {"/del-http01", http01RequestFields, nil},
+	"AddRedirect":              {"/add-redirect", redirectRequestFields, nil},
+	"DeleteRedirect":           {"/del-redirect", redirectRequestFields, nil},
+	"SetDefaultIPv4":           {"/set-default-ipv4", defaultIPRequestFields, nil},
+	"SetDefaultIPv6":           {"/set-default-ipv6", defaultIPRequestFields, nil},
+	"SetTXT":                   {"/set-txt", txtRequestFields, nil},
+	"ClearTXT":                 {"/clear-txt", hostRequestFields, nil},
+	"AddA":                     {"/add-a", addressRequestFields, nil},
+	"ClearA":                   {"/clear-a", hostRequestFields, nil},
+	"AddAAAA":                  {"/add-aaaa", addressRequestFields, nil},
+	"ClearAAAA":                {"/clear-aaaa", hostRequestFields, nil},
+	"AddCAA":                   {"/add-caa", caaRequestFields, nil},
+	"ClearCAA":                 {"/clear-caa", hostRequestFields, nil},
+	"SetCNAME":                 {"/set-cname", cnameRequestFields, nil},
+	"ClearCNAME":               {"/clear-cname", hostRequestFields, nil},
+	"SetDNAME":                 {"/set-dname", cnameRequestFields, nil},
+	"ClearDNAME":               {"/clear-dname", hostRequestFields, nil},
+	"SetServFail":              {"/set-servfail", hostRequestFields, nil},
+	"ClearServFail":            {"/clear-servfail", hostRequestFields, nil},
+	"AddSVCB":                  {"/add-svcb", svcbRequestFields, nil},
+	"ClearSVCB":                {"/clear-svcb", hostRequestFields, nil},
+	"AddHTTPS":                 {"/add-https", svcbRequestFields, nil},
+	"ClearHTTPS":               {"/clear-https", hostRequestFields, nil},
+	"SetDNSFailure":            {"/set-dns-failure", dnsFailureRequestFields, nil},
+	"ClearDNSFailure":          {"/clear-dns-failure", dnsFailureRequestFields, nil},
+	"GetDNSSECTrustAnchor":     {"/dnssec-trust-anchor", nil, dnssecTrustAnchorFields},
+	"SetDNSSECBroken":          {"/set-dnssec-broken", hostRequestFields, nil},
+	"ClearDNSSECBroken":        {"/clear-dnssec-broken", hostRequestFields, nil},
+	"AddTLSALPN01":             {"/add-tlsalpn01", tlsALPN01RequestFields, nil},
+	"DeleteTLSALPN01":          {"/del-tlsalpn01", hostRequestFields, nil},
+	"SetLatency":               {"/set-latency", latencyRequestFields, nil},
+	"ClearLatency":             {"/clear-latency", latencyRequestFields, nil},
+	"AddBatch":                 {"/add-batch", batchFields, nil},
+	"ClearRequestHistory":      {"/clear-request-history", historyRequestFields, nil},
+	"GetHTTPRequestHistory":    {"/http-request-history", historyQueryFields, httpRequestHistoryFields},
+	"GetDNSRequestHistory":     {"/dns-request-history", historyQueryFields, dnsRequestHistoryFields},
+	"GetTLSALPNRequestHistory": {"/tlsalpn01-request-history", historyQueryFields, tlsALPNRequestHistoryFields},
+}
+
+// grpcRequestEvent is the field of the RequestEvent message of a type of
+// request events.
+type grpcRequestEvent struct {
+	number protowire.Number
+	fields []grpcField
+}
+
+var grpcRequestEvents = map[challtestsrv.RequestEventType]grpcRequestEvent{
+	challtestsrv.HTTPRequestEventType:    {1, httpRequestEventFields},
+	challtestsrv.DNSRequestEventType:     {2, dnsRequestEventFields},
+	challtestsrv.TLSALPNRequestEventType: {3, tlsALPNRequestEventFields},
+}
+
+// grpcHandler returns a handler serving the gRPC calls of the management
+// service with the handlers of the HTTP management interface of mux, and
+// the other requests with mux.
+func (srv *managementServer) grpcHandler(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		method := strings.TrimPrefix(r.URL.Path, "/"+grpcService+"/")
+		request, err := readGRPCMessage(r.Body)
+		if err != nil {
+			writeGRPCStatus(w, err)
+			return
+		}
+		switch method {
+		case "GetRequestHistory":
+			srv.getHistoryGRPC(w, request)
+			return
+		case "StreamRequestHistory":
+			srv.streamHistory(w, r, request)
+			return
+		}
+		m, ok := grpcMethods[method]
+		if !ok {
+			writeGRPCStatus(w, &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %q", r.URL.Path)})
+			return
+		}
+		response, err := m.call(mux, r, request)
+		if err != nil {
+			writeGRPCStatus(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if err := writeGRPCMessage(w, response); err != nil {
+			srv.log.Printf("Error writing gRPC response: %v\n", err)
+			return
+		}
+		writeGRPCStatus(w, nil)
+	})
+}
+
+// call POSTs the request in JSON form to the handler of the method's path
+// and returns the response message.
+func (m grpcMethod) call(mux http.Handler, r *http.Request, request []byte) ([]byte, error) {
+	value, err := parseMessage(request, m.request)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(r.Context(), http.MethodPost, m.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httpRequest)
+
+	switch recorder.Code {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		return nil, &grpcError{grpcInvalidArgument, strings.TrimSpace(recorder.Body.String())}
+	case http.StatusNotFound:
+		return nil, &grpcError{grpcUnimplemented, fmt.Sprintf("%s is disabled", m.path)}
+	default:
+		return nil, &grpcError{grpcInternal, fmt.Sprintf("%s failed with status %d", m.path, recorder.Code)}
+	}
+	if m.response == nil {
+		return nil, nil
+	}
+
+	var response interface{}
+	decoder := json.NewDecoder(recorder.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+	if list, ok := response.([]interface{}); ok {
+		response = map[string]interface{}{m.response[0].key: list}
+	}
+	return appendMessage(nil, m.response, response)
+}
+
+// parseHistoryRequest returns the filter of a HistoryRequest message.
+func parseHistoryRequest(message []byte) (historyFilter, error) {
+	value, err := parseMessage(message, historyRequestFields)
+	if err != nil {
+		return historyFilter{}, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	jsonRequest, err := json.Marshal(value)
+	if err != nil {
+		return historyFilter{}, err
+	}
+	var request historyRequest
+	if err := json.Unmarshal(jsonRequest, &request); err != nil {
+		return historyFilter{}, err
+	}
+	filter, err := request.filter()
+	if err != nil {
+		return filter, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	return filter, nil
+}
+
+// getHistoryGRPC responds with the events of the request history selected by
+// the filter of a HistoryRequest message.
+func (srv *managementServer) getHistoryGRPC(w http.ResponseWriter, request []byte) {
+	filter, err := parseHistoryRequest(request)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+	var response []byte
+	for _, event := range srv.history.Find(filter) {
+		message, err := encodeRequestEvent(event)
+		if err != nil {
+			writeGRPCStatus(w, err)
+			return
+		}
+		response = protowire.AppendTag(response, 1, protowire.BytesType)
+		response = protowire.AppendBytes(response, message)
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := writeGRPCMessage(w, response); err != nil {
+		srv.log.Printf("Error writing gRPC response: %v\n", err)
+		return
+	}
+	writeGRPCStatus(w, nil)
+}
+
+// streamHistory streams the events of the request history selected by the
+// filter of a HistoryRequest message, the recorded events first and then the
+// events as they are recorded, until the client cancels the call.
+func (srv *managementServer) streamHistory(w http.ResponseWriter, r *http.Request, request []byte) {
+	filter, err := parseHistoryRequest(request)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeGRPCStatus(w, errors.New("streaming is not supported"))
+		return
+	}
+
+	srv.log.Printf("Streaming challenge server request history for %q %q events\n",
+		filter.host, filter.typ)
+	w.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(grpcHistoryPollInterval)
+	defer ticker.Stop()
+	var sent uint64
+	for {
+		for _, event := range srv.history.Find(filter) {
+			if event.seq <= sent {
+				continue
+			}
+			message, err := encodeRequestEvent(event)
+			if err == nil {
+				err = writeGRPCMessage(w, message)
+			}
+			if err != nil {
+				srv.log.Printf("Error streaming request history: %v\n", err)
+				return
+			}
+			sent = event.seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			writeGRPCStatus(w, nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// encodeRequestEvent returns the RequestEvent message of an event.
+func encodeRequestEvent(event historyEvent) ([]byte, error) {
+	t, ok := grpcRequestEvents[event.Type()]
+	if !ok {
+		return nil, fmt.Errorf("unknown request event type %d", event.Type())
+	}
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonEvent))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	message, err := appendMessage(nil, t.fields, value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendBytes(protowire.AppendTag(nil, t.number, protowire.BytesType), message), nil
+}
+
+// readGRPCMessage reads a length-prefixed message of a gRPC request.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading message: %s", err)}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessageSize {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("message of %d bytes is too large", size)}
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading message: %s", err)}
+	}
+	return message, nil
+}
+
+// writeGRPCMessage writes a length-prefixed message of a gRPC response.
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	_, err := w.Write(append(frame, message...))
+	return err
+}
+
+// writeGRPCStatus writes the status of a gRPC call to the trailers of the
+// response, which is OK if the error is nil.
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code, message := grpcOK, ""
+	if err != nil {
+		code, message = grpcInternal, err.Error()
+		var grpcErr *grpcError
+		if errors.As(err, &grpcErr) {
+			code = grpcErr.code
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", percentEncode(message))
+	}
+}
+
+// percentEncode encodes a grpc-message, escaping the bytes that are not
+// printable ASCII characters and '%'.
+func percentEncode(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// parseMessage parses a protobuf message with the given fields into its
+// JSON form. Unknown fields are ignored.
+func parseMessage(b []byte, fields []grpcField) (map[string]interface{}, error) {
+	value := make(map[string]interface{})
+	for len(b) > 0 {
+		number, wireType, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var field *grpcField
+		for i := range fields {
+			if fields[i].number == number {
+				field = &fields[i]
+			}
+		}
+		if field == nil {
+			n = protowire.ConsumeFieldValue(number, wireType, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		var v interface{}
+		switch {
+		case field.kind == fieldString && wireType == protowire.BytesType:
+			v, n = protowire.ConsumeString(b)
+		case field.kind == fieldBool && wireType == protowire.VarintType:
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			v = x != 0
+		case field.kind == fieldUint && wireType == protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case field.kind == fieldMessage && wireType == protowire.BytesType:
+			var raw []byte
+			raw, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				var err error
+				if v, err = parseMessage(raw, field.fields); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("field %d has unexpected wire type %d", number, wireType)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if field.repeated {
+			list, _ := value[field.key].([]interface{})
+			value[field.key] = append(list, v)
+		} else {
+			value[field.key] = v
+		}
+	}
+	return value, nil
+}
+
+// appendMessage appends the protobuf message with the given fields of a
+// value in JSON form, decoded with json.Decoder.UseNumber, to b.
+func appendMessage(b []byte, fields []grpcField, value interface{}) ([]byte, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v is not a JSON object", value)
+	}
+	for _, field := range fields {
+		v, ok := object[field.key]
+		if !ok || v == nil {
+			continue
+		}
+		values := []interface{}{v}
+		if field.repeated {
+			if values, ok = v.([]interface{}); !ok {
+				return nil, fmt.Errorf("%s is not a JSON list", field.key)
+			}
+		}
+		for _, v := range values {
+			var err error
+			if b, err = appendField(b, field, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendField appends a field with a value in JSON form to b, omitting the
+// default values of fields that aren't repeated.
+func appendField(b []byte, field grpcField, value interface{}) ([]byte, error) {
+	switch field.kind {
+	case fieldString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", field.key)
+		}
+		if s == "" && !field.repeated {
+			return b, nil
+		}
+		b = protowire.AppendTag(b, field.number, protowire.BytesType)
+		return protowire.AppendString(b, s), nil
+	case fieldBool:
+		t, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a boolean", field.key)
+		}
+		if !t && !field.repeated {
+			return b, nil
+		}
+		b = protowire.AppendTag(b, field.number, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(t)), nil
+	case fieldUint:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a number", field.key)
+		}
+		x, err := strconv.ParseUint(number.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an unsigned integer", field.key)
+		}
+		if x == 0 && !field.repeated {
+			return b, nil
+		}
+		b = protowire.AppendTag(b, field.number, protowire.VarintType)
+		return protowire.AppendVarint(b, x), nil
+	case fieldMessage:
+		message, err := appendMessage(nil, field.fields, value)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, field.number, protowire.BytesType)
+		return protowire.AppendBytes(b, message), nil
+	}
+	return nil, fmt.Errorf("%s has unknown kind %d", field.key, field.kind)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadGRPCMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		frame    []byte
+		want     []byte
+		wantCode int
+	}{
+		{
+			name:  "message",
+			frame: []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'},
+			want:  []byte("abc"),
+		},
+		{
+			name:  "empty message",
+			frame: []byte{0, 0, 0, 0, 0},
+			want:  []byte{},
+		},
+		{
+			name:     "compressed message",
+			frame:    []byte{1, 0, 0, 0, 3, 'a', 'b', 'c'},
+			wantCode: grpcUnimplemented,
+		},
+		{
+			name:     "too large message",
+			frame:    []byte{0, 0, 0x40, 0, 1},
+			wantCode: grpcInvalidArgument,
+		},
+		{
+			name:     "truncated prefix",
+			frame:    []byte{0, 0, 0},
+			wantCode: grpcInvalidArgument,
+		},
+		{
+			name:     "truncated message",
+			frame:    []byte{0, 0, 0, 0, 4, 'a', 'b', 'c'},
+			wantCode: grpcInvalidArgument,
+		},
+		{
+			name:     "no message",
+			frame:    []byte{},
+			wantCode: grpcInvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readGRPCMessage(bytes.NewReader(tc.frame))
+			if tc.wantCode != grpcOK {
+				var grpcErr *grpcError
+				if !errors.As(err, &grpcErr) || grpcErr.code != tc.wantCode {
+					t.Fatalf("readGRPCMessage(%v) returned error %v, want status %d", tc.frame, err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readGRPCMessage(%v) returned error: %s", tc.frame, err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("readGRPCMessage(%v) = %v, want %v", tc.frame, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteGRPCMessage(t *testing.T) {
+	for _, message := range [][]byte{{}, []byte("abc"), bytes.Repeat([]byte{0xff}, 300)} {
+		var frame bytes.Buffer
+		if err := writeGRPCMessage(&frame, message); err != nil {
+			t.Fatalf("writeGRPCMessage returned error: %s", err)
+		}
+		if frame.Len() != 5+len(message) {
+			t.Errorf("frame of a %d byte message has %d bytes", len(message), frame.Len())
+		}
+		got, err := readGRPCMessage(&frame)
+		if err != nil {
+			t.Fatalf("reading written message: %s", err)
+		}
+		if !bytes.Equal(got, message) {
+			t.Errorf("read message %v, want %v", got, message)
+		}
+	}
+}
+
+func TestPercentEncode(t *testing.T) {
+	testCases := map[string]string{
+		"":                  "",
+		"invalid argument":  "invalid argument",
+		"100% done":         "100%25 done",
+		"line\nbreak":       "line%0Abreak",
+		"café":              "caf%C3%A9",
+		"tilde ~ and space": "tilde ~ and space",
+	}
+	for message, want := range testCases {
+		if got := percentEncode(message); got != want {
+			t.Errorf("percentEncode(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fields []grpcField
+		json   string
+		want   string
+	}{
+		{
+			name:   "strings",
+			fields: http01RequestFields,
+			json:   `{"Token": "token", "Content": "content"}`,
+		},
+		{
+			name:   "default values are omitted",
+			fields: http01RequestFields,
+			json:   `{"Token": "token", "Content": ""}`,
+			want:   `{"Token": "token"}`,
+		},
+		{
+			name:   "repeated strings",
+			fields: addressRequestFields,
+			json:   `{"Host": "example.com", "Addresses": ["10.0.0.1", "", "::1"]}`,
+		},
+		{
+			name:   "nested messages",
+			fields: caaRequestFields,
+			json: `{"Host": "example.com", "Policies": [
+				{"Flag": 128, "Tag": "issue", "Value": "letsencrypt.org", "Parameters": ["validationmethods=dns-01"]},
+				{"Tag": "iodef", "Value": "mailto:security@example.com"}
+			]}`,
+		},
+		{
+			name:   "booleans and integers",
+			fields: svcbRecordFields,
+			json:   `{"Priority": 1, "Target": ".", "ALPN": ["h2"], "NoDefaultALPN": true, "Port": 8443}`,
+		},
+		{
+			name:   "unknown keys are ignored",
+			fields: hostRequestFields,
+			json:   `{"Host": "example.com", "Unknown": 1}`,
+			want:   `{"Host": "example.com"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value := decodeJSON(t, tc.json)
+			message, err := appendMessage(nil, tc.fields, value)
+			if err != nil {
+				t.Fatalf("appendMessage(%s) returned error: %s", tc.json, err)
+			}
+			parsed, err := parseMessage(message, tc.fields)
+			if err != nil {
+				t.Fatalf("parseMessage returned error: %s", err)
+			}
+
+			want := tc.json
+			if tc.want != "" {
+				want = tc.want
+			}
+			// The parsed message has uint64 numbers, compare the JSON forms
+			got, err := json.Marshal(parsed)
+			if err != nil {
+				t.Fatalf("marshaling parsed message: %s", err)
+			}
+			if !reflect.DeepEqual(decodeJSON(t, string(got)), decodeJSON(t, want)) {
+				t.Errorf("message of %s was parsed as %s", want, got)
+			}
+		})
+	}
+}
+
+func TestAppendMessageErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		fields  []grpcField
+		json    string
+		wantErr string
+	}{
+		{"not an object", hostRequestFields, `"example.com"`, "is not a JSON object"},
+		{"not a list", addressRequestFields, `{"Addresses": "10.0.0.1"}`, "Addresses is not a JSON list"},
+		{"not a string", hostRequestFields, `{"Host": 1}`, "Host is not a string"},
+		{"not a boolean", svcbRecordFields, `{"NoDefaultALPN": "yes"}`, "NoDefaultALPN is not a boolean"},
+		{"not a number", svcbRecordFields, `{"Port": "443"}`, "Port is not a number"},
+		{"negative number", svcbRecordFields, `{"Port": -1}`, "Port is not an unsigned integer"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := appendMessage(nil, tc.fields, decodeJSON(t, tc.json))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("appendMessage(%s) returned error %v, want %q", tc.json, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		message []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"truncated string", []byte{0x0a, 0x05, 'a'}},
+		{"wrong wire type", []byte{0x08, 0x01}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseMessage(tc.message, hostRequestFields); err == nil {
+				t.Errorf("parseMessage(%v) returned no error", tc.message)
+			}
+		})
+	}
+}
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("decoding %s: %s", s, err)
+	}
+	return value
+}
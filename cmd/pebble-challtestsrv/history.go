@@ -1,112 +1,313 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/letsencrypt/challtestsrv"
 )
 
+// historyEventTypes are the types of request events by name
+var historyEventTypes = map[string]challtestsrv.RequestEventType{
+	"http":    challtestsrv.HTTPRequestEventType,
+	"dns":     challtestsrv.DNSRequestEventType,
+	"tlsalpn": challtestsrv.TLSALPNRequestEventType,
+}
+
+// historyEventTypeName returns the name of a type of request events.
+func historyEventTypeName(typ challtestsrv.RequestEventType) string {
+	for name, t := range historyEventTypes {
+		if t == typ {
+			return name
+		}
+	}
+	return ""
+}
+
+// historyEvent is an event of the request history with the time the request
+// was received.
+type historyEvent struct {
+	challtestsrv.RequestEvent
+	// The sequence number of the event, which increases with each event
+	seq  uint64
+	Time time.Time
+}
+
+// MarshalJSON returns the JSON form of the request event with the name of its
+// type and its time.
+func (e historyEvent) MarshalJSON() ([]byte, error) {
+	typ := historyEventTypeName(e.Type())
+	switch event := e.RequestEvent.(type) {
+	case challtestsrv.HTTPRequestEvent:
+		return json.Marshal(struct {
+			challtestsrv.HTTPRequestEvent
+			Type string
+			Time time.Time
+		}{event, typ, e.Time})
+	case challtestsrv.DNSRequestEvent:
+		return json.Marshal(struct {
+			challtestsrv.DNSRequestEvent
+			Type string
+			Time time.Time
+		}{event, typ, e.Time})
+	case challtestsrv.TLSALPNRequestEvent:
+		return json.Marshal(struct {
+			challtestsrv.TLSALPNRequestEvent
+			Type string
+			Time time.Time
+		}{event, typ, e.Time})
+	}
+	return nil, fmt.Errorf("unknown request event %T", e.RequestEvent)
+}
+
+// historyFilter selects events of the request history. Empty fields match all
+// events.
+type historyFilter struct {
+	// A pattern of the hosts of the events as accepted by path.Match, e.g.
+	// "example.com" or "*.example.com", matched case-insensitively
+	host string
+	// The name of the type of the events, e.g. "dns"
+	typ string
+	// The events received at or after since and before until
+	since time.Time
+	until time.Time
+}
+
+// historyRequest is the POST body of the request history endpoints.
+type historyRequest struct {
+	Host  string
+	Typ   string `json:"type"`
+	Since string
+	Until string
+}
+
+// filter returns the historyFilter of the request, or an error if a
+// parameter is invalid.
+func (request historyRequest) filter() (historyFilter, error) {
+	filter := historyFilter{
+		host: strings.ToLower(request.Host),
+		typ:  request.Typ,
+	}
+	if _, err := path.Match(filter.host, ""); err != nil {
+		return filter, fmt.Errorf("invalid host pattern %q", request.Host)
+	}
+	if _, ok := historyEventTypes[filter.typ]; filter.typ != "" && !ok {
+		return filter, fmt.Errorf("%q event type unknown", request.Typ)
+	}
+	var err error
+	if request.Since != "" {
+		if filter.since, err = time.Parse(time.RFC3339, request.Since); err != nil {
+			return filter, fmt.Errorf("invalid since time: %s", err)
+		}
+	}
+	if request.Until != "" {
+		if filter.until, err = time.Parse(time.RFC3339, request.Until); err != nil {
+			return filter, fmt.Errorf("invalid until time: %s", err)
+		}
+	}
+	return filter, nil
+}
+
+// matches returns whether the filter selects the event.
+func (f historyFilter) matches(event historyEvent) bool {
+	if f.host != "" {
+		if ok, _ := path.Match(f.host, strings.ToLower(event.Key())); !ok {
+			return false
+		}
+	}
+	if f.typ != "" && historyEventTypes[f.typ] != event.Type() {
+		return false
+	}
+	if !f.since.IsZero() && event.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !event.Time.Before(f.until) {
+		return false
+	}
+	return true
+}
+
+// requestHistory is the history of the requests to the challenge servers. It
+// records the requests where the challenge servers receive them, with the
+// time they were received, instead of the history of the challtestsrv
+// package, which doesn't have their times.
+type requestHistory struct {
+	mu     sync.RWMutex
+	seq    uint64
+	events []historyEvent
+}
+
+// Add records a request event received now.
+func (h *requestHistory) Add(event challtestsrv.RequestEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	h.events = append(h.events, historyEvent{
+		RequestEvent: event,
+		seq:          h.seq,
+		Time:         time.Now().UTC(),
+	})
+}
+
+// Find returns the events selected by the filter in the order they were
+// recorded.
+func (h *requestHistory) Find(filter historyFilter) []historyEvent {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var events []historyEvent
+	for _, event := range h.events {
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Clear deletes the events selected by the filter and returns how many there
+// were.
+func (h *requestHistory) Clear(filter historyFilter) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var events []historyEvent
+	for _, event := range h.events {
+		if !filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+	cleared := len(h.events) - len(events)
+	h.events = events
+	return cleared
+}
+
+// recordHTTP returns a handler recording the HTTP requests to the next
+// handler.
+func (h *requestHistory) recordHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName := ""
+		if r.TLS != nil {
+			serverName = r.TLS.ServerName
+		}
+		h.Add(challtestsrv.HTTPRequestEvent{
+			URL:        r.URL.String(),
+			Host:       r.Host,
+			HTTPS:      r.TLS != nil,
+			ServerName: serverName,
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordTLSALPN returns a function recording the TLS-ALPN-01 handshakes whose
+// certificates are returned by the given function, with their server names
+// and offered ALPN protocols.
+func (h *requestHistory) recordTLSALPN(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		h.Add(challtestsrv.TLSALPNRequestEvent{
+			ServerName:      hello.ServerName,
+			SupportedProtos: hello.SupportedProtos,
+		})
+		return getCertificate(hello)
+	}
+}
+
 // clearHistory handles an HTTP POST request to clear the challenge server
-// request history for a specific hostname and type of event.
+// request history matching a hostname pattern.
 //
-// The POST body is expected to have two parameters:
-// "host" - the hostname to clear history for.
+// The POST body is expected to have four parameters, of which only "host" must
+// not be empty:
+// "host" - the hostname or the pattern of hostnames to clear history for,
+// e.g. "*.example.com".
 // "type" - the type of event to clear. May be "http", "dns", or "tlsalpn".
+// All types are cleared if empty.
+// "since" - the RFC 3339 time of the oldest event to clear.
+// "until" - the RFC 3339 time before which the events to clear were received.
 //
 // A successful POST will write http.StatusOK to the client.
 func (srv *managementServer) clearHistory(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		Host string
-		Typ  string `json:"type"`
-	}
+	var request historyRequest
 	if err := mustParsePOST(&request, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	typeMap := map[string]challtestsrv.RequestEventType{
-		"http":    challtestsrv.HTTPRequestEventType,
-		"dns":     challtestsrv.DNSRequestEventType,
-		"tlsalpn": challtestsrv.TLSALPNRequestEventType,
-	}
 	if request.Host == "" {
 		http.Error(w, "host parameter must not be empty", http.StatusBadRequest)
 		return
 	}
-	if code, ok := typeMap[request.Typ]; ok {
-		srv.challSrv.ClearRequestHistory(request.Host, code)
-		srv.log.Printf("Cleared challenge server request history for %q %q events\n",
-			request.Host, request.Typ)
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	http.Error(w, fmt.Sprintf("%q event type unknown", request.Typ), http.StatusBadRequest)
-}
-
-// getHTTPHistory returns only the HTTPRequestEvents for the given hostname
-// from the challenge server's request history in JSON form.
-func (srv *managementServer) getHTTPHistory(w http.ResponseWriter, r *http.Request) {
-	host, err := requestHost(r)
+	filter, err := request.filter()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	srv.writeHistory(
-		srv.challSrv.RequestHistory(host, challtestsrv.HTTPRequestEventType),
-		w)
+
+	cleared := srv.history.Clear(filter)
+	srv.log.Printf("Cleared %d challenge server request history events for %q %q events\n",
+		cleared, request.Host, request.Typ)
+	w.WriteHeader(http.StatusOK)
 }
 
-// getDNSHistory returns only the DNSRequestEvents from the challenge
-// server's request history in JSON form.
-func (srv *managementServer) getDNSHistory(w http.ResponseWriter, r *http.Request) {
-	host, err := requestHost(r)
-	if err != nil {
+// getHistory returns the events of the challenge server's request history
+// matching the filter of a POST body in JSON form.
+//
+// The POST body may have four parameters, which select all events if empty:
+// "host" - the hostname or the pattern of hostnames of the events, e.g.
+// "*.example.com".
+// "type" - the type of the events. May be "http", "dns", or "tlsalpn".
+// "since" - the RFC 3339 time of the oldest event.
+// "until" - the RFC 3339 time before which the events were received.
+func (srv *managementServer) getHistory(w http.ResponseWriter, r *http.Request) {
+	var request historyRequest
+	if err := mustParsePOST(&request, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	srv.writeHistory(
-		srv.challSrv.RequestHistory(host, challtestsrv.DNSRequestEventType),
-		w)
-}
-
-// getTLSALPNHistory returns only the TLSALPNRequestEvents from the challenge
-// server's request history in JSON form.
-func (srv *managementServer) getTLSALPNHistory(w http.ResponseWriter, r *http.Request) {
-	host, err := requestHost(r)
+	filter, err := request.filter()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	srv.writeHistory(
-		srv.challSrv.RequestHistory(host, challtestsrv.TLSALPNRequestEventType),
-		w)
+	srv.writeHistory(srv.history.Find(filter), w)
 }
 
-// requestHost extracts the Host parameter of a JSON POST body in the provided
-// request, or returns an error.
-func requestHost(r *http.Request) (string, error) {
-	var request struct {
-		Host string
-	}
-	if err := mustParsePOST(&request, r); err != nil {
-		return "", err
-	}
-	if request.Host == "" {
-		return "", errors.New("host parameter of POST body must not be empty")
+// getTypeHistory returns a handler returning only the events of the given
+// type from the challenge server's request history in JSON form. The POST
+// body must have a non-empty "host" parameter and may have the "since" and
+// "until" parameters of getHistory.
+func (srv *managementServer) getTypeHistory(typ string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request historyRequest
+		if err := mustParsePOST(&request, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Host == "" {
+			http.Error(w, "host parameter of POST body must not be empty", http.StatusBadRequest)
+			return
+		}
+		request.Typ = typ
+		filter, err := request.filter()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.writeHistory(srv.history.Find(filter), w)
 	}
-	return request.Host, nil
 }
 
-// writeHistory writes the provided list of challtestsrv.RequestEvents to the
-// provided http.ResponseWriter in JSON form.
+// writeHistory writes the provided list of historyEvents to the provided
+// http.ResponseWriter in JSON form.
 func (srv *managementServer) writeHistory(
-	history []challtestsrv.RequestEvent, w http.ResponseWriter) {
+	history []historyEvent, w http.ResponseWriter) {
 	// Always write an empty JSON list instead of `null`
 	if history == nil {
-		history = []challtestsrv.RequestEvent{}
+		history = []historyEvent{}
 	}
 	jsonHistory, err := json.MarshalIndent(history, "", "   ")
 	if err != nil {
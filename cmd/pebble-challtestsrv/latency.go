@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of responses whose latency can be injected, named like the request
+// history event types
+const (
+	latencyDNS     = "dns"
+	latencyHTTP    = "http"
+	latencyTLSALPN = "tlsalpn"
+)
+
+var latencyKinds = []string{latencyDNS, latencyHTTP, latencyTLSALPN}
+
+// latencies holds the delays injected before the responses to requests for
+// specific hosts. It is safe to use concurrently.
+type latencies struct {
+	mu sync.RWMutex
+	// A map of response kind to a map of host to delay
+	delays map[string]map[string]time.Duration
+}
+
+func newLatencies() *latencies {
+	l := &latencies{delays: make(map[string]map[string]time.Duration)}
+	for _, kind := range latencyKinds {
+		l.delays[kind] = make(map[string]time.Duration)
+	}
+	return l
+}
+
+// latencyHost returns the host of a DNS name, HTTP Host header or TLS server
+// name, lower case without a port or trailing dot.
+func latencyHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Set sets the delay of the responses of the given kind for the host.
+func (l *latencies) Set(kind, host string, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.delays[kind][latencyHost(host)] = delay
+}
+
+// Delete deletes the delay of the responses of the given kind for the host.
+func (l *latencies) Delete(kind, host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.delays[kind], latencyHost(host))
+}
+
+// wait sleeps for the delay of the responses of the given kind for the host,
+// if any.
+func (l *latencies) wait(kind, host string) {
+	l.mu.RLock()
+	delay := l.delays[kind][latencyHost(host)]
+	l.mu.RUnlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// delayHTTP returns a handler delaying the responses of the next handler by
+// the HTTP latency of the Host of the requests.
+func (l *latencies) delayHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.wait(latencyHTTP, r.Host)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// delayTLSALPN returns a function delaying the certificates of TLS-ALPN-01
+// handshakes returned by the given function by the TLS-ALPN latency of the
+// server name of the handshakes.
+func (l *latencies) delayTLSALPN(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		l.wait(latencyTLSALPN, hello.ServerName)
+		return getCertificate(hello)
+	}
+}
+
+// setLatency handles an HTTP POST request to delay responses for a host.
+//
+// The POST body is expected to have three non-empty parameters:
+// "host" - the hostname whose responses should be delayed.
+// "type" - the kind of responses to delay. May be "dns", "http" or "tlsalpn".
+// "delay" - the delay as a duration string, e.g. "1.5s".
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) setLatency(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host  string
+		Typ   string `json:"type"`
+		Delay string
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Host == "" {
+		http.Error(w, "host parameter must not be empty", http.StatusBadRequest)
+		return
+	}
+	if _, ok := srv.latencies.delays[request.Typ]; !ok {
+		http.Error(w, fmt.Sprintf("%q latency type unknown", request.Typ), http.StatusBadRequest)
+		return
+	}
+	delay, err := time.ParseDuration(request.Delay)
+	if err != nil || delay < 0 {
+		http.Error(w, fmt.Sprintf("invalid delay %q", request.Delay), http.StatusBadRequest)
+		return
+	}
+
+	srv.latencies.Set(request.Typ, request.Host, delay)
+	srv.log.Printf("Delaying %s responses for %q by %s", request.Typ, request.Host, delay)
+	w.WriteHeader(http.StatusOK)
+}
+
+// clearLatency handles an HTTP POST request to stop delaying responses for a
+// host.
+//
+// The POST body is expected to have two parameters:
+// "host" - the hostname whose responses should not be delayed.
+// "type" - the kind of responses not to delay. May be "dns", "http" or
+// "tlsalpn", or empty for all kinds.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) clearLatency(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Host string
+		Typ  string `json:"type"`
+	}
+	if err := mustParsePOST(&request, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Host == "" {
+		http.Error(w, "host parameter must not be empty", http.StatusBadRequest)
+		return
+	}
+	kinds := latencyKinds
+	if request.Typ != "" {
+		if _, ok := srv.latencies.delays[request.Typ]; !ok {
+			http.Error(w, fmt.Sprintf("%q latency type unknown", request.Typ), http.StatusBadRequest)
+			return
+		}
+		kinds = []string{request.Typ}
+	}
+
+	for _, kind := range kinds {
+		srv.latencies.Delete(kind, request.Host)
+	}
+	srv.log.Printf("Removed %s response delays for %q", strings.Join(kinds, ", "), request.Host)
+	w.WriteHeader(http.StatusOK)
+}
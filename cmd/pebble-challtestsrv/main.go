@@ -5,6 +5,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
@@ -12,6 +14,9 @@ import (
 	"strings"
 
 	"github.com/letsencrypt/challtestsrv"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
 	"github.com/letsencrypt/pebble/cmd"
 )
 
@@ -23,6 +28,16 @@ type managementServer struct {
 	log *log.Logger
 	// The challenge server that is under control by the management server
 	challSrv *challtestsrv.ChallSrv
+	// The DNS server answering the record types the challenge server doesn't
+	dnsSrv *dnsServer
+	// The delays of the responses of the challenge servers for hosts
+	latencies *latencies
+	// The history of the requests to the challenge servers
+	history *requestHistory
+	// The metrics of the challenge servers and the management server
+	metrics *challSrvMetrics
+	// The TLS-ALPN-01 challenge response certificates
+	tlsALPNCerts *tlsALPNCerts
 }
 
 func (srv *managementServer) Run() {
@@ -63,6 +78,20 @@ func main() {
 		"Comma separated bind addresses/ports for DNS-01 challenges and fake DNS data. Set empty to disable.")
 	tlsAlpnOneBind := flag.String("tlsalpn01", ":5001",
 		"Comma separated bind addresses/ports for TLS-ALPN-01 and HTTPS HTTP-01 challenges. Set empty to disable.")
+	dotBind := flag.String("dot", "",
+		"Comma separated bind addresses/ports for DNS-over-TLS queries of the fake DNS data. Set empty to disable.")
+	dohBind := flag.String("doh", "",
+		"Comma separated bind addresses/ports for DNS-over-HTTPS queries of the fake DNS data. Set empty to disable.")
+	dnsTLSCert := flag.String("dnsTLSCert", "",
+		"PEM certificate file of the DNS-over-TLS and DNS-over-HTTPS servers. A self-signed certificate is generated if empty.")
+	dnsTLSKey := flag.String("dnsTLSKey", "",
+		"PEM private key file of the DNS-over-TLS and DNS-over-HTTPS servers")
+	dnssec := flag.Bool("dnssec", false,
+		"Sign the fake DNS data with DNSSEC in responses to queries with the DNSSEC OK bit")
+	dnssecZone := flag.String("dnssecZone", ".",
+		"Zone whose names are signed with DNSSEC")
+	dnssecKey := flag.String("dnssecKey", "",
+		"PEM ECDSA P-256, ECDSA P-384 or Ed25519 private key file to sign DNS responses with. A P-256 key is generated if empty.")
 	managementBind := flag.String("management", ":8055",
 		"Bind address/port for management HTTP interface")
 	defaultIPv4 := flag.String("defaultIPv4", "127.0.0.1",
@@ -76,10 +105,13 @@ func main() {
 	httpsOneAddresses := filterEmpty(strings.Split(*httpsOneBind, ","))
 	dnsOneAddresses := filterEmpty(strings.Split(*dnsOneBind, ","))
 	tlsAlpnOneAddresses := filterEmpty(strings.Split(*tlsAlpnOneBind, ","))
+	dotAddresses := filterEmpty(strings.Split(*dotBind, ","))
+	dohAddresses := filterEmpty(strings.Split(*dohBind, ","))
 
 	logger := log.New(os.Stdout, "pebble-challtestsrv - ", log.Ldate|log.Ltime)
 
-	// Create a new challenge server with the provided config
+	// Create a new challenge server with the provided config. It provides the
+	// challenge responses and mock DNS data, but the servers are run below.
 	srv, err := challtestsrv.New(challtestsrv.Config{
 		HTTPOneAddrs:    httpOneAddresses,
 		HTTPSOneAddrs:   httpsOneAddresses,
@@ -90,13 +122,25 @@ func main() {
 	cmd.FailOnError(err, "Unable to construct challenge server")
 
 	// Create a new management server with the provided config
+	latencies := newLatencies()
+	history := &requestHistory{}
+	metrics := newChallSrvMetrics()
 	oobSrv := managementServer{
 		Server: &http.Server{
 			Addr: *managementBind,
 		},
-		challSrv: srv,
-		log:      logger,
+		challSrv:     srv,
+		dnsSrv:       newDNSServer(srv, latencies, history, metrics),
+		latencies:    latencies,
+		history:      history,
+		metrics:      metrics,
+		tlsALPNCerts: newTLSALPNCerts(srv),
+		log:          logger,
 	}
+	// The gRPC management service of management.proto is served alongside the
+	// HTTP management interface, over HTTP/2 without TLS
+	oobSrv.Handler = h2c.NewHandler(
+		metrics.countManagement(oobSrv.grpcHandler(http.DefaultServeMux)), &http2.Server{})
 	// Register handlers on the management server for adding challenge responses
 	// for the configured challenges.
 	if *httpOneBind != "" || *httpsOneBind != "" {
@@ -121,9 +165,28 @@ func main() {
 		http.HandleFunc("/clear-caa", oobSrv.delDNSCAARecord)
 		http.HandleFunc("/set-cname", oobSrv.addDNSCNAMERecord)
 		http.HandleFunc("/clear-cname", oobSrv.delDNSCNAMERecord)
+		http.HandleFunc("/set-dname", oobSrv.addDNSDNAMERecord)
+		http.HandleFunc("/clear-dname", oobSrv.delDNSDNAMERecord)
 		http.HandleFunc("/set-servfail", oobSrv.addDNSServFailRecord)
 		http.HandleFunc("/clear-servfail", oobSrv.delDNSServFailRecord)
+		http.HandleFunc("/add-svcb", oobSrv.addDNSSVCBRecord(typeSVCB))
+		http.HandleFunc("/clear-svcb", oobSrv.delDNSSVCBRecord(typeSVCB))
+		http.HandleFunc("/add-https", oobSrv.addDNSSVCBRecord(typeHTTPS))
+		http.HandleFunc("/clear-https", oobSrv.delDNSSVCBRecord(typeHTTPS))
+		http.HandleFunc("/set-dns-failure", oobSrv.addDNSFailure)
+		http.HandleFunc("/clear-dns-failure", oobSrv.delDNSFailure)
 
+		oobSrv.dnsSrv.install()
+		if *dnssec {
+			signer, err := newDNSSECSigner(*dnssecZone, *dnssecKey)
+			cmd.FailOnError(err, "Unable to construct DNSSEC signer")
+			oobSrv.dnsSrv.enableDNSSEC(signer)
+			http.HandleFunc("/dnssec-trust-anchor", oobSrv.getDNSSECTrustAnchor)
+			http.HandleFunc("/set-dnssec-broken", oobSrv.setDNSSECBroken(true))
+			http.HandleFunc("/clear-dnssec-broken", oobSrv.setDNSSECBroken(false))
+			logger.Printf("Signing DNS responses for zone %q with DNSSEC key tag %d",
+				signer.zone, signer.dnskey.KeyTag())
+		}
 		srv.SetDefaultDNSIPv4(*defaultIPv4)
 		srv.SetDefaultDNSIPv6(*defaultIPv6)
 		if *defaultIPv4 != "" {
@@ -135,24 +198,86 @@ func main() {
 				*defaultIPv6)
 		}
 	}
+
+	// Create the challenge servers answering with the responses of the
+	// challenge server
+	var servers []challengeServer
+	httpOneHandler := history.recordHTTP(metrics.countHTTP(latencies.delayHTTP(srv)))
+	for _, address := range httpOneAddresses {
+		servers = append(servers, httpOneServer(address, httpOneHandler, nil))
+	}
+	if len(httpsOneAddresses) > 0 {
+		cert, err := selfSignedCertificate("challenge test server")
+		cmd.FailOnError(err, "Unable to generate HTTPS HTTP-01 certificate")
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+		for _, address := range httpsOneAddresses {
+			servers = append(servers, httpOneServer(address, httpOneHandler, tlsConfig))
+		}
+	}
+	for _, address := range dnsOneAddresses {
+		servers = append(servers, dnsOneServers(address, oobSrv.dnsSrv)...)
+	}
+	if len(tlsAlpnOneAddresses) > 0 {
+		getCertificate := history.recordTLSALPN(
+			metrics.countTLSALPN(latencies.delayTLSALPN(oobSrv.tlsALPNCerts.getCertificate)))
+		for _, address := range tlsAlpnOneAddresses {
+			servers = append(servers, tlsALPNOneServer(address, getCertificate))
+		}
+	}
+
+	// The DNS-over-TLS and DNS-over-HTTPS servers answer queries with the same
+	// fake DNS data as the DNS-01 servers
+	if len(dotAddresses) > 0 || len(dohAddresses) > 0 {
+		if *dnsOneBind == "" {
+			cmd.FailOnError(errors.New("-dns01 must not be empty"),
+				"Unable to construct DNS-over-TLS and DNS-over-HTTPS servers")
+		}
+		tlsConfig, err := encryptedDNSTLSConfig(*dnsTLSCert, *dnsTLSKey)
+		cmd.FailOnError(err, "Unable to load DNS-over-TLS and DNS-over-HTTPS certificate")
+		for _, address := range dotAddresses {
+			logger.Printf("Creating DNS-over-TLS server on %s", address)
+			servers = append(servers, dnsOverTLSServer(address, tlsConfig, oobSrv.dnsSrv))
+		}
+		for _, address := range dohAddresses {
+			logger.Printf("Creating DNS-over-HTTPS server on https://%s%s", address, dohPath)
+			servers = append(servers, dnsOverHTTPSServer(address, tlsConfig, oobSrv.dnsSrv))
+		}
+	}
+
 	if *tlsAlpnOneBind != "" {
 		http.HandleFunc("/add-tlsalpn01", oobSrv.addTLSALPN01)
 		http.HandleFunc("/del-tlsalpn01", oobSrv.delTLSALPN01)
 	}
 
+	http.HandleFunc("/add-batch", oobSrv.addBatch)
 	http.HandleFunc("/clear-request-history", oobSrv.clearHistory)
-	http.HandleFunc("/http-request-history", oobSrv.getHTTPHistory)
-	http.HandleFunc("/dns-request-history", oobSrv.getDNSHistory)
-	http.HandleFunc("/tlsalpn01-request-history", oobSrv.getTLSALPNHistory)
+	http.HandleFunc("/request-history", oobSrv.getHistory)
+	http.HandleFunc("/http-request-history", oobSrv.getTypeHistory("http"))
+	http.HandleFunc("/dns-request-history", oobSrv.getTypeHistory("dns"))
+	http.HandleFunc("/tlsalpn01-request-history", oobSrv.getTypeHistory("tlsalpn"))
+	http.Handle("/metrics", metrics)
+	http.HandleFunc("/set-latency", oobSrv.setLatency)
+	http.HandleFunc("/clear-latency", oobSrv.clearLatency)
 
 	// Start all of the sub-servers in their own Go routines so that the main Go
 	// routine can spin forever looking for signals to catch.
-	go srv.Run()
+	logger.Printf("Starting challenge servers")
+	for _, challSrv := range servers {
+		go func(challSrv challengeServer) {
+			if err := challSrv.ListenAndServe(); err != nil {
+				logger.Print(err)
+			}
+		}(challSrv)
+	}
 	go oobSrv.Run()
 
 	cmd.CatchSignals(func() {
 		logger.Printf("Caught signals. Shutting down")
-		srv.Shutdown()
+		for _, challSrv := range servers {
+			if err := challSrv.Shutdown(); err != nil {
+				logger.Printf("err in Shutdown(): %s", err)
+			}
+		}
 		oobSrv.Shutdown()
 		logger.Printf("Goodbye!")
 	})
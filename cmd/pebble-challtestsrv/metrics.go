@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"github.com/letsencrypt/pebble/metrics"
+)
+
+// challSrvMetrics are the Prometheus metrics of the challenge servers and the
+// management interface, served at /metrics of the management interface.
+type challSrvMetrics struct {
+	*metrics.Registry
+	dnsQueries         *metrics.CounterVec
+	http01Requests     *metrics.CounterVec
+	tlsALPN01Requests  *metrics.CounterVec
+	managementRequests *metrics.CounterVec
+}
+
+func newChallSrvMetrics() *challSrvMetrics {
+	registry := metrics.NewRegistry()
+	return &challSrvMetrics{
+		Registry: registry,
+		dnsQueries: registry.NewCounterVec("challtestsrv_dns_queries_total",
+			"DNS queries by query type and response code.", "qtype", "rcode"),
+		http01Requests: registry.NewCounterVec("challtestsrv_http01_requests_total",
+			"HTTP-01 and HTTPS HTTP-01 requests by host and scheme.", "host", "scheme"),
+		tlsALPN01Requests: registry.NewCounterVec("challtestsrv_tlsalpn01_requests_total",
+			"TLS-ALPN-01 handshakes by server name.", "host"),
+		managementRequests: registry.NewCounterVec("challtestsrv_management_requests_total",
+			"Management interface requests by path and status code, the gRPC status code of gRPC calls.", "path", "code"),
+	}
+}
+
+// queryTypeName returns the name of a DNS query type, e.g. "TXT", or its
+// RFC 3597 name like "TYPE64999" if it is unknown.
+func queryTypeName(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	if name, ok := svcbTypeNames[qtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// rcodeResponseWriter is a dns.ResponseWriter remembering the response code
+// of the response written, if any.
+type rcodeResponseWriter struct {
+	dns.ResponseWriter
+	rcode   int
+	written bool
+}
+
+func (w *rcodeResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.rcode, w.written = m.Rcode, true
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// countDNS counts a DNS query answered by a rcodeResponseWriter. Queries
+// without a response, like those of timeout failures, have the response code
+// "none".
+func (m *challSrvMetrics) countDNS(r *dns.Msg, w *rcodeResponseWriter) {
+	qtype := "none"
+	if len(r.Question) > 0 {
+		qtype = queryTypeName(r.Question[0].Qtype)
+	}
+	rcode := "none"
+	if w.written {
+		rcode = dns.RcodeToString[w.rcode]
+	}
+	m.dnsQueries.Inc(qtype, rcode)
+}
+
+// countHTTP returns a handler counting the HTTP-01 requests to the next
+// handler.
+func (m *challSrvMetrics) countHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		m.http01Requests.Inc(host, scheme)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// countTLSALPN returns a function counting the TLS-ALPN-01 handshakes whose
+// certificates are returned by the given function.
+func (m *challSrvMetrics) countTLSALPN(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		m.tlsALPN01Requests.Inc(hello.ServerName)
+		return getCertificate(hello)
+	}
+}
+
+// statusResponseWriter is a http.ResponseWriter remembering the status code
+// of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response for streaming gRPC calls.
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// countManagement returns a handler counting the requests to the management
+// interface served by the next handler, with the HTTP status code of the
+// response or the gRPC status code of gRPC calls.
+func (m *challSrvMetrics) countManagement(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		code := strconv.Itoa(sw.code)
+		if sw.code == 0 {
+			code = strconv.Itoa(http.StatusOK)
+		}
+		if status := w.Header().Get(http.TrailerPrefix + "Grpc-Status"); status != "" {
+			code = status
+		}
+		m.managementRequests.Inc(r.URL.Path, code)
+	})
+}
@@ -4,8 +4,6 @@ package main
 import (
 	"net/http"
 	"strings"
-
-	"github.com/letsencrypt/challtestsrv"
 )
 
 // setDefaultDNSIPv4 handles an HTTP POST request to set the default IPv4
@@ -182,19 +180,21 @@ func (srv *managementServer) delDNSAAAARecord(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(http.StatusOK)
 }
 
-// addDNSCAARecord handles an HTTP POST request to add a mock CAA query
-// response record for a host.
+// addDNSCAARecord handles an HTTP POST request to add mock CAA query
+// response records for a host, in addition to the records added before.
 //
 // The POST body is expected to have two non-empty parameters:
-// "host" - the hostname that when queried should return the mocked CAA record.
-// "policies" - an array of CAA policy objects. Each policy object is expected
-// to have two non-empty keys, "tag" and "value".
+// "host" - the hostname that when queried should return the mocked CAA records.
+// "policies" - an array of CAA record objects. Each record object is expected
+// to have two non-empty keys, "tag" and "value", and may have the keys "flag"
+// and "parameters", an array of parameters like "validationmethods=dns-01"
+// appended to the value.
 //
 // A successful POST will write http.StatusOK to the client.
 func (srv *managementServer) addDNSCAARecord(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Host     string
-		Policies []challtestsrv.MockCAAPolicy
+		Policies []mockCAARecord
 	}
 	if err := mustParsePOST(&request, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -207,16 +207,16 @@ func (srv *managementServer) addDNSCAARecord(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	srv.challSrv.AddDNSCAARecord(request.Host, request.Policies)
+	srv.dnsSrv.AddCAARecords(request.Host, request.Policies)
 	srv.log.Printf("Added response for DNS CAA queries to %q", request.Host)
 	w.WriteHeader(http.StatusOK)
 }
 
-// delDNSCAARecord handles an HTTP POST request to delete an existing mock CAA
-// policy record for a host.
+// delDNSCAARecord handles an HTTP POST request to delete the existing mock CAA
+// records for a host.
 //
 // The POST body is expected to have one non-empty parameter:
-// "host" - the hostname to remove the mock CAA policy for.
+// "host" - the hostname to remove the mock CAA records for.
 //
 // A successful POST will write http.StatusOK to the client.
 func (srv *managementServer) delDNSCAARecord(w http.ResponseWriter, r *http.Request) {
@@ -234,7 +234,7 @@ func (srv *managementServer) delDNSCAARecord(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	srv.challSrv.DeleteDNSCAARecord(request.Host)
+	srv.dnsSrv.DeleteCAARecords(request.Host)
 	srv.log.Printf("Removed response for DNS CAA queries to %q", request.Host)
 	w.WriteHeader(http.StatusOK)
 }
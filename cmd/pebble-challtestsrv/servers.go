@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/letsencrypt/challtestsrv"
+	"github.com/miekg/dns"
+)
+
+// challengeServer is a challenge response or mock DNS server run by the
+// command. The challtestsrv package provides the challenge responses, mock
+// DNS data and request history, but the command runs the servers so that it
+// can add behavior in front of the handlers of the package.
+type challengeServer interface {
+	ListenAndServe() error
+	Shutdown() error
+}
+
+// challHTTPServer is an HTTP-01, HTTPS HTTP-01 or TLS-ALPN-01 challenge
+// server. It serves HTTPS if it has a TLSConfig.
+type challHTTPServer struct {
+	*http.Server
+}
+
+func (c challHTTPServer) ListenAndServe() error {
+	var err error
+	if c.Server.TLSConfig != nil {
+		// The certificate is in the TLSConfig
+		err = c.Server.ListenAndServeTLS("", "")
+	} else {
+		err = c.Server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c challHTTPServer) Shutdown() error {
+	return c.Server.Shutdown(context.Background())
+}
+
+// httpOneServer creates an HTTP-01 challenge server with the given handler,
+// or an HTTPS HTTP-01 challenge server useful for redirect targets if it has a
+// TLS configuration.
+func httpOneServer(address string, handler http.Handler, tlsConfig *tls.Config) challengeServer {
+	srv := &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		TLSConfig:    tlsConfig,
+	}
+	srv.SetKeepAlivesEnabled(false)
+	return challHTTPServer{srv}
+}
+
+// tlsALPNOneServer creates a TLS-ALPN-01 challenge server answering
+// handshakes with the certificates of the given function.
+func tlsALPNOneServer(
+	address string,
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) challengeServer {
+	srv := &http.Server{
+		Addr:         address,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		TLSConfig: &tls.Config{
+			NextProtos:     []string{challtestsrv.ACMETLS1Protocol},
+			GetCertificate: getCertificate,
+		},
+	}
+	srv.SetKeepAlivesEnabled(false)
+	return challHTTPServer{srv}
+}
+
+// dnsOneServers creates a UDP and a TCP DNS-01 challenge server answering
+// queries with the given handler.
+func dnsOneServers(address string, handler dns.Handler) []challengeServer {
+	var servers []challengeServer
+	for _, network := range []string{"udp", "tcp"} {
+		servers = append(servers, &dns.Server{
+			Addr:         address,
+			Net:          network,
+			Handler:      handler,
+			ReadTimeout:  time.Second,
+			WriteTimeout: time.Second,
+		})
+	}
+	return servers
+}
+
+// selfSignedCertificate generates a self-signed certificate for localhost,
+// 127.0.0.1 and ::1 with the given common name.
+func selfSignedCertificate(commonName string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// The SVCB and HTTPS record types of RFC 9460, which the miekg/dns package
+// doesn't define. Their answers are encoded as RFC 3597 unknown records.
+const (
+	typeSVCB  uint16 = 64
+	typeHTTPS uint16 = 65
+)
+
+// svcbTypeNames are the names of the SVCB and HTTPS record types.
+var svcbTypeNames = map[uint16]string{
+	typeSVCB:  "SVCB",
+	typeHTTPS: "HTTPS",
+}
+
+// The SvcParamKeys of the service parameters of mock SVCB and HTTPS records,
+// see RFC 9460 Section 14.3.2
+const (
+	svcParamALPN          uint16 = 1
+	svcParamNoDefaultALPN uint16 = 2
+	svcParamPort          uint16 = 3
+	svcParamIPv4Hint      uint16 = 4
+	svcParamIPv6Hint      uint16 = 6
+)
+
+// mockSVCBRecord holds the data of a mock SVCB or HTTPS record. See
+// https://www.rfc-editor.org/rfc/rfc9460
+type mockSVCBRecord struct {
+	// SvcPriority of the record, 0 for AliasMode
+	Priority uint16
+	// TargetName of the record, "." if empty
+	Target string
+	// Application protocols of the "alpn" parameter, e.g. "h2"
+	ALPN []string
+	// Whether the "no-default-alpn" parameter is present
+	NoDefaultALPN bool
+	// Port of the "port" parameter, none if zero
+	Port uint16
+	// IPv4 addresses of the "ipv4hint" parameter
+	IPv4Hint []string
+	// IPv6 addresses of the "ipv6hint" parameter
+	IPv6Hint []string
+}
+
+// rdata returns the wire format RDATA of a mock SVCB or HTTPS record.
+func (rec mockSVCBRecord) rdata() ([]byte, error) {
+	target := rec.Target
+	if target == "" {
+		target = "."
+	}
+	name := make([]byte, 256)
+	nameLen, err := dns.PackDomainName(dns.Fqdn(target), name, 0, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %s", rec.Target, err)
+	}
+
+	rdata := binary.BigEndian.AppendUint16(nil, rec.Priority)
+	rdata = append(rdata, name[:nameLen]...)
+	// The parameters must be in increasing order of their keys
+	param := func(key uint16, value []byte) {
+		rdata = binary.BigEndian.AppendUint16(rdata, key)
+		rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(value)))
+		rdata = append(rdata, value...)
+	}
+	if len(rec.ALPN) > 0 {
+		var value []byte
+		for _, proto := range rec.ALPN {
+			if proto == "" || len(proto) > 255 {
+				return nil, fmt.Errorf("invalid alpn protocol %q", proto)
+			}
+			value = append(value, byte(len(proto)))
+			value = append(value, proto...)
+		}
+		param(svcParamALPN, value)
+	}
+	if rec.NoDefaultALPN {
+		param(svcParamNoDefaultALPN, nil)
+	}
+	if rec.Port != 0 {
+		param(svcParamPort, binary.BigEndian.AppendUint16(nil, rec.Port))
+	}
+	if len(rec.IPv4Hint) > 0 {
+		var value []byte
+		for _, addr := range rec.IPv4Hint {
+			ip := net.ParseIP(addr).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ipv4hint address %q", addr)
+			}
+			value = append(value, ip...)
+		}
+		param(svcParamIPv4Hint, value)
+	}
+	if len(rec.IPv6Hint) > 0 {
+		var value []byte
+		for _, addr := range rec.IPv6Hint {
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("invalid ipv6hint address %q", addr)
+			}
+			value = append(value, ip.To16()...)
+		}
+		param(svcParamIPv6Hint, value)
+	}
+	return rdata, nil
+}
+
+// AddSVCBRecords adds mock records of a record type, SVCB or HTTPS, for the
+// given host, replacing any records of the type already added for the host.
+// An error is returned if a record can't be encoded.
+func (s *dnsServer) AddSVCBRecords(rrtype uint16, host string, records []mockSVCBRecord) error {
+	for _, rec := range records {
+		if _, err := rec.rdata(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.svcbRecords[rrtype][dns.Fqdn(host)] = records
+	return nil
+}
+
+// DeleteSVCBRecords deletes the mock records of a record type, SVCB or HTTPS,
+// for the given host.
+func (s *dnsServer) DeleteSVCBRecords(rrtype uint16, host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.svcbRecords[rrtype], dns.Fqdn(host))
+}
+
+// GetSVCBRecords returns the mock records of a record type, SVCB or HTTPS, for
+// the given host.
+func (s *dnsServer) GetSVCBRecords(rrtype uint16, host string) []mockSVCBRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.svcbRecords[rrtype][dns.Fqdn(host)]
+}
+
+// svcbAnswers creates SVCB or HTTPS RRs for the given question using the mock
+// records. If there are no mock records of the question type for the hostname
+// no RRs will be returned.
+func (s *dnsServer) svcbAnswers(q dns.Question) []dns.RR {
+	var records []dns.RR
+	for _, rec := range s.GetSVCBRecords(q.Qtype, q.Name) {
+		rdata, err := rec.rdata()
+		if err != nil {
+			continue
+		}
+		records = append(records, &dns.RFC3597{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: q.Qtype,
+				Class:  dns.ClassINET,
+			},
+			Rdata: hex.EncodeToString(rdata),
+		})
+	}
+	return records
+}
+
+// addDNSSVCBRecord returns a handler for HTTP POST requests to add mock query
+// response records of a record type, SVCB or HTTPS, for a host.
+//
+// The POST body is expected to have two non-empty parameters:
+// "host" - the hostname that when queried should return the mocked records.
+// "records" - an array of record objects. Each record object may have the
+// keys "priority", "target", "alpn", "noDefaultALPN", "port", "ipv4hint" and
+// "ipv6hint".
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) addDNSSVCBRecord(rrtype uint16) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Host    string
+			Records []mockSVCBRecord
+		}
+		if err := mustParsePOST(&request, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// If the request has no host or no records it's a bad request
+		if request.Host == "" || len(request.Records) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := srv.dnsSrv.AddSVCBRecords(rrtype, request.Host, request.Records); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.log.Printf("Added response for DNS %s queries to %q",
+			svcbTypeNames[rrtype], request.Host)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// delDNSSVCBRecord returns a handler for HTTP POST requests to delete the
+// existing mock records of a record type, SVCB or HTTPS, for a host.
+//
+// The POST body is expected to have one non-empty parameter:
+// "host" - the hostname to remove the mock records for.
+//
+// A successful POST will write http.StatusOK to the client.
+func (srv *managementServer) delDNSSVCBRecord(rrtype uint16) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Host string
+		}
+		if err := mustParsePOST(&request, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// If the request has an empty host it's a bad request
+		if request.Host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		srv.dnsSrv.DeleteSVCBRecords(rrtype, request.Host)
+		srv.log.Printf("Removed response for DNS %s queries to %q",
+			svcbTypeNames[rrtype], request.Host)
+		w.WriteHeader(http.StatusOK)
+	}
+}
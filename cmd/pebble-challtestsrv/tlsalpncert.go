@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/letsencrypt/challtestsrv"
+)
+
+// The key types of TLS-ALPN-01 challenge response certificates
+const (
+	tlsALPNKeyECDSA   = "ecdsa"
+	tlsALPNKeyRSA     = "rsa"
+	tlsALPNKeyEd25519 = "ed25519"
+)
+
+// The deliberately wrong acmeIdentifier extensions of TLS-ALPN-01 challenge
+// response certificates
+const (
+	// The certificate has no acmeIdentifier extension
+	acmeIdentifierMissing = "missing"
+	// The extension isn't marked critical
+	acmeIdentifierNonCritical = "noncritical"
+	// The extension has the hash of a different key authorization
+	acmeIdentifierWrong = "wrong"
+	// The extension value is the bare hash, not a DER encoded OCTET STRING
+	acmeIdentifierMalformed = "malformed"
+)
+
+// tlsALPNCertOptions are the options of the TLS-ALPN-01 challenge response
+// certificate of a host. The zero value is a correct certificate with an ECDSA
+// P-256 key.
+type tlsALPNCertOptions struct {
+	// The key type, "ecdsa" (P-256), "rsa" (2048 bits) or "ed25519"
+	KeyType string
+	// DNS names in the SAN extension in addition to the host
+	ExtraNames []string
+	// A deliberately wrong acmeIdentifier extension, "missing",
+	// "noncritical", "wrong" or "malformed", or empty for a correct one
+	ACMEIdentifier string
+}
+
+// validate returns an error if the options have an unknown key type or
+// acmeIdentifier extension, or an empty extra name.
+func (o tlsALPNCertOptions) validate() error {
+	switch o.KeyType {
+	case "", tlsALPNKeyECDSA, tlsALPNKeyRSA, tlsALPNKeyEd25519:
+	default:
+		return fmt.Errorf("unknown key type %q", o.KeyType)
+	}
+	for _, name := range o.ExtraNames {
+		if name == "" {
+			return fmt.Errorf("extra names must not be empty")
+		}
+	}
+	switch o.ACMEIdentifier {
+	case "", acmeIdentifierMissing, acmeIdentifierNonCritical, acmeIdentifierWrong, acmeIdentifierMalformed:
+	default:
+		return fmt.Errorf("unknown acmeIdentifier %q", o.ACMEIdentifier)
+	}
+	return nil
+}
+
+// tlsALPNCerts creates the TLS-ALPN-01 challenge response certificates for
+// the key authorizations of a challenge server, with the certificate options
+// of their hosts.
+type tlsALPNCerts struct {
+	challSrv *challtestsrv.ChallSrv
+
+	mu sync.Mutex
+	// A map of host to the options of its certificate
+	options map[string]tlsALPNCertOptions
+	// A map of key type to the key of the certificates, generated when it is
+	// first used
+	keys map[string]crypto.Signer
+}
+
+func newTLSALPNCerts(challSrv *challtestsrv.ChallSrv) *tlsALPNCerts {
+	return &tlsALPNCerts{
+		challSrv: challSrv,
+		options:  make(map[string]tlsALPNCertOptions),
+		keys:     make(map[string]crypto.Signer),
+	}
+}
+
+// SetOptions sets the certificate options of a host. The zero value options
+// remove the options set before.
+func (c *tlsALPNCerts) SetOptions(host string, options tlsALPNCertOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if options.KeyType == "" && len(options.ExtraNames) == 0 && options.ACMEIdentifier == "" {
+		delete(c.options, host)
+		return
+	}
+	c.options[host] = options
+}
+
+// key returns the key of a key type, generating it if it's the first use.
+func (c *tlsALPNCerts) key(keyType string) (crypto.Signer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[keyType]; ok {
+		return key, nil
+	}
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case tlsALPNKeyRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case tlsALPNKeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.keys[keyType] = key
+	return key, nil
+}
+
+// getCertificate returns the TLS-ALPN-01 challenge response certificate for
+// the server name of a TLS hello like the challtestsrv package does, with the
+// certificate options of the server name.
+func (c *tlsALPNCerts) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != challtestsrv.ACMETLS1Protocol {
+		return nil, fmt.Errorf(
+			"ALPN failed, ClientHelloInfo.SupportedProtos: %s",
+			hello.SupportedProtos)
+	}
+
+	ka, found := c.challSrv.GetTLSALPNChallenge(hello.ServerName)
+	if !found {
+		return nil, fmt.Errorf("unknown ClientHelloInfo.ServerName: %s", hello.ServerName)
+	}
+	c.mu.Lock()
+	options := c.options[hello.ServerName]
+	c.mu.Unlock()
+
+	key, err := c.key(options.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating challenge certificate key: %s", err)
+	}
+
+	if options.ACMEIdentifier == acmeIdentifierWrong {
+		ka += ".wrong"
+	}
+	kaHash := sha256.Sum256([]byte(ka))
+	extValue, err := asn1.Marshal(kaHash[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling hash OCTET STRING: %s", err)
+	}
+	ext := pkix.Extension{
+		Id:       challtestsrv.IDPeAcmeIdentifier,
+		Critical: options.ACMEIdentifier != acmeIdentifierNonCritical,
+		Value:    extValue,
+	}
+	if options.ACMEIdentifier == acmeIdentifierMalformed {
+		ext.Value = kaHash[:]
+	}
+	certTmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1729),
+		DNSNames:     append([]string{hello.ServerName}, options.ExtraNames...),
+	}
+	if options.ACMEIdentifier != acmeIdentifierMissing {
+		certTmpl.ExtraExtensions = []pkix.Extension{ext}
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &certTmpl, &certTmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating challenge certificate: %s", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{certBytes},
+		PrivateKey:  key,
+	}, nil
+}
@@ -10,12 +10,20 @@ import "net/http"
 // "content" - the key authorization value to use to construct the TLS-ALPN-01
 // challenge response certificate.
 //
+// The POST body may also have the certificate options of the host:
+// "keyType" - the key type of the certificate, "ecdsa" (default), "rsa" or
+// "ed25519".
+// "extraNames" - DNS names to add to the SAN extension in addition to the host.
+// "acmeIdentifier" - a deliberately wrong acmeIdentifier extension, "missing",
+// "noncritical", "wrong" or "malformed".
+//
 // A successful POST will write http.StatusOK to the client.
 func (srv *managementServer) addTLSALPN01(w http.ResponseWriter, r *http.Request) {
 	// Unmarshal the request body JSON as a request object
 	var request struct {
 		Host    string
 		Content string
+		tlsALPNCertOptions
 	}
 	if err := mustParsePOST(&request, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -27,9 +35,14 @@ func (srv *managementServer) addTLSALPN01(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := request.tlsALPNCertOptions.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Add the TLS-ALPN-01 challenge to the challenge server
 	srv.challSrv.AddTLSALPNChallenge(request.Host, request.Content)
+	srv.tlsALPNCerts.SetOptions(request.Host, request.tlsALPNCertOptions)
 	srv.log.Printf("Added TLS-ALPN-01 challenge for host %q - key auth %q\n",
 		request.Host, request.Content)
 	w.WriteHeader(http.StatusOK)
@@ -60,6 +73,7 @@ func (srv *managementServer) delTLSALPN01(w http.ResponseWriter, r *http.Request
 
 	// Delete the TLS-ALPN-01 challenge for the given host from the challenge server
 	srv.challSrv.DeleteTLSALPNChallenge(request.Host)
+	srv.tlsALPNCerts.SetOptions(request.Host, tlsALPNCertOptions{})
 	srv.log.Printf("Removed TLS-ALPN-01 challenge for host %q\n", request.Host)
 	w.WriteHeader(http.StatusOK)
 }
@@ -1,32 +1,83 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"math"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble"
 	"github.com/letsencrypt/pebble/cmd"
-	"github.com/letsencrypt/pebble/db"
-	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/random"
 	"github.com/letsencrypt/pebble/wfe"
 )
 
-type config struct {
-	Pebble struct {
-		ListenAddress           string
-		ManagementListenAddress string
-		HTTPPort                int
-		TLSPort                 int
-		Certificate             string
-		PrivateKey              string
-		OCSPResponderURL        string
-		// Require External Account Binding for "newAccount" requests
-		ExternalAccountBindingRequired bool
-		ExternalAccountMACKeys         map[string]string
+// defaultShutdownGracePeriod is how long Pebble waits for requests in flight
+// to complete when it is terminated, unless configured otherwise.
+const defaultShutdownGracePeriod = 10 * time.Second
+
+// envAliases are the environment variables read by earlier versions of
+// Pebble, which override the config like the variables they were replaced by.
+var envAliases = []cmd.EnvAlias{
+	{Name: "PEBBLE_ALTERNATE_ROOTS", Replacement: "PEBBLE_ALTERNATEROOTS", Convert: envInt(0, math.MaxInt32)},
+	{Name: "PEBBLE_CHAIN_LENGTH", Replacement: "PEBBLE_CHAINLENGTH", Convert: envInt(0, math.MaxInt32)},
+	{Name: "PEBBLE_VA_NOSLEEP", Replacement: "PEBBLE_NOSLEEP", Convert: envFlag},
+	{Name: "PEBBLE_VA_SLEEPTIME", Replacement: "PEBBLE_SLEEPTIME", Convert: envInt(1, math.MaxInt32)},
+	{Name: "PEBBLE_VA_ALWAYS_VALID", Replacement: "PEBBLE_ALWAYSVALID", Convert: envFlag},
+	{Name: "PEBBLE_WFE_NONCEREJECT", Replacement: "PEBBLE_NONCES_REJECTPERCENT", Convert: envNonceReject},
+	{Name: "PEBBLE_AUTHZREUSE", Replacement: "PEBBLE_AUTHZREUSEPOLICY_PERCENT", Convert: envInt(0, 100)},
+	{Name: "PEBBLE_WFE_ORDERS_PER_PAGE", Replacement: "PEBBLE_ORDERSPERPAGE", Convert: envInt(1, math.MaxInt32)},
+}
+
+// envFlag converts the value of a deprecated boolean environment variable,
+// which was only true if it was 1 or true.
+func envFlag(value string) (string, bool) {
+	switch value {
+	case "1", "true", "True", "TRUE":
+		return "true", true
 	}
+	return "", false
+}
+
+// envInt returns a converter of a deprecated integer environment variable,
+// which was ignored unless it was between min and max.
+func envInt(min, max int) func(string) (string, bool) {
+	return func(value string) (string, bool) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return "", false
+		}
+		return strconv.Itoa(n), true
+	}
+}
+
+// envNonceReject converts the value of PEBBLE_WFE_NONCEREJECT, which was
+// clipped to 0 when negative and to 99 when above 100.
+func envNonceReject(value string) (string, bool) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return "", false
+	}
+	if n < 0 {
+		n = 0
+	} else if n > 100 {
+		n = 99
+	}
+	return strconv.Itoa(n), true
+}
+
+type config struct {
+	Pebble pebble.Config
+	// Isolated ACME directories keyed by name, each with the settings of
+	// the pebble object overridden by its own
+	Tenants map[string]json.RawMessage
 }
 
 func main() {
@@ -41,7 +92,15 @@ func main() {
 	resolverAddress := flag.String(
 		"dnsserver",
 		"",
-		"Define a custom DNS server address (ex: 192.168.0.56:5053 or 8.8.8.8:53).")
+		"Define a custom DNS server address (ex: 192.168.0.56:5053, 8.8.8.8:53, tls://1.1.1.1:853 or https://1.1.1.1/dns-query), or a comma separated list of addresses to fail over to in order.")
+	seed := flag.Int64(
+		"seed",
+		0,
+		"Derive all randomness from a non-zero seed to make test runs reproducible")
+	fixturesFile := flag.String(
+		"fixtures",
+		"",
+		"File path to a JSON, YAML or TOML file of accounts, EAB keys, authorizations and certificates to load before serving")
 	flag.Parse()
 	if *configFile == "" {
 		flag.Usage()
@@ -52,61 +111,145 @@ func main() {
 	logger := log.New(os.Stdout, "Pebble ", log.LstdFlags)
 	logger.Printf("Starting Pebble ACME server")
 
+	if *seed != 0 {
+		random.Seed(*seed)
+		logger.Printf("Deterministic mode: deriving all randomness from seed %d", *seed)
+	}
+
+	load := func() (pebble.Config, map[string]pebble.Config, error) {
+		return loadConfig(*configFile, *strictMode, *resolverAddress, logger)
+	}
+	config, tenants, err := load()
+	cmd.FailOnError(err, "Loading config")
+	if *fixturesFile != "" {
+		var fixtures wfe.Fixtures
+		err = cmd.ReadConfigFile(*fixturesFile, &fixtures)
+		cmd.FailOnError(err, "Reading fixtures")
+		config.Fixtures = &fixtures
+	}
+
+	server, err := pebble.NewServer(config)
+	cmd.FailOnError(err, "Creating Pebble server")
+	for name, tenantConfig := range tenants {
+		err = server.AddTenant(name, tenantConfig)
+		cmd.FailOnError(err, "Adding tenant")
+	}
+	err = server.Start()
+	cmd.FailOnError(err, "Starting Pebble server")
+	go func() {
+		err := server.Wait()
+		cmd.FailOnError(err, "Serving requests")
+	}()
+
+	gracePeriod := defaultShutdownGracePeriod
+	if config.ShutdownGracePeriod > 0 {
+		gracePeriod = time.Duration(config.ShutdownGracePeriod) * time.Second
+	}
+
+	// Reload the config on SIGHUP, shut down gracefully on SIGTERM and SIGINT
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			logger.Printf("Caught SIGHUP, reloading %s", *configFile)
+			reload(server, load, logger)
+			continue
+		}
+
+		logger.Printf("Caught %s, waiting up to %s for requests in flight to complete",
+			cmd.SignalName(sig), gracePeriod)
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		err := server.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			logger.Printf("Error shutting down: %s", err)
+		}
+		logger.Printf("Exiting")
+		os.Exit(0)
+	}
+}
+
+// loadConfig reads the config file, applies the environment variable
+// overrides and the command line flags, and returns the config of the server
+// and of its tenants.
+func loadConfig(
+	filename string,
+	strict bool,
+	dnsServer string,
+	logger *log.Logger) (pebble.Config, map[string]pebble.Config, error) {
 	var c config
-	err := cmd.ReadConfigFile(*configFile, &c)
-	cmd.FailOnError(err, "Reading JSON config file into config structure")
-
-	alternateRoots := 0
-	alternateRootsVal := os.Getenv("PEBBLE_ALTERNATE_ROOTS")
-	if val, err := strconv.ParseInt(alternateRootsVal, 10, 0); err == nil && val >= 0 {
-		alternateRoots = int(val)
-	}
-
-	chainLength := 1
-	if val, err := strconv.ParseInt(os.Getenv("PEBBLE_CHAIN_LENGTH"), 10, 0); err == nil && val >= 0 {
-		chainLength = int(val)
-	}
-
-	db := db.NewMemoryStore()
-	ca := ca.New(logger, db, c.Pebble.OCSPResponderURL, alternateRoots, chainLength)
-	va := va.New(logger, c.Pebble.HTTPPort, c.Pebble.TLSPort, *strictMode, *resolverAddress)
-
-	for keyID, key := range c.Pebble.ExternalAccountMACKeys {
-		err := db.AddExternalAccountKeyByID(keyID, key)
-		cmd.FailOnError(err, "Failed to add key to external account bindings")
-	}
-
-	wfeImpl := wfe.New(logger, db, va, ca, *strictMode, c.Pebble.ExternalAccountBindingRequired)
-	muxHandler := wfeImpl.Handler()
-
-	if c.Pebble.ManagementListenAddress != "" {
-		go func() {
-			adminHandler := wfeImpl.ManagementHandler()
-			err = http.ListenAndServeTLS(
-				c.Pebble.ManagementListenAddress,
-				c.Pebble.Certificate,
-				c.Pebble.PrivateKey,
-				adminHandler)
-			cmd.FailOnError(err, "Calling ListenAndServeTLS() for admin interface")
-		}()
-		logger.Printf("Management interface listening on: %s\n", c.Pebble.ManagementListenAddress)
-		logger.Printf("Root CA certificate available at: https://%s%s0",
-			c.Pebble.ManagementListenAddress, wfe.RootCertPath)
-		for i := 0; i < alternateRoots; i++ {
-			logger.Printf("Alternate (%d) root CA certificate available at: https://%s%s%d",
-				i+1, c.Pebble.ManagementListenAddress, wfe.RootCertPath, i+1)
+	if err := cmd.ReadConfigFile(filename, &c); err != nil {
+		return pebble.Config{}, nil, fmt.Errorf("reading config file into config structure: %s", err)
+	}
+	if err := cmd.ApplyEnvOverrides("PEBBLE", &c.Pebble, envAliases, logger); err != nil {
+		return pebble.Config{}, nil, fmt.Errorf("applying environment variable overrides: %s", err)
+	}
+
+	if strict {
+		c.Pebble.Strict.Enabled = true
+	}
+	c.Pebble.DNSServer = dnsServer
+	c.Pebble.Logger = logger
+
+	tenants := make(map[string]pebble.Config)
+	for name, tenant := range c.Tenants {
+		tenantConfig, err := newTenantConfig(c.Pebble, tenant)
+		if err != nil {
+			return pebble.Config{}, nil, fmt.Errorf("reading config of tenant %q: %s", name, err)
 		}
-	} else {
-		logger.Print("Management interface is disabled")
-	}
-
-	logger.Printf("Listening on: %s\n", c.Pebble.ListenAddress)
-	logger.Printf("ACME directory available at: https://%s%s",
-		c.Pebble.ListenAddress, wfe.DirectoryPath)
-	err = http.ListenAndServeTLS(
-		c.Pebble.ListenAddress,
-		c.Pebble.Certificate,
-		c.Pebble.PrivateKey,
-		muxHandler)
-	cmd.FailOnError(err, "Calling ListenAndServeTLS()")
+		tenants[name] = tenantConfig
+	}
+	return c.Pebble, tenants, nil
+}
+
+// reload reloads the config of the server and its tenants. An invalid config
+// is logged, the server keeps running.
+func reload(
+	server *pebble.Server,
+	load func() (pebble.Config, map[string]pebble.Config, error),
+	logger *log.Logger) {
+	config, tenants, err := load()
+	if err != nil {
+		logger.Printf("Error reloading config: %s", err)
+		return
+	}
+	if err := server.Reload(config); err != nil {
+		logger.Printf("Error reloading config: %s", err)
+		return
+	}
+	for name, tenantConfig := range tenants {
+		tenant := server.Tenant(name)
+		if tenant == nil {
+			logger.Printf("Ignoring new tenant %q, adding tenants requires a restart", name)
+			continue
+		}
+		if err := tenant.Reload(tenantConfig); err != nil {
+			logger.Printf("Error reloading config of tenant %q: %s", name, err)
+		}
+	}
+}
+
+// newTenantConfig returns the config of a tenant: the config of the pebble
+// object without its listen addresses, overridden by the tenant's settings.
+// Objects of the tenant's settings are merged with those of the pebble object.
+func newTenantConfig(base pebble.Config, tenant json.RawMessage) (pebble.Config, error) {
+	base.ListenAddress = ""
+	base.ManagementListenAddress = ""
+	base.ListenAddresses = nil
+	base.ManagementListenAddresses = nil
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return pebble.Config{}, err
+	}
+
+	var config pebble.Config
+	if err := json.Unmarshal(baseJSON, &config); err != nil {
+		return pebble.Config{}, err
+	}
+	if err := cmd.UnmarshalConfig(tenant, &config); err != nil {
+		return pebble.Config{}, err
+	}
+	config.DNSServer = base.DNSServer
+	config.Logger = base.Logger
+	return config, nil
 }
@@ -1 +1,58 @@
 package main
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/letsencrypt/pebble"
+	"github.com/letsencrypt/pebble/cmd"
+)
+
+func TestEnvAliases(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		check func(pebble.Config) bool
+	}{
+		{"PEBBLE_ALTERNATE_ROOTS", "2", func(c pebble.Config) bool { return c.AlternateRoots == 2 }},
+		{"PEBBLE_ALTERNATE_ROOTS", "-1", func(c pebble.Config) bool { return c.AlternateRoots == 0 }},
+		{"PEBBLE_CHAIN_LENGTH", "3", func(c pebble.Config) bool { return c.ChainLength == 3 }},
+		{"PEBBLE_VA_NOSLEEP", "1", func(c pebble.Config) bool { return c.NoSleep }},
+		{"PEBBLE_VA_NOSLEEP", "TRUE", func(c pebble.Config) bool { return c.NoSleep }},
+		{"PEBBLE_VA_NOSLEEP", "0", func(c pebble.Config) bool { return !c.NoSleep }},
+		{"PEBBLE_VA_NOSLEEP", "yes", func(c pebble.Config) bool { return !c.NoSleep }},
+		{"PEBBLE_VA_SLEEPTIME", "3", func(c pebble.Config) bool { return c.SleepTime == 3 }},
+		{"PEBBLE_VA_SLEEPTIME", "0", func(c pebble.Config) bool { return c.SleepTime == 0 }},
+		{"PEBBLE_VA_ALWAYS_VALID", "true", func(c pebble.Config) bool { return c.AlwaysValid }},
+		{"PEBBLE_WFE_NONCEREJECT", "0", func(c pebble.Config) bool {
+			return c.Nonces.RejectPercent != nil && *c.Nonces.RejectPercent == 0
+		}},
+		{"PEBBLE_WFE_NONCEREJECT", "150", func(c pebble.Config) bool {
+			return c.Nonces.RejectPercent != nil && *c.Nonces.RejectPercent == 99
+		}},
+		{"PEBBLE_WFE_NONCEREJECT", "-5", func(c pebble.Config) bool {
+			return c.Nonces.RejectPercent != nil && *c.Nonces.RejectPercent == 0
+		}},
+		{"PEBBLE_WFE_NONCEREJECT", "many", func(c pebble.Config) bool { return c.Nonces.RejectPercent == nil }},
+		{"PEBBLE_AUTHZREUSE", "100", func(c pebble.Config) bool {
+			return c.AuthzReusePolicy.Percent != nil && *c.AuthzReusePolicy.Percent == 100
+		}},
+		{"PEBBLE_AUTHZREUSE", "many", func(c pebble.Config) bool { return c.AuthzReusePolicy.Percent == nil }},
+		{"PEBBLE_WFE_ORDERS_PER_PAGE", "15", func(c pebble.Config) bool { return c.OrdersPerPage == 15 }},
+		{"PEBBLE_WFE_ORDERS_PER_PAGE", "0", func(c pebble.Config) bool { return c.OrdersPerPage == 0 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name+"="+tc.value, func(t *testing.T) {
+			t.Setenv(tc.name, tc.value)
+			var config pebble.Config
+			err := cmd.ApplyEnvOverrides("PEBBLE", &config, envAliases, log.New(io.Discard, "", 0))
+			if err != nil {
+				t.Fatalf("ApplyEnvOverrides returned error: %s", err)
+			}
+			if !tc.check(config) {
+				t.Errorf("%s=%s set unexpected config %+v", tc.name, tc.value, config)
+			}
+		})
+	}
+}
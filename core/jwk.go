@@ -0,0 +1,57 @@
+package core
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/letsencrypt/pebble/acme"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// KeyThumbprint returns the RFC 7638 SHA-256 thumbprint of a JWK. Unlike
+// jose.JSONWebKey's Thumbprint it supports ML-DSA keys.
+func KeyThumbprint(key *jose.JSONWebKey) ([]byte, error) {
+	if input, ok := mldsaThumbprintInput(key); ok {
+		digest := sha256.Sum256([]byte(input))
+		return digest[:], nil
+	}
+	return key.Thumbprint(crypto.SHA256)
+}
+
+// MarshalJWK returns the JSON form of a JWK, supporting ML-DSA keys.
+func MarshalJWK(key *jose.JSONWebKey) ([]byte, error) {
+	if key == nil {
+		return []byte("null"), nil
+	}
+	if data, ok, err := marshalMLDSAJWK(key); ok {
+		return data, err
+	}
+	return json.Marshal(key)
+}
+
+// UnmarshalJWK parses the JSON form of a JWK, supporting ML-DSA keys.
+func UnmarshalJWK(data []byte) (*jose.JSONWebKey, error) {
+	if key, ok, err := unmarshalMLDSAJWK(data); ok {
+		return key, err
+	}
+	var key jose.JSONWebKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// MarshalJSON marshals the account with its key in the JSON form of
+// MarshalJWK.
+func (acct Account) MarshalJSON() ([]byte, error) {
+	key, err := MarshalJWK(acct.Key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		acme.Account
+		Key json.RawMessage `json:"key"`
+	}{acct.Account, key})
+}
@@ -0,0 +1,98 @@
+//go:build go1.27
+
+package core
+
+import (
+	"crypto"
+	"crypto/mldsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// MLDSASupported is true if this build of Pebble supports ML-DSA keys, which
+// requires Go 1.27 or later.
+const MLDSASupported = true
+
+// MLDSAAlgorithms are the JWS algorithms of ML-DSA keys.
+var MLDSAAlgorithms = []string{"ML-DSA-44", "ML-DSA-65", "ML-DSA-87"}
+
+var mldsaParameters = map[string]mldsa.Parameters{
+	"ML-DSA-44": mldsa.MLDSA44(),
+	"ML-DSA-65": mldsa.MLDSA65(),
+	"ML-DSA-87": mldsa.MLDSA87(),
+}
+
+// MLDSAAlgorithm returns the JWS algorithm of an ML-DSA public key, e.g.
+// "ML-DSA-44", or "" if the key isn't an ML-DSA key.
+func MLDSAAlgorithm(key crypto.PublicKey) string {
+	if k, ok := key.(*mldsa.PublicKey); ok {
+		return k.Parameters().String()
+	}
+	return ""
+}
+
+// VerifyMLDSA verifies an ML-DSA signature of a message with an empty
+// context.
+func VerifyMLDSA(key crypto.PublicKey, message, signature []byte) error {
+	k, ok := key.(*mldsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("not an ML-DSA key: %T", key)
+	}
+	return mldsa.Verify(k, message, signature, nil)
+}
+
+// akpJWK is the JSON form of an ML-DSA public key, a JWK of the "AKP" key type
+// of draft-ietf-cose-dilithium.
+type akpJWK struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Pub string `json:"pub"`
+}
+
+func marshalMLDSAJWK(key *jose.JSONWebKey) ([]byte, bool, error) {
+	k, ok := key.Key.(*mldsa.PublicKey)
+	if !ok {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(akpJWK{
+		Kty: "AKP",
+		Alg: k.Parameters().String(),
+		Pub: base64.RawURLEncoding.EncodeToString(k.Bytes()),
+	})
+	return data, true, err
+}
+
+func unmarshalMLDSAJWK(data []byte) (*jose.JSONWebKey, bool, error) {
+	var jwk akpJWK
+	if err := json.Unmarshal(data, &jwk); err != nil || jwk.Kty != "AKP" {
+		return nil, false, nil
+	}
+	params, ok := mldsaParameters[jwk.Alg]
+	if !ok {
+		return nil, true, fmt.Errorf("unknown AKP algorithm %q", jwk.Alg)
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(jwk.Pub)
+	if err != nil {
+		return nil, true, errors.New("invalid AKP public key encoding")
+	}
+	k, err := mldsa.NewPublicKey(params, pub)
+	if err != nil {
+		return nil, true, err
+	}
+	return &jose.JSONWebKey{Key: k, Algorithm: jwk.Alg}, true, nil
+}
+
+// mldsaThumbprintInput returns the RFC 7638 thumbprint input of an ML-DSA
+// key, which has the required members "alg", "kty" and "pub".
+func mldsaThumbprintInput(key *jose.JSONWebKey) (string, bool) {
+	k, ok := key.Key.(*mldsa.PublicKey)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(`{"alg":%q,"kty":"AKP","pub":%q}`,
+		k.Parameters().String(), base64.RawURLEncoding.EncodeToString(k.Bytes())), true
+}
@@ -0,0 +1,41 @@
+//go:build !go1.27
+
+package core
+
+import (
+	"crypto"
+	"errors"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// MLDSASupported is true if this build of Pebble supports ML-DSA keys, which
+// requires Go 1.27 or later.
+const MLDSASupported = false
+
+// MLDSAAlgorithms are the JWS algorithms of ML-DSA keys.
+var MLDSAAlgorithms []string
+
+// MLDSAAlgorithm returns the JWS algorithm of an ML-DSA public key, e.g.
+// "ML-DSA-44", or "" if the key isn't an ML-DSA key.
+func MLDSAAlgorithm(key crypto.PublicKey) string {
+	return ""
+}
+
+// VerifyMLDSA verifies an ML-DSA signature of a message with an empty
+// context.
+func VerifyMLDSA(key crypto.PublicKey, message, signature []byte) error {
+	return errors.New("ML-DSA requires Pebble built with Go 1.27 or later")
+}
+
+func marshalMLDSAJWK(key *jose.JSONWebKey) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+func unmarshalMLDSAJWK(data []byte) (*jose.JSONWebKey, bool, error) {
+	return nil, false, nil
+}
+
+func mldsaThumbprintInput(key *jose.JSONWebKey) (string, bool) {
+	return "", false
+}
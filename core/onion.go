@@ -0,0 +1,63 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// OnionSuffix is the special-use TLD of Tor onion services.
+	OnionSuffix = ".onion"
+
+	onionV3Version    = 3
+	onionV3AddressLen = 56
+)
+
+// IsOnionName returns true if the DNS name is in the .onion special-use TLD.
+func IsOnionName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), OnionSuffix)
+}
+
+// OnionPublicKey returns the ed25519 public key of the Tor v3 onion service
+// a DNS name belongs to. The name may be a subdomain of the onion service's
+// address. An error is returned if the name is not a valid v3 onion name.
+//
+// A v3 onion address is the base32 encoding of the service's public key,
+// a two byte checksum and a version byte, as described in the Tor rendezvous
+// specification (rend-spec-v3.txt, Section 6).
+func OnionPublicKey(name string) (ed25519.PublicKey, error) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, OnionSuffix) {
+		return nil, errors.New("name is not a .onion name")
+	}
+	labels := strings.Split(strings.TrimSuffix(name, OnionSuffix), ".")
+	address := labels[len(labels)-1]
+	if len(address) != onionV3AddressLen {
+		return nil, errors.New("name is not a v3 onion address")
+	}
+
+	decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(address))
+	if err != nil {
+		return nil, errors.New("onion address is not valid base32")
+	}
+	pubKey := decoded[:ed25519.PublicKeySize]
+	checksum := decoded[ed25519.PublicKeySize : ed25519.PublicKeySize+2]
+	version := decoded[ed25519.PublicKeySize+2]
+	if version != onionV3Version {
+		return nil, errors.New("onion address has an unsupported version")
+	}
+
+	h := sha3.New256()
+	_, _ = h.Write([]byte(".onion checksum"))
+	_, _ = h.Write(pubKey)
+	_, _ = h.Write([]byte{version})
+	expected := h.Sum(nil)[:2]
+	if checksum[0] != expected[0] || checksum[1] != expected[1] {
+		return nil, errors.New("onion address has an invalid checksum")
+	}
+	return ed25519.PublicKey(pubKey), nil
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"unicode/utf16"
+)
+
+var (
+	oidPKCS12CertBag       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	// Java only trusts the certificates of PKCS#12 trust stores that have
+	// this attribute, listing the key usages they are trusted for
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+)
+
+// pkcs12MACIterations is the iteration count of the key derivation of the MAC
+// of PKCS#12 files
+const pkcs12MACIterations = 2048
+
+// pkcs12PFX is a PKCS#12 PFX (RFC 7292 Section 4).
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData
+}
+
+// pkcs12ContentInfo is a PKCS#7 ContentInfo of the data content type.
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,tag:0"`
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkcs12AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12AlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue
+}
+
+// pkcs12SafeBag is a SafeBag (RFC 7292 Section 4.2) of a certificate.
+type pkcs12SafeBag struct {
+	BagID      asn1.ObjectIdentifier
+	BagValue   pkcs12CertBag     `asn1:"explicit,tag:0"`
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	CertID    asn1.ObjectIdentifier
+	CertValue []byte `asn1:"explicit,tag:0"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// PKCS12TrustStore returns the certificates as a DER encoded PKCS#12 trust
+// store: unencrypted certificate bags named after the subjects and serials of
+// the certificates, marked as trusted for Java, and a SHA-256 HMAC keyed with
+// the password.
+func PKCS12TrustStore(certs []*Certificate, password string) ([]byte, error) {
+	trusted, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+	if err != nil {
+		return nil, err
+	}
+	bags := make([]pkcs12SafeBag, len(certs))
+	for i, cert := range certs {
+		name := cert.Cert.Subject.CommonName
+		if cert.ID != "" {
+			name += " " + cert.ID
+		}
+		bags[i] = pkcs12SafeBag{
+			BagID: oidPKCS12CertBag,
+			BagValue: pkcs12CertBag{
+				CertID:    oidX509Certificate,
+				CertValue: cert.DER,
+			},
+			Attributes: []pkcs12Attribute{
+				{
+					ID: oidFriendlyName,
+					Values: []asn1.RawValue{{
+						Class: asn1.ClassUniversal,
+						Tag:   asn1.TagBMPString,
+						Bytes: bmpString(name),
+					}},
+				},
+				{
+					ID:     oidJavaTrustedKeyUsage,
+					Values: []asn1.RawValue{{FullBytes: trusted}},
+				},
+			},
+		}
+	}
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]pkcs12ContentInfo{{ContentType: oidPKCS7Data, Content: safeContents}})
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, pkcs12MACKey(salt, append(bmpString(password), 0, 0), pkcs12MACIterations))
+	_, _ = mac.Write(authSafe)
+	return asn1.Marshal(pkcs12PFX{
+		Version:  3,
+		AuthSafe: pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: authSafe},
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkcs12AlgorithmIdentifier{
+					Algorithm:  oidSHA256,
+					Parameters: asn1.NullRawValue,
+				},
+				Digest: mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12MACIterations,
+		},
+	})
+}
+
+// bmpString returns the bytes of a string as a BMPString, which PKCS#12
+// passwords are encoded as with a null terminator.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+// pkcs12MACKey derives the SHA-256 HMAC key of a PKCS#12 file from the salt
+// and the null terminated BMPString password with the key derivation of RFC 7292 Appendix B.2.
+// The key is as long as a SHA-256 hash, so a single round of hashing is
+// needed.
+func pkcs12MACKey(salt, password []byte, iterations int) []byte {
+	const v = sha256.BlockSize
+	// The diversifier of MAC keys is 3
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = 3
+	}
+	input := append(d, fill(salt, v)...)
+	input = append(input, fill(password, v)...)
+
+	sum := sha256.Sum256(input)
+	for i := 1; i < iterations; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum[:]
+}
+
+// fill repeats the bytes up to the next multiple of the block size, or
+// returns nothing if there are none.
+func fill(b []byte, blockSize int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	n := blockSize * ((len(b) + blockSize - 1) / blockSize)
+	filled := make([]byte, n)
+	for i := range filled {
+		filled[i] = b[i%len(b)]
+	}
+	return filled
+}
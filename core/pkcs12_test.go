@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// opensslTrustStore is a trust store of a single certificate created with
+//
+//	openssl pkcs12 -export -nokeys -in cert.pem -passout pass:changeit \
+//	  -macalg sha256 -maciter -iter 2048 -certpbe NONE
+const opensslTrustStore = `
+MIICKQIBAzCCAd8GCSqGSIb3DQEHAaCCAdAEggHMMIIByDCCAcQGCSqGSIb3DQEHAaCCAbUEggGx
+MIIBrTCCAakGCyqGSIb3DQEMCgEDoIIBmDCCAZQGCiqGSIb3DQEJFgGgggGEBIIBgDCCAXwwggEh
+oAMCAQICFATTv+idQEyVJby79tnInudExA1fMAoGCCqGSM49BAMCMBMxETAPBgNVBAMMCHAxMiB0
+ZXN0MB4XDTI2MTAxNTExMzU1MloXDTM2MTAxMjExMzU1MlowEzERMA8GA1UEAwwIcDEyIHRlc3Qw
+WTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAR3DCORTN72JsUQhxDR6sZtFKp3IBFwlM74Ccjf65wb
+t9On85alPrzhC/86CzEVlJ48/spJmR/ymB/DkeSrJLBGo1MwUTAdBgNVHQ4EFgQUYaWegaVuH8/3
+EFD6B9pbHQhKLbYwHwYDVR0jBBgwFoAUYaWegaVuH8/3EFD6B9pbHQhKLbYwDwYDVR0TAQH/BAUw
+AwEB/zAKBggqhkjOPQQDAgNJADBGAiEAx2K4Fc29WjOc37DIs2Yjlq6LkfiAlhFWnYcvcfFzsdYC
+IQDAntaH4Qnusy3MQ73WYWe+P3w68RCDrXFo30BiFMkSTzBBMDEwDQYJYIZIAWUDBAIBBQAEIKQ6
+luhrtys11V44x5d0cT+wWIU+Lat2tsJ1LkhLHgc7BAhloZ7ZlybsCgICCAA=`
+
+func TestBMPString(t *testing.T) {
+	testCases := []struct {
+		s    string
+		want []byte
+	}{
+		{"", nil},
+		{"ab", []byte{0, 'a', 0, 'b'}},
+		{"é", []byte{0, 0xe9}},
+		{"\U0001F512", []byte{0xd8, 0x3d, 0xdd, 0x12}},
+	}
+	for _, tc := range testCases {
+		if got := bmpString(tc.s); !bytes.Equal(got, tc.want) {
+			t.Errorf("bmpString(%q) = %x, want %x", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestFill(t *testing.T) {
+	testCases := []struct {
+		b         string
+		blockSize int
+		want      string
+	}{
+		{"", 4, ""},
+		{"ab", 4, "abab"},
+		{"abc", 4, "abca"},
+		{"abcd", 4, "abcd"},
+		{"abcde", 4, "abcdeabc"},
+	}
+	for _, tc := range testCases {
+		if got := fill([]byte(tc.b), tc.blockSize); string(got) != tc.want {
+			t.Errorf("fill(%q, %d) = %q, want %q", tc.b, tc.blockSize, got, tc.want)
+		}
+	}
+}
+
+// verifyPKCS12MAC parses a PKCS#12 file and checks its SHA-256 MAC.
+func verifyPKCS12MAC(t *testing.T, der []byte, password string) pkcs12PFX {
+	t.Helper()
+	var pfx pkcs12PFX
+	if rest, err := asn1.Unmarshal(der, &pfx); err != nil || len(rest) > 0 {
+		t.Fatalf("parsing PKCS#12 file: %v (%d trailing bytes)", err, len(rest))
+	}
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) {
+		t.Fatalf("MAC algorithm is %s, want SHA-256", pfx.MacData.Mac.Algorithm.Algorithm)
+	}
+	key := pkcs12MACKey(pfx.MacData.MacSalt, append(bmpString(password), 0, 0), pfx.MacData.Iterations)
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(pfx.AuthSafe.Content)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Errorf("MAC with password %q doesn't match", password)
+	}
+	return pfx
+}
+
+func TestPKCS12MACKey(t *testing.T) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(opensslTrustStore))
+	if err != nil {
+		t.Fatalf("decoding trust store: %s", err)
+	}
+	verifyPKCS12MAC(t, der, "changeit")
+}
+
+func TestPKCS12TrustStore(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Pebble Test Root"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %s", err)
+	}
+	certs := []*Certificate{
+		{ID: "1a2b", Cert: cert, DER: der},
+		{Cert: cert, DER: der},
+	}
+
+	for _, password := range []string{"changeit", "", "pässwort"} {
+		store, err := PKCS12TrustStore(certs, password)
+		if err != nil {
+			t.Fatalf("PKCS12TrustStore returned error: %s", err)
+		}
+		pfx := verifyPKCS12MAC(t, store, password)
+
+		var authSafe []pkcs12ContentInfo
+		if _, err := asn1.Unmarshal(pfx.AuthSafe.Content, &authSafe); err != nil || len(authSafe) != 1 {
+			t.Fatalf("parsing authenticated safe: %v (%d contents)", err, len(authSafe))
+		}
+		var bags []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(authSafe[0].Content, &bags); err != nil {
+			t.Fatalf("parsing safe contents: %s", err)
+		}
+		if len(bags) != len(certs) {
+			t.Fatalf("trust store has %d bags, want %d", len(bags), len(certs))
+		}
+		for i, bag := range bags {
+			if !bytes.Equal(bag.BagValue.CertValue, der) {
+				t.Errorf("bag %d doesn't contain the certificate", i)
+			}
+			// The attributes are a DER set, sorted by their encoding
+			var name []byte
+			trusted := false
+			for _, attribute := range bag.Attributes {
+				switch {
+				case attribute.ID.Equal(oidFriendlyName):
+					name = attribute.Values[0].Bytes
+				case attribute.ID.Equal(oidJavaTrustedKeyUsage):
+					trusted = true
+				}
+			}
+			wantName := bmpString(strings.TrimSpace("Pebble Test Root " + certs[i].ID))
+			if !bytes.Equal(name, wantName) || !trusted {
+				t.Errorf("bag %d has attributes %+v, want friendly name %x and trusted key usage",
+					i, bag.Attributes, wantName)
+			}
+		}
+	}
+}
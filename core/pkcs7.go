@@ -0,0 +1,65 @@
+package core
+
+import (
+	"encoding/asn1"
+)
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// pkcs7ContentInfo is a PKCS#7 ContentInfo (RFC 2315 Section 7).
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// pkcs7SignedData is a PKCS#7 SignedData (RFC 2315 Section 9.1) without
+// signers, which only conveys certificates.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+// PKCS7 returns the certificate and the intermediates of the issuer chain
+// with the given index as a DER encoded degenerate PKCS#7 SignedData
+// structure, leaf certificate first, like the application/pkcs7-mime
+// certificate format of RFC 8555 Section 7.4.2.
+func (c Certificate) PKCS7(no int) ([]byte, error) {
+	certs := append([]byte{}, c.DER...)
+	if 0 <= no && no < len(c.IssuerChains) {
+		for _, cert := range c.IssuerChains[no] {
+			certs = append(certs, cert.DER...)
+		}
+	}
+
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      certs,
+		},
+		SignerInfos: emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      signedData,
+		},
+	})
+}
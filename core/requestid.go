@@ -0,0 +1,32 @@
+package core
+
+import "context"
+
+// requestIDKey is the context key of the ID of the ACME request that work is
+// done for.
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of an ACME request, which
+// the VA and CA include in the log lines of the work they do for it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the ACME request of the context, or "" if
+// there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestLogPrefix returns the prefix of log lines of work done for the ACME
+// request of the context, e.g. "[3f9c2a1b7d4e5f60] ", or "" if there is none.
+func RequestLogPrefix(ctx context.Context) string {
+	if id := RequestID(ctx); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// Orders, authorizations and challenges are shared between the WFE, the VA
+// and the CA, which update them concurrently. Rather than locking them, code
+// reads them through snapshots and changes them through their Update method.
+
+// Snapshot returns a copy of the order taken while holding its read lock.
+// Changes to the order are not visible in the copy, and changes to the copy
+// are not applied to the order. The authorizations, certificate, CSR and
+// delegation the order refers to are shared with the order.
+func (o *Order) Snapshot() *Order {
+	o.RLock()
+	defer o.RUnlock()
+	return &Order{
+		Order:                     copyACMEOrder(o.Order),
+		ID:                        o.ID,
+		AccountID:                 o.AccountID,
+		Names:                     append([]string(nil), o.Names...),
+		ParsedCSR:                 o.ParsedCSR,
+		ExpiresDate:               o.ExpiresDate,
+		AuthorizationObjects:      append([]*Authorization(nil), o.AuthorizationObjects...),
+		BeganProcessing:           o.BeganProcessing,
+		CertificateObject:         o.CertificateObject,
+		AutoRenewalStart:          o.AutoRenewalStart,
+		AutoRenewalEnd:            o.AutoRenewalEnd,
+		AutoRenewalLifetime:       o.AutoRenewalLifetime,
+		AutoRenewalLifetimeAdjust: o.AutoRenewalLifetimeAdjust,
+		NotBeforeDate:             o.NotBeforeDate,
+		NotAfterDate:              o.NotAfterDate,
+		Canceled:                  o.Canceled,
+		DelegationObject:          o.DelegationObject,
+	}
+}
+
+// Update applies changes to the order while holding its write lock.
+func (o *Order) Update(update func(*Order)) {
+	o.Lock()
+	defer o.Unlock()
+	update(o)
+}
+
+func copyACMEOrder(order acme.Order) acme.Order {
+	order.Identifiers = append([]acme.Identifier(nil), order.Identifiers...)
+	order.Authorizations = append([]string(nil), order.Authorizations...)
+	if order.AutoRenewal != nil {
+		autoRenewal := *order.AutoRenewal
+		order.AutoRenewal = &autoRenewal
+	}
+	return order
+}
+
+// Snapshot returns a copy of the authorization taken while holding its read
+// lock. The order and challenges the authorization refers to are shared with
+// the authorization.
+func (authz *Authorization) Snapshot() *Authorization {
+	authz.RLock()
+	defer authz.RUnlock()
+	authorization := authz.Authorization
+	authorization.Challenges = append([]acme.Challenge(nil), authz.Authorization.Challenges...)
+	return &Authorization{
+		Authorization: authorization,
+		ID:            authz.ID,
+		URL:           authz.URL,
+		ExpiresDate:   authz.ExpiresDate,
+		Order:         authz.Order,
+		Challenges:    append([]*Challenge(nil), authz.Challenges...),
+	}
+}
+
+// Update applies changes to the authorization while holding its write lock.
+func (authz *Authorization) Update(update func(*Authorization)) {
+	authz.Lock()
+	defer authz.Unlock()
+	update(authz)
+}
+
+// Snapshot returns a copy of the challenge taken while holding its read lock.
+// The authorization the challenge belongs to is shared with the challenge.
+func (ch *Challenge) Snapshot() *Challenge {
+	ch.RLock()
+	defer ch.RUnlock()
+	return &Challenge{
+		Challenge:     ch.Challenge,
+		ID:            ch.ID,
+		Authz:         ch.Authz,
+		ValidatedDate: ch.ValidatedDate,
+		OnionCSR:      ch.OnionCSR,
+	}
+}
+
+// Update applies changes to the challenge while holding its write lock.
+func (ch *Challenge) Update(update func(*Challenge)) {
+	ch.Lock()
+	defer ch.Unlock()
+	update(ch)
+}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"bytes"
-	"crypto"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
@@ -25,13 +24,38 @@ type Order struct {
 	AuthorizationObjects []*Authorization
 	BeganProcessing      bool
 	CertificateObject    *Certificate
+	// The parsed "auto-renewal" schedule of an ACME STAR recurrent order. These
+	// are only meaningful when the embedded acme.Order's AutoRenewal is not nil.
+	AutoRenewalStart          time.Time
+	AutoRenewalEnd            time.Time
+	AutoRenewalLifetime       time.Duration
+	AutoRenewalLifetimeAdjust time.Duration
+	// The parsed notBefore and notAfter of the certificate requested by the
+	// order. These are zero if the order didn't request a validity period.
+	NotBeforeDate time.Time
+	NotAfterDate  time.Time
+	// Canceled is set when the client cancels a recurrent order.
+	Canceled bool
+	// DelegationObject is the STAR delegation the order was placed for, if any.
+	DelegationObject *Delegation
 }
 
-func (o *Order) GetStatus() (string, error) {
+// IsRecurrent returns true if the order is an ACME STAR recurrent order. The
+// caller is expected to hold the order lock.
+func (o *Order) IsRecurrent() bool {
+	return o.AutoRenewal != nil
+}
+
+func (o *Order) GetStatus(now time.Time) (string, error) {
 	// Lock the order for reading
 	o.RLock()
 	defer o.RUnlock()
 
+	// A canceled recurrent order is always canceled
+	if o.Canceled {
+		return acme.StatusCanceled, nil
+	}
+
 	// If the order has an error set, the status is invalid
 	if o.Error != nil {
 		return acme.StatusInvalid, nil
@@ -48,7 +72,7 @@ func (o *Order) GetStatus() (string, error) {
 
 		authzStatuses[authzStatus]++
 
-		if authzExpires.Before(time.Now()) {
+		if authzExpires.Before(now) {
 			authzStatuses[acme.StatusExpired]++
 		}
 	}
@@ -107,10 +131,69 @@ func (o *Order) GetStatus() (string, error) {
 	return "", fmt.Errorf("Order is in an unknown state")
 }
 
+// ExternalAccountKey is an External Account Binding HMAC key, which newAccount
+// requests bind accounts to by its ID. A disabled key can't bind new accounts.
+type ExternalAccountKey struct {
+	ID       string
+	Key      []byte
+	Disabled bool
+	Policy   ExternalAccountKeyPolicy
+}
+
+// ExternalAccountKeyPolicy scopes an External Account Binding key like
+// commercial CAs scope their EAB credentials. The zero value doesn't limit
+// anything.
+type ExternalAccountKeyPolicy struct {
+	// Domain names the accounts bound to the key may order certificates for,
+	// including their subdomains, and IP addresses. Empty allows all
+	// identifiers.
+	IdentifierSuffixes []string `json:"identifierSuffixes,omitempty"`
+	// Maximum number of accounts the key binds. Zero means no limit.
+	MaxAccounts int `json:"maxAccounts,omitempty"`
+	// Maximum number of certificates issued to the accounts bound to the key.
+	// Zero means no limit.
+	MaxCertificates int `json:"maxCertificates,omitempty"`
+	// Time the key expires at, after which it can't bind accounts and the
+	// accounts bound to it can't place orders. Nil means never.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Delegation is a STAR delegation configuration (RFC 9115) owned by the
+// identifier owner account with the given AccountID.
+type Delegation struct {
+	acme.Delegation
+	ID        string
+	AccountID string
+}
+
+// Profile is a named certificate profile that newOrder requests can select.
+// Zero values select the CA's defaults.
+type Profile struct {
+	Description string
+	// ValidityPeriod is the validity period of leaf certificates in seconds.
+	ValidityPeriod int
+	// ExtKeyUsages and KeyUsages name the extended key usages (e.g.
+	// "serverAuth") and key usages (e.g. "digitalSignature") of leaf
+	// certificates.
+	ExtKeyUsages []string
+	KeyUsages    []string
+	// MustStaple adds the TLS feature extension requiring OCSP stapling.
+	MustStaple bool
+	// CSRMustStaple selects how finalize CSRs requesting the TLS feature
+	// extension are handled: "ignore" (the default), "honor" or "reject".
+	CSRMustStaple string
+	// IncludeSCTs embeds SCTs in leaf certificates if a CT log is configured.
+	IncludeSCTs bool
+}
+
 type Account struct {
 	acme.Account
 	Key *jose.JSONWebKey `json:"key"`
 	ID  string           `json:"-"`
+	// ID of the External Account Binding key the account is bound to, if any
+	ExternalAccountKeyID string `json:"-"`
+	// URL of the terms of service the account agreed to, if any
+	AgreedTermsOfService string `json:"-"`
 }
 
 type Authorization struct {
@@ -129,6 +212,8 @@ type Challenge struct {
 	ID            string
 	Authz         *Authorization
 	ValidatedDate time.Time
+	// OnionCSR is the DER encoded CSR submitted to an onion-csr-01 challenge.
+	OnionCSR []byte
 }
 
 func (ch *Challenge) ExpectedKeyAuthorization(key *jose.JSONWebKey) string {
@@ -136,7 +221,7 @@ func (ch *Challenge) ExpectedKeyAuthorization(key *jose.JSONWebKey) string {
 		panic("ExpectedKeyAuthorization called with nil key")
 	}
 
-	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	thumbprint, err := KeyThumbprint(key)
 	if err != nil {
 		panic("ExpectedKeyAuthorization: " + err.Error())
 	}
@@ -150,6 +235,12 @@ type Certificate struct {
 	DER          []byte
 	IssuerChains [][]*Certificate
 	AccountID    string
+	// Recurrent is true for certificates issued for an ACME STAR recurrent
+	// order.
+	Recurrent bool
+	// AllowGet is true for certificates that may be fetched by unauthenticated
+	// GET requests, e.g. delegated certificates.
+	AllowGet bool
 }
 
 func (c Certificate) PEM() []byte {
@@ -193,8 +284,40 @@ type RevokedCertificate struct {
 	Reason      *uint
 }
 
+// ScheduledRevocation is the time a certificate will be revoked at, like
+// a CA revokes certificates after an incident. Until then its renewalInfo
+// asks for an immediate renewal.
+type ScheduledRevocation struct {
+	At             time.Time `json:"at"`
+	Reason         *uint     `json:"reason,omitempty"`
+	ExplanationURL string    `json:"explanationURL,omitempty"`
+}
+
+// PausedAccount is the identifiers an account is paused for, like Let's
+// Encrypt pauses accounts whose orders keep failing validation. New orders of
+// the account for these identifiers are rejected until the account is unpaused
+// by visiting its unpause URL.
+type PausedAccount struct {
+	Identifiers  []acme.Identifier `json:"identifiers"`
+	UnpauseToken string            `json:"unpauseToken"`
+}
+
+// BlockedDomain is a domain name newOrder requests may not include.
+type BlockedDomain struct {
+	Domain string `json:"domain"`
+	// Subtree blocks the subdomains of the domain too
+	Subtree bool `json:"subtree"`
+}
+
 type ValidationRecord struct {
-	URL         string
-	Error       *acme.ProblemDetails
+	URL string
+	// Transcript describes the requests the validation made, in order, e.g.
+	// one per redirect followed by an HTTP-01 validation
+	Transcript []acme.ValidationRecord
+	Error      *acme.ProblemDetails
+	// Transient reports that the validation failed because connecting to the
+	// challenge host or looking up its DNS records failed, so that it may
+	// succeed when retried
+	Transient   bool
 	ValidatedAt time.Time
 }
@@ -0,0 +1,418 @@
+// Package ct implements a minimal in-memory RFC 6962 Certificate Transparency
+// log. It accepts certificates and precertificates without checking their
+// chains, returns signed certificate timestamps (SCTs) and serves signed tree
+// heads. It is meant to let the CA embed SCTs in issued certificates, not to
+// be a real log.
+package ct
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/clock"
+)
+
+const (
+	// Values of the RFC 6962 LogEntryType enum
+	x509Entry    = 0
+	precertEntry = 1
+
+	// Values of the RFC 6962 SignatureType enum
+	certificateTimestampSignature = 0
+	treeHashSignature             = 1
+
+	// TLS HashAlgorithm sha256 and SignatureAlgorithm ecdsa
+	hashAlgorithmSHA256     = 4
+	signatureAlgorithmECDSA = 3
+)
+
+var (
+	// OIDPrecertificatePoison is the critical extension marking
+	// a precertificate. See RFC 6962 Section 3.1.
+	OIDPrecertificatePoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// OIDSCTList is the extension embedding SCTs in a certificate. See RFC
+	// 6962 Section 3.3.
+	OIDSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// LogConfig configures a mock CT log.
+type LogConfig struct {
+	// Name describes the log.
+	Name string
+	// PrivateKey is the path of a PEM encoded ECDSA P-256 private key. A new
+	// key is generated if it is empty.
+	PrivateKey string
+}
+
+// SCT is a signed certificate timestamp in the JSON format of the
+// add-chain and add-pre-chain responses (RFC 6962 Section 4.1).
+type SCT struct {
+	Version    uint8  `json:"sct_version"`
+	ID         []byte `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions []byte `json:"extensions"`
+	Signature  []byte `json:"signature"`
+}
+
+// Log is a mock RFC 6962 CT log.
+type Log struct {
+	name  string
+	key   *ecdsa.PrivateKey
+	logID [sha256.Size]byte
+	clk   clock.Clock
+
+	sync.Mutex
+	leafHashes [][]byte
+}
+
+// New creates a mock CT log that timestamps entries with clk.
+func New(config LogConfig, clk clock.Clock) (*Log, error) {
+	var key *ecdsa.PrivateKey
+	var err error
+	if config.PrivateKey != "" {
+		key, err = readECPrivateKey(config.PrivateKey)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("CT log keys must be ECDSA P-256 keys")
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Log{
+		name:  config.Name,
+		key:   key,
+		logID: sha256.Sum256(spki),
+		clk:   clk,
+	}, nil
+}
+
+// readECPrivateKey reads a PEM encoded SEC 1 or PKCS#8 ECDSA private key.
+func readECPrivateKey(filename string) (*ecdsa.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM private key found", filename)
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ECDSA private key", filename)
+	}
+	return ecKey, nil
+}
+
+// Name returns the log's description.
+func (l *Log) Name() string {
+	return l.name
+}
+
+// LogID returns the log's ID, the SHA-256 hash of its public key.
+func (l *Log) LogID() []byte {
+	return l.logID[:]
+}
+
+// PublicKey returns the DER encoded SubjectPublicKeyInfo of the log's key.
+func (l *Log) PublicKey() []byte {
+	spki, _ := x509.MarshalPKIXPublicKey(l.key.Public())
+	return spki
+}
+
+// tlsVector appends a TLS variable-length vector with a length prefix of
+// lenBytes bytes to buf.
+func tlsVector(buf *bytes.Buffer, lenBytes int, data []byte) {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(data)))
+	buf.Write(length[8-lenBytes:])
+	buf.Write(data)
+}
+
+// sign returns the TLS encoded DigitallySigned struct over data.
+func (l *Log) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	sig, err := l.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(hashAlgorithmSHA256)
+	buf.WriteByte(signatureAlgorithmECDSA)
+	tlsVector(&buf, 2, sig)
+	return buf.Bytes(), nil
+}
+
+// timestampedEntry returns the TLS encoded timestamp, entry type and signed
+// entry shared by SCT signatures and Merkle tree leaves.
+func timestampedEntry(timestamp uint64, entryType uint16, signedEntry []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, timestamp)
+	_ = binary.Write(&buf, binary.BigEndian, entryType)
+	buf.Write(signedEntry)
+	// No CtExtensions
+	tlsVector(&buf, 2, nil)
+	return buf.Bytes()
+}
+
+// addEntry signs an SCT for an entry and appends the entry to the log.
+func (l *Log) addEntry(entryType uint16, signedEntry []byte) (*SCT, error) {
+	timestamp := uint64(l.clk.Now().UnixNano() / int64(time.Millisecond))
+	entry := timestampedEntry(timestamp, entryType, signedEntry)
+
+	var signed bytes.Buffer
+	signed.WriteByte(0) // sct_version v1
+	signed.WriteByte(certificateTimestampSignature)
+	signed.Write(entry)
+	sig, err := l.sign(signed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// The Merkle tree leaf is a v1 timestamped entry (RFC 6962 Section 3.4)
+	leaf := append([]byte{0, 0}, entry...)
+	leafHash := sha256.Sum256(append([]byte{0}, leaf...))
+	l.Lock()
+	l.leafHashes = append(l.leafHashes, leafHash[:])
+	l.Unlock()
+
+	return &SCT{
+		ID:        l.LogID(),
+		Timestamp: timestamp,
+		Signature: sig,
+	}, nil
+}
+
+// AddChain logs a certificate. chain is the DER encoded certificate followed
+// by its issuers.
+func (l *Log) AddChain(chain [][]byte) (*SCT, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("chain must contain at least one certificate")
+	}
+	if _, err := x509.ParseCertificate(chain[0]); err != nil {
+		return nil, err
+	}
+	var signedEntry bytes.Buffer
+	tlsVector(&signedEntry, 3, chain[0])
+	return l.addEntry(x509Entry, signedEntry.Bytes())
+}
+
+// tbsCertificate is used to remove the poison extension from
+// a precertificate's TBSCertificate.
+type tbsCertificate struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueID           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+// AddPreChain logs a precertificate issued directly by the CA. chain is the
+// DER encoded precertificate followed by its issuer.
+func (l *Log) AddPreChain(chain [][]byte) (*SCT, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("chain must contain the precertificate and its issuer")
+	}
+	precert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := x509.ParseCertificate(chain[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var tbs tbsCertificate
+	if _, err := asn1.Unmarshal(precert.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	var poisoned bool
+	extensions := tbs.Extensions[:0]
+	for _, ext := range tbs.Extensions {
+		if ext.Id.Equal(OIDPrecertificatePoison) {
+			poisoned = true
+			continue
+		}
+		extensions = append(extensions, ext)
+	}
+	if !poisoned {
+		return nil, errors.New("precertificate does not contain the poison extension")
+	}
+	tbs.Raw = nil
+	tbs.Extensions = extensions
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	var signedEntry bytes.Buffer
+	signedEntry.Write(issuerKeyHash[:])
+	tlsVector(&signedEntry, 3, tbsDER)
+	return l.addEntry(precertEntry, signedEntry.Bytes())
+}
+
+// merkleTreeHash computes the RFC 6962 Section 2.1 Merkle Tree Hash of a list
+// of leaf hashes.
+func merkleTreeHash(leafHashes [][]byte) []byte {
+	switch len(leafHashes) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leafHashes[0]
+	}
+	// k is the largest power of two smaller than the number of leaves
+	k := 1
+	for k<<1 < len(leafHashes) {
+		k <<= 1
+	}
+	node := append([]byte{1}, merkleTreeHash(leafHashes[:k])...)
+	node = append(node, merkleTreeHash(leafHashes[k:])...)
+	hash := sha256.Sum256(node)
+	return hash[:]
+}
+
+// signedTreeHead is the JSON format of a get-sth response (RFC 6962 Section
+// 4.3).
+type signedTreeHead struct {
+	TreeSize          uint64 `json:"tree_size"`
+	Timestamp         uint64 `json:"timestamp"`
+	SHA256RootHash    []byte `json:"sha256_root_hash"`
+	TreeHeadSignature []byte `json:"tree_head_signature"`
+}
+
+// sth returns a signed tree head covering all logged entries.
+func (l *Log) sth() (*signedTreeHead, error) {
+	l.Lock()
+	leafHashes := append([][]byte(nil), l.leafHashes...)
+	l.Unlock()
+
+	sth := &signedTreeHead{
+		TreeSize:       uint64(len(leafHashes)),
+		Timestamp:      uint64(l.clk.Now().UnixNano() / int64(time.Millisecond)),
+		SHA256RootHash: merkleTreeHash(leafHashes),
+	}
+	var signed bytes.Buffer
+	signed.WriteByte(0) // version v1
+	signed.WriteByte(treeHashSignature)
+	_ = binary.Write(&signed, binary.BigEndian, sth.Timestamp)
+	_ = binary.Write(&signed, binary.BigEndian, sth.TreeSize)
+	signed.Write(sth.SHA256RootHash)
+	sig, err := l.sign(signed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sth.TreeHeadSignature = sig
+	return sth, nil
+}
+
+// Serialize returns the TLS encoding of an SCT used in SCT lists (RFC 6962
+// Section 3.2).
+func (sct *SCT) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(sct.Version)
+	buf.Write(sct.ID)
+	_ = binary.Write(&buf, binary.BigEndian, sct.Timestamp)
+	tlsVector(&buf, 2, sct.Extensions)
+	buf.Write(sct.Signature)
+	return buf.Bytes()
+}
+
+// SCTListExtension returns the certificate extension embedding the given SCTs.
+func SCTListExtension(scts []*SCT) (pkix.Extension, error) {
+	var list bytes.Buffer
+	for _, sct := range scts {
+		tlsVector(&list, 2, sct.Serialize())
+	}
+	var buf bytes.Buffer
+	tlsVector(&buf, 2, list.Bytes())
+	value, err := asn1.Marshal(buf.Bytes())
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{
+		Id:    OIDSCTList,
+		Value: value,
+	}, nil
+}
+
+// ServeHTTP serves the log's add-chain, add-pre-chain and get-sth RFC 6962
+// endpoints below "/ct/v1/". The request path must be relative to the log's
+// base URL.
+func (l *Log) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	var result interface{}
+	var err error
+	switch strings.TrimPrefix(request.URL.Path, "/") {
+	case "ct/v1/add-chain", "ct/v1/add-pre-chain":
+		if request.Method != http.MethodPost {
+			response.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Chain [][]byte `json:"chain"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(request.URL.Path, "add-chain") {
+			result, err = l.AddChain(req.Chain)
+		} else {
+			result, err = l.AddPreChain(req.Chain)
+		}
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "ct/v1/get-sth":
+		result, err = l.sth()
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(response).Encode(result)
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ParseBlockedDomains parses a list of blocked domains with one entry per
+// line. An entry starting with a period, e.g. ".example.com", blocks the
+// domain and its subdomains, other entries only the name itself. Empty lines
+// and lines starting with "#" are ignored.
+func ParseBlockedDomains(r io.Reader) ([]core.BlockedDomain, error) {
+	var domains []core.BlockedDomain
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		domain := core.BlockedDomain{
+			Domain:  strings.TrimPrefix(entry, "."),
+			Subtree: strings.HasPrefix(entry, "."),
+		}
+		if err := checkBlockedDomain(domain); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, scanner.Err()
+}
+
+// normalizeDomain returns the lower case form of a domain name without a
+// trailing period.
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// checkBlockedDomain returns an error if a blocked domain has no domain name.
+func checkBlockedDomain(domain core.BlockedDomain) error {
+	if normalizeDomain(domain.Domain) == "" {
+		return fmt.Errorf("blocked domains must not be empty")
+	}
+	return nil
+}
+
+// AddBlockedDomain blocks a domain name, replacing an earlier block of the
+// same name.
+func (m *MemoryStore) AddBlockedDomain(domain core.BlockedDomain) error {
+	if err := checkBlockedDomain(domain); err != nil {
+		return err
+	}
+	m.blockedDomainsMu.Lock()
+	defer m.blockedDomainsMu.Unlock()
+	m.blockedDomains[normalizeDomain(domain.Domain)] = domain.Subtree
+	return nil
+}
+
+// SetBlockedDomains replaces all blocked domains.
+func (m *MemoryStore) SetBlockedDomains(domains []core.BlockedDomain) error {
+	blocked := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		if err := checkBlockedDomain(domain); err != nil {
+			return err
+		}
+		blocked[normalizeDomain(domain.Domain)] = domain.Subtree
+	}
+	m.blockedDomainsMu.Lock()
+	defer m.blockedDomainsMu.Unlock()
+	m.blockedDomains = blocked
+	return nil
+}
+
+// RemoveBlockedDomain unblocks a domain name, returning whether it was
+// blocked. Subdomains blocked by their own entries stay blocked.
+func (m *MemoryStore) RemoveBlockedDomain(name string) bool {
+	name = normalizeDomain(name)
+	m.blockedDomainsMu.Lock()
+	defer m.blockedDomainsMu.Unlock()
+	_, ok := m.blockedDomains[name]
+	delete(m.blockedDomains, name)
+	return ok
+}
+
+// BlockedDomains returns the blocked domains sorted by name.
+func (m *MemoryStore) BlockedDomains() []core.BlockedDomain {
+	m.blockedDomainsMu.RLock()
+	defer m.blockedDomainsMu.RUnlock()
+	domains := make([]core.BlockedDomain, 0, len(m.blockedDomains))
+	for name, subtree := range m.blockedDomains {
+		domains = append(domains, core.BlockedDomain{Domain: name, Subtree: subtree})
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		return domains[i].Domain < domains[j].Domain
+	})
+	return domains
+}
+
+// IsDomainBlocked returns whether a DNS name, which may be a wildcard name,
+// is blocked by an entry of the name itself or by a subtree block of one of
+// its ancestors.
+func (m *MemoryStore) IsDomainBlocked(name string) bool {
+	name = normalizeDomain(name)
+	m.blockedDomainsMu.RLock()
+	defer m.blockedDomainsMu.RUnlock()
+	if _, ok := m.blockedDomains[name]; ok {
+		return true
+	}
+	for i := strings.Index(name, "."); i >= 0; i = strings.Index(name, ".") {
+		name = name[i+1:]
+		if m.blockedDomains[name] {
+			return true
+		}
+	}
+	return false
+}
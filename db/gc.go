@@ -0,0 +1,93 @@
+package db
+
+import (
+	"time"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// PruneCounts counts the objects pruned from the store.
+type PruneCounts struct {
+	Orders         int `json:"orders"`
+	Authorizations int `json:"authorizations"`
+	Challenges     int `json:"challenges"`
+}
+
+// PruneExpired removes the orders, authorizations and challenges that expired
+// before the given time. Recurrent orders are kept until their end date.
+// Certificates are never pruned.
+func (m *MemoryStore) PruneExpired(before time.Time) PruneCounts {
+	var counts PruneCounts
+	counts.Orders = m.pruneOrders(before)
+	var challenges []*core.Challenge
+	counts.Authorizations, challenges = m.pruneAuthorizations(before)
+	counts.Challenges = m.pruneChallenges(challenges)
+	return counts
+}
+
+// pruneOrders removes the orders that expired before the given time, and
+// returns their number.
+func (m *MemoryStore) pruneOrders(before time.Time) int {
+	m.ordersMu.Lock()
+	defer m.ordersMu.Unlock()
+
+	pruned := 0
+	for id, order := range m.ordersByID {
+		snapshot := order.Snapshot()
+		expires := snapshot.ExpiresDate
+		if snapshot.IsRecurrent() && snapshot.AutoRenewalEnd.After(expires) {
+			expires = snapshot.AutoRenewalEnd
+		}
+		if expires.Before(before) {
+			delete(m.ordersByID, id)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		for accountID, orders := range m.ordersByAccountID {
+			var kept []*core.Order
+			for _, order := range orders {
+				if _, present := m.ordersByID[order.ID]; present {
+					kept = append(kept, order)
+				}
+			}
+			m.ordersByAccountID[accountID] = kept
+		}
+	}
+	return pruned
+}
+
+// pruneAuthorizations removes the authorizations that expired before the
+// given time, and returns their number and their challenges.
+func (m *MemoryStore) pruneAuthorizations(before time.Time) (int, []*core.Challenge) {
+	m.authorizationsMu.Lock()
+	defer m.authorizationsMu.Unlock()
+
+	pruned := 0
+	var challenges []*core.Challenge
+	for id, authz := range m.authorizationsByID {
+		snapshot := authz.Snapshot()
+		if snapshot.ExpiresDate.Before(before) {
+			delete(m.authorizationsByID, id)
+			pruned++
+			challenges = append(challenges, snapshot.Challenges...)
+		}
+	}
+	return pruned, challenges
+}
+
+// pruneChallenges removes the given challenges, and returns the number of
+// challenges removed.
+func (m *MemoryStore) pruneChallenges(challenges []*core.Challenge) int {
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
+
+	pruned := 0
+	for _, chal := range challenges {
+		if _, present := m.challengesByID[chal.ID]; present {
+			delete(m.challengesByID, chal.ID)
+			pruned++
+		}
+	}
+	return pruned
+}
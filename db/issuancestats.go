@@ -0,0 +1,137 @@
+package db
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// IssuanceCounters count the issuance events of an account, of a registered
+// domain or of all accounts.
+type IssuanceCounters struct {
+	OrdersCreated         int `json:"ordersCreated"`
+	AuthorizationsValid   int `json:"authorizationsValid"`
+	AuthorizationsInvalid int `json:"authorizationsInvalid"`
+	CertificatesIssued    int `json:"certificatesIssued"`
+	CertificatesRevoked   int `json:"certificatesRevoked"`
+}
+
+// IssuanceStats are the issuance counters since Pebble started or the
+// counters were reset. The counters of registered domains, e.g.
+// "example.com" for "www.example.com", count each event once even if it
+// includes several names of the domain. IP addresses are counted as their own
+// registered domain.
+type IssuanceStats struct {
+	Total    IssuanceCounters             `json:"total"`
+	Accounts map[string]*IssuanceCounters `json:"accounts"`
+	Domains  map[string]*IssuanceCounters `json:"domains"`
+}
+
+// newIssuanceStats returns issuance statistics with zero counters.
+func newIssuanceStats() IssuanceStats {
+	return IssuanceStats{
+		Accounts: make(map[string]*IssuanceCounters),
+		Domains:  make(map[string]*IssuanceCounters),
+	}
+}
+
+// registeredDomain returns the domain a name is registered under, i.e. the
+// public suffix and one more label, or the name itself if it is an IP address
+// or has no registered domain.
+func registeredDomain(name string) string {
+	name = normalizeDomain(strings.TrimPrefix(name, "*."))
+	if net.ParseIP(name) != nil {
+		return name
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return domain
+	}
+	return name
+}
+
+// countIssuance increments the counters of all accounts, of the account with
+// the given ID, if any, and of the registered domains of the names.
+func (m *MemoryStore) countIssuance(accountID string, names []string, increment func(*IssuanceCounters)) {
+	m.issuanceStatsMu.Lock()
+	defer m.issuanceStatsMu.Unlock()
+
+	increment(&m.issuanceStats.Total)
+	if accountID != "" {
+		counters := m.issuanceStats.Accounts[accountID]
+		if counters == nil {
+			counters = &IssuanceCounters{}
+			m.issuanceStats.Accounts[accountID] = counters
+		}
+		increment(counters)
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		domain := registeredDomain(name)
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		counters := m.issuanceStats.Domains[domain]
+		if counters == nil {
+			counters = &IssuanceCounters{}
+			m.issuanceStats.Domains[domain] = counters
+		}
+		increment(counters)
+	}
+}
+
+// countCertificate increments the counters of the account and the names of a
+// certificate.
+func (m *MemoryStore) countCertificate(cert *core.Certificate, increment func(*IssuanceCounters)) {
+	names := append([]string{}, cert.Cert.DNSNames...)
+	for _, ip := range cert.Cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	m.countIssuance(cert.AccountID, names, increment)
+}
+
+// CountAuthorization counts the outcome of the validation of an
+// authorization, which is valid or invalid.
+func (m *MemoryStore) CountAuthorization(authz *core.Authorization) {
+	snapshot := authz.Snapshot()
+	var accountID string
+	if snapshot.Order != nil {
+		accountID = snapshot.Order.AccountID
+	}
+	names := []string{snapshot.Identifier.Value}
+	switch snapshot.Status {
+	case acme.StatusValid:
+		m.countIssuance(accountID, names, func(c *IssuanceCounters) { c.AuthorizationsValid++ })
+	case acme.StatusInvalid:
+		m.countIssuance(accountID, names, func(c *IssuanceCounters) { c.AuthorizationsInvalid++ })
+	}
+}
+
+// IssuanceStats returns a copy of the issuance counters.
+func (m *MemoryStore) IssuanceStats() IssuanceStats {
+	m.issuanceStatsMu.Lock()
+	defer m.issuanceStatsMu.Unlock()
+
+	stats := newIssuanceStats()
+	stats.Total = m.issuanceStats.Total
+	for accountID, counters := range m.issuanceStats.Accounts {
+		c := *counters
+		stats.Accounts[accountID] = &c
+	}
+	for domain, counters := range m.issuanceStats.Domains {
+		c := *counters
+		stats.Domains[domain] = &c
+	}
+	return stats
+}
+
+// ResetIssuanceStats sets all issuance counters to zero.
+func (m *MemoryStore) ResetIssuanceStats() {
+	m.issuanceStatsMu.Lock()
+	defer m.issuanceStatsMu.Unlock()
+	m.issuanceStats = newIssuanceStats()
+}
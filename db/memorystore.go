@@ -9,14 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"reflect"
+	"sort"
 	"strconv"
 	"sync"
-	"time"
 
 	"gopkg.in/square/go-jose.v2"
 
 	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
 	"github.com/letsencrypt/pebble/core"
 )
 
@@ -33,9 +33,15 @@ func (e ExistingAccountError) Error() string {
 // Pebble keeps all of its various objects (accounts, orders, etc)
 // in-memory, not persisted anywhere. MemoryStore implements this in-memory
 // "database"
+//
+// Each collection of objects has its own lock, so that operations on unrelated
+// collections don't block each other. The orders, authorizations and
+// challenges returned are shared, callers read them through their Snapshot
+// method and change them through their Update method.
 type MemoryStore struct {
-	sync.RWMutex
+	clk clock.Clock
 
+	accountsMu       sync.RWMutex
 	accountIDCounter int
 
 	accountsByID map[string]*core.Account
@@ -44,48 +50,151 @@ type MemoryStore struct {
 	// key bytes.
 	accountsByKeyID map[string]*core.Account
 
+	ordersMu          sync.RWMutex
 	ordersByID        map[string]*core.Order
 	ordersByAccountID map[string][]*core.Order
 
+	authorizationsMu   sync.RWMutex
 	authorizationsByID map[string]*core.Authorization
 
+	challengesMu   sync.RWMutex
 	challengesByID map[string]*core.Challenge
 
+	certificatesMu          sync.RWMutex
 	certificatesByID        map[string]*core.Certificate
 	revokedCertificatesByID map[string]*core.RevokedCertificate
 
-	externalAccountKeysByID map[string][]byte
+	// Indexes of the IDs of certificates and revoked certificates by serial
+	// number (in hex) and by SHA-256 digest of their DER bytes
+	certificateIDsBySerial    map[string]string
+	certificateIDsByDERDigest map[[sha256.Size]byte]string
+
+	externalAccountKeysMu   sync.RWMutex
+	externalAccountKeysByID map[string]*core.ExternalAccountKey
+
+	delegationsMu          sync.RWMutex
+	delegationsByID        map[string]*core.Delegation
+	delegationsByAccountID map[string][]*core.Delegation
+
+	// ARI responses overriding the computed renewal window of a certificate,
+	// keyed by certificate ID
+	ariResponsesMu       sync.RWMutex
+	ariResponsesByCertID map[string]*acme.RenewalInfo
+
+	// Revocations scheduled for certificates, keyed by certificate ID
+	scheduledRevocationsMu       sync.RWMutex
+	scheduledRevocationsByCertID map[string]*core.ScheduledRevocation
+
+	// Keys of certificates revoked for key compromise, which can't be used
+	// for accounts or certificates anymore. Like accounts, keys are indexed
+	// by the hex encoding of the SHA256 sum of their public key bytes.
+	blockedKeysMu   sync.RWMutex
+	blockedKeysByID map[string]bool
+
+	// Identifiers accounts are paused for, keyed by account ID
+	pausedAccountsMu   sync.RWMutex
+	pausedAccountsByID map[string]*core.PausedAccount
+
+	// Domains newOrder requests may not include, mapped to whether their
+	// subdomains are blocked too
+	blockedDomainsMu sync.RWMutex
+	blockedDomains   map[string]bool
+
+	// Counters of orders, authorizations and certificates by account and
+	// registered domain
+	issuanceStatsMu sync.Mutex
+	issuanceStats   IssuanceStats
+
+	// The metrics of the store, nil if it isn't instrumented
+	metrics *storeMetrics
 }
 
-func NewMemoryStore() *MemoryStore {
+func NewMemoryStore(clk clock.Clock) *MemoryStore {
 	return &MemoryStore{
-		accountIDCounter:        1,
-		accountsByID:            make(map[string]*core.Account),
-		accountsByKeyID:         make(map[string]*core.Account),
-		ordersByID:              make(map[string]*core.Order),
-		ordersByAccountID:       make(map[string][]*core.Order),
-		authorizationsByID:      make(map[string]*core.Authorization),
-		challengesByID:          make(map[string]*core.Challenge),
-		certificatesByID:        make(map[string]*core.Certificate),
-		revokedCertificatesByID: make(map[string]*core.RevokedCertificate),
-		externalAccountKeysByID: make(map[string][]byte),
+		clk:                       clk,
+		accountIDCounter:          1,
+		accountsByID:              make(map[string]*core.Account),
+		accountsByKeyID:           make(map[string]*core.Account),
+		ordersByID:                make(map[string]*core.Order),
+		ordersByAccountID:         make(map[string][]*core.Order),
+		authorizationsByID:        make(map[string]*core.Authorization),
+		challengesByID:            make(map[string]*core.Challenge),
+		certificatesByID:          make(map[string]*core.Certificate),
+		revokedCertificatesByID:   make(map[string]*core.RevokedCertificate),
+		certificateIDsBySerial:    make(map[string]string),
+		certificateIDsByDERDigest: make(map[[sha256.Size]byte]string),
+		externalAccountKeysByID:   make(map[string]*core.ExternalAccountKey),
+		delegationsByID:           make(map[string]*core.Delegation),
+		delegationsByAccountID:    make(map[string][]*core.Delegation),
+		ariResponsesByCertID:      make(map[string]*acme.RenewalInfo),
+		blockedKeysByID:           make(map[string]bool),
+		pausedAccountsByID:        make(map[string]*core.PausedAccount),
+		blockedDomains:            make(map[string]bool),
+		issuanceStats:             newIssuanceStats(),
+
+		scheduledRevocationsByCertID: make(map[string]*core.ScheduledRevocation),
 	}
 }
 
+// StoreStats are the numbers of objects in a MemoryStore.
+type StoreStats struct {
+	Accounts            int `json:"accounts"`
+	Orders              int `json:"orders"`
+	Authorizations      int `json:"authorizations"`
+	Challenges          int `json:"challenges"`
+	Certificates        int `json:"certificates"`
+	RevokedCertificates int `json:"revokedCertificates"`
+	ExternalAccountKeys int `json:"externalAccountKeys"`
+}
+
+// Stats returns the numbers of objects in the store.
+func (m *MemoryStore) Stats() StoreStats {
+	var stats StoreStats
+
+	m.accountsMu.RLock()
+	stats.Accounts = len(m.accountsByID)
+	m.accountsMu.RUnlock()
+
+	m.ordersMu.RLock()
+	stats.Orders = len(m.ordersByID)
+	m.ordersMu.RUnlock()
+
+	m.authorizationsMu.RLock()
+	stats.Authorizations = len(m.authorizationsByID)
+	m.authorizationsMu.RUnlock()
+
+	m.challengesMu.RLock()
+	stats.Challenges = len(m.challengesByID)
+	m.challengesMu.RUnlock()
+
+	m.certificatesMu.RLock()
+	stats.Certificates = len(m.certificatesByID)
+	stats.RevokedCertificates = len(m.revokedCertificatesByID)
+	m.certificatesMu.RUnlock()
+
+	m.externalAccountKeysMu.RLock()
+	stats.ExternalAccountKeys = len(m.externalAccountKeysByID)
+	m.externalAccountKeysMu.RUnlock()
+
+	return stats
+}
+
 func (m *MemoryStore) GetAccountByID(id string) *core.Account {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionAccounts)()
+	m.accountsMu.RLock()
+	defer m.accountsMu.RUnlock()
 	return m.accountsByID[id]
 }
 
 func (m *MemoryStore) GetAccountByKey(key crypto.PublicKey) (*core.Account, error) {
+	defer m.observe(operationLookup, collectionAccounts)()
 	keyID, err := keyToID(key)
 	if err != nil {
 		return nil, err
 	}
 
-	m.RLock()
-	defer m.RUnlock()
+	m.accountsMu.RLock()
+	defer m.accountsMu.RUnlock()
 	return m.accountsByKeyID[keyID], nil
 }
 
@@ -93,8 +202,9 @@ func (m *MemoryStore) GetAccountByKey(key crypto.PublicKey) (*core.Account, erro
 // the public key associated to the account does not change. Use ChangeAccountKey
 // to change the account's public key.
 func (m *MemoryStore) UpdateAccountByID(id string, acct *core.Account) error {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationUpdate, collectionAccounts)()
+	m.accountsMu.Lock()
+	defer m.accountsMu.Unlock()
 	if m.accountsByID[id] == nil {
 		return fmt.Errorf("account with ID %q does not exist", id)
 	}
@@ -108,8 +218,9 @@ func (m *MemoryStore) UpdateAccountByID(id string, acct *core.Account) error {
 }
 
 func (m *MemoryStore) AddAccount(acct *core.Account) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionAccounts)()
+	m.accountsMu.Lock()
+	defer m.accountsMu.Unlock()
 
 	acctID := strconv.Itoa(m.accountIDCounter)
 	m.accountIDCounter++
@@ -138,8 +249,9 @@ func (m *MemoryStore) AddAccount(acct *core.Account) (int, error) {
 }
 
 func (m *MemoryStore) ChangeAccountKey(acct *core.Account, newKey *jose.JSONWebKey) error {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationUpdate, collectionAccounts)()
+	m.accountsMu.Lock()
+	defer m.accountsMu.Unlock()
 
 	oldKeyID, err := keyToID(acct.Key)
 	if err != nil {
@@ -163,13 +275,13 @@ func (m *MemoryStore) ChangeAccountKey(acct *core.Account, newKey *jose.JSONWebK
 }
 
 func (m *MemoryStore) AddOrder(order *core.Order) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionOrders)()
+	m.ordersMu.Lock()
+	defer m.ordersMu.Unlock()
 
-	order.RLock()
-	orderID := order.ID
-	accountID := order.AccountID
-	order.RUnlock()
+	snapshot := order.Snapshot()
+	orderID := snapshot.ID
+	accountID := snapshot.AccountID
 	if len(orderID) == 0 {
 		return 0, fmt.Errorf("order must have a non-empty ID to add to MemoryStore")
 	}
@@ -186,39 +298,47 @@ func (m *MemoryStore) AddOrder(order *core.Order) (int, error) {
 	m.ordersByAccountID[accountID] = append(ordersByAccountID, order)
 
 	m.ordersByID[orderID] = order
+
+	names := make([]string, len(snapshot.Identifiers))
+	for i, ident := range snapshot.Identifiers {
+		names[i] = ident.Value
+	}
+	m.countIssuance(accountID, names, func(c *IssuanceCounters) { c.OrdersCreated++ })
 	return len(m.ordersByID), nil
 }
 
 func (m *MemoryStore) GetOrderByID(id string) *core.Order {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionOrders)()
+	m.ordersMu.RLock()
+	defer m.ordersMu.RUnlock()
 
 	if order, ok := m.ordersByID[id]; ok {
-		orderStatus, err := order.GetStatus()
+		orderStatus, err := order.GetStatus(m.clk.Now())
 		if err != nil {
 			panic(err)
 		}
-		order.Lock()
-		defer order.Unlock()
-		order.Status = orderStatus
+		order.Update(func(order *core.Order) {
+			order.Status = orderStatus
+		})
 		return order
 	}
 	return nil
 }
 
 func (m *MemoryStore) GetOrdersByAccountID(accountID string) []*core.Order {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionOrders)()
+	m.ordersMu.RLock()
+	defer m.ordersMu.RUnlock()
 
 	if orders, ok := m.ordersByAccountID[accountID]; ok {
 		for _, order := range orders {
-			orderStatus, err := order.GetStatus()
+			orderStatus, err := order.GetStatus(m.clk.Now())
 			if err != nil {
 				panic(err)
 			}
-			order.Lock()
-			defer order.Unlock()
-			order.Status = orderStatus
+			order.Update(func(order *core.Order) {
+				order.Status = orderStatus
+			})
 		}
 		return orders
 	}
@@ -226,15 +346,14 @@ func (m *MemoryStore) GetOrdersByAccountID(accountID string) []*core.Order {
 }
 
 func (m *MemoryStore) AddAuthorization(authz *core.Authorization) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionAuthorizations)()
+	m.authorizationsMu.Lock()
+	defer m.authorizationsMu.Unlock()
 
-	authz.RLock()
-	authzID := authz.ID
+	authzID := authz.Snapshot().ID
 	if len(authzID) == 0 {
 		return 0, fmt.Errorf("authz must have a non-empty ID to add to MemoryStore")
 	}
-	authz.RUnlock()
 
 	if _, present := m.authorizationsByID[authzID]; present {
 		return 0, fmt.Errorf("authz %q already exists", authzID)
@@ -245,20 +364,42 @@ func (m *MemoryStore) AddAuthorization(authz *core.Authorization) (int, error) {
 }
 
 func (m *MemoryStore) GetAuthorizationByID(id string) *core.Authorization {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionAuthorizations)()
+	m.authorizationsMu.RLock()
+	defer m.authorizationsMu.RUnlock()
 	return m.authorizationsByID[id]
 }
 
 // FindValidAuthorization fetches the first, if any, valid and unexpired authorization for the
 // provided identifier, from the ACME account matching accountID.
 func (m *MemoryStore) FindValidAuthorization(accountID string, identifier acme.Identifier) *core.Authorization {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationScan, collectionAuthorizations)()
+	m.authorizationsMu.RLock()
+	defer m.authorizationsMu.RUnlock()
 	for _, authz := range m.authorizationsByID {
-		if authz.Status == acme.StatusValid && identifier.Equals(authz.Identifier) &&
-			authz.Order != nil && authz.Order.AccountID == accountID &&
-			authz.ExpiresDate.After(time.Now()) {
+		snapshot := authz.Snapshot()
+		if snapshot.Status == acme.StatusValid && identifier.Equals(snapshot.Identifier) &&
+			snapshot.Order != nil && snapshot.Order.AccountID == accountID &&
+			snapshot.ExpiresDate.After(m.clk.Now()) {
+			return authz
+		}
+	}
+	return nil
+}
+
+// FindValidSubdomainAuthorization fetches the first, if any, valid and unexpired
+// authorization for the provided identifier that also authorizes its subdomains
+// (RFC 9444), from the ACME account matching accountID.
+func (m *MemoryStore) FindValidSubdomainAuthorization(accountID string, identifier acme.Identifier) *core.Authorization {
+	defer m.observe(operationScan, collectionAuthorizations)()
+	m.authorizationsMu.RLock()
+	defer m.authorizationsMu.RUnlock()
+	for _, authz := range m.authorizationsByID {
+		snapshot := authz.Snapshot()
+		if snapshot.Status == acme.StatusValid && snapshot.SubdomainAuthAllowed &&
+			identifier.Equals(snapshot.Identifier) &&
+			snapshot.Order != nil && snapshot.Order.AccountID == accountID &&
+			snapshot.ExpiresDate.After(m.clk.Now()) {
 			return authz
 		}
 	}
@@ -266,12 +407,11 @@ func (m *MemoryStore) FindValidAuthorization(accountID string, identifier acme.I
 }
 
 func (m *MemoryStore) AddChallenge(chal *core.Challenge) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionChallenges)()
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
 
-	chal.RLock()
-	chalID := chal.ID
-	chal.RUnlock()
+	chalID := chal.Snapshot().ID
 	if len(chalID) == 0 {
 		return 0, fmt.Errorf("challenge must have a non-empty ID to add to MemoryStore")
 	}
@@ -285,14 +425,16 @@ func (m *MemoryStore) AddChallenge(chal *core.Challenge) (int, error) {
 }
 
 func (m *MemoryStore) GetChallengeByID(id string) *core.Challenge {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionChallenges)()
+	m.challengesMu.RLock()
+	defer m.challengesMu.RUnlock()
 	return m.challengesByID[id]
 }
 
 func (m *MemoryStore) AddCertificate(cert *core.Certificate) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionCertificates)()
+	m.certificatesMu.Lock()
+	defer m.certificatesMu.Unlock()
 
 	certID := cert.ID
 	if len(certID) == 0 {
@@ -307,48 +449,63 @@ func (m *MemoryStore) AddCertificate(cert *core.Certificate) (int, error) {
 	}
 
 	m.certificatesByID[certID] = cert
+	m.indexCertificate(cert)
+	// Only certificates issued to accounts are counted, not CA certificates
+	if !cert.Cert.IsCA {
+		m.countCertificate(cert, func(c *IssuanceCounters) { c.CertificatesIssued++ })
+	}
 	return len(m.certificatesByID), nil
 }
 
+// indexCertificate adds a certificate to the serial number and DER digest
+// indexes. The caller is expected to hold the store lock for writing.
+func (m *MemoryStore) indexCertificate(cert *core.Certificate) {
+	m.certificateIDsBySerial[cert.Cert.SerialNumber.Text(16)] = cert.ID
+	m.certificateIDsByDERDigest[sha256.Sum256(cert.DER)] = cert.ID
+}
+
 func (m *MemoryStore) GetCertificateByID(id string) *core.Certificate {
-	m.RLock()
-	defer m.RUnlock()
+	defer m.observe(operationLookup, collectionCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
 	return m.certificatesByID[id]
 }
 
-// GetCertificateByDER loops over all certificates to find the one that matches the provided DER bytes.
-// This method is linear and it's not optimized to give you a quick response.
+// GetCertificateByDER finds the certificate that matches the provided DER
+// bytes using the DER digest index.
 func (m *MemoryStore) GetCertificateByDER(der []byte) *core.Certificate {
-	m.RLock()
-	defer m.RUnlock()
-	for _, c := range m.certificatesByID {
-		if reflect.DeepEqual(c.DER, der) {
-			return c
-		}
+	defer m.observe(operationLookup, collectionCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+	if id, ok := m.certificateIDsByDERDigest[sha256.Sum256(der)]; ok {
+		return m.certificatesByID[id]
 	}
-
 	return nil
 }
 
-// GetCertificateByDER loops over all revoked certificates to find the one that matches the provided
-// DER bytes. This method is linear and it's not optimized to give you a quick response.
+// GetRevokedCertificateByDER finds the revoked certificate that matches the
+// provided DER bytes using the DER digest index.
 func (m *MemoryStore) GetRevokedCertificateByDER(der []byte) *core.RevokedCertificate {
-	m.RLock()
-	defer m.RUnlock()
-	for _, c := range m.revokedCertificatesByID {
-		if reflect.DeepEqual(c.Certificate.DER, der) {
-			return c
-		}
+	defer m.observe(operationLookup, collectionRevokedCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+	if id, ok := m.certificateIDsByDERDigest[sha256.Sum256(der)]; ok {
+		return m.revokedCertificatesByID[id]
 	}
-
 	return nil
 }
 
 func (m *MemoryStore) RevokeCertificate(cert *core.RevokedCertificate) {
-	m.Lock()
-	defer m.Unlock()
+	defer m.observe(operationAdd, collectionRevokedCertificates)()
+	m.certificatesMu.Lock()
+	defer m.certificatesMu.Unlock()
+	if _, issued := m.certificatesByID[cert.Certificate.ID]; issued && !cert.Certificate.Cert.IsCA {
+		m.countCertificate(cert.Certificate, func(c *IssuanceCounters) { c.CertificatesRevoked++ })
+	}
 	m.revokedCertificatesByID[cert.Certificate.ID] = cert
 	delete(m.certificatesByID, cert.Certificate.ID)
+	// Certificates like intermediates are revoked without having been added
+	m.indexCertificate(cert.Certificate)
 }
 
 /*
@@ -375,32 +532,27 @@ func keyToID(key crypto.PublicKey) (string, error) {
 	}
 }
 
-// GetCertificateBySerial loops over all certificates to find the one that matches the provided
-// serial number. This method is linear and it's not optimized to give you a quick response.
+// GetCertificateBySerial finds the certificate that matches the provided
+// serial number using the serial number index.
 func (m *MemoryStore) GetCertificateBySerial(serialNumber *big.Int) *core.Certificate {
-	m.RLock()
-	defer m.RUnlock()
-	for _, c := range m.certificatesByID {
-		if serialNumber.Cmp(c.Cert.SerialNumber) == 0 {
-			return c
-		}
+	defer m.observe(operationLookup, collectionCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+	if id, ok := m.certificateIDsBySerial[serialNumber.Text(16)]; ok {
+		return m.certificatesByID[id]
 	}
-
 	return nil
 }
 
-// GetRevokedCertificateBySerial loops over all revoked certificates to find the one that matches the
-// provided serial number. This method is linear and it's not optimized to give you a quick
-// response.
+// GetRevokedCertificateBySerial finds the revoked certificate that matches the
+// provided serial number using the serial number index.
 func (m *MemoryStore) GetRevokedCertificateBySerial(serialNumber *big.Int) *core.RevokedCertificate {
-	m.RLock()
-	defer m.RUnlock()
-	for _, c := range m.revokedCertificatesByID {
-		if serialNumber.Cmp(c.Certificate.Cert.SerialNumber) == 0 {
-			return c
-		}
+	defer m.observe(operationLookup, collectionRevokedCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+	if id, ok := m.certificateIDsBySerial[serialNumber.Text(16)]; ok {
+		return m.revokedCertificatesByID[id]
 	}
-
 	return nil
 }
 
@@ -408,6 +560,7 @@ func (m *MemoryStore) GetRevokedCertificateBySerial(serialNumber *big.Int) *core
 // store with the key ID as its index. This will store the key value in its
 // unencoded, raw form.
 func (m *MemoryStore) AddExternalAccountKeyByID(keyID, key string) error {
+	defer m.observe(operationAdd, collectionExternalAccountKeys)()
 	if len(key) == 0 || len(keyID) == 0 {
 		return errors.New("key ID and key must not be empty")
 	}
@@ -417,23 +570,281 @@ func (m *MemoryStore) AddExternalAccountKeyByID(keyID, key string) error {
 		return fmt.Errorf("failed to decode base64 URL encoded key %q: %s", key, err)
 	}
 
-	m.Lock()
-	defer m.Unlock()
+	m.externalAccountKeysMu.Lock()
+	defer m.externalAccountKeysMu.Unlock()
 
 	if _, ok := m.externalAccountKeysByID[keyID]; ok {
 		return fmt.Errorf("key ID %q is already present", keyID)
 	}
 
-	m.externalAccountKeysByID[keyID] = keyDecoded
+	m.externalAccountKeysByID[keyID] = &core.ExternalAccountKey{ID: keyID, Key: keyDecoded}
 
 	return nil
 }
 
-// GetExternalAccountKeyByID will return the raw, base64 URL unencoded key
-// value by its key ID pair.
-func (m *MemoryStore) GetExtenalAccountKeyByID(keyID string) ([]byte, bool) {
-	m.RLock()
-	defer m.RUnlock()
-	key, ok := m.externalAccountKeysByID[keyID]
-	return key, ok
+// GetExternalAccountKeyByID returns the External Account Binding key with the
+// given key ID, or nil if there is none.
+func (m *MemoryStore) GetExternalAccountKeyByID(keyID string) *core.ExternalAccountKey {
+	defer m.observe(operationLookup, collectionExternalAccountKeys)()
+	m.externalAccountKeysMu.RLock()
+	defer m.externalAccountKeysMu.RUnlock()
+	return m.externalAccountKeysByID[keyID]
+}
+
+// GetExternalAccountKeys returns the External Account Binding keys sorted by
+// key ID.
+func (m *MemoryStore) GetExternalAccountKeys() []*core.ExternalAccountKey {
+	m.externalAccountKeysMu.RLock()
+	defer m.externalAccountKeysMu.RUnlock()
+	keys := make([]*core.ExternalAccountKey, 0, len(m.externalAccountKeysByID))
+	for _, key := range m.externalAccountKeysByID {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// UpdateExternalAccountKey changes the External Account Binding key with the
+// given key ID, and returns the changed key. Keys are replaced rather than
+// changed in place, so that the keys returned by the store never change.
+func (m *MemoryStore) UpdateExternalAccountKey(
+	keyID string,
+	update func(key *core.ExternalAccountKey)) (*core.ExternalAccountKey, error) {
+	defer m.observe(operationUpdate, collectionExternalAccountKeys)()
+	m.externalAccountKeysMu.Lock()
+	defer m.externalAccountKeysMu.Unlock()
+
+	existing, ok := m.externalAccountKeysByID[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key ID %q is not present", keyID)
+	}
+	updated := *existing
+	update(&updated)
+	m.externalAccountKeysByID[keyID] = &updated
+	return &updated, nil
+}
+
+// GetExternalAccountKeyUsage returns the number of accounts bound to the
+// External Account Binding key with the given key ID and the number of
+// certificates issued to them.
+func (m *MemoryStore) GetExternalAccountKeyUsage(keyID string) (int, int) {
+	defer m.observe(operationScan, collectionAccounts)()
+	m.accountsMu.RLock()
+	accountIDs := make(map[string]bool)
+	for _, acct := range m.accountsByID {
+		if acct.ExternalAccountKeyID == keyID {
+			accountIDs[acct.ID] = true
+		}
+	}
+	m.accountsMu.RUnlock()
+
+	// The certificates are counted rather than the orders they were issued
+	// for, since the garbage collection prunes orders but never certificates
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+	certificates := 0
+	for _, cert := range m.certificatesByID {
+		if accountIDs[cert.AccountID] {
+			certificates++
+		}
+	}
+	for _, revoked := range m.revokedCertificatesByID {
+		if accountIDs[revoked.Certificate.AccountID] {
+			certificates++
+		}
+	}
+	return len(accountIDs), certificates
+}
+
+// AddDelegation adds a STAR delegation object for the delegation's account.
+func (m *MemoryStore) AddDelegation(delegation *core.Delegation) (int, error) {
+	defer m.observe(operationAdd, collectionDelegations)()
+	if len(delegation.ID) == 0 {
+		return 0, fmt.Errorf("delegation must have a non-empty ID to add to MemoryStore")
+	}
+	if m.GetAccountByID(delegation.AccountID) == nil {
+		return 0, fmt.Errorf("account %q does not exist", delegation.AccountID)
+	}
+
+	m.delegationsMu.Lock()
+	defer m.delegationsMu.Unlock()
+	if _, present := m.delegationsByID[delegation.ID]; present {
+		return 0, fmt.Errorf("delegation %q already exists", delegation.ID)
+	}
+
+	m.delegationsByID[delegation.ID] = delegation
+	m.delegationsByAccountID[delegation.AccountID] = append(
+		m.delegationsByAccountID[delegation.AccountID], delegation)
+	return len(m.delegationsByID), nil
+}
+
+func (m *MemoryStore) GetDelegationByID(id string) *core.Delegation {
+	defer m.observe(operationLookup, collectionDelegations)()
+	m.delegationsMu.RLock()
+	defer m.delegationsMu.RUnlock()
+	return m.delegationsByID[id]
+}
+
+func (m *MemoryStore) GetDelegationsByAccountID(accountID string) []*core.Delegation {
+	defer m.observe(operationLookup, collectionDelegations)()
+	m.delegationsMu.RLock()
+	defer m.delegationsMu.RUnlock()
+	return m.delegationsByAccountID[accountID]
+}
+
+// SetARIResponse overrides the renewalInfo response for the certificate with
+// the given ID. A nil response removes a previous override.
+func (m *MemoryStore) SetARIResponse(certID string, info *acme.RenewalInfo) {
+	m.ariResponsesMu.Lock()
+	defer m.ariResponsesMu.Unlock()
+	if info == nil {
+		delete(m.ariResponsesByCertID, certID)
+		return
+	}
+	m.ariResponsesByCertID[certID] = info
+}
+
+// GetARIResponse returns the renewalInfo response override for the
+// certificate with the given ID, or nil if there is none.
+func (m *MemoryStore) GetARIResponse(certID string) *acme.RenewalInfo {
+	m.ariResponsesMu.RLock()
+	defer m.ariResponsesMu.RUnlock()
+	return m.ariResponsesByCertID[certID]
+}
+
+// GetARIResponses returns the renewalInfo response overrides keyed by
+// certificate ID.
+func (m *MemoryStore) GetARIResponses() map[string]*acme.RenewalInfo {
+	m.ariResponsesMu.RLock()
+	defer m.ariResponsesMu.RUnlock()
+	responses := make(map[string]*acme.RenewalInfo, len(m.ariResponsesByCertID))
+	for certID, info := range m.ariResponsesByCertID {
+		responses[certID] = info
+	}
+	return responses
+}
+
+// ScheduleRevocation schedules the revocation of the certificate with the
+// given ID, replacing a previous schedule. A nil schedule removes a previous
+// one.
+func (m *MemoryStore) ScheduleRevocation(certID string, scheduled *core.ScheduledRevocation) {
+	m.scheduledRevocationsMu.Lock()
+	defer m.scheduledRevocationsMu.Unlock()
+	if scheduled == nil {
+		delete(m.scheduledRevocationsByCertID, certID)
+		return
+	}
+	m.scheduledRevocationsByCertID[certID] = scheduled
+}
+
+// GetScheduledRevocation returns the revocation scheduled for the certificate
+// with the given ID, or nil if there is none.
+func (m *MemoryStore) GetScheduledRevocation(certID string) *core.ScheduledRevocation {
+	m.scheduledRevocationsMu.RLock()
+	defer m.scheduledRevocationsMu.RUnlock()
+	return m.scheduledRevocationsByCertID[certID]
+}
+
+// GetScheduledRevocations returns the scheduled revocations keyed by
+// certificate ID.
+func (m *MemoryStore) GetScheduledRevocations() map[string]*core.ScheduledRevocation {
+	m.scheduledRevocationsMu.RLock()
+	defer m.scheduledRevocationsMu.RUnlock()
+	scheduled := make(map[string]*core.ScheduledRevocation, len(m.scheduledRevocationsByCertID))
+	for certID, s := range m.scheduledRevocationsByCertID {
+		scheduled[certID] = s
+	}
+	return scheduled
+}
+
+// BlockKey blocks a public key, e.g. the key of a certificate revoked for key
+// compromise.
+func (m *MemoryStore) BlockKey(key crypto.PublicKey) error {
+	keyID, err := keyToID(key)
+	if err != nil {
+		return err
+	}
+	m.blockedKeysMu.Lock()
+	defer m.blockedKeysMu.Unlock()
+	m.blockedKeysByID[keyID] = true
+	return nil
+}
+
+// IsKeyBlocked returns whether a public key was blocked.
+func (m *MemoryStore) IsKeyBlocked(key crypto.PublicKey) (bool, error) {
+	keyID, err := keyToID(key)
+	if err != nil {
+		return false, err
+	}
+	m.blockedKeysMu.RLock()
+	defer m.blockedKeysMu.RUnlock()
+	return m.blockedKeysByID[keyID], nil
+}
+
+// PauseAccount pauses the account with the given ID for the given identifiers,
+// in addition to those it is already paused for. The token is the account's
+// unpause token unless the account is already paused, in which case its token
+// is kept.
+func (m *MemoryStore) PauseAccount(accountID string, idents []acme.Identifier, token string) *core.PausedAccount {
+	m.pausedAccountsMu.Lock()
+	defer m.pausedAccountsMu.Unlock()
+	paused := &core.PausedAccount{UnpauseToken: token}
+	if existing := m.pausedAccountsByID[accountID]; existing != nil {
+		paused.UnpauseToken = existing.UnpauseToken
+		paused.Identifiers = append(paused.Identifiers, existing.Identifiers...)
+	}
+	for _, ident := range idents {
+		known := false
+		for _, i := range paused.Identifiers {
+			known = known || i.Equals(ident)
+		}
+		if !known {
+			paused.Identifiers = append(paused.Identifiers, ident)
+		}
+	}
+	m.pausedAccountsByID[accountID] = paused
+	return paused
+}
+
+// UnpauseAccount unpauses the account with the given ID for all identifiers,
+// and returns whether it was paused.
+func (m *MemoryStore) UnpauseAccount(accountID string) bool {
+	m.pausedAccountsMu.Lock()
+	defer m.pausedAccountsMu.Unlock()
+	_, paused := m.pausedAccountsByID[accountID]
+	delete(m.pausedAccountsByID, accountID)
+	return paused
+}
+
+// GetPausedAccount returns the identifiers the account with the given ID is
+// paused for, or nil if it isn't paused.
+func (m *MemoryStore) GetPausedAccount(accountID string) *core.PausedAccount {
+	m.pausedAccountsMu.RLock()
+	defer m.pausedAccountsMu.RUnlock()
+	return m.pausedAccountsByID[accountID]
+}
+
+// GetPausedAccountByToken returns the ID of the paused account with the given
+// unpause token and the identifiers it is paused for, or "" and nil if there
+// is none.
+func (m *MemoryStore) GetPausedAccountByToken(token string) (string, *core.PausedAccount) {
+	m.pausedAccountsMu.RLock()
+	defer m.pausedAccountsMu.RUnlock()
+	for accountID, paused := range m.pausedAccountsByID {
+		if paused.UnpauseToken == token {
+			return accountID, paused
+		}
+	}
+	return "", nil
+}
+
+// GetPausedAccounts returns the paused accounts keyed by account ID.
+func (m *MemoryStore) GetPausedAccounts() map[string]*core.PausedAccount {
+	m.pausedAccountsMu.RLock()
+	defer m.pausedAccountsMu.RUnlock()
+	paused := make(map[string]*core.PausedAccount, len(m.pausedAccountsByID))
+	for accountID, p := range m.pausedAccountsByID {
+		paused[accountID] = p
+	}
+	return paused
 }
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+func TestGetExternalAccountKeyUsage(t *testing.T) {
+	clk := clock.NewFake()
+	m := NewMemoryStore(clk)
+	addAccount := func(keyID string) *core.Account {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generating key: %s", err)
+		}
+		acct := &core.Account{
+			Key:                  &jose.JSONWebKey{Key: key.Public()},
+			ExternalAccountKeyID: keyID,
+		}
+		if _, err := m.AddAccount(acct); err != nil {
+			t.Fatalf("adding account: %s", err)
+		}
+		return acct
+	}
+	serial := int64(0)
+	addCertificate := func(acct *core.Account) *core.Certificate {
+		serial++
+		cert := &core.Certificate{
+			ID:        big.NewInt(serial).Text(16),
+			Cert:      &x509.Certificate{SerialNumber: big.NewInt(serial)},
+			AccountID: acct.ID,
+		}
+		if _, err := m.AddCertificate(cert); err != nil {
+			t.Fatalf("adding certificate: %s", err)
+		}
+		return cert
+	}
+	addOrder := func(acct *core.Account, cert *core.Certificate) {
+		order := &core.Order{
+			ID:                big.NewInt(serial).Text(16),
+			AccountID:         acct.ID,
+			ExpiresDate:       clk.Now().Add(time.Hour),
+			CertificateObject: cert,
+		}
+		if _, err := m.AddOrder(order); err != nil {
+			t.Fatalf("adding order: %s", err)
+		}
+	}
+
+	first, second := addAccount("kid"), addAccount("kid")
+	other := addAccount("other-kid")
+	addAccount("")
+	for _, acct := range []*core.Account{first, first, second, other} {
+		addOrder(acct, addCertificate(acct))
+	}
+	revoked := addCertificate(second)
+	m.RevokeCertificate(&core.RevokedCertificate{Certificate: revoked, RevokedAt: clk.Now()})
+
+	check := func(when string) {
+		t.Helper()
+		if accounts, certificates := m.GetExternalAccountKeyUsage("kid"); accounts != 2 || certificates != 4 {
+			t.Errorf("%s, key is used by %d accounts with %d certificates, want 2 and 4",
+				when, accounts, certificates)
+		}
+		if accounts, certificates := m.GetExternalAccountKeyUsage("unknown"); accounts != 0 || certificates != 0 {
+			t.Errorf("%s, unknown key is used by %d accounts with %d certificates", when, accounts, certificates)
+		}
+	}
+	check("before pruning orders")
+	// Pruning the orders the certificates were issued for doesn't free quota
+	if err := clk.Advance(2 * time.Hour); err != nil {
+		t.Fatalf("advancing clock: %s", err)
+	}
+	if counts := m.PruneExpired(clk.Now()); counts.Orders != 4 {
+		t.Fatalf("pruned %d orders, want 4", counts.Orders)
+	}
+	check("after pruning orders")
+}
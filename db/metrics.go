@@ -0,0 +1,70 @@
+package db
+
+import (
+	"time"
+
+	"github.com/letsencrypt/pebble/metrics"
+)
+
+// The operations on the collections of the store that are measured
+const (
+	operationAdd    = "add"
+	operationUpdate = "update"
+	operationLookup = "lookup"
+	// Lookups that iterate over a whole collection since no index has the
+	// key they look up by
+	operationScan = "scan"
+)
+
+// The collections of the store, named like the fields of StoreStats
+const (
+	collectionAccounts            = "accounts"
+	collectionOrders              = "orders"
+	collectionAuthorizations      = "authorizations"
+	collectionChallenges          = "challenges"
+	collectionCertificates        = "certificates"
+	collectionRevokedCertificates = "revokedCertificates"
+	collectionExternalAccountKeys = "externalAccountKeys"
+	collectionDelegations         = "delegations"
+)
+
+// storeMetrics are the Prometheus metrics of the operations on a store.
+type storeMetrics struct {
+	operations *metrics.SummaryVec
+}
+
+// SetMetrics adds the sizes of the collections of the store and the counts
+// and durations of the operations on them to the registry. It must be called
+// before the store is used.
+func (m *MemoryStore) SetMetrics(registry *metrics.Registry) {
+	registry.NewGaugeFunc("pebble_store_objects",
+		"Objects in the store by collection.", "collection", func() map[string]float64 {
+			stats := m.Stats()
+			return map[string]float64{
+				collectionAccounts:            float64(stats.Accounts),
+				collectionOrders:              float64(stats.Orders),
+				collectionAuthorizations:      float64(stats.Authorizations),
+				collectionChallenges:          float64(stats.Challenges),
+				collectionCertificates:        float64(stats.Certificates),
+				collectionRevokedCertificates: float64(stats.RevokedCertificates),
+				collectionExternalAccountKeys: float64(stats.ExternalAccountKeys),
+			}
+		})
+	m.metrics = &storeMetrics{
+		operations: registry.NewSummaryVec("pebble_store_operation_duration_seconds",
+			"Durations of the operations on the store by operation and collection, including waiting for locks.",
+			"operation", "collection"),
+	}
+}
+
+// observe returns a function to be deferred by an operation on a collection,
+// which records the duration of the operation when it returns.
+func (m *MemoryStore) observe(operation, collection string) func() {
+	if m.metrics == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		m.metrics.operations.Observe(time.Since(start).Seconds(), operation, collection)
+	}
+}
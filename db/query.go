@@ -0,0 +1,95 @@
+package db
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// OrderFilter selects the orders of an account returned by FindOrders. Empty
+// fields match all orders.
+type OrderFilter struct {
+	// Status of the orders, e.g. "valid"
+	Status string
+	// Value of an identifier of the orders, e.g. "example.com" or "10.0.0.1"
+	Identifier string
+}
+
+// FindOrders returns the orders of the account with the given ID matching the
+// filter, in the order they were created.
+func (m *MemoryStore) FindOrders(accountID string, filter OrderFilter) []*core.Order {
+	var orders []*core.Order
+	for _, order := range m.GetOrdersByAccountID(accountID) {
+		snapshot := order.Snapshot()
+		if filter.Status != "" && snapshot.Status != filter.Status {
+			continue
+		}
+		if filter.Identifier != "" && !hasIdentifier(snapshot, filter.Identifier) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// hasIdentifier returns whether an order has an identifier with the value,
+// comparing domain names case-insensitively.
+func hasIdentifier(order *core.Order, value string) bool {
+	for _, ident := range order.Identifiers {
+		if strings.EqualFold(ident.Value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindCertificatesByName returns the certificates, revoked or not, with a DNS
+// name or IP address matching the name, sorted by the date they are valid
+// from. Domain names are compared case-insensitively and wildcard names only
+// match themselves.
+func (m *MemoryStore) FindCertificatesByName(name string) []*core.Certificate {
+	defer m.observe(operationScan, collectionCertificates)()
+	m.certificatesMu.RLock()
+	defer m.certificatesMu.RUnlock()
+
+	var certs []*core.Certificate
+	for _, cert := range m.certificatesByID {
+		if certificateHasName(cert, name) {
+			certs = append(certs, cert)
+		}
+	}
+	for _, revoked := range m.revokedCertificatesByID {
+		if certificateHasName(revoked.Certificate, name) {
+			certs = append(certs, revoked.Certificate)
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		if !certs[i].Cert.NotBefore.Equal(certs[j].Cert.NotBefore) {
+			return certs[i].Cert.NotBefore.Before(certs[j].Cert.NotBefore)
+		}
+		return certs[i].ID < certs[j].ID
+	})
+	return certs
+}
+
+// certificateHasName returns whether a certificate has a DNS name or IP
+// address matching the name.
+func certificateHasName(cert *core.Certificate, name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		for _, certIP := range cert.Cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	name = normalizeDomain(name)
+	for _, dnsName := range cert.Cert.DNSNames {
+		if normalizeDomain(dnsName) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package pebble
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server creates an HTTP/3 server for the ACME handler, and wraps the
+// handler of the TLS listener to advertise it with Alt-Svc headers.
+func (s *Server) newHTTP3Server(acmeHandler http.Handler) error {
+	cert, err := tls.LoadX509KeyPair(s.config.Certificate, s.config.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %s", err)
+	}
+	s.http3Server = &http3.Server{
+		Handler: acmeHandler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}),
+	}
+
+	handler := s.acmeServer.Handler
+	s.acmeServer.Handler = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		// Only fails if the HTTP/3 server isn't listening yet
+		_ = s.http3Server.SetQUICHeaders(response.Header())
+		handler.ServeHTTP(response, request)
+	})
+	return nil
+}
+
+// listenHTTP3 starts serving HTTP/3 requests on the UDP address.
+func (s *Server) listenHTTP3(address string) (net.Addr, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := s.http3Server.Serve(conn)
+		if err != http.ErrServerClosed {
+			s.errs <- err
+		}
+	}()
+	return conn.LocalAddr(), nil
+}
+
+// shutdownHTTP3 stops the HTTP/3 server, waiting for active requests to
+// complete until the context is done.
+func (s *Server) shutdownHTTP3(ctx context.Context) error {
+	if s.http3Server == nil {
+		return nil
+	}
+	return s.http3Server.Shutdown(ctx)
+}
@@ -0,0 +1,174 @@
+package pebble
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks listen addresses that are paths of Unix domain sockets,
+// e.g. "unix:/run/pebble/acme.sock".
+const unixPrefix = "unix:"
+
+// The address families of TCP listeners. Listeners on a wildcard address such
+// as "[::]:14000" accept IPv4 and IPv6 connections unless restricted to one
+// family.
+const (
+	familyDualStack = "dual-stack"
+	familyIPv4      = "ipv4"
+	familyIPv6      = "ipv6"
+)
+
+// ListenerConfig configures an additional listener of the ACME or management
+// interface, e.g. to serve requests on several interfaces at once.
+type ListenerConfig struct {
+	// Address of the listener, e.g. "[::1]:14000", or a Unix domain socket
+	// path with the "unix:" prefix
+	Address string
+	// Address family of the listener: "dual-stack", "ipv4" or "ipv6". Empty
+	// means dual-stack.
+	Family string
+	// TLS versions, cipher suites and HTTP protocols of the listener. Nil
+	// means those of the primary listener. OCSP stapling can't be configured
+	// per listener.
+	TLS *TLSConfig
+	// Certificate and private key files of the listener. Empty means those
+	// of the config.
+	Certificate string
+	PrivateKey  string
+}
+
+// extraListener is an additional listener of the ACME or management
+// interface.
+type extraListener struct {
+	config   ListenerConfig
+	server   *http.Server
+	listener net.Listener
+	// The certificate and private key files the server serves
+	certFile string
+	keyFile  string
+}
+
+// newExtraListener creates the server of an additional listener. Its TLS
+// settings default to those of the primary server, whose certificate is
+// served if the listener doesn't have its own.
+func newExtraListener(config ListenerConfig, handler http.Handler, primary *http.Server, certFile, keyFile string) (*extraListener, error) {
+	if config.Address == "" {
+		return nil, errors.New("listeners must have an address")
+	}
+	if _, err := networkOf(config.Family); err != nil {
+		return nil, err
+	}
+	if (config.Certificate == "") != (config.PrivateKey == "") {
+		return nil, errors.New("listeners must have both a certificate and a private key, or neither")
+	}
+
+	l := &extraListener{
+		config:   config,
+		server:   &http.Server{Handler: handler},
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if config.Certificate != "" {
+		l.certFile, l.keyFile = config.Certificate, config.PrivateKey
+	}
+	if config.TLS != nil {
+		if config.TLS.OCSPStapling || config.TLS.OCSPResponse != "" {
+			return nil, errors.New("OCSP stapling can't be configured per listener")
+		}
+		if err := config.TLS.apply(l.server); err != nil {
+			return nil, err
+		}
+	} else if primary.TLSConfig != nil {
+		l.server.Handler = primary.Handler
+		l.server.TLSNextProto = primary.TLSNextProto
+		l.server.TLSConfig = primary.TLSConfig.Clone()
+	}
+	if l.server.TLSConfig != nil && config.Certificate != "" {
+		// The certificate of the OCSP stapler is the primary one
+		l.server.TLSConfig.GetCertificate = nil
+	}
+	return l, nil
+}
+
+// networkOf returns the network of TCP listeners of an address family.
+func networkOf(family string) (string, error) {
+	switch family {
+	case "", familyDualStack:
+		return "tcp", nil
+	case familyIPv4:
+		return "tcp4", nil
+	case familyIPv6:
+		// Go restricts "tcp6" listeners on wildcard addresses to IPv6
+		return "tcp6", nil
+	}
+	return "", fmt.Errorf("unknown address family %q, expected %q, %q or %q",
+		family, familyDualStack, familyIPv4, familyIPv6)
+}
+
+// socketPath returns the path of the Unix domain socket of a listen address,
+// or "" if it is a TCP address.
+func socketPath(address string) string {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(address, unixPrefix)
+}
+
+// parseSocketMode parses the octal file mode of Unix domain sockets, e.g.
+// "0660". An empty mode leaves the mode of new sockets to the umask.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q, expected an octal mode such as \"0660\"", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// listen listens on a TCP address of an address family, or on a Unix domain
+// socket for addresses with the "unix:" prefix. A stale socket file left
+// behind by a previous process is removed, and the socket gets the mode if it
+// isn't zero.
+func listen(address, family string, mode os.FileMode) (net.Listener, error) {
+	path := socketPath(address)
+	if path == "" {
+		network, err := networkOf(family)
+		if err != nil {
+			return nil, err
+		}
+		return net.Listen(network, address)
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %s", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("setting socket mode: %s", err)
+		}
+	}
+	return listener, nil
+}
+
+// urlHost returns the host of URLs of a listen address. Unix domain sockets
+// don't have one, clients connecting to them send "localhost".
+func urlHost(address string) string {
+	if socketPath(address) != "" {
+		return "localhost"
+	}
+	return address
+}
@@ -0,0 +1,51 @@
+package pebble
+
+import (
+	"errors"
+
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/va"
+)
+
+// LoadTestConfig tunes Pebble for benchmarking ACME clients at scale, so that
+// Pebble isn't the bottleneck of the load test. The zero value changes
+// nothing.
+type LoadTestConfig struct {
+	// Skip the artificial sleeps: the random sleep before validations, the
+	// delays and retry intervals of the challenge timings, the issuance delay
+	// and the processing time of the finalize workers
+	NoSleep bool
+	// Number of CA keys generated ahead of time in the background, which the
+	// CA hierarchies of tenants and intermediate rotations use instead of
+	// generating keys while a request waits. Zero means no pool.
+	KeyPool int
+	// Seconds between the issuance rate summaries logged. Zero means none.
+	ReportInterval int
+}
+
+// validate returns an error if the load testing config has negative
+// settings.
+func (c LoadTestConfig) validate() error {
+	if c.KeyPool < 0 || c.ReportInterval < 0 {
+		return errors.New("key pool size and report interval must not be negative")
+	}
+	return nil
+}
+
+// apply returns the config with the settings the load testing mode overrides.
+func (c LoadTestConfig) apply(config Config) Config {
+	if !c.NoSleep {
+		return config
+	}
+	config.NoSleep = true
+	config.IssuanceDelay = ca.IssuanceDelay{}
+	config.Finalize.ProcessingTime = 0
+	// Challenge timings keep their attempts, without waiting between them
+	timings := make(map[string]va.ChallengeTiming, len(config.ChallengeTimings))
+	for challType, timing := range config.ChallengeTimings {
+		timing.Delay, timing.RetryInterval = 0, 0
+		timings[challType] = timing
+	}
+	config.ChallengeTimings = timings
+	return config
+}
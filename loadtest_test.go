@@ -0,0 +1,73 @@
+package pebble
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+func TestLoadTestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		config  LoadTestConfig
+		wantErr bool
+	}{
+		{config: LoadTestConfig{}},
+		{config: LoadTestConfig{NoSleep: true, KeyPool: 8, ReportInterval: 10}},
+		{config: LoadTestConfig{KeyPool: -1}, wantErr: true},
+		{config: LoadTestConfig{ReportInterval: -1}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		if err := tc.config.validate(); (err != nil) != tc.wantErr {
+			t.Errorf("validate(%+v) returned error %v, want error %t", tc.config, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLoadTestConfigApply(t *testing.T) {
+	config := Config{
+		SleepTime:     10,
+		IssuanceDelay: ca.IssuanceDelay{Min: 1, Max: 5},
+		Finalize:      wfe.FinalizeConfig{Workers: 4, ProcessingTime: 250},
+		ChallengeTimings: map[string]va.ChallengeTiming{
+			"http-01": {Delay: 5, Attempts: 3, RetryInterval: 10},
+		},
+	}
+	testCases := []struct {
+		name     string
+		loadTest LoadTestConfig
+		want     Config
+	}{
+		{
+			name:     "sleeps kept",
+			loadTest: LoadTestConfig{KeyPool: 8, ReportInterval: 10},
+			want:     config,
+		},
+		{
+			name:     "sleeps skipped",
+			loadTest: LoadTestConfig{NoSleep: true},
+			want: Config{
+				NoSleep:   true,
+				SleepTime: 10,
+				Finalize:  wfe.FinalizeConfig{Workers: 4},
+				ChallengeTimings: map[string]va.ChallengeTiming{
+					"http-01": {Attempts: 3},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.loadTest.apply(config)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("apply() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+	// The challenge timings of the config are copied, not modified
+	if config.ChallengeTimings["http-01"].Delay != 5 {
+		t.Errorf("apply() modified the challenge timings of the config")
+	}
+}
@@ -0,0 +1,156 @@
+package pebble
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+// ManagementAuthConfig configures the authentication of management requests.
+// The zero value accepts all requests.
+type ManagementAuthConfig struct {
+	// Bearer tokens accepted in the Authorization header of requests, e.g.
+	// "Authorization: Bearer s3cr3t"
+	Tokens []string
+	// File of PEM encoded CA certificates, one of which must have issued the
+	// TLS client certificate of requests
+	ClientCAs string
+	// Require both a token and a client certificate if both are configured,
+	// instead of either of them
+	RequireBoth bool
+}
+
+// managementAuth authenticates management requests with bearer tokens and
+// TLS client certificates.
+type managementAuth struct {
+	tokens      [][]byte
+	clientCAs   *x509.CertPool
+	requireBoth bool
+}
+
+// newManagementAuth loads the client CAs of a management authentication
+// config. It returns nil if the config accepts all requests.
+func newManagementAuth(config ManagementAuthConfig) (*managementAuth, error) {
+	if len(config.Tokens) == 0 && config.ClientCAs == "" {
+		return nil, nil
+	}
+	auth := &managementAuth{requireBoth: config.RequireBoth}
+	for _, token := range config.Tokens {
+		if token == "" {
+			return nil, errors.New("tokens must not be empty")
+		}
+		auth.tokens = append(auth.tokens, []byte(token))
+	}
+	if config.ClientCAs != "" {
+		pemBytes, err := os.ReadFile(config.ClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CAs: %s", err)
+		}
+		auth.clientCAs = x509.NewCertPool()
+		if !auth.clientCAs.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no PEM certificates in client CAs file %s", config.ClientCAs)
+		}
+	}
+	return auth, nil
+}
+
+// requestsClientCerts returns true if TLS clients must be asked for
+// certificates to authenticate requests.
+func (a *managementAuth) requestsClientCerts() bool {
+	return a != nil && a.clientCAs != nil
+}
+
+// allows returns true if the request has a valid token or client
+// certificate, or both if both are required.
+func (a *managementAuth) allows(request *http.Request) bool {
+	if a == nil {
+		return true
+	}
+	hasToken, hasCert := a.validToken(request), a.validClientCert(request)
+	switch {
+	case len(a.tokens) == 0:
+		return hasCert
+	case a.clientCAs == nil:
+		return hasToken
+	case a.requireBoth:
+		return hasToken && hasCert
+	default:
+		return hasToken || hasCert
+	}
+}
+
+// validToken returns true if the request's Authorization header has one of
+// the bearer tokens.
+func (a *managementAuth) validToken(request *http.Request) bool {
+	scheme, token, found := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	valid := false
+	for _, t := range a.tokens {
+		// Compare all tokens in constant time so that timing doesn't reveal
+		// them
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), t) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// validClientCert returns true if the TLS client certificate of the request
+// was issued by one of the client CAs.
+func (a *managementAuth) validClientCert(request *http.Request) bool {
+	if a.clientCAs == nil || request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	certs := request.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         a.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// protect returns a handler responding 401 Unauthorized to requests for
+// non-public endpoints that neither the authentication nor any of the
+// fallbacks allows, like those of the parent of a tenant.
+func (a *managementAuth) protect(handler http.Handler, fallbacks ...*managementAuth) http.Handler {
+	if a == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		allowed := wfe.IsPublicManagementPath(request.URL.Path) || a.allows(request)
+		for _, fallback := range fallbacks {
+			allowed = allowed || fallback != nil && fallback.allows(request)
+		}
+		if !allowed {
+			if len(a.tokens) > 0 {
+				response.Header().Set("WWW-Authenticate", `Bearer realm="pebble-management"`)
+			}
+			http.Error(response, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(response, request)
+	})
+}
+
+// requestClientCerts makes a TLS server ask clients for certificates, which
+// the handlers verify against the client CAs of the server or its tenants.
+func requestClientCerts(server *http.Server) {
+	if server.TLSConfig == nil {
+		server.TLSConfig = &tls.Config{}
+	}
+	server.TLSConfig.ClientAuth = tls.RequestClientCert
+}
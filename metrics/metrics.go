@@ -0,0 +1,268 @@
+// Package metrics implements minimal Prometheus metrics. Counters, gauges and
+// summaries are kept in a Registry, which serves them in the Prometheus text exposition format to
+// scrapes of a /metrics endpoint. It is meant to count what Pebble and the
+// challenge test server do during test runs, not to be a complete Prometheus
+// client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the content type of the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry is a set of metrics served together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is a metric of a Registry.
+type metric interface {
+	// write writes the metric in the text exposition format
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec adds a counter with the given name, help text and label names
+// to the registry. The name should end with "_total", e.g.
+// "challtestsrv_dns_queries_total".
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+	r.metrics = append(r.metrics, c)
+	return c
+}
+
+// NewGaugeFunc adds a gauge with the given name, help text and label name to
+// the registry, whose values are collected on each scrape, keyed by the value
+// of the label.
+func (r *Registry) NewGaugeFunc(name, help, label string, collect func() map[string]float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, &gaugeFunc{
+		name:    name,
+		help:    help,
+		label:   label,
+		collect: collect,
+	})
+}
+
+// NewSummaryVec adds a summary with the given name, help text and label names
+// to the registry. Summaries report the count and sum of the observed values,
+// without quantiles. The name should have the unit of the values, e.g.
+// "pebble_store_operation_duration_seconds".
+func (r *Registry) NewSummaryVec(name, help string, labels ...string) *SummaryVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &SummaryVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*summaryValue),
+	}
+	r.metrics = append(r.metrics, s)
+	return s
+}
+
+// ServeHTTP writes the metrics of the registry in the text exposition
+// format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// CounterVec is a counter with a value for each combination of the values of
+// its labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu sync.Mutex
+	// A map of the joined label values to the counter value
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Inc increments the counter for the label values by one.
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds a non-negative value to the counter for the label values, which
+// must be as many as the label names of the counter.
+func (c *CounterVec) Add(value float64, labels ...string) {
+	if len(labels) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", c.name, len(c.labels), len(labels)))
+	}
+	if value < 0 {
+		panic(fmt.Sprintf("metrics: %s can't decrease", c.name))
+	}
+	key := strings.Join(labels, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string{}, labels...)}
+		c.values[key] = v
+	}
+	v.value += value
+}
+
+// write writes the counter in the text exposition format, its values sorted
+// by their labels.
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := c.values[key]
+		writeSample(w, c.name, c.labels, v.labels, v.value)
+	}
+}
+
+// gaugeFunc is a gauge whose values are collected on each scrape.
+type gaugeFunc struct {
+	name    string
+	help    string
+	label   string
+	collect func() map[string]float64
+}
+
+// write writes the collected values of the gauge in the text exposition
+// format, sorted by their label.
+func (g *gaugeFunc) write(w io.Writer) {
+	values := g.collect()
+	writeHeader(w, g.name, g.help, "gauge")
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeSample(w, g.name, []string{g.label}, []string{key}, values[key])
+	}
+}
+
+// SummaryVec is a summary with the count and sum of the values observed for
+// each combination of the values of its labels.
+type SummaryVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu sync.Mutex
+	// A map of the joined label values to the count and sum
+	values map[string]*summaryValue
+}
+
+type summaryValue struct {
+	labels []string
+	count  float64
+	sum    float64
+}
+
+// Observe adds a value to the summary for the label values, which must be as
+// many as the label names of the summary.
+func (s *SummaryVec) Observe(value float64, labels ...string) {
+	if len(labels) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", s.name, len(s.labels), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		v = &summaryValue{labels: append([]string{}, labels...)}
+		s.values[key] = v
+	}
+	v.count++
+	v.sum += value
+}
+
+// write writes the summary in the text exposition format, its values sorted
+// by their labels.
+func (s *SummaryVec) write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeHeader(w, s.name, s.help, "summary")
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := s.values[key]
+		writeSample(w, s.name+"_sum", s.labels, v.labels, v.sum)
+		writeSample(w, s.name+"_count", s.labels, v.labels, v.count)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escape(help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// writeSample writes a sample line of a metric with the label names and
+// values.
+func writeSample(w io.Writer, name string, labelNames, labelValues []string, value float64) {
+	pairs := make([]string, len(labelNames))
+	for i, label := range labelNames {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", label, escape(labelValues[i], true))
+	}
+	labels := ""
+	if len(pairs) > 0 {
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// escape escapes backslashes and line feeds of help texts and label values,
+// and double quotes of label values.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+package pebble
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// Fetching an OCSP response is retried after ocspRetryInterval if it
+	// fails, and a response without a nextUpdate time is refreshed after
+	// ocspRefreshInterval.
+	ocspRetryInterval   = time.Minute
+	ocspRefreshInterval = time.Hour
+	ocspFetchTimeout    = 10 * time.Second
+)
+
+var ocspStatuses = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// ocspStapler staples an OCSP response for the serving certificate to the TLS
+// handshakes of the ACME listener. The response is read from a file, or
+// fetched from the OCSP responder of the certificate and refreshed halfway
+// through its validity.
+type ocspStapler struct {
+	log          *log.Logger
+	leaf         *x509.Certificate
+	issuer       *x509.Certificate
+	responseFile string
+	// cert is the *tls.Certificate served with the current response
+	cert atomic.Value
+	stop chan struct{}
+}
+
+// newOCSPStapler creates a stapler for the certificate and key files. The
+// certificate file must include the issuer of the certificate.
+func newOCSPStapler(logger *log.Logger, certFile, keyFile, responseFile string) (*ocspStapler, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %s", err)
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, errors.New("the certificate file must include the issuer certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+	if responseFile == "" && len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("the certificate has no OCSP responder URL")
+	}
+
+	stapler := &ocspStapler{
+		log:          logger,
+		leaf:         leaf,
+		issuer:       issuer,
+		responseFile: responseFile,
+		stop:         make(chan struct{}),
+	}
+	stapler.cert.Store(&cert)
+	if responseFile != "" {
+		der, err := ioutil.ReadFile(responseFile)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := stapler.staple(der); err != nil {
+			return nil, err
+		}
+	}
+	return stapler, nil
+}
+
+// getCertificate is the tls.Config GetCertificate callback.
+func (st *ocspStapler) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return st.cert.Load().(*tls.Certificate), nil
+}
+
+// staple checks that the DER encoded response is a response for the
+// certificate signed by its issuer, and staples it to the TLS handshakes. It
+// returns the parsed response.
+func (st *ocspStapler) staple(der []byte) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, st.leaf, st.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OCSP response: %s", err)
+	}
+	cert := *st.cert.Load().(*tls.Certificate)
+	cert.OCSPStaple = der
+	st.cert.Store(&cert)
+	st.log.Printf("Stapling %s OCSP response for the serving certificate, next update %s",
+		ocspStatuses[resp.Status], resp.NextUpdate.Format(time.RFC3339))
+	return resp, nil
+}
+
+// run fetches and staples OCSP responses from the responder of the
+// certificate until stopped. A response read from a file is never refreshed.
+func (st *ocspStapler) run() {
+	if st.responseFile != "" {
+		return
+	}
+	for {
+		wait := ocspRetryInterval
+		resp, err := st.fetch()
+		if err != nil {
+			st.log.Printf("Error fetching OCSP response for the serving certificate: %s", err)
+		} else if resp.NextUpdate.IsZero() {
+			wait = ocspRefreshInterval
+		} else if refresh := resp.NextUpdate.Sub(resp.ThisUpdate) / 2; time.Until(resp.ThisUpdate.Add(refresh)) > wait {
+			wait = time.Until(resp.ThisUpdate.Add(refresh))
+		}
+
+		select {
+		case <-st.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// fetch requests an OCSP response from the first responder of the
+// certificate and staples it.
+func (st *ocspStapler) fetch() (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(st.leaf, st.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: ocspFetchTimeout}
+	httpResp, err := client.Post(st.leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", httpResp.Status, st.leaf.OCSPServer[0])
+	}
+	der, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return st.staple(der)
+}
+
+// shutdown stops refreshing the response.
+func (st *ocspStapler) shutdown() {
+	close(st.stop)
+}
@@ -0,0 +1,1172 @@
+// Package pebble runs a Pebble ACME server. It lets Go programs, e.g. the tests
+// of an ACME client, start Pebble in-process instead of running the pebble
+// command.
+package pebble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/ct"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/metrics"
+	"github.com/letsencrypt/pebble/trace"
+	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/webhook"
+	"github.com/letsencrypt/pebble/wfe"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Config configures a Pebble server. It is the "pebble" object of the pebble
+// command's config file.
+type Config struct {
+	// Addresses of the ACME and management listeners, e.g. "0.0.0.0:14000",
+	// or Unix domain socket paths with the "unix:" prefix
+	ListenAddress           string
+	ManagementListenAddress string
+	HTTPPort                int
+	TLSPort                 int
+	Certificate             string
+	PrivateKey              string
+	OCSPResponderURL        string
+	// Require External Account Binding for "newAccount" requests
+	ExternalAccountBindingRequired bool
+	ExternalAccountMACKeys         map[string]string
+	// Fields of the meta object of the directory
+	DirectoryMeta wfe.DirectoryMeta
+	// Agreement of accounts to the terms of service
+	TermsOfServiceAgreement wfe.TermsOfServiceAgreement
+	// Policies of External Account Binding keys, by key ID
+	ExternalAccountKeyPolicies map[string]core.ExternalAccountKeyPolicy
+	// STAR delegation objects (RFC 9115) created for every new account
+	Delegations []acme.Delegation
+	// Maximum number of labels between an identifier and the ancestor domain
+	// authorizing it (RFC 9444). Zero means no limit.
+	SubdomainAuthMaxDepth int
+	// Policies for wildcard identifiers
+	WildcardPolicy wfe.WildcardPolicy
+	// Perturbations of the served PEM certificate chains
+	ChainPerturbation wfe.ChainPerturbation
+	// Whether the root is appended to the served certificate chains: "never"
+	// (the default), "always" or "alternate" to serve it at alternate links
+	RootInChain string
+	// Rules for the identifiers newOrder requests may include
+	IdentifierPolicy wfe.IdentifierPolicy
+	// File listing domains newOrder requests may not include, one per line
+	BlockedDomainsFile string
+	// Certificate profiles that newOrder requests can select, keyed by name
+	Profiles map[string]core.Profile
+	// Existing CA hierarchy to load instead of generating a new one
+	CAHierarchy *ca.ExistingHierarchy
+	// Key algorithm of a generated CA hierarchy, e.g. "ecdsa-p256"
+	CAKeyAlgorithm string
+	// Signature algorithm of leaf certificates, e.g. "ECDSA-SHA384"
+	LeafSignatureAlgorithm string
+	// Alternate issuance chains in addition to those of AlternateRoots
+	AlternateChains []ca.AlternateChain
+	// URLs embedded in leaf and intermediate certificates. "{management}"
+	// is replaced with the management interface base URL.
+	CertificateURLs ca.CertificateURLConfig
+	// Serial number generation of leaf certificates
+	Serials ca.SerialConfig
+	// Limits on the notBefore and notAfter fields of newOrder requests
+	ValidityPolicy wfe.ValidityPolicy
+	// Validity period in seconds of all certificates in short-lived mode
+	ShortLivedValidityPeriod int
+	// Number of orders per page of account orders lists
+	OrdersPerPage int
+	// Limits on the number and length of order identifiers
+	IdentifierLimits wfe.IdentifierLimits
+	// Simulated rate limits
+	RateLimits wfe.RateLimits
+	// Faults injected into ACME responses
+	Faults []wfe.Fault
+	// CORS headers of ACME responses for browser-based ACME clients
+	CORS wfe.CORSConfig
+	// Compression of ACME responses for clients accepting it
+	Compression wfe.CompressionConfig
+	// Delay in seconds between order finalization and issuance
+	IssuanceDelay ca.IssuanceDelay
+	// Workers completing finalized orders
+	Finalize wfe.FinalizeConfig
+	// Validation delay and attempts per challenge type
+	ChallengeTimings map[string]va.ChallengeTiming
+	// CAA checks of validations
+	CAA va.CAAConfig
+	// DNS resolver the identifiers of challenges are resolved with, which may
+	// be a DNS over TLS or HTTPS resolver. The -dnsserver flag overrides its
+	// address.
+	DNSResolver va.DNSResolverConfig
+	// Remote perspectives challenges are validated from
+	MultiPerspective va.MultiPerspectiveConfig
+	// Redirects followed by HTTP-01 validations
+	HTTPRedirects va.RedirectPolicy
+	// HTTP or SOCKS5 proxy the connections of HTTP-01 and TLS-ALPN-01
+	// validations are made through
+	ValidationProxy va.ProxyConfig
+	// Address family of the connections of HTTP-01 and TLS-ALPN-01
+	// validations: "ipv4", "ipv6", "prefer-ipv4" or "prefer-ipv6". The first
+	// resolved address of any family is connected to if it is empty.
+	ValidationAddressFamily string
+	// Retries of validations failing because connecting to the challenge host
+	// or looking up its DNS records failed
+	TransientRetries va.TransientRetryConfig
+	// External validators challenges of some types are delegated to
+	ExternalValidators []va.ExternalValidatorConfig
+	// Additional challenge types offered in authorizations
+	ChallengeTypes []ChallengeType
+	// Built-in challenge types offered in authorizations by kind of identifier
+	OfferedChallenges wfe.OfferedChallenges
+	// When valid authorizations are reused in new orders
+	AuthzReusePolicy wfe.AuthzReusePolicy
+	// Hosts and ports the HTTP-01 and TLS-ALPN-01 validations of identifiers
+	// connect to instead of the identifiers on the HTTP and TLS ports
+	ValidationTargets map[string]va.ValidationTarget
+	// Garbage collection of expired orders, authorizations, challenges and
+	// nonces
+	GarbageCollection wfe.GCConfig
+	// File the audit log of ACME requests is appended to
+	AuditLog string
+	// File structured access log lines of ACME requests are appended to, or
+	// "-" for stdout
+	AccessLog string
+	// OpenTelemetry collector that traces are exported to
+	Tracing trace.Config
+	// Serve pprof profiles and runtime statistics on the management interface
+	DebugEndpoints bool
+	// Serve Prometheus metrics of the store on the management interface
+	Metrics bool
+	// Settings for benchmarking ACME clients at scale
+	LoadTest LoadTestConfig
+	// Bearer tokens and TLS client certificates required for management
+	// requests
+	ManagementAuth ManagementAuthConfig
+	// TLS versions, cipher suites and HTTP protocols of the ACME listener
+	TLS TLSConfig
+	// Additional listeners of the ACME and management interfaces, e.g. on
+	// other interfaces. The URLs of resources use the host of the requests
+	// or the address of the primary listener, which they require.
+	ListenAddresses           []ListenerConfig
+	ManagementListenAddresses []ListenerConfig
+	// UDP address of an HTTP/3 listener for ACME requests, advertised by
+	// Alt-Svc headers of the ACME listener, e.g. "0.0.0.0:14000"
+	HTTP3ListenAddress string
+	// Base URLs of the ACME and management interfaces used in the URLs of
+	// resources when Pebble is behind a reverse proxy, e.g.
+	// "https://acme.example.com/pebble". URLs use the host of the requests if
+	// they are empty.
+	ExternalURL           string
+	ExternalManagementURL string
+	// Octal file mode of Unix domain socket listeners, e.g. "0660"
+	UnixSocketMode string
+	// Seconds the pebble command waits for requests in flight to complete
+	// when it is terminated. Defaults to 10.
+	ShutdownGracePeriod int
+	// Webhooks notified of order, authorization and certificate events
+	Webhooks []webhook.Config
+	// Mock CT logs that precertificates are submitted to
+	CTLogs []ct.LogConfig
+	// Checks of ACME requests against RFC 8555. The -strict flag enables
+	// all of them.
+	Strict wfe.StrictConfig
+	// Account keys and JWS algorithms accepted in ACME requests
+	KeyPolicy wfe.KeyPolicy
+	// Experimental ML-DSA account and certificate keys, only supported by
+	// Pebble built with Go 1.27 or later
+	PostQuantum wfe.PostQuantumConfig
+	// Lifetime of nonces and rules rejecting the nonces of specific accounts
+	// and endpoints
+	Nonces wfe.NonceConfig
+	// Checks of the CSRs of finalize requests
+	CSRPolicy wfe.CSRPolicy
+	// Checks of the contacts of newAccount and account update requests
+	ContactPolicy wfe.ContactPolicy
+	// Reason codes accepted in revocation requests
+	Revocation wfe.RevocationConfig
+	// Revocation of every certificate issued some time after issuance
+	ScheduledRevocation wfe.ScheduledRevocationConfig
+	// Suggested renewal window of the renewalInfo of certificates
+	ARI wfe.ARIConfig
+	// Retry-After headers of the responses for polled orders and
+	// authorizations
+	RetryAfter wfe.RetryAfterConfig
+	// Limits of ACME STAR recurrent orders advertised in the directory
+	STAR wfe.STARConfig
+	// Number of alternate roots cross-signing the issuing intermediate
+	AlternateRoots int
+	// Number of intermediates of each chain. Defaults to one.
+	ChainLength int
+	// Consider all challenges valid without making validation requests
+	AlwaysValid bool
+	// Validate challenges without a random sleep
+	NoSleep bool
+	// Maximum seconds of the random sleep before validations. Defaults to
+	// five.
+	SleepTime int
+
+	// Custom DNS server used to resolve the identifiers of challenges, e.g.
+	// "127.0.0.1:8053", overriding the address of the DNSResolver. A comma
+	// separated list overrides its failover addresses too. The system
+	// resolver is used if both are empty.
+	DNSServer string `json:"-"`
+	// Accounts, External Account Binding keys, authorizations and
+	// certificates loaded into the database before the server serves
+	// requests, if not nil
+	Fixtures *wfe.Fixtures `json:"-"`
+	// Logger of the server. Defaults to logging to stdout.
+	Logger *log.Logger `json:"-"`
+}
+
+// Server is a Pebble ACME server with its management interface.
+type Server struct {
+	config Config
+	log    *log.Logger
+	clk    *clock.FakeClock
+	db     *db.MemoryStore
+	ca     *ca.CAImpl
+	va     *va.VAImpl
+	wfe    *wfe.WebFrontEndImpl
+
+	handler           *http.ServeMux
+	managementHandler *http.ServeMux
+	// The WFE's handlers, which Reload replaces with those of a reconfigured
+	// copy of the WFE
+	wfeHandler           *reloadableHandler
+	wfeManagementHandler *reloadableHandler
+	// The authentication of management requests, nil if there is none
+	managementAuth *managementAuth
+	// The pool the CA keys of the server and its tenants are taken from,
+	// nil if there is none
+	keyPool *ca.KeyPool
+	// reloadMu serializes reloads, which replace the WFE
+	reloadMu         sync.Mutex
+	acmeServer       *http.Server
+	managementServer *http.Server
+	http3Server      *http3.Server
+	ocspStapler      *ocspStapler
+
+	// The additional listeners of the ACME and management interfaces
+	acmeListeners       []*extraListener
+	managementListeners []*extraListener
+
+	// Tenants are isolated servers served under a path prefix on the
+	// listeners of their parent, or on their own listeners
+	parent     *Server
+	pathPrefix string
+	tenants    map[string]*Server
+
+	// File mode of Unix domain socket listeners
+	socketMode os.FileMode
+
+	// The addresses the servers listen on, set by Start
+	mu             sync.Mutex
+	acmeAddr       string
+	managementAddr string
+	started        bool
+	errs           chan error
+}
+
+// NewServer creates a Pebble server from the given configuration. Call Start
+// to start serving requests.
+func NewServer(config Config) (*Server, error) {
+	return newServer(config, "", nil)
+}
+
+// newServer creates a server whose handlers are served under the path prefix.
+// Servers with a path prefix don't listen themselves, they are served by the
+// server they are a tenant of. The CA keys are taken from the key pool of the
+// parent, if not nil, or from a key pool of the server's own if configured
+// and the parent's has keys of another algorithm.
+func newServer(config Config, pathPrefix string, keyPool *ca.KeyPool) (*Server, error) {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stdout, "Pebble ", log.LstdFlags)
+	}
+	if err := config.LoadTest.validate(); err != nil {
+		return nil, fmt.Errorf("configuring load testing: %s", err)
+	}
+	config = config.LoadTest.apply(config)
+	chainLength := config.ChainLength
+	if chainLength == 0 {
+		chainLength = 1
+	}
+	if config.AlternateRoots < 0 || chainLength < 0 {
+		return nil, errors.New("alternate roots and chain length must not be negative")
+	}
+
+	socketMode, err := parseSocketMode(config.UnixSocketMode)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Unix sockets: %s", err)
+	}
+	managementURL := "https://" + urlHost(config.ManagementListenAddress)
+	if config.ExternalManagementURL != "" {
+		managementURL = strings.TrimSuffix(config.ExternalManagementURL, "/")
+	}
+	urls := config.CertificateURLs.WithManagementURL(managementURL + pathPrefix)
+
+	// A fake clock lets the management interface move time forward
+	clk := clock.NewFake()
+	webhooks, err := webhook.New(logger, clk, config.Webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("configuring webhooks: %s", err)
+	}
+	tracer, err := trace.New(logger, config.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("configuring tracing: %s", err)
+	}
+	store := db.NewMemoryStore(clk)
+	var registry *metrics.Registry
+	if config.Metrics {
+		registry = metrics.NewRegistry()
+		store.SetMetrics(registry)
+	}
+
+	if config.LoadTest.KeyPool > 0 && !keyPool.Generates(config.CAKeyAlgorithm) {
+		keyPool, err = ca.NewKeyPool(logger, config.CAKeyAlgorithm, config.LoadTest.KeyPool)
+		if err != nil {
+			return nil, fmt.Errorf("creating CA key pool: %s", err)
+		}
+	}
+	caImpl, err := ca.New(logger, clk, store, config.OCSPResponderURL, config.AlternateRoots, chainLength,
+		config.CAHierarchy, config.CAKeyAlgorithm, keyPool, urls)
+	if err != nil {
+		return nil, fmt.Errorf("creating CA: %s", err)
+	}
+	for _, alt := range config.AlternateChains {
+		if _, err := caImpl.AddAlternateChain(alt); err != nil {
+			return nil, fmt.Errorf("adding alternate chain: %s", err)
+		}
+	}
+	if err := caImpl.SetLeafSignatureAlgorithm(config.LeafSignatureAlgorithm); err != nil {
+		return nil, fmt.Errorf("configuring leaf signature algorithm: %s", err)
+	}
+	if err := caImpl.SetProfiles(config.Profiles); err != nil {
+		return nil, fmt.Errorf("configuring certificate profiles: %s", err)
+	}
+	if err := caImpl.SetShortLivedValidityPeriod(config.ShortLivedValidityPeriod); err != nil {
+		return nil, fmt.Errorf("configuring short-lived mode: %s", err)
+	}
+	if err := caImpl.SetCTLogs(config.CTLogs); err != nil {
+		return nil, fmt.Errorf("configuring CT logs: %s", err)
+	}
+	if err := caImpl.SetSerialConfig(config.Serials); err != nil {
+		return nil, fmt.Errorf("configuring serial numbers: %s", err)
+	}
+	if err := caImpl.SetIssuanceDelay(config.IssuanceDelay); err != nil {
+		return nil, fmt.Errorf("configuring issuance delay: %s", err)
+	}
+	caImpl.SetWebhooks(webhooks)
+	caImpl.SetTracer(tracer)
+
+	vaImpl := va.New(logger, clk, config.HTTPPort, config.TLSPort, config.Strict.Enabled)
+	resolverConfig := config.DNSResolver
+	if config.DNSServer != "" {
+		addresses := strings.Split(config.DNSServer, ",")
+		resolverConfig.Address, resolverConfig.FailoverAddresses = addresses[0], addresses[1:]
+	}
+	if err := vaImpl.SetDNSResolver(resolverConfig); err != nil {
+		return nil, fmt.Errorf("configuring the DNS resolver: %s", err)
+	}
+	if err := registerChallengeTypes(vaImpl, config); err != nil {
+		return nil, fmt.Errorf("configuring challenge types: %s", err)
+	}
+	if err := vaImpl.SetChallengeTimings(config.ChallengeTimings); err != nil {
+		return nil, fmt.Errorf("configuring challenge validation timings: %s", err)
+	}
+	if err := vaImpl.SetCAA(config.CAA); err != nil {
+		return nil, fmt.Errorf("configuring CAA checks: %s", err)
+	}
+	if err := vaImpl.SetMultiPerspective(config.MultiPerspective); err != nil {
+		return nil, fmt.Errorf("configuring multi-perspective validation: %s", err)
+	}
+	if err := vaImpl.SetRedirectPolicy(config.HTTPRedirects); err != nil {
+		return nil, fmt.Errorf("configuring HTTP-01 redirects: %s", err)
+	}
+	if err := vaImpl.SetProxy(config.ValidationProxy); err != nil {
+		return nil, fmt.Errorf("configuring the validation proxy: %s", err)
+	}
+	if err := vaImpl.SetAddressFamily(config.ValidationAddressFamily); err != nil {
+		return nil, fmt.Errorf("configuring the validation address family: %s", err)
+	}
+	if err := vaImpl.SetTransientRetries(config.TransientRetries); err != nil {
+		return nil, fmt.Errorf("configuring transient validation retries: %s", err)
+	}
+	if err := vaImpl.SetExternalValidators(config.ExternalValidators); err != nil {
+		return nil, fmt.Errorf("configuring external validators: %s", err)
+	}
+	if err := vaImpl.SetValidationTargets(config.ValidationTargets); err != nil {
+		return nil, fmt.Errorf("configuring validation targets: %s", err)
+	}
+	vaImpl.SetAlwaysValid(config.AlwaysValid)
+	vaImpl.SetNoSleep(config.NoSleep)
+	if err := vaImpl.SetSleepTime(config.SleepTime); err != nil {
+		return nil, fmt.Errorf("configuring the VA sleep time: %s", err)
+	}
+	vaImpl.SetWebhooks(webhooks)
+	vaImpl.SetStore(store)
+	vaImpl.SetTracer(tracer)
+
+	for keyID, key := range config.ExternalAccountMACKeys {
+		if err := store.AddExternalAccountKeyByID(keyID, key); err != nil {
+			return nil, fmt.Errorf("adding external account binding key %q: %s", keyID, err)
+		}
+	}
+	if err := loadBlockedDomains(store, config.BlockedDomainsFile); err != nil {
+		return nil, err
+	}
+
+	wfeImpl := wfe.New(logger, clk, store, vaImpl, caImpl, config.Strict.Enabled, config.ExternalAccountBindingRequired)
+	wfeImpl.SetStrict(config.Strict)
+	if err := wfeImpl.SetChallengeTypes(wfeChallengeTypes(config)); err != nil {
+		return nil, fmt.Errorf("configuring challenge types: %s", err)
+	}
+	if err := wfeImpl.SetOfferedChallenges(config.OfferedChallenges); err != nil {
+		return nil, fmt.Errorf("configuring offered challenges: %s", err)
+	}
+	if err := wfeImpl.SetAuthzReusePolicy(config.AuthzReusePolicy); err != nil {
+		return nil, fmt.Errorf("configuring authz reuse: %s", err)
+	}
+	if err := wfeImpl.SetWildcardPolicy(config.WildcardPolicy); err != nil {
+		return nil, fmt.Errorf("configuring the wildcard policy: %s", err)
+	}
+	if err := wfeImpl.SetChainPerturbation(config.ChainPerturbation); err != nil {
+		return nil, fmt.Errorf("configuring chain perturbation: %s", err)
+	}
+	if err := wfeImpl.SetRootInChain(config.RootInChain); err != nil {
+		return nil, fmt.Errorf("configuring the root in chain mode: %s", err)
+	}
+	if err := wfeImpl.SetIdentifierPolicy(config.IdentifierPolicy); err != nil {
+		return nil, fmt.Errorf("configuring the identifier policy: %s", err)
+	}
+	if err := wfeImpl.SetDirectoryMeta(config.DirectoryMeta); err != nil {
+		return nil, fmt.Errorf("configuring the directory meta: %s", err)
+	}
+	wfeImpl.SetTermsOfServiceAgreement(config.TermsOfServiceAgreement)
+	wfeImpl.SetDelegations(config.Delegations)
+	wfeImpl.SetSubdomainAuthMaxDepth(config.SubdomainAuthMaxDepth)
+	wfeImpl.SetValidityPolicy(config.ValidityPolicy)
+	wfeImpl.SetOrdersPerPage(config.OrdersPerPage)
+	wfeImpl.SetIdentifierLimits(config.IdentifierLimits)
+	wfeImpl.SetRateLimits(config.RateLimits)
+	if err := wfeImpl.SetFaults(config.Faults); err != nil {
+		return nil, fmt.Errorf("configuring fault injection: %s", err)
+	}
+	if err := wfeImpl.SetCORS(config.CORS); err != nil {
+		return nil, fmt.Errorf("configuring CORS: %s", err)
+	}
+	if err := wfeImpl.SetCompression(config.Compression); err != nil {
+		return nil, fmt.Errorf("configuring compression: %s", err)
+	}
+	if err := wfeImpl.SetKeyPolicy(config.KeyPolicy); err != nil {
+		return nil, fmt.Errorf("configuring key policy: %s", err)
+	}
+	if err := wfeImpl.SetPostQuantum(config.PostQuantum); err != nil {
+		return nil, fmt.Errorf("configuring post-quantum support: %s", err)
+	}
+	if err := wfeImpl.SetNonces(config.Nonces); err != nil {
+		return nil, fmt.Errorf("configuring nonces: %s", err)
+	}
+	if err := wfeImpl.SetCSRPolicy(config.CSRPolicy); err != nil {
+		return nil, fmt.Errorf("configuring CSR policy: %s", err)
+	}
+	if err := wfeImpl.SetContactPolicy(config.ContactPolicy); err != nil {
+		return nil, fmt.Errorf("configuring contact policy: %s", err)
+	}
+	if err := wfeImpl.SetRevocation(config.Revocation); err != nil {
+		return nil, fmt.Errorf("configuring revocation: %s", err)
+	}
+	if err := wfeImpl.SetScheduledRevocation(config.ScheduledRevocation); err != nil {
+		return nil, fmt.Errorf("configuring scheduled revocation: %s", err)
+	}
+	if err := wfeImpl.SetARI(config.ARI); err != nil {
+		return nil, fmt.Errorf("configuring ARI: %s", err)
+	}
+	if err := wfeImpl.SetRetryAfter(config.RetryAfter); err != nil {
+		return nil, fmt.Errorf("configuring Retry-After: %s", err)
+	}
+	if err := wfeImpl.SetSTAR(config.STAR); err != nil {
+		return nil, fmt.Errorf("configuring STAR: %s", err)
+	}
+	if err := wfeImpl.SetFinalize(config.Finalize); err != nil {
+		return nil, fmt.Errorf("configuring finalize workers: %s", err)
+	}
+	if err := wfeImpl.SetExternalAccountKeyPolicies(config.ExternalAccountKeyPolicies); err != nil {
+		return nil, fmt.Errorf("configuring external account binding key policies: %s", err)
+	}
+	if err := wfeImpl.SetGarbageCollection(config.GarbageCollection); err != nil {
+		return nil, fmt.Errorf("configuring garbage collection: %s", err)
+	}
+	wfeImpl.SetWebhooks(webhooks)
+	wfeImpl.SetTracer(tracer)
+	wfeImpl.SetDebugEndpoints(config.DebugEndpoints)
+	if registry != nil {
+		wfeImpl.SetMetrics(registry)
+	}
+	if err := wfeImpl.SetIssuanceRateReport(config.LoadTest.ReportInterval); err != nil {
+		return nil, fmt.Errorf("configuring issuance rate reports: %s", err)
+	}
+	wfeImpl.SetPathPrefix(pathPrefix)
+	if err := wfeImpl.SetExternalURLs(config.ExternalURL, config.ExternalManagementURL); err != nil {
+		return nil, fmt.Errorf("configuring external URLs: %s", err)
+	}
+	if err := wfeImpl.SetAuditLog(config.AuditLog); err != nil {
+		return nil, fmt.Errorf("configuring audit log: %s", err)
+	}
+	if err := wfeImpl.SetAccessLog(config.AccessLog); err != nil {
+		return nil, fmt.Errorf("configuring access log: %s", err)
+	}
+
+	s := &Server{
+		config: config,
+		log:    logger,
+		clk:    clk,
+		db:     store,
+		ca:     caImpl,
+		va:     vaImpl,
+		wfe:    &wfeImpl,
+
+		keyPool:              keyPool,
+		handler:              http.NewServeMux(),
+		managementHandler:    http.NewServeMux(),
+		wfeHandler:           newReloadableHandler(wfeImpl.Handler()),
+		wfeManagementHandler: newReloadableHandler(wfeImpl.ManagementHandler()),
+		pathPrefix:           pathPrefix,
+		socketMode:           socketMode,
+		tenants:              make(map[string]*Server),
+		errs:                 make(chan error, 3),
+	}
+	s.handler.Handle("/", s.wfeHandler)
+	// Path prefixed tenants are authenticated by the server they are a
+	// tenant of
+	s.managementAuth, err = newManagementAuth(config.ManagementAuth)
+	if err != nil {
+		return nil, fmt.Errorf("configuring management authentication: %s", err)
+	}
+	if pathPrefix != "" {
+		s.managementHandler.Handle("/", s.wfeManagementHandler)
+		return s, nil
+	}
+	s.managementHandler.Handle("/", s.managementAuth.protect(s.wfeManagementHandler))
+	if config.ListenAddress != "" {
+		wfeImpl.AddListener("acme")
+		acmeHandler := externalPathHandler(config.ExternalURL, s.handler)
+		s.acmeServer = &http.Server{Handler: acmeHandler}
+		if err := config.TLS.apply(s.acmeServer); err != nil {
+			return nil, fmt.Errorf("configuring TLS: %s", err)
+		}
+		if config.TLS.OCSPStapling || config.TLS.OCSPResponse != "" {
+			s.ocspStapler, err = newOCSPStapler(logger, config.Certificate, config.PrivateKey, config.TLS.OCSPResponse)
+			if err != nil {
+				return nil, fmt.Errorf("configuring OCSP stapling: %s", err)
+			}
+			s.acmeServer.TLSConfig.GetCertificate = s.ocspStapler.getCertificate
+			s.acmeServer.RegisterOnShutdown(s.ocspStapler.shutdown)
+		}
+		if config.HTTP3ListenAddress != "" {
+			if err := s.newHTTP3Server(acmeHandler); err != nil {
+				return nil, fmt.Errorf("configuring HTTP/3: %s", err)
+			}
+		}
+		for _, listenerConfig := range config.ListenAddresses {
+			l, err := newExtraListener(listenerConfig, acmeHandler, s.acmeServer, config.Certificate, config.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("configuring ACME listener %q: %s", listenerConfig.Address, err)
+			}
+			wfeImpl.AddListener("acme " + listenerConfig.Address)
+			s.acmeListeners = append(s.acmeListeners, l)
+		}
+	} else if len(config.ListenAddresses) > 0 {
+		return nil, errors.New("additional ACME listeners require a listen address")
+	}
+	if config.ManagementListenAddress != "" {
+		wfeImpl.AddListener("management")
+		managementHandler := externalPathHandler(config.ExternalManagementURL, s.managementHandler)
+		s.managementServer = &http.Server{Handler: managementHandler}
+		for _, listenerConfig := range config.ManagementListenAddresses {
+			l, err := newExtraListener(listenerConfig, managementHandler, s.managementServer, config.Certificate, config.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("configuring management listener %q: %s", listenerConfig.Address, err)
+			}
+			wfeImpl.AddListener("management " + listenerConfig.Address)
+			s.managementListeners = append(s.managementListeners, l)
+		}
+		if s.managementAuth.requestsClientCerts() {
+			s.requestManagementClientCerts()
+		}
+	} else if len(config.ManagementListenAddresses) > 0 {
+		return nil, errors.New("additional management listeners require a management listen address")
+	}
+	return s, nil
+}
+
+// requestManagementClientCerts makes the management listeners ask TLS
+// clients for certificates.
+func (s *Server) requestManagementClientCerts() {
+	requestClientCerts(s.managementServer)
+	for _, l := range s.managementListeners {
+		requestClientCerts(l.server)
+	}
+}
+
+// externalPathHandler strips the path of an external URL from the requests
+// to the handler, if the reverse proxy in front of Pebble didn't.
+func externalPathHandler(externalURL string, handler http.Handler) http.Handler {
+	u, err := url.Parse(externalURL)
+	if err != nil {
+		return handler
+	}
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if basePath == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(basePath, handler)
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == basePath || strings.HasPrefix(request.URL.Path, basePath+"/") {
+			stripped.ServeHTTP(response, request)
+			return
+		}
+		handler.ServeHTTP(response, request)
+	})
+}
+
+// AddTenant adds an isolated ACME directory with its own store, CA hierarchy
+// and policies to the server. A tenant without a listen address is served on
+// the server's listeners under the path prefix "/<name>", e.g. its directory
+// is at "/<name>/dir". A tenant with a listen address is served on its own
+// listeners, which the server starts and shuts down. AddTenant must be called
+// before Start.
+func (s *Server) AddTenant(name string, config Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("server already started")
+	}
+	if s.parent != nil {
+		return errors.New("tenants can't have tenants")
+	}
+	if name == "" || strings.ContainsAny(name, "/?#%") {
+		return fmt.Errorf("invalid tenant name %q", name)
+	}
+	if _, ok := s.tenants[name]; ok {
+		return fmt.Errorf("duplicate tenant %q", name)
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = s.log
+	}
+	config.Logger = log.New(logger.Writer(), fmt.Sprintf("%s[%s] ", logger.Prefix(), name), logger.Flags())
+
+	if config.ListenAddress != "" {
+		tenant, err := newServer(config, "", s.keyPool)
+		if err != nil {
+			return fmt.Errorf("creating tenant %q: %s", name, err)
+		}
+		tenant.parent = s
+		tenant.errs = s.errs
+		s.tenants[name] = tenant
+		return nil
+	}
+
+	prefix := "/" + name
+	if wfe.IsEndpointPath(prefix) {
+		return fmt.Errorf("tenant name %q conflicts with the path of an endpoint", name)
+	}
+	config.ListenAddress = s.config.ListenAddress
+	config.ManagementListenAddress = s.config.ManagementListenAddress
+	tenant, err := newServer(config, prefix, s.keyPool)
+	if err != nil {
+		return fmt.Errorf("creating tenant %q: %s", name, err)
+	}
+	tenant.parent = s
+	s.tenants[name] = tenant
+	s.handler.Handle(prefix+"/", http.StripPrefix(prefix, tenant.handler))
+	// The credentials of the server are accepted by its tenants too, which
+	// may accept their own
+	var tenantManagement http.Handler = tenant.managementHandler
+	if tenant.managementAuth != nil {
+		tenantManagement = tenant.managementAuth.protect(tenantManagement, s.managementAuth)
+	} else {
+		tenantManagement = s.managementAuth.protect(tenantManagement)
+	}
+	s.managementHandler.Handle(prefix+"/", http.StripPrefix(prefix, tenantManagement))
+	if tenant.managementAuth.requestsClientCerts() && s.managementServer != nil {
+		s.requestManagementClientCerts()
+	}
+	return nil
+}
+
+// Reload applies the policies of a changed configuration while the server
+// keeps serving requests, without losing its accounts, orders, certificates
+// and CA hierarchy. The reloaded settings are the validity policy, identifier
+// and rate limits, faults, delegations, subdomain authorization depth, orders
+// per page, External Account Binding requirement, profiles, short-lived mode,
+// issuance delay, finalize workers and challenge timings. Alternate chains and
+// External Account Binding keys that weren't configured before are added.
+// Other settings, e.g. the listen addresses or the CA hierarchy, are ignored.
+// Requests in flight complete with the previous policies. Reload stops at the
+// first invalid setting, leaving the settings reloaded before it in effect.
+func (s *Server) Reload(config Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// The load testing mode can't be reloaded, but keeps overriding the
+	// settings that are
+	config = s.config.LoadTest.apply(config)
+
+	// The WFE's settings are applied to a copy, which replaces the WFE once
+	// all are valid
+	wfeImpl := s.wfe.Clone()
+	wfeImpl.SetDelegations(config.Delegations)
+	wfeImpl.SetSubdomainAuthMaxDepth(config.SubdomainAuthMaxDepth)
+	wfeImpl.SetValidityPolicy(config.ValidityPolicy)
+	wfeImpl.SetOrdersPerPage(config.OrdersPerPage)
+	wfeImpl.SetIdentifierLimits(config.IdentifierLimits)
+	wfeImpl.SetRateLimits(config.RateLimits)
+	wfeImpl.SetExternalAccountBindingRequired(config.ExternalAccountBindingRequired)
+	wfeImpl.SetStrict(config.Strict)
+	if err := wfeImpl.SetDirectoryMeta(config.DirectoryMeta); err != nil {
+		return fmt.Errorf("configuring the directory meta: %s", err)
+	}
+	wfeImpl.SetTermsOfServiceAgreement(config.TermsOfServiceAgreement)
+	if err := wfeImpl.SetOfferedChallenges(config.OfferedChallenges); err != nil {
+		return fmt.Errorf("configuring offered challenges: %s", err)
+	}
+	if err := wfeImpl.SetAuthzReusePolicy(config.AuthzReusePolicy); err != nil {
+		return fmt.Errorf("configuring authz reuse: %s", err)
+	}
+	if err := wfeImpl.SetWildcardPolicy(config.WildcardPolicy); err != nil {
+		return fmt.Errorf("configuring the wildcard policy: %s", err)
+	}
+	if err := wfeImpl.SetChainPerturbation(config.ChainPerturbation); err != nil {
+		return fmt.Errorf("configuring chain perturbation: %s", err)
+	}
+	if err := wfeImpl.SetRootInChain(config.RootInChain); err != nil {
+		return fmt.Errorf("configuring the root in chain mode: %s", err)
+	}
+	if err := wfeImpl.SetIdentifierPolicy(config.IdentifierPolicy); err != nil {
+		return fmt.Errorf("configuring the identifier policy: %s", err)
+	}
+	if err := wfeImpl.SetFaults(config.Faults); err != nil {
+		return fmt.Errorf("configuring fault injection: %s", err)
+	}
+	if err := wfeImpl.SetCORS(config.CORS); err != nil {
+		return fmt.Errorf("configuring CORS: %s", err)
+	}
+	if err := wfeImpl.SetCompression(config.Compression); err != nil {
+		return fmt.Errorf("configuring compression: %s", err)
+	}
+	if err := wfeImpl.SetKeyPolicy(config.KeyPolicy); err != nil {
+		return fmt.Errorf("configuring key policy: %s", err)
+	}
+	if err := wfeImpl.SetPostQuantum(config.PostQuantum); err != nil {
+		return fmt.Errorf("configuring post-quantum support: %s", err)
+	}
+	if err := wfeImpl.SetNonces(config.Nonces); err != nil {
+		return fmt.Errorf("configuring nonces: %s", err)
+	}
+	if err := wfeImpl.SetCSRPolicy(config.CSRPolicy); err != nil {
+		return fmt.Errorf("configuring CSR policy: %s", err)
+	}
+	if err := wfeImpl.SetContactPolicy(config.ContactPolicy); err != nil {
+		return fmt.Errorf("configuring contact policy: %s", err)
+	}
+	if err := wfeImpl.SetRevocation(config.Revocation); err != nil {
+		return fmt.Errorf("configuring revocation: %s", err)
+	}
+	if err := wfeImpl.SetScheduledRevocation(config.ScheduledRevocation); err != nil {
+		return fmt.Errorf("configuring scheduled revocation: %s", err)
+	}
+	if err := wfeImpl.SetARI(config.ARI); err != nil {
+		return fmt.Errorf("configuring ARI: %s", err)
+	}
+	if err := wfeImpl.SetRetryAfter(config.RetryAfter); err != nil {
+		return fmt.Errorf("configuring Retry-After: %s", err)
+	}
+	if err := wfeImpl.SetSTAR(config.STAR); err != nil {
+		return fmt.Errorf("configuring STAR: %s", err)
+	}
+	if err := wfeImpl.SetFinalize(config.Finalize); err != nil {
+		return fmt.Errorf("configuring finalize workers: %s", err)
+	}
+
+	if err := s.ca.SetShortLivedValidityPeriod(config.ShortLivedValidityPeriod); err != nil {
+		return fmt.Errorf("configuring short-lived mode: %s", err)
+	}
+	if err := s.ca.SetProfiles(config.Profiles); err != nil {
+		return fmt.Errorf("configuring certificate profiles: %s", err)
+	}
+	if err := s.ca.SetIssuanceDelay(config.IssuanceDelay); err != nil {
+		return fmt.Errorf("configuring issuance delay: %s", err)
+	}
+	for len(s.config.AlternateChains) < len(config.AlternateChains) {
+		alt := config.AlternateChains[len(s.config.AlternateChains)]
+		if _, err := s.ca.AddAlternateChain(alt); err != nil {
+			return fmt.Errorf("adding alternate chain: %s", err)
+		}
+		s.config.AlternateChains = append(s.config.AlternateChains, alt)
+	}
+	if err := s.va.SetChallengeTimings(config.ChallengeTimings); err != nil {
+		return fmt.Errorf("configuring challenge validation timings: %s", err)
+	}
+	if err := s.va.SetCAA(config.CAA); err != nil {
+		return fmt.Errorf("configuring CAA checks: %s", err)
+	}
+	if err := s.va.SetMultiPerspective(config.MultiPerspective); err != nil {
+		return fmt.Errorf("configuring multi-perspective validation: %s", err)
+	}
+	if err := s.va.SetRedirectPolicy(config.HTTPRedirects); err != nil {
+		return fmt.Errorf("configuring HTTP-01 redirects: %s", err)
+	}
+	if err := s.va.SetProxy(config.ValidationProxy); err != nil {
+		return fmt.Errorf("configuring the validation proxy: %s", err)
+	}
+	if err := s.va.SetAddressFamily(config.ValidationAddressFamily); err != nil {
+		return fmt.Errorf("configuring the validation address family: %s", err)
+	}
+	if err := s.va.SetTransientRetries(config.TransientRetries); err != nil {
+		return fmt.Errorf("configuring transient validation retries: %s", err)
+	}
+	if err := s.va.SetExternalValidators(config.ExternalValidators); err != nil {
+		return fmt.Errorf("configuring external validators: %s", err)
+	}
+	if err := s.va.SetValidationTargets(config.ValidationTargets); err != nil {
+		return fmt.Errorf("configuring validation targets: %s", err)
+	}
+	for keyID, key := range config.ExternalAccountMACKeys {
+		if s.db.GetExternalAccountKeyByID(keyID) != nil {
+			continue
+		}
+		if err := s.db.AddExternalAccountKeyByID(keyID, key); err != nil {
+			return fmt.Errorf("adding external account binding key %q: %s", keyID, err)
+		}
+	}
+	if err := loadBlockedDomains(s.db, config.BlockedDomainsFile); err != nil {
+		return err
+	}
+	if err := wfeImpl.SetExternalAccountKeyPolicies(config.ExternalAccountKeyPolicies); err != nil {
+		return fmt.Errorf("configuring external account binding key policies: %s", err)
+	}
+
+	s.wfeHandler.set(wfeImpl.Handler())
+	s.wfeManagementHandler.set(wfeImpl.ManagementHandler())
+	s.wfe = wfeImpl
+	s.log.Printf("Reloaded configuration")
+	return nil
+}
+
+// loadBlockedDomains replaces the blocked domains of the store with those of
+// the file, or removes all of them if there is no file.
+func loadBlockedDomains(store *db.MemoryStore, file string) error {
+	var domains []core.BlockedDomain
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("loading blocked domains: %s", err)
+		}
+		defer f.Close()
+		domains, err = db.ParseBlockedDomains(f)
+		if err != nil {
+			return fmt.Errorf("loading blocked domains from %s: %s", file, err)
+		}
+	}
+	return store.SetBlockedDomains(domains)
+}
+
+// reloadableHandler serves requests with a handler that can be replaced while
+// requests are served.
+type reloadableHandler struct {
+	handler atomic.Value
+}
+
+func newReloadableHandler(handler http.Handler) *reloadableHandler {
+	h := &reloadableHandler{}
+	h.set(handler)
+	return h
+}
+
+func (h *reloadableHandler) set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+func (h *reloadableHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	(*h.handler.Load().(*http.Handler)).ServeHTTP(response, request)
+}
+
+// Tenant returns the tenant with the given name, or nil if there is none.
+func (s *Server) Tenant(name string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tenants[name]
+}
+
+// Start starts serving ACME requests and, if configured, management requests
+// in the background. The listen addresses may use port 0 to listen on any
+// free port, DirectoryURL and ManagementURL return the actual addresses.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("server already started")
+	}
+	if s.acmeServer == nil {
+		return errors.New("no ACME listen address configured")
+	}
+
+	acmeListener, err := listen(s.config.ListenAddress, "", s.socketMode)
+	if err != nil {
+		return fmt.Errorf("listening on ACME interface: %s", err)
+	}
+	listeners := []net.Listener{acmeListener}
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	var managementListener net.Listener
+	if s.managementServer != nil {
+		managementListener, err = listen(s.config.ManagementListenAddress, "", s.socketMode)
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("listening on management interface: %s", err)
+		}
+		listeners = append(listeners, managementListener)
+	}
+	for _, l := range s.extraListeners() {
+		l.listener, err = listen(l.config.Address, l.config.Family, s.socketMode)
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("listening on %s: %s", l.config.Address, err)
+		}
+		listeners = append(listeners, l.listener)
+	}
+	if s.config.Fixtures != nil {
+		err = s.wfe.LoadFixtures(*s.config.Fixtures, listenAddr(s.config.ListenAddress, acmeListener))
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("loading fixtures: %s", err)
+		}
+	}
+	var http3Addr net.Addr
+	if s.http3Server != nil {
+		http3Addr, err = s.listenHTTP3(s.config.HTTP3ListenAddress)
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("listening on HTTP/3 interface: %s", err)
+		}
+	}
+	s.started = true
+
+	s.acmeAddr = listenAddr(s.config.ListenAddress, acmeListener)
+	s.wfe.SetListening("acme")
+	if s.ocspStapler != nil {
+		go s.ocspStapler.run()
+	}
+	go s.serve(s.acmeServer, acmeListener, s.config.Certificate, s.config.PrivateKey)
+	s.log.Printf("Listening on: %s\n", listenerName(s.acmeAddr, acmeListener))
+	s.serveExtraListeners("acme", s.acmeListeners)
+	if http3Addr != nil {
+		s.log.Printf("HTTP/3 listening on: %s\n", http3Addr)
+	}
+	s.log.Printf("ACME directory available at: https://%s%s", s.acmeAddr, wfe.DirectoryPath)
+
+	if managementListener == nil {
+		s.log.Print("Management interface is disabled")
+		return s.startTenants()
+	}
+	s.managementAddr = listenAddr(s.config.ManagementListenAddress, managementListener)
+	s.wfe.SetListening("management")
+	go s.serve(s.managementServer, managementListener, s.config.Certificate, s.config.PrivateKey)
+	s.log.Printf("Management interface listening on: %s\n", listenerName(s.managementAddr, managementListener))
+	s.serveExtraListeners("management", s.managementListeners)
+	s.log.Printf("Root CA certificate available at: https://%s%s0",
+		s.managementAddr, wfe.RootCertPath)
+	for i := 1; i < s.ca.GetNumberOfRootCerts(); i++ {
+		s.log.Printf("Alternate (%d) root CA certificate available at: https://%s%s%d",
+			i, s.managementAddr, wfe.RootCertPath, i)
+	}
+	return s.startTenants()
+}
+
+// startTenants starts the tenants with their own listeners. The server keeps
+// serving requests if a tenant fails to start, call Shutdown to stop it.
+func (s *Server) startTenants() error {
+	for name, tenant := range s.tenants {
+		if tenant.pathPrefix == "" {
+			if err := tenant.Start(); err != nil {
+				return fmt.Errorf("starting tenant %q: %s", name, err)
+			}
+			continue
+		}
+		s.log.Printf("Tenant %q ACME directory available at: https://%s%s%s",
+			name, s.acmeAddr, tenant.pathPrefix, wfe.DirectoryPath)
+	}
+	return nil
+}
+
+// listenAddr returns the address a listener listens on, using the configured
+// host and the actual port, which differs from the configured one if that is
+// port 0. It is "localhost" for Unix domain sockets.
+func listenAddr(configured string, listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return "localhost"
+	}
+	host, _, err := net.SplitHostPort(configured)
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		return listener.Addr().String()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// listenerName returns the address of a listener for logging, which is the
+// path of Unix domain sockets.
+func listenerName(addr string, listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return unixPrefix + listener.Addr().String()
+	}
+	return addr
+}
+
+// extraListeners returns the additional listeners of the ACME and management
+// interfaces.
+func (s *Server) extraListeners() []*extraListener {
+	return append(append([]*extraListener{}, s.acmeListeners...), s.managementListeners...)
+}
+
+// serveExtraListeners serves the additional listeners of an interface.
+func (s *Server) serveExtraListeners(name string, listeners []*extraListener) {
+	for _, l := range listeners {
+		s.wfe.SetListening(name + " " + l.config.Address)
+		go s.serve(l.server, l.listener, l.certFile, l.keyFile)
+		addr := listenAddr(l.config.Address, l.listener)
+		if name == "acme" {
+			s.log.Printf("Also listening on: %s\n", listenerName(addr, l.listener))
+		} else {
+			s.log.Printf("Management interface also listening on: %s\n", listenerName(addr, l.listener))
+		}
+	}
+}
+
+func (s *Server) serve(server *http.Server, listener net.Listener, certFile, keyFile string) {
+	if server.TLSConfig != nil && server.TLSConfig.GetCertificate != nil {
+		// The certificate is provided by the OCSP stapler
+		certFile, keyFile = "", ""
+	}
+	err := server.ServeTLS(listener, certFile, keyFile)
+	if err != http.ErrServerClosed {
+		s.errs <- err
+	}
+}
+
+// Wait blocks until serving requests fails and returns the error. It blocks
+// forever once the server was shut down.
+func (s *Server) Wait() error {
+	return <-s.errs
+}
+
+// Shutdown stops serving requests, waiting for active requests to complete
+// until the context is done. Background work such as pending validations is
+// not stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.acmeServer != nil {
+		err = s.acmeServer.Shutdown(ctx)
+	}
+	if s.managementServer != nil {
+		if mgmtErr := s.managementServer.Shutdown(ctx); err == nil {
+			err = mgmtErr
+		}
+	}
+	for _, l := range s.extraListeners() {
+		if listenerErr := l.server.Shutdown(ctx); err == nil {
+			err = listenerErr
+		}
+	}
+	if http3Err := s.shutdownHTTP3(ctx); err == nil {
+		err = http3Err
+	}
+	for _, tenant := range s.tenants {
+		if tenantErr := tenant.Shutdown(ctx); err == nil {
+			err = tenantErr
+		}
+	}
+	return err
+}
+
+// Handler returns the handler of ACME requests, to serve them on a listener
+// that isn't managed by the server, e.g. an httptest.Server. The ACME listen
+// address can be empty then.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ManagementHandler returns the handler of management interface requests, to
+// serve them on a listener that isn't managed by the server.
+func (s *Server) ManagementHandler() http.Handler {
+	return s.managementHandler
+}
+
+// DirectoryURL returns the URL of the ACME directory. It is empty until the
+// server was started.
+func (s *Server) DirectoryURL() string {
+	acmeAddr, _ := s.addrs()
+	if acmeAddr == "" {
+		return ""
+	}
+	return "https://" + acmeAddr + s.pathPrefix + wfe.DirectoryPath
+}
+
+// ManagementURL returns the base URL of the management interface. It is empty
+// until the server was started, or if the management interface is disabled.
+func (s *Server) ManagementURL() string {
+	_, managementAddr := s.addrs()
+	if managementAddr == "" {
+		return ""
+	}
+	return "https://" + managementAddr + s.pathPrefix
+}
+
+// addrs returns the addresses of the listeners the server is served on, which
+// are the listeners of its parent for tenants served under a path prefix.
+func (s *Server) addrs() (string, string) {
+	if s.pathPrefix != "" {
+		return s.parent.addrs()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acmeAddr, s.managementAddr
+}
+
+// Store returns the in-memory store of the server's accounts, orders,
+// authorizations, challenges and certificates.
+func (s *Server) Store() *db.MemoryStore {
+	return s.db
+}
+
+// CA returns the server's certificate authority, e.g. to get its root
+// certificates.
+func (s *Server) CA() *ca.CAImpl {
+	return s.ca
+}
+
+// Clock returns the server's clock, which can be moved forward.
+func (s *Server) Clock() *clock.FakeClock {
+	return s.clk
+}
@@ -0,0 +1,303 @@
+package pebbletest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+const (
+	// Pebble rejects a share of valid nonces, requests are retried with a new
+	// nonce up to maxNonceRetries times.
+	maxNonceRetries = 10
+	// Orders and authorizations are polled every pollInterval until they
+	// reach a final state, for at most pollTimeout.
+	pollInterval = 50 * time.Millisecond
+	pollTimeout  = 30 * time.Second
+
+	badNonceProblem = "urn:ietf:params:acme:error:badNonce"
+)
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// client is a minimal ACME client issuing certificates from a Pebble server
+// that considers all challenges valid.
+type client struct {
+	http  *http.Client
+	dir   directory
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+}
+
+func newClient(httpClient *http.Client, directoryURL string) (*client, error) {
+	resp, err := httpClient.Get(directoryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting directory: unexpected status %s", resp.Status)
+	}
+	c := &client{http: httpClient}
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		return nil, fmt.Errorf("decoding directory: %s", err)
+	}
+
+	c.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// issue creates an account, orders a certificate for the domains, answers the
+// http-01 challenges of its authorizations and finalizes it.
+func (c *client) issue(domains []string) (tls.Certificate, error) {
+	if len(domains) == 0 {
+		return tls.Certificate{}, errors.New("no domains")
+	}
+
+	resp, _, err := c.post(c.dir.NewAccount, map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}, nil)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating account: %s", err)
+	}
+	c.kid = resp.Header.Get("Location")
+
+	var identifiers []acme.Identifier
+	for _, domain := range domains {
+		identifiers = append(identifiers, acme.Identifier{Type: acme.IdentifierDNS, Value: domain})
+	}
+	var order acme.Order
+	resp, _, err = c.post(c.dir.NewOrder, map[string]interface{}{
+		"identifiers": identifiers,
+	}, &order)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating order: %s", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range order.Authorizations {
+		if err := c.authorize(authzURL); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating CSR: %s", err)
+	}
+	_, _, err = c.post(order.Finalize, map[string]interface{}{
+		"csr": base64.RawURLEncoding.EncodeToString(csr),
+	}, &order)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("finalizing order: %s", err)
+	}
+	err = c.poll(orderURL, &order, func() (bool, error) {
+		switch order.Status {
+		case acme.StatusValid:
+			return true, nil
+		case acme.StatusInvalid:
+			return false, fmt.Errorf("order %s is invalid: %v", orderURL, order.Error)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	_, chain, err := c.post(order.Certificate, nil, nil)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("getting certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// authorize answers the http-01 challenge of a pending authorization and
+// waits for the authorization to become valid.
+func (c *client) authorize(authzURL string) error {
+	var authz acme.Authorization
+	if _, _, err := c.post(authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("getting authorization: %s", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == acme.ChallengeHTTP01 {
+			challenge = &authz.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("authorization %s has no %s challenge", authzURL, acme.ChallengeHTTP01)
+	}
+	if _, _, err := c.post(challenge.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("answering challenge: %s", err)
+	}
+
+	return c.poll(authzURL, &authz, func() (bool, error) {
+		switch authz.Status {
+		case acme.StatusValid:
+			return true, nil
+		case acme.StatusPending:
+			return false, nil
+		}
+		for _, chall := range authz.Challenges {
+			if chall.Error != nil {
+				return false, fmt.Errorf("authorization %s is %s: %s", authzURL, authz.Status, chall.Error)
+			}
+		}
+		return false, fmt.Errorf("authorization %s is %s", authzURL, authz.Status)
+	})
+}
+
+// poll fetches the object at url into v until done returns true or an error.
+func (c *client) poll(url string, v interface{}, done func() (bool, error)) error {
+	deadline := time.Now().Add(pollTimeout)
+	for {
+		finished, err := done()
+		if err != nil || finished {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out polling %s", url)
+		}
+		time.Sleep(pollInterval)
+		if _, _, err := c.post(url, nil, v); err != nil {
+			return err
+		}
+	}
+}
+
+// post sends a JWS signed POST request with the JSON encoded payload to the
+// URL, or a POST-as-GET request if the payload is nil. A JSON response is
+// decoded into v if it is not nil. It returns the response and its body.
+func (c *client) post(url string, payload interface{}, v interface{}) (*http.Response, []byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		jws, err := c.sign(url, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := c.http.Post(url, "application/jose+json", strings.NewReader(jws))
+		if err != nil {
+			return nil, nil, err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			var prob acme.ProblemDetails
+			if err := json.Unmarshal(respBody, &prob); err != nil || prob.Type == "" {
+				return nil, nil, fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			if prob.Type == badNonceProblem && attempt < maxNonceRetries {
+				continue
+			}
+			return nil, nil, &prob
+		}
+		if v != nil {
+			if err := json.NewDecoder(bytes.NewReader(respBody)).Decode(v); err != nil {
+				return nil, nil, fmt.Errorf("decoding response of %s: %s", url, err)
+			}
+		}
+		return resp, respBody, nil
+	}
+}
+
+// sign returns the flattened JSON serialization of the JWS of the payload for
+// the URL, with the key ID of the account once it was created, or the
+// account's JWK before.
+func (c *client) sign(url string, payload []byte) (string, error) {
+	if c.nonce == "" {
+		resp, err := c.http.Head(c.dir.NewNonce)
+		if err != nil {
+			return "", fmt.Errorf("getting nonce: %s", err)
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	options := &jose.SignerOptions{
+		EmbedJWK: c.kid == "",
+		ExtraHeaders: map[jose.HeaderKey]interface{}{
+			"url":   url,
+			"nonce": c.nonce,
+		},
+	}
+	var key interface{} = c.key
+	if c.kid != "" {
+		key = jose.JSONWebKey{Key: c.key, KeyID: c.kid}
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, options)
+	if err != nil {
+		return "", err
+	}
+	c.nonce = ""
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	// The full serialization omits the empty payload of POST-as-GET requests,
+	// so the flattened JWS is built from the compact serialization
+	compact, err := jws.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(compact, ".")
+	flattened, err := json.Marshal(acme.JSONSigned{
+		Protected: parts[0],
+		Payload:   parts[1],
+		Sig:       parts[2],
+	})
+	return string(flattened), err
+}
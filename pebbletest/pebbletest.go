@@ -0,0 +1,150 @@
+// Package pebbletest runs Pebble on httptest servers for Go tests. It trusts
+// the test servers and Pebble's roots in HTTP clients and TLS configurations,
+// and issues certificates with a single call:
+//
+//	srv := pebbletest.New(t)
+//	defer srv.Close()
+//	cert := srv.IssueCertificate(t, "example.com")
+//
+// Validation of challenges is skipped by default, so certificates can be
+// issued for any identifier without answering challenges.
+package pebbletest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/letsencrypt/pebble"
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+// Server is a Pebble server serving ACME and management requests on httptest
+// servers.
+type Server struct {
+	*pebble.Server
+	// ACME serves ACME requests.
+	ACME *httptest.Server
+	// Management serves the management interface.
+	Management *httptest.Server
+}
+
+// New starts a Pebble server with the default test configuration: challenges
+// are always valid, validations don't sleep and nothing is logged.
+func New(t testing.TB) *Server {
+	return NewWithConfig(t, pebble.Config{
+		AlwaysValid: true,
+		NoSleep:     true,
+	})
+}
+
+// NewWithConfig starts a Pebble server with the given configuration. The
+// listen addresses, certificate and private key of the configuration are
+// ignored, requests are served by httptest servers with their own
+// certificate. Nothing is logged unless the configuration has a logger.
+func NewWithConfig(t testing.TB, config pebble.Config) *Server {
+	t.Helper()
+
+	config.ListenAddress = ""
+	config.ManagementListenAddress = ""
+	config.ListenAddresses = nil
+	config.ManagementListenAddresses = nil
+	if config.Logger == nil {
+		config.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	server, err := pebble.NewServer(config)
+	if err != nil {
+		t.Fatalf("creating Pebble server: %s", err)
+	}
+
+	return &Server{
+		Server:     server,
+		ACME:       httptest.NewTLSServer(server.Handler()),
+		Management: httptest.NewTLSServer(server.ManagementHandler()),
+	}
+}
+
+// Close shuts down the httptest servers.
+func (s *Server) Close() {
+	s.ACME.Close()
+	s.Management.Close()
+}
+
+// DirectoryURL returns the URL of the ACME directory.
+func (s *Server) DirectoryURL() string {
+	return s.ACME.URL + wfe.DirectoryPath
+}
+
+// ManagementURL returns the base URL of the management interface.
+func (s *Server) ManagementURL() string {
+	return s.Management.URL
+}
+
+// Roots returns a pool of Pebble's root certificates, which certificates
+// issued by Pebble chain to.
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	s.addRoots(pool)
+	return pool
+}
+
+func (s *Server) addRoots(pool *x509.CertPool) {
+	for i := 0; i < s.CA().GetNumberOfRootCerts(); i++ {
+		if root := s.CA().GetRootCert(i); root != nil {
+			pool.AddCert(root.Cert)
+		}
+	}
+}
+
+// TrustRoots adds the certificates of the httptest servers and Pebble's root
+// certificates to the root CAs of the given TLS configuration. A nil RootCAs
+// pool is replaced by a pool of just these certificates.
+func (s *Server) TrustRoots(config *tls.Config) {
+	if config.RootCAs == nil {
+		config.RootCAs = x509.NewCertPool()
+	}
+	config.RootCAs.AddCert(s.ACME.Certificate())
+	config.RootCAs.AddCert(s.Management.Certificate())
+	s.addRoots(config.RootCAs)
+}
+
+// TLSConfig returns a TLS configuration trusting the httptest servers and
+// Pebble's roots.
+func (s *Server) TLSConfig() *tls.Config {
+	config := &tls.Config{}
+	s.TrustRoots(config)
+	return config
+}
+
+// Client returns an HTTP client trusting the httptest servers and Pebble's
+// roots, for ACME clients under test and for connecting to servers using
+// certificates issued by Pebble.
+func (s *Server) Client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: s.TLSConfig(),
+		},
+	}
+}
+
+// IssueCertificate creates an account and orders a certificate for the given
+// DNS names, failing the test on errors. The returned certificate includes its
+// chain and private key. It requires challenges to be always valid, as they
+// are with New.
+func (s *Server) IssueCertificate(t testing.TB, domains ...string) tls.Certificate {
+	t.Helper()
+
+	c, err := newClient(s.Client(), s.DirectoryURL())
+	if err != nil {
+		t.Fatalf("creating ACME client: %s", err)
+	}
+	cert, err := c.issue(domains)
+	if err != nil {
+		t.Fatalf("issuing certificate for %v: %s", domains, err)
+	}
+	return cert
+}
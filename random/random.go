@@ -0,0 +1,68 @@
+// Package random is the source of the randomness of Pebble's identifiers,
+// tokens, nonces, serial numbers and simulated behaviours. Random bytes are
+// cryptographically secure unless Pebble runs in deterministic mode, where all
+// randomness is derived from a seed so that test runs can be replayed.
+package random
+
+import (
+	"crypto/rand"
+	"io"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	mu sync.Mutex
+	// source makes the random choices, and provides the random bytes in
+	// deterministic mode
+	source = mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	// deterministic is true if source was seeded with Seed
+	deterministic bool
+)
+
+// Reader is a source of random bytes. It reads from crypto/rand unless Pebble
+// runs in deterministic mode.
+var Reader io.Reader = reader{}
+
+type reader struct{}
+
+func (reader) Read(b []byte) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if deterministic {
+		return source.Read(b)
+	}
+	return rand.Read(b)
+}
+
+// Seed switches to deterministic mode, deriving all randomness from seed. It
+// must be called before Pebble's components are created.
+func Seed(seed int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	source = mrand.New(mrand.NewSource(seed))
+	deterministic = true
+}
+
+// Intn returns a random number in [0,n). It panics if n <= 0.
+func Intn(n int) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return source.Intn(n)
+}
+
+// Float64 returns a random number in [0.0,1.0).
+func Float64() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return source.Float64()
+}
+
+// Shuffle randomizes the order of n elements using swap to swap the elements
+// with indexes i and j.
+func Shuffle(n int, swap func(i, j int)) {
+	mu.Lock()
+	defer mu.Unlock()
+	source.Shuffle(n, swap)
+}
@@ -0,0 +1,128 @@
+package pebble
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// HTTP protocols of TLSConfig, named by their ALPN protocol IDs
+const (
+	protocolHTTP1 = "http/1.1"
+	protocolHTTP2 = "h2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig configures the TLS parameters and HTTP protocols of the ACME
+// listener. The zero value uses Go's defaults and serves HTTP/1.1 and HTTP/2.
+type TLSConfig struct {
+	// Minimum and maximum TLS versions, e.g. "1.2" and "1.3"
+	MinVersion string
+	MaxVersion string
+	// Names of the TLS 1.0 to 1.2 cipher suites, e.g.
+	// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". The TLS 1.3 cipher suites
+	// can't be configured.
+	CipherSuites []string
+	// HTTP protocols that are served, "http/1.1" and "h2". Requests of other
+	// protocols are rejected with a 505 HTTP Version Not Supported response.
+	Protocols []string
+	// Staple OCSP responses fetched from the OCSP responder of the serving
+	// certificate
+	OCSPStapling bool
+	// File of a DER encoded OCSP response that is stapled instead
+	OCSPResponse string
+}
+
+// apply configures the TLS parameters and HTTP protocols of an HTTP server.
+func (c TLSConfig) apply(server *http.Server) error {
+	config := &tls.Config{}
+	var err error
+	if config.MinVersion, err = parseTLSVersion(c.MinVersion); err != nil {
+		return err
+	}
+	if config.MaxVersion, err = parseTLSVersion(c.MaxVersion); err != nil {
+		return err
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return fmt.Errorf("minimum TLS version %s is above the maximum %s", c.MinVersion, c.MaxVersion)
+	}
+	if config.CipherSuites, err = parseCipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
+
+	http1, http2 := len(c.Protocols) == 0, len(c.Protocols) == 0
+	for _, protocol := range c.Protocols {
+		switch protocol {
+		case protocolHTTP1:
+			http1 = true
+		case protocolHTTP2:
+			http2 = true
+		default:
+			return fmt.Errorf("unknown HTTP protocol %q, expected %q or %q", protocol, protocolHTTP1, protocolHTTP2)
+		}
+	}
+	if !http2 {
+		// A non-nil map disables the automatic HTTP/2 support of the server
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	if !http1 {
+		// Go always offers HTTP/1.1 with ALPN, so HTTP/1 requests are
+		// rejected by the handler
+		config.NextProtos = []string{protocolHTTP2}
+		server.Handler = http2Only(server.Handler)
+	}
+	server.TLSConfig = config
+	return nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	suites := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		suite, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suite %q can't be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// http2Only rejects requests that don't use HTTP/2.
+func http2Only(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.ProtoMajor != 2 {
+			response.Header().Set("Connection", "close")
+			http.Error(response, "HTTP/2 is required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		handler.ServeHTTP(response, request)
+	})
+}
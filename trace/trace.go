@@ -0,0 +1,347 @@
+// Package trace implements a minimal OpenTelemetry tracer. Spans are exported
+// in the JSON encoding of OTLP/HTTP to an OpenTelemetry collector, and W3C
+// Trace Context "traceparent" headers are accepted and propagated so that
+// Pebble's spans join the traces of the ACME clients under test. It is meant
+// to show what Pebble does for a request, not to be a complete OpenTelemetry
+// SDK.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds of the OTLP protocol
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+const (
+	traceparentHeader = "traceparent"
+
+	defaultServiceName = "pebble"
+	instrumentationURL = "github.com/letsencrypt/pebble"
+
+	// Spans are exported in batches every exportInterval, or as soon as
+	// exportBatchSize spans ended. At most queueSize spans wait for export,
+	// further spans are dropped.
+	exportInterval  = time.Second
+	exportBatchSize = 256
+	queueSize       = 4096
+	exportTimeout   = 10 * time.Second
+
+	// OTLP status codes
+	statusError = 2
+)
+
+// Config configures the export of spans.
+type Config struct {
+	// Endpoint is the OTLP/HTTP traces URL of an OpenTelemetry collector, e.g.
+	// "http://localhost:4318/v1/traces". Tracing is disabled if it is empty.
+	Endpoint string
+	// ServiceName is the service.name resource attribute of the exported
+	// spans. Defaults to "pebble".
+	ServiceName string
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type spanContextKey struct{}
+
+// Span is an operation of a trace. A nil Span ignores all calls, so callers
+// don't need to check whether tracing is enabled.
+type Span struct {
+	tracer     *Tracer
+	ctx        spanContext
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	mu         sync.Mutex
+	attributes map[string]string
+	err        string
+}
+
+// Tracer creates spans and exports them once they ended. A nil Tracer creates
+// nil spans.
+type Tracer struct {
+	log         *log.Logger
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	spans       chan *exportedSpan
+}
+
+// New creates a Tracer exporting spans to the configured endpoint. It returns
+// nil if tracing is disabled.
+func New(log *log.Logger, config Config) (*Tracer, error) {
+	if config.Endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint %q must be an absolute http or https URL", config.Endpoint)
+	}
+
+	t := &Tracer{
+		log:         log,
+		endpoint:    config.Endpoint,
+		serviceName: defaultServiceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		spans:       make(chan *exportedSpan, queueSize),
+	}
+	if config.ServiceName != "" {
+		t.serviceName = config.ServiceName
+	}
+	go t.export()
+	log.Printf("Exporting traces of service %q to %s", t.serviceName, t.endpoint)
+	return t, nil
+}
+
+// Extract returns a context carrying the remote parent span of the W3C Trace
+// Context "traceparent" header, if the header is present and valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(header.Get(traceparentHeader), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ctx
+	}
+	// Version 00 has exactly four fields, future versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return ctx
+	}
+
+	var sc spanContext
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.traceID) {
+		return ctx
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.spanID) {
+		return ctx
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// Inject sets the W3C Trace Context "traceparent" header to the span of the
+// context, if any.
+func Inject(ctx context.Context, header http.Header) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	if !ok {
+		return
+	}
+	header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01",
+		hex.EncodeToString(sc.traceID[:]), hex.EncodeToString(sc.spanID[:])))
+}
+
+// Detach returns a context carrying the span of the given context, but not
+// its deadline and cancellation, for work that continues after a request was
+// handled.
+func Detach(ctx context.Context) context.Context {
+	if sc, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		return context.WithValue(context.Background(), spanContextKey{}, sc)
+	}
+	return context.Background()
+}
+
+// Start starts a span of the given kind. Its parent is the span of the given
+// context, if any, otherwise the span starts a new trace. The returned context
+// carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	if parent, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		span.ctx.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(span.ctx.traceID[:])
+	}
+	_, _ = rand.Read(span.ctx.spanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span.ctx), span
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = fmt.Sprint(value)
+}
+
+// SetError marks the span as failed with the given message.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = message
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exported := &exportedSpan{
+		TraceID:           hex.EncodeToString(s.ctx.traceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	if s.parentID != ([8]byte{}) {
+		exported.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	for k, v := range s.attributes {
+		exported.Attributes = append(exported.Attributes, newAttribute(k, v))
+	}
+	if s.err != "" {
+		exported.Status = &status{Code: statusError, Message: s.err}
+	}
+
+	select {
+	case s.tracer.spans <- exported:
+	default:
+		s.tracer.log.Printf("Dropping span %q, too many spans are waiting for export", s.name)
+	}
+}
+
+// export sends the ended spans to the collector in batches.
+func (t *Tracer) export() {
+	ticker := time.NewTicker(exportInterval)
+	var batch []*exportedSpan
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.send(batch); err != nil {
+			t.log.Printf("Error exporting %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+func (t *Tracer) send(spans []*exportedSpan) error {
+	body, err := json.Marshal(exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []attribute{newAttribute("service.name", t.serviceName)},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: instrumentationURL},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// The JSON encoding of an OTLP ExportTraceServiceRequest
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope           `json:"scope"`
+	Spans []*exportedSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type exportedSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func newAttribute(key, value string) attribute {
+	return attribute{Key: key, Value: attributeValue{StringValue: value}}
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	testCases := []struct {
+		name        string
+		traceparent string
+		valid       bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true},
+		{"future version with more fields", "01-" + traceID + "-" + spanID + "-01-extra", true},
+		{"missing header", "", false},
+		{"too few fields", "00-" + traceID + "-" + spanID, false},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", false},
+		{"short trace ID", "00-" + traceID[2:] + "-" + spanID + "-01", false},
+		{"non-hex trace ID", "00-" + traceID[1:] + "x-" + spanID + "-01", false},
+		{"long span ID", "00-" + traceID + "-" + spanID + "00-01", false},
+		{"non-hex span ID", "00-" + traceID + "-" + spanID[1:] + "g-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", false},
+		{"zero span ID", "00-" + traceID + "-0000000000000000-01", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.traceparent != "" {
+				header.Set("traceparent", tc.traceparent)
+			}
+			ctx := Extract(context.Background(), header)
+
+			injected := http.Header{}
+			Inject(ctx, injected)
+			got := injected.Get("traceparent")
+			want := ""
+			if tc.valid {
+				want = "00-" + traceID + "-" + spanID + "-01"
+			}
+			if got != want {
+				t.Errorf("traceparent %q was propagated as %q, want %q", tc.traceparent, got, want)
+			}
+		})
+	}
+}
+
+func TestStartChildSpan(t *testing.T) {
+	tracer := &Tracer{log: log.New(io.Discard, "", 0), spans: make(chan *exportedSpan, 1)}
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, span := tracer.Start(Extract(context.Background(), header), "request", KindServer)
+	span.SetError("failed")
+	span.End()
+	exported := <-tracer.spans
+	if exported.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span has trace ID %s, want the remote parent's", exported.TraceID)
+	}
+	if exported.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("span has parent span ID %s, want the remote parent's", exported.ParentSpanID)
+	}
+	if exported.SpanID == "00f067aa0ba902b7" || exported.Status == nil || exported.Status.Code != statusError {
+		t.Errorf("span %+v isn't a new failed span", exported)
+	}
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		enabled  bool
+		wantErr  bool
+	}{
+		{endpoint: ""},
+		{endpoint: "http://localhost:4318/v1/traces", enabled: true},
+		{endpoint: "https://collector/v1/traces", enabled: true},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "grpc://localhost:4317", wantErr: true},
+		{endpoint: "http:///v1/traces", wantErr: true},
+	}
+	for _, tc := range testCases {
+		tracer, err := New(log.New(io.Discard, "", 0), Config{Endpoint: tc.endpoint})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("New(%q) returned error %v, want error %t", tc.endpoint, err, tc.wantErr)
+		}
+		if (tracer != nil) != tc.enabled {
+			t.Errorf("New(%q) returned tracer %v, want tracer %t", tc.endpoint, tracer, tc.enabled)
+		}
+	}
+}
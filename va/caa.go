@@ -0,0 +1,209 @@
+package va
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// defaultCAAIssuerDomain is the issuer domain name CAA records must authorize
+// unless configured otherwise.
+const defaultCAAIssuerDomain = "pebble.letsencrypt.org"
+
+// caaCriticalFlag is the issuer critical flag of CAA records, see RFC 8659
+// Section 4.1.
+const caaCriticalFlag = 128
+
+// CAAConfig configures the CAA checks of validations (RFC 8659), including the
+// accounturi and validationmethods parameters (RFC 8657).
+type CAAConfig struct {
+	// Don't check CAA records
+	Disabled bool
+	// Issuer domain names identifying Pebble in the issue and issuewild
+	// properties of CAA records. Defaults to "pebble.letsencrypt.org".
+	IssuerDomains []string
+}
+
+// caaConfig is the CAAConfig with the defaults applied. It can be
+// reconfigured while the VA validates challenges.
+type caaConfig struct {
+	sync.RWMutex
+	disabled      bool
+	issuerDomains []string
+}
+
+// SetCAA configures the CAA checks of validations, replacing the configuration
+// set before. It may be called while the VA validates challenges.
+func (va *VAImpl) SetCAA(config CAAConfig) error {
+	issuerDomains := make([]string, 0, len(config.IssuerDomains))
+	for _, domain := range config.IssuerDomains {
+		if domain == "" || strings.ContainsAny(domain, "; \t") {
+			return fmt.Errorf("invalid CAA issuer domain %q", domain)
+		}
+		issuerDomains = append(issuerDomains, strings.ToLower(domain))
+	}
+	if len(issuerDomains) == 0 {
+		issuerDomains = []string{defaultCAAIssuerDomain}
+	}
+	if config.Disabled {
+		va.log.Printf("Disabling CAA checks")
+	}
+
+	va.caa.Lock()
+	defer va.caa.Unlock()
+	va.caa.disabled = config.Disabled
+	va.caa.issuerDomains = issuerDomains
+	return nil
+}
+
+// checkCAA returns a problem if the CAA records of the identifier of a
+// validation task don't authorize Pebble to issue certificates for it, to the
+// account of the task with the challenge type of the task. IP identifiers and
+// .onion names have no CAA records in the DNS.
+func (va VAImpl) checkCAA(task *vaTask) *acme.ProblemDetails {
+	va.caa.RLock()
+	disabled, issuerDomains := va.caa.disabled, va.caa.issuerDomains
+	va.caa.RUnlock()
+	if disabled || task.Identifier.Type != acme.IdentifierDNS || core.IsOnionName(task.Identifier.Value) {
+		return nil
+	}
+
+	name := task.Identifier.Value
+	records, err := va.lookupCAA(task.Context, name)
+	if err != nil {
+		return acme.DNSProblem(fmt.Sprintf("Error looking up CAA records for %q: %s", name, err))
+	}
+	// The identifier of the task has the wildcard prefix of the identifier of
+	// the authorization stripped
+	wildcard := strings.HasPrefix(task.Challenge.Authz.Snapshot().Identifier.Value, "*.")
+	if !caaAuthorizes(records, issuerDomains, wildcard, task.AccountURL, task.Challenge.Type) {
+		va.log.Printf("%sCAA records for %s forbid issuance", core.RequestLogPrefix(task.Context), name)
+		return acme.CAAProblem(fmt.Sprintf(
+			"CAA record for %s prevents issuance", name))
+	}
+	return nil
+}
+
+// lookupCAA returns the relevant CAA record set of a domain name, found by
+// climbing the DNS tree from the name towards the root as described in RFC
+// 8659 Section 3. It is empty if no name up the tree has CAA records.
+func (va VAImpl) lookupCAA(ctx context.Context, name string) ([]*dns.CAA, error) {
+	ctx, cancel := context.WithTimeout(ctx, validationTimeout)
+	defer cancel()
+
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := range labels {
+		domain := strings.Join(labels[i:], ".")
+		in, err := va.exchange(ctx, domain, dns.TypeCAA)
+		if err != nil {
+			return nil, err
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			return nil, fmt.Errorf("DNS lookup for %q returned an unsuccessful response: %s",
+				domain, dns.RcodeToString[in.Rcode])
+		}
+		var records []*dns.CAA
+		for _, rr := range in.Answer {
+			// Aliases are followed by the resolver, the answer includes
+			// the CNAME records
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+	return nil, nil
+}
+
+// caaAuthorizes returns whether a relevant CAA record set authorizes one of
+// the issuer domains to issue a certificate for a name, a wildcard name if
+// wildcard is true, to the account with the given URL validating the name with
+// the given challenge type.
+func caaAuthorizes(records []*dns.CAA, issuerDomains []string, wildcard bool, accountURL, challType string) bool {
+	var issue, issuewild []*dns.CAA
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issuewild = append(issuewild, record)
+		case "iodef", "issuemail", "issuevmc", "contactemail", "contactphone":
+		default:
+			// Unknown properties with the critical flag forbid issuance
+			if record.Flag&caaCriticalFlag != 0 {
+				return false
+			}
+		}
+	}
+
+	// The issuewild properties, if any, apply to wildcard names instead of the
+	// issue properties
+	properties := issue
+	if wildcard && len(issuewild) > 0 {
+		properties = issuewild
+	}
+	if len(properties) == 0 {
+		return true
+	}
+	for _, record := range properties {
+		if caaRecordAuthorizes(record.Value, issuerDomains, accountURL, challType) {
+			return true
+		}
+	}
+	return false
+}
+
+// caaRecordAuthorizes returns whether the value of an issue or issuewild
+// property authorizes one of the issuer domains, with the parameters of the
+// value allowing the account URL and challenge type. Malformed values don't
+// authorize anything.
+func caaRecordAuthorizes(value string, issuerDomains []string, accountURL, challType string) bool {
+	parts := strings.Split(value, ";")
+	domain := strings.ToLower(strings.TrimSpace(parts[0]))
+	if domain == "" {
+		// An empty issuer domain name authorizes no CA
+		return false
+	}
+	known := false
+	for _, d := range issuerDomains {
+		known = known || d == domain
+	}
+	if !known {
+		return false
+	}
+
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(param, "=")
+		if !ok {
+			return false
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch strings.ToLower(key) {
+		case "accounturi":
+			if val != accountURL {
+				return false
+			}
+		case "validationmethods":
+			allowed := false
+			for _, method := range strings.Split(val, ",") {
+				allowed = allowed || strings.TrimSpace(method) == challType
+			}
+			if !allowed {
+				return false
+			}
+		}
+	}
+	return true
+}
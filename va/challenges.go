@@ -0,0 +1,102 @@
+package va
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ValidationRequest describes a challenge to validators of registered
+// challenge types and, as a JSON object, to external validators.
+type ValidationRequest struct {
+	Identifier       acme.Identifier `json:"identifier"`
+	Type             string          `json:"type"`
+	Token            string          `json:"token"`
+	KeyAuthorization string          `json:"keyAuthorization"`
+	AccountURL       string          `json:"accountURL"`
+}
+
+// ChallengeValidator validates a challenge of a registered challenge type,
+// returning a problem if the validation fails.
+type ChallengeValidator func(ctx context.Context, request ValidationRequest) *acme.ProblemDetails
+
+// challengeValidators are the validators of the registered challenge types.
+type challengeValidators struct {
+	sync.RWMutex
+	byType map[string]ChallengeValidator
+}
+
+// RegisterChallengeType registers an additional challenge type, whose
+// challenges are validated by the validator. Challenges of a type registered
+// without a validator must be delegated to an external validator. It must be
+// called before the VA validates challenges of the type.
+func (va *VAImpl) RegisterChallengeType(name string, validate ChallengeValidator) error {
+	va.registered.Lock()
+	defer va.registered.Unlock()
+	if _, ok := va.registered.byType[name]; ok || va.builtinChallengeType(name) {
+		return fmt.Errorf("challenge type %q is already defined", name)
+	}
+	va.registered.byType[name] = validate
+	return nil
+}
+
+// builtinChallengeType returns whether the VA validates challenges of a type
+// itself.
+func (va VAImpl) builtinChallengeType(name string) bool {
+	switch name {
+	case acme.ChallengeHTTP01, acme.ChallengeTLSALPN01, acme.ChallengeDNS01, acme.ChallengeOnionCSR01:
+		return true
+	}
+	return false
+}
+
+// knownChallengeType returns whether challenges of a type are built in or
+// registered.
+func (va VAImpl) knownChallengeType(name string) bool {
+	va.registered.RLock()
+	defer va.registered.RUnlock()
+	_, ok := va.registered.byType[name]
+	return ok || va.builtinChallengeType(name)
+}
+
+// registeredValidator returns the validator of a registered challenge type,
+// and whether the type is registered.
+func (va VAImpl) registeredValidator(name string) (ChallengeValidator, bool) {
+	va.registered.RLock()
+	defer va.registered.RUnlock()
+	validate, ok := va.registered.byType[name]
+	return validate, ok
+}
+
+// newValidationRequest returns the description of the challenge of a task.
+func newValidationRequest(task *vaTask) ValidationRequest {
+	return ValidationRequest{
+		Identifier:       task.Identifier,
+		Type:             task.Challenge.Type,
+		Token:            task.Challenge.Token,
+		KeyAuthorization: task.Challenge.ExpectedKeyAuthorization(task.Account.Key),
+		AccountURL:       task.AccountURL,
+	}
+}
+
+// validateRegistered validates the challenge of a task with the validator of
+// its registered challenge type.
+func (va VAImpl) validateRegistered(task *vaTask, validate ChallengeValidator) *core.ValidationRecord {
+	result := &core.ValidationRecord{
+		URL:         task.Identifier.Value,
+		ValidatedAt: va.clk.Now(),
+	}
+	if validate == nil {
+		result.Error = acme.InternalErrorProblem(
+			fmt.Sprintf("No validator is configured for %s challenges", task.Challenge.Type))
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(task.Context, validationTimeout)
+	defer cancel()
+	result.Error = validate(ctx, newValidationRequest(task))
+	return result
+}
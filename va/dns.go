@@ -0,0 +1,41 @@
+package va
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// resolvConf is the file the addresses of the system resolvers are read from
+// for queries the standard library can't make, like CAA queries.
+const resolvConf = "/etc/resolv.conf"
+
+// exchange queries the records of the given type for a name with the
+// recursive resolver `va.resolver`, or the first system resolver if no custom
+// resolver is specified.
+func (va VAImpl) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	resolver := va.resolver
+	if resolver == nil {
+		config, err := dns.ClientConfigFromFile(resolvConf)
+		if err != nil {
+			return nil, err
+		}
+		if len(config.Servers) == 0 {
+			return nil, errors.New("no system DNS resolver configured")
+		}
+		resolver = &resolverList{
+			resolvers: []*dnsResolver{{
+				address:   net.JoinHostPort(config.Servers[0], config.Port),
+				dnsClient: new(dns.Client),
+				tcpClient: &dns.Client{Net: "tcp"},
+			}},
+			ednsBufferSize: defaultEDNS0BufferSize,
+		}
+	}
+
+	message := new(dns.Msg)
+	message.SetQuestion(dns.Fqdn(name), qtype)
+	return resolver.exchange(ctx, message)
+}
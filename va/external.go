@@ -0,0 +1,188 @@
+package va
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ExternalValidatorConfig configures an external validator challenges of some
+// types are delegated to instead of being validated by the VA, to prototype
+// validation methods without changing Pebble. The validator is an HTTP
+// endpoint or a command.
+type ExternalValidatorConfig struct {
+	// Types of the challenges delegated to the validator, e.g. "http-01"
+	ChallengeTypes []string
+	// URL the validation request is POSTed to. The challenge is valid if the
+	// endpoint responds with status 200.
+	URL string
+	// Command, and its arguments, run with the validation request as its
+	// standard input. The challenge is valid if it exits with status 0.
+	Command []string
+	// Seconds the validator may take. Defaults to 15.
+	Timeout int
+}
+
+// externalValidator is a parsed ExternalValidatorConfig.
+type externalValidator struct {
+	url     string
+	command []string
+	timeout time.Duration
+}
+
+// externalValidators are the external validators by challenge type. They can
+// be reconfigured while the VA validates challenges.
+type externalValidators struct {
+	sync.RWMutex
+	validators map[string]*externalValidator
+}
+
+// SetExternalValidators configures the external validators challenges are
+// delegated to, replacing the validators configured before. It may be called
+// while the VA validates challenges.
+func (va *VAImpl) SetExternalValidators(configs []ExternalValidatorConfig) error {
+	validators := make(map[string]*externalValidator)
+	for _, config := range configs {
+		if (config.URL == "") == (len(config.Command) == 0) {
+			return errors.New("external validators must have either a URL or a command")
+		}
+		if config.URL != "" {
+			u, err := url.Parse(config.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("external validator URL %q must be an absolute http or https URL", config.URL)
+			}
+		}
+		if config.Timeout < 0 {
+			return errors.New("external validator timeout must not be negative")
+		}
+		if len(config.ChallengeTypes) == 0 {
+			return errors.New("external validators must have challenge types")
+		}
+
+		v := &externalValidator{
+			url:     config.URL,
+			command: config.Command,
+			timeout: validationTimeout,
+		}
+		if config.Timeout > 0 {
+			v.timeout = time.Duration(config.Timeout) * time.Second
+		}
+		for _, typ := range config.ChallengeTypes {
+			if validators[typ] != nil {
+				return fmt.Errorf("challenge type %q has more than one external validator", typ)
+			}
+			validators[typ] = v
+		}
+		va.log.Printf("Delegating validation of %s challenges to %s",
+			strings.Join(config.ChallengeTypes, ", "), v.name())
+	}
+
+	va.external.Lock()
+	defer va.external.Unlock()
+	va.external.validators = validators
+	return nil
+}
+
+// externalValidator returns the external validator of a challenge type, or nil
+// if challenges of the type are validated by the VA.
+func (va VAImpl) externalValidator(challengeType string) *externalValidator {
+	va.external.RLock()
+	defer va.external.RUnlock()
+	return va.external.validators[challengeType]
+}
+
+// name returns the URL or command of the validator for logging.
+func (v *externalValidator) name() string {
+	if v.url != "" {
+		return v.url
+	}
+	return strings.Join(v.command, " ")
+}
+
+// validateExternal delegates the validation of the challenge of a task to an
+// external validator.
+func (va VAImpl) validateExternal(task *vaTask, v *externalValidator) *core.ValidationRecord {
+	result := &core.ValidationRecord{
+		URL:         task.Identifier.Value,
+		ValidatedAt: va.clk.Now(),
+	}
+	body, err := json.Marshal(newValidationRequest(task))
+	if err != nil {
+		result.Error = acme.InternalErrorProblem(fmt.Sprintf("Error marshaling external validation request: %s", err))
+		return result
+	}
+
+	va.log.Printf("%sDelegating validation of challenge %s to external validator %s",
+		core.RequestLogPrefix(task.Context), task.Challenge.ID, v.name())
+	ctx, cancel := context.WithTimeout(task.Context, v.timeout)
+	defer cancel()
+	var valid bool
+	var output []byte
+	if v.url != "" {
+		valid, output, err = v.post(ctx, body)
+	} else {
+		valid, output, err = v.run(ctx, body)
+	}
+	if err != nil {
+		result.Error = acme.InternalErrorProblem(
+			fmt.Sprintf("External validator %s failed: %s", v.name(), err))
+		return result
+	}
+	if !valid {
+		detail := strings.TrimSpace(responseSnippet(output))
+		if detail == "" {
+			detail = fmt.Sprintf("External validator %s rejected the challenge", v.name())
+		}
+		result.Error = acme.UnauthorizedProblem(detail)
+	}
+	return result
+}
+
+// post POSTs a validation request to the validator endpoint, returning whether
+// it responded with status 200 and the response body.
+func (v *externalValidator) post(ctx context.Context, body []byte) (bool, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+	output, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSnippet))
+	if err != nil {
+		return false, nil, err
+	}
+	return resp.StatusCode == http.StatusOK, output, nil
+}
+
+// run runs the validator command with the validation request as its standard
+// input, returning whether it exited with status 0 and its output.
+func (v *externalValidator) run(ctx context.Context, body []byte) (bool, []byte, error) {
+	cmd := exec.CommandContext(ctx, v.command[0], v.command[1:]...) // nolint:gosec
+	cmd.Stdin = bytes.NewReader(body)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return false, output, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, output, nil
+}
@@ -0,0 +1,128 @@
+package va
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+const (
+	// AddressFamilyIPv4 and AddressFamilyIPv6 restrict validation connections
+	// to the IPv4 or IPv6 addresses of identifiers
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+	// AddressFamilyPreferIPv4 and AddressFamilyPreferIPv6 make validation
+	// connections to an address of the preferred family first, falling back
+	// to an address of the other family if the connection fails
+	AddressFamilyPreferIPv4 = "prefer-ipv4"
+	AddressFamilyPreferIPv6 = "prefer-ipv6"
+)
+
+// addressFamily is the configured address family of validation connections.
+// It can be reconfigured while the VA validates challenges.
+type addressFamily struct {
+	sync.RWMutex
+	family string
+}
+
+// SetAddressFamily configures the address family of the connections of
+// HTTP-01 and TLS-ALPN-01 validations, replacing the family configured
+// before. Connections are made to the first resolved address of any family if
+// it is empty. It may be called while the VA validates challenges.
+func (va *VAImpl) SetAddressFamily(family string) error {
+	switch family {
+	case "":
+	case AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyPreferIPv4, AddressFamilyPreferIPv6:
+		va.log.Printf("Making validation connections with address family %s", family)
+	default:
+		return fmt.Errorf("unknown address family %q", family)
+	}
+
+	va.family.Lock()
+	defer va.family.Unlock()
+	va.family.family = family
+	return nil
+}
+
+// candidateAddresses returns the resolved addresses of a host that validation
+// connections are attempted to, in order, following the address family.
+func (va VAImpl) candidateAddresses(host string, addrs []string) ([]string, error) {
+	va.family.RLock()
+	family := va.family.family
+	va.family.RUnlock()
+	if family == "" {
+		return addrs[:1], nil
+	}
+
+	var ipv4, ipv6 []string
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+
+	preferred, other := ipv4, ipv6
+	if family == AddressFamilyIPv6 || family == AddressFamilyPreferIPv6 {
+		preferred, other = ipv6, ipv4
+	}
+	candidates := firstAddress(preferred)
+	if family == AddressFamilyPreferIPv4 || family == AddressFamilyPreferIPv6 {
+		candidates = append(candidates, firstAddress(other)...)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no %s address found for %q", family, host)
+	}
+	return candidates, nil
+}
+
+// firstAddress returns the first of some addresses, if any.
+func firstAddress(addrs []string) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[:1]
+}
+
+// dialAddresses connects to a port of the resolved addresses of a host for a
+// validation, trying the candidate addresses in order until a connection
+// succeeds. The addresses are recorded in the validation record.
+func (va VAImpl) dialAddresses(
+	ctx context.Context,
+	network, host string,
+	addrs []string,
+	port string,
+	record *acme.ValidationRecord,
+	logPrefix string,
+) (net.Conn, error) {
+	record.AddressesResolved = addrs
+	record.AddressesTried = nil
+	record.AddressUsed = ""
+	candidates, err := va.candidateAddresses(host, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, addr := range candidates {
+		if i > 0 {
+			va.log.Printf("%sConnecting to %s failed, falling back to %s: %s", logPrefix, candidates[i-1], addr, err)
+		}
+		var conn net.Conn
+		conn, err = va.dial(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			va.log.Printf("%sConnected to %s of %s", logPrefix, addr, host)
+			record.AddressUsed = addr
+			return conn, nil
+		}
+		record.AddressesTried = append(record.AddressesTried, addr)
+	}
+	return nil, err
+}
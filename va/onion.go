@@ -0,0 +1,138 @@
+package va
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+var (
+	// CA/Browser Forum request attributes used by the onion-csr-01 challenge.
+	// See the Baseline Requirements, Appendix B.
+	oidCASigningNonce        = asn1.ObjectIdentifier{2, 23, 140, 41}
+	oidApplicantSigningNonce = asn1.ObjectIdentifier{2, 23, 140, 42}
+)
+
+// The applicant signing nonce must contain at least 64 bits of entropy.
+const minApplicantNonceLength = 8
+
+// csrAttribute and tbsCSR are used to parse the raw request attributes of
+// a CSR, since crypto/x509 only exposes extension request attributes.
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type tbsCSR struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []csrAttribute `asn1:"tag:0"`
+}
+
+// csrOctetStringAttribute returns the value of a single valued OCTET STRING
+// request attribute of a CSR, or nil if the CSR has no such attribute.
+func csrOctetStringAttribute(csr *x509.CertificateRequest, oid asn1.ObjectIdentifier) ([]byte, error) {
+	var tbs tbsCSR
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return nil, err
+	}
+	for _, attr := range tbs.Attributes {
+		if !attr.Type.Equal(oid) {
+			continue
+		}
+		if len(attr.Values) != 1 {
+			return nil, fmt.Errorf("attribute %s must have exactly one value", oid)
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	return nil, nil
+}
+
+// validateOnionCSR01 checks the CSR submitted for an onion-csr-01 challenge.
+// The CSR must be signed by the onion service's key, name the identifier and
+// include the CA signing nonce from the challenge as well as an applicant
+// signing nonce.
+func (va VAImpl) validateOnionCSR01(task *vaTask) *core.ValidationRecord {
+	result := &core.ValidationRecord{
+		URL:         task.Identifier.Value,
+		ValidatedAt: va.clk.Now(),
+	}
+
+	chal := task.Challenge.Snapshot()
+	csrDER := chal.OnionCSR
+	nonce := chal.Nonce
+
+	onionKey, err := core.OnionPublicKey(task.Identifier.Value)
+	if err != nil {
+		result.Error = acme.MalformedProblem(fmt.Sprintf(
+			"Identifier %q is not a valid onion name: %s", task.Identifier.Value, err))
+		return result
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		result.Error = acme.MalformedProblem(fmt.Sprintf(
+			"Error parsing %s CSR: %s", acme.ChallengeOnionCSR01, err))
+		return result
+	}
+	if err := csr.CheckSignature(); err != nil {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"Invalid %s CSR signature: %s", acme.ChallengeOnionCSR01, err))
+		return result
+	}
+	csrKey, ok := csr.PublicKey.(ed25519.PublicKey)
+	if !ok || !bytes.Equal(csrKey, onionKey) {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"%s CSR was not signed by the onion service key of %q",
+			acme.ChallengeOnionCSR01, task.Identifier.Value))
+		return result
+	}
+
+	var named bool
+	for _, name := range csr.DNSNames {
+		if strings.EqualFold(name, task.Identifier.Value) ||
+			strings.EqualFold(strings.TrimPrefix(name, "*."), task.Identifier.Value) {
+			named = true
+			break
+		}
+	}
+	if !named {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"%s CSR does not contain the name %q", acme.ChallengeOnionCSR01, task.Identifier.Value))
+		return result
+	}
+
+	expectedNonce, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		result.Error = acme.InternalErrorProblem("Challenge has a malformed nonce")
+		return result
+	}
+	caNonce, err := csrOctetStringAttribute(csr, oidCASigningNonce)
+	if err != nil || !bytes.Equal(caNonce, expectedNonce) {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"%s CSR does not contain the challenge nonce in a caSigningNonce attribute",
+			acme.ChallengeOnionCSR01))
+		return result
+	}
+	applicantNonce, err := csrOctetStringAttribute(csr, oidApplicantSigningNonce)
+	if err != nil || len(applicantNonce) < minApplicantNonceLength {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"%s CSR does not contain an applicantSigningNonce attribute of at least %d bytes",
+			acme.ChallengeOnionCSR01, minApplicantNonceLength))
+		return result
+	}
+
+	return result
+}
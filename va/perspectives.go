@@ -0,0 +1,178 @@
+package va
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Perspective is a simulated remote network perspective that challenges are
+// validated from in addition to the primary validation, like the remote
+// perspectives of Multi-Perspective Issuance Corroboration (MPIC).
+type Perspective struct {
+	// Name of the perspective in logs and problems, e.g. "us-east"
+	Name string
+	// Address of the DNS resolver of the perspective, e.g. "127.0.0.1:8054",
+	// "tls://127.0.0.1:8854" or "https://127.0.0.1:8444/dns-query" like the
+	// address of the DNSResolverConfig, whose certificate is verified the same
+	// way. Defaults to the resolver of the primary validation.
+	DNSServer string
+	// Local IP address validation connections of the perspective are made
+	// from, e.g. "127.0.0.2". Defaults to any address.
+	SourceAddress string
+}
+
+// MultiPerspectiveConfig configures the remote perspectives challenges are
+// validated from.
+type MultiPerspectiveConfig struct {
+	Perspectives []Perspective
+	// Number of perspectives whose validation must succeed. Defaults to the
+	// number of perspectives less the failures allowed by the CA/Browser
+	// Forum Baseline Requirements: one for up to 5 perspectives, two for more.
+	Quorum int
+}
+
+// perspectiveConfig is the MultiPerspectiveConfig with the defaults applied.
+// It can be reconfigured while the VA validates challenges.
+type perspectiveConfig struct {
+	sync.RWMutex
+	perspectives []Perspective
+	quorum       int
+}
+
+// SetMultiPerspective configures the remote perspectives challenges are
+// validated from, replacing the perspectives configured before. No
+// perspectives disables multi-perspective validation. It may be called while
+// the VA validates challenges.
+func (va *VAImpl) SetMultiPerspective(config MultiPerspectiveConfig) error {
+	names := make(map[string]bool, len(config.Perspectives))
+	for _, p := range config.Perspectives {
+		if p.Name == "" {
+			return fmt.Errorf("perspectives must have a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("perspective %q is configured more than once", p.Name)
+		}
+		names[p.Name] = true
+		if p.DNSServer != "" {
+			if _, err := newDNSResolver(p.DNSServer, va.resolverTLS, 0); err != nil {
+				return fmt.Errorf("perspective %q: %s", p.Name, err)
+			}
+		}
+		if p.SourceAddress != "" && net.ParseIP(p.SourceAddress) == nil {
+			return fmt.Errorf("perspective %q has invalid source address %q", p.Name, p.SourceAddress)
+		}
+	}
+	count := len(config.Perspectives)
+	quorum := config.Quorum
+	if quorum < 0 || quorum > count {
+		return fmt.Errorf("perspective quorum %d is not between 0 and %d", quorum, count)
+	}
+	if quorum == 0 {
+		switch {
+		case count <= 1:
+			quorum = count
+		case count <= 5:
+			quorum = count - 1
+		default:
+			quorum = count - 2
+		}
+	}
+	if count > 0 {
+		va.log.Printf("Validating challenges from %d remote perspectives with a quorum of %d", count, quorum)
+	}
+
+	va.perspectives.Lock()
+	defer va.perspectives.Unlock()
+	va.perspectives.perspectives = config.Perspectives
+	va.perspectives.quorum = quorum
+	return nil
+}
+
+// perspectiveResult is the outcome of the validation from a perspective.
+type perspectiveResult struct {
+	name string
+	err  *acme.ProblemDetails
+}
+
+// validateFromPerspectives validates the challenge of a task from the remote
+// perspectives, if any, and returns a problem if fewer than the quorum of them
+// succeed. The problem reports the outcome of every perspective.
+func (va VAImpl) validateFromPerspectives(task *vaTask) *acme.ProblemDetails {
+	va.perspectives.RLock()
+	perspectives, quorum := va.perspectives.perspectives, va.perspectives.quorum
+	va.perspectives.RUnlock()
+	if len(perspectives) == 0 {
+		return nil
+	}
+
+	results := make(chan perspectiveResult, len(perspectives))
+	for _, p := range perspectives {
+		go func(p Perspective) {
+			records := make(chan *core.ValidationRecord, 1)
+			va.fromPerspective(p).performValidation(task, records)
+			results <- perspectiveResult{name: p.Name, err: (<-records).Error}
+		}(p)
+	}
+
+	prefix := core.RequestLogPrefix(task.Context)
+	byName := make(map[string]*acme.ProblemDetails, len(perspectives))
+	var firstErr *acme.ProblemDetails
+	succeeded := 0
+	for range perspectives {
+		result := <-results
+		byName[result.name] = result.err
+		if result.err == nil {
+			succeeded++
+			va.log.Printf("%sValidation from perspective %q succeeded", prefix, result.name)
+			continue
+		}
+		va.log.Printf("%sValidation from perspective %q failed: %s", prefix, result.name, result.err)
+		if firstErr == nil {
+			firstErr = result.err
+		}
+	}
+	if succeeded >= quorum {
+		return nil
+	}
+
+	outcomes := make([]string, 0, len(perspectives))
+	for _, p := range perspectives {
+		if err := byName[p.Name]; err != nil {
+			outcomes = append(outcomes, fmt.Sprintf("%s: %s", p.Name, err.Detail))
+		} else {
+			outcomes = append(outcomes, fmt.Sprintf("%s: succeeded", p.Name))
+		}
+	}
+	prob := *firstErr
+	prob.Detail = fmt.Sprintf(
+		"During secondary validation: %d of %d remote perspectives succeeded, %d are required (%s)",
+		succeeded, len(perspectives), quorum, strings.Join(outcomes, "; "))
+	return &prob
+}
+
+// fromPerspective returns a copy of the VA validating challenges from a
+// perspective, without the random sleep of the primary validation.
+func (va VAImpl) fromPerspective(p Perspective) VAImpl {
+	va.sleep = false
+	if p.DNSServer != "" {
+		// The resolver of the perspective has the query timeout and retries
+		// of the resolvers of the primary validation
+		resolver := &resolverList{log: va.log}
+		if va.resolver != nil {
+			*resolver = *va.resolver
+		}
+		// The address was checked by SetMultiPerspective
+		r, _ := newDNSResolver(p.DNSServer, va.resolverTLS, resolver.timeout)
+		resolver.resolvers = []*dnsResolver{r}
+		va.resolver = resolver
+	}
+	if p.SourceAddress != "" {
+		va.sourceAddr = net.ParseIP(p.SourceAddress)
+	}
+	return va
+}
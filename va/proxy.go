@@ -0,0 +1,241 @@
+package va
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProxyConfig configures the proxy the connections of HTTP-01 and TLS-ALPN-01
+// validations are made through, where challenge hosts can only be reached
+// through a proxy.
+type ProxyConfig struct {
+	// URL of the proxy: "http://host:port" for an HTTP proxy, which is sent
+	// CONNECT requests, or "socks5://host:port" for a SOCKS5 proxy. The user
+	// and password of the URL, if any, authenticate to the proxy. Connections
+	// are made directly if it is empty.
+	URL string
+}
+
+// proxyConfig is the parsed proxy URL. It can be reconfigured while the VA
+// validates challenges.
+type proxyConfig struct {
+	sync.RWMutex
+	url *url.URL
+}
+
+// socks5Replies are the messages of the failure replies of SOCKS5 proxies,
+// see RFC 1928 Section 6.
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// SetProxy configures the proxy the connections of HTTP-01 and TLS-ALPN-01
+// validations are made through, replacing the proxy configured before. It may
+// be called while the VA validates challenges.
+func (va *VAImpl) SetProxy(config ProxyConfig) error {
+	var proxyURL *url.URL
+	if config.URL != "" {
+		u, err := url.Parse(config.URL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL %q: %s", config.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "socks5" {
+			return fmt.Errorf("proxy URL %q is not an http or socks5 URL", u.Redacted())
+		}
+		if u.Hostname() == "" || u.Port() == "" {
+			return fmt.Errorf("proxy URL %q has no host and port", u.Redacted())
+		}
+		proxyURL = u
+		va.log.Printf("Making validation connections through the proxy %s", u.Redacted())
+	}
+
+	va.proxy.Lock()
+	defer va.proxy.Unlock()
+	va.proxy.url = proxyURL
+	return nil
+}
+
+// dial connects to the address of a validation, through the proxy if one is
+// configured, in which case the address is connected to over TCP.
+func (va VAImpl) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	va.proxy.RLock()
+	proxyURL := va.proxy.url
+	va.proxy.RUnlock()
+	if proxyURL == nil {
+		return va.dialer().DialContext(ctx, network, addr)
+	}
+
+	conn, err := va.dialer().DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to proxy %s: %s", proxyURL.Host, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(validationTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if proxyURL.Scheme == "socks5" {
+		err = socks5Connect(conn, proxyURL.User, addr)
+	} else {
+		err = httpConnect(conn, proxyURL.User, addr)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("connecting to %s through proxy %s: %s", addr, proxyURL.Host, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect asks the HTTP proxy at the other end of a connection to tunnel
+// it to an address with a CONNECT request.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT request returned %s", resp.Status)
+	}
+	return nil
+}
+
+// socks5Connect asks the SOCKS5 proxy at the other end of a connection to
+// connect it to an address, authenticating with the user and password if
+// there are any, see RFC 1928 and RFC 1929.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portString)
+	}
+
+	// Offer no authentication, and username/password authentication if there
+	// are credentials
+	methods := []byte{0x00}
+	if user != nil {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("proxy is not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if user == nil {
+			return errors.New("SOCKS5 proxy requires authentication")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username and password must be at most 255 bytes long")
+		}
+		auth := []byte{0x01, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	default:
+		return errors.New("SOCKS5 proxy accepts none of the authentication methods offered")
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		message, ok := socks5Replies[header[1]]
+		if !ok {
+			message = fmt.Sprintf("unknown SOCKS5 reply %d", header[1])
+		}
+		return errors.New(message)
+	}
+	// Skip the address the proxy bound for the connection
+	var boundLen int
+	switch header[3] {
+	case 0x01:
+		boundLen = net.IPv4len
+	case 0x04:
+		boundLen = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLen = int(length[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLen+2))
+	return err
+}
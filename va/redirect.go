@@ -0,0 +1,122 @@
+package va
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMaxRedirects is the number of redirects HTTP-01 validations follow
+// unless configured otherwise, like the Go HTTP client
+const defaultMaxRedirects = 10
+
+// RedirectPolicy configures the redirects HTTP-01 validations follow. The zero
+// value follows up to 10 redirects to any http or https URL.
+type RedirectPolicy struct {
+	// Maximum number of redirects followed. Defaults to 10. A negative number
+	// follows no redirects.
+	MaxRedirects int
+	// Schemes of the URLs redirects may target, "http" and "https" if empty
+	Schemes []string
+	// Ports redirects may target, any port if empty. URLs without a port
+	// target the default port of their scheme.
+	Ports []int
+	// Don't follow redirects to IP addresses other than the identifier
+	DenyIPAddresses bool
+	// Don't follow redirects to hosts other than the identifier
+	DenyOtherHosts bool
+}
+
+// redirectPolicy is the RedirectPolicy with the defaults applied. It can be
+// reconfigured while the VA validates challenges.
+type redirectPolicy struct {
+	sync.RWMutex
+	policy RedirectPolicy
+}
+
+// SetRedirectPolicy configures the redirects HTTP-01 validations follow,
+// replacing the policy configured before. It may be called while the VA
+// validates challenges.
+func (va *VAImpl) SetRedirectPolicy(policy RedirectPolicy) error {
+	for _, scheme := range policy.Schemes {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("redirect scheme %q is not http or https", scheme)
+		}
+	}
+	for _, port := range policy.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("redirect port %d is not between 1 and 65535", port)
+		}
+	}
+	if policy.MaxRedirects == 0 {
+		policy.MaxRedirects = defaultMaxRedirects
+	} else if policy.MaxRedirects < 0 {
+		policy.MaxRedirects = 0
+	}
+	if len(policy.Schemes) == 0 {
+		policy.Schemes = []string{"http", "https"}
+	}
+	va.log.Printf("Following up to %d HTTP-01 redirects", policy.MaxRedirects)
+
+	va.redirects.Lock()
+	defer va.redirects.Unlock()
+	va.redirects.policy = policy
+	return nil
+}
+
+// redirectPolicy returns the configured redirect policy.
+func (va VAImpl) redirectPolicy() RedirectPolicy {
+	va.redirects.RLock()
+	defer va.redirects.RUnlock()
+	return va.redirects.policy
+}
+
+// checkRedirect returns an error if the policy forbids following a redirect
+// of the HTTP-01 validation of an identifier to a request, after the requests
+// made so far.
+func (policy RedirectPolicy) checkRedirect(identifier string, req *http.Request, via []*http.Request) error {
+	if len(via) > policy.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", policy.MaxRedirects)
+	}
+
+	target := req.URL
+	allowed := false
+	for _, scheme := range policy.Schemes {
+		allowed = allowed || scheme == target.Scheme
+	}
+	if !allowed {
+		return fmt.Errorf("redirect to %q: scheme %q is not allowed", target, target.Scheme)
+	}
+
+	if len(policy.Ports) > 0 {
+		port := target.Port()
+		if port == "" {
+			port = "80"
+			if target.Scheme == "https" {
+				port = "443"
+			}
+		}
+		allowed = false
+		for _, p := range policy.Ports {
+			allowed = allowed || strconv.Itoa(p) == port
+		}
+		if !allowed {
+			return fmt.Errorf("redirect to %q: port %s is not allowed", target, port)
+		}
+	}
+
+	host := target.Hostname()
+	if strings.EqualFold(host, identifier) {
+		return nil
+	}
+	if policy.DenyIPAddresses && net.ParseIP(host) != nil {
+		return fmt.Errorf("redirect to %q: IP addresses are not allowed", target)
+	}
+	if policy.DenyOtherHosts {
+		return fmt.Errorf("redirect to %q: hosts other than %q are not allowed", target, identifier)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package va
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// dotScheme and dohScheme prefix the addresses of DNS over TLS (RFC 7858)
+	// and DNS over HTTPS (RFC 8484) resolvers
+	dotScheme = "tls://"
+	dohScheme = "https://"
+	// dotPort is the port of DNS over TLS resolvers whose address has none
+	dotPort = "853"
+	// dohMediaType is the media type of DNS over HTTPS queries and responses
+	dohMediaType = "application/dns-message"
+	// defaultQueryTimeout is the number of milliseconds a query to a resolver
+	// may take unless configured otherwise
+	defaultQueryTimeout = 2000
+	// defaultEDNS0BufferSize is the UDP payload size advertised with EDNS0
+	// unless configured otherwise, as recommended by DNS Flag Day 2020
+	defaultEDNS0BufferSize = 1232
+)
+
+// DNSResolverConfig configures the DNS resolver the identifiers of challenges
+// are resolved with, which may be reached with DNS over TLS or HTTPS where
+// plain DNS is blocked.
+type DNSResolverConfig struct {
+	// Address of the resolver: "host:port" for DNS over UDP, e.g.
+	// "127.0.0.1:8053", "tls://host:port" for DNS over TLS, e.g.
+	// "tls://127.0.0.1:8853", or the URL of a DNS over HTTPS resolver, e.g.
+	// "https://127.0.0.1:8443/dns-query". The system resolver is used if it
+	// is empty.
+	Address string
+	// Addresses of further resolvers, like the address, a query fails over to
+	// in order when the resolvers before them don't answer it
+	FailoverAddresses []string
+	// Milliseconds a query to a resolver may take. Defaults to 2000.
+	QueryTimeout int
+	// Number of times a query is retried with a resolver that doesn't answer
+	// it before failing over to the next resolver
+	Retries int
+	// PEM file of the CA certificates the certificate of a DNS over TLS or
+	// HTTPS resolver is verified with. Defaults to the system roots.
+	CACertificates string
+	// Name the certificate of the resolver is verified for. Defaults to the
+	// host of the address.
+	ServerName string
+	// Don't verify the certificate of the resolver
+	InsecureSkipVerify bool
+	// UDP payload size advertised with EDNS0 in queries. Defaults to 1232. A
+	// negative size sends queries without EDNS0.
+	EDNS0BufferSize int
+	// Query DNS over UDP resolvers over TCP only, instead of over UDP with
+	// truncated responses retried over TCP
+	TCP bool
+}
+
+// resolverList queries a list of recursive DNS resolvers in failover order.
+type resolverList struct {
+	resolvers []*dnsResolver
+	// timeout of each query, none if zero, and the number of times a query
+	// is retried with a resolver
+	timeout time.Duration
+	retries int
+	// ednsBufferSize is the UDP payload size advertised with EDNS0, which
+	// isn't used if it is zero, and tcp forces DNS over UDP resolvers to be
+	// queried over TCP
+	ednsBufferSize uint16
+	tcp            bool
+	// log records which resolver answered, if not nil
+	log *log.Logger
+}
+
+// dnsResolver queries a recursive DNS resolver over UDP, TLS or HTTPS.
+type dnsResolver struct {
+	// address is the "host:port" of UDP and TLS resolvers and the URL of
+	// HTTPS resolvers
+	address string
+	// dnsClient queries UDP and TLS resolvers, tcpClient UDP resolvers over
+	// TCP and httpClient HTTPS resolvers
+	dnsClient  *dns.Client
+	tcpClient  *dns.Client
+	httpClient *http.Client
+}
+
+// SetDNSResolver configures the DNS resolvers the identifiers of challenges
+// are resolved with. It must be called before the VA validates challenges.
+func (va *VAImpl) SetDNSResolver(config DNSResolverConfig) error {
+	if config.QueryTimeout < 0 || config.Retries < 0 {
+		return fmt.Errorf("DNS query timeout and retries must not be negative")
+	}
+	if config.EDNS0BufferSize > 0 && (config.EDNS0BufferSize < dns.MinMsgSize || config.EDNS0BufferSize > dns.MaxMsgSize) {
+		return fmt.Errorf("EDNS0 buffer size must be between %d and %d", dns.MinMsgSize, dns.MaxMsgSize)
+	}
+	ednsBufferSize := config.EDNS0BufferSize
+	if ednsBufferSize == 0 {
+		ednsBufferSize = defaultEDNS0BufferSize
+	} else if ednsBufferSize < 0 {
+		ednsBufferSize = 0
+	}
+	if config.Address == "" && len(config.FailoverAddresses) > 0 {
+		return fmt.Errorf("DNS failover resolvers require a resolver address")
+	}
+	queryTimeout := config.QueryTimeout
+	if queryTimeout == 0 {
+		queryTimeout = defaultQueryTimeout
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify, // nolint:gosec
+	}
+	if config.CACertificates != "" {
+		pemBytes, err := os.ReadFile(config.CACertificates)
+		if err != nil {
+			return fmt.Errorf("reading CA certificates: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemBytes) {
+			return fmt.Errorf("no CA certificates in %q", config.CACertificates)
+		}
+	}
+
+	if config.Address == "" {
+		va.log.Print("Using system DNS resolver for ACME challenges")
+		va.resolver = nil
+		va.resolverTLS = tlsConfig
+		return nil
+	}
+	resolver := &resolverList{
+		timeout:        time.Duration(queryTimeout) * time.Millisecond,
+		retries:        config.Retries,
+		ednsBufferSize: uint16(ednsBufferSize),
+		tcp:            config.TCP,
+		log:            va.log,
+	}
+	addresses := append([]string{config.Address}, config.FailoverAddresses...)
+	for _, address := range addresses {
+		r, err := newDNSResolver(address, tlsConfig, resolver.timeout)
+		if err != nil {
+			return err
+		}
+		resolver.resolvers = append(resolver.resolvers, r)
+	}
+	va.log.Printf("Using custom DNS resolver for ACME challenges: %s", config.Address)
+	if len(config.FailoverAddresses) > 0 {
+		va.log.Printf("Failing DNS queries over to %s with a %dms timeout and %d retries",
+			strings.Join(config.FailoverAddresses, ", "), queryTimeout, config.Retries)
+	}
+	if config.TCP {
+		va.log.Print("Querying DNS resolvers over TCP only")
+	}
+	va.resolver = resolver
+	va.resolverTLS = tlsConfig
+	return nil
+}
+
+// exchange sends a query to the resolvers in order, retrying it with each of
+// them, until one answers. A resolver failing with a SERVFAIL or REFUSED
+// response doesn't answer, and its response is returned if no resolver does.
+func (l *resolverList) exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	var in *dns.Msg
+	var err error
+	name := message.Question[0].Name
+	if l.ednsBufferSize > 0 && message.IsEdns0() == nil {
+		message = message.Copy()
+		message.SetEdns0(l.ednsBufferSize, false)
+	}
+	for _, r := range l.resolvers {
+		for attempt := 0; attempt <= l.retries; attempt++ {
+			in, err = l.exchangeOnce(ctx, r, message)
+			if err == nil && in.Rcode != dns.RcodeServerFailure && in.Rcode != dns.RcodeRefused {
+				if l.log != nil && len(l.resolvers) > 1 {
+					l.log.Printf("DNS resolver %s answered the %s query for %s",
+						r.address, dns.TypeToString[message.Question[0].Qtype], name)
+				}
+				return in, nil
+			}
+			if ctx.Err() != nil {
+				return in, err
+			}
+			if l.log != nil && (len(l.resolvers) > 1 || l.retries > 0) {
+				var failure string
+				if err != nil {
+					failure = err.Error()
+				} else {
+					failure = "unsuccessful response " + dns.RcodeToString[in.Rcode]
+				}
+				l.log.Printf("DNS resolver %s failed the %s query for %s (attempt %d): %s",
+					r.address, dns.TypeToString[message.Question[0].Qtype], name, attempt+1, failure)
+			}
+		}
+	}
+	return in, err
+}
+
+// exchangeOnce sends a query to a resolver within the query timeout. A query
+// to a DNS over UDP resolver is retried over TCP if the response is truncated.
+func (l *resolverList) exchangeOnce(ctx context.Context, r *dnsResolver, message *dns.Msg) (*dns.Msg, error) {
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+	in, err := r.exchange(ctx, message, l.tcp)
+	if err != nil || !in.Truncated || l.tcp || r.tcpClient == nil {
+		return in, err
+	}
+	if l.log != nil {
+		l.log.Printf("DNS resolver %s truncated the response to the %s query for %s, retrying over TCP",
+			r.address, dns.TypeToString[message.Question[0].Qtype], message.Question[0].Name)
+	}
+	return r.exchange(ctx, message, true)
+}
+
+// newDNSResolver returns a resolver querying the resolver at the given
+// address, whose certificate is verified with the given TLS configuration for
+// DNS over TLS and HTTPS. Queries time out after the given timeout, or the
+// default timeouts of the DNS client if it is zero.
+func newDNSResolver(address string, tlsConfig *tls.Config, timeout time.Duration) (*dnsResolver, error) {
+	switch {
+	case strings.HasPrefix(address, dohScheme):
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS over HTTPS resolver URL %q", address)
+		}
+		return &dnsResolver{
+			address: address,
+			httpClient: &http.Client{
+				Timeout: validationTimeout,
+				Transport: &http.Transport{
+					TLSClientConfig:   tlsConfig.Clone(),
+					ForceAttemptHTTP2: true,
+				},
+			},
+		}, nil
+	case strings.HasPrefix(address, dotScheme):
+		hostPort := strings.TrimPrefix(address, dotScheme)
+		host, _, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			host = hostPort
+			hostPort = net.JoinHostPort(hostPort, dotPort)
+		}
+		if host == "" {
+			return nil, fmt.Errorf("invalid DNS over TLS resolver address %q", address)
+		}
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		return &dnsResolver{
+			address:   hostPort,
+			dnsClient: &dns.Client{Net: "tcp-tls", TLSConfig: config, Timeout: timeout},
+		}, nil
+	default:
+		return &dnsResolver{
+			address:   address,
+			dnsClient: &dns.Client{Timeout: timeout},
+			tcpClient: &dns.Client{Net: "tcp", Timeout: timeout},
+		}, nil
+	}
+}
+
+// exchange sends a query to the resolver, over TCP if tcp is true and it is a
+// DNS over UDP resolver, and returns its response.
+func (r *dnsResolver) exchange(ctx context.Context, message *dns.Msg, tcp bool) (*dns.Msg, error) {
+	if r.httpClient == nil {
+		client := r.dnsClient
+		if tcp && r.tcpClient != nil {
+			client = r.tcpClient
+		}
+		in, _, err := client.ExchangeContext(ctx, message, r.address)
+		return in, err
+	}
+
+	query, err := message.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.address, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS resolver %s returned status code %d", r.address, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, fmt.Errorf("unpacking DNS over HTTPS response: %s", err)
+	}
+	return in, nil
+}
@@ -0,0 +1,85 @@
+package va
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// TransientRetryConfig configures the retries of validations failing
+// transiently, because connecting to the challenge host or looking up its DNS
+// records failed, like a production CA riding out a brief DNS propagation
+// delay or server restart.
+type TransientRetryConfig struct {
+	// Number of times a transiently failing validation is retried before the
+	// attempt fails. Defaults to 0, no retries.
+	Retries int
+	// Seconds before the first retry. Defaults to 1.
+	Backoff int
+	// Factor the backoff is multiplied by after each retry. Defaults to 2.
+	Multiplier int
+	// Maximum seconds between retries, unlimited if 0
+	MaxBackoff int
+}
+
+// transientRetries is the TransientRetryConfig with the defaults applied. It
+// can be reconfigured while the VA validates challenges.
+type transientRetries struct {
+	sync.RWMutex
+	config TransientRetryConfig
+}
+
+// SetTransientRetries configures the retries of transiently failing
+// validations, replacing the configuration set before. It may be called while
+// the VA validates challenges.
+func (va *VAImpl) SetTransientRetries(config TransientRetryConfig) error {
+	if config.Retries < 0 || config.Backoff < 0 || config.Multiplier < 0 || config.MaxBackoff < 0 {
+		return fmt.Errorf("transient validation retries must not be negative")
+	}
+	if config.Backoff == 0 {
+		config.Backoff = 1
+	}
+	if config.Multiplier == 0 {
+		config.Multiplier = 2
+	}
+	if config.Retries > 0 {
+		va.log.Printf("Retrying transiently failing validations %d times after %ds, with a backoff multiplier of %d",
+			config.Retries, config.Backoff, config.Multiplier)
+	}
+
+	va.retries.Lock()
+	defer va.retries.Unlock()
+	va.retries.config = config
+	return nil
+}
+
+// validateRetryingTransient validates the challenge of a task with concurrent
+// validations, retrying them with backoff while they fail transiently and
+// retries are left. It returns the first failed result of the last
+// validations, or a successful result.
+func (va VAImpl) validateRetryingTransient(task *vaTask) *core.ValidationRecord {
+	va.retries.RLock()
+	config := va.retries.config
+	va.retries.RUnlock()
+
+	backoff := config.Backoff
+	for retry := 0; ; retry++ {
+		results := make(chan *core.ValidationRecord, concurrentValidations)
+		for i := 0; i < concurrentValidations; i++ {
+			go va.performValidation(task, results)
+		}
+		record := va.firstError(results)
+		if record.Error == nil || !record.Transient || retry == config.Retries {
+			return record
+		}
+
+		va.log.Printf("%sValidation of challenge %s failed transiently: %s. Retry %d of %d in %d seconds",
+			core.RequestLogPrefix(task.Context), task.Challenge.ID, record.Error, retry+1, config.Retries, backoff)
+		sleepSeconds(backoff)
+		backoff *= config.Multiplier
+		if config.MaxBackoff > 0 && backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+}
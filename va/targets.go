@@ -0,0 +1,92 @@
+package va
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// ValidationTarget overrides the host and port HTTP-01 and TLS-ALPN-01
+// validations of an identifier connect to, so that test services bound to
+// different ports can be validated by one Pebble instance.
+type ValidationTarget struct {
+	// "host:port" HTTP-01 validations connect to instead of the identifier on
+	// the HTTP port. The host may be empty, e.g. ":5012", to connect to the
+	// identifier on another port.
+	HTTP string
+	// "host:port" TLS-ALPN-01 validations connect to instead of the
+	// identifier on the TLS port, likewise
+	TLSALPN string
+}
+
+// target is a parsed "host:port" of a ValidationTarget.
+type target struct {
+	host string
+	port string
+}
+
+// validationTargets are the parsed validation targets by identifier, or by
+// "*." and the parent domain of the identifiers they apply to. They can be
+// reconfigured while the VA validates challenges.
+type validationTargets struct {
+	sync.RWMutex
+	byIdentifier map[string]map[string]target
+}
+
+// SetValidationTargets configures the validation targets of identifiers,
+// replacing the targets configured before. A target configured for
+// "*.example.com" applies to all subdomains of example.com without a target of
+// their own. It may be called while the VA validates challenges.
+func (va *VAImpl) SetValidationTargets(targets map[string]ValidationTarget) error {
+	byIdentifier := make(map[string]map[string]target, len(targets))
+	for identifier, t := range targets {
+		if identifier == "" {
+			return fmt.Errorf("validation targets must have an identifier")
+		}
+		byType := make(map[string]target)
+		for challType, hostPort := range map[string]string{
+			acme.ChallengeHTTP01:    t.HTTP,
+			acme.ChallengeTLSALPN01: t.TLSALPN,
+		} {
+			if hostPort == "" {
+				continue
+			}
+			host, port, err := net.SplitHostPort(hostPort)
+			if err != nil {
+				return fmt.Errorf("%s validation target of %q: %s", challType, identifier, err)
+			}
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				return fmt.Errorf("%s validation target of %q: port %q is not between 1 and 65535",
+					challType, identifier, port)
+			}
+			byType[challType] = target{host: host, port: port}
+			va.log.Printf("Connecting to %s for %s validations of %s", hostPort, challType, identifier)
+		}
+		byIdentifier[strings.ToLower(identifier)] = byType
+	}
+
+	va.targets.Lock()
+	defer va.targets.Unlock()
+	va.targets.byIdentifier = byIdentifier
+	return nil
+}
+
+// validationTarget returns the host and port the validations of a challenge
+// type for an identifier connect to, which are empty unless overridden.
+func (va VAImpl) validationTarget(identifier, challType string) (string, string) {
+	va.targets.RLock()
+	defer va.targets.RUnlock()
+	identifier = strings.ToLower(identifier)
+	byType, ok := va.targets.byIdentifier[identifier]
+	if !ok {
+		if _, parent, found := strings.Cut(identifier, "."); found {
+			byType = va.targets.byIdentifier["*."+parent]
+		}
+	}
+	t := byType[challType]
+	return t.host, t.port
+}
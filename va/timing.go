@@ -0,0 +1,70 @@
+package va
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChallengeTiming configures the timing of the validation of a challenge type.
+// It replaces the random sleep between 0 and the VA sleep time seconds for
+// challenges of the type.
+type ChallengeTiming struct {
+	// Delay is the number of seconds before the first validation attempt.
+	Delay int
+	// Attempts is the number of validation attempts before the challenge
+	// becomes invalid. Defaults to 1.
+	Attempts int
+	// RetryInterval is the number of seconds between attempts.
+	RetryInterval int
+}
+
+// challengeTimings are the configured validation timings of challenge types.
+// They can be reconfigured while the VA validates challenges.
+type challengeTimings struct {
+	sync.RWMutex
+	byType map[string]ChallengeTiming
+}
+
+// SetChallengeTimings configures the validation timing of challenge types,
+// replacing the timings configured before. It may be called while the VA
+// validates challenges.
+func (va *VAImpl) SetChallengeTimings(timings map[string]ChallengeTiming) error {
+	byType := make(map[string]ChallengeTiming, len(timings))
+	for challType, timing := range timings {
+		if !va.knownChallengeType(challType) {
+			return fmt.Errorf("unknown challenge type %q", challType)
+		}
+		if timing.Delay < 0 || timing.Attempts < 0 || timing.RetryInterval < 0 {
+			return fmt.Errorf("%s validation timing must not be negative", challType)
+		}
+		if timing.Attempts == 0 {
+			timing.Attempts = 1
+		}
+		byType[challType] = timing
+		va.log.Printf("Validating %s challenges after %ds with %d attempts %ds apart",
+			challType, timing.Delay, timing.Attempts, timing.RetryInterval)
+	}
+
+	va.timings.Lock()
+	defer va.timings.Unlock()
+	va.timings.byType = byType
+	return nil
+}
+
+// challengeTiming returns the validation timing of a challenge type, and
+// whether one is configured.
+func (va VAImpl) challengeTiming(challType string) (ChallengeTiming, bool) {
+	va.timings.RLock()
+	defer va.timings.RUnlock()
+	timing, ok := va.timings.byType[challType]
+	if !ok {
+		return ChallengeTiming{Attempts: 1}, false
+	}
+	return timing, true
+}
+
+// sleepSeconds sleeps for the given number of seconds.
+func sleepSeconds(seconds int) {
+	time.Sleep(time.Duration(seconds) * time.Second)
+}
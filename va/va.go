@@ -8,14 +8,13 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -25,7 +24,12 @@ import (
 
 	"github.com/letsencrypt/challtestsrv"
 	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
 	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/random"
+	"github.com/letsencrypt/pebble/trace"
+	"github.com/letsencrypt/pebble/webhook"
 )
 
 const (
@@ -42,33 +46,17 @@ const (
 	// How many concurrent validations are performed?
 	concurrentValidations = 3
 
-	// noSleepEnvVar defines the environment variable name used to signal that the
-	// VA should *not* sleep between validation attempts. Set this to 1 when you
-	// invoke Pebble if you wish validation to be done at full speed, e.g.:
-	//   PEBBLE_VA_NOSLEEP=1 pebble
-	noSleepEnvVar = "PEBBLE_VA_NOSLEEP"
-
-	// sleepTimeEnvVar defines the environment variable name used to set the time
-	// the VA should sleep between validation attempts (if not disabled). Set this
-	// e.g. to 5 when you invoke Pebble if you wish the delays to be between 0
-	// and 5 seconds (instead between 0 and 15 seconds):
-	//   PEBBLE_VA_SLEEPTIME=5 pebble
-	sleepTimeEnvVar = "PEBBLE_VA_SLEEPTIME"
-
 	// defaultSleepTime defines the default sleep time (in seconds) between
-	// validation attempts. Can be disabled or modified by the environment
-	// variables PEBBLE_VA_NOSLEEP resp. PEBBLE_VA_SLEEPTIME (see above).
+	// validation attempts. Can be disabled or modified with SetNoSleep resp.
+	// SetSleepTime.
 	defaultSleepTime = 5
 
 	// validationTimeout defines the timeout for validation attempts.
 	validationTimeout = 15 * time.Second
 
-	// noValidateEnvVar defines the environment variable name used to signal that
-	// the VA should *not* actually validate challenges. Set this to 1 when you
-	// invoke Pebble if you wish validation to always succeed without actually
-	// making any challenge requests, e.g.:
-	//   PEBBLE_VA_ALWAYS_VALID=1 pebble"
-	noValidateEnvVar = "PEBBLE_VA_ALWAYS_VALID"
+	// maxResponseSnippet is the number of bytes of the response of a failed
+	// HTTP-01 request recorded in the validation record.
+	maxResponseSnippet = 256
 )
 
 func userAgent() string {
@@ -89,124 +77,211 @@ func certNames(cert *x509.Certificate) string {
 }
 
 type vaTask struct {
+	// Context carries the trace span of the validation
+	Context    context.Context
 	Identifier acme.Identifier
 	Challenge  *core.Challenge
 	Account    *core.Account
+	// AccountURL is matched by the accounturi parameter of CAA records
+	AccountURL string
 }
 
 type VAImpl struct {
-	log                *log.Logger
-	httpPort           int
-	tlsPort            int
-	tasks              chan *vaTask
-	sleep              bool
-	sleepTime          int
-	alwaysValid        bool
-	strict             bool
-	customResolverAddr string
-	dnsClient          *dns.Client
+	log         *log.Logger
+	httpPort    int
+	tlsPort     int
+	tasks       chan *vaTask
+	sleep       bool
+	sleepTime   int
+	alwaysValid bool
+	strict      bool
+	clk         clock.Clock
+	// resolver resolves the identifiers of challenges, the system resolver
+	// if nil. resolverTLS verifies the certificates of the resolvers of
+	// perspectives.
+	resolver    *resolverList
+	resolverTLS *tls.Config
+	// sourceAddr is the local IP address validation connections are made
+	// from, any address if nil
+	sourceAddr net.IP
+	// timings is shared with the copies of the VA processing tasks, so that
+	// they see the configured timings
+	timings *challengeTimings
+	// caa, perspectives, redirects, proxy, family, retries, external,
+	// registered and targets are shared the same way
+	caa          *caaConfig
+	perspectives *perspectiveConfig
+	redirects    *redirectPolicy
+	proxy        *proxyConfig
+	family       *addressFamily
+	retries      *transientRetries
+	external     *externalValidators
+	registered   *challengeValidators
+	targets      *validationTargets
+	// webhooks are notified of valid and invalid authorizations
+	webhooks *webhook.Notifier
+	// store counts the outcomes of authorizations, if not nil
+	store  *db.MemoryStore
+	tracer *trace.Tracer
 }
 
 func New(
 	log *log.Logger,
+	clk clock.Clock,
 	httpPort, tlsPort int,
-	strict bool, customResolverAddr string) *VAImpl {
+	strict bool) *VAImpl {
 	va := &VAImpl{
-		log:                log,
-		httpPort:           httpPort,
-		tlsPort:            tlsPort,
-		tasks:              make(chan *vaTask, taskQueueSize),
-		sleep:              true,
-		sleepTime:          defaultSleepTime,
-		strict:             strict,
-		customResolverAddr: customResolverAddr,
-	}
-
-	if customResolverAddr != "" {
-		va.log.Printf("Using custom DNS resolver for ACME challenges: %s", customResolverAddr)
-		va.dnsClient = new(dns.Client)
-	} else {
-		va.log.Print("Using system DNS resolver for ACME challenges")
-	}
-
-	// Read the PEBBLE_VA_NOSLEEP environment variable string
-	noSleep := os.Getenv(noSleepEnvVar)
-	// If it is set to something true-like, then the VA shouldn't sleep
-	switch noSleep {
-	case "1", "true", "True", "TRUE":
-		va.sleep = false
-		va.log.Printf("Disabling random VA sleeps")
-	}
-
-	sleepTime := os.Getenv(sleepTimeEnvVar)
-	sleepTimeInt, err := strconv.Atoi(sleepTime)
-	if err == nil && va.sleep && sleepTimeInt >= 1 {
-		va.sleepTime = sleepTimeInt
-		va.log.Printf("Setting maximum random VA sleep time to %d seconds", va.sleepTime)
-	}
-
-	noValidate := os.Getenv(noValidateEnvVar)
-	switch noValidate {
-	case "1", "true", "True", "TRUE":
-		va.alwaysValid = true
-		va.log.Printf("Disabling VA challenge requests. VA always returns valid")
+		log:          log,
+		clk:          clk,
+		httpPort:     httpPort,
+		tlsPort:      tlsPort,
+		tasks:        make(chan *vaTask, taskQueueSize),
+		sleep:        true,
+		sleepTime:    defaultSleepTime,
+		strict:       strict,
+		resolverTLS:  &tls.Config{},
+		timings:      &challengeTimings{byType: make(map[string]ChallengeTiming)},
+		caa:          &caaConfig{issuerDomains: []string{defaultCAAIssuerDomain}},
+		perspectives: &perspectiveConfig{},
+		redirects:    &redirectPolicy{policy: RedirectPolicy{MaxRedirects: defaultMaxRedirects, Schemes: []string{"http", "https"}}},
+		proxy:        &proxyConfig{},
+		family:       &addressFamily{},
+		retries:      &transientRetries{config: TransientRetryConfig{Backoff: 1, Multiplier: 2}},
+		external:     &externalValidators{},
+		registered:   &challengeValidators{byType: make(map[string]ChallengeValidator)},
+		targets:      &validationTargets{},
 	}
 
 	go va.processTasks()
 	return va
 }
 
-func (va VAImpl) ValidateChallenge(ident acme.Identifier, chal *core.Challenge, acct *core.Account) {
+func (va VAImpl) ValidateChallenge(
+	ctx context.Context,
+	ident acme.Identifier,
+	chal *core.Challenge,
+	acct *core.Account,
+	acctURL string) {
 	task := &vaTask{
+		Context:    ctx,
 		Identifier: ident,
 		Challenge:  chal,
 		Account:    acct,
+		AccountURL: acctURL,
 	}
 	// Submit the task for validation
 	va.tasks <- task
 }
 
-func (va VAImpl) processTasks() {
+// processTasks uses a pointer receiver so that the VA is copied for each task
+// when it is processed, after the VA was configured.
+func (va *VAImpl) processTasks() {
 	for task := range va.tasks {
 		go va.process(task)
 	}
 }
 
-func (va VAImpl) firstError(results chan *core.ValidationRecord) *acme.ProblemDetails {
+// firstError returns the first failed result of the concurrent validations,
+// or the last result if they all succeeded.
+func (va VAImpl) firstError(results chan *core.ValidationRecord) *core.ValidationRecord {
+	var result *core.ValidationRecord
 	for i := 0; i < concurrentValidations; i++ {
-		result := <-results
+		result = <-results
 		if result.Error != nil {
-			return result.Error
+			return result
 		}
 	}
-	return nil
+	return result
 }
 
 // setAuthzValid updates an authorization and an associated challenge to be
 // status valid. The authorization expiry is updated to now plus the configured
 // `validAuthzExpire` duration.
 func (va VAImpl) setAuthzValid(authz *core.Authorization, chal *core.Challenge) {
-	authz.Lock()
-	defer authz.Unlock()
-	// Update the authz expiry for the new validity period
-	now := time.Now().UTC()
-	authz.ExpiresDate = now.Add(validAuthzExpire)
-	authz.Expires = authz.ExpiresDate.Format(time.RFC3339)
-	// Update the authz status
-	authz.Status = acme.StatusValid
+	authz.Update(func(authz *core.Authorization) {
+		// Update the authz expiry for the new validity period
+		now := va.clk.Now().UTC()
+		authz.ExpiresDate = now.Add(validAuthzExpire)
+		authz.Expires = authz.ExpiresDate.Format(time.RFC3339)
+		// Update the authz status
+		authz.Status = acme.StatusValid
+	})
 
-	chal.Lock()
-	defer chal.Unlock()
-	// Update the challenge status
-	chal.Status = acme.StatusValid
+	chal.Update(func(chal *core.Challenge) {
+		// Update the challenge status
+		chal.Status = acme.StatusValid
+	})
+
+	va.countAuthorization(authz)
+	va.webhooks.Notify(webhook.AuthorizationValid, authzEventData(authz, chal))
+}
+
+// SetAlwaysValid makes the VA consider all challenges valid without making
+// validation requests if alwaysValid is true. It must be called before the VA
+// validates challenges.
+func (va *VAImpl) SetAlwaysValid(alwaysValid bool) {
+	if alwaysValid && !va.alwaysValid {
+		va.alwaysValid = true
+		va.log.Printf("Disabling VA challenge requests. VA always returns valid")
+	}
+}
+
+// SetNoSleep disables the random sleep before validations if noSleep is true.
+// It must be called before the VA validates challenges.
+func (va *VAImpl) SetNoSleep(noSleep bool) {
+	if noSleep && va.sleep {
+		va.sleep = false
+		va.log.Printf("Disabling random VA sleeps")
+	}
+}
+
+// SetSleepTime sets the maximum number of seconds of the random sleep before
+// validations. Zero keeps the default of five seconds. It must be called
+// before the VA validates challenges.
+func (va *VAImpl) SetSleepTime(seconds int) error {
+	if seconds < 0 {
+		return errors.New("VA sleep time must not be negative")
+	}
+	if seconds > 0 {
+		va.sleepTime = seconds
+		va.log.Printf("Setting maximum random VA sleep time to %d seconds", va.sleepTime)
+	}
+	return nil
+}
+
+// SetTracer configures the tracer of challenge validations. It must be called
+// before the VA validates challenges.
+func (va *VAImpl) SetTracer(tracer *trace.Tracer) {
+	va.tracer = tracer
+}
+
+// SetWebhooks configures the webhooks notified of valid and invalid
+// authorizations. It must be called before the VA validates challenges.
+func (va *VAImpl) SetWebhooks(webhooks *webhook.Notifier) {
+	va.webhooks = webhooks
+}
+
+// SetStore configures the store counting the outcomes of authorizations in
+// its issuance statistics. It must be called before the VA validates
+// challenges.
+func (va *VAImpl) SetStore(store *db.MemoryStore) {
+	va.store = store
+}
+
+// countAuthorization counts the outcome of an authorization in the issuance
+// statistics of the store, if any.
+func (va VAImpl) countAuthorization(authz *core.Authorization) {
+	if va.store != nil {
+		va.store.CountAuthorization(authz)
+	}
 }
 
 // setOrderError updates an order with an error from an authorization
 // validation.
 func (va VAImpl) setOrderError(order *core.Order, err *acme.ProblemDetails) {
-	order.Lock()
-	defer order.Unlock()
-	order.Error = err
+	order.Update(func(order *core.Order) {
+		order.Error = err
+	})
 }
 
 // setAuthzInvalid updates an authorization and an associated challenge to be
@@ -216,68 +291,131 @@ func (va VAImpl) setAuthzInvalid(
 	authz *core.Authorization,
 	chal *core.Challenge,
 	err *acme.ProblemDetails) {
-	authz.Lock()
-	defer authz.Unlock()
-	// Update the authz status
-	authz.Status = acme.StatusInvalid
+	authz.Update(func(authz *core.Authorization) {
+		// Update the authz status
+		authz.Status = acme.StatusInvalid
+	})
+
+	chal.Update(func(chal *core.Challenge) {
+		// Update the challenge error field
+		chal.Error = err
+		// Update the challenge status
+		chal.Status = acme.StatusInvalid
+	})
+
+	va.countAuthorization(authz)
+	va.webhooks.Notify(webhook.AuthorizationInvalid, authzEventData(authz, chal))
+}
 
-	// Lock the challenge for update
-	chal.Lock()
-	defer chal.Unlock()
-	// Update the challenge error field
-	chal.Error = err
-	// Update the challenge status
-	chal.Status = acme.StatusInvalid
+// authzEventData returns the data of a webhook event about the validation of
+// an authorization with the given challenge.
+func authzEventData(authz *core.Authorization, chal *core.Challenge) webhook.AuthorizationData {
+	authzSnapshot := authz.Snapshot()
+	chalSnapshot := chal.Snapshot()
+	data := webhook.AuthorizationData{
+		ID:         authzSnapshot.ID,
+		Identifier: authzSnapshot.Identifier,
+		Challenge:  chalSnapshot.Type,
+		Error:      chalSnapshot.Error,
+	}
+	if authzSnapshot.Order != nil {
+		data.AccountID = authzSnapshot.Order.AccountID
+	}
+	return data
 }
 
 func (va VAImpl) process(task *vaTask) {
-	va.log.Printf("Pulled a task from the Tasks queue: %#v", task)
-	va.log.Printf("Starting %d validations.", concurrentValidations)
+	prefix := core.RequestLogPrefix(task.Context)
+	va.log.Printf("%sPulled a task from the Tasks queue: %#v", prefix, task)
+	va.log.Printf("%sStarting %d validations.", prefix, concurrentValidations)
 
 	chal := task.Challenge
-	chal.Lock()
-	// Update the validated date for the challenge
-	now := time.Now().UTC()
-	chal.ValidatedDate = now
-	chal.Validated = chal.ValidatedDate.Format(time.RFC3339)
+	chal.Update(func(chal *core.Challenge) {
+		// The challenge is processing until the validation attempts complete
+		chal.Status = acme.StatusProcessing
+		// Update the validated date for the challenge
+		now := va.clk.Now().UTC()
+		chal.ValidatedDate = now
+		chal.Validated = chal.ValidatedDate.Format(time.RFC3339)
+	})
 	authz := chal.Authz
-	chal.Unlock()
-
-	results := make(chan *core.ValidationRecord, concurrentValidations)
 
-	// Start a number of go routines to perform concurrent validations
-	for i := 0; i < concurrentValidations; i++ {
-		go va.performValidation(task, results)
+	ctx, span := va.tracer.Start(task.Context, "va.validate", trace.KindInternal)
+	defer span.End()
+	span.SetAttribute("pebble.challenge.type", chal.Type)
+	span.SetAttribute("pebble.identifier", task.Identifier.Value)
+
+	timing, _ := va.challengeTiming(chal.Type)
+	if timing.Delay > 0 {
+		va.log.Printf("%sSleeping for %d seconds before validating", prefix, timing.Delay)
+		sleepSeconds(timing.Delay)
+	}
+
+	var err *acme.ProblemDetails
+	var record *core.ValidationRecord
+	for attempt := 1; attempt <= timing.Attempts; attempt++ {
+		attemptCtx, attemptSpan := va.tracer.Start(ctx, "va.attempt", trace.KindInternal)
+		attemptSpan.SetAttribute("pebble.attempt", attempt)
+		attemptTask := *task
+		attemptTask.Context = attemptCtx
+
+		record = va.validateRetryingTransient(&attemptTask)
+		err = record.Error
+		// Once the primary validation succeeded, the challenge must be
+		// validated from the quorum of the remote perspectives
+		if err == nil {
+			err = va.validateFromPerspectives(&attemptTask)
+		}
+		if err != nil {
+			attemptSpan.SetError(err.Error())
+		}
+		attemptSpan.End()
+		if err == nil || attempt == timing.Attempts {
+			break
+		}
+		va.log.Printf("%sValidation attempt %d of %d for challenge %s failed: %s. Retrying in %d seconds",
+			prefix, attempt, timing.Attempts, chal.ID, err, timing.RetryInterval)
+		sleepSeconds(timing.RetryInterval)
+	}
+	chal.Update(func(chal *core.Challenge) {
+		chal.ValidationRecord = record.Transcript
+	})
+	// The CAA records of the identifier must authorize issuance once the
+	// challenge is validated, unless validation is skipped
+	if err == nil && !va.alwaysValid {
+		err = va.checkCAA(task)
 	}
-
-	err := va.firstError(results)
 	// If one of the results was an error, the challenge fails
 	if err != nil {
+		span.SetError(err.Error())
+		// The problem refers to the request that started the validation
+		err.RequestID = core.RequestID(task.Context)
 		va.setAuthzInvalid(authz, chal, err)
-		va.log.Printf("authz %s set INVALID by completed challenge %s", authz.ID, chal.ID)
+		va.log.Printf("%sauthz %s set INVALID by completed challenge %s", prefix, authz.ID, chal.ID)
 		va.setOrderError(authz.Order, err)
-		va.log.Printf("order %s set INVALID by invalid authz %s", authz.Order.ID, authz.ID)
+		va.log.Printf("%sorder %s set INVALID by invalid authz %s", prefix, authz.Order.ID, authz.ID)
 		return
 	}
 
 	// If there was no error, then the challenge succeeded and the authz is valid
 	va.setAuthzValid(authz, chal)
-	va.log.Printf("authz %s set VALID by completed challenge %s", authz.ID, chal.ID)
+	va.log.Printf("%sauthz %s set VALID by completed challenge %s", prefix, authz.ID, chal.ID)
 }
 
 func (va VAImpl) performValidation(task *vaTask, results chan<- *core.ValidationRecord) {
-	if va.sleep {
+	if _, timed := va.challengeTiming(task.Challenge.Type); va.sleep && !timed {
 		// Sleep for a random amount of time between 0 and va.sleepTime seconds
-		len := time.Duration(rand.Intn(va.sleepTime))
-		va.log.Printf("Sleeping for %s seconds before validating", time.Second*len)
+		len := time.Duration(random.Intn(va.sleepTime))
+		va.log.Printf("%sSleeping for %s seconds before validating",
+			core.RequestLogPrefix(task.Context), time.Second*len)
 		time.Sleep(time.Second * len)
 	}
 
 	// If `alwaysValid` is true then return a validation record immediately
 	// without actually making any validation requests.
 	if va.alwaysValid {
-		va.log.Printf("%s is enabled. Skipping real validation of challenge %s",
-			noValidateEnvVar, task.Challenge.ID)
+		va.log.Printf("%salwaysValid is enabled. Skipping real validation of challenge %s",
+			core.RequestLogPrefix(task.Context), task.Challenge.ID)
 		// NOTE(@cpu): The validation record's URL will not match the value it would
 		// have received in a real validation request. For simplicity when faking
 		// validation we always set it to the task identifier regardless of challenge
@@ -285,11 +423,16 @@ func (va VAImpl) performValidation(task *vaTask, results chan<- *core.Validation
 		// the URL to the `_acme-challenge` subdomain.
 		results <- &core.ValidationRecord{
 			URL:         task.Identifier.Value,
-			ValidatedAt: time.Now(),
+			ValidatedAt: va.clk.Now(),
 		}
 		return
 	}
 
+	if v := va.externalValidator(task.Challenge.Type); v != nil {
+		results <- va.validateExternal(task, v)
+		return
+	}
+
 	switch task.Challenge.Type {
 	case acme.ChallengeHTTP01:
 		results <- va.validateHTTP01(task)
@@ -297,8 +440,14 @@ func (va VAImpl) performValidation(task *vaTask, results chan<- *core.Validation
 		results <- va.validateTLSALPN01(task)
 	case acme.ChallengeDNS01:
 		results <- va.validateDNS01(task)
+	case acme.ChallengeOnionCSR01:
+		results <- va.validateOnionCSR01(task)
 	default:
-		va.log.Printf("Error: performValidation(): Invalid challenge type: %q", task.Challenge.Type)
+		validate, ok := va.registeredValidator(task.Challenge.Type)
+		if !ok {
+			va.log.Printf("Error: performValidation(): Invalid challenge type: %q", task.Challenge.Type)
+		}
+		results <- va.validateRegistered(task, validate)
 	}
 }
 
@@ -308,12 +457,17 @@ func (va VAImpl) validateDNS01(task *vaTask) *core.ValidationRecord {
 
 	result := &core.ValidationRecord{
 		URL:         challengeSubdomain,
-		ValidatedAt: time.Now(),
+		ValidatedAt: va.clk.Now(),
 	}
 
 	txts, err := va.getTXTEntry(challengeSubdomain)
+	result.Transcript = []acme.ValidationRecord{{
+		Hostname:   challengeSubdomain,
+		TXTRecords: txts,
+	}}
 	if err != nil {
 		result.Error = acme.UnauthorizedProblem(fmt.Sprintf("Error retrieving TXT records for DNS challenge (%q)", err))
+		result.Transient = true
 		return result
 	}
 
@@ -323,10 +477,8 @@ func (va VAImpl) validateDNS01(task *vaTask) *core.ValidationRecord {
 		return result
 	}
 
-	task.Challenge.RLock()
-	expectedKeyAuthorization := task.Challenge.ExpectedKeyAuthorization(task.Account.Key)
+	expectedKeyAuthorization := task.Challenge.Snapshot().ExpectedKeyAuthorization(task.Account.Key)
 	h := sha256.Sum256([]byte(expectedKeyAuthorization))
-	task.Challenge.RUnlock()
 	authorizedKeysDigest := base64.RawURLEncoding.EncodeToString(h[:])
 
 	for _, element := range txts {
@@ -342,6 +494,14 @@ func (va VAImpl) validateDNS01(task *vaTask) *core.ValidationRecord {
 
 func (va VAImpl) validateTLSALPN01(task *vaTask) *core.ValidationRecord {
 	portString := strconv.Itoa(va.tlsPort)
+	host := task.Identifier.Value
+	targetHost, targetPort := va.validationTarget(task.Identifier.Value, acme.ChallengeTLSALPN01)
+	if targetHost != "" {
+		host = targetHost
+	}
+	if targetPort != "" {
+		portString = targetPort
+	}
 
 	var serverNameIdentifier string
 	switch task.Identifier.Type {
@@ -352,30 +512,33 @@ func (va VAImpl) validateTLSALPN01(task *vaTask) *core.ValidationRecord {
 	}
 	result := &core.ValidationRecord{
 		URL:         net.JoinHostPort(task.Identifier.Value, portString),
-		ValidatedAt: time.Now(),
+		Transcript:  []acme.ValidationRecord{{Hostname: host, Port: portString}},
+		ValidatedAt: va.clk.Now(),
 	}
 
-	addrs, err := va.resolveIP(task.Identifier.Value)
+	addrs, err := va.resolveIP(host)
 
 	if err != nil {
 		result.Error = acme.MalformedProblem(
-			fmt.Sprintf("Error occurred while resolving URL %q: %q", task.Identifier.Value, err))
+			fmt.Sprintf("Error occurred while resolving URL %q: %q", host, err))
+		result.Transient = true
 		return result
 	}
 
 	if len(addrs) == 0 {
 		result.Error = acme.MalformedProblem(
-			fmt.Sprintf("Could not resolve URL %q", task.Identifier.Value))
+			fmt.Sprintf("Could not resolve URL %q", host))
 		return result
 	}
 
-	cs, problem := va.fetchConnectionState(net.JoinHostPort(addrs[0], portString), &tls.Config{
+	cs, problem := va.fetchConnectionState(task, addrs, portString, &tls.Config{
 		ServerName:         serverNameIdentifier,
 		NextProtos:         []string{acme.ACMETLS1Protocol},
 		InsecureSkipVerify: true,
-	})
+	}, &result.Transcript[0])
 	if problem != nil {
 		result.Error = problem
+		result.Transient = true
 		return result
 	}
 
@@ -411,7 +574,7 @@ func (va VAImpl) validateTLSALPN01(task *vaTask) *core.ValidationRecord {
 			"Incorrect validation certificate for %s challenge. "+
 				"Requested %s from %s. Received %d certificate(s), "+
 				"first certificate had names %q",
-			acme.ChallengeTLSALPN01, task.Identifier, net.JoinHostPort(task.Identifier.Value, portString), len(certs), names)
+			acme.ChallengeTLSALPN01, task.Identifier, net.JoinHostPort(host, portString), len(certs), names)
 		result.Error = acme.UnauthorizedProblem(errText)
 		return result
 	}
@@ -449,13 +612,38 @@ func (va VAImpl) validateTLSALPN01(task *vaTask) *core.ValidationRecord {
 	return result
 }
 
-func (va VAImpl) fetchConnectionState(hostPort string, config *tls.Config) (*tls.ConnectionState, *acme.ProblemDetails) {
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: validationTimeout}, "tcp", hostPort, config)
+func (va VAImpl) fetchConnectionState(
+	task *vaTask,
+	addrs []string,
+	port string,
+	config *tls.Config,
+	record *acme.ValidationRecord,
+) (*tls.ConnectionState, *acme.ProblemDetails) {
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+
+	rawConn, err := va.dialAddresses(ctx, "tcp", record.Hostname, addrs, port, record, core.RequestLogPrefix(task.Context))
+	var conn *tls.Conn
+	if err == nil {
+		conn = tls.Client(rawConn, config)
+		err = conn.HandshakeContext(ctx)
+		if err != nil {
+			_ = rawConn.Close()
+		}
+	}
 
 	if err != nil {
+		// Report the address connected to last, or the host if no address
+		// could be connected to
+		addr := record.AddressUsed
+		if addr == "" && len(record.AddressesTried) > 0 {
+			addr = record.AddressesTried[len(record.AddressesTried)-1]
+		} else if addr == "" {
+			addr = record.Hostname
+		}
 		// TODO(@cpu): Return better err - see parseHTTPConnError from boulder
 		return nil, acme.UnauthorizedProblem(
-			fmt.Sprintf("Failed to connect to %s for the %s challenge", hostPort, acme.ChallengeTLSALPN01))
+			fmt.Sprintf("Failed to connect to %s for the %s challenge", net.JoinHostPort(addr, port), acme.ChallengeTLSALPN01))
 	}
 
 	// close errors are not important here
@@ -467,14 +655,22 @@ func (va VAImpl) fetchConnectionState(hostPort string, config *tls.Config) (*tls
 	return &cs, nil
 }
 
-func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
-	body, url, err := va.fetchHTTP(task.Identifier.Value, task.Challenge.Token)
+// dialer returns the dialer of validation connections, which are made from
+// the source address of the VA, if any.
+func (va VAImpl) dialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: validationTimeout}
+	if va.sourceAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: va.sourceAddr}
+	}
+	return dialer
+}
 
+func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
 	result := &core.ValidationRecord{
-		URL:         url,
-		ValidatedAt: time.Now(),
-		Error:       err,
+		ValidatedAt: va.clk.Now(),
 	}
+	body, err := va.fetchHTTP(task.Context, task.Identifier.Value, task.Challenge.Token, result)
+	result.Error = err
 	if result.Error != nil {
 		return result
 	}
@@ -483,6 +679,7 @@ func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
 	// The server SHOULD ignore whitespace characters at the end of the body
 	payload := strings.TrimRight(string(body), whitespaceCutset)
 	if payload != expectedKeyAuthorization {
+		result.Transcript[len(result.Transcript)-1].ResponseSnippet = responseSnippet(body)
 		result.Error = acme.UnauthorizedProblem(
 			fmt.Sprintf("The key authorization file from the server did not match this challenge %q != %q",
 				expectedKeyAuthorization, payload))
@@ -493,25 +690,35 @@ func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
 
 // NOTE(@cpu): fetchHTTP only fetches the ACME HTTP-01 challenge path for
 // a given challenge & identifier domain. It is not a challenge agnostic general
-// purpose HTTP function
-func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *acme.ProblemDetails) {
+// purpose HTTP function. It records the URL it fetched, the redirects it
+// followed and the addresses it connected to in the validation record.
+func (va VAImpl) fetchHTTP(ctx context.Context, identifier string, token string, record *core.ValidationRecord) ([]byte, *acme.ProblemDetails) {
 	path := fmt.Sprintf("%s%s", acme.HTTP01BaseURL, token)
 	portString := strconv.Itoa(va.httpPort)
+	// The identifier may be served by another host and port, in which case
+	// requests for the identifier connect to that host
+	targetHost, targetPort := va.validationTarget(identifier, acme.ChallengeHTTP01)
+	if targetPort != "" {
+		portString = targetPort
+	}
 
 	url := &url.URL{
 		Scheme: "http",
 		Host:   net.JoinHostPort(identifier, portString),
 		Path:   path,
 	}
+	record.URL = url.String()
+	record.Transcript = []acme.ValidationRecord{httpValidationRecord(url)}
 
-	va.log.Printf("Attempting to validate w/ HTTP: %s\n", url)
+	va.log.Printf("%sAttempting to validate w/ HTTP: %s\n", core.RequestLogPrefix(ctx), url)
 	httpRequest, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
-		return nil, url.String(), acme.MalformedProblem(
+		return nil, acme.MalformedProblem(
 			fmt.Sprintf("Invalid URL %q\n", url.String()))
 	}
 	httpRequest.Header.Set("User-Agent", userAgent())
 	httpRequest.Header.Set("Accept", "*/*")
+	trace.Inject(ctx, httpRequest.Header)
 
 	transport := &http.Transport{
 		// We don't expect to make multiple requests to a client, so close
@@ -525,12 +732,14 @@ func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *ac
 			InsecureSkipVerify: true,
 		},
 
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		DialContext: func(dialCtx context.Context, network, addr string) (net.Conn, error) {
 			host, port, err := net.SplitHostPort(addr)
 			if err != nil {
 				return nil, err
 			}
-			dialer := &net.Dialer{}
+			if targetHost != "" && strings.EqualFold(host, identifier) {
+				host = targetHost
+			}
 
 			// Control specifically which IP will be used for this request
 			addrs, err := va.resolveIP(host)
@@ -541,18 +750,30 @@ func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *ac
 				return nil, fmt.Errorf("could not resolve URL %q", url.String())
 			}
 
-			return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0], port))
+			// The request being made is the last one recorded
+			hop := &record.Transcript[len(record.Transcript)-1]
+			return va.dialAddresses(dialCtx, network, host, addrs, port, hop, core.RequestLogPrefix(ctx))
 		},
 	}
 
+	policy := va.redirectPolicy()
 	client := &http.Client{
 		Transport: transport,
 		Timeout:   validationTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if err := policy.checkRedirect(identifier, req, via); err != nil {
+				return err
+			}
+			record.Transcript = append(record.Transcript, httpValidationRecord(req.URL))
+			va.log.Printf("%sFollowing HTTP-01 redirect to %s\n", core.RequestLogPrefix(ctx), req.URL)
+			return nil
+		},
 	}
 
 	resp, err := client.Do(httpRequest)
 	if err != nil {
-		return nil, url.String(), acme.ConnectionProblem(err.Error())
+		record.Transient = true
+		return nil, acme.ConnectionProblem(err.Error())
 	}
 
 	// NOTE: This is *not* using a `io.LimitedReader` and isn't suitable for
@@ -560,36 +781,62 @@ func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *ac
 	// use Pebble anywhere that isn't a testing rig!!!
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, url.String(), acme.InternalErrorProblem(err.Error())
+		return nil, acme.InternalErrorProblem(err.Error())
 	}
 	err = resp.Body.Close()
 	if err != nil {
-		return nil, url.String(), acme.InternalErrorProblem(err.Error())
+		return nil, acme.InternalErrorProblem(err.Error())
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, url.String(), acme.UnauthorizedProblem(
+		record.Transcript[len(record.Transcript)-1].ResponseSnippet = responseSnippet(body)
+		return nil, acme.UnauthorizedProblem(
 			fmt.Sprintf("Non-200 status code from HTTP: %s returned %d",
 				url.String(), resp.StatusCode))
 	}
 
-	return body, url.String(), nil
+	return body, nil
+}
+
+// httpValidationRecord returns the record of an HTTP-01 request of a URL.
+func httpValidationRecord(u *url.URL) acme.ValidationRecord {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return acme.ValidationRecord{
+		URL:      u.String(),
+		Hostname: u.Hostname(),
+		Port:     port,
+	}
+}
+
+// responseSnippet returns the beginning of an HTTP-01 response body, as much
+// of it as is recorded in validation records.
+func responseSnippet(body []byte) string {
+	if len(body) > maxResponseSnippet {
+		body = body[:maxResponseSnippet]
+	}
+	return strings.ToValidUTF8(string(body), "\uFFFD")
 }
 
-// getTXTEntry fetches TXT entries for the given domain name using the recursive resolver located at
-// `va.customResolverAddr`, or the default system resolver if no custom resolver addr is specified
+// getTXTEntry fetches TXT entries for the given domain name using the recursive resolver
+// `va.resolver`, or the default system resolver if no custom resolver is specified
 func (va VAImpl) getTXTEntry(name string) ([]string, error) {
 	ctx, cancelfunc := context.WithTimeout(context.Background(), validationTimeout)
 	defer cancelfunc()
 
-	if va.customResolverAddr == "" {
+	if va.resolver == nil {
 		return net.DefaultResolver.LookupTXT(ctx, name)
 	}
 
 	var txts []string
 	message := new(dns.Msg)
 	message.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
-	in, _, err := va.dnsClient.ExchangeContext(ctx, message, va.customResolverAddr)
+	in, err := va.resolver.exchange(ctx, message)
 
 	if err != nil {
 		return nil, err
@@ -608,13 +855,13 @@ func (va VAImpl) getTXTEntry(name string) ([]string, error) {
 	return txts, nil
 }
 
-// resolveIP find all IPs for the given domain name using the recursive resolver located at
-// `va.customResolverAddr`, or the default system resolver if no custom resolver addr is specified
+// resolveIP find all IPs for the given domain name using the recursive resolver
+// `va.resolver`, or the default system resolver if no custom resolver is specified
 func (va VAImpl) resolveIP(name string) ([]string, error) {
 	ctx, cancelfunc := context.WithTimeout(context.Background(), validationTimeout)
 	defer cancelfunc()
 
-	if va.customResolverAddr == "" {
+	if va.resolver == nil {
 		return net.DefaultResolver.LookupHost(ctx, name)
 	}
 
@@ -628,7 +875,7 @@ func (va VAImpl) resolveIP(name string) ([]string, error) {
 
 	messageAAAA := new(dns.Msg)
 	messageAAAA.SetQuestion(dns.Fqdn(name), dns.TypeAAAA)
-	inAAAA, _, err := va.dnsClient.ExchangeContext(ctx, messageAAAA, va.customResolverAddr)
+	inAAAA, err := va.resolver.exchange(ctx, messageAAAA)
 
 	if err != nil {
 		return nil, err
@@ -642,7 +889,7 @@ func (va VAImpl) resolveIP(name string) ([]string, error) {
 
 	messageA := new(dns.Msg)
 	messageA.SetQuestion(dns.Fqdn(name), dns.TypeA)
-	inA, _, err := va.dnsClient.ExchangeContext(ctx, messageA, va.customResolverAddr)
+	inA, err := va.resolver.exchange(ctx, messageA)
 
 	if err != nil {
 		return nil, err
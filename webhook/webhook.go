@@ -0,0 +1,225 @@
+// Package webhook notifies user defined URLs of ACME object lifecycle events,
+// so that test harnesses don't have to poll the ACME endpoints to detect state
+// changes. Events are POSTed as JSON objects and failed deliveries are
+// retried.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Event types that webhooks can subscribe to
+const (
+	OrderCreated         = "order.created"
+	AuthorizationValid   = "authorization.valid"
+	AuthorizationInvalid = "authorization.invalid"
+	CertificateIssued    = "certificate.issued"
+	CertificateRevoked   = "certificate.revoked"
+)
+
+const (
+	defaultAttempts      = 3
+	defaultRetryInterval = 1
+	deliveryTimeout      = 10 * time.Second
+)
+
+var eventTypes = map[string]bool{
+	OrderCreated:         true,
+	AuthorizationValid:   true,
+	AuthorizationInvalid: true,
+	CertificateIssued:    true,
+	CertificateRevoked:   true,
+}
+
+// Config configures a webhook.
+type Config struct {
+	// URL the events are POSTed to.
+	URL string
+	// Events lists the event types sent to the webhook. All events are sent
+	// if it is empty.
+	Events []string
+	// Attempts is the number of times an event is delivered before giving up.
+	// Defaults to 3.
+	Attempts int
+	// RetryInterval is the number of seconds between delivery attempts.
+	// Defaults to 1.
+	RetryInterval int
+}
+
+// Event is the JSON body POSTed to webhooks.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// OrderData is the data of order events.
+type OrderData struct {
+	ID          string            `json:"id"`
+	AccountID   string            `json:"accountID"`
+	Identifiers []acme.Identifier `json:"identifiers"`
+}
+
+// AuthorizationData is the data of authorization events.
+type AuthorizationData struct {
+	ID         string               `json:"id"`
+	AccountID  string               `json:"accountID"`
+	Identifier acme.Identifier      `json:"identifier"`
+	Challenge  string               `json:"challenge"`
+	Error      *acme.ProblemDetails `json:"error,omitempty"`
+}
+
+// CertificateData is the data of certificate events.
+type CertificateData struct {
+	Serial    string    `json:"serial"`
+	AccountID string    `json:"accountID,omitempty"`
+	OrderID   string    `json:"orderID,omitempty"`
+	Names     []string  `json:"names"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Reason    *uint     `json:"reason,omitempty"`
+}
+
+// NewCertificateData returns the data of a certificate event for the given
+// certificate.
+func NewCertificateData(cert *core.Certificate, orderID string) CertificateData {
+	names := append([]string{}, cert.Cert.DNSNames...)
+	for _, ip := range cert.Cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return CertificateData{
+		Serial:    cert.ID,
+		AccountID: cert.AccountID,
+		OrderID:   orderID,
+		Names:     names,
+		NotBefore: cert.Cert.NotBefore,
+		NotAfter:  cert.Cert.NotAfter,
+	}
+}
+
+type hook struct {
+	url           string
+	events        map[string]bool
+	attempts      int
+	retryInterval time.Duration
+}
+
+// Notifier delivers events to the configured webhooks. A nil Notifier
+// discards all events.
+type Notifier struct {
+	log    *log.Logger
+	clk    clock.Clock
+	client *http.Client
+	hooks  []hook
+}
+
+// New creates a Notifier for the given webhooks. It returns nil if no webhooks
+// are configured.
+func New(log *log.Logger, clk clock.Clock, configs []Config) (*Notifier, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	n := &Notifier{
+		log:    log,
+		clk:    clk,
+		client: &http.Client{Timeout: deliveryTimeout},
+	}
+	for _, config := range configs {
+		u, err := url.Parse(config.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook URL %q must be an absolute http or https URL", config.URL)
+		}
+		if config.Attempts < 0 || config.RetryInterval < 0 {
+			return nil, errors.New("webhook attempts and retry interval must not be negative")
+		}
+
+		h := hook{
+			url:           config.URL,
+			attempts:      defaultAttempts,
+			retryInterval: defaultRetryInterval * time.Second,
+		}
+		if config.Attempts > 0 {
+			h.attempts = config.Attempts
+		}
+		if config.RetryInterval > 0 {
+			h.retryInterval = time.Duration(config.RetryInterval) * time.Second
+		}
+		if len(config.Events) > 0 {
+			h.events = make(map[string]bool, len(config.Events))
+			for _, e := range config.Events {
+				if !eventTypes[e] {
+					return nil, fmt.Errorf("unknown webhook event type %q", e)
+				}
+				h.events[e] = true
+			}
+		}
+		n.hooks = append(n.hooks, h)
+		log.Printf("Sending events to webhook %s", h.url)
+	}
+	return n, nil
+}
+
+// Notify sends an event of the given type to all webhooks subscribed to it.
+// Events are delivered in the background, so Notify never blocks.
+func (n *Notifier) Notify(eventType string, data interface{}) {
+	if n == nil {
+		return
+	}
+
+	body, err := json.Marshal(Event{
+		Type: eventType,
+		Time: n.clk.Now().UTC(),
+		Data: data,
+	})
+	if err != nil {
+		n.log.Printf("Error marshaling %s webhook event: %s", eventType, err)
+		return
+	}
+
+	for _, h := range n.hooks {
+		if h.events != nil && !h.events[eventType] {
+			continue
+		}
+		go n.deliver(h, eventType, body)
+	}
+}
+
+// deliver POSTs an event to a webhook, retrying until it responds with
+// a 2xx status or the attempts are exhausted.
+func (n *Notifier) deliver(h hook, eventType string, body []byte) {
+	for attempt := 1; attempt <= h.attempts; attempt++ {
+		err := n.post(h.url, body)
+		if err == nil {
+			return
+		}
+		n.log.Printf("Delivering %s event to webhook %s failed (attempt %d of %d): %s",
+			eventType, h.url, attempt, h.attempts, err)
+		if attempt < h.attempts {
+			time.Sleep(h.retryInterval)
+		}
+	}
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
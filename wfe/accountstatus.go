@@ -0,0 +1,80 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// deactivateAccountObjects invalidates the pending and ready orders of
+// a deactivated account and deactivates their pending authorizations, so that
+// the account doesn't leave live objects behind. Valid orders and
+// authorizations are kept.
+func (wfe *WebFrontEndImpl) deactivateAccountObjects(acct *core.Account) {
+	invalidated := 0
+	for _, order := range wfe.db.GetOrdersByAccountID(acct.ID) {
+		snapshot := order.Snapshot()
+		if snapshot.Status != acme.StatusPending && snapshot.Status != acme.StatusReady {
+			continue
+		}
+		for _, authz := range snapshot.AuthorizationObjects {
+			authz.Update(func(authz *core.Authorization) {
+				if authz.Status == acme.StatusPending {
+					authz.Status = acme.StatusDeactivated
+				}
+			})
+		}
+		order.Update(func(order *core.Order) {
+			order.Error = acme.UnauthorizedProblem("Account has been deactivated")
+			order.Status = acme.StatusInvalid
+		})
+		invalidated++
+	}
+	wfe.log.Printf("Invalidated %d orders of deactivated account %s", invalidated, acct.ID)
+}
+
+// handleReactivateAccount makes the deactivated account given by ID or URL
+// in the body of a POST request valid again, e.g. {"account": "1"}. The
+// orders invalidated when the account was deactivated stay invalid.
+func (wfe *WebFrontEndImpl) handleReactivateAccount(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Account string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	accountID := objectID(req.Account)
+	existingAcct := wfe.db.GetAccountByID(accountID)
+	if existingAcct == nil {
+		wfe.sendError(acme.AccountDoesNotExistProblem(fmt.Sprintf(
+			"No account %q", req.Account)), response)
+		return
+	}
+	if existingAcct.Status != acme.StatusDeactivated {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Account %q is %s, not %s", accountID, existingAcct.Status, acme.StatusDeactivated)), response)
+		return
+	}
+
+	reactivated := *existingAcct
+	reactivated.Status = acme.StatusValid
+	if err := wfe.db.UpdateAccountByID(accountID, &reactivated); err != nil {
+		wfe.sendError(acme.InternalErrorProblem(fmt.Sprintf(
+			"Error storing reactivated account: %s", err)), response)
+		return
+	}
+	wfe.log.Printf("Reactivated account %s with the management interface", accountID)
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, &reactivated)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
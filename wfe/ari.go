@@ -0,0 +1,305 @@
+package wfe
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// This file implements the renewalInfo resource of the ACME Renewal
+// Information (ARI) extension described in RFC 9773. Unless a response is
+// overridden in the store, the suggested window of a certificate is
+// proportional to its validity period, so that certificates issued in
+// short-lived mode can be renewed several times in a single test run.
+
+const (
+	// The suggested renewal window of a certificate starts two thirds and ends
+	// five sixths into its validity period unless configured otherwise.
+	ariWindowStart = 2.0 / 3
+	ariWindowEnd   = 5.0 / 6
+
+	// Clients are asked to poll renewalInfo again after a twelfth of the
+	// certificate's validity period, bounded by these durations.
+	ariMinRetryAfter = time.Minute
+	ariMaxRetryAfter = 6 * time.Hour
+)
+
+// ARIConfig configures the suggested renewal window of certificates whose
+// renewalInfo isn't overridden with the management interface.
+type ARIConfig struct {
+	// Fractions of the validity period of a certificate its suggested window
+	// starts and ends at, e.g. 0.5 for the middle. If both are zero the window
+	// starts two thirds and ends five sixths into the validity period.
+	WindowStart float64
+	WindowEnd   float64
+	// URL of a page explaining the suggested window, included in all
+	// renewalInfo responses
+	ExplanationURL string
+	// Seconds of the Retry-After header of renewalInfo responses. Zero means
+	// a twelfth of the certificate's validity period, between one minute and
+	// six hours.
+	RetryAfter int
+}
+
+// SetARI configures the suggested renewal window of certificates. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetARI(config ARIConfig) error {
+	if config.WindowStart == 0 && config.WindowEnd == 0 {
+		config.WindowStart, config.WindowEnd = ariWindowStart, ariWindowEnd
+	}
+	if config.RetryAfter < 0 {
+		return errors.New("ARI Retry-After must not be negative")
+	}
+	if config.WindowStart < 0 || config.WindowEnd > 1 || config.WindowStart >= config.WindowEnd {
+		return fmt.Errorf("ARI window from %v to %v must be within 0 and 1 and must not be empty",
+			config.WindowStart, config.WindowEnd)
+	}
+	wfe.ari = config
+	return nil
+}
+
+// renewalInfoRetryAfter returns the polling interval for the renewalInfo of
+// a certificate.
+func renewalInfoRetryAfter(cert *core.Certificate) time.Duration {
+	retryAfter := cert.Cert.NotAfter.Sub(cert.Cert.NotBefore) / 12
+	if retryAfter < ariMinRetryAfter {
+		return ariMinRetryAfter
+	}
+	if retryAfter > ariMaxRetryAfter {
+		return ariMaxRetryAfter
+	}
+	return retryAfter
+}
+
+// renewalInfo computes the renewalInfo response of a certificate. Revoked
+// certificates, and those scheduled for revocation, should be renewed
+// immediately, so their suggested window is in the past.
+func (wfe *WebFrontEndImpl) renewalInfo(cert *core.Certificate, revoked bool) *acme.RenewalInfo {
+	if info := wfe.db.GetARIResponse(cert.ID); info != nil {
+		return info
+	}
+
+	var start, end time.Time
+	var explanationURL string
+	if scheduled := wfe.db.GetScheduledRevocation(cert.ID); scheduled != nil && !revoked {
+		revoked = true
+		explanationURL = scheduled.ExplanationURL
+	}
+	if revoked {
+		end = wfe.clk.Now()
+		start = end.Add(-renewalInfoRetryAfter(cert))
+	} else {
+		notBefore := cert.Cert.NotBefore
+		validity := float64(cert.Cert.NotAfter.Sub(notBefore))
+		start = notBefore.Add(time.Duration(validity * wfe.ari.WindowStart))
+		end = notBefore.Add(time.Duration(validity * wfe.ari.WindowEnd))
+		explanationURL = wfe.ari.ExplanationURL
+	}
+	return &acme.RenewalInfo{
+		SuggestedWindow: acme.SuggestedWindow{
+			Start: start.UTC().Format(time.RFC3339),
+			End:   end.UTC().Format(time.RFC3339),
+		},
+		ExplanationURL: explanationURL,
+	}
+}
+
+// parseARICertID parses an ARI certificate identifier, the base64url encoded
+// authority key identifier and serial number of a certificate joined by
+// a period. See RFC 9773 Section 4.1.
+func parseARICertID(certID string) ([]byte, *big.Int, error) {
+	parts := strings.Split(certID, ".")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("certificate identifier %q must have two parts", certID)
+	}
+	keyID, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("certificate identifier has malformed key identifier: %s", err)
+	}
+	serial, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(serial) == 0 {
+		return nil, nil, fmt.Errorf("certificate identifier has malformed serial number")
+	}
+	return keyID, new(big.Int).SetBytes(serial), nil
+}
+
+// lookupARICertificate returns the certificate identified by an ARI
+// certificate identifier, and whether it is revoked.
+func (wfe *WebFrontEndImpl) lookupARICertificate(certID string) (*core.Certificate, bool, *acme.ProblemDetails) {
+	keyID, serial, err := parseARICertID(certID)
+	if err != nil {
+		return nil, false, acme.MalformedProblem(err.Error())
+	}
+
+	cert := wfe.db.GetCertificateBySerial(serial)
+	revoked := false
+	if cert == nil {
+		if rcert := wfe.db.GetRevokedCertificateBySerial(serial); rcert != nil {
+			cert, revoked = rcert.Certificate, true
+		}
+	}
+	if cert == nil || !bytes.Equal(cert.Cert.AuthorityKeyId, keyID) {
+		return nil, false, acme.NotFoundProblem(fmt.Sprintf(
+			"No certificate found for identifier %q", certID))
+	}
+	return cert, revoked, nil
+}
+
+// verifyReplaces checks the certificate a new order replaces, see RFC 9773
+// Section 5. The certificate must be unexpired and unrevoked, must belong to
+// the account placing the order, must share an identifier with the order and
+// must not already be replaced by another order that isn't invalid.
+func (wfe *WebFrontEndImpl) verifyReplaces(order *core.Order) *acme.ProblemDetails {
+	cert, revoked, prob := wfe.lookupARICertificate(order.Replaces)
+	if prob != nil {
+		return acme.MalformedProblem(fmt.Sprintf("Invalid replaces field: %s", prob.Detail))
+	}
+	if cert.AccountID != order.AccountID {
+		return acme.UnauthorizedProblem(fmt.Sprintf(
+			"Certificate %q being replaced is not associated with account %q",
+			order.Replaces, order.AccountID))
+	}
+	if revoked {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Certificate %q being replaced is revoked", order.Replaces))
+	}
+	if cert.Cert.NotAfter.Before(wfe.clk.Now()) {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Certificate %q being replaced expired %s", order.Replaces, cert.Cert.NotAfter))
+	}
+
+	certNames := make(map[string]bool)
+	for _, name := range cert.Cert.DNSNames {
+		certNames[strings.ToLower(name)] = true
+	}
+	for _, ip := range cert.Cert.IPAddresses {
+		certNames[ip.String()] = true
+	}
+	shared := false
+	for _, ident := range order.Identifiers {
+		shared = shared || certNames[ident.Value]
+	}
+	if !shared {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Certificate %q being replaced shares no identifier with the order", order.Replaces))
+	}
+
+	for _, other := range wfe.db.GetOrdersByAccountID(order.AccountID) {
+		snapshot := other.Snapshot()
+		if snapshot.Replaces == "" || snapshot.Status == acme.StatusInvalid {
+			continue
+		}
+		if replaced, _, _ := wfe.lookupARICertificate(snapshot.Replaces); replaced != nil && replaced.ID == cert.ID {
+			return acme.AlreadyReplacedProblem(fmt.Sprintf(
+				"Certificate %q is already replaced by order %q", order.Replaces, snapshot.ID))
+		}
+	}
+	return nil
+}
+
+// RenewalInfo serves the renewalInfo of a certificate to unauthenticated GET
+// requests.
+func (wfe *WebFrontEndImpl) RenewalInfo(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	certID := strings.TrimPrefix(request.URL.Path, renewalInfoPath)
+	cert, revoked, prob := wfe.lookupARICertificate(certID)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	retryAfter := renewalInfoRetryAfter(cert)
+	if wfe.ari.RetryAfter > 0 {
+		retryAfter = time.Duration(wfe.ari.RetryAfter) * time.Second
+	}
+	response.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.renewalInfo(cert, revoked))
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshaling renewalInfo"), response)
+		return
+	}
+}
+
+// handleARIResponses lists the renewalInfo response overrides by certificate
+// serial on GET, and overrides the renewalInfo of a certificate on POST, e.g.
+// {"serial": "2fe11459f6b06186", "start": "2025-01-01T00:00:00Z",
+// "end": "2025-01-02T00:00:00Z", "explanationURL": "https://example.com"}.
+// A POST request without start and end removes the override.
+func (wfe *WebFrontEndImpl) handleARIResponses(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req struct {
+			Serial         string
+			Start          string
+			End            string
+			ExplanationURL string
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+
+		serial, ok := new(big.Int).SetString(req.Serial, 16)
+		if !ok {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid serial number %q", req.Serial)), response)
+			return
+		}
+		cert := wfe.db.GetCertificateBySerial(serial)
+		if cert == nil {
+			if rcert := wfe.db.GetRevokedCertificateBySerial(serial); rcert != nil {
+				cert = rcert.Certificate
+			}
+		}
+		if cert == nil {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No certificate with serial %s", req.Serial)), response)
+			return
+		}
+
+		var info *acme.RenewalInfo
+		if req.Start != "" || req.End != "" {
+			start, err := time.Parse(time.RFC3339, req.Start)
+			if err != nil {
+				wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid start %q: %s", req.Start, err)), response)
+				return
+			}
+			end, err := time.Parse(time.RFC3339, req.End)
+			if err != nil {
+				wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid end %q: %s", req.End, err)), response)
+				return
+			}
+			if !end.After(start) {
+				wfe.sendError(acme.MalformedProblem("The end of the window must be after its start"), response)
+				return
+			}
+			info = &acme.RenewalInfo{
+				SuggestedWindow: acme.SuggestedWindow{
+					Start: start.UTC().Format(time.RFC3339),
+					End:   end.UTC().Format(time.RFC3339),
+				},
+				ExplanationURL: req.ExplanationURL,
+			}
+		}
+		wfe.db.SetARIResponse(cert.ID, info)
+		wfe.log.Printf("Set the renewalInfo of certificate %s to %+v", cert.ID, info)
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.db.GetARIResponses())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+package wfe
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// auditRecordKey is the request context key of the audit record of an ACME
+// request.
+type auditRecordKey struct{}
+
+// auditLog is an append-only log of ACME requests with one JSON object per
+// line.
+type auditLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time          time.Time         `json:"time"`
+	RequestID     string            `json:"requestID,omitempty"`
+	Method        string            `json:"method"`
+	Endpoint      string            `json:"endpoint"`
+	Path          string            `json:"path"`
+	AccountID     string            `json:"accountID,omitempty"`
+	KeyThumbprint string            `json:"keyThumbprint,omitempty"`
+	Identifiers   []acme.Identifier `json:"identifiers,omitempty"`
+	Status        int               `json:"status"`
+	Result        string            `json:"result"`
+	Problem       string            `json:"problem,omitempty"`
+
+	// key is the JWK that authenticated the request. It is used to find the
+	// account of requests that created the account.
+	key *jose.JSONWebKey
+}
+
+// recordingResponseWriter records the status and any problem document of the
+// response to an ACME request for the audit log and tracing.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	problem []byte
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+		w.problem = append(w.problem, data...)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// SetAuditLog appends a record of every ACME request to the file at the given
+// path. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetAuditLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %s", err)
+	}
+	wfe.auditLog = &auditLog{file: file}
+	wfe.log.Printf("Writing audit log of ACME requests to %s", path)
+	return nil
+}
+
+// startAudit starts the audit record of an ACME request to the endpoint with
+// the given pattern. It returns the response writer and request the handler
+// must use, and a function writing the record once the request was handled.
+func (wfe *WebFrontEndImpl) startAudit(
+	pattern string,
+	response http.ResponseWriter,
+	request *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	if wfe.auditLog == nil {
+		return response, request, func() {}
+	}
+
+	record := &auditRecord{
+		Time:      wfe.clk.Now().UTC(),
+		RequestID: core.RequestID(request.Context()),
+		Method:    request.Method,
+		Endpoint:  pattern,
+		Path:      request.RequestURI,
+	}
+	writer := &recordingResponseWriter{ResponseWriter: response}
+	request = request.WithContext(context.WithValue(request.Context(), auditRecordKey{}, record))
+
+	return writer, request, func() {
+		if err := recover(); err != nil {
+			// The handler aborted the response
+			record.Result = "aborted"
+			wfe.writeAuditRecord(record)
+			panic(err)
+		}
+		wfe.finishAudit(record, writer)
+	}
+}
+
+// finishAudit completes an audit record from the response and writes it.
+func (wfe *WebFrontEndImpl) finishAudit(record *auditRecord, writer *recordingResponseWriter) {
+	record.Status = writer.status
+	if record.Status == 0 {
+		record.Status = http.StatusOK
+	}
+	record.Result = "success"
+	if record.Status >= http.StatusBadRequest {
+		record.Result = "error"
+	}
+
+	if len(writer.problem) > 0 {
+		var prob acme.ProblemDetails
+		if err := json.Unmarshal(writer.problem, &prob); err == nil {
+			record.Problem = prob.Type
+		}
+	}
+
+	if record.key != nil {
+		if record.AccountID == "" {
+			if acct, err := wfe.db.GetAccountByKey(record.key); err == nil && acct != nil {
+				record.AccountID = acct.ID
+			}
+		}
+		if thumbprint, err := core.KeyThumbprint(record.key); err == nil {
+			record.KeyThumbprint = base64.RawURLEncoding.EncodeToString(thumbprint)
+		}
+	}
+
+	wfe.writeAuditRecord(record)
+}
+
+func (wfe *WebFrontEndImpl) writeAuditRecord(record *auditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		wfe.log.Printf("Error marshaling audit record: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	wfe.auditLog.Lock()
+	defer wfe.auditLog.Unlock()
+	if _, err := wfe.auditLog.file.Write(line); err != nil {
+		wfe.log.Printf("Error writing audit record: %s", err)
+	}
+}
+
+// auditKey records the JWK that authenticated an ACME request.
+func auditKey(request *http.Request, key *jose.JSONWebKey) {
+	if record, ok := request.Context().Value(auditRecordKey{}).(*auditRecord); ok {
+		record.key = key
+	}
+}
+
+// auditIdentifiers records the identifiers an ACME request refers to.
+func auditIdentifiers(request *http.Request, identifiers []acme.Identifier) {
+	if record, ok := request.Context().Value(auditRecordKey{}).(*auditRecord); ok {
+		record.Identifiers = identifiers
+	}
+}
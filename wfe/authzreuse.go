@@ -0,0 +1,182 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/random"
+)
+
+// AuthzReusePolicy configures when valid authorizations from previous orders
+// are reused in new orders, so that authorization reuse can be tested
+// deterministically.
+type AuthzReusePolicy struct {
+	// Percentage of the time valid authorizations are reused. Defaults to 50.
+	Percent *int `json:"percent,omitempty"`
+	// Percentage of the time valid authorizations are reused by identifier
+	// type, "dns" or "ip", instead of Percent
+	IdentifierTypes map[string]int `json:"identifierTypes,omitempty"`
+	// Seconds since their validation after which valid authorizations are no
+	// longer reused, unlimited if 0
+	MaxAge int `json:"maxAge,omitempty"`
+	// Identifiers whose valid authorizations are always reused. Entries like
+	// "*.example.com" match all subdomains of example.com.
+	Always []string `json:"always,omitempty"`
+	// Identifiers whose valid authorizations are never reused, likewise
+	Never []string `json:"never,omitempty"`
+}
+
+// authzReuse is the authorization reuse policy. It can be replaced with the
+// management interface while the WFE serves requests.
+type authzReuse struct {
+	sync.RWMutex
+	policy AuthzReusePolicy
+}
+
+// newAuthzReusePolicy checks an authorization reuse policy and returns it with
+// the default percentage filled in.
+func newAuthzReusePolicy(policy AuthzReusePolicy) (AuthzReusePolicy, error) {
+	if policy.Percent == nil {
+		percent := defaultAuthzReuse
+		policy.Percent = &percent
+	}
+	if *policy.Percent < 0 || *policy.Percent > 100 {
+		return policy, fmt.Errorf("authz reuse percent %d is not between 0 and 100", *policy.Percent)
+	}
+	for identType, percent := range policy.IdentifierTypes {
+		if identType != acme.IdentifierDNS && identType != acme.IdentifierIP {
+			return policy, fmt.Errorf("authz reuse: unknown identifier type %q", identType)
+		}
+		if percent < 0 || percent > 100 {
+			return policy, fmt.Errorf("authz reuse percent %d of %s identifiers is not between 0 and 100",
+				percent, identType)
+		}
+	}
+	if policy.MaxAge < 0 {
+		return policy, fmt.Errorf("authz reuse max age must not be negative")
+	}
+	always := make(map[string]bool, len(policy.Always))
+	for _, ident := range policy.Always {
+		if ident == "" {
+			return policy, fmt.Errorf("authz reuse identifiers must not be empty")
+		}
+		always[strings.ToLower(ident)] = true
+	}
+	for _, ident := range policy.Never {
+		if ident == "" {
+			return policy, fmt.Errorf("authz reuse identifiers must not be empty")
+		}
+		if always[strings.ToLower(ident)] {
+			return policy, fmt.Errorf("authz reuse identifier %q is both always and never reused", ident)
+		}
+	}
+	return policy, nil
+}
+
+// SetAuthzReusePolicy configures when valid authorizations are reused in new
+// orders. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetAuthzReusePolicy(policy AuthzReusePolicy) error {
+	policy, err := newAuthzReusePolicy(policy)
+	if err != nil {
+		return err
+	}
+	wfe.authzReuse = &authzReuse{policy: policy}
+	wfe.log.Printf("Configured to attempt authz reuse for each identifier %d%% of the time",
+		*policy.Percent)
+	return nil
+}
+
+// matchesAuthzReuseIdentifier returns whether an identifier value matches one
+// of the identifiers of an authorization reuse policy.
+func matchesAuthzReuseIdentifier(value string, idents []string) bool {
+	value = strings.ToLower(value)
+	for _, ident := range idents {
+		ident = strings.ToLower(ident)
+		if value == ident ||
+			(strings.HasPrefix(ident, "*.") && strings.HasSuffix(value, ident[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// authzValidatedDate returns when the valid challenge of an authorization was
+// validated, or the zero time if unknown.
+func authzValidatedDate(authz *core.Authorization) time.Time {
+	var validated time.Time
+	for _, chal := range authz.Snapshot().Challenges {
+		chal.RLock()
+		if chal.Status == acme.StatusValid && chal.ValidatedDate.After(validated) {
+			validated = chal.ValidatedDate
+		}
+		chal.RUnlock()
+	}
+	return validated
+}
+
+// reuseAuthorization returns whether a valid authorization is reused for an
+// identifier of a new order.
+func (wfe *WebFrontEndImpl) reuseAuthorization(ident acme.Identifier, authz *core.Authorization) bool {
+	wfe.authzReuse.RLock()
+	policy := wfe.authzReuse.policy
+	wfe.authzReuse.RUnlock()
+
+	if policy.MaxAge > 0 {
+		validated := authzValidatedDate(authz)
+		if !validated.IsZero() && wfe.clk.Now().Sub(validated) > time.Duration(policy.MaxAge)*time.Second {
+			return false
+		}
+	}
+	if matchesAuthzReuseIdentifier(ident.Value, policy.Never) {
+		return false
+	}
+	if matchesAuthzReuseIdentifier(ident.Value, policy.Always) {
+		return true
+	}
+	percent, ok := policy.IdentifierTypes[ident.Type]
+	if !ok {
+		percent = *policy.Percent
+	}
+	return random.Intn(100) < percent
+}
+
+// handleAuthzReuse returns the authorization reuse policy on GET, and replaces
+// it with the body of a POST request, e.g. {"percent": 100, "never":
+// ["example.com"]}, which has the fields of the authzReusePolicy configuration.
+func (wfe *WebFrontEndImpl) handleAuthzReuse(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req AuthzReusePolicy
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+		policy, err := newAuthzReusePolicy(req)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		wfe.authzReuse.Lock()
+		wfe.authzReuse.policy = policy
+		wfe.authzReuse.Unlock()
+		wfe.log.Printf("Configured to attempt authz reuse for each identifier %d%% of the time, "+
+			"always for [%s], never for [%s]",
+			*policy.Percent, strings.Join(policy.Always, ", "), strings.Join(policy.Never, ", "))
+	}
+
+	wfe.authzReuse.RLock()
+	policy := wfe.authzReuse.policy
+	wfe.authzReuse.RUnlock()
+	err := wfe.writeJSONResponse(response, http.StatusOK, policy)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,61 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// checkBlockedDomain returns a rejectedIdentifier problem if a DNS identifier
+// of a new order is blocked.
+func (wfe *WebFrontEndImpl) checkBlockedDomain(ident acme.Identifier) *acme.ProblemDetails {
+	if ident.Type != acme.IdentifierDNS || !wfe.db.IsDomainBlocked(ident.Value) {
+		return nil
+	}
+	return acme.RejectedIdentifierProblem(fmt.Sprintf(
+		"Identifier %q is blocked", ident.Value))
+}
+
+// handleBlockedDomains lists the blocked domains on GET, blocks the domain of
+// the body of a POST request, e.g. {"domain": "example.com", "subtree":
+// true}, and unblocks the domain of the "domain" query parameter of a DELETE
+// request, or all domains without the parameter.
+func (wfe *WebFrontEndImpl) handleBlockedDomains(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		if domain := request.URL.Query().Get("domain"); domain != "" {
+			if !wfe.db.RemoveBlockedDomain(domain) {
+				wfe.sendError(acme.MalformedProblem(
+					fmt.Sprintf("Domain %q is not blocked", domain)), response)
+				return
+			}
+			wfe.log.Printf("Unblocked domain %s", domain)
+		} else {
+			_ = wfe.db.SetBlockedDomains(nil)
+			wfe.log.Printf("Unblocked all domains")
+		}
+	default:
+		var domain core.BlockedDomain
+		if !wfe.readManagementPOST(response, request, &domain) {
+			return
+		}
+		if err := wfe.db.AddBlockedDomain(domain); err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		wfe.log.Printf("Blocked domain %s (subtree %t)", domain.Domain, domain.Subtree)
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.db.BlockedDomains())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package wfe
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Media types of the certificate formats served at certificate URLs, see RFC
+// 8555 Section 7.4.2
+const (
+	pemCertificateChainType = "application/pem-certificate-chain"
+	pkixCertType            = "application/pkix-cert"
+	pkcs7MimeType           = "application/pkcs7-mime"
+)
+
+// negotiateCertificateFormat returns the media type of the certificate format
+// most preferred by an Accept header. The PEM certificate chain is served if
+// the header doesn't accept any other format.
+func negotiateCertificateFormat(accept string) string {
+	format := pemCertificateChainType
+	bestQuality := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case pemCertificateChainType, pkixCertType, pkcs7MimeType:
+		default:
+			continue
+		}
+		if quality > bestQuality {
+			format, bestQuality = mediaType, quality
+		}
+	}
+	return format
+}
+
+// writeCertificate writes a certificate with the intermediates of the issuer
+// chain with the given index in the format negotiated with the Accept header
+// of the request: a PEM certificate chain, the DER encoded certificate alone,
+// or a PKCS#7 structure with the chain.
+func (wfe *WebFrontEndImpl) writeCertificate(
+	response http.ResponseWriter,
+	request *http.Request,
+	cert *core.Certificate,
+	no int) {
+	response.Header().Add("Vary", "Accept")
+
+	var body []byte
+	switch format := negotiateCertificateFormat(request.Header.Get("Accept")); format {
+	case pkixCertType:
+		response.Header().Set("Content-Type", pkixCertType)
+		body = cert.DER
+	case pkcs7MimeType:
+		var err error
+		if body, err = cert.PKCS7(no); err != nil {
+			wfe.sendError(acme.InternalErrorProblem("Error encoding PKCS#7 certificate chain"), response)
+			return
+		}
+		response.Header().Set("Content-Type", pkcs7MimeType)
+	default:
+		response.Header().Set("Content-Type", pemCertificateChainType+"; charset=utf-8")
+		body = wfe.pemChain(cert, no)
+	}
+	response.WriteHeader(http.StatusOK)
+	_, _ = response.Write(body)
+}
@@ -0,0 +1,118 @@
+package wfe
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
+)
+
+// readManagementPOST checks that a management request is a POST request and
+// unmarshals its JSON body into v. A problem is sent and false returned if
+// the request is not acceptable.
+func (wfe *WebFrontEndImpl) readManagementPOST(
+	response http.ResponseWriter,
+	request *http.Request,
+	v interface{}) bool {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		wfe.sendError(acme.MethodNotAllowed(), response)
+		return false
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("unable to read request body"), response)
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		wfe.sendError(acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+		return false
+	}
+	return true
+}
+
+// handleChains lists the CA's issuance chains, starting with the default
+// chain.
+func (wfe *WebFrontEndImpl) handleChains(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.ca.GetChains())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleAddChain adds an alternate issuance chain to the CA.
+func (wfe *WebFrontEndImpl) handleAddChain(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var alt ca.AlternateChain
+	if !wfe.readManagementPOST(response, request, &alt) {
+		return
+	}
+
+	no, err := wfe.ca.AddAlternateChain(alt)
+	if err != nil {
+		wfe.sendError(acme.MalformedProblem(err.Error()), response)
+		return
+	}
+	result := ca.ChainInfo{
+		Index: no,
+		Chain: wfe.ca.GetChains()[no].Chain,
+	}
+	err = wfe.writeJSONResponse(response, http.StatusCreated, result)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSetDefaultChain makes one of the CA's issuance chains the default
+// chain, e.g. to simulate a chain switch.
+func (wfe *WebFrontEndImpl) handleSetDefaultChain(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Index int
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	if err := wfe.ca.SetDefaultChain(req.Index); err != nil {
+		wfe.sendError(acme.MalformedProblem(err.Error()), response)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+// handleRotateIntermediate replaces the CA's issuing intermediate, optionally
+// revoking the old one to simulate an intermediate compromise.
+func (wfe *WebFrontEndImpl) handleRotateIntermediate(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Revoke bool
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	if err := wfe.ca.RotateIntermediate(req.Revoke); err != nil {
+		wfe.sendError(acme.InternalErrorProblem(err.Error()), response)
+		return
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.ca.GetChains())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,80 @@
+package wfe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// builtinChallengeTypes are the challenge types Pebble implements itself
+var builtinChallengeTypes = map[string]bool{
+	acme.ChallengeHTTP01:     true,
+	acme.ChallengeTLSALPN01:  true,
+	acme.ChallengeDNS01:      true,
+	acme.ChallengeOnionCSR01: true,
+}
+
+// ChallengeType configures an additional challenge type offered in
+// authorizations, besides the challenge types Pebble implements, to iterate
+// on drafts of new ACME challenge types.
+type ChallengeType struct {
+	// Name of the challenge type, e.g. "dns-account-01"
+	Name string
+	// Types of the identifiers the challenge is offered for, "dns" if empty
+	IdentifierTypes []string
+	// Offer the challenge for wildcard identifiers and subdomain
+	// authorizations too, which are only offered dns-01 challenges otherwise
+	Wildcards bool
+	// Offer decides whether the challenge is offered in an authorization
+	// instead of IdentifierTypes and Wildcards, if not nil
+	Offer func(authz *core.Authorization) bool `json:"-"`
+}
+
+// SetChallengeTypes configures the additional challenge types offered in new
+// authorizations. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetChallengeTypes(types []ChallengeType) error {
+	seen := make(map[string]bool, len(types))
+	for _, t := range types {
+		if t.Name == "" {
+			return fmt.Errorf("challenge types must have a name")
+		}
+		if builtinChallengeTypes[t.Name] || seen[t.Name] {
+			return fmt.Errorf("challenge type %q is already defined", t.Name)
+		}
+		seen[t.Name] = true
+		for _, identType := range t.IdentifierTypes {
+			if identType != acme.IdentifierDNS && identType != acme.IdentifierIP {
+				return fmt.Errorf("challenge type %q: unknown identifier type %q", t.Name, identType)
+			}
+		}
+		wfe.log.Printf("Offering %s challenges", t.Name)
+	}
+	wfe.challengeTypes = types
+	return nil
+}
+
+// offers returns whether a challenge of the type is offered in an
+// authorization.
+func (t ChallengeType) offers(authz *core.Authorization) bool {
+	if t.Offer != nil {
+		return t.Offer(authz)
+	}
+
+	ident := authz.Identifier
+	identTypes := t.IdentifierTypes
+	if len(identTypes) == 0 {
+		identTypes = []string{acme.IdentifierDNS}
+	}
+	offered := false
+	for _, identType := range identTypes {
+		offered = offered || identType == ident.Type
+	}
+	// Onion names are only offered onion-csr-01 challenges, since Pebble can't
+	// reach onion services
+	if !offered || (ident.Type == acme.IdentifierDNS && core.IsOnionName(ident.Value)) {
+		return false
+	}
+	return t.Wildcards || (!strings.HasPrefix(ident.Value, "*.") && !authz.SubdomainAuthAllowed)
+}
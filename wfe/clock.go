@@ -0,0 +1,54 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+)
+
+// clockState is the response of the management clock endpoint.
+type clockState struct {
+	Now    string `json:"now"`
+	Offset int64  `json:"offset"`
+}
+
+// handleClock shows the time of the fake clock for GET requests, and moves it
+// forward by a number of seconds for POST requests, e.g. to make orders and
+// authorizations expire or certificates enter their renewal window.
+func (wfe *WebFrontEndImpl) handleClock(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	fake, ok := wfe.clk.(*clock.FakeClock)
+	if !ok {
+		wfe.sendError(acme.MalformedProblem("Pebble is not using a fake clock"), response)
+		return
+	}
+
+	if request.Method != http.MethodGet {
+		var req struct {
+			Advance int64
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+		if err := fake.Advance(time.Duration(req.Advance) * time.Second); err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		wfe.log.Printf("Moved the clock forward by %d seconds", req.Advance)
+	}
+
+	state := clockState{
+		Now:    fake.Now().UTC().Format(time.RFC3339),
+		Offset: int64(fake.Offset() / time.Second),
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, state)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,160 @@
+package wfe
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The content codings responses may be compressed with
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// defaultCompressedEndpoints are the endpoints whose responses are compressed
+// if the compression config doesn't list any
+var defaultCompressedEndpoints = []string{"directory", "order", "certificate"}
+
+// CompressionConfig configures the compression of ACME responses for clients
+// that accept gzip or deflate content coding.
+type CompressionConfig struct {
+	// Compress responses
+	Enabled bool
+	// Endpoints whose responses are compressed, by the names used for
+	// faults, e.g. "directory". An endpoint of "*" matches all endpoints.
+	// Empty means the directory, order and certificate endpoints.
+	Endpoints []string
+}
+
+// SetCompression configures the compression of ACME responses. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetCompression(config CompressionConfig) error {
+	wfe.compressed = nil
+	if !config.Enabled {
+		return nil
+	}
+	endpoints := config.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = defaultCompressedEndpoints
+	}
+	compressed := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		if endpoint == "*" {
+			for _, pattern := range faultEndpoints {
+				compressed[pattern] = true
+			}
+			continue
+		}
+		pattern, ok := faultEndpoints[endpoint]
+		if !ok {
+			return fmt.Errorf("unknown endpoint %q", endpoint)
+		}
+		compressed[pattern] = true
+	}
+	wfe.compressed = compressed
+	wfe.log.Printf("Compressing responses of %v", endpoints)
+	return nil
+}
+
+// startCompression compresses the response to a request to the endpoint with
+// the given pattern if configured and accepted by the client. It returns the
+// response writer the handler must use, and a function finishing the
+// compressed body once the request was handled.
+func (wfe *WebFrontEndImpl) startCompression(
+	pattern string,
+	response http.ResponseWriter,
+	request *http.Request) (http.ResponseWriter, func()) {
+	if !wfe.compressed[pattern] {
+		return response, func() {}
+	}
+	response.Header().Add("Vary", "Accept-Encoding")
+	encoding := acceptedEncoding(request.Header.Get("Accept-Encoding"))
+	if encoding == "" || request.Method == http.MethodHead {
+		return response, func() {}
+	}
+
+	writer := &compressingResponseWriter{ResponseWriter: response, encoding: encoding}
+	return writer, func() {
+		if writer.compressor != nil {
+			_ = writer.compressor.Close()
+		}
+	}
+}
+
+// acceptedEncoding returns the content coding of an Accept-Encoding header to
+// compress a response with, preferring gzip, or "" if neither gzip nor deflate
+// is accepted.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		ok := true
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				q, err := strconv.ParseFloat(value, 64)
+				ok = err == nil && q > 0
+			}
+		}
+		if coding == "*" {
+			wildcard = ok
+			continue
+		}
+		if _, seen := accepted[coding]; !seen {
+			accepted[coding] = ok
+		}
+	}
+	for _, coding := range []string{encodingGzip, encodingDeflate} {
+		if ok, seen := accepted[coding]; ok || !seen && wildcard {
+			return coding
+		}
+	}
+	return ""
+}
+
+// compressingResponseWriter is a http.ResponseWriter compressing the response
+// body with a content coding. Responses without a body and responses with an
+// explicit Content-Length, like those of truncatedBody faults, are sent
+// uncompressed.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	// The compressor of the body, nil if the response isn't compressed
+	compressor io.WriteCloser
+}
+
+func (w *compressingResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+		header.Get("Content-Length") == "" && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == encodingGzip {
+			w.compressor = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			// The deflate content coding is the zlib format, see RFC 9110
+			// Section 8.4.1.2
+			w.compressor = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressingResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.compressor == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.compressor.Write(data)
+}
@@ -0,0 +1,166 @@
+package wfe
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"br, deflate, gzip", "gzip"},
+		{"deflate;q=1.0, gzip;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip; q=0.000, deflate;q=0", ""},
+		{"gzip;q=invalid, deflate", "deflate"},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"gzip;q=0, *", "deflate"},
+		{"gzip;q=0, deflate;q=0, *", ""},
+		{"gzip, gzip;q=0", "gzip"},
+	}
+	for _, tc := range testCases {
+		if got := acceptedEncoding(tc.header); got != tc.want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    CompressionConfig
+		want      []string
+		wantNot   []string
+		wantError bool
+	}{
+		{
+			name:    "disabled",
+			config:  CompressionConfig{Endpoints: []string{"*"}},
+			wantNot: []string{DirectoryPath, orderPath},
+		},
+		{
+			name:    "default endpoints",
+			config:  CompressionConfig{Enabled: true},
+			want:    []string{DirectoryPath, orderPath, certPath},
+			wantNot: []string{newOrderPath},
+		},
+		{
+			name:    "configured endpoints",
+			config:  CompressionConfig{Enabled: true, Endpoints: []string{"newOrder"}},
+			want:    []string{newOrderPath},
+			wantNot: []string{DirectoryPath},
+		},
+		{
+			name:   "all endpoints",
+			config: CompressionConfig{Enabled: true, Endpoints: []string{"*"}},
+			want:   []string{DirectoryPath, newAccountPath, renewalInfoPath},
+		},
+		{
+			name:      "unknown endpoint",
+			config:    CompressionConfig{Enabled: true, Endpoints: []string{"dir"}},
+			wantError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{log: log.New(io.Discard, "", 0)}
+			err := wfe.SetCompression(tc.config)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("SetCompression(%+v) returned error %v, want error %t", tc.config, err, tc.wantError)
+			}
+			for _, pattern := range tc.want {
+				if !wfe.compressed[pattern] {
+					t.Errorf("responses of %s aren't compressed", pattern)
+				}
+			}
+			for _, pattern := range tc.wantNot {
+				if wfe.compressed[pattern] {
+					t.Errorf("responses of %s are compressed", pattern)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressingResponseWriter(t *testing.T) {
+	const body = `{"status": "valid"}`
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		method         string
+		status         int
+		contentLength  bool
+		wantEncoding   string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", status: http.StatusOK, wantEncoding: "gzip"},
+		{name: "deflate", acceptEncoding: "deflate", status: http.StatusCreated, wantEncoding: "deflate"},
+		{name: "not accepted", acceptEncoding: "br", status: http.StatusOK},
+		{name: "HEAD", acceptEncoding: "gzip", method: http.MethodHead, status: http.StatusOK},
+		{name: "no content", acceptEncoding: "gzip", status: http.StatusNoContent},
+		{name: "explicit length", acceptEncoding: "gzip", status: http.StatusOK, contentLength: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{compressed: map[string]bool{orderPath: true}}
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			request := httptest.NewRequest(method, orderPath+"1", nil)
+			request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			recorder := httptest.NewRecorder()
+
+			response, finish := wfe.startCompression(orderPath, recorder, request)
+			if tc.contentLength {
+				response.Header().Set("Content-Length", "19")
+			}
+			response.WriteHeader(tc.status)
+			if tc.status != http.StatusNoContent {
+				_, _ = response.Write([]byte(body))
+			}
+			finish()
+
+			if got := recorder.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary header is %q, want Accept-Encoding", got)
+			}
+			if got := recorder.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+				t.Fatalf("Content-Encoding is %q, want %q", got, tc.wantEncoding)
+			}
+			var reader io.Reader = recorder.Body
+			var err error
+			switch tc.wantEncoding {
+			case "gzip":
+				reader, err = gzip.NewReader(recorder.Body)
+			case "deflate":
+				reader, err = zlib.NewReader(recorder.Body)
+			}
+			if err != nil {
+				t.Fatalf("reading %s body: %s", tc.wantEncoding, err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("reading %s body: %s", tc.wantEncoding, err)
+			}
+			want := body
+			if tc.status == http.StatusNoContent {
+				want = ""
+			}
+			if string(got) != want {
+				t.Errorf("body is %q, want %q", got, want)
+			}
+		})
+	}
+}
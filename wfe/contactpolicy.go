@@ -0,0 +1,185 @@
+package wfe
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// mailtoScheme is the URL scheme of email contacts
+const mailtoScheme = "mailto"
+
+// ContactPolicy configures the checks of the contacts of newAccount and
+// account update requests. The zero value accepts up to two mailto contacts,
+// or none.
+type ContactPolicy struct {
+	// Most contacts an account may have. Zero means the default of two.
+	MaxContacts int
+	// URL schemes of the contacts accepted, e.g. "mailto" and "tel". Empty
+	// means only "mailto". Contacts of other schemes are rejected with an
+	// unsupportedContact problem.
+	Schemes []string
+	// Accounts must have at least one mailto contact
+	RequireMailto bool
+	// Mailto contacts must be a single plain address without a display
+	// name or header fields, like "mailto:admin@example.com", whose domain
+	// has at least two labels
+	StrictMailto bool
+}
+
+// contactPolicy is the ContactPolicy with its defaults applied.
+type contactPolicy struct {
+	maxContacts   int
+	schemes       map[string]bool
+	requireMailto bool
+	strictMailto  bool
+}
+
+func newContactPolicy(policy ContactPolicy) *contactPolicy {
+	p := &contactPolicy{
+		maxContacts:   policy.MaxContacts,
+		schemes:       make(map[string]bool),
+		requireMailto: policy.RequireMailto,
+		strictMailto:  policy.StrictMailto,
+	}
+	if p.maxContacts == 0 {
+		p.maxContacts = maxContactsPerAcct
+	}
+	for _, scheme := range policy.Schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	if len(p.schemes) == 0 {
+		p.schemes[mailtoScheme] = true
+	}
+	return p
+}
+
+// SetContactPolicy configures the checks of account contacts. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetContactPolicy(policy ContactPolicy) error {
+	if policy.MaxContacts < 0 {
+		return fmt.Errorf("maxContacts must not be negative")
+	}
+	for _, scheme := range policy.Schemes {
+		if u, err := url.Parse(scheme + ":x"); err != nil || !strings.EqualFold(u.Scheme, scheme) {
+			return fmt.Errorf("invalid contact scheme %q", scheme)
+		}
+	}
+	p := newContactPolicy(policy)
+	if p.requireMailto && !p.schemes[mailtoScheme] {
+		return fmt.Errorf("requireMailto needs the %q scheme to be accepted", mailtoScheme)
+	}
+	wfe.contactPolicy = p
+	return nil
+}
+
+// isASCII determines if every character in a string is encoded in
+// the ASCII character set.
+func isASCII(str string) bool {
+	for _, r := range str {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+func (wfe *WebFrontEndImpl) verifyContacts(acct acme.Account) *acme.ProblemDetails {
+	return wfe.contactPolicy.checkContacts(acct.Contact)
+}
+
+// checkContacts returns an invalidContact problem if the contacts are too
+// many, malformed or lack a required mailto contact, and an
+// unsupportedContact problem if one has a scheme that isn't accepted.
+func (p *contactPolicy) checkContacts(contacts []string) *acme.ProblemDetails {
+	if len(contacts) > p.maxContacts {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"too many contacts provided: %d > %d", len(contacts), p.maxContacts))
+	}
+
+	hasMailto := false
+	for _, c := range contacts {
+		parsed, err := url.Parse(c)
+		if err != nil {
+			return acme.InvalidContactProblem(fmt.Sprintf("contact %q is invalid", c))
+		}
+		scheme := strings.ToLower(parsed.Scheme)
+		if !p.schemes[scheme] {
+			return acme.UnsupportedContactProblem(fmt.Sprintf(
+				"contact method %q is not supported", parsed.Scheme))
+		}
+		if scheme != mailtoScheme {
+			// Other schemes are accepted as long as the contact isn't empty
+			if parsed.Opaque == "" && parsed.Host == "" && parsed.Path == "" {
+				return acme.InvalidContactProblem(fmt.Sprintf("contact %q is empty", c))
+			}
+			continue
+		}
+		if prob := p.checkMailto(parsed); prob != nil {
+			return prob
+		}
+		hasMailto = true
+	}
+
+	// Providing no Contacts is perfectly acceptable unless a mailto contact
+	// is required
+	if p.requireMailto && !hasMailto {
+		return acme.InvalidContactProblem("at least one mailto contact is required")
+	}
+	return nil
+}
+
+// checkMailto returns an invalidContact problem if a mailto contact isn't a
+// valid email address, or not a strict one if mailto contacts must be.
+func (p *contactPolicy) checkMailto(parsed *url.URL) *acme.ProblemDetails {
+	email := parsed.Opaque
+	// An empty or omitted Contact array should be used instead of an empty contact
+	if email == "" {
+		return acme.InvalidContactProblem("empty contact email")
+	}
+	if !isASCII(email) {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"contact email %q contains non-ASCII characters", email))
+	}
+	// NOTE(@cpu): ParseAddress may allow invalid emails since it supports RFC 5322
+	// display names. This is sufficient for Pebble because we don't intend to
+	// use the emails for anything and check this as a best effort for client
+	// developers to test invalid contact problems.
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"contact email %q is invalid", email))
+	}
+	if !p.strictMailto {
+		return nil
+	}
+
+	if parsed.RawQuery != "" || parsed.ForceQuery {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"contact email %q must not have header fields", email))
+	}
+	if addr.Name != "" || addr.Address != email {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"contact email %q must be a single address without a display name", email))
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if labels := strings.Split(domain, "."); len(labels) < 2 || containsEmpty(labels) {
+		return acme.InvalidContactProblem(fmt.Sprintf(
+			"contact email %q must have a domain with at least two labels", email))
+	}
+	return nil
+}
+
+// containsEmpty returns true if one of the strings is empty.
+func containsEmpty(strs []string) bool {
+	for _, s := range strs {
+		if s == "" {
+			return true
+		}
+	}
+	return false
+}
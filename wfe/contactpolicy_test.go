@@ -0,0 +1,103 @@
+package wfe
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+func TestSetContactPolicy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  ContactPolicy
+		wantErr bool
+	}{
+		{name: "defaults", policy: ContactPolicy{}},
+		{name: "schemes", policy: ContactPolicy{Schemes: []string{"mailto", "TEL", "https"}, RequireMailto: true}},
+		{name: "negative maximum", policy: ContactPolicy{MaxContacts: -1}, wantErr: true},
+		{name: "invalid scheme", policy: ContactPolicy{Schemes: []string{"mail to"}}, wantErr: true},
+		{name: "scheme with colon", policy: ContactPolicy{Schemes: []string{"tel:"}}, wantErr: true},
+		{name: "mailto required but rejected", policy: ContactPolicy{Schemes: []string{"tel"}, RequireMailto: true}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{}
+			err := wfe.SetContactPolicy(tc.policy)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("SetContactPolicy(%+v) returned error %v, want error %t", tc.policy, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckContacts(t *testing.T) {
+	invalid := acme.InvalidContactProblem("").Type
+	unsupported := acme.UnsupportedContactProblem("").Type
+	strict := ContactPolicy{StrictMailto: true}
+	testCases := []struct {
+		name     string
+		policy   ContactPolicy
+		contacts []string
+		want     string
+	}{
+		{name: "no contacts"},
+		{name: "mailto", contacts: []string{"mailto:admin@example.com"}},
+		{name: "two contacts", contacts: []string{"mailto:a@example.com", "mailto:b@example.com"}},
+		{
+			name:     "too many contacts",
+			contacts: []string{"mailto:a@example.com", "mailto:b@example.com", "mailto:c@example.com"},
+			want:     invalid,
+		},
+		{
+			name:     "raised maximum",
+			policy:   ContactPolicy{MaxContacts: 3},
+			contacts: []string{"mailto:a@example.com", "mailto:b@example.com", "mailto:c@example.com"},
+		},
+		{name: "unsupported scheme", contacts: []string{"tel:+12025550123"}, want: unsupported},
+		{
+			name:     "accepted scheme",
+			policy:   ContactPolicy{Schemes: []string{"mailto", "tel"}},
+			contacts: []string{"TEL:+12025550123"},
+		},
+		{
+			name:     "empty contact of accepted scheme",
+			policy:   ContactPolicy{Schemes: []string{"tel"}},
+			contacts: []string{"tel:"},
+			want:     invalid,
+		},
+		{name: "empty email", contacts: []string{"mailto:"}, want: invalid},
+		{name: "non-ASCII email", contacts: []string{"mailto:admin@exämple.com"}, want: invalid},
+		{name: "malformed email", contacts: []string{"mailto:admin"}, want: invalid},
+		{name: "malformed URL", contacts: []string{"mailto:%zz"}, want: invalid},
+		{name: "display name", contacts: []string{"mailto:Admin <admin@example.com>"}},
+		{name: "single label domain", contacts: []string{"mailto:admin@localhost"}},
+		{
+			name:     "mailto required",
+			policy:   ContactPolicy{Schemes: []string{"mailto", "tel"}, RequireMailto: true},
+			contacts: []string{"tel:+12025550123"},
+			want:     invalid,
+		},
+		{
+			name:   "mailto required without contacts",
+			policy: ContactPolicy{RequireMailto: true},
+			want:   invalid,
+		},
+		{name: "strict", policy: strict, contacts: []string{"mailto:admin@example.com"}},
+		{name: "strict display name", policy: strict, contacts: []string{"mailto:Admin <admin@example.com>"}, want: invalid},
+		{name: "strict header fields", policy: strict, contacts: []string{"mailto:admin@example.com?subject=hi"}, want: invalid},
+		{name: "strict single label domain", policy: strict, contacts: []string{"mailto:admin@localhost"}, want: invalid},
+		{name: "strict empty label", policy: strict, contacts: []string{"mailto:admin@example..com"}, want: invalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prob := newContactPolicy(tc.policy).checkContacts(tc.contacts)
+			var got string
+			if prob != nil {
+				got = prob.Type
+			}
+			if got != tc.want {
+				t.Errorf("checkContacts(%q) returned problem %+v, want type %q", tc.contacts, prob, tc.want)
+			}
+		})
+	}
+}
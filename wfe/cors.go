@@ -0,0 +1,160 @@
+package wfe
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultCORSMaxAge = 5
+
+// CORSConfig configures the CORS headers of ACME responses, which let
+// browser-based ACME clients use the API. The zero value allows all origins.
+type CORSConfig struct {
+	// Disable CORS, browsers then reject cross-origin responses
+	Disabled bool
+	// Origins allowed to read responses, e.g. "https://app.example.com", or
+	// "*" for all origins. Defaults to all origins.
+	AllowedOrigins []string
+	// Request headers allowed in preflighted requests. Defaults to
+	// "Content-Type", which requests with "application/jose+json" bodies need.
+	AllowedHeaders []string
+	// Response headers exposed to clients. Defaults to "Link",
+	// "Replay-Nonce", "Location" and "X-Request-Id".
+	ExposedHeaders []string
+	// Seconds browsers may cache preflight responses. Defaults to 5.
+	MaxAge int
+}
+
+// cors is the CORS configuration with the defaults applied.
+type cors struct {
+	disabled       bool
+	allOrigins     bool
+	origins        map[string]bool
+	allowedHeaders string
+	exposedHeaders string
+	maxAge         string
+}
+
+func defaultCORS() *cors {
+	return newCORS(CORSConfig{})
+}
+
+func newCORS(config CORSConfig) *cors {
+	c := &cors{
+		disabled:       config.Disabled,
+		allOrigins:     len(config.AllowedOrigins) == 0,
+		origins:        make(map[string]bool),
+		allowedHeaders: "Content-Type",
+		exposedHeaders: "Link, Replay-Nonce, Location, " + requestIDHeader,
+		maxAge:         strconv.Itoa(defaultCORSMaxAge),
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			c.allOrigins = true
+		}
+		c.origins[origin] = true
+	}
+	if len(config.AllowedHeaders) > 0 {
+		c.allowedHeaders = strings.Join(config.AllowedHeaders, ", ")
+	}
+	if len(config.ExposedHeaders) > 0 {
+		c.exposedHeaders = strings.Join(config.ExposedHeaders, ", ")
+	}
+	if config.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(config.MaxAge)
+	}
+	return c
+}
+
+// SetCORS configures the CORS headers of ACME responses. It must be called
+// before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetCORS(config CORSConfig) error {
+	if config.MaxAge < 0 {
+		return errors.New("maxAge must not be negative")
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid origin %q, expected e.g. \"https://app.example.com\"", origin)
+		}
+	}
+
+	wfe.cors = newCORS(config)
+	switch {
+	case config.Disabled:
+		wfe.log.Printf("Disabling CORS")
+	case len(config.AllowedOrigins) > 0:
+		wfe.log.Printf("Allowing CORS requests from %s", strings.Join(config.AllowedOrigins, ", "))
+	}
+	return nil
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header
+// for the origin of a request, or "" if the origin isn't allowed.
+func (c *cors) allowedOrigin(origin string) string {
+	switch {
+	case c.disabled:
+		return ""
+	case c.allOrigins:
+		return "*"
+	case c.origins[origin]:
+		return origin
+	}
+	return ""
+}
+
+// processCORS reads and writes all necessary request and response headers in order to
+// enable use by CORS-aware user agents. If the request is a CORS preflight request, the
+// function returns true, in which case no further data may be written to the response.
+func (wfe *WebFrontEndImpl) processCORS(request *http.Request, response http.ResponseWriter,
+	allowedMethodsMap map[string]bool) bool {
+	// 6.1.1, 6.2.1. No Origin header means CORS is not relevant.
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	// The response varies with the origin unless all origins are allowed
+	if !wfe.cors.allOrigins {
+		response.Header().Add("Vary", "Origin")
+	}
+	// 6.1.2, 6.2.2. Terminate the CORS processing if the origin isn't allowed.
+	allowOrigin := wfe.cors.allowedOrigin(origin)
+	if allowOrigin == "" {
+		return false
+	}
+
+	// 6.2. Request is a CORS preflight
+	if request.Method == http.MethodOptions {
+		// 6.2.3, 6.2.5. -Request-Method must be present and must be a match for one of the allowed methods
+		method := request.Header.Get("Access-Control-Request-Method")
+		if _, allowed := allowedMethodsMap[method]; method == "" || !allowed {
+			return false
+		}
+		// 6.2.4, 6.2.6. -Request-Headers is not processed, the allowed headers are sent.
+		// 6.2.7. Send -Allow-Origin, without -Allow-Credentials support.
+		response.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		// 6.2.8. Send -Max-Age.
+		response.Header().Set("Access-Control-Max-Age", wfe.cors.maxAge)
+		// 6.2.9. -Allow-Methods is not sent because ACME only uses simple methods.
+		// 6.2.10. -Allow-Headers required for "Content-Type: application/jose+json"
+		response.Header().Set("Access-Control-Allow-Headers", wfe.cors.allowedHeaders)
+		// Terminate the request
+		response.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	// 6.1. Otherwise, request is a CORS simple or actual request.
+	// 6.1.3. Send -Allow-Origin, without Allow-Credentials support.
+	response.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	// 6.1.4. Send -Expose-Headers for the response headers ACME uses.
+	response.Header().Set("Access-Control-Expose-Headers", wfe.cors.exposedHeaders)
+	// Continue processing the request
+	return false
+}
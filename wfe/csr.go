@@ -0,0 +1,236 @@
+package wfe
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec // Debian weak key lists hash moduli with SHA-1
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// Extensions a CSR may request if extraneous extensions are rejected
+var allowedCSRExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},               // subjectKeyIdentifier
+	{2, 5, 29, 15},               // keyUsage
+	{2, 5, 29, 17},               // subjectAltName
+	{2, 5, 29, 19},               // basicConstraints
+	{2, 5, 29, 37},               // extKeyUsage
+	{1, 3, 6, 1, 5, 5, 7, 1, 24}, // TLS feature (must-staple)
+}
+
+// smallPrimesProduct is the product of the primes below 1000, which no RSA
+// modulus of a good key shares a factor with.
+var smallPrimesProduct = func() *big.Int {
+	product := big.NewInt(1)
+	for p := int64(2); p < 1000; p++ {
+		if big.NewInt(p).ProbablyPrime(0) {
+			product.Mul(product, big.NewInt(p))
+		}
+	}
+	return product
+}()
+
+// fermatRounds is the number of Fermat factorization rounds tried on RSA
+// moduli, which factors moduli whose primes are too close together.
+const fermatRounds = 100
+
+// CSRPolicy configures the checks of the CSRs of finalize requests, in
+// addition to those always made: the CSR names must exactly match the order
+// identifiers and the CSR key must not be the key of an account. All checks
+// are off by default.
+type CSRPolicy struct {
+	// The subject common name, if any, must be one of the CSR names, and the
+	// names must be lower case and must not repeat
+	ExactNames bool
+	// Smallest and largest RSA key sizes in bits accepted. Zero means no
+	// limit.
+	MinRSAKeySize int
+	MaxRSAKeySize int
+	// Reject weak RSA keys: those with a public exponent other than 65537, a
+	// modulus with a small prime factor, or primes close enough to factor
+	RejectWeakRSAKeys bool
+	// File listing known-bad RSA keys in the format of the Debian
+	// openssl-blacklist: one hex encoded SHA-1 hash of "Modulus=<HEX>\n", or
+	// its last 20 hex digits, per line. Lines starting with "#" are ignored.
+	WeakKeysFile string
+	// Reject CSRs requesting extensions other than subjectAltName, keyUsage,
+	// extKeyUsage, basicConstraints, subjectKeyIdentifier and must-staple
+	RejectExtraExtensions bool
+}
+
+// csrPolicy is the CSRPolicy with the weak keys file loaded.
+type csrPolicy struct {
+	CSRPolicy
+	// weakKeys are the hashes of the weak keys file, lower case
+	weakKeys map[string]bool
+}
+
+// SetCSRPolicy configures the checks of finalize CSRs. It must be called
+// before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetCSRPolicy(policy CSRPolicy) error {
+	if policy.MinRSAKeySize < 0 || policy.MaxRSAKeySize < 0 {
+		return errors.New("RSA key sizes must not be negative")
+	}
+	if policy.MaxRSAKeySize != 0 && policy.MinRSAKeySize > policy.MaxRSAKeySize {
+		return fmt.Errorf("minRSAKeySize %d is above maxRSAKeySize %d",
+			policy.MinRSAKeySize, policy.MaxRSAKeySize)
+	}
+	p := &csrPolicy{CSRPolicy: policy}
+	if policy.WeakKeysFile != "" {
+		weakKeys, err := loadWeakKeys(policy.WeakKeysFile)
+		if err != nil {
+			return err
+		}
+		p.weakKeys = weakKeys
+		wfe.log.Printf("Loaded %d weak keys from %s", len(weakKeys), policy.WeakKeysFile)
+	}
+	wfe.csrPolicy = p
+	return nil
+}
+
+// loadWeakKeys reads a weak keys file in the Debian openssl-blacklist
+// format.
+func loadWeakKeys(filename string) (map[string]bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	weakKeys := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 20 && len(hash) != 40 {
+			return nil, fmt.Errorf("%s:%d: invalid weak key hash %q", filename, line, hash)
+		}
+		// Match the last 20 hex digits, like the Debian lists do
+		weakKeys[hash[len(hash)-20:]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return weakKeys, nil
+}
+
+// checkCSR returns a badCSR problem if the CSR fails one of the checks of the
+// CSR policy.
+func (p *csrPolicy) checkCSR(csr *x509.CertificateRequest) *acme.ProblemDetails {
+	if p.ExactNames {
+		if prob := checkCSRNames(csr); prob != nil {
+			return prob
+		}
+	}
+	if key, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		if prob := p.checkRSAKey(key); prob != nil {
+			return prob
+		}
+	}
+	if p.RejectExtraExtensions {
+		for _, ext := range csr.Extensions {
+			if !containsOID(allowedCSRExtensions, ext.Id) {
+				return acme.BadCSRProblem(fmt.Sprintf("CSR requests unsupported extension %s", ext.Id))
+			}
+		}
+	}
+	return nil
+}
+
+// checkCSRNames returns a badCSR problem if the common name of the CSR isn't
+// one of its names, or if its names aren't lower case or repeat.
+func checkCSRNames(csr *x509.CertificateRequest) *acme.ProblemDetails {
+	names := make(map[string]bool, len(csr.DNSNames)+len(csr.IPAddresses))
+	for _, name := range csr.DNSNames {
+		if name != strings.ToLower(name) {
+			return acme.BadCSRProblem(fmt.Sprintf("CSR name %q is not lower case", name))
+		}
+		if names[name] {
+			return acme.BadCSRProblem(fmt.Sprintf("CSR contains name %q more than once", name))
+		}
+		names[name] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		if names[ip.String()] {
+			return acme.BadCSRProblem(fmt.Sprintf("CSR contains IP %q more than once", ip))
+		}
+		names[ip.String()] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" && !names[cn] {
+		return acme.BadCSRProblem(fmt.Sprintf("CSR common name %q is not one of its names", cn))
+	}
+	return nil
+}
+
+// checkRSAKey returns a badCSR problem if the policy doesn't accept the RSA key
+// of a CSR.
+func (p *csrPolicy) checkRSAKey(key *rsa.PublicKey) *acme.ProblemDetails {
+	size := key.N.BitLen()
+	if p.MinRSAKeySize != 0 && size < p.MinRSAKeySize {
+		return acme.BadCSRProblem(fmt.Sprintf(
+			"CSR RSA key of %d bits is too small, the minimum is %d bits", size, p.MinRSAKeySize))
+	}
+	if p.MaxRSAKeySize != 0 && size > p.MaxRSAKeySize {
+		return acme.BadCSRProblem(fmt.Sprintf(
+			"CSR RSA key of %d bits is too large, the maximum is %d bits", size, p.MaxRSAKeySize))
+	}
+	if p.RejectWeakRSAKeys {
+		if key.E != 65537 {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR RSA key has public exponent %d, only 65537 is accepted", key.E))
+		}
+		if new(big.Int).GCD(nil, nil, key.N, smallPrimesProduct).Cmp(big.NewInt(1)) != 0 {
+			return acme.BadCSRProblem("CSR RSA key modulus has a small prime factor")
+		}
+		if fermatFactorable(key.N) {
+			return acme.BadCSRProblem("CSR RSA key modulus can be factored, its primes are too close")
+		}
+	}
+	if len(p.weakKeys) > 0 {
+		hash := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", key.N))) // nolint:gosec
+		if p.weakKeys[hex.EncodeToString(hash[:])[20:]] {
+			return acme.BadCSRProblem("CSR RSA key is a known weak key")
+		}
+	}
+	return nil
+}
+
+// fermatFactorable returns whether Fermat's factorization method factors n
+// within fermatRounds rounds, starting from the square root of n.
+func fermatFactorable(n *big.Int) bool {
+	a := new(big.Int).Sqrt(n)
+	if new(big.Int).Mul(a, a).Cmp(n) == 0 {
+		return true
+	}
+	b2 := new(big.Int)
+	b := new(big.Int)
+	for i := 0; i < fermatRounds; i++ {
+		a.Add(a, big.NewInt(1))
+		b2.Mul(a, a)
+		b2.Sub(b2, n)
+		b.Sqrt(b2)
+		if new(big.Int).Mul(b, b).Cmp(b2) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range oids {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
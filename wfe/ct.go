@@ -0,0 +1,53 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ctLogInfo describes one of the CA's mock CT logs.
+type ctLogInfo struct {
+	Name  string `json:"description"`
+	LogID []byte `json:"log_id"`
+	Key   []byte `json:"key"`
+	URL   string `json:"url"`
+}
+
+// handleCTLogs lists the CA's mock CT logs at ctLogsPath and serves the RFC
+// 6962 endpoints of each log below ctLogsPath + "<index>/".
+func (wfe *WebFrontEndImpl) handleCTLogs(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	logs := wfe.ca.GetCTLogs()
+
+	if request.URL.Path == "" {
+		infos := make([]ctLogInfo, len(logs))
+		for i, log := range logs {
+			infos[i] = ctLogInfo{
+				Name:  log.Name(),
+				LogID: log.LogID(),
+				Key:   log.PublicKey(),
+				URL:   wfe.relativeEndpoint(request, fmt.Sprintf("%s%d/", ctLogsPath, i)),
+			}
+		}
+		err := wfe.writeJSONResponse(response, http.StatusOK, infos)
+		if err != nil {
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		return
+	}
+
+	parts := strings.SplitN(request.URL.Path, "/", 2)
+	no, err := strconv.Atoi(parts[0])
+	if err != nil || no < 0 || no >= len(logs) || len(parts) != 2 {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+	request.URL.Path = parts[1]
+	logs[no].ServeHTTP(response, request)
+}
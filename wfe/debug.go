@@ -0,0 +1,83 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// runtimeStats are the Go runtime statistics shown by the debug endpoint.
+type runtimeStats struct {
+	GoVersion    string `json:"goVersion"`
+	NumCPU       int    `json:"numCPU"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	Goroutines   int    `json:"goroutines"`
+	Uptime       string `json:"uptime"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+// started is when Pebble started, for the uptime of the runtime statistics.
+var started = time.Now()
+
+// SetDebugEndpoints enables the pprof profiling and runtime statistics
+// endpoints of the management interface. It must be called before
+// ManagementHandler.
+func (wfe *WebFrontEndImpl) SetDebugEndpoints(enabled bool) {
+	wfe.debugEndpoints = enabled
+	if enabled {
+		wfe.log.Printf("Enabling debug endpoints on the management interface")
+	}
+}
+
+// handleDebug registers the debug endpoints on the management interface mux,
+// if they are enabled.
+func (wfe *WebFrontEndImpl) handleDebug(m *http.ServeMux) {
+	if !wfe.debugEndpoints {
+		return
+	}
+	// The pprof handlers expect the full path, so they are not registered
+	// with HandleManagementFunc
+	m.HandleFunc(debugPprofPath, pprof.Index)
+	m.HandleFunc(debugPprofPath+"cmdline", pprof.Cmdline)
+	m.HandleFunc(debugPprofPath+"profile", pprof.Profile)
+	m.HandleFunc(debugPprofPath+"symbol", pprof.Symbol)
+	m.HandleFunc(debugPprofPath+"trace", pprof.Trace)
+	wfe.HandleManagementFunc(m, debugRuntimePath, wfe.handleRuntimeStats)
+}
+
+// handleRuntimeStats shows statistics of the Go runtime.
+func (wfe *WebFrontEndImpl) handleRuntimeStats(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := runtimeStats{
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		Uptime:       time.Since(started).Round(time.Second).String(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		TotalAlloc:   mem.TotalAlloc,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		PauseTotalNs: mem.PauseTotalNs,
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, stats)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
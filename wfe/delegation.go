@@ -0,0 +1,313 @@
+package wfe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// This file implements the STAR delegation extension described in RFC 9115.
+// An identifier owner (IdO) account is given a set of delegation objects by
+// the CA. The IdO can then place orders that reference a delegation, and the
+// certificates for those orders are constrained by the delegation's CSR
+// template. The third party the certificates are delegated to (the NDC)
+// fetches them with unauthenticated GET requests.
+
+const (
+	// Template values that match any value, or any non-empty value.
+	templateOptional  = "*"
+	templateMandatory = "**"
+)
+
+// SetDelegations configures the delegation objects that are created for every
+// new account. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetDelegations(delegations []acme.Delegation) {
+	wfe.delegations = delegations
+}
+
+// addDelegations creates the configured delegation objects for a new account.
+func (wfe *WebFrontEndImpl) addDelegations(acct *core.Account) error {
+	for _, d := range wfe.delegations {
+		_, err := wfe.db.AddDelegation(&core.Delegation{
+			Delegation: d,
+			ID:         newToken(),
+			AccountID:  acct.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListDelegations returns the delegation object URLs of an account. See RFC
+// 9115 Section 2.3.1.
+func (wfe *WebFrontEndImpl) ListDelegations(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	postData, prob := wfe.verifyPOST(request, wfe.lookupJWK)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	acct, prob := wfe.validPOSTAsGET(postData)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	accountID := strings.TrimPrefix(request.URL.Path, delegationsPath)
+	if accountID != acct.ID {
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Account that authenticated the request does not own the specified delegations"), response)
+		return
+	}
+
+	delegationURLs := []string{}
+	for _, d := range wfe.db.GetDelegationsByAccountID(acct.ID) {
+		delegationURLs = append(delegationURLs, wfe.relativeEndpoint(request, delegationPath+d.ID))
+	}
+
+	resp := struct {
+		Delegations []string `json:"delegations"`
+	}{
+		Delegations: delegationURLs,
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, resp)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshaling delegations"), response)
+		return
+	}
+}
+
+// Delegation returns a delegation object to the account that owns it.
+func (wfe *WebFrontEndImpl) Delegation(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	postData, prob := wfe.verifyPOST(request, wfe.lookupJWK)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	acct, prob := wfe.validPOSTAsGET(postData)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	delegationID := strings.TrimPrefix(request.URL.Path, delegationPath)
+	delegation := wfe.db.GetDelegationByID(delegationID)
+	if delegation == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if delegation.AccountID != acct.ID {
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Account that authenticated the request does not own the specified delegation"), response)
+		return
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, delegation.Delegation)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshaling delegation"), response)
+		return
+	}
+}
+
+// verifyDelegation looks up the delegation referenced by a new order and
+// checks that the order's identifiers are allowed by the delegation's CSR
+// template. The delegation is set on the order if everything checks out. The
+// caller is expected to hold the order lock for writing.
+func (wfe *WebFrontEndImpl) verifyDelegation(
+	order *core.Order,
+	delegationURL string) *acme.ProblemDetails {
+
+	idx := strings.LastIndex(delegationURL, delegationPath)
+	if idx == -1 {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order delegation %q is not a delegation URL", delegationURL))
+	}
+	delegation := wfe.db.GetDelegationByID(delegationURL[idx+len(delegationPath):])
+	if delegation == nil || delegation.AccountID != order.AccountID {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order delegation %q does not exist", delegationURL))
+	}
+
+	for _, ident := range order.Identifiers {
+		if ident.Type != acme.IdentifierDNS {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Delegated orders may only include %s identifiers", acme.IdentifierDNS))
+		}
+		if !templateAllowsName(delegation.CSRTemplate, ident.Value) {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Identifier %q is not allowed by the delegation CSR template", ident.Value))
+		}
+	}
+
+	order.DelegationObject = delegation
+	// Non-recurrent delegated certificates are fetched by the third party in the
+	// same way as the certificates of recurrent orders. See RFC 9115 Section
+	// 2.3.2.
+	if !order.IsRecurrent() {
+		order.AllowCertificateGet = true
+	}
+	return nil
+}
+
+// templateAllowsName returns true if a DNS name matches one of the
+// subjectAltName entries of a CSR template. Entries may be a literal name, the
+// mandatory wildcard "**" matching any name, or a name with a leftmost "*"
+// label matching exactly one label.
+func templateAllowsName(tmpl acme.CSRTemplate, name string) bool {
+	name = strings.ToLower(name)
+	for _, allowed := range tmpl.Extensions.SubjectAltName.DNS {
+		allowed = strings.ToLower(allowed)
+		switch {
+		case allowed == templateMandatory || allowed == templateOptional:
+			return true
+		case allowed == name:
+			return true
+		case strings.HasPrefix(allowed, "*.") && !strings.HasPrefix(name, "*."):
+			labels := strings.SplitN(name, ".", 2)
+			if len(labels) == 2 && labels[1] == allowed[2:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyCSRTemplate checks that a CSR finalizing a delegated order complies
+// with the delegation's CSR template. See RFC 9115 Section 4.
+func verifyCSRTemplate(tmpl acme.CSRTemplate, csr *x509.CertificateRequest) *acme.ProblemDetails {
+	if len(tmpl.KeyTypes) > 0 {
+		var keyAllowed bool
+		for _, kt := range tmpl.KeyTypes {
+			if templateAllowsKey(kt, csr) {
+				keyAllowed = true
+				break
+			}
+		}
+		if !keyAllowed {
+			return acme.BadCSRProblem("CSR key type is not allowed by the delegation CSR template")
+		}
+	}
+
+	if prob := verifyTemplateSubject(tmpl.Subject, csr.Subject); prob != nil {
+		return prob
+	}
+
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return acme.BadCSRProblem("CSR contains subjectAltNames not allowed by the delegation CSR template")
+	}
+	for _, name := range csr.DNSNames {
+		if !templateAllowsName(tmpl, name) {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR name %q is not allowed by the delegation CSR template", name))
+		}
+	}
+	return nil
+}
+
+// templateAllowsKey returns true if the CSR's public key and signature
+// algorithm match the given CSR template key type.
+func templateAllowsKey(kt acme.CSRTemplateKeyType, csr *x509.CertificateRequest) bool {
+	switch kt.PublicKeyType {
+	case "rsaEncryption":
+		key, ok := csr.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		if kt.PublicKeyLength != 0 && key.N.BitLen() != kt.PublicKeyLength {
+			return false
+		}
+	case "id-ecPublicKey":
+		key, ok := csr.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		curves := map[string]string{
+			"secp256r1": "P-256",
+			"secp384r1": "P-384",
+			"secp521r1": "P-521",
+		}
+		if kt.NamedCurve != "" && curves[kt.NamedCurve] != key.Curve.Params().Name {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if kt.SignatureType == "" {
+		return true
+	}
+	signatureTypes := map[string]x509.SignatureAlgorithm{
+		"sha256WithRSAEncryption": x509.SHA256WithRSA,
+		"sha384WithRSAEncryption": x509.SHA384WithRSA,
+		"sha512WithRSAEncryption": x509.SHA512WithRSA,
+		"ecdsa-with-SHA256":       x509.ECDSAWithSHA256,
+		"ecdsa-with-SHA384":       x509.ECDSAWithSHA384,
+		"ecdsa-with-SHA512":       x509.ECDSAWithSHA512,
+	}
+	return signatureTypes[kt.SignatureType] == csr.SignatureAlgorithm
+}
+
+// verifyTemplateSubject checks a CSR subject against the subject of a CSR
+// template. Subject attributes not present in the template must not be present
+// in the CSR.
+func verifyTemplateSubject(tmpl map[string]string, subject pkix.Name) *acme.ProblemDetails {
+	first := func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	attributes := map[string]string{
+		"commonName":          subject.CommonName,
+		"organization":        first(subject.Organization),
+		"organizationalUnit":  first(subject.OrganizationalUnit),
+		"country":             first(subject.Country),
+		"stateOrProvinceName": first(subject.Province),
+		"localityName":        first(subject.Locality),
+		"serialNumber":        subject.SerialNumber,
+		"streetAddress":       first(subject.StreetAddress),
+		"postalCode":          first(subject.PostalCode),
+	}
+
+	for attr := range tmpl {
+		if _, known := attributes[attr]; !known {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"Delegation CSR template contains unsupported subject attribute %q", attr))
+		}
+	}
+
+	for attr, value := range attributes {
+		expected, present := tmpl[attr]
+		switch {
+		case !present && value != "":
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR subject attribute %q is not allowed by the delegation CSR template", attr))
+		case !present, expected == templateOptional:
+			continue
+		case expected == templateMandatory && value == "":
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR subject attribute %q is required by the delegation CSR template", attr))
+		case expected != templateMandatory && !strings.EqualFold(expected, value):
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR subject attribute %q must be %q", attr, expected))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// DirectoryMeta configures the fields of the meta object of the directory.
+type DirectoryMeta struct {
+	// URL of the terms of service. Defaults to a data URL.
+	TermsOfService string
+	// URL of a website describing the CA, not advertised if empty
+	Website string
+	// Domain names recognized as referring to the CA in CAA records, not
+	// advertised if empty
+	CAAIdentities []string
+	// Descriptions of the profiles advertised by profile name, instead of the
+	// descriptions of the certificate profiles the CA offers
+	Profiles map[string]string
+}
+
+// eabRequirement is whether newAccount requests must include an External
+// Account Binding. It can be changed with the management interface while the
+// WFE serves requests.
+type eabRequirement struct {
+	sync.RWMutex
+	required bool
+}
+
+// SetExternalAccountBindingRequired configures whether newAccount requests
+// must include an External Account Binding. It must be called before the WFE
+// starts serving requests.
+func (wfe *WebFrontEndImpl) SetExternalAccountBindingRequired(required bool) {
+	wfe.requireEAB = &eabRequirement{required: required}
+}
+
+// externalAccountRequired returns whether newAccount requests must include an
+// External Account Binding.
+func (wfe *WebFrontEndImpl) externalAccountRequired() bool {
+	wfe.requireEAB.RLock()
+	defer wfe.requireEAB.RUnlock()
+	return wfe.requireEAB.required
+}
+
+// SetDirectoryMeta configures the fields of the meta object of the directory.
+// It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetDirectoryMeta(meta DirectoryMeta) error {
+	for field, value := range map[string]string{
+		"terms of service": meta.TermsOfService,
+		"website":          meta.Website,
+	} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" {
+			return fmt.Errorf("%s URL %q must be an absolute URL", field, value)
+		}
+	}
+	for _, identity := range meta.CAAIdentities {
+		if identity == "" {
+			return fmt.Errorf("CAA identities must not be empty")
+		}
+	}
+	if meta.TermsOfService == "" {
+		meta.TermsOfService = ToSURL
+	}
+	wfe.directoryMeta = meta
+	wfe.tos = &termsOfService{url: meta.TermsOfService}
+	return nil
+}
+
+// directoryMetaObject returns the meta object of the directory.
+func (wfe *WebFrontEndImpl) directoryMetaObject() map[string]interface{} {
+	meta := map[string]interface{}{
+		"termsOfService":          wfe.termsOfServiceURL(),
+		"externalAccountRequired": wfe.externalAccountRequired(),
+		"auto-renewal":            wfe.autoRenewalMeta(),
+		"subdomainAuthAllowed":    true,
+		"profiles":                wfe.ca.GetProfileDescriptions(),
+	}
+	if wfe.directoryMeta.Website != "" {
+		meta["website"] = wfe.directoryMeta.Website
+	}
+	if len(wfe.directoryMeta.CAAIdentities) > 0 {
+		meta["caaIdentities"] = wfe.directoryMeta.CAAIdentities
+	}
+	if wfe.directoryMeta.Profiles != nil {
+		meta["profiles"] = wfe.directoryMeta.Profiles
+	}
+	return meta
+}
+
+// handleExternalAccountRequired returns whether newAccount requests must
+// include an External Account Binding on GET, and changes it with the
+// "required" field of the body of a POST request, e.g. {"required": true}, so
+// that clients detecting the requirement in the directory can be tested.
+func (wfe *WebFrontEndImpl) handleExternalAccountRequired(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req struct {
+			Required bool
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+		wfe.requireEAB.Lock()
+		wfe.requireEAB.required = req.Required
+		wfe.requireEAB.Unlock()
+		wfe.log.Printf("External account binding required: %t", req.Required)
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, map[string]bool{
+		"externalAccountRequired": wfe.externalAccountRequired(),
+	})
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,296 @@
+package wfe
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// SetExternalAccountKeyPolicies sets the policies of External Account Binding
+// keys by key ID. The keys must exist.
+func (wfe *WebFrontEndImpl) SetExternalAccountKeyPolicies(policies map[string]core.ExternalAccountKeyPolicy) error {
+	for keyID, policy := range policies {
+		if err := verifyEABKeyPolicy(policy); err != nil {
+			return fmt.Errorf("key ID %q: %s", keyID, err)
+		}
+		policy := policy
+		_, err := wfe.db.UpdateExternalAccountKey(keyID, func(key *core.ExternalAccountKey) {
+			key.Policy = policy
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyEABKeyPolicy(policy core.ExternalAccountKeyPolicy) error {
+	if policy.MaxAccounts < 0 || policy.MaxCertificates < 0 {
+		return errors.New("maximum numbers of accounts and certificates must not be negative")
+	}
+	for _, suffix := range policy.IdentifierSuffixes {
+		if suffix == "" {
+			return errors.New("identifier suffixes must not be empty")
+		}
+	}
+	return nil
+}
+
+// checkEABKeyPolicy returns an unauthorized problem if the policy of an
+// External Account Binding key doesn't allow it to bind another account.
+func (wfe *WebFrontEndImpl) checkEABKeyPolicy(key *core.ExternalAccountKey) *acme.ProblemDetails {
+	policy := key.Policy
+	if policy.Expires != nil && !wfe.clk.Now().Before(*policy.Expires) {
+		return acme.UnauthorizedProblem(fmt.Sprintf(
+			"the field 'kid' references a key that expired at %s", policy.Expires.UTC().Format(time.RFC3339)))
+	}
+	if policy.MaxAccounts > 0 {
+		if accounts, _ := wfe.db.GetExternalAccountKeyUsage(key.ID); accounts >= policy.MaxAccounts {
+			return acme.UnauthorizedProblem(fmt.Sprintf(
+				"the field 'kid' references a key that already bound the maximum of %d accounts", policy.MaxAccounts))
+		}
+	}
+	return nil
+}
+
+// checkEABOrderPolicy returns a problem if the policy of the External Account
+// Binding key of the account of a new order doesn't allow the order: an
+// unauthorized problem if the key expired or the certificate quota of the key
+// is used up, or a rejectedIdentifier problem for the identifiers outside of
+// the suffixes of the key.
+func (wfe *WebFrontEndImpl) checkEABOrderPolicy(acct *core.Account, order *core.Order) *acme.ProblemDetails {
+	if acct.ExternalAccountKeyID == "" {
+		return nil
+	}
+	key := wfe.db.GetExternalAccountKeyByID(acct.ExternalAccountKeyID)
+	if key == nil {
+		return nil
+	}
+	policy := key.Policy
+	if policy.Expires != nil && !wfe.clk.Now().Before(*policy.Expires) {
+		return acme.UnauthorizedProblem(fmt.Sprintf(
+			"The External Account Binding key %q of the account expired at %s",
+			key.ID, policy.Expires.UTC().Format(time.RFC3339)))
+	}
+	if policy.MaxCertificates > 0 {
+		if _, certificates := wfe.db.GetExternalAccountKeyUsage(key.ID); certificates >= policy.MaxCertificates {
+			return acme.UnauthorizedProblem(fmt.Sprintf(
+				"The accounts bound to External Account Binding key %q were already issued the maximum of %d certificates",
+				key.ID, policy.MaxCertificates))
+		}
+	}
+	if len(policy.IdentifierSuffixes) == 0 {
+		return nil
+	}
+	var probs []acme.SubProblemDetails
+	for _, ident := range order.Snapshot().Identifiers {
+		if !matchesIdentifierSuffixes(ident, policy.IdentifierSuffixes) {
+			probs = append(probs, acme.SubProblem(ident, acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"External Account Binding key %q doesn't allow identifier %q", key.ID, ident.Value))))
+		}
+	}
+	return acme.IdentifiersProblem(acme.RejectedIdentifierProblem,
+		"Order included identifiers not allowed by the External Account Binding key", probs)
+}
+
+// matchesIdentifierSuffixes returns whether an identifier is one of the domain
+// names or IP addresses of the suffixes, or a subdomain of one of the names.
+func matchesIdentifierSuffixes(ident acme.Identifier, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+		if ident.Type == acme.IdentifierIP {
+			if ip := net.ParseIP(suffix); ip != nil && ip.Equal(net.ParseIP(ident.Value)) {
+				return true
+			}
+			continue
+		}
+		if ident.Value == suffix || strings.HasSuffix(ident.Value, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// eabKeyForDisplay is an External Account Binding key in the format of the
+// externalAccountMACKeys config, with its policy and the number of accounts
+// bound to it and certificates issued to them.
+type eabKeyForDisplay struct {
+	KeyID        string                         `json:"keyID"`
+	HMACKey      string                         `json:"hmacKey"`
+	Disabled     bool                           `json:"disabled,omitempty"`
+	Policy       *core.ExternalAccountKeyPolicy `json:"policy,omitempty"`
+	Accounts     int                            `json:"accounts"`
+	Certificates int                            `json:"certificates"`
+}
+
+func (wfe *WebFrontEndImpl) eabKeyDisplay(key *core.ExternalAccountKey) eabKeyForDisplay {
+	display := eabKeyForDisplay{
+		KeyID:    key.ID,
+		HMACKey:  base64.RawURLEncoding.EncodeToString(key.Key),
+		Disabled: key.Disabled,
+	}
+	if policy := key.Policy; len(policy.IdentifierSuffixes) > 0 || policy.MaxAccounts > 0 ||
+		policy.MaxCertificates > 0 || policy.Expires != nil {
+		display.Policy = &policy
+	}
+	display.Accounts, display.Certificates = wfe.db.GetExternalAccountKeyUsage(key.ID)
+	return display
+}
+
+// eabKeyRequest is the body of a POST request adding or rotating an External
+// Account Binding key.
+type eabKeyRequest struct {
+	KeyID   string
+	HMACKey string
+	Policy  *core.ExternalAccountKeyPolicy
+}
+
+// readEABKeyRequest reads the body of a POST request changing an External
+// Account Binding key. A random HMAC key is generated if the request has
+// none. The policy of the request, if any, replaces the policy of the key.
+func (wfe *WebFrontEndImpl) readEABKeyRequest(
+	response http.ResponseWriter,
+	request *http.Request,
+	req *eabKeyRequest) bool {
+	if !wfe.readManagementPOST(response, request, req) {
+		return false
+	}
+	if req.KeyID == "" {
+		wfe.sendError(acme.MalformedProblem("No key ID"), response)
+		return false
+	}
+	if req.HMACKey == "" {
+		req.HMACKey = randomString(32)
+	} else if _, err := base64.RawURLEncoding.DecodeString(req.HMACKey); err != nil {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"HMAC key %q is not base64 URL encoded: %s", req.HMACKey, err)), response)
+		return false
+	}
+	if req.Policy != nil {
+		if err := verifyEABKeyPolicy(*req.Policy); err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return false
+		}
+	}
+	return true
+}
+
+// handleEABKeys lists the External Account Binding keys on GET, and adds a key
+// on POST, e.g. {"keyID": "kid-3", "hmacKey": "...", "policy": {"maxAccounts":
+// 1}}. Without "hmacKey" a random HMAC key is generated, which is returned
+// with the key ID.
+func (wfe *WebFrontEndImpl) handleEABKeys(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req eabKeyRequest
+		if !wfe.readEABKeyRequest(response, request, &req) {
+			return
+		}
+		if err := wfe.db.AddExternalAccountKeyByID(req.KeyID, req.HMACKey); err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		key, _ := wfe.db.UpdateExternalAccountKey(req.KeyID, func(key *core.ExternalAccountKey) {
+			if req.Policy != nil {
+				key.Policy = *req.Policy
+			}
+		})
+		wfe.log.Printf("Added external account binding key %q", req.KeyID)
+
+		err := wfe.writeJSONResponse(response, http.StatusCreated, wfe.eabKeyDisplay(key))
+		if err != nil {
+			response.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	keys := wfe.db.GetExternalAccountKeys()
+	result := make([]eabKeyForDisplay, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, wfe.eabKeyDisplay(key))
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, result)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleDisableEABKey disables the External Account Binding key with the key
+// ID in the body of a POST request, e.g. {"keyID": "kid-3"}, so that it can't
+// bind new accounts. Accounts already bound to the key are kept. With
+// "enable": true the POST enables the key again.
+func (wfe *WebFrontEndImpl) handleDisableEABKey(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		KeyID  string
+		Enable bool
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	key, err := wfe.db.UpdateExternalAccountKey(req.KeyID, func(key *core.ExternalAccountKey) {
+		key.Disabled = !req.Enable
+	})
+	if err != nil {
+		wfe.sendError(acme.NotFoundProblem(err.Error()), response)
+		return
+	}
+	if key.Disabled {
+		wfe.log.Printf("Disabled external account binding key %q", req.KeyID)
+	} else {
+		wfe.log.Printf("Enabled external account binding key %q", req.KeyID)
+	}
+
+	err = wfe.writeJSONResponse(response, http.StatusOK, wfe.eabKeyDisplay(key))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRotateEABKey replaces the HMAC key of the External Account Binding key
+// with the key ID in the body of a POST request, e.g. {"keyID": "kid-3"}, with
+// the given "hmacKey" or a random one, and its policy with the given "policy"
+// if any. Bindings must then be signed with the new HMAC key.
+func (wfe *WebFrontEndImpl) handleRotateEABKey(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req eabKeyRequest
+	if !wfe.readEABKeyRequest(response, request, &req) {
+		return
+	}
+	hmacKey, _ := base64.RawURLEncoding.DecodeString(req.HMACKey)
+
+	key, err := wfe.db.UpdateExternalAccountKey(req.KeyID, func(key *core.ExternalAccountKey) {
+		key.Key = hmacKey
+		if req.Policy != nil {
+			key.Policy = *req.Policy
+		}
+	})
+	if err != nil {
+		wfe.sendError(acme.NotFoundProblem(err.Error()), response)
+		return
+	}
+	wfe.log.Printf("Rotated external account binding key %q", req.KeyID)
+
+	err = wfe.writeJSONResponse(response, http.StatusOK, wfe.eabKeyDisplay(key))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,178 @@
+package wfe
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/random"
+)
+
+// Types of faults that can be injected into responses
+const (
+	// faultServerError responds with a serverInternal problem
+	faultServerError = "serverError"
+	// faultBadNonce responds with a badNonce problem, like the nonces'
+	// rejectPercent
+	faultBadNonce = "badNonce"
+	// faultMalformedJSON responds with a 200 and a JSON body that doesn't parse
+	faultMalformedJSON = "malformedJSON"
+	// faultTruncatedBody processes the request but sends only half of the
+	// response body before closing the connection
+	faultTruncatedBody = "truncatedBody"
+	// faultWrongContentType processes the request but sends the response with
+	// a text/html content type
+	faultWrongContentType = "wrongContentType"
+	// faultDropConnection closes the connection without responding
+	faultDropConnection = "dropConnection"
+)
+
+var faultTypes = map[string]bool{
+	faultServerError:      true,
+	faultBadNonce:         true,
+	faultMalformedJSON:    true,
+	faultTruncatedBody:    true,
+	faultWrongContentType: true,
+	faultDropConnection:   true,
+}
+
+// faultEndpoints maps the endpoint names used to configure faults to the
+// patterns of the WFE's handlers.
+var faultEndpoints = map[string]string{
+	"directory":       DirectoryPath,
+	"newNonce":        noncePath,
+	"newAccount":      newAccountPath,
+	"account":         acctPath,
+	"newOrder":        newOrderPath,
+	"order":           orderPath,
+	"finalize":        orderFinalizePath,
+	"authz":           authzPath,
+	"challenge":       challengePath,
+	"certificate":     certPath,
+	"starCertificate": starCertPath,
+	"revokeCert":      revokeCertPath,
+	"keyChange":       keyRolloverPath,
+	"orders":          ordersPath,
+	"delegations":     delegationsPath,
+	"delegation":      delegationPath,
+	"renewalInfo":     renewalInfoPath,
+}
+
+// Fault injects a fault of the given type into Percent percent of the
+// responses of the given endpoints. An endpoint of "*" matches all
+// endpoints.
+type Fault struct {
+	Endpoints []string
+	Percent   int
+	Type      string
+}
+
+// SetFaults configures the faults injected into responses. It must be called
+// before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetFaults(faults []Fault) error {
+	for _, fault := range faults {
+		if !faultTypes[fault.Type] {
+			return fmt.Errorf("unknown fault type %q", fault.Type)
+		}
+		if fault.Percent < 0 || fault.Percent > 100 {
+			return fmt.Errorf("fault %q percentage must be between 0 and 100", fault.Type)
+		}
+		for _, endpoint := range fault.Endpoints {
+			if _, ok := faultEndpoints[endpoint]; !ok && endpoint != "*" {
+				return fmt.Errorf("fault %q has unknown endpoint %q", fault.Type, endpoint)
+			}
+		}
+		wfe.log.Printf("Configured to inject %s faults into %d%% of %v responses",
+			fault.Type, fault.Percent, fault.Endpoints)
+	}
+	wfe.faults = faults
+	return nil
+}
+
+// pickFault returns the type of the fault to inject into a response of the
+// endpoint with the given pattern, or "" to respond normally.
+func (wfe *WebFrontEndImpl) pickFault(pattern string) string {
+	for _, fault := range wfe.faults {
+		for _, endpoint := range fault.Endpoints {
+			if endpoint != "*" && faultEndpoints[endpoint] != pattern {
+				continue
+			}
+			if random.Intn(100) < fault.Percent {
+				return fault.Type
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// bufferedResponse is a http.ResponseWriter that keeps the response so that it
+// can be altered before it is sent.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(data)
+}
+
+// injectFault responds to a request with a fault. The handler is called for
+// faults that alter a regular response.
+func (wfe *WebFrontEndImpl) injectFault(
+	fault string,
+	response http.ResponseWriter,
+	request *http.Request,
+	handler func(http.ResponseWriter)) {
+	wfe.log.Printf("Injecting %s fault into response to %s %s", fault, request.Method, request.URL.Path)
+
+	switch fault {
+	case faultServerError:
+		wfe.sendError(acme.InternalErrorProblem("Injected fault"), response)
+		return
+	case faultBadNonce:
+		wfe.sendError(acme.BadNonceProblem("Injected fault"), response)
+		return
+	case faultMalformedJSON:
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		response.WriteHeader(http.StatusOK)
+		_, _ = response.Write([]byte(`{"status": "valid", "injected`))
+		return
+	case faultDropConnection:
+		// Aborts the response without writing anything
+		panic(http.ErrAbortHandler)
+	}
+
+	buffered := &bufferedResponse{header: response.Header()}
+	handler(buffered)
+	if buffered.status == 0 {
+		buffered.status = http.StatusOK
+	}
+	body := buffered.body.Bytes()
+
+	switch fault {
+	case faultWrongContentType:
+		response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case faultTruncatedBody:
+		// Announce the full length, the server closes the connection after the
+		// short body
+		response.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		body = body[:len(body)/2]
+	}
+	response.WriteHeader(buffered.status)
+	_, _ = response.Write(body)
+}
@@ -0,0 +1,135 @@
+package wfe
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// defaultFinalizeQueueDepth is the number of finalized orders waiting for a
+// finalize worker if the finalize config doesn't say
+const defaultFinalizeQueueDepth = 1000
+
+// FinalizeConfig configures the workers completing finalized orders, which
+// process the CSRs and sign the certificates of the orders while they are
+// processing. The zero value completes every order as soon as it's
+// finalized.
+type FinalizeConfig struct {
+	// Orders completed at the same time. Zero means no limit.
+	Workers int
+	// Finalized orders waiting for a worker if all are busy. Finalize
+	// requests are rejected with a rateLimited problem while the queue is
+	// full. Zero means 1000.
+	QueueDepth int
+	// Milliseconds a worker spends on each order before asking the CA to
+	// complete it, in addition to the issuance delay of the CA
+	ProcessingTime int
+}
+
+// finalizeJob is a finalized order waiting for a finalize worker.
+type finalizeJob struct {
+	ctx   context.Context
+	order *core.Order
+}
+
+// finalizer is the queue and the workers completing finalized orders. It's
+// shared by the copies of the WFE.
+type finalizer struct {
+	sync.Mutex
+	config FinalizeConfig
+	// The finalized orders waiting for a worker, nil if the number of
+	// workers is unlimited
+	queue chan finalizeJob
+}
+
+// SetFinalize configures the workers completing finalized orders. It may be
+// called while the WFE serves requests, and configures the copies of the WFE
+// too. Orders already queued are completed by the previous workers.
+func (wfe *WebFrontEndImpl) SetFinalize(config FinalizeConfig) error {
+	if config.Workers < 0 || config.QueueDepth < 0 || config.ProcessingTime < 0 {
+		return errors.New("finalize workers, queue depth and processing time must not be negative")
+	}
+	if config.QueueDepth == 0 {
+		config.QueueDepth = defaultFinalizeQueueDepth
+	}
+
+	f := wfe.finalizer
+	f.Lock()
+	defer f.Unlock()
+	if config == f.config {
+		return nil
+	}
+	// The previous workers exit once their queue is drained
+	if f.queue != nil {
+		close(f.queue)
+		f.queue = nil
+	}
+	f.config = config
+	if config.Workers > 0 {
+		f.queue = make(chan finalizeJob, config.QueueDepth)
+		for i := 0; i < config.Workers; i++ {
+			go wfe.finalizeWorker(f.queue, config.ProcessingTime)
+		}
+		wfe.log.Printf("Completing finalized orders with %d workers and a queue of %d orders",
+			config.Workers, config.QueueDepth)
+	}
+	return nil
+}
+
+// finalizeWorker completes the orders of a finalize queue until the queue is
+// closed.
+func (wfe *WebFrontEndImpl) finalizeWorker(queue <-chan finalizeJob, processingTime int) {
+	for job := range queue {
+		wfe.processFinalizedOrder(job.ctx, job.order, processingTime)
+	}
+}
+
+// processFinalizedOrder spends the processing time on a finalized order and
+// then completes it.
+func (wfe *WebFrontEndImpl) processFinalizedOrder(ctx context.Context, order *core.Order, processingTime int) {
+	time.Sleep(time.Duration(processingTime) * time.Millisecond)
+	wfe.completeOrder(ctx, order)
+}
+
+// beginProcessing sets a finalized order processing with its parsed CSR and
+// hands it to the finalize workers, or completes it in a goroutine of its own
+// if the number of workers is unlimited. If the queue of the workers is full
+// a rateLimited problem is sent instead and false returned, leaving the order
+// as it is.
+func (wfe *WebFrontEndImpl) beginProcessing(
+	ctx context.Context,
+	response http.ResponseWriter,
+	order *core.Order,
+	parsedCSR *x509.CertificateRequest) bool {
+	// Finalized orders are queued holding the lock, so that the queue can't
+	// fill up between checking it and queueing the order
+	f := wfe.finalizer
+	f.Lock()
+	defer f.Unlock()
+	if f.queue != nil && len(f.queue) == cap(f.queue) {
+		retryAfter := time.Duration(f.config.ProcessingTime) * time.Millisecond
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		wfe.sendRateLimited(response, retryAfter, "Too many orders are being finalized")
+		return false
+	}
+
+	order.Update(func(order *core.Order) {
+		order.ParsedCSR = parsedCSR
+		order.BeganProcessing = true
+		order.Status = acme.StatusProcessing
+	})
+	if f.queue == nil {
+		go wfe.processFinalizedOrder(ctx, order, f.config.ProcessingTime)
+	} else {
+		f.queue <- finalizeJob{ctx: ctx, order: order}
+	}
+	return true
+}
@@ -0,0 +1,314 @@
+package wfe
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// fixtureAuthzExpire is how long the authorizations of fixtures are valid,
+// like the 30 days of Let's Encrypt, so that long test runs can reuse them.
+const fixtureAuthzExpire = 30 * 24 * time.Hour
+
+// Fixtures are accounts, External Account Binding keys, authorizations and
+// certificates loaded into the database before the WFE serves requests, so
+// that integration tests can start from a known state.
+type Fixtures struct {
+	// Base64 URL encoded External Account Binding HMAC keys by key ID
+	ExternalAccountKeys map[string]string
+	Accounts            []AccountFixture
+}
+
+// AccountFixture is an account of the fixtures.
+type AccountFixture struct {
+	// PEM encoded private or public key of the account
+	Key     string
+	Contact []string
+	// ID of the External Account Binding key the account is bound to, if any
+	ExternalAccountKeyID string
+	// Identifiers the account has valid authorizations for
+	Authorizations []acme.Identifier
+	Certificates   []CertificateFixture
+}
+
+// CertificateFixture is a certificate issued to an account of the fixtures by
+// a valid order.
+type CertificateFixture struct {
+	Identifiers []acme.Identifier
+	// Profile the certificate is issued with, the default profile if empty
+	Profile string
+	// PEM encoded private or public key of the certificate. A new key is
+	// generated if empty.
+	Key string
+}
+
+// LoadFixtures adds the accounts, External Account Binding keys, valid
+// authorizations and issued certificates of the fixtures to the database. The
+// URLs of the resources use the host of the ACME interface, e.g.
+// "localhost:14000", with localhost instead of unspecified addresses like
+// 0.0.0.0, unless an external URL is configured. It must be called before the
+// WFE starts serving requests.
+func (wfe *WebFrontEndImpl) LoadFixtures(fixtures Fixtures, host string) error {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if ip := net.ParseIP(h); ip != nil && ip.IsUnspecified() {
+			host = net.JoinHostPort("localhost", port)
+		}
+	}
+	request, err := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return err
+	}
+	request.TLS = &tls.ConnectionState{}
+
+	for keyID, key := range fixtures.ExternalAccountKeys {
+		if err := wfe.db.AddExternalAccountKeyByID(keyID, key); err != nil {
+			return fmt.Errorf("external account key %q: %s", keyID, err)
+		}
+	}
+	for i, fixture := range fixtures.Accounts {
+		if err := wfe.loadAccountFixture(fixture, request); err != nil {
+			return fmt.Errorf("account %d: %s", i, err)
+		}
+	}
+	wfe.log.Printf("Loaded %d accounts and %d external account keys from the fixtures",
+		len(fixtures.Accounts), len(fixtures.ExternalAccountKeys))
+	return nil
+}
+
+// loadAccountFixture adds an account of the fixtures with its authorizations
+// and certificates to the database.
+func (wfe *WebFrontEndImpl) loadAccountFixture(fixture AccountFixture, request *http.Request) error {
+	key, err := parseFixtureKey(fixture.Key)
+	if err != nil {
+		return err
+	}
+	if fixture.ExternalAccountKeyID != "" && wfe.db.GetExternalAccountKeyByID(fixture.ExternalAccountKeyID) == nil {
+		return fmt.Errorf("unknown external account key %q", fixture.ExternalAccountKeyID)
+	}
+
+	acct := &core.Account{
+		Account: acme.Account{
+			Contact: fixture.Contact,
+			Status:  acme.StatusValid,
+		},
+		Key:                  &jose.JSONWebKey{Key: key},
+		ExternalAccountKeyID: fixture.ExternalAccountKeyID,
+		AgreedTermsOfService: wfe.termsOfServiceURL(),
+	}
+	if prob := wfe.verifyContacts(acct.Account); prob != nil {
+		return errors.New(prob.Detail)
+	}
+	if _, err := wfe.db.AddAccount(acct); err != nil {
+		return err
+	}
+	acct.Orders = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", ordersPath, acct.ID))
+	wfe.log.Printf("Loaded fixture account %s",
+		wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, acct.ID)))
+
+	// The authorizations are valid for an order that was never finalized
+	authzs := make(map[acme.Identifier]*core.Authorization)
+	if len(fixture.Authorizations) > 0 {
+		if _, err := wfe.addFixtureOrder(acct, fixture.Authorizations, "", authzs, request); err != nil {
+			return err
+		}
+	}
+
+	for _, cert := range fixture.Certificates {
+		certKey, err := parseFixtureKey(cert.Key)
+		if err != nil {
+			return err
+		}
+		order, err := wfe.addFixtureOrder(acct, cert.Identifiers, cert.Profile, authzs, request)
+		if err != nil {
+			return err
+		}
+		if err := wfe.issueFixtureCertificate(order, certKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFixtureOrder adds an order for identifiers to the database whose
+// authorizations are valid. The authorizations of identifiers already in
+// authzs are shared, the new ones are added to it.
+func (wfe *WebFrontEndImpl) addFixtureOrder(
+	acct *core.Account,
+	idents []acme.Identifier,
+	profile string,
+	authzs map[acme.Identifier]*core.Authorization,
+	request *http.Request) (*core.Order, error) {
+	if len(idents) == 0 {
+		return nil, errors.New("certificates must have identifiers")
+	}
+	if profile == "" && wfe.ca.GetProfile(ca.DefaultProfile) != nil {
+		profile = ca.DefaultProfile
+	}
+	if profile != "" && wfe.ca.GetProfile(profile) == nil {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+
+	expires := wfe.clk.Now().Add(fixtureAuthzExpire)
+	order := &core.Order{
+		ID:        newToken(),
+		AccountID: acct.ID,
+		Order: acme.Order{
+			Status:  acme.StatusReady,
+			Expires: expires.UTC().Format(time.RFC3339),
+			Profile: profile,
+		},
+		ExpiresDate: expires,
+	}
+	for _, ident := range idents {
+		ident.AncestorDomain = ""
+		if ident.Type == acme.IdentifierDNS {
+			ident.Value = strings.ToLower(ident.Value)
+		}
+		if prob := wfe.verifyIdentifier(ident); prob != nil {
+			return nil, errors.New(prob.Detail)
+		}
+		authz, ok := authzs[ident]
+		if !ok {
+			var err error
+			if authz, err = wfe.addFixtureAuthorization(order, ident, expires, request); err != nil {
+				return nil, err
+			}
+			authzs[ident] = authz
+		}
+		order.Identifiers = append(order.Identifiers, ident)
+		order.Authorizations = append(order.Authorizations, authz.URL)
+		order.AuthorizationObjects = append(order.AuthorizationObjects, authz)
+	}
+	if _, err := wfe.db.AddOrder(order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// addFixtureAuthorization adds a valid authorization for an identifier of an
+// order to the database. The first challenge offered for the identifier is
+// valid.
+func (wfe *WebFrontEndImpl) addFixtureAuthorization(
+	order *core.Order,
+	ident acme.Identifier,
+	expires time.Time,
+	request *http.Request) (*core.Authorization, error) {
+	authz := &core.Authorization{
+		ID:          newToken(),
+		ExpiresDate: expires,
+		Order:       order,
+		Authorization: acme.Authorization{
+			Status:     acme.StatusValid,
+			Identifier: ident,
+			Expires:    expires.UTC().Format(time.RFC3339),
+		},
+	}
+	authz.URL = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
+	if err := wfe.makeChallenges(authz, request); err != nil {
+		return nil, err
+	}
+	if len(authz.Challenges) == 0 {
+		return nil, fmt.Errorf("no challenges are offered for %s", ident.Value)
+	}
+	now := wfe.clk.Now().UTC()
+	authz.Challenges[0].Update(func(chal *core.Challenge) {
+		chal.Status = acme.StatusValid
+		chal.ValidatedDate = now
+		chal.Validated = now.Format(time.RFC3339)
+	})
+	if _, err := wfe.db.AddAuthorization(authz); err != nil {
+		return nil, err
+	}
+	return authz, nil
+}
+
+// issueFixtureCertificate issues the certificate of a fixture order for a
+// public key.
+func (wfe *WebFrontEndImpl) issueFixtureCertificate(order *core.Order, key crypto.PublicKey) error {
+	csr := &x509.CertificateRequest{PublicKey: key}
+	for _, ident := range order.Identifiers {
+		if ident.Type == acme.IdentifierIP {
+			csr.IPAddresses = append(csr.IPAddresses, net.ParseIP(ident.Value))
+			continue
+		}
+		csr.DNSNames = append(csr.DNSNames, ident.Value)
+	}
+	order.Update(func(order *core.Order) {
+		order.ParsedCSR = csr
+		order.BeganProcessing = true
+		order.Status = acme.StatusProcessing
+	})
+
+	wfe.completeOrder(context.Background(), order)
+	cert := order.Snapshot().CertificateObject
+	if cert == nil {
+		return fmt.Errorf("issuing the certificate of order %s failed", order.ID)
+	}
+	wfe.log.Printf("Loaded fixture certificate %s of order %s", cert.ID, order.ID)
+	return nil
+}
+
+// parseFixtureKey parses a PEM encoded private or public key of the fixtures,
+// returning its public key. A new ECDSA P-256 key is generated if it is empty.
+func parseFixtureKey(pemKey string) (crypto.PublicKey, error) {
+	if pemKey == "" {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public(), nil
+	}
+
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("no PEM key found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k.Public(), nil
+	case *ecdsa.PrivateKey:
+		return k.Public(), nil
+	case ed25519.PrivateKey:
+		return k.Public(), nil
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
@@ -0,0 +1,100 @@
+package wfe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/db"
+)
+
+// defaultNonceLifetime is how long unused nonces are kept by the garbage
+// collection unless configured otherwise.
+const defaultNonceLifetime = time.Hour
+
+// GCConfig configures the garbage collection of expired objects, so that
+// long running Pebble instances don't grow without bound.
+type GCConfig struct {
+	// Interval is the number of seconds between garbage collections. Zero
+	// disables the garbage collection.
+	Interval int
+	// Retention is the number of seconds orders, authorizations and
+	// challenges are kept after they expire.
+	Retention int
+	// NonceLifetime is the number of seconds unused nonces, and used nonces
+	// remembered to detect replays, are kept. Defaults to one hour.
+	NonceLifetime int
+}
+
+// gcStats counts the objects pruned by the garbage collection since Pebble
+// started.
+type gcStats struct {
+	sync.Mutex
+	db.PruneCounts
+	Nonces int `json:"nonces"`
+	Runs   int `json:"runs"`
+}
+
+// SetGarbageCollection starts the garbage collection of expired objects. It
+// must be called at most once, before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetGarbageCollection(config GCConfig) error {
+	if config.Interval < 0 || config.Retention < 0 || config.NonceLifetime < 0 {
+		return errors.New("garbage collection settings must not be negative")
+	}
+	if config.Interval == 0 {
+		return nil
+	}
+
+	interval := time.Duration(config.Interval) * time.Second
+	retention := time.Duration(config.Retention) * time.Second
+	nonceLifetime := defaultNonceLifetime
+	if config.NonceLifetime > 0 {
+		nonceLifetime = time.Duration(config.NonceLifetime) * time.Second
+	}
+	wfe.log.Printf("Collecting garbage every %s, keeping expired objects for %s and nonces for %s",
+		interval, retention, nonceLifetime)
+
+	go func() {
+		for range time.Tick(interval) {
+			wfe.collectGarbage(retention, nonceLifetime)
+		}
+	}()
+	return nil
+}
+
+// collectGarbage prunes the objects that expired longer than retention ago and
+// the nonces older than nonceLifetime.
+func (wfe *WebFrontEndImpl) collectGarbage(retention, nonceLifetime time.Duration) {
+	now := wfe.clk.Now()
+	counts := wfe.db.PruneExpired(now.Add(-retention))
+	nonces := wfe.nonce.prune(now.Add(-nonceLifetime))
+
+	wfe.gcStats.Lock()
+	defer wfe.gcStats.Unlock()
+	wfe.gcStats.Orders += counts.Orders
+	wfe.gcStats.Authorizations += counts.Authorizations
+	wfe.gcStats.Challenges += counts.Challenges
+	wfe.gcStats.Nonces += nonces
+	wfe.gcStats.Runs++
+
+	if counts != (db.PruneCounts{}) || nonces > 0 {
+		wfe.log.Printf("Pruned %d orders, %d authorizations, %d challenges and %d nonces",
+			counts.Orders, counts.Authorizations, counts.Challenges, nonces)
+	}
+}
+
+// handleGCStats shows the number of objects pruned by the garbage collection.
+func (wfe *WebFrontEndImpl) handleGCStats(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	wfe.gcStats.Lock()
+	defer wfe.gcStats.Unlock()
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.gcStats)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,100 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/letsencrypt/pebble/db"
+)
+
+// listeners tracks whether the listeners Pebble serves requests on are
+// listening, keyed by the name of the listener.
+type listeners struct {
+	sync.Mutex
+	listening map[string]bool
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Status    string          `json:"status"`
+	Listeners map[string]bool `json:"listeners"`
+	CA        string          `json:"ca"`
+	Store     db.StoreStats   `json:"store"`
+}
+
+// AddListener registers a listener that must be listening for Pebble to be
+// ready. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) AddListener(name string) {
+	wfe.listeners.Lock()
+	defer wfe.listeners.Unlock()
+	wfe.listeners.listening[name] = false
+}
+
+// SetListening marks a listener as listening.
+func (wfe *WebFrontEndImpl) SetListening(name string) {
+	wfe.listeners.Lock()
+	defer wfe.listeners.Unlock()
+	wfe.listeners.listening[name] = true
+}
+
+// handleHealthz reports that Pebble is running.
+func (wfe *WebFrontEndImpl) handleHealthz(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, healthResponse{Status: "ok"})
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleReadyz reports whether Pebble is ready to serve ACME requests: all
+// listeners are listening and the CA can issue certificates. The response
+// includes the numbers of objects in the store.
+func (wfe *WebFrontEndImpl) handleReadyz(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	readiness := readinessResponse{
+		Status:    "ready",
+		Listeners: make(map[string]bool),
+		CA:        "ready",
+		Store:     wfe.db.Stats(),
+	}
+	wfe.listeners.Lock()
+	for name, listening := range wfe.listeners.listening {
+		readiness.Listeners[name] = listening
+		if !listening {
+			readiness.Status = "not ready"
+		}
+	}
+	wfe.listeners.Unlock()
+	if err := wfe.ca.Ready(); err != nil {
+		readiness.CA = err.Error()
+		readiness.Status = "not ready"
+	}
+
+	status := http.StatusOK
+	if readiness.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	err := wfe.writeJSONResponse(response, status, readiness)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,210 @@
+package wfe
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxDNSLabelLength is the maximum length of a DNS label. See RFC 1035
+// Section 2.3.4.
+const maxDNSLabelLength = 63
+
+// IdentifierRule matches the identifiers of orders by a regular expression or
+// a domain suffix.
+type IdentifierRule struct {
+	// Regular expression the whole value of an identifier matches, e.g.
+	// "^[a-z]+\\.example\\.com$"
+	Regex string
+	// Domain a DNS identifier is equal to or a subdomain of, e.g.
+	// "example.com"
+	Suffix string
+}
+
+// IdentifierPolicy configures which identifiers newOrder requests may include.
+// The zero value accepts all identifiers.
+type IdentifierPolicy struct {
+	// If not empty, DNS identifiers must match one of the rules
+	Allow []IdentifierRule
+	// Identifiers matching one of the rules are rejected
+	Deny []IdentifierRule
+	// Reject DNS identifiers that are public suffixes, like "co.uk", according
+	// to the public suffix list
+	RejectPublicSuffixes bool
+	// Reject DNS identifiers with empty labels, labels longer than 63
+	// characters, labels starting or ending with a hyphen, or an all-numeric
+	// top-level domain
+	ValidateLabels bool
+	// Maximum number of labels of DNS identifiers, not counting the wildcard
+	// label. Zero means no limit.
+	MaxLabels int
+	// IP networks in CIDR notation, e.g. "10.0.0.0/8", whose addresses are
+	// rejected as identifiers
+	BlockedNetworks []string
+}
+
+// identifierRule is a parsed IdentifierRule.
+type identifierRule struct {
+	regex  *regexp.Regexp
+	suffix string
+}
+
+// identifierPolicy is a parsed IdentifierPolicy.
+type identifierPolicy struct {
+	allow                []identifierRule
+	deny                 []identifierRule
+	rejectPublicSuffixes bool
+	validateLabels       bool
+	maxLabels            int
+	blockedNetworks      []*net.IPNet
+}
+
+// newIdentifierRules parses identifier rules.
+func newIdentifierRules(rules []IdentifierRule) ([]identifierRule, error) {
+	var parsed []identifierRule
+	for _, rule := range rules {
+		if (rule.Regex == "") == (rule.Suffix == "") {
+			return nil, fmt.Errorf("identifier rules must have either a regex or a suffix")
+		}
+		r := identifierRule{suffix: strings.ToLower(strings.TrimPrefix(rule.Suffix, "."))}
+		if rule.Regex != "" {
+			regex, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("identifier rule regex %q: %s", rule.Regex, err)
+			}
+			r.regex = regex
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+func newIdentifierPolicy(policy IdentifierPolicy) (*identifierPolicy, error) {
+	allow, err := newIdentifierRules(policy.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newIdentifierRules(policy.Deny)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxLabels < 0 {
+		return nil, fmt.Errorf("identifier max labels must not be negative")
+	}
+	p := &identifierPolicy{
+		allow:                allow,
+		deny:                 deny,
+		rejectPublicSuffixes: policy.RejectPublicSuffixes,
+		validateLabels:       policy.ValidateLabels,
+		maxLabels:            policy.MaxLabels,
+	}
+	for _, cidr := range policy.BlockedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("blocked network %q: %s", cidr, err)
+		}
+		p.blockedNetworks = append(p.blockedNetworks, network)
+	}
+	return p, nil
+}
+
+// SetIdentifierPolicy configures which identifiers newOrder requests may
+// include. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetIdentifierPolicy(policy IdentifierPolicy) error {
+	p, err := newIdentifierPolicy(policy)
+	if err != nil {
+		return err
+	}
+	wfe.identifierPolicy = p
+	return nil
+}
+
+// matches returns whether an identifier matches the rule. The value of a
+// wildcard identifier is matched by regular expressions as is, and by suffixes
+// without the wildcard label.
+func (r identifierRule) matches(ident acme.Identifier) bool {
+	value := strings.ToLower(ident.Value)
+	if r.regex != nil {
+		return r.regex.MatchString(value)
+	}
+	if ident.Type != acme.IdentifierDNS {
+		return false
+	}
+	value = strings.TrimPrefix(value, "*.")
+	return value == r.suffix || strings.HasSuffix(value, "."+r.suffix)
+}
+
+// matchesAny returns whether an identifier matches one of the rules.
+func matchesAny(rules []identifierRule, ident acme.Identifier) bool {
+	for _, r := range rules {
+		if r.matches(ident) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns a rejectedIdentifier problem if the policy doesn't allow an
+// identifier of a new order, which has already been checked to be well
+// formed.
+func (p *identifierPolicy) check(ident acme.Identifier) *acme.ProblemDetails {
+	if matchesAny(p.deny, ident) {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"Identifier %q is forbidden by policy", ident.Value))
+	}
+
+	if ident.Type == acme.IdentifierIP {
+		ip := net.ParseIP(ident.Value)
+		for _, network := range p.blockedNetworks {
+			if network.Contains(ip) {
+				return acme.RejectedIdentifierProblem(fmt.Sprintf(
+					"IP address %q is in the blocked network %s", ident.Value, network))
+			}
+		}
+		return nil
+	}
+
+	if len(p.allow) > 0 && !matchesAny(p.allow, ident) {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"Identifier %q is not allowed by policy", ident.Value))
+	}
+	name := strings.ToLower(strings.TrimPrefix(ident.Value, "*."))
+	labels := strings.Split(name, ".")
+	if p.validateLabels {
+		for _, label := range labels {
+			if label == "" {
+				return acme.RejectedIdentifierProblem(fmt.Sprintf(
+					"DNS identifier %q has an empty label", ident.Value))
+			}
+			if len(label) > maxDNSLabelLength {
+				return acme.RejectedIdentifierProblem(fmt.Sprintf(
+					"DNS identifier %q has a label longer than %d characters", ident.Value, maxDNSLabelLength))
+			}
+			if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+				return acme.RejectedIdentifierProblem(fmt.Sprintf(
+					"DNS identifier %q has a label starting or ending with a hyphen", ident.Value))
+			}
+		}
+		if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+			return acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"DNS identifier %q has an all-numeric top-level domain", ident.Value))
+		}
+	}
+	if p.maxLabels > 0 && len(labels) > p.maxLabels {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"DNS identifier %q has more than %d labels", ident.Value, p.maxLabels))
+	}
+	if p.rejectPublicSuffixes {
+		// Private suffixes, like "github.io", are rejected like ICANN suffixes
+		if suffix, _ := publicsuffix.PublicSuffix(name); suffix == name {
+			return acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"DNS identifier %q is a public suffix", ident.Value))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package wfe
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// defaultJWSAlgorithms are the JWS algorithms accepted if the key policy
+// doesn't list any.
+var defaultJWSAlgorithms = []string{
+	string(jose.RS256), string(jose.ES256), string(jose.ES384), string(jose.ES512),
+}
+
+// jwsAlgorithmCurves are the curves of the ECDSA JWS algorithms. See RFC 7518
+// Section 3.4.
+var jwsAlgorithmCurves = map[string]string{
+	string(jose.ES256): "P-256",
+	string(jose.ES384): "P-384",
+	string(jose.ES512): "P-521",
+}
+
+// KeyPolicy limits the account keys and the JWS algorithms of ACME requests.
+// The zero value accepts RSA keys of any size with RS256 and ECDSA keys with
+// ES256, ES384 and ES512.
+type KeyPolicy struct {
+	// JWS algorithms accepted, from RS256, RS384, RS512, PS256, PS384, PS512,
+	// ES256, ES384, ES512 and EdDSA. A key type is only accepted if one of
+	// its algorithms is, e.g. Ed25519 keys with EdDSA.
+	Algorithms []string
+	// Smallest and largest RSA key sizes in bits accepted. Zero means no
+	// limit.
+	MinRSAKeySize int
+	MaxRSAKeySize int
+}
+
+// keyPolicy is the KeyPolicy with the defaults applied.
+type keyPolicy struct {
+	algorithms    []string
+	allowed       map[string]bool
+	minRSAKeySize int
+	maxRSAKeySize int
+}
+
+func newKeyPolicy(policy KeyPolicy) *keyPolicy {
+	p := &keyPolicy{
+		algorithms:    policy.Algorithms,
+		allowed:       make(map[string]bool),
+		minRSAKeySize: policy.MinRSAKeySize,
+		maxRSAKeySize: policy.MaxRSAKeySize,
+	}
+	if len(p.algorithms) == 0 {
+		p.algorithms = defaultJWSAlgorithms
+	}
+	for _, alg := range p.algorithms {
+		p.allowed[alg] = true
+	}
+	return p
+}
+
+// SetKeyPolicy configures the account keys and JWS algorithms that are
+// accepted. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetKeyPolicy(policy KeyPolicy) error {
+	for _, alg := range policy.Algorithms {
+		if keyTypeForAlgorithm(alg) == "" {
+			return fmt.Errorf("unsupported JWS algorithm %q", alg)
+		}
+	}
+	if policy.MinRSAKeySize < 0 || policy.MaxRSAKeySize < 0 {
+		return fmt.Errorf("RSA key sizes must not be negative")
+	}
+	if policy.MaxRSAKeySize != 0 && policy.MinRSAKeySize > policy.MaxRSAKeySize {
+		return fmt.Errorf("minRSAKeySize %d is above maxRSAKeySize %d",
+			policy.MinRSAKeySize, policy.MaxRSAKeySize)
+	}
+	wfe.keyPolicy = newKeyPolicy(policy)
+	return nil
+}
+
+// keyTypeForAlgorithm returns the JWK key type signing with the JWS
+// algorithm, or "" if the algorithm isn't supported.
+func keyTypeForAlgorithm(alg string) string {
+	switch jose.SignatureAlgorithm(alg) {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		return "RSA"
+	case jose.ES256, jose.ES384, jose.ES512:
+		return "EC"
+	case jose.EdDSA:
+		return "OKP"
+	}
+	return ""
+}
+
+// checkKey returns a badPublicKey problem if the key policy doesn't accept
+// the key. ML-DSA keys are accepted if mldsa is set.
+func (p *keyPolicy) checkKey(key *jose.JSONWebKey, mldsa bool) *acme.ProblemDetails {
+	if core.MLDSAAlgorithm(key.Key) != "" {
+		if !mldsa {
+			return acme.BadPublicKeyProblem("ML-DSA keys are not accepted")
+		}
+		return nil
+	}
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		if !p.allowsKeyType("RSA", "") {
+			return acme.BadPublicKeyProblem("RSA keys are not accepted")
+		}
+		size := k.N.BitLen()
+		if p.minRSAKeySize != 0 && size < p.minRSAKeySize {
+			return acme.BadPublicKeyProblem(fmt.Sprintf(
+				"RSA key of %d bits is too small, the minimum is %d bits", size, p.minRSAKeySize))
+		}
+		if p.maxRSAKeySize != 0 && size > p.maxRSAKeySize {
+			return acme.BadPublicKeyProblem(fmt.Sprintf(
+				"RSA key of %d bits is too large, the maximum is %d bits", size, p.maxRSAKeySize))
+		}
+	case *ecdsa.PublicKey:
+		curve := k.Params().Name
+		if !p.allowsKeyType("EC", curve) {
+			return acme.BadPublicKeyProblem(fmt.Sprintf("ECDSA %s keys are not accepted", curve))
+		}
+	case ed25519.PublicKey:
+		if !p.allowsKeyType("OKP", "") {
+			return acme.BadPublicKeyProblem("Ed25519 keys are not accepted")
+		}
+	default:
+		return acme.BadPublicKeyProblem(fmt.Sprintf(
+			"no signature algorithms suitable for given key type: %T", key.Key))
+	}
+	return nil
+}
+
+// allowsKeyType returns whether one of the accepted algorithms signs with
+// keys of the JWK key type, and for ECDSA keys of the curve.
+func (p *keyPolicy) allowsKeyType(keyType, curve string) bool {
+	for _, alg := range p.algorithms {
+		if keyTypeForAlgorithm(alg) == keyType && (curve == "" || jwsAlgorithmCurves[alg] == curve) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAlgorithm checks that the key policy accepts (1) the key, and (2) the
+// algorithm of the JWS header, which must fit the key, and that (3) the
+// Algorithm field on the JWK is either absent or matches the algorithm of the
+// JWS header. ML-DSA keys and algorithms are accepted if mldsa is set.
+// Precondition: parsedJws must have exactly one signature on it.
+func (p *keyPolicy) checkAlgorithm(
+	key *jose.JSONWebKey,
+	parsedJws *jose.JSONWebSignature,
+	mldsa bool) *acme.ProblemDetails {
+	if prob := p.checkKey(key, mldsa); prob != nil {
+		return prob
+	}
+	algorithms := p.algorithms
+	if mldsa {
+		algorithms = append(append([]string{}, algorithms...), core.MLDSAAlgorithms...)
+	}
+	jwsAlgorithm := parsedJws.Signatures[0].Header.Algorithm
+	if !(p.allowed[jwsAlgorithm] || mldsa && isMLDSAAlgorithm(jwsAlgorithm)) || !fitsKey(jwsAlgorithm, key) {
+		return acme.BadSignatureAlgorithmProblem(fmt.Sprintf(
+			"signature type '%s' in JWS header is not supported, expected one of %s",
+			jwsAlgorithm, strings.Join(algorithms, ", ")), algorithms)
+	}
+	if key.Algorithm != "" && key.Algorithm != jwsAlgorithm {
+		return acme.BadPublicKeyProblem(fmt.Sprintf(
+			"algorithm '%s' on JWK is unacceptable", key.Algorithm))
+	}
+	return nil
+}
+
+// fitsKey returns whether the JWS algorithm signs with the key.
+func fitsKey(alg string, key *jose.JSONWebKey) bool {
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		return keyTypeForAlgorithm(alg) == "RSA"
+	case *ecdsa.PublicKey:
+		return jwsAlgorithmCurves[alg] == k.Params().Name
+	case ed25519.PublicKey:
+		return alg == string(jose.EdDSA)
+	}
+	return alg == core.MLDSAAlgorithm(key.Key)
+}
@@ -0,0 +1,69 @@
+package wfe
+
+import (
+	"fmt"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// IdentifierLimits limits the identifiers of orders, so that clients can test
+// splitting large sets of names over several orders.
+type IdentifierLimits struct {
+	// MaxIdentifiers is the maximum number of identifiers per order. Zero
+	// means no limit.
+	MaxIdentifiers int
+	// MaxSANLength is the maximum length of DNS identifiers. Zero means the
+	// DNS limit of 253 characters.
+	MaxSANLength int
+	// MaxCNLength is the maximum length of the common name of finalization
+	// CSRs. Zero means no limit.
+	MaxCNLength int
+}
+
+// SetIdentifierLimits configures the limits on order identifiers. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetIdentifierLimits(limits IdentifierLimits) {
+	wfe.identifierLimits = limits
+}
+
+// verifyIdentifierCount rejects orders with more identifiers than allowed.
+// The identifiers over the limit are reported as subproblems.
+func (wfe *WebFrontEndImpl) verifyIdentifierCount(idents []acme.Identifier) *acme.ProblemDetails {
+	max := wfe.identifierLimits.MaxIdentifiers
+	if max == 0 || len(idents) <= max {
+		return nil
+	}
+	detail := fmt.Sprintf("Order included more than %d identifiers", max)
+	var probs []acme.SubProblemDetails
+	for _, ident := range idents[max:] {
+		probs = append(probs, acme.SubProblem(ident, acme.RejectedIdentifierProblem(detail)))
+	}
+	prob := acme.RejectedIdentifierProblem(fmt.Sprintf("%s (%d identifiers)", detail, len(idents)))
+	prob.Subproblems = probs
+	return prob
+}
+
+// verifyIdentifierLength rejects DNS identifiers longer than allowed.
+func (wfe *WebFrontEndImpl) verifyIdentifierLength(name string) *acme.ProblemDetails {
+	max := wfe.identifierLimits.MaxSANLength
+	if max == 0 || max >= maxDNSIdentifierLength || len(name) <= max {
+		return nil
+	}
+	return acme.RejectedIdentifierProblem(fmt.Sprintf(
+		"Order included DNS identifier that was longer than %d characters", max))
+}
+
+// verifyCommonNameLength rejects finalization CSRs with a common name longer
+// than allowed.
+func (wfe *WebFrontEndImpl) verifyCommonNameLength(cn string) *acme.ProblemDetails {
+	max := wfe.identifierLimits.MaxCNLength
+	if max == 0 || len(cn) <= max {
+		return nil
+	}
+	prob := acme.BadCSRProblem(fmt.Sprintf(
+		"CSR common name is longer than %d characters", max))
+	prob.Subproblems = []acme.SubProblemDetails{
+		acme.SubProblem(acme.Identifier{Type: acme.IdentifierDNS, Value: cn}, prob),
+	}
+	return prob
+}
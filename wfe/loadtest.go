@@ -0,0 +1,234 @@
+package wfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+)
+
+// issuanceRate measures the rates at which orders are created and
+// certificates issued since Pebble started or the issuance statistics were
+// reset. It uses the wall clock rather than the WFE's clock, which the
+// management interface can move. It's shared by the copies of the WFE.
+type issuanceRate struct {
+	sync.Mutex
+	since time.Time
+	// The total counters at the last report and when they were taken
+	reported   db.IssuanceCounters
+	reportedAt time.Time
+	// The most certificates issued per second over a report interval
+	peak float64
+}
+
+// issuanceRateSummary is the issuance rate returned by the management
+// interface.
+type issuanceRateSummary struct {
+	Since                     string  `json:"since"`
+	Seconds                   float64 `json:"seconds"`
+	OrdersCreated             int     `json:"ordersCreated"`
+	CertificatesIssued        int     `json:"certificatesIssued"`
+	OrdersPerSecond           float64 `json:"ordersPerSecond"`
+	CertificatesPerSecond     float64 `json:"certificatesPerSecond"`
+	PeakCertificatesPerSecond float64 `json:"peakCertificatesPerSecond"`
+}
+
+func newIssuanceRate() *issuanceRate {
+	now := time.Now()
+	return &issuanceRate{since: now, reportedAt: now}
+}
+
+// reset starts measuring the issuance rate anew.
+func (r *issuanceRate) reset() {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	r.since, r.reported, r.reportedAt, r.peak = now, db.IssuanceCounters{}, now, 0
+}
+
+// summary returns the average rates of the total counters since the
+// measurement started. The caller must hold the lock.
+func (r *issuanceRate) summary(total db.IssuanceCounters, now time.Time) issuanceRateSummary {
+	seconds := now.Sub(r.since).Seconds()
+	summary := issuanceRateSummary{
+		Since:                     r.since.UTC().Format(time.RFC3339),
+		Seconds:                   seconds,
+		OrdersCreated:             total.OrdersCreated,
+		CertificatesIssued:        total.CertificatesIssued,
+		PeakCertificatesPerSecond: r.peak,
+	}
+	if seconds > 0 {
+		summary.OrdersPerSecond = float64(total.OrdersCreated) / seconds
+		summary.CertificatesPerSecond = float64(total.CertificatesIssued) / seconds
+	}
+	return summary
+}
+
+// SetIssuanceRateReport starts logging a summary of the issuance rate every
+// interval seconds. Zero disables the summaries. It must be called at most
+// once, before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetIssuanceRateReport(interval int) error {
+	if interval < 0 {
+		return errors.New("issuance rate report interval must not be negative")
+	}
+	if interval == 0 {
+		return nil
+	}
+	wfe.log.Printf("Reporting the issuance rate every %ds", interval)
+
+	go func() {
+		for range time.Tick(time.Duration(interval) * time.Second) {
+			wfe.reportIssuanceRate()
+		}
+	}()
+	return nil
+}
+
+// reportIssuanceRate logs the rates of the orders created and the
+// certificates issued since the last report, and on average.
+func (wfe *WebFrontEndImpl) reportIssuanceRate() {
+	total := wfe.db.IssuanceStats().Total
+	r := wfe.issuanceRate
+	r.Lock()
+	now := time.Now()
+	elapsed := now.Sub(r.reportedAt)
+	orders := total.OrdersCreated - r.reported.OrdersCreated
+	certs := total.CertificatesIssued - r.reported.CertificatesIssued
+	r.reported, r.reportedAt = total, now
+	certRate := float64(certs) / elapsed.Seconds()
+	if certRate > r.peak {
+		r.peak = certRate
+	}
+	summary := r.summary(total, now)
+	r.Unlock()
+
+	wfe.log.Printf("Issuance rate: %d certificates (%.1f/s) and %d orders (%.1f/s) in the last %s, "+
+		"%.1f certificates/s since %s, peak %.1f/s",
+		certs, certRate, orders, float64(orders)/elapsed.Seconds(), elapsed.Round(time.Second),
+		summary.CertificatesPerSecond, summary.Since, summary.PeakCertificatesPerSecond)
+}
+
+// handleIssuanceRate returns the numbers of orders created and certificates
+// issued with their average rates since Pebble started or the issuance
+// statistics were reset, and the peak certificate rate of the logged reports.
+func (wfe *WebFrontEndImpl) handleIssuanceRate(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	total := wfe.db.IssuanceStats().Total
+	wfe.issuanceRate.Lock()
+	summary := wfe.issuanceRate.summary(total, time.Now())
+	wfe.issuanceRate.Unlock()
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, summary)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePreauthorize adds valid authorizations for a batch of domains to the
+// account given by ID or URL in the body of a POST request, e.g. {"account":
+// "1", "domains": ["a.example.com", "b.example.com"]}, so that load tests can
+// create orders without solving challenges. IP addresses are authorized as IP
+// identifiers. New orders reuse the authorizations as the authorization reuse
+// policy says.
+func (wfe *WebFrontEndImpl) handlePreauthorize(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Account string
+		Domains []string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+	if len(req.Domains) == 0 {
+		wfe.sendError(acme.MalformedProblem("No domains to preauthorize"), response)
+		return
+	}
+
+	accountID := objectID(req.Account)
+	acct := wfe.db.GetAccountByID(accountID)
+	if acct == nil {
+		wfe.sendError(acme.AccountDoesNotExistProblem(fmt.Sprintf(
+			"No account %q", req.Account)), response)
+		return
+	}
+	acmeWFE, acmeRequest, err := wfe.acmeRequest(acct)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem(err.Error()), response)
+		return
+	}
+
+	var idents []acme.Identifier
+	seen := make(map[string]bool)
+	for _, domain := range req.Domains {
+		domain = strings.ToLower(domain)
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		ident := acme.Identifier{Type: acme.IdentifierDNS, Value: domain}
+		if net.ParseIP(domain) != nil {
+			ident.Type = acme.IdentifierIP
+		}
+		idents = append(idents, ident)
+	}
+	// The authorizations are valid for an order that is never finalized, like
+	// those of the fixtures
+	authzs := make(map[acme.Identifier]*core.Authorization)
+	order, err := acmeWFE.addFixtureOrder(acct, idents, "", authzs, acmeRequest)
+	if err != nil {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Error preauthorizing domains: %s", err)), response)
+		return
+	}
+	wfe.log.Printf("Preauthorized %d identifiers for account %s", len(idents), accountID)
+
+	result := struct {
+		Expires        string   `json:"expires"`
+		Authorizations []string `json:"authorizations"`
+	}{
+		Expires:        order.Expires,
+		Authorizations: order.Authorizations,
+	}
+	err = wfe.writeJSONResponse(response, http.StatusOK, result)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// acmeRequest returns a copy of the WFE and a request whose URLs are those of
+// the ACME interface the account was created on, so that resources for the
+// account can be created on the management interface.
+func (wfe *WebFrontEndImpl) acmeRequest(acct *core.Account) (*WebFrontEndImpl, *http.Request, error) {
+	if acct.Orders == "" {
+		return nil, nil, fmt.Errorf("account %s has no orders URL", acct.ID)
+	}
+	base, err := url.Parse(strings.TrimSuffix(acct.Orders, ordersPath+acct.ID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing orders URL of account %s: %s", acct.ID, err)
+	}
+	acmeWFE := wfe.Clone()
+	acmeWFE.externalURL = &url.URL{
+		Scheme: base.Scheme,
+		Host:   base.Host,
+		Path:   strings.TrimSuffix(base.Path, wfe.pathPrefix),
+	}
+	request, err := http.NewRequest(http.MethodGet, base.String()+"/", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return acmeWFE, request, nil
+}
@@ -0,0 +1,63 @@
+package wfe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/pebble/db"
+)
+
+func TestIssuanceRateSummary(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name    string
+		total   db.IssuanceCounters
+		elapsed time.Duration
+		want    issuanceRateSummary
+	}{
+		{
+			name:  "nothing elapsed",
+			total: db.IssuanceCounters{OrdersCreated: 2, CertificatesIssued: 1},
+			want: issuanceRateSummary{
+				Since:              "2024-01-01T00:00:00Z",
+				OrdersCreated:      2,
+				CertificatesIssued: 1,
+				// No rates without elapsed time
+			},
+		},
+		{
+			name:    "rates",
+			total:   db.IssuanceCounters{OrdersCreated: 120, CertificatesIssued: 60, CertificatesRevoked: 5},
+			elapsed: time.Minute,
+			want: issuanceRateSummary{
+				Since:                     "2024-01-01T00:00:00Z",
+				Seconds:                   60,
+				OrdersCreated:             120,
+				CertificatesIssued:        60,
+				OrdersPerSecond:           2,
+				CertificatesPerSecond:     1,
+				PeakCertificatesPerSecond: 3,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &issuanceRate{since: since, reportedAt: since, peak: tc.want.PeakCertificatesPerSecond}
+			if got := r.summary(tc.total, since.Add(tc.elapsed)); got != tc.want {
+				t.Errorf("summary() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIssuanceRateReset(t *testing.T) {
+	r := newIssuanceRate()
+	r.since = r.since.Add(-time.Hour)
+	r.reported = db.IssuanceCounters{CertificatesIssued: 10}
+	r.peak = 5
+
+	r.reset()
+	if time.Since(r.since) > time.Minute || r.reported != (db.IssuanceCounters{}) || r.peak != 0 {
+		t.Errorf("reset() left %+v", r)
+	}
+}
@@ -0,0 +1,12 @@
+package wfe
+
+import (
+	"github.com/letsencrypt/pebble/metrics"
+)
+
+// SetMetrics serves the Prometheus metrics of the registry at /metrics of the
+// management interface. It must be called before ManagementHandler.
+func (wfe *WebFrontEndImpl) SetMetrics(registry *metrics.Registry) {
+	wfe.metrics = registry
+	wfe.log.Printf("Serving metrics on the management interface")
+}
@@ -1,11 +1,19 @@
 package wfe
 
 import (
-	"crypto/rand"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/random"
 )
 
 /*
@@ -16,31 +24,180 @@ import (
  */
 const nonceLen = 16
 
+// Reasons a nonce is rejected
+var (
+	errNonceUnknown  = errors.New("JWS has an invalid anti-replay nonce")
+	errNonceUsed     = errors.New("JWS has an anti-replay nonce that was already used")
+	errNonceExpired  = errors.New("JWS has an expired anti-replay nonce")
+	errNonceRejected = errors.New("JWS has an anti-replay nonce rejected by a nonce rejection rule")
+)
+
+// NonceConfig configures the lifetime of nonces and rules rejecting valid
+// nonces, to test how clients handle badNonce problems.
+type NonceConfig struct {
+	// Seconds a nonce can be used after it was issued. Zero means nonces
+	// don't expire.
+	Lifetime int
+	// Percentage of valid nonces rejected at random. Defaults to 5.
+	RejectPercent *int
+	// Rules rejecting the valid nonces of matching requests
+	Reject []NonceRejection
+}
+
+// NonceRejection rejects the valid nonces of the requests to the given
+// endpoints by the given accounts with a badNonce problem.
+type NonceRejection struct {
+	// Names of the endpoints, like those of faults, e.g. "newOrder". Empty
+	// matches all endpoints.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// IDs or URLs of the accounts. Empty matches all requests, including
+	// those not signed by an account's key.
+	Accounts []string `json:"accounts,omitempty"`
+	// Number of nonces rejected before the rule stops matching. Zero rejects
+	// all nonces of matching requests.
+	Count int `json:"count,omitempty"`
+}
+
+// nonceRejection is a NonceRejection with the number of nonces it may still
+// reject.
+type nonceRejection struct {
+	NonceRejection
+	Remaining int `json:"remaining"`
+}
+
+func newNonceRejection(rule NonceRejection) (*nonceRejection, error) {
+	if rule.Count < 0 {
+		return nil, errors.New("nonce rejection count must not be negative")
+	}
+	for _, endpoint := range rule.Endpoints {
+		if _, ok := faultEndpoints[endpoint]; !ok {
+			return nil, fmt.Errorf("nonce rejection has unknown endpoint %q", endpoint)
+		}
+	}
+	accounts := make([]string, len(rule.Accounts))
+	for i, account := range rule.Accounts {
+		// Account URLs end with the account ID
+		accounts[i] = account[strings.LastIndex(account, "/")+1:]
+	}
+	rule.Accounts = accounts
+	return &nonceRejection{NonceRejection: rule, Remaining: rule.Count}, nil
+}
+
+// matches returns whether the rule rejects the nonce of a request to the
+// endpoint with the pattern by the account, which is "" if the request isn't
+// signed by an account's key. The caller must hold the nonce map's lock.
+func (r *nonceRejection) matches(pattern, accountID string) bool {
+	if r.Count > 0 && r.Remaining == 0 {
+		return false
+	}
+	if len(r.Endpoints) > 0 && !containsFunc(r.Endpoints, func(e string) bool {
+		return faultEndpoints[e] == pattern
+	}) {
+		return false
+	}
+	if len(r.Accounts) > 0 && !containsFunc(r.Accounts, func(a string) bool {
+		return a == accountID && accountID != ""
+	}) {
+		return false
+	}
+	if r.Count > 0 {
+		r.Remaining--
+	}
+	return true
+}
+
+func containsFunc(values []string, f func(string) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNonces configures the nonce lifetime and rejection rules. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetNonces(config NonceConfig) error {
+	if config.Lifetime < 0 {
+		return errors.New("nonce lifetime must not be negative")
+	}
+	rejectPercent := defaultNonceReject
+	if config.RejectPercent != nil {
+		rejectPercent = *config.RejectPercent
+	}
+	if rejectPercent < 0 || rejectPercent > 100 {
+		return fmt.Errorf("nonce reject percent %d is not between 0 and 100", rejectPercent)
+	}
+	var rules []*nonceRejection
+	for _, rule := range config.Reject {
+		r, err := newNonceRejection(rule)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	wfe.nonceLifetime = time.Duration(config.Lifetime) * time.Second
+	wfe.nonceRejections = rules
+	wfe.nonceErrPercent = rejectPercent
+	wfe.log.Printf("Configured to reject %d%% of good nonces", rejectPercent)
+	if config.Lifetime > 0 {
+		wfe.log.Printf("Nonces expire %s after they are issued", wfe.nonceLifetime)
+	}
+	for _, rule := range config.Reject {
+		wfe.log.Printf("Configured to reject nonces of %v requests by accounts %v (count %d)",
+			rule.Endpoints, rule.Accounts, rule.Count)
+	}
+	return nil
+}
+
 /*
- * Note: We place no upper bound on the number of nonces we issue. We obtain
- * a lock for both issuing nonces and checking them. This is *not* a performant
- * or safe strategy for a production server. Consider the NonceServer
- * approach[0] used by Boulder if you are looking for a more robust nonce
- * implementation for an ACME server.
+ * Note: We place no upper bound on the number of nonces we issue, unused
+ * nonces are only forgotten by the garbage collection (see gc.go). Used nonces
+ * are forgotten defaultNonceLifetime after they were used, or earlier by the
+ * garbage collection, so that serving requests doesn't grow the map without
+ * bound when the garbage collection is disabled. We obtain a lock for both issuing nonces and checking them. This is *not*
+ * a performant or safe strategy for a production server. Consider the
+ * NonceServer approach[0] used by Boulder if you are looking for a more robust
+ * nonce implementation for an ACME server.
  *
  * [0] - https://github.com/letsencrypt/boulder/blob/c8f1fb3e2fade026aad76f23eafa137482d89bf5/nonce/nonce.go
  */
 type nonceMap struct {
 	sync.Mutex
-	nonces map[string]struct{}
+	clk clock.Clock
+	// nonces maps the unused nonces to their creation time
+	nonces map[string]time.Time
+	// used maps the used nonces to the time they were used, so that replayed
+	// nonces are told apart from unknown ones
+	used map[string]time.Time
+	// usedOrder lists the used nonces in the order they were used, to forget
+	// them once they are older than defaultNonceLifetime
+	usedOrder []usedNonce
+	// rejections are the nonce rejection rules added with the management
+	// interface, which apply in addition to the configured ones
+	rejections []*nonceRejection
+}
+
+type usedNonce struct {
+	nonce string
+	used  time.Time
 }
 
-func newNonceMap() *nonceMap {
-	return &nonceMap{nonces: make(map[string]struct{})}
+func newNonceMap(clk clock.Clock) *nonceMap {
+	return &nonceMap{
+		clk:    clk,
+		nonces: make(map[string]time.Time),
+		used:   make(map[string]time.Time),
+	}
 }
 
 func (n *nonceMap) createNonce() string {
 	n.Lock()
 	defer n.Unlock()
 
-	// Read `nonceLen` random bytes from rand.Reader
+	// Read `nonceLen` random bytes from random.Reader
 	b := make([]byte, nonceLen)
-	_, err := io.ReadFull(rand.Reader, b)
+	_, err := io.ReadFull(random.Reader, b)
 	if err != nil {
 		panic(fmt.Sprintf("Error reading random bytes: %s", err))
 	}
@@ -48,21 +205,130 @@ func (n *nonceMap) createNonce() string {
 	// Encode the bytes to base64 URL encoding
 	nonce := base64.RawURLEncoding.EncodeToString(b)
 	// Record the nonce, and give it back to the caller
-	n.nonces[nonce] = struct{}{}
+	n.nonces[nonce] = n.clk.Now()
 	return nonce
 }
 
-func (n *nonceMap) validNonce(nonce string) bool {
+// useNonce consumes a nonce, which can only be used once. It returns an error
+// if the nonce isn't one we generated, was already used, is older than the
+// lifetime or is rejected by one of the rules.
+func (n *nonceMap) useNonce(
+	nonce string,
+	lifetime time.Duration,
+	rules []*nonceRejection,
+	pattern, accountID string) error {
 	n.Lock()
 	defer n.Unlock()
 
-	// If the nonce is one we generated its valid
-	if _, present := n.nonces[nonce]; present {
-		// Strike the nonce after it has been validated
-		// It can only be used once!
-		delete(n.nonces, nonce)
-		return true
+	created, present := n.nonces[nonce]
+	if !present {
+		if _, used := n.used[nonce]; used {
+			return errNonceUsed
+		}
+		return errNonceUnknown
 	}
+	// Strike the nonce, it can only be used once!
+	delete(n.nonces, nonce)
+	now := n.clk.Now()
+	n.forgetUsed(now.Add(-defaultNonceLifetime))
+	n.used[nonce] = now
+	n.usedOrder = append(n.usedOrder, usedNonce{nonce: nonce, used: now})
 
-	return false
+	if lifetime > 0 && now.Sub(created) > lifetime {
+		return errNonceExpired
+	}
+	for _, rules := range [][]*nonceRejection{rules, n.rejections} {
+		for _, rule := range rules {
+			if rule.matches(pattern, accountID) {
+				return errNonceRejected
+			}
+		}
+	}
+	return nil
+}
+
+// forgetUsed forgets the used nonces used before the given time. The caller
+// must hold the lock.
+func (n *nonceMap) forgetUsed(before time.Time) {
+	i := 0
+	for ; i < len(n.usedOrder) && n.usedOrder[i].used.Before(before); i++ {
+		// The garbage collection may have forgotten the nonce already
+		delete(n.used, n.usedOrder[i].nonce)
+	}
+	n.usedOrder = n.usedOrder[i:]
+}
+
+// prune forgets the unused nonces created and the used nonces used before the
+// given time, and returns their number.
+func (n *nonceMap) prune(before time.Time) int {
+	n.Lock()
+	defer n.Unlock()
+
+	pruned := 0
+	for _, nonces := range []map[string]time.Time{n.nonces, n.used} {
+		for nonce, t := range nonces {
+			if t.Before(before) {
+				delete(nonces, nonce)
+				pruned++
+			}
+		}
+	}
+	return pruned
+}
+
+// endpointKey is the request context key of the pattern of the endpoint a
+// request is handled by.
+type endpointKey struct{}
+
+func withEndpoint(request *http.Request, pattern string) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), endpointKey{}, pattern))
+}
+
+func requestEndpoint(request *http.Request) string {
+	pattern, _ := request.Context().Value(endpointKey{}).(string)
+	return pattern
+}
+
+// handleNonceRejections lists the nonce rejection rules added with the
+// management interface on GET, adds a rule on POST and removes all of them on
+// DELETE.
+func (wfe *WebFrontEndImpl) handleNonceRejections(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		wfe.nonce.Lock()
+		wfe.nonce.rejections = nil
+		wfe.nonce.Unlock()
+		wfe.log.Printf("Removed all nonce rejection rules of the management interface")
+	default:
+		var rule NonceRejection
+		if !wfe.readManagementPOST(response, request, &rule) {
+			return
+		}
+		r, err := newNonceRejection(rule)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		wfe.nonce.Lock()
+		wfe.nonce.rejections = append(wfe.nonce.rejections, r)
+		wfe.nonce.Unlock()
+		wfe.log.Printf("Added rule rejecting nonces of %v requests by accounts %v (count %d)",
+			rule.Endpoints, rule.Accounts, rule.Count)
+	}
+
+	wfe.nonce.Lock()
+	rules := make([]nonceRejection, 0, len(wfe.nonce.rejections))
+	for _, r := range wfe.nonce.rejections {
+		rules = append(rules, *r)
+	}
+	wfe.nonce.Unlock()
+	err := wfe.writeJSONResponse(response, http.StatusOK, rules)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
@@ -0,0 +1,273 @@
+package wfe
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/pebble/clock"
+)
+
+func TestSetNonces(t *testing.T) {
+	percent := func(p int) *int { return &p }
+	testCases := []struct {
+		name        string
+		config      NonceConfig
+		wantPercent int
+		wantErr     bool
+	}{
+		{
+			name:        "defaults",
+			wantPercent: defaultNonceReject,
+		},
+		{
+			name:        "never reject",
+			config:      NonceConfig{RejectPercent: percent(0)},
+			wantPercent: 0,
+		},
+		{
+			name:        "always reject",
+			config:      NonceConfig{Lifetime: 300, RejectPercent: percent(100)},
+			wantPercent: 100,
+		},
+		{
+			name:        "rules",
+			config:      NonceConfig{Reject: []NonceRejection{{Endpoints: []string{"newOrder"}, Count: 1}}},
+			wantPercent: defaultNonceReject,
+		},
+		{
+			name:    "negative lifetime",
+			config:  NonceConfig{Lifetime: -1},
+			wantErr: true,
+		},
+		{
+			name:    "negative percent",
+			config:  NonceConfig{RejectPercent: percent(-1)},
+			wantErr: true,
+		},
+		{
+			name:    "percent above 100",
+			config:  NonceConfig{RejectPercent: percent(101)},
+			wantErr: true,
+		},
+		{
+			name:    "unknown endpoint",
+			config:  NonceConfig{Reject: []NonceRejection{{Endpoints: []string{"newOrders"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative count",
+			config:  NonceConfig{Reject: []NonceRejection{{Count: -1}}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{log: log.New(io.Discard, "", 0)}
+			err := wfe.SetNonces(tc.config)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("SetNonces(%+v) returned no error", tc.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetNonces(%+v) returned error: %s", tc.config, err)
+			}
+			if wfe.nonceErrPercent != tc.wantPercent {
+				t.Errorf("SetNonces(%+v) set reject percent %d, want %d",
+					tc.config, wfe.nonceErrPercent, tc.wantPercent)
+			}
+			if want := time.Duration(tc.config.Lifetime) * time.Second; wfe.nonceLifetime != want {
+				t.Errorf("SetNonces(%+v) set lifetime %s, want %s", tc.config, wfe.nonceLifetime, want)
+			}
+			if len(wfe.nonceRejections) != len(tc.config.Reject) {
+				t.Errorf("SetNonces(%+v) set %d rules, want %d",
+					tc.config, len(wfe.nonceRejections), len(tc.config.Reject))
+			}
+		})
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lifetime time.Duration
+		advance  time.Duration
+		reuse    bool
+		unknown  bool
+		want     error
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:     "valid before expiry",
+			lifetime: time.Minute,
+			advance:  time.Minute - time.Second,
+		},
+		{
+			name:     "expired",
+			lifetime: time.Minute,
+			advance:  time.Minute + time.Second,
+			want:     errNonceExpired,
+		},
+		{
+			name:    "no lifetime",
+			advance: 24 * time.Hour,
+		},
+		{
+			name:  "used",
+			reuse: true,
+			want:  errNonceUsed,
+		},
+		{
+			name:    "unknown",
+			unknown: true,
+			want:    errNonceUnknown,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake()
+			nonces := newNonceMap(clk)
+			nonce := nonces.createNonce()
+			if err := clk.Advance(tc.advance); err != nil {
+				t.Fatalf("advancing clock: %s", err)
+			}
+			if tc.reuse {
+				if err := nonces.useNonce(nonce, tc.lifetime, nil, newOrderPath, "1"); err != nil {
+					t.Fatalf("first use of nonce returned error: %s", err)
+				}
+			}
+			if tc.unknown {
+				nonce = "bm90IGEgbm9uY2U"
+			}
+			if err := nonces.useNonce(nonce, tc.lifetime, nil, newOrderPath, "1"); err != tc.want {
+				t.Errorf("useNonce returned %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestUsedNoncesForgotten(t *testing.T) {
+	// Without the garbage collection, used nonces are only forgotten by
+	// useNonce
+	clk := clock.NewFake()
+	nonces := newNonceMap(clk)
+	useNonces := func(count int) []string {
+		var used []string
+		for i := 0; i < count; i++ {
+			nonce := nonces.createNonce()
+			if err := nonces.useNonce(nonce, 0, nil, newOrderPath, "1"); err != nil {
+				t.Fatalf("useNonce returned error: %s", err)
+			}
+			used = append(used, nonce)
+		}
+		return used
+	}
+	advance := func(d time.Duration) {
+		if err := clk.Advance(d); err != nil {
+			t.Fatalf("advancing clock: %s", err)
+		}
+	}
+
+	old := useNonces(10)
+	advance(defaultNonceLifetime / 2)
+	recent := useNonces(10)
+	advance(defaultNonceLifetime/2 + time.Second)
+	useNonces(1)
+
+	if len(nonces.used) != 11 || len(nonces.usedOrder) != 11 {
+		t.Errorf("%d used nonces remembered in %d entries, want 11",
+			len(nonces.used), len(nonces.usedOrder))
+	}
+	if err := nonces.useNonce(old[0], 0, nil, newOrderPath, "1"); err != errNonceUnknown {
+		t.Errorf("reusing a forgotten nonce returned %v, want %v", err, errNonceUnknown)
+	}
+	if err := nonces.useNonce(recent[0], 0, nil, newOrderPath, "1"); err != errNonceUsed {
+		t.Errorf("reusing a recent nonce returned %v, want %v", err, errNonceUsed)
+	}
+
+	// Nonces already pruned by the garbage collection are skipped
+	nonces.prune(clk.Now())
+	advance(defaultNonceLifetime + time.Second)
+	useNonces(1)
+	if len(nonces.used) != 1 || len(nonces.usedOrder) != 1 {
+		t.Errorf("%d used nonces remembered in %d entries, want 1",
+			len(nonces.used), len(nonces.usedOrder))
+	}
+}
+
+func TestNonceRejection(t *testing.T) {
+	type request struct {
+		pattern   string
+		accountID string
+		want      bool
+	}
+	testCases := []struct {
+		name     string
+		rule     NonceRejection
+		requests []request
+	}{
+		{
+			name: "all",
+			rule: NonceRejection{},
+			requests: []request{
+				{pattern: newOrderPath, accountID: "1", want: true},
+				{pattern: newAccountPath, want: true},
+			},
+		},
+		{
+			name: "endpoint",
+			rule: NonceRejection{Endpoints: []string{"newOrder", "finalize"}},
+			requests: []request{
+				{pattern: newOrderPath, accountID: "1", want: true},
+				{pattern: orderFinalizePath, accountID: "2", want: true},
+				{pattern: authzPath, accountID: "1", want: false},
+			},
+		},
+		{
+			name: "account by ID and URL",
+			rule: NonceRejection{Accounts: []string{"2", "https://localhost:14000/my-account/3"}},
+			requests: []request{
+				{pattern: newOrderPath, accountID: "1", want: false},
+				{pattern: newOrderPath, accountID: "2", want: true},
+				{pattern: newOrderPath, accountID: "3", want: true},
+				{pattern: newAccountPath, want: false},
+			},
+		},
+		{
+			name: "count",
+			rule: NonceRejection{Endpoints: []string{"newOrder"}, Count: 2},
+			requests: []request{
+				{pattern: authzPath, want: false},
+				{pattern: newOrderPath, want: true},
+				{pattern: newOrderPath, want: true},
+				{pattern: newOrderPath, want: false},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := newNonceRejection(tc.rule)
+			if err != nil {
+				t.Fatalf("newNonceRejection(%+v) returned error: %s", tc.rule, err)
+			}
+			nonces := newNonceMap(clock.NewFake())
+			for i, r := range tc.requests {
+				want := error(nil)
+				if r.want {
+					want = errNonceRejected
+				}
+				nonce := nonces.createNonce()
+				err := nonces.useNonce(nonce, 0, []*nonceRejection{rule}, r.pattern, r.accountID)
+				if err != want {
+					t.Errorf("request %d to %s by account %q returned %v, want %v",
+						i, r.pattern, r.accountID, err, want)
+				}
+			}
+		})
+	}
+}
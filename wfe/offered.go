@@ -0,0 +1,152 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// OfferedChallenges configures the built-in challenge types offered in new
+// authorizations by kind of identifier, so that the challenge selection of
+// clients can be tested. A nil list offers the default challenge types, an
+// empty list none.
+type OfferedChallenges struct {
+	// Challenge types offered for DNS names, by default http-01, tls-alpn-01
+	// and dns-01
+	DNS []string `json:"dns"`
+	// Challenge types offered for wildcard identifiers and subdomain
+	// authorizations, by default dns-01
+	Wildcard []string `json:"wildcard"`
+	// Challenge types offered for IP addresses, by default http-01 and
+	// tls-alpn-01
+	IP []string `json:"ip"`
+	// Challenge types not offered at all, including additional challenge
+	// types
+	Disabled []string `json:"disabled"`
+}
+
+// offeredChallenges are the challenge types offered in new authorizations,
+// with the defaults filled in. They can be changed with the management
+// interface while the WFE serves requests.
+type offeredChallenges struct {
+	sync.RWMutex
+	offered OfferedChallenges
+}
+
+// withDefaults returns the offered challenges with the default challenge types
+// of the kinds of identifiers without a list.
+func (o OfferedChallenges) withDefaults() OfferedChallenges {
+	if o.DNS == nil {
+		o.DNS = []string{acme.ChallengeHTTP01, acme.ChallengeTLSALPN01, acme.ChallengeDNS01}
+	}
+	if o.Wildcard == nil {
+		o.Wildcard = []string{acme.ChallengeDNS01}
+	}
+	if o.IP == nil {
+		o.IP = []string{acme.ChallengeHTTP01, acme.ChallengeTLSALPN01}
+	}
+	if o.Disabled == nil {
+		o.Disabled = []string{}
+	}
+	return o
+}
+
+// checkOfferedChallenges returns an error if the offered challenges contain
+// unknown challenge types, or challenge types that can't validate IP
+// addresses for IP addresses.
+func (wfe *WebFrontEndImpl) checkOfferedChallenges(o OfferedChallenges) error {
+	for kind, types := range map[string][]string{"dns": o.DNS, "wildcard": o.Wildcard, "ip": o.IP} {
+		for _, t := range types {
+			if t != acme.ChallengeHTTP01 && t != acme.ChallengeTLSALPN01 && t != acme.ChallengeDNS01 {
+				return fmt.Errorf("challenge type %q offered for %s identifiers must be one of %s, %s or %s",
+					t, kind, acme.ChallengeHTTP01, acme.ChallengeTLSALPN01, acme.ChallengeDNS01)
+			}
+			// DNS-01 can't prove control of IP addresses, see RFC 8738 Section 7
+			if kind == "ip" && t == acme.ChallengeDNS01 {
+				return fmt.Errorf("challenge type %q can't be offered for ip identifiers", t)
+			}
+		}
+	}
+	for _, t := range o.Disabled {
+		known := builtinChallengeTypes[t]
+		for _, custom := range wfe.challengeTypes {
+			known = known || custom.Name == t
+		}
+		if !known {
+			return fmt.Errorf("disabled challenge type %q is unknown", t)
+		}
+	}
+	return nil
+}
+
+// SetOfferedChallenges configures the challenge types offered in new
+// authorizations. It must be called before the WFE starts serving requests,
+// and after SetChallengeTypes.
+func (wfe *WebFrontEndImpl) SetOfferedChallenges(offered OfferedChallenges) error {
+	if err := wfe.checkOfferedChallenges(offered); err != nil {
+		return err
+	}
+	wfe.offered = &offeredChallenges{offered: offered.withDefaults()}
+	return nil
+}
+
+// offeredChallengeTypes returns the built-in challenge types offered in an
+// authorization, and the disabled challenge types.
+func (wfe *WebFrontEndImpl) offeredChallengeTypes(authz *core.Authorization) ([]string, map[string]bool) {
+	wfe.offered.RLock()
+	defer wfe.offered.RUnlock()
+	offered := wfe.offered.offered
+
+	types := offered.DNS
+	if authz.Identifier.Type == acme.IdentifierIP {
+		types = offered.IP
+	} else if strings.HasPrefix(authz.Identifier.Value, "*.") || authz.SubdomainAuthAllowed {
+		types = offered.Wildcard
+	}
+	disabled := make(map[string]bool, len(offered.Disabled))
+	for _, t := range offered.Disabled {
+		disabled[t] = true
+	}
+	return types, disabled
+}
+
+// handleOfferedChallenges returns the challenge types offered in new
+// authorizations on GET, and replaces them with the body of a POST request,
+// e.g. {"disabled": ["http-01"]}, which has the fields of the
+// offeredChallenges configuration.
+func (wfe *WebFrontEndImpl) handleOfferedChallenges(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req OfferedChallenges
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+		if err := wfe.checkOfferedChallenges(req); err != nil {
+			wfe.sendError(acme.MalformedProblem(err.Error()), response)
+			return
+		}
+		req = req.withDefaults()
+		wfe.offered.Lock()
+		wfe.offered.offered = req
+		wfe.offered.Unlock()
+		wfe.log.Printf("Offering [%s] challenges for DNS names, [%s] for wildcards, [%s] for IP addresses, disabled: [%s]",
+			strings.Join(req.DNS, ", "), strings.Join(req.Wildcard, ", "), strings.Join(req.IP, ", "),
+			strings.Join(req.Disabled, ", "))
+	}
+
+	wfe.offered.RLock()
+	offered := wfe.offered.offered
+	wfe.offered.RUnlock()
+	err := wfe.writeJSONResponse(response, http.StatusOK, offered)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,28 @@
+package wfe
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// parseOnionCSRResponse returns the DER encoded CSR from the POST body of an
+// onion-csr-01 challenge response. The CSR is verified by the VA.
+func parseOnionCSRResponse(body []byte) ([]byte, *acme.ProblemDetails) {
+	var chalResp struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(body, &chalResp); err != nil {
+		return nil, acme.MalformedProblem("Error unmarshaling body JSON")
+	}
+	if chalResp.CSR == "" {
+		return nil, acme.MalformedProblem(
+			"Challenge response body for " + acme.ChallengeOnionCSR01 + " must contain a csr")
+	}
+	csr, err := base64.RawURLEncoding.DecodeString(chalResp.CSR)
+	if err != nil {
+		return nil, acme.MalformedProblem("Error decoding Base64url-encoded CSR: " + err.Error())
+	}
+	return csr, nil
+}
@@ -0,0 +1,145 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// checkPaused returns the problem of a new order for identifiers its account
+// is paused for, like Boulder's, with the URL of the page unpausing the
+// account.
+func (wfe *WebFrontEndImpl) checkPaused(
+	order *core.Order,
+	request *http.Request) *acme.ProblemDetails {
+	snapshot := order.Snapshot()
+	paused := wfe.db.GetPausedAccount(snapshot.AccountID)
+	if paused == nil {
+		return nil
+	}
+	var pausedIdents []string
+	for _, ident := range snapshot.Identifiers {
+		for _, p := range paused.Identifiers {
+			if ident.Equals(p) {
+				pausedIdents = append(pausedIdents, ident.Value)
+			}
+		}
+	}
+	if len(pausedIdents) == 0 {
+		return nil
+	}
+	unpauseURL := wfe.relativeEndpoint(request, unpausePath+paused.UnpauseToken)
+	return acme.PausedProblem(fmt.Sprintf(
+		"Your account is temporarily prevented from requesting certificates for %s "+
+			"and possibly others. Please visit: %s",
+		strings.Join(pausedIdents, ", "), unpauseURL))
+}
+
+// Unpause serves the self-service page unpausing the account with the token in
+// the URL: a GET request shows the identifiers the account is paused for and a
+// button POSTing to the same URL, which unpauses the account.
+func (wfe *WebFrontEndImpl) Unpause(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	token := strings.TrimPrefix(request.URL.Path, unpausePath)
+	accountID, paused := wfe.db.GetPausedAccountByToken(token)
+	if paused == nil {
+		wfe.sendError(acme.NotFoundProblem("Unknown or expired unpause URL"), response)
+		return
+	}
+
+	var body string
+	if request.Method == http.MethodPost {
+		wfe.db.UnpauseAccount(accountID)
+		wfe.log.Printf("Unpaused account %s", accountID)
+		body = fmt.Sprintf("<p>Account %s was unpaused, it can request certificates again.</p>",
+			html.EscapeString(accountID))
+	} else {
+		var idents strings.Builder
+		for _, ident := range paused.Identifiers {
+			fmt.Fprintf(&idents, "<li>%s</li>", html.EscapeString(ident.Value))
+		}
+		body = fmt.Sprintf("<p>Account %s is paused for these identifiers:</p><ul>%s</ul>"+
+			"<form method=\"post\"><button type=\"submit\">Unpause</button></form>",
+			html.EscapeString(accountID), idents.String())
+	}
+
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(response,
+		"<!DOCTYPE html><html><head><title>Unpause account</title></head><body>%s</body></html>\n", body)
+}
+
+// handlePausedAccounts lists the paused accounts on GET and pauses an account
+// by ID or URL for identifiers on POST, e.g. {"account": "1", "identifiers":
+// [{"type": "dns", "value": "example.com"}]}. With "unpause": true the POST
+// unpauses the account instead.
+func (wfe *WebFrontEndImpl) handlePausedAccounts(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req struct {
+			Account     string
+			Identifiers []acme.Identifier
+			Unpause     bool
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+
+		// Account URLs end with the account ID
+		accountID := req.Account[strings.LastIndex(req.Account, "/")+1:]
+		if wfe.db.GetAccountByID(accountID) == nil {
+			wfe.sendError(acme.AccountDoesNotExistProblem(fmt.Sprintf(
+				"No account %q", req.Account)), response)
+			return
+		}
+
+		if req.Unpause {
+			wfe.db.UnpauseAccount(accountID)
+			wfe.log.Printf("Unpaused account %s with the management interface", accountID)
+		} else {
+			if len(req.Identifiers) == 0 {
+				wfe.sendError(acme.MalformedProblem("No identifiers to pause the account for"), response)
+				return
+			}
+			idents := make([]acme.Identifier, 0, len(req.Identifiers))
+			for _, ident := range req.Identifiers {
+				// Match the identifiers of orders, which are normalized
+				switch ident.Type {
+				case acme.IdentifierDNS:
+					ident.Value = strings.ToLower(ident.Value)
+				case acme.IdentifierIP:
+					ip := net.ParseIP(ident.Value)
+					if ip == nil {
+						wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+							"Invalid IP address %q", ident.Value)), response)
+						return
+					}
+					ident.Value = ip.String()
+				default:
+					wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+						"Unsupported identifier type %q", ident.Type)), response)
+					return
+				}
+				idents = append(idents, acme.Identifier{Type: ident.Type, Value: ident.Value})
+			}
+			paused := wfe.db.PauseAccount(accountID, idents, newToken())
+			wfe.log.Printf("Paused account %s for %d identifiers", accountID, len(paused.Identifiers))
+		}
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.db.GetPausedAccounts())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,116 @@
+package wfe
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/random"
+)
+
+// Perturbations of the PEM certificate chains served at certificate URLs
+const (
+	// Serve the intermediates in reverse order, which requires chains of more
+	// than one intermediate
+	PerturbReorderIntermediates = "reorderIntermediates"
+	// Add blank lines before, between and after the certificates
+	PerturbBlankLines = "blankLines"
+	// Add the subject and issuer of each certificate as explanatory text
+	// before it, like "openssl x509 -subject -issuer"
+	PerturbComments = "comments"
+	// Add spaces and tabs to the end of every line
+	PerturbTrailingWhitespace = "trailingWhitespace"
+	// Serve the leaf certificate twice
+	PerturbDuplicateLeaf = "duplicateLeaf"
+)
+
+var chainPerturbations = []string{
+	PerturbReorderIntermediates,
+	PerturbBlankLines,
+	PerturbComments,
+	PerturbTrailingWhitespace,
+	PerturbDuplicateLeaf,
+}
+
+// ChainPerturbation configures perturbations of the served PEM certificate
+// chains, like the output real CAs have shipped, to test the robustness of
+// the PEM parsers of clients.
+type ChainPerturbation struct {
+	// Perturbations applied to the chains, e.g. "blankLines"
+	Perturbations []string
+	// Apply a random subset of the perturbations to each response instead of
+	// all of them
+	Random bool
+}
+
+// SetChainPerturbation configures the perturbations of the served PEM
+// certificate chains. It must be called before the WFE starts serving
+// requests.
+func (wfe *WebFrontEndImpl) SetChainPerturbation(perturbation ChainPerturbation) error {
+	for _, p := range perturbation.Perturbations {
+		known := false
+		for _, name := range chainPerturbations {
+			known = known || p == name
+		}
+		if !known {
+			return fmt.Errorf("unknown chain perturbation %q", p)
+		}
+	}
+	if len(perturbation.Perturbations) > 0 {
+		wfe.log.Printf("Perturbing served certificate chains with %v (random %t)",
+			perturbation.Perturbations, perturbation.Random)
+	}
+	wfe.chainPerturbation = perturbation
+	return nil
+}
+
+// pemChain returns the PEM certificate chain of a certificate with the
+// intermediates of the issuer chain with the given index, with the configured
+// perturbations applied.
+func (wfe *WebFrontEndImpl) pemChain(cert *core.Certificate, no int) []byte {
+	perturb := make(map[string]bool)
+	for _, p := range wfe.chainPerturbation.Perturbations {
+		perturb[p] = !wfe.chainPerturbation.Random || random.Intn(2) == 0
+	}
+	if len(perturb) == 0 {
+		return cert.Chain(no)
+	}
+
+	var intermediates []*core.Certificate
+	if 0 <= no && no < len(cert.IssuerChains) {
+		intermediates = append(intermediates, cert.IssuerChains[no]...)
+	}
+	if perturb[PerturbReorderIntermediates] {
+		reorder := intermediates
+		if n := len(reorder); n > 0 && bytes.Equal(reorder[n-1].Cert.RawSubject, reorder[n-1].Cert.RawIssuer) {
+			// A served root stays last
+			reorder = reorder[:n-1]
+		}
+		for i, j := 0, len(reorder)-1; i < j; i, j = i+1, j-1 {
+			reorder[i], reorder[j] = reorder[j], reorder[i]
+		}
+	}
+	chain := []*core.Certificate{cert}
+	if perturb[PerturbDuplicateLeaf] {
+		chain = append(chain, cert)
+	}
+	chain = append(chain, intermediates...)
+
+	var buf bytes.Buffer
+	for _, c := range chain {
+		if perturb[PerturbBlankLines] {
+			buf.WriteString("\n\n")
+		}
+		if perturb[PerturbComments] {
+			fmt.Fprintf(&buf, "subject=%s\nissuer=%s\n", c.Cert.Subject, c.Cert.Issuer)
+		}
+		buf.Write(c.PEM())
+	}
+	if perturb[PerturbBlankLines] {
+		buf.WriteString("\n\n")
+	}
+	if !perturb[PerturbTrailingWhitespace] {
+		return buf.Bytes()
+	}
+	return bytes.ReplaceAll(buf.Bytes(), []byte("\n"), []byte(" \t \n"))
+}
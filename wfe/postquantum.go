@@ -0,0 +1,151 @@
+package wfe
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Keys of the unprotected header of JWS parsed by parseMLDSAJWS. ACME never
+// uses the unprotected header and parseJWS rejects JWS that have one, so it
+// carries what verifyMLDSAJWS needs: go-jose can't verify ML-DSA signatures.
+const (
+	mldsaSigningInputHeader = jose.HeaderKey("pebble-signing-input")
+	mldsaPayloadHeader      = jose.HeaderKey("pebble-payload")
+)
+
+// PostQuantumConfig enables experimental post-quantum support. It requires
+// Pebble to be built with Go 1.27 or later.
+type PostQuantumConfig struct {
+	// Accept ML-DSA account keys signing JWS with the ML-DSA-44, ML-DSA-65
+	// and ML-DSA-87 algorithms of draft-ietf-cose-dilithium
+	AccountKeys bool
+	// Issue certificates for ML-DSA keys of finalize CSRs
+	CertificateKeys bool
+}
+
+// SetPostQuantum configures the experimental post-quantum support. It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetPostQuantum(config PostQuantumConfig) error {
+	if (config.AccountKeys || config.CertificateKeys) && !core.MLDSASupported {
+		return errors.New("ML-DSA keys require Pebble built with Go 1.27 or later")
+	}
+	wfe.postQuantum = config
+	if config.AccountKeys {
+		wfe.log.Printf("Accepting ML-DSA account keys (experimental)")
+	}
+	if config.CertificateKeys {
+		wfe.log.Printf("Issuing certificates for ML-DSA keys (experimental)")
+	}
+	return nil
+}
+
+// parseMLDSAJWS parses a flattened JSON serialized JWS signed with an ML-DSA
+// algorithm, which go-jose doesn't support. It returns nil if the JWS isn't
+// signed with an ML-DSA algorithm.
+func parseMLDSAJWS(body string) (*jose.JSONWebSignature, error) {
+	var raw struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal([]byte(body), &raw); err != nil {
+		return nil, nil
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(raw.Protected)
+	if err != nil {
+		return nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(protected, &fields); err != nil {
+		return nil, nil
+	}
+	var alg string
+	if err := json.Unmarshal(fields["alg"], &alg); err != nil || !isMLDSAAlgorithm(alg) {
+		return nil, nil
+	}
+
+	header := jose.Header{
+		Algorithm:    alg,
+		ExtraHeaders: make(map[jose.HeaderKey]interface{}),
+	}
+	for name, value := range fields {
+		var err error
+		switch name {
+		case "alg":
+		case "kid":
+			err = json.Unmarshal(value, &header.KeyID)
+		case "nonce":
+			err = json.Unmarshal(value, &header.Nonce)
+		case "jwk":
+			header.JSONWebKey, err = core.UnmarshalJWK(value)
+		default:
+			var v interface{}
+			err = json.Unmarshal(value, &v)
+			header.ExtraHeaders[jose.HeaderKey(name)] = v
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Parse error reading JWS header %q", name)
+		}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(raw.Payload)
+	if err != nil {
+		return nil, errors.New("Parse error reading JWS payload")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(raw.Signature)
+	if err != nil {
+		return nil, errors.New("Parse error reading JWS signature")
+	}
+
+	return &jose.JSONWebSignature{
+		Signatures: []jose.Signature{{
+			Header:    header,
+			Protected: header,
+			Unprotected: jose.Header{
+				ExtraHeaders: map[jose.HeaderKey]interface{}{
+					mldsaSigningInputHeader: raw.Protected + "." + raw.Payload,
+					mldsaPayloadHeader:      payload,
+				},
+			},
+			Signature: signature,
+		}},
+	}, nil
+}
+
+// verifyMLDSAJWS verifies a JWS parsed by parseMLDSAJWS and returns its
+// payload.
+func verifyMLDSAJWS(key *jose.JSONWebKey, parsedJWS *jose.JSONWebSignature) ([]byte, error) {
+	sig := parsedJWS.Signatures[0]
+	signingInput, ok := sig.Unprotected.ExtraHeaders[mldsaSigningInputHeader].(string)
+	if !ok {
+		return nil, errors.New("unsupported signature algorithm for ML-DSA key")
+	}
+	if err := core.VerifyMLDSA(key.Key, []byte(signingInput), sig.Signature); err != nil {
+		return nil, errors.New("ML-DSA signature verification failed")
+	}
+	payload, _ := sig.Unprotected.ExtraHeaders[mldsaPayloadHeader].([]byte)
+	return payload, nil
+}
+
+// isMLDSAAlgorithm returns whether a JWS algorithm is an ML-DSA algorithm,
+// including unsupported parameter sets and composites, which the key policy
+// rejects.
+func isMLDSAAlgorithm(alg string) bool {
+	return strings.HasPrefix(alg, "ML-DSA-")
+}
+
+// checkCSRKey returns a badCSR problem if certificates can't be issued for
+// the key of a finalize CSR.
+func (wfe *WebFrontEndImpl) checkCSRKey(key interface{}) *acme.ProblemDetails {
+	if core.MLDSAAlgorithm(key) != "" && !wfe.postQuantum.CertificateKeys {
+		return acme.BadCSRProblem("ML-DSA CSR keys are not accepted")
+	}
+	return nil
+}
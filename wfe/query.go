@@ -0,0 +1,238 @@
+package wfe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+)
+
+// certificateSummary describes an issued certificate to test harnesses
+// asserting on the state of Pebble.
+type certificateSummary struct {
+	Serial      string    `json:"serial"`
+	Status      string    `json:"status"`
+	AccountID   string    `json:"accountID,omitempty"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// certificateDetails describes an issued certificate with its key and the
+// chains it is served with.
+type certificateDetails struct {
+	certificateSummary
+	Issuer             string               `json:"issuer"`
+	SignatureAlgorithm string               `json:"signatureAlgorithm"`
+	SubjectKeyID       string               `json:"subjectKeyID"`
+	Key                keyDetails           `json:"key"`
+	Chains             [][]chainCertificate `json:"chains"`
+	RevokedAt          *time.Time           `json:"revokedAt,omitempty"`
+	Reason             *uint                `json:"reason,omitempty"`
+	Certificate        string               `json:"certificate"`
+}
+
+// keyDetails describes the public key of a certificate.
+type keyDetails struct {
+	Algorithm string `json:"algorithm"`
+	// Size of RSA keys in bits
+	Size int `json:"size,omitempty"`
+	// Curve of ECDSA keys, e.g. "P-256"
+	Curve string `json:"curve,omitempty"`
+	// Hex encoded SHA-256 digest of the DER encoded public key
+	SPKIDigest string `json:"spkiDigest"`
+}
+
+// chainCertificate describes an issuer of a chain a certificate is served
+// with.
+type chainCertificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+// orderSummary describes an order to test harnesses asserting on the state of
+// Pebble.
+type orderSummary struct {
+	ID                string               `json:"id"`
+	Status            string               `json:"status"`
+	Identifiers       []acme.Identifier    `json:"identifiers"`
+	Profile           string               `json:"profile,omitempty"`
+	Expires           string               `json:"expires"`
+	CertificateSerial string               `json:"certificateSerial,omitempty"`
+	Error             *acme.ProblemDetails `json:"error,omitempty"`
+}
+
+// summarizeOrder returns the summary of an order.
+func summarizeOrder(order *core.Order) orderSummary {
+	snapshot := order.Snapshot()
+	summary := orderSummary{
+		ID:          snapshot.ID,
+		Status:      snapshot.Status,
+		Identifiers: snapshot.Identifiers,
+		Profile:     snapshot.Profile,
+		Expires:     snapshot.Expires,
+		Error:       snapshot.Error,
+	}
+	if snapshot.CertificateObject != nil {
+		summary.CertificateSerial = snapshot.CertificateObject.Cert.SerialNumber.Text(16)
+	}
+	return summary
+}
+
+// summarizeCertificate returns the summary of a certificate.
+func (wfe *WebFrontEndImpl) summarizeCertificate(cert *core.Certificate) certificateSummary {
+	summary := certificateSummary{
+		Serial:    cert.Cert.SerialNumber.Text(16),
+		Status:    acme.StatusValid,
+		AccountID: cert.AccountID,
+		DNSNames:  cert.Cert.DNSNames,
+		NotBefore: cert.Cert.NotBefore.UTC(),
+		NotAfter:  cert.Cert.NotAfter.UTC(),
+	}
+	for _, ip := range cert.Cert.IPAddresses {
+		summary.IPAddresses = append(summary.IPAddresses, ip.String())
+	}
+	if wfe.db.GetRevokedCertificateBySerial(cert.Cert.SerialNumber) != nil {
+		summary.Status = acme.StatusRevoked
+	}
+	return summary
+}
+
+// describeKey returns the details of the public key of a certificate.
+func describeKey(cert *x509.Certificate) keyDetails {
+	details := keyDetails{Algorithm: cert.PublicKeyAlgorithm.String()}
+	if alg := core.MLDSAAlgorithm(cert.PublicKey); alg != "" {
+		details.Algorithm = alg
+	}
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		details.Size = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		details.Curve = key.Curve.Params().Name
+	}
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	details.SPKIDigest = hex.EncodeToString(digest[:])
+	return details
+}
+
+// handleCertsByName lists the certificates, revoked or not, issued for the
+// DNS name or IP address of the path, e.g. /certs-by-name/example.com.
+func (wfe *WebFrontEndImpl) handleCertsByName(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	name := strings.TrimPrefix(request.URL.Path, certsByNamePath)
+	if name == "" {
+		wfe.sendError(acme.MalformedProblem("A DNS name or IP address is required"), response)
+		return
+	}
+
+	summaries := []certificateSummary{}
+	for _, cert := range wfe.db.FindCertificatesByName(name) {
+		summaries = append(summaries, wfe.summarizeCertificate(cert))
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, summaries)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleCertDetailsBySerial describes the certificate with the hex serial of
+// the path, with its key and the chains it is served with.
+func (wfe *WebFrontEndImpl) handleCertDetailsBySerial(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	serialStr := strings.TrimPrefix(request.URL.Path, certDetailsBySerial)
+	serial := big.NewInt(0)
+	if _, ok := serial.SetString(serialStr, 16); !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cert := wfe.db.GetCertificateBySerial(serial)
+	revoked := wfe.db.GetRevokedCertificateBySerial(serial)
+	if revoked != nil {
+		cert = revoked.Certificate
+	}
+	if cert == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	details := certificateDetails{
+		certificateSummary: wfe.summarizeCertificate(cert),
+		Issuer:             cert.Cert.Issuer.String(),
+		SignatureAlgorithm: cert.Cert.SignatureAlgorithm.String(),
+		SubjectKeyID:       hex.EncodeToString(cert.Cert.SubjectKeyId),
+		Key:                describeKey(cert.Cert),
+		Chains:             [][]chainCertificate{},
+		Certificate:        string(cert.PEM()),
+	}
+	for _, issuers := range cert.IssuerChains {
+		chain := []chainCertificate{}
+		for _, issuer := range issuers {
+			chain = append(chain, chainCertificate{
+				Subject:  issuer.Cert.Subject.String(),
+				Issuer:   issuer.Cert.Issuer.String(),
+				Serial:   issuer.Cert.SerialNumber.Text(16),
+				NotAfter: issuer.Cert.NotAfter.UTC(),
+			})
+		}
+		details.Chains = append(details.Chains, chain)
+	}
+	if revoked != nil {
+		revokedAt := revoked.RevokedAt.UTC()
+		details.RevokedAt = &revokedAt
+		details.Reason = revoked.Reason
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, details)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleOrdersByAccount lists the orders of the account with the ID of the
+// path, e.g. /orders-by-account/1, optionally only those with the status and
+// identifier of the "status" and "identifier" query parameters.
+func (wfe *WebFrontEndImpl) handleOrdersByAccount(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	accountID := strings.TrimPrefix(request.URL.Path, ordersByAccountPath)
+	if wfe.db.GetAccountByID(accountID) == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := request.URL.Query()
+	filter := db.OrderFilter{
+		Status:     query.Get("status"),
+		Identifier: query.Get("identifier"),
+	}
+	summaries := []orderSummary{}
+	for _, order := range wfe.db.FindOrders(accountID, filter) {
+		summaries = append(summaries, summarizeOrder(order))
+	}
+	err := wfe.writeJSONResponse(response, http.StatusOK, summaries)
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,289 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// RateLimit allows Count requests per Period seconds. A zero Count disables
+// the limit.
+type RateLimit struct {
+	Count  int
+	Period int
+}
+
+// RateLimits configures the simulated rate limits of the WFE.
+type RateLimits struct {
+	// NewOrdersPerAccount limits the newOrder requests of each account.
+	NewOrdersPerAccount RateLimit
+	// CertificatesPerDomain limits the finalized orders including names of
+	// each base domain (the last two labels of a name).
+	CertificatesPerDomain RateLimit
+	// NewAccountsPerIP limits the accounts created from each IP address.
+	NewAccountsPerIP RateLimit
+	// PendingOrdersPerAccount caps the pending orders of each account. Zero
+	// disables the cap.
+	PendingOrdersPerAccount int
+	// PendingAuthorizationsPerAccount caps the pending authorizations of each
+	// account. Zero disables the cap.
+	PendingAuthorizationsPerAccount int
+}
+
+// rateLimiter keeps a sliding window of the requests counted against each
+// bucket of a set of rate limits.
+type rateLimiter struct {
+	sync.Mutex
+	clk     clock.Clock
+	buckets map[string][]time.Time
+}
+
+func newRateLimiter(clk clock.Clock) *rateLimiter {
+	return &rateLimiter{
+		clk:     clk,
+		buckets: make(map[string][]time.Time),
+	}
+}
+
+// SetRateLimits configures the simulated rate limits. It must be called
+// before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetRateLimits(limits RateLimits) {
+	wfe.rateLimits = limits
+}
+
+// allow counts a request against the buckets of a rate limit identified by
+// name and keys. The request is only counted if none of the buckets is full,
+// otherwise the time until the request may be retried is returned.
+func (rl *rateLimiter) allow(name string, limit RateLimit, keys ...string) (bool, time.Duration) {
+	if limit.Count <= 0 {
+		return true, 0
+	}
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := rl.clk.Now()
+	period := time.Duration(limit.Period) * time.Second
+	var retryAfter time.Duration
+	for _, key := range keys {
+		bucket := name + ":" + key
+		// Forget requests that left the window
+		requests := rl.buckets[bucket]
+		for len(requests) > 0 && !requests[0].After(now.Add(-period)) {
+			requests = requests[1:]
+		}
+		rl.buckets[bucket] = requests
+		if len(requests) >= limit.Count {
+			if wait := requests[0].Add(period).Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, key := range keys {
+		bucket := name + ":" + key
+		rl.buckets[bucket] = append(rl.buckets[bucket], now)
+	}
+	return true, 0
+}
+
+// reset empties all buckets.
+func (rl *rateLimiter) reset() {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.buckets = make(map[string][]time.Time)
+}
+
+// sendRateLimited sends a rateLimited problem with a Retry-After header.
+func (wfe *WebFrontEndImpl) sendRateLimited(
+	response http.ResponseWriter,
+	retryAfter time.Duration,
+	detail string) {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	response.Header().Set("Retry-After", strconv.Itoa(seconds))
+	wfe.sendError(acme.RateLimitedProblem(fmt.Sprintf(
+		"%s, retry after %d seconds", detail, seconds)), response)
+}
+
+// checkNewAccountRateLimit counts a new account against the limit of the
+// requesting IP address. A problem is sent and false returned if the limit
+// is exceeded.
+func (wfe *WebFrontEndImpl) checkNewAccountRateLimit(response http.ResponseWriter, request *http.Request) bool {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+	limit := wfe.rateLimits.NewAccountsPerIP
+	if ok, retryAfter := wfe.rateLimiter.allow("newAccountsPerIP", limit, ip); !ok {
+		wfe.sendRateLimited(response, retryAfter, fmt.Sprintf(
+			"Too many new accounts (%d per %d seconds) from IP %s", limit.Count, limit.Period, ip))
+		return false
+	}
+	return true
+}
+
+// checkNewOrderRateLimit counts a new order against the limit of the account.
+// A problem is sent and false returned if the limit is exceeded.
+func (wfe *WebFrontEndImpl) checkNewOrderRateLimit(response http.ResponseWriter, accountID string) bool {
+	limit := wfe.rateLimits.NewOrdersPerAccount
+	if ok, retryAfter := wfe.rateLimiter.allow("newOrdersPerAccount", limit, accountID); !ok {
+		wfe.sendRateLimited(response, retryAfter, fmt.Sprintf(
+			"Too many new orders (%d per %d seconds) for account %s", limit.Count, limit.Period, accountID))
+		return false
+	}
+	return true
+}
+
+// checkPendingLimits checks that a new order keeps its account within the
+// caps on pending orders and authorizations. A problem is sent and false
+// returned if the order would exceed a cap, with a Retry-After header giving
+// the time until the first pending order or authorization expires.
+func (wfe *WebFrontEndImpl) checkPendingLimits(response http.ResponseWriter, order *core.Order) bool {
+	maxOrders := wfe.rateLimits.PendingOrdersPerAccount
+	maxAuthzs := wfe.rateLimits.PendingAuthorizationsPerAccount
+	if maxOrders <= 0 && maxAuthzs <= 0 {
+		return true
+	}
+
+	snapshot := order.Snapshot()
+	now := wfe.clk.Now()
+	var pendingOrders, pendingAuthzs []time.Time
+	seen := make(map[*core.Authorization]bool)
+	for _, existing := range wfe.db.GetOrdersByAccountID(snapshot.AccountID) {
+		existing := existing.Snapshot()
+		if existing.Status == acme.StatusPending && existing.ExpiresDate.After(now) {
+			pendingOrders = append(pendingOrders, existing.ExpiresDate)
+		}
+		for _, authz := range existing.AuthorizationObjects {
+			if seen[authz] {
+				continue
+			}
+			seen[authz] = true
+			authz := authz.Snapshot()
+			if authz.Status == acme.StatusPending && authz.ExpiresDate.After(now) {
+				pendingAuthzs = append(pendingAuthzs, authz.ExpiresDate)
+			}
+		}
+	}
+
+	if maxOrders > 0 && len(pendingOrders) >= maxOrders {
+		wfe.sendRateLimited(response, firstExpiry(pendingOrders).Sub(now), fmt.Sprintf(
+			"Too many pending orders (%d) for account %s", maxOrders, snapshot.AccountID))
+		return false
+	}
+	if maxAuthzs <= 0 {
+		return true
+	}
+	needed := wfe.newAuthorizationsNeeded(snapshot)
+	if needed > maxAuthzs {
+		wfe.sendError(acme.RateLimitedProblem(fmt.Sprintf(
+			"Order needs %d new pending authorizations, more than the cap (%d) for account %s",
+			needed, maxAuthzs, snapshot.AccountID)), response)
+		return false
+	}
+	if needed > 0 && len(pendingAuthzs)+needed > maxAuthzs {
+		wfe.sendRateLimited(response, firstExpiry(pendingAuthzs).Sub(now), fmt.Sprintf(
+			"Too many pending authorizations (%d) for account %s", maxAuthzs, snapshot.AccountID))
+		return false
+	}
+	return true
+}
+
+// newAuthorizationsNeeded returns the number of pending authorizations
+// makeAuthorizations would create for an order, one for each identifier, or
+// ancestor domain of subdomain identifiers, without a valid authorization of
+// the account.
+func (wfe *WebFrontEndImpl) newAuthorizationsNeeded(order *core.Order) int {
+	needed := 0
+	ancestors := make(map[string]bool)
+	for _, name := range order.Identifiers {
+		ident := acme.Identifier{Type: name.Type, Value: name.Value}
+		var authz *core.Authorization
+		if name.AncestorDomain != "" {
+			if ancestors[name.AncestorDomain] {
+				continue
+			}
+			ancestors[name.AncestorDomain] = true
+			ident.Value = name.AncestorDomain
+			authz = wfe.db.FindValidSubdomainAuthorization(order.AccountID, ident)
+		} else {
+			authz = wfe.db.FindValidAuthorization(order.AccountID, ident)
+			if authz == nil && ident.Type == acme.IdentifierDNS {
+				authz = wfe.findAncestorAuthorization(order.AccountID, ident.Value)
+			}
+		}
+		if authz == nil {
+			needed++
+		}
+	}
+	return needed
+}
+
+// firstExpiry returns the earliest of the expiry times.
+func firstExpiry(expiries []time.Time) time.Time {
+	first := expiries[0]
+	for _, expiry := range expiries[1:] {
+		if expiry.Before(first) {
+			first = expiry
+		}
+	}
+	return first
+}
+
+// checkCertificatesRateLimit counts a certificate against the limits of the
+// base domains of its names. A problem is sent and false returned if one of
+// the limits is exceeded.
+func (wfe *WebFrontEndImpl) checkCertificatesRateLimit(response http.ResponseWriter, names []string) bool {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, name := range names {
+		domain := baseDomain(name)
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	limit := wfe.rateLimits.CertificatesPerDomain
+	if ok, retryAfter := wfe.rateLimiter.allow("certificatesPerDomain", limit, domains...); !ok {
+		wfe.sendRateLimited(response, retryAfter, fmt.Sprintf(
+			"Too many certificates (%d per %d seconds) for the domains %s",
+			limit.Count, limit.Period, strings.Join(domains, ", ")))
+		return false
+	}
+	return true
+}
+
+// baseDomain approximates the registered domain of a DNS name with its last
+// two labels.
+func baseDomain(name string) string {
+	labels := strings.Split(strings.TrimPrefix(strings.ToLower(name), "*."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// handleResetRateLimits empties all rate limit buckets.
+func (wfe *WebFrontEndImpl) handleResetRateLimits(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		wfe.sendError(acme.MethodNotAllowed(), response)
+		return
+	}
+	wfe.rateLimiter.reset()
+	wfe.log.Printf("Reset all rate limit buckets")
+	response.WriteHeader(http.StatusOK)
+}
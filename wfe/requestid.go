@@ -0,0 +1,163 @@
+package wfe
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/trace"
+)
+
+const (
+	// requestIDHeader carries the ID of an ACME request in responses. A valid
+	// ID sent by the client in the request header is used instead of a new
+	// one.
+	requestIDHeader = "X-Request-Id"
+	maxRequestIDLen = 128
+)
+
+// accessLog is a log of all ACME requests with one JSON object per line.
+type accessLog struct {
+	sync.Mutex
+	out io.Writer
+}
+
+// accessRecord is a line of the access log.
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestID"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Method     string    `json:"method"`
+	Endpoint   string    `json:"endpoint"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Status     int       `json:"status"`
+	Problem    string    `json:"problem,omitempty"`
+	DurationMS float64   `json:"durationMS"`
+}
+
+// SetAccessLog writes a structured access log line for every ACME request to
+// the file at the given path, or to stdout if the path is "-". It must be
+// called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetAccessLog(path string) error {
+	switch path {
+	case "":
+		return nil
+	case "-":
+		wfe.accessLog = &accessLog{out: os.Stdout}
+	default:
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("opening access log: %s", err)
+		}
+		wfe.accessLog = &accessLog{out: file}
+	}
+	wfe.log.Printf("Writing access log of ACME requests to %s", path)
+	return nil
+}
+
+// startRequest assigns an ID to an ACME request, which is sent in the
+// X-Request-Id response header and carried by the returned request's context.
+func startRequest(response http.ResponseWriter, request *http.Request) *http.Request {
+	id := request.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	response.Header().Set(requestIDHeader, id)
+	return request.WithContext(core.WithRequestID(request.Context(), id))
+}
+
+// newRequestID returns a random request ID. It doesn't use the random
+// package, so that request IDs don't change the randomness of deterministic
+// mode.
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID returns whether a request ID sent by a client can be used in
+// log lines and problem documents as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// detachRequest returns a context carrying the span and the request ID of an
+// ACME request's context, for work that continues after the request was
+// handled.
+func detachRequest(ctx context.Context) context.Context {
+	return core.WithRequestID(trace.Detach(ctx), core.RequestID(ctx))
+}
+
+// startAccessLog starts the access log line of an ACME request to the
+// endpoint with the given pattern. It returns the response writer the handler
+// must use, and a function writing the line once the request was handled.
+func (wfe *WebFrontEndImpl) startAccessLog(
+	pattern string,
+	response http.ResponseWriter,
+	request *http.Request) (http.ResponseWriter, func()) {
+	if wfe.accessLog == nil {
+		return response, func() {}
+	}
+
+	start := time.Now()
+	writer := &recordingResponseWriter{ResponseWriter: response}
+	return writer, func() {
+		record := accessRecord{
+			Time:       start.UTC(),
+			RequestID:  core.RequestID(request.Context()),
+			RemoteAddr: request.RemoteAddr,
+			Method:     request.Method,
+			Endpoint:   pattern,
+			Path:       request.RequestURI,
+			Proto:      request.Proto,
+			UserAgent:  request.UserAgent(),
+			Status:     writer.status,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		if len(writer.problem) > 0 {
+			var prob acme.ProblemDetails
+			if err := json.Unmarshal(writer.problem, &prob); err == nil {
+				record.Problem = prob.Type
+			}
+		}
+		wfe.writeAccessRecord(record)
+	}
+}
+
+func (wfe *WebFrontEndImpl) writeAccessRecord(record accessRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		wfe.log.Printf("Error marshaling access log record: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	wfe.accessLog.Lock()
+	defer wfe.accessLog.Unlock()
+	if _, err := wfe.accessLog.out.Write(line); err != nil {
+		wfe.log.Printf("Error writing access log record: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+package wfe
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/random"
+)
+
+// RetryAfterConfig configures the Retry-After headers of the responses for
+// the orders and authorizations clients poll. See RFC 8555 Section 7.4. The
+// zero value sends a header only for processing orders, with the minimum
+// issuance delay.
+type RetryAfterConfig struct {
+	// Seconds of the Retry-After header of pending orders and pending
+	// authorizations. Zero means no header.
+	PendingOrder int
+	PendingAuthz int
+	// Seconds of the Retry-After header of processing orders. Zero means
+	// the minimum issuance delay, at least one second.
+	ProcessingOrder int
+	// Largest random number of seconds added to each Retry-After header
+	Jitter int
+	// Percentage of the responses that omit the Retry-After header they
+	// would otherwise have
+	OmitPercent int
+	// Send Retry-After headers as HTTP dates instead of seconds
+	HTTPDate bool
+}
+
+// SetRetryAfter configures the Retry-After headers of polled orders and
+// authorizations. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetRetryAfter(config RetryAfterConfig) error {
+	if config.PendingOrder < 0 || config.PendingAuthz < 0 || config.ProcessingOrder < 0 {
+		return errors.New("Retry-After seconds must not be negative")
+	}
+	if config.Jitter < 0 {
+		return errors.New("Retry-After jitter must not be negative")
+	}
+	if config.OmitPercent < 0 || config.OmitPercent > 100 {
+		return errors.New("Retry-After omit percentage must be between 0 and 100")
+	}
+	wfe.retryAfter = config
+	return nil
+}
+
+// setOrderRetryAfter sets a Retry-After header on responses for orders that
+// are pending or processing, suggesting clients when to poll again. By
+// default only processing orders get one, once the minimum issuance delay
+// has passed.
+func (wfe *WebFrontEndImpl) setOrderRetryAfter(response http.ResponseWriter, status string) {
+	switch status {
+	case acme.StatusPending:
+		wfe.setRetryAfter(response, wfe.retryAfter.PendingOrder)
+	case acme.StatusProcessing:
+		retryAfter := wfe.retryAfter.ProcessingOrder
+		if retryAfter == 0 {
+			retryAfter = wfe.ca.GetIssuanceDelay().Min
+		}
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		wfe.setRetryAfter(response, retryAfter)
+	}
+}
+
+// setAuthzRetryAfter sets a Retry-After header on responses for pending
+// authorizations if configured.
+func (wfe *WebFrontEndImpl) setAuthzRetryAfter(response http.ResponseWriter, status string) {
+	if status == acme.StatusPending {
+		wfe.setRetryAfter(response, wfe.retryAfter.PendingAuthz)
+	}
+}
+
+// setRetryAfter sets a Retry-After header of the given seconds plus jitter,
+// unless the seconds are zero or the header is randomly omitted.
+func (wfe *WebFrontEndImpl) setRetryAfter(response http.ResponseWriter, seconds int) {
+	if seconds == 0 || random.Intn(100) < wfe.retryAfter.OmitPercent {
+		return
+	}
+	if wfe.retryAfter.Jitter > 0 {
+		seconds += random.Intn(wfe.retryAfter.Jitter + 1)
+	}
+	if wfe.retryAfter.HTTPDate {
+		retryAt := wfe.clk.Now().Add(time.Duration(seconds) * time.Second)
+		response.Header().Set("Retry-After", retryAt.UTC().Format(http.TimeFormat))
+		return
+	}
+	response.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,150 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/webhook"
+)
+
+// defaultRevocationReasons are the reason codes accepted in revocation
+// requests unless configured otherwise, those accepted by Let's Encrypt:
+// unspecified, keyCompromise, affiliationChanged, superseded and
+// cessationOfOperation.
+var defaultRevocationReasons = []uint{0, 1, 3, 4, 5}
+
+// RevocationConfig configures the reason codes ACME clients may revoke
+// certificates with.
+type RevocationConfig struct {
+	// Reason codes accepted in revocation requests, see RFC 5280 Section
+	// 5.3.1. Defaults to 0, 1, 3, 4 and 5.
+	Reasons []uint
+	// Accept the keyCompromise reason in revocation requests signed by an
+	// account key. By default it must be requested with the certificate key,
+	// which proves the key was compromised.
+	AllowAccountKeyCompromise bool
+}
+
+// revocationPolicy is the RevocationConfig with the defaults applied.
+type revocationPolicy struct {
+	reasons                   map[uint]bool
+	allowAccountKeyCompromise bool
+}
+
+func newRevocationPolicy(config RevocationConfig) *revocationPolicy {
+	reasons := config.Reasons
+	if len(reasons) == 0 {
+		reasons = defaultRevocationReasons
+	}
+	p := &revocationPolicy{
+		reasons:                   make(map[uint]bool, len(reasons)),
+		allowAccountKeyCompromise: config.AllowAccountKeyCompromise,
+	}
+	for _, r := range reasons {
+		p.reasons[r] = true
+	}
+	return p
+}
+
+// SetRevocation configures the reason codes accepted in revocation requests.
+// It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetRevocation(config RevocationConfig) error {
+	for _, r := range config.Reasons {
+		if !validRevocationReason(r) {
+			return fmt.Errorf("invalid revocation reason %d", r)
+		}
+	}
+	wfe.revocation = newRevocationPolicy(config)
+	return nil
+}
+
+// validRevocationReason returns whether a reason code is defined by RFC 5280.
+func validRevocationReason(r uint) bool {
+	return r != unusedRevocationReason && r <= aACompromiseRevocationReason
+}
+
+// checkReason returns a badRevocationReason problem if the reason code of a
+// revocation request isn't accepted. certKey is whether the request is signed
+// by the certificate key rather than an account key.
+func (p *revocationPolicy) checkReason(reason *uint, certKey bool) *acme.ProblemDetails {
+	if reason == nil {
+		return nil
+	}
+	r := *reason
+	if !validRevocationReason(r) {
+		return acme.BadRevocationReasonProblem(fmt.Sprintf("Invalid revocation reason: %d", r))
+	}
+	if !p.reasons[r] {
+		return acme.BadRevocationReasonProblem(fmt.Sprintf("Revocation reason %d is not accepted", r))
+	}
+	if r == keyCompromiseRevocationReason && !certKey && !p.allowAccountKeyCompromise {
+		return acme.BadRevocationReasonProblem(
+			"Revocation reason keyCompromise must be requested with the certificate key")
+	}
+	return nil
+}
+
+// revokeCertificate revokes a certificate, blocks its key if it is revoked for
+// key compromise and notifies the webhooks.
+func (wfe *WebFrontEndImpl) revokeCertificate(cert *core.Certificate, reason *uint) {
+	wfe.db.RevokeCertificate(&core.RevokedCertificate{
+		Certificate: cert,
+		RevokedAt:   wfe.clk.Now(),
+		Reason:      reason,
+	})
+	// Like Boulder, block the key of a certificate revoked for key compromise
+	if reason != nil && *reason == keyCompromiseRevocationReason {
+		if err := wfe.db.BlockKey(cert.Cert.PublicKey); err != nil {
+			wfe.log.Printf("Error blocking the compromised key of certificate %s: %s", cert.ID, err)
+		} else {
+			wfe.log.Printf("Blocked the compromised key of certificate %s", cert.ID)
+		}
+	}
+	data := webhook.NewCertificateData(cert, "")
+	data.Reason = reason
+	wfe.webhooks.Notify(webhook.CertificateRevoked, data)
+}
+
+// handleRevokeCert revokes the certificate with the serial number in the body
+// of a POST request, bypassing the revocation checks of ACME requests, e.g.
+// {"serial": "2fe11459f6b06186", "reason": 4}.
+func (wfe *WebFrontEndImpl) handleRevokeCert(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Serial string
+		Reason *uint
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	serial, ok := new(big.Int).SetString(req.Serial, 16)
+	if !ok {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid serial number %q", req.Serial)), response)
+		return
+	}
+	if req.Reason != nil && !validRevocationReason(*req.Reason) {
+		wfe.sendError(acme.BadRevocationReasonProblem(
+			fmt.Sprintf("Invalid revocation reason: %d", *req.Reason)), response)
+		return
+	}
+	cert := wfe.db.GetCertificateBySerial(serial)
+	if cert == nil {
+		if wfe.db.GetRevokedCertificateBySerial(serial) != nil {
+			wfe.sendError(acme.AlreadyRevokedProblem("Certificate has already been revoked."), response)
+			return
+		}
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No certificate with serial %s", req.Serial)), response)
+		return
+	}
+
+	wfe.revokeCertificate(cert, req.Reason)
+	wfe.log.Printf("Revoked certificate %s with the management interface", cert.ID)
+	response.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,94 @@
+package wfe
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Modes of appending the self-signed root to the chains served at certificate
+// URLs
+const (
+	// Never serve the root, like most CAs
+	RootInChainNever = "never"
+	// Always serve the root after the intermediates
+	RootInChainAlways = "always"
+	// Serve the chains without the root at the certificate URL and its
+	// alternate links, and the same chains with the root at further alternate
+	// links
+	RootInChainAlternate = "alternate"
+)
+
+// SetRootInChain configures whether the self-signed root is appended to the
+// served certificate chains, with one of the RootInChain modes. The root is
+// never served if the mode is empty. It must be called before the WFE starts
+// serving requests.
+func (wfe *WebFrontEndImpl) SetRootInChain(mode string) error {
+	switch mode {
+	case "", RootInChainNever:
+	case RootInChainAlways, RootInChainAlternate:
+		wfe.log.Printf("Serving the root in certificate chains: %s", mode)
+	default:
+		return fmt.Errorf("unknown root in chain mode %q", mode)
+	}
+	wfe.rootInChain = mode
+	return nil
+}
+
+// numberOfServedChains returns the number of chains a certificate is served
+// with at its certificate URL and alternate links.
+func (wfe *WebFrontEndImpl) numberOfServedChains(cert *core.Certificate) int {
+	if wfe.rootInChain == RootInChainAlternate {
+		return 2 * len(cert.IssuerChains)
+	}
+	return len(cert.IssuerChains)
+}
+
+// servedChain returns the certificate to serve for the chain with the given
+// index of the served chains, and the index of its issuer chain. The root of
+// the issuer chain is appended to the copy of the certificate returned if it
+// is served with the chain.
+func (wfe *WebFrontEndImpl) servedChain(cert *core.Certificate, no int) (*core.Certificate, int) {
+	withRoot := wfe.rootInChain == RootInChainAlways
+	if wfe.rootInChain == RootInChainAlternate && no >= len(cert.IssuerChains) {
+		withRoot = true
+		no -= len(cert.IssuerChains)
+	}
+	if !withRoot || no < 0 || no >= len(cert.IssuerChains) {
+		return cert, no
+	}
+
+	issuers := cert.IssuerChains[no]
+	last := cert
+	if len(issuers) > 0 {
+		last = issuers[len(issuers)-1]
+	}
+	root := wfe.findRoot(last)
+	if root == nil {
+		wfe.log.Printf("No root found for the issuer of certificate %s", cert.ID)
+		return cert, no
+	}
+
+	served := *cert
+	served.IssuerChains = make([][]*core.Certificate, len(cert.IssuerChains))
+	copy(served.IssuerChains, cert.IssuerChains)
+	served.IssuerChains[no] = append(append([]*core.Certificate{}, issuers...), root)
+	return &served, no
+}
+
+// findRoot returns the root of the CA that issued a certificate, or nil if the
+// issuer isn't a root, e.g. of a chain that was removed.
+func (wfe *WebFrontEndImpl) findRoot(cert *core.Certificate) *core.Certificate {
+	for i := 0; i < wfe.ca.GetNumberOfRootCerts(); i++ {
+		root := wfe.ca.GetRootCert(i)
+		if root == nil {
+			continue
+		}
+		if bytes.Equal(root.Cert.RawSubject, cert.Cert.RawIssuer) &&
+			cert.Cert.CheckSignatureFrom(root.Cert) == nil {
+			return root
+		}
+	}
+	return nil
+}
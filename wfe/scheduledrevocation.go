@@ -0,0 +1,145 @@
+package wfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ScheduledRevocationConfig schedules the revocation of every certificate
+// issued, so that ARI-aware clients can be tested reacting to revocations by
+// the CA. Until a certificate is revoked, its renewalInfo asks for an
+// immediate renewal.
+type ScheduledRevocationConfig struct {
+	// Seconds after issuance certificates are revoked. Zero disables the
+	// scheduled revocations.
+	After int
+	// Reason code certificates are revoked with, none if nil
+	Reason *uint
+	// URL of a page explaining the revocation, in the renewalInfo of the
+	// certificates
+	ExplanationURL string
+}
+
+// SetScheduledRevocation configures the revocation of every certificate
+// issued. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetScheduledRevocation(config ScheduledRevocationConfig) error {
+	if config.After < 0 {
+		return errors.New("scheduled revocation delay must not be negative")
+	}
+	if config.Reason != nil && !validRevocationReason(*config.Reason) {
+		return fmt.Errorf("invalid revocation reason %d", *config.Reason)
+	}
+	wfe.scheduledRevocation = config
+	if config.After > 0 {
+		wfe.log.Printf("Revoking certificates %ds after they are issued", config.After)
+	}
+	return nil
+}
+
+// completeOrder asks the CA to complete an order, and schedules the revocation
+// of the certificate issued if configured.
+func (wfe *WebFrontEndImpl) completeOrder(ctx context.Context, order *core.Order) {
+	wfe.ca.CompleteOrder(ctx, order)
+
+	config := wfe.scheduledRevocation
+	cert := order.Snapshot().CertificateObject
+	if config.After == 0 || cert == nil {
+		return
+	}
+	wfe.scheduleRevocation(cert, &core.ScheduledRevocation{
+		At:             cert.Cert.NotBefore.Add(time.Duration(config.After) * time.Second),
+		Reason:         config.Reason,
+		ExplanationURL: config.ExplanationURL,
+	})
+}
+
+// scheduleRevocation records the scheduled revocation of a certificate and
+// revokes it when the clock reaches the time of the revocation, unless the
+// schedule was replaced or the certificate revoked in the meantime.
+func (wfe *WebFrontEndImpl) scheduleRevocation(cert *core.Certificate, scheduled *core.ScheduledRevocation) {
+	wfe.db.ScheduleRevocation(cert.ID, scheduled)
+	wfe.log.Printf("Scheduled the revocation of certificate %s at %s",
+		cert.ID, scheduled.At.UTC().Format(time.RFC3339))
+
+	go func() {
+		clock.SleepUntil(wfe.clk, scheduled.At)
+		if wfe.db.GetScheduledRevocation(cert.ID) != scheduled {
+			return
+		}
+		wfe.db.ScheduleRevocation(cert.ID, nil)
+		if wfe.db.GetCertificateByID(cert.ID) == nil {
+			return
+		}
+		wfe.revokeCertificate(cert, scheduled.Reason)
+		wfe.log.Printf("Revoked certificate %s as scheduled", cert.ID)
+	}()
+}
+
+// handleScheduledRevocations lists the scheduled revocations by certificate
+// serial on GET, and schedules the revocation of a certificate on POST, e.g.
+// {"serial": "2fe11459f6b06186", "after": 300, "reason": 4}. The time of the
+// revocation is given in seconds from now with "after", or as an RFC 3339
+// timestamp with "at".
+func (wfe *WebFrontEndImpl) handleScheduledRevocations(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req struct {
+			Serial         string
+			After          int
+			At             string
+			Reason         *uint
+			ExplanationURL string
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+
+		serial, ok := new(big.Int).SetString(req.Serial, 16)
+		if !ok {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid serial number %q", req.Serial)), response)
+			return
+		}
+		cert := wfe.db.GetCertificateBySerial(serial)
+		if cert == nil {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No unrevoked certificate with serial %s", req.Serial)), response)
+			return
+		}
+		if req.Reason != nil && !validRevocationReason(*req.Reason) {
+			wfe.sendError(acme.BadRevocationReasonProblem(
+				fmt.Sprintf("Invalid revocation reason: %d", *req.Reason)), response)
+			return
+		}
+		at := wfe.clk.Now().Add(time.Duration(req.After) * time.Second)
+		if req.At != "" {
+			var err error
+			if at, err = time.Parse(time.RFC3339, req.At); err != nil {
+				wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Invalid time %q: %s", req.At, err)), response)
+				return
+			}
+		} else if req.After < 0 {
+			wfe.sendError(acme.MalformedProblem("The revocation delay must not be negative"), response)
+			return
+		}
+		wfe.scheduleRevocation(cert, &core.ScheduledRevocation{
+			At:             at,
+			Reason:         req.Reason,
+			ExplanationURL: req.ExplanationURL,
+		})
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.db.GetScheduledRevocations())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
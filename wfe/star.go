@@ -0,0 +1,285 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// This file implements ACME STAR, short-term automatic renewal of certificates
+// as described in RFC 8739.
+
+const (
+	// The shortest certificate lifetime (in seconds) a client may request for
+	// a recurrent order by default. This is deliberately short so that clients
+	// can observe several renewals in an integration test.
+	defaultSTARMinLifetime = 60
+
+	// The longest time span (in seconds) between now and the end date of
+	// a recurrent order by default.
+	defaultSTARMaxDuration = 365 * 24 * 60 * 60
+)
+
+// STARConfig configures the limits of recurrent orders, which the directory
+// advertises in its "auto-renewal" meta. The zero value allows lifetimes of
+// at least 60 seconds, end dates up to a year ahead and unauthenticated GET
+// requests for the certificates.
+type STARConfig struct {
+	// Shortest certificate lifetime in seconds a recurrent order may request.
+	// Zero means 60 seconds.
+	MinLifetime int
+	// Longest time in seconds between now and the end date of a recurrent
+	// order. Zero means one year.
+	MaxDuration int
+	// Whether recurrent orders may allow unauthenticated GET requests for
+	// their certificates. Defaults to true.
+	AllowCertificateGet *bool
+}
+
+// starLimits are the STAR limits with the defaults applied.
+type starLimits struct {
+	minLifetime         int
+	maxDuration         int
+	allowCertificateGet bool
+}
+
+func newSTARLimits(config STARConfig) starLimits {
+	limits := starLimits{
+		minLifetime:         config.MinLifetime,
+		maxDuration:         config.MaxDuration,
+		allowCertificateGet: config.AllowCertificateGet == nil || *config.AllowCertificateGet,
+	}
+	if limits.minLifetime == 0 {
+		limits.minLifetime = defaultSTARMinLifetime
+	}
+	if limits.maxDuration == 0 {
+		limits.maxDuration = defaultSTARMaxDuration
+	}
+	return limits
+}
+
+// SetSTAR configures the limits of recurrent orders. It must be called before
+// the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetSTAR(config STARConfig) error {
+	if config.MinLifetime < 0 || config.MaxDuration < 0 {
+		return fmt.Errorf("STAR minimum lifetime and maximum duration must not be negative")
+	}
+	limits := newSTARLimits(config)
+	if limits.minLifetime > limits.maxDuration {
+		return fmt.Errorf("STAR minimum lifetime must not exceed the maximum duration")
+	}
+	wfe.star = limits
+	return nil
+}
+
+// autoRenewalMeta returns the "auto-renewal" object of the directory meta
+// field. See RFC 8739 Section 3.1.1.
+func (wfe *WebFrontEndImpl) autoRenewalMeta() map[string]interface{} {
+	return map[string]interface{}{
+		"min-lifetime":          wfe.star.minLifetime,
+		"max-duration":          wfe.star.maxDuration,
+		"allow-certificate-get": wfe.star.allowCertificateGet,
+	}
+}
+
+// verifyAutoRenewal checks the "auto-renewal" object of a new recurrent order
+// and sets the parsed schedule on the order. The order must not be shared
+// yet, i.e. not added to the database, since its fields are written without
+// the order lock.
+func (wfe *WebFrontEndImpl) verifyAutoRenewal(order *core.Order) *acme.ProblemDetails {
+	ar := order.AutoRenewal
+
+	// RFC 8739 Section 3.1.1: notBefore and notAfter are not compatible with
+	// recurrent orders.
+	if order.NotBefore != "" || order.NotAfter != "" {
+		return acme.MalformedProblem(
+			"Recurrent orders must not include notBefore or notAfter fields")
+	}
+
+	now := wfe.clk.Now()
+	start := now
+	if ar.StartDate != "" {
+		parsed, err := time.Parse(time.RFC3339, ar.StartDate)
+		if err != nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Recurrent order has malformed start-date %q", ar.StartDate))
+		}
+		start = parsed
+	}
+
+	if ar.EndDate == "" {
+		return acme.MalformedProblem("Recurrent order is missing an end-date")
+	}
+	end, err := time.Parse(time.RFC3339, ar.EndDate)
+	if err != nil {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Recurrent order has malformed end-date %q", ar.EndDate))
+	}
+	if !end.After(start) || !end.After(now) {
+		return acme.MalformedProblem("Recurrent order end-date must be in the future and after start-date")
+	}
+	if end.Sub(now) > time.Duration(wfe.star.maxDuration)*time.Second {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Recurrent order end-date is more than %d seconds in the future", wfe.star.maxDuration))
+	}
+
+	if ar.Lifetime < wfe.star.minLifetime {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Recurrent order lifetime must be at least %d seconds", wfe.star.minLifetime))
+	}
+	if ar.LifetimeAdjust < 0 {
+		return acme.MalformedProblem("Recurrent order lifetime-adjust must not be negative")
+	}
+	if ar.AllowCertificateGet && !wfe.star.allowCertificateGet {
+		return acme.MalformedProblem("Server does not allow unauthenticated certificate GET requests")
+	}
+
+	order.AutoRenewalStart = start
+	order.AutoRenewalEnd = end
+	order.AutoRenewalLifetime = time.Duration(ar.Lifetime) * time.Second
+	order.AutoRenewalLifetimeAdjust = time.Duration(ar.LifetimeAdjust) * time.Second
+	return nil
+}
+
+// cancelOrder handles a POST to an order URL requesting cancellation of
+// a recurrent order. See RFC 8739 Section 3.1.2.
+func (wfe *WebFrontEndImpl) cancelOrder(
+	postData *authenticatedPOST,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	existingAcct, prob := wfe.getAcctByKey(postData.jwk)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	var cancelRequest struct {
+		Status string
+	}
+	if err := wfe.unmarshalBody(postData.body, &cancelRequest); err != nil {
+		wfe.sendError(acme.MalformedProblem("Error unmarshaling order update JSON body: "+err.Error()), response)
+		return
+	}
+	if cancelRequest.Status != acme.StatusCanceled {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Malformed order update, status must be %q not %q",
+			acme.StatusCanceled, cancelRequest.Status)), response)
+		return
+	}
+
+	orderID := strings.TrimPrefix(request.URL.Path, orderPath)
+	order := wfe.db.GetOrderByID(orderID)
+	if order == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Check and cancel the order in a single update
+	order.Update(func(order *core.Order) {
+		switch {
+		case order.AccountID != existingAcct.ID:
+			prob = acme.UnauthorizedProblem(
+				"Account that authenticated the request does not own the specified order")
+		case !order.IsRecurrent():
+			prob = acme.AutoRenewalCancellationInvalidProblem(
+				"Only recurrent orders can be canceled")
+		case order.Canceled:
+			prob = acme.AutoRenewalCancellationInvalidProblem(
+				"Recurrent order is already canceled")
+		default:
+			order.Canceled = true
+			order.Status = acme.StatusCanceled
+		}
+	})
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	wfe.log.Printf("Recurrent order %s was canceled", orderID)
+
+	orderResp := wfe.orderForDisplay(order, request)
+	err := wfe.writeJSONResponse(response, http.StatusOK, orderResp)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshaling order"), response)
+		return
+	}
+}
+
+// StarCertificate serves the most recent certificate of a recurrent order. If
+// the order allows it the certificate may be fetched with an unauthenticated
+// GET request, otherwise a POST-as-GET request by the order's account is
+// required. See RFC 8739 Section 3.3.
+func (wfe *WebFrontEndImpl) StarCertificate(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	orderID := strings.TrimPrefix(request.URL.Path, starCertPath)
+	order := wfe.db.GetOrderByID(orderID)
+	if order == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	snapshot := order.Snapshot()
+	recurrent := snapshot.IsRecurrent()
+	allowGet := recurrent && snapshot.AutoRenewal.AllowCertificateGet
+	orderAccountID := snapshot.AccountID
+	canceled := snapshot.Canceled
+	end := snapshot.AutoRenewalEnd
+	cert := snapshot.CertificateObject
+
+	if !recurrent {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if request.Method == http.MethodPost {
+		postData, prob := wfe.verifyPOST(request, wfe.lookupJWK)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		acct, prob := wfe.validPOSTAsGET(postData)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		if acct.ID != orderAccountID {
+			wfe.sendError(acme.UnauthorizedProblem(
+				"Account authenticating request does not own the recurrent order"), response)
+			return
+		}
+	} else if !allowGet || wfe.strict.forbidUnauthenticatedGET {
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Recurrent order does not allow unauthenticated certificate GET requests"), response)
+		return
+	}
+
+	if canceled {
+		wfe.sendError(acme.AutoRenewalCanceledProblem("Recurrent order was canceled"), response)
+		return
+	}
+	// Once the end date has passed and the last certificate expired the order
+	// is expired
+	now := wfe.clk.Now()
+	if now.After(end) && (cert == nil || now.After(cert.Cert.NotAfter)) {
+		wfe.sendError(acme.AutoRenewalExpiredProblem("Recurrent order has expired"), response)
+		return
+	}
+	if cert == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	response.Header().Set("Cert-Not-Before", cert.Cert.NotBefore.UTC().Format(http.TimeFormat))
+	response.Header().Set("Cert-Not-After", cert.Cert.NotAfter.UTC().Format(http.TimeFormat))
+	cert, no := wfe.servedChain(cert, 0)
+	wfe.writeCertificate(response, request, cert, no)
+}
@@ -0,0 +1,192 @@
+package wfe
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+func TestSetSTAR(t *testing.T) {
+	allow, deny := true, false
+	testCases := []struct {
+		name    string
+		config  STARConfig
+		want    starLimits
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			config: STARConfig{},
+			want:   starLimits{minLifetime: 60, maxDuration: 365 * 24 * 60 * 60, allowCertificateGet: true},
+		},
+		{
+			name:   "custom",
+			config: STARConfig{MinLifetime: 3600, MaxDuration: 604800, AllowCertificateGet: &deny},
+			want:   starLimits{minLifetime: 3600, maxDuration: 604800, allowCertificateGet: false},
+		},
+		{
+			name:   "certificate GET allowed",
+			config: STARConfig{AllowCertificateGet: &allow},
+			want:   starLimits{minLifetime: 60, maxDuration: 365 * 24 * 60 * 60, allowCertificateGet: true},
+		},
+		{
+			name:    "negative lifetime",
+			config:  STARConfig{MinLifetime: -1},
+			wantErr: true,
+		},
+		{
+			name:    "negative duration",
+			config:  STARConfig{MaxDuration: -1},
+			wantErr: true,
+		},
+		{
+			name:    "lifetime exceeds duration",
+			config:  STARConfig{MinLifetime: 7200, MaxDuration: 3600},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{}
+			err := wfe.SetSTAR(tc.config)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("SetSTAR(%+v) returned no error", tc.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetSTAR(%+v) returned error: %s", tc.config, err)
+			}
+			if wfe.star != tc.want {
+				t.Errorf("SetSTAR(%+v) set limits %+v, want %+v", tc.config, wfe.star, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAutoRenewal(t *testing.T) {
+	clk := clock.NewFake()
+	now := clk.Now()
+	date := func(d time.Duration) string {
+		return now.Add(d).Format(time.RFC3339)
+	}
+	testCases := []struct {
+		name        string
+		autoRenewal acme.AutoRenewal
+		notAfter    string
+		limits      STARConfig
+		wantProblem string
+	}{
+		{
+			name:        "valid",
+			autoRenewal: acme.AutoRenewal{EndDate: date(24 * time.Hour), Lifetime: 3600},
+		},
+		{
+			name: "valid with start date",
+			autoRenewal: acme.AutoRenewal{
+				StartDate: date(time.Hour), EndDate: date(24 * time.Hour), Lifetime: 3600, LifetimeAdjust: 60,
+			},
+		},
+		{
+			name:        "notAfter",
+			autoRenewal: acme.AutoRenewal{EndDate: date(24 * time.Hour), Lifetime: 3600},
+			notAfter:    date(2 * time.Hour),
+			wantProblem: "must not include notBefore or notAfter",
+		},
+		{
+			name:        "malformed start date",
+			autoRenewal: acme.AutoRenewal{StartDate: "tomorrow", EndDate: date(24 * time.Hour), Lifetime: 3600},
+			wantProblem: "malformed start-date",
+		},
+		{
+			name:        "missing end date",
+			autoRenewal: acme.AutoRenewal{Lifetime: 3600},
+			wantProblem: "missing an end-date",
+		},
+		{
+			name:        "malformed end date",
+			autoRenewal: acme.AutoRenewal{EndDate: "2030-01-01", Lifetime: 3600},
+			wantProblem: "malformed end-date",
+		},
+		{
+			name:        "end date in the past",
+			autoRenewal: acme.AutoRenewal{EndDate: date(-time.Hour), Lifetime: 3600},
+			wantProblem: "must be in the future",
+		},
+		{
+			name: "end date before start date",
+			autoRenewal: acme.AutoRenewal{
+				StartDate: date(48 * time.Hour), EndDate: date(24 * time.Hour), Lifetime: 3600,
+			},
+			wantProblem: "must be in the future and after start-date",
+		},
+		{
+			name:        "end date beyond maximum duration",
+			autoRenewal: acme.AutoRenewal{EndDate: date(48 * time.Hour), Lifetime: 3600},
+			limits:      STARConfig{MaxDuration: 24 * 60 * 60},
+			wantProblem: "more than 86400 seconds in the future",
+		},
+		{
+			name:        "lifetime below minimum",
+			autoRenewal: acme.AutoRenewal{EndDate: date(24 * time.Hour), Lifetime: 59},
+			wantProblem: "at least 60 seconds",
+		},
+		{
+			name:        "lifetime below configured minimum",
+			autoRenewal: acme.AutoRenewal{EndDate: date(24 * time.Hour), Lifetime: 3599},
+			limits:      STARConfig{MinLifetime: 3600},
+			wantProblem: "at least 3600 seconds",
+		},
+		{
+			name:        "negative lifetime adjust",
+			autoRenewal: acme.AutoRenewal{EndDate: date(24 * time.Hour), Lifetime: 3600, LifetimeAdjust: -1},
+			wantProblem: "lifetime-adjust must not be negative",
+		},
+		{
+			name: "certificate GET not allowed",
+			autoRenewal: acme.AutoRenewal{
+				EndDate: date(24 * time.Hour), Lifetime: 3600, AllowCertificateGet: true,
+			},
+			limits:      STARConfig{AllowCertificateGet: new(bool)},
+			wantProblem: "does not allow unauthenticated certificate GET",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wfe := &WebFrontEndImpl{clk: clk}
+			if err := wfe.SetSTAR(tc.limits); err != nil {
+				t.Fatalf("SetSTAR(%+v) returned error: %s", tc.limits, err)
+			}
+			autoRenewal := tc.autoRenewal
+			order := &core.Order{}
+			order.AutoRenewal = &autoRenewal
+			order.NotAfter = tc.notAfter
+
+			prob := wfe.verifyAutoRenewal(order)
+			if tc.wantProblem == "" {
+				if prob != nil {
+					t.Fatalf("verifyAutoRenewal returned problem: %s", prob.Detail)
+				}
+				if order.AutoRenewalEnd.Format(time.RFC3339) != autoRenewal.EndDate {
+					t.Errorf("order end is %s, want %s", order.AutoRenewalEnd, autoRenewal.EndDate)
+				}
+				if order.AutoRenewalLifetime != time.Duration(autoRenewal.Lifetime)*time.Second {
+					t.Errorf("order lifetime is %s, want %ds", order.AutoRenewalLifetime, autoRenewal.Lifetime)
+				}
+				return
+			}
+			if prob == nil {
+				t.Fatalf("verifyAutoRenewal returned no problem, want %q", tc.wantProblem)
+			}
+			if prob.Type != acme.MalformedProblem("").Type || !strings.Contains(prob.Detail, tc.wantProblem) {
+				t.Errorf("verifyAutoRenewal returned %s problem %q, want malformed %q",
+					prob.Type, prob.Detail, tc.wantProblem)
+			}
+		})
+	}
+}
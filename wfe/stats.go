@@ -0,0 +1,27 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+)
+
+// handleStats returns the numbers of orders created, authorizations validated
+// and certificates issued and revoked, in total, by account ID and by
+// registered domain, so that load tests can verify the issuance volumes. A
+// DELETE request resets the counters and the issuance rate.
+func (wfe *WebFrontEndImpl) handleStats(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method == http.MethodDelete {
+		wfe.db.ResetIssuanceStats()
+		wfe.issuanceRate.reset()
+		wfe.log.Printf("Reset the issuance statistics")
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, wfe.db.IssuanceStats())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
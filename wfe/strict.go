@@ -0,0 +1,124 @@
+package wfe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// StrictConfig selects how pedantically ACME requests are checked against
+// RFC 8555. Each check that is nil is enabled if Enabled is set, and otherwise
+// has its default, so that strict mode can be turned on with individual checks
+// relaxed to what production CAs tolerate.
+type StrictConfig struct {
+	// Enable all checks that aren't disabled explicitly, like the -strict
+	// flag
+	Enabled bool
+	// The Content-Type of POST requests must be exactly
+	// "application/jose+json", without parameters. On by default, when off
+	// the media type is compared case-insensitively and parameters such as
+	// "charset" are ignored.
+	ExactContentType *bool
+	// The JWS "url" header must be exactly the request URL and requests
+	// must not carry a Replay-Nonce header. On by default, when off only the
+	// path of the "url" header is compared and Replay-Nonce request headers
+	// are ignored.
+	ValidateJWSHeaders *bool
+	// Certificates can only be fetched with POST-as-GET requests, even if the
+	// order allows unauthenticated GET requests. Off by default.
+	ForbidUnauthenticatedGET *bool
+	// Request bodies must not contain JSON fields unknown to the endpoint.
+	// Off by default.
+	RejectUnknownFields *bool
+	// Request bodies must not contain the "resource" field of ACME v1. Off
+	// by default.
+	RejectLegacyResource *bool
+	// Challenge responses must have a body of exactly "{}". Off by default.
+	ExactChallengeBody *bool
+}
+
+// strictness is the StrictConfig with the defaults applied.
+type strictness struct {
+	exactContentType         bool
+	validateJWSHeaders       bool
+	forbidUnauthenticatedGET bool
+	rejectUnknownFields      bool
+	rejectLegacyResource     bool
+	exactChallengeBody       bool
+}
+
+func newStrictness(config StrictConfig) strictness {
+	check := func(value *bool, def bool) bool {
+		switch {
+		case value != nil:
+			return *value
+		case config.Enabled:
+			return true
+		}
+		return def
+	}
+	return strictness{
+		exactContentType:         check(config.ExactContentType, true),
+		validateJWSHeaders:       check(config.ValidateJWSHeaders, true),
+		forbidUnauthenticatedGET: check(config.ForbidUnauthenticatedGET, false),
+		rejectUnknownFields:      check(config.RejectUnknownFields, false),
+		rejectLegacyResource:     check(config.RejectLegacyResource, false),
+		exactChallengeBody:       check(config.ExactChallengeBody, false),
+	}
+}
+
+// SetStrict configures the checks of ACME requests. It must be called before
+// the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetStrict(config StrictConfig) {
+	wfe.strict = newStrictness(config)
+	if config.Enabled {
+		wfe.log.Printf("Strict mode enabled")
+	}
+}
+
+// validContentType returns whether the Content-Type header of a POST request
+// is "application/jose+json".
+func (s strictness) validContentType(contentType string) bool {
+	if s.exactContentType {
+		return contentType == expectedJWSContentType
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == expectedJWSContentType
+}
+
+// matchingURL returns whether the JWS "url" header matches the URL of the
+// request.
+func (s strictness) matchingURL(headerURL, expectedURL string) bool {
+	if s.validateJWSHeaders || headerURL == expectedURL {
+		return headerURL == expectedURL
+	}
+	header, err := url.Parse(headerURL)
+	if err != nil {
+		return false
+	}
+	expected, err := url.Parse(expectedURL)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSuffix(header.Path, "/") == strings.TrimSuffix(expected.Path, "/")
+}
+
+// unmarshalBody unmarshals the JSON body of a request, rejecting fields that
+// v doesn't have if unknown fields aren't allowed.
+func (wfe *WebFrontEndImpl) unmarshalBody(body []byte, v interface{}) error {
+	if !wfe.strict.rejectUnknownFields {
+		return json.Unmarshal(body, v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after JSON object")
+	}
+	return nil
+}
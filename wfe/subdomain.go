@@ -0,0 +1,83 @@
+package wfe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// This file implements subdomain authorizations as described in RFC 9444. An
+// identifier in a newOrder request may name an "ancestorDomain", in which case
+// the authorization is created for the ancestor domain and, once valid, also
+// authorizes the ancestor's subdomains.
+
+// SetSubdomainAuthMaxDepth configures the maximum number of labels between
+// an identifier and the ancestor domain authorizing it. A depth of zero means
+// there is no limit. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetSubdomainAuthMaxDepth(depth int) {
+	wfe.subdomainAuthMaxDepth = depth
+}
+
+// subdomainDepth returns the number of labels between a DNS name and one of
+// its ancestor domains. A wildcard prefix is not counted.
+func subdomainDepth(name, ancestor string) int {
+	name = strings.TrimPrefix(name, "*.")
+	return strings.Count(name, ".") - strings.Count(ancestor, ".")
+}
+
+// verifyAncestorDomain checks that the ancestorDomain of an order identifier
+// is a proper ancestor of the identifier within the configured maximum depth.
+func (wfe *WebFrontEndImpl) verifyAncestorDomain(ident acme.Identifier) *acme.ProblemDetails {
+	if ident.Type != acme.IdentifierDNS {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included ancestorDomain for a non-%s identifier: %q",
+			acme.IdentifierDNS, ident.Value))
+	}
+
+	ancestor := ident.AncestorDomain
+	if strings.Contains(ancestor, "*") || strings.HasSuffix(ancestor, ".") ||
+		!strings.Contains(ancestor, ".") {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included malformed ancestorDomain %q", ancestor))
+	}
+	// A wildcard name may be authorized by its base domain
+	base := strings.TrimPrefix(ident.Value, "*.")
+	if base != ancestor && !strings.HasSuffix(base, "."+ancestor) {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included ancestorDomain %q that is not an ancestor of %q",
+			ancestor, ident.Value))
+	}
+	if wfe.subdomainAuthMaxDepth > 0 && subdomainDepth(ident.Value, ancestor) > wfe.subdomainAuthMaxDepth {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included ancestorDomain %q more than %d labels above %q",
+			ancestor, wfe.subdomainAuthMaxDepth, ident.Value))
+	}
+	return nil
+}
+
+// findAncestorAuthorization looks for an existing valid subdomain
+// authorization for one of the ancestors of a DNS name, within the configured
+// maximum depth. The base domain of a wildcard name is considered one of its
+// ancestors.
+func (wfe *WebFrontEndImpl) findAncestorAuthorization(accountID, name string) *core.Authorization {
+	ancestor := strings.TrimPrefix(name, "*.")
+	if ancestor == name {
+		ancestor = ancestor[strings.Index(ancestor, ".")+1:]
+	}
+	for strings.Contains(ancestor, ".") {
+		if wfe.subdomainAuthMaxDepth > 0 && subdomainDepth(name, ancestor) > wfe.subdomainAuthMaxDepth {
+			return nil
+		}
+		authz := wfe.db.FindValidSubdomainAuthorization(accountID, acme.Identifier{
+			Type:  acme.IdentifierDNS,
+			Value: ancestor,
+		})
+		if authz != nil {
+			return authz
+		}
+		ancestor = ancestor[strings.Index(ancestor, ".")+1:]
+	}
+	return nil
+}
@@ -1,17 +1,18 @@
 package wfe
 
 import (
-	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"github.com/letsencrypt/pebble/random"
 )
 
 // randomString and newToken come from Boulder core/util.go
 // randomString returns a randomly generated string of the requested length.
 func randomString(byteLength int) string {
 	b := make([]byte, byteLength)
-	_, err := io.ReadFull(rand.Reader, b)
+	_, err := io.ReadFull(random.Reader, b)
 	if err != nil {
 		panic(fmt.Sprintf("Error reading random bytes: %s", err))
 	}
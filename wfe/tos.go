@@ -0,0 +1,99 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// TermsOfServiceAgreement configures the agreement of accounts to the terms
+// of service.
+type TermsOfServiceAgreement struct {
+	// Don't require newAccount requests to agree to the terms of service, nor
+	// accounts to agree to changed terms of service again
+	Optional bool
+}
+
+// termsOfService is the URL of the current terms of service. It can be
+// changed with the management interface while the WFE serves requests.
+type termsOfService struct {
+	sync.RWMutex
+	url string
+}
+
+// SetTermsOfServiceAgreement configures the agreement of accounts to the
+// terms of service. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetTermsOfServiceAgreement(agreement TermsOfServiceAgreement) {
+	wfe.tosAgreement = agreement
+}
+
+// termsOfServiceURL returns the URL of the current terms of service.
+func (wfe *WebFrontEndImpl) termsOfServiceURL() string {
+	wfe.tos.RLock()
+	defer wfe.tos.RUnlock()
+	return wfe.tos.url
+}
+
+// termsOfServiceLink returns the Link header value of the terms of service.
+func (wfe *WebFrontEndImpl) termsOfServiceLink() string {
+	return link(wfe.termsOfServiceURL(), "terms-of-service")
+}
+
+// checkTermsOfService returns a userActionRequired problem, and adds a Link
+// header of the terms of service to the response, if the account must agree
+// to the current terms of service before making the request. See RFC 8555
+// Section 7.3.3.
+func (wfe *WebFrontEndImpl) checkTermsOfService(
+	acct *core.Account,
+	response http.ResponseWriter) *acme.ProblemDetails {
+	tosURL := wfe.termsOfServiceURL()
+	if wfe.tosAgreement.Optional || acct.AgreedTermsOfService == tosURL {
+		return nil
+	}
+	response.Header().Add("Link", wfe.termsOfServiceLink())
+	prob := acme.UserActionRequiredProblem(fmt.Sprintf(
+		"The terms of service changed, the account must agree to the terms of service at %s "+
+			"by updating it with termsOfServiceAgreed", tosURL))
+	prob.Instance = tosURL
+	return prob
+}
+
+// handleTermsOfService returns the URL of the current terms of service on
+// GET, and replaces it with the "url" field of the body of a POST request,
+// e.g. {"url": "https://example.com/tos-v2.pdf"}, after which accounts must
+// agree to the new terms of service before they can order certificates.
+func (wfe *WebFrontEndImpl) handleTermsOfService(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet {
+		var req struct {
+			URL string
+		}
+		if !wfe.readManagementPOST(response, request, &req) {
+			return
+		}
+		if u, err := url.Parse(req.URL); err != nil || u.Scheme == "" {
+			wfe.sendError(acme.MalformedProblem(
+				fmt.Sprintf("terms of service URL %q must be an absolute URL", req.URL)), response)
+			return
+		}
+		wfe.tos.Lock()
+		wfe.tos.url = req.URL
+		wfe.tos.Unlock()
+		wfe.log.Printf("Changed the terms of service to %s", req.URL)
+	}
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, map[string]string{
+		"url": wfe.termsOfServiceURL(),
+	})
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,57 @@
+package wfe
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/trace"
+)
+
+// SetTracer configures the tracer of ACME requests. It must be called before
+// the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetTracer(tracer *trace.Tracer) {
+	wfe.tracer = tracer
+}
+
+// startSpan starts the span of an ACME request to the endpoint with the given
+// pattern, continuing the trace of a "traceparent" request header. It returns
+// the response writer and request the handler must use, the request's context
+// carries the span. The returned function ends the span once the request was
+// handled.
+func (wfe *WebFrontEndImpl) startSpan(
+	pattern string,
+	response http.ResponseWriter,
+	request *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	if wfe.tracer == nil {
+		return response, request, func() {}
+	}
+
+	ctx, span := wfe.tracer.Start(trace.Extract(request.Context(), request.Header),
+		request.Method+" "+pattern, trace.KindServer)
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("http.route", pattern)
+	span.SetAttribute("url.path", request.RequestURI)
+	if requestID := core.RequestID(request.Context()); requestID != "" {
+		span.SetAttribute("pebble.request.id", requestID)
+	}
+	writer := &recordingResponseWriter{ResponseWriter: response}
+
+	return writer, request.WithContext(ctx), func() {
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", status)
+		if len(writer.problem) > 0 {
+			var prob acme.ProblemDetails
+			if err := json.Unmarshal(writer.problem, &prob); err == nil {
+				span.SetError(prob.Type)
+			}
+		} else if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,293 @@
+package wfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// defaultTransitionProblem is the problem type of the challenges and orders
+// failed with the management interface without a type
+const defaultTransitionProblem = "unauthorized"
+
+// objectID returns the ID of an account, order, authorization or challenge
+// given by its ID or URL, which ends with the ID.
+func objectID(idOrURL string) string {
+	return idOrURL[strings.LastIndex(idOrURL, "/")+1:]
+}
+
+// transitionProblem returns the problem of the given type and detail, or of
+// the default type and the given default detail if they are empty. It returns
+// false if the type is unknown.
+func transitionProblem(typ, detail, defaultDetail string) (*acme.ProblemDetails, bool) {
+	if typ == "" {
+		typ = defaultTransitionProblem
+	}
+	if detail == "" {
+		detail = defaultDetail
+	}
+	return acme.ProblemOfType(typ, detail)
+}
+
+// handleExpireAuthz expires the pending or valid authorization given by ID or
+// URL in the body of a POST request immediately, e.g. {"authz": "abc"}. The
+// orders of the authorization become invalid like when it expires on its own.
+func (wfe *WebFrontEndImpl) handleExpireAuthz(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Authz string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	authzID := objectID(req.Authz)
+	authz := wfe.db.GetAuthorizationByID(authzID)
+	if authz == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No authorization %q", req.Authz)), response)
+		return
+	}
+	now := wfe.clk.Now()
+	if prob := checkExpirable(authz.Snapshot(), now); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	expires := now.Add(-time.Second)
+	authz.Update(func(authz *core.Authorization) {
+		authz.ExpiresDate = expires
+		authz.Expires = expires.UTC().Format(time.RFC3339)
+	})
+	wfe.log.Printf("Expired authorization %s with the management interface", authzID)
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, prepAuthorizationForDisplay(authz, now))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// checkExpirable returns a problem if an authorization isn't pending or valid, or
+// has expired already.
+func checkExpirable(authz *core.Authorization, now time.Time) *acme.ProblemDetails {
+	if authz.Status != acme.StatusPending && authz.Status != acme.StatusValid {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Authorization %q is %s, only %s and %s authorizations expire",
+			authz.ID, authz.Status, acme.StatusPending, acme.StatusValid))
+	}
+	if authz.ExpiresDate.Before(now) {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Authorization %q expired %s", authz.ID, authz.Expires))
+	}
+	return nil
+}
+
+// handleInvalidateChallenge marks the challenge of a pending authorization
+// given by ID or URL in the body of a POST request invalid, like a failed
+// validation, e.g. {"challenge": "abc", "type": "dns", "detail": "SERVFAIL"}.
+// The problem type may be a URN or the part after the ACME error namespace,
+// "unauthorized" if empty. The authorization and its order become invalid.
+func (wfe *WebFrontEndImpl) handleInvalidateChallenge(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Challenge string
+		Type      string
+		Detail    string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	chalID := objectID(req.Challenge)
+	chal := wfe.db.GetChallengeByID(chalID)
+	if chal == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No challenge %q", req.Challenge)), response)
+		return
+	}
+	prob, ok := transitionProblem(req.Type, req.Detail,
+		"Challenge invalidated with the management interface")
+	if !ok {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Unknown problem type %q", req.Type)), response)
+		return
+	}
+	authz := chal.Snapshot().Authz
+	if authz == nil {
+		wfe.sendError(acme.InternalErrorProblem("challenge missing associated authz"), response)
+		return
+	}
+	if status := authz.Snapshot().Status; status != acme.StatusPending {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Authorization of challenge %q is %s, not %s", chalID, status, acme.StatusPending)), response)
+		return
+	}
+
+	// Like a failed validation, the challenge error is the error of the order
+	authz.Update(func(authz *core.Authorization) {
+		authz.Status = acme.StatusInvalid
+	})
+	chal.Update(func(chal *core.Challenge) {
+		chal.Error = prob
+		chal.Status = acme.StatusInvalid
+	})
+	if order := authz.Snapshot().Order; order != nil {
+		order.Update(func(order *core.Order) {
+			order.Error = prob
+		})
+	}
+	wfe.log.Printf("Invalidated challenge %s with the management interface: %s", chalID, prob)
+
+	err := wfe.writeJSONResponse(response, http.StatusOK, prepAuthorizationForDisplay(authz, wfe.clk.Now()))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleFailOrder makes the pending or ready order given by ID or URL in the
+// body of a POST request invalid with a problem, e.g. {"order": "abc",
+// "type": "serverInternal"}. The problem type may be a URN or the part after
+// the ACME error namespace, "unauthorized" if empty.
+func (wfe *WebFrontEndImpl) handleFailOrder(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Order  string
+		Type   string
+		Detail string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+
+	orderID := objectID(req.Order)
+	order := wfe.db.GetOrderByID(orderID)
+	if order == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No order %q", req.Order)), response)
+		return
+	}
+	prob, ok := transitionProblem(req.Type, req.Detail,
+		"Order failed with the management interface")
+	if !ok {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf("Unknown problem type %q", req.Type)), response)
+		return
+	}
+	if status := order.Snapshot().Status; status != acme.StatusPending && status != acme.StatusReady {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Order %q is %s, only %s and %s orders can fail",
+			orderID, status, acme.StatusPending, acme.StatusReady)), response)
+		return
+	}
+
+	order.Update(func(order *core.Order) {
+		order.Error = prob
+	})
+	wfe.log.Printf("Failed order %s with the management interface: %s", orderID, prob)
+
+	// Getting the order again updates its status
+	err := wfe.writeJSONResponse(response, http.StatusOK, summarizeOrder(wfe.db.GetOrderByID(orderID)))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleUnexpire sets the expiry of the authorization or order given by ID or
+// URL in the body of a POST request to a time in the future, by default one
+// day from now, e.g. {"authz": "abc"} or {"order": "abc", "expires":
+// "2030-01-01T00:00:00Z"}. Un-expiring an order also un-expires its
+// authorizations that expire before it.
+func (wfe *WebFrontEndImpl) handleUnexpire(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	var req struct {
+		Authz   string
+		Order   string
+		Expires string
+	}
+	if !wfe.readManagementPOST(response, request, &req) {
+		return
+	}
+	if (req.Authz == "") == (req.Order == "") {
+		wfe.sendError(acme.MalformedProblem("Exactly one of authz and order must be given"), response)
+		return
+	}
+
+	now := wfe.clk.Now()
+	expires := now.AddDate(0, 0, 1)
+	if req.Expires != "" {
+		var err error
+		expires, err = time.Parse(time.RFC3339, req.Expires)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+				"Invalid expires %q: %s", req.Expires, err)), response)
+			return
+		}
+		if !expires.After(now) {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+				"Expires %q is not in the future", req.Expires)), response)
+			return
+		}
+	}
+
+	if req.Authz != "" {
+		authzID := objectID(req.Authz)
+		authz := wfe.db.GetAuthorizationByID(authzID)
+		if authz == nil {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No authorization %q", req.Authz)), response)
+			return
+		}
+		unexpireAuthz(authz, expires)
+		wfe.log.Printf("Un-expired authorization %s until %s with the management interface",
+			authzID, expires.UTC().Format(time.RFC3339))
+
+		err := wfe.writeJSONResponse(response, http.StatusOK, prepAuthorizationForDisplay(authz, now))
+		if err != nil {
+			response.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	orderID := objectID(req.Order)
+	order := wfe.db.GetOrderByID(orderID)
+	if order == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No order %q", req.Order)), response)
+		return
+	}
+	order.Update(func(order *core.Order) {
+		order.ExpiresDate = expires
+		order.Expires = expires.UTC().Format(time.RFC3339)
+	})
+	for _, authz := range order.Snapshot().AuthorizationObjects {
+		if authz.Snapshot().ExpiresDate.Before(expires) {
+			unexpireAuthz(authz, expires)
+		}
+	}
+	wfe.log.Printf("Un-expired order %s until %s with the management interface",
+		orderID, expires.UTC().Format(time.RFC3339))
+
+	// Getting the order again updates its status
+	err := wfe.writeJSONResponse(response, http.StatusOK, summarizeOrder(wfe.db.GetOrderByID(orderID)))
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// unexpireAuthz sets the expiry of an authorization.
+func unexpireAuthz(authz *core.Authorization, expires time.Time) {
+	authz.Update(func(authz *core.Authorization) {
+		authz.ExpiresDate = expires
+		authz.Expires = expires.UTC().Format(time.RFC3339)
+	})
+}
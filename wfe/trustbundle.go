@@ -0,0 +1,63 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// defaultTrustStorePassword is the password of PKCS#12 trust bundles if the
+// request doesn't give one, the default password of Java trust stores
+const defaultTrustStorePassword = "changeit"
+
+// handleTrustBundle serves the certificates of the CA's current chains, roots
+// first, in the format named by the path: "pem" for concatenated PEM
+// certificates, "der" for concatenated DER certificates and "p12" for a
+// PKCS#12 trust store protected by the password of the "password" query
+// parameter.
+func (wfe *WebFrontEndImpl) handleTrustBundle(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	certs := wfe.ca.GetTrustBundle()
+	var body []byte
+	switch request.URL.Path {
+	case "pem":
+		for _, cert := range certs {
+			body = append(body, cert.PEM()...)
+		}
+		response.Header().Set("Content-Type", "application/pem-certificate-chain; charset=utf-8")
+	case "der":
+		for _, cert := range certs {
+			body = append(body, cert.DER...)
+		}
+		response.Header().Set("Content-Type", "application/octet-stream")
+	case "p12":
+		password := defaultTrustStorePassword
+		if values, ok := request.URL.Query()["password"]; ok {
+			password = values[0]
+		}
+		var err error
+		if body, err = core.PKCS12TrustStore(certs, password); err != nil {
+			wfe.log.Printf("Error encoding trust bundle: %s", err)
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.Header().Set("Content-Type", "application/x-pkcs12")
+	default:
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The bundle changes when chains are added or intermediates rotated
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(http.StatusOK)
+	_, _ = response.Write(body)
+}
@@ -0,0 +1,81 @@
+package wfe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// clockSkewAllowance is how far in the past a newOrder notBefore may be when
+// backdating is not allowed, to tolerate client clock skew.
+const clockSkewAllowance = time.Minute
+
+// ValidityPolicy limits the notBefore and notAfter fields that newOrder
+// requests may include. See RFC 8555 Section 7.4.
+type ValidityPolicy struct {
+	// MaxValidityPeriod is the longest validity period in seconds a client may
+	// request. Zero means the validity period of the order's profile.
+	MaxValidityPeriod int
+	// MaxBackdate is how many seconds in the past a requested notBefore may
+	// be.
+	MaxBackdate int
+}
+
+// SetValidityPolicy configures the limits on the requested validity of new
+// orders. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetValidityPolicy(policy ValidityPolicy) {
+	wfe.validityPolicy = policy
+}
+
+// verifyValidity checks the notBefore and notAfter fields of a new order
+// against the validity policy and sets the parsed dates on the order. The
+// caller is expected to hold the order lock for writing.
+func (wfe *WebFrontEndImpl) verifyValidity(order *core.Order) *acme.ProblemDetails {
+	if order.NotBefore == "" && order.NotAfter == "" {
+		return nil
+	}
+
+	now := wfe.clk.Now()
+	notBefore := now
+	if order.NotBefore != "" {
+		parsed, err := time.Parse(time.RFC3339, order.NotBefore)
+		if err != nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Order has malformed notBefore %q", order.NotBefore))
+		}
+		notBefore = parsed
+	}
+	maxBackdate := time.Duration(wfe.validityPolicy.MaxBackdate) * time.Second
+	if notBefore.Before(now.Add(-maxBackdate - clockSkewAllowance)) {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order notBefore may be at most %d seconds in the past", wfe.validityPolicy.MaxBackdate))
+	}
+
+	maxValidity := ca.ValidityPeriod(wfe.ca.GetProfile(order.Profile))
+	if wfe.validityPolicy.MaxValidityPeriod > 0 {
+		maxValidity = time.Duration(wfe.validityPolicy.MaxValidityPeriod) * time.Second
+	}
+	notAfter := notBefore.Add(maxValidity)
+	if order.NotAfter != "" {
+		parsed, err := time.Parse(time.RFC3339, order.NotAfter)
+		if err != nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Order has malformed notAfter %q", order.NotAfter))
+		}
+		notAfter = parsed
+	}
+	if !notAfter.After(notBefore) || !notAfter.After(now) {
+		return acme.MalformedProblem("Order notAfter must be in the future and after notBefore")
+	}
+	if notAfter.Sub(notBefore) > maxValidity {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order requests a validity period longer than %d seconds", int(maxValidity.Seconds())))
+	}
+
+	order.NotBeforeDate = notBefore
+	order.NotAfterDate = notAfter
+	return nil
+}
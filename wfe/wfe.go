@@ -14,25 +14,26 @@ import (
 	"io/ioutil"
 	"log"
 	"math/big"
-	"math/rand"
 	"net"
 	"net/http"
-	"net/mail"
 	"net/url"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"gopkg.in/square/go-jose.v2"
 
 	"github.com/letsencrypt/pebble/acme"
 	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/clock"
 	"github.com/letsencrypt/pebble/core"
 	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/metrics"
+	"github.com/letsencrypt/pebble/random"
+	"github.com/letsencrypt/pebble/trace"
 	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/webhook"
 )
 
 const (
@@ -49,36 +50,79 @@ const (
 	authzPath         = "/authZ/"
 	challengePath     = "/chalZ/"
 	certPath          = "/certZ/"
+	starCertPath      = "/star-certZ/"
 	revokeCertPath    = "/revoke-cert"
 	keyRolloverPath   = "/rollover-account-key"
 	ordersPath        = "/list-orderz/"
+	delegationsPath   = "/list-delegationz/"
+	delegationPath    = "/delegationZ/"
+	renewalInfoPath   = "/renewal-info/"
+	unpausePath       = "/unpause/"
 
 	// Theses entrypoints are not a part of the standard ACME endpoints,
 	// and are exposed by Pebble as an integration test tool. We export
 	// RootCertPath so that the pebble binary can reference it.
-	RootCertPath         = "/roots/"
-	rootKeyPath          = "/root-keys/"
-	intermediateCertPath = "/intermediates/"
-	intermediateKeyPath  = "/intermediate-keys/"
-	certStatusBySerial   = "/cert-status-by-serial/"
+	RootCertPath           = "/roots/"
+	rootKeyPath            = "/root-keys/"
+	intermediateCertPath   = "/intermediates/"
+	intermediateKeyPath    = "/intermediate-keys/"
+	trustBundlePath        = "/trust-bundle/"
+	certStatusBySerial     = "/cert-status-by-serial/"
+	chainsPath             = "/chains"
+	addChainPath           = "/add-chain"
+	setDefaultChainPath    = "/set-default-chain"
+	rotateIntermediatePath = "/rotate-intermediate"
+	resetRateLimitsPath    = "/reset-rate-limits"
+	ctLogsPath             = "/ct-logs/"
+	clockPath              = "/clock"
+	gcStatsPath            = "/gc-stats"
+	nonceRejectionsPath    = "/nonce-rejections"
+	revokeCertAdminPath    = "/revoke-cert"
+	revocationSchedulePath = "/scheduled-revocations"
+	ariResponsesPath       = "/ari-responses"
+	reactivateAccountPath  = "/reactivate-account"
+	pausedAccountsPath     = "/paused-accounts"
+	eabKeysPath            = "/eab-keys"
+	disableEABKeyPath      = "/disable-eab-key"
+	rotateEABKeyPath       = "/rotate-eab-key"
+	eabRequiredPath        = "/external-account-required"
+	termsOfServicePath     = "/terms-of-service"
+	offeredChallengesPath  = "/offered-challenges"
+	authzReusePath         = "/authz-reuse"
+	blockedDomainsPath     = "/blocked-domains"
+	certsByNamePath        = "/certs-by-name/"
+	certDetailsBySerial    = "/cert-details-by-serial/"
+	ordersByAccountPath    = "/orders-by-account/"
+	statsPath              = "/stats"
+	issuanceRatePath       = "/issuance-rate"
+	preauthorizePath       = "/preauthorize"
+	expireAuthzPath        = "/expire-authz"
+	invalidateChallPath    = "/invalidate-challenge"
+	failOrderPath          = "/fail-order"
+	unexpirePath           = "/unexpire"
+
+	// Health and readiness probes are served by both the ACME and the
+	// management interface
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+
+	// Profiling and runtime statistics, if debug endpoints are enabled
+	debugPprofPath   = "/debug/pprof/"
+	debugRuntimePath = "/debug/runtime"
+
+	// Prometheus metrics, if enabled
+	metricsPath = "/metrics"
 
 	// How long do pending authorizations last before expiring?
 	pendingAuthzExpire = time.Hour
 
-	// How many contacts is an account allowed to have?
+	// How many contacts is an account allowed to have if the contact policy
+	// doesn't say?
 	maxContactsPerAcct = 2
 
-	// badNonceEnvVar defines the environment variable name used to provide
-	// a percentage value for how often good nonces should be rejected as if they
-	// were bad. This can be used to exercise client nonce handling/retries.
-	// To have the WFE not reject any good nonces, run Pebble like:
-	//   PEBBLE_WFE_NONCEREJECT=0 pebble
-	// To have the WFE reject 15% of good nonces, run Pebble like:
-	//   PEBBLE_WFE_NONCEREJECT=15 pebble
-	badNonceEnvVar = "PEBBLE_WFE_NONCEREJECT"
-
-	// By default when no PEBBLE_WFE_NONCEREJECT is set, what percentage of good
-	// nonces are rejected?
+	// By default when the nonce config doesn't say, what percentage of good
+	// nonces are rejected as if they were bad? This exercises client nonce
+	// handling/retries.
 	defaultNonceReject = 5
 
 	// POST requests with a JWS body must have the following Content-Type header
@@ -96,22 +140,15 @@ const (
 	unusedRevocationReason       = 7
 	aACompromiseRevocationReason = 10
 
-	// authzReuseEnvVar defines an environment variable name used to provide a
-	// percentage value for how often Pebble should try to reuse valid authorizations
-	// for each identifier in an order. The percentage is independent of whether a
-	// valid authorization exists or not for each identifier in an order.
-	authzReuseEnvVar = "PEBBLE_AUTHZREUSE"
+	// Certificates revoked with the keyCompromise reason code block their key
+	keyCompromiseRevocationReason = 1
 
-	// The default value when PEBBLE_WFE_AUTHZREUSE is not set, how often to try
-	// and reuse valid authorizations.
+	// How often to try and reuse valid authorizations for each identifier in
+	// an order if the authorization reuse policy doesn't say. The percentage is
+	// independent of whether a valid authorization exists or not for each
+	// identifier in an order.
 	defaultAuthzReuse = 50
 
-	// ordersPerPageEnvVar defines the environment variable name used to provide
-	// the number of orders to show per page. To have the WFE show 15 orders per
-	// page, run Pebble like:
-	//   PEBBLE_WFE_ORDERS_PER_PAGE=15 pebble
-	ordersPerPageEnvVar = "PEBBLE_WFE_ORDERS_PER_PAGE"
-
 	// The default number of orders enumerated per page
 	defaultOrdersPerPage = 3
 )
@@ -145,87 +182,114 @@ func (th *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type WebFrontEndImpl struct {
-	log               *log.Logger
-	db                *db.MemoryStore
-	nonce             *nonceMap
-	nonceErrPercent   int
-	authzReusePercent int
-	ordersPerPage     int
-	va                *va.VAImpl
-	ca                *ca.CAImpl
-	strict            bool
-	requireEAB        bool
+	log             *log.Logger
+	clk             clock.Clock
+	db              *db.MemoryStore
+	nonce           *nonceMap
+	nonceErrPercent int
+	nonceLifetime   time.Duration
+	nonceRejections []*nonceRejection
+	authzReuse      *authzReuse
+	ordersPerPage   int
+	va              *va.VAImpl
+	ca              *ca.CAImpl
+	strict          strictness
+	requireEAB      *eabRequirement
+	directoryMeta   DirectoryMeta
+	tos             *termsOfService
+	tosAgreement    TermsOfServiceAgreement
+	delegations     []acme.Delegation
+	challengeTypes  []ChallengeType
+	offered         *offeredChallenges
+
+	subdomainAuthMaxDepth int
+	wildcardPolicy        WildcardPolicy
+	chainPerturbation     ChainPerturbation
+	rootInChain           string
+	validityPolicy        ValidityPolicy
+	identifierLimits      IdentifierLimits
+	rateLimits            RateLimits
+	rateLimiter           *rateLimiter
+	faults                []Fault
+	gcStats               *gcStats
+	webhooks              *webhook.Notifier
+	auditLog              *auditLog
+	accessLog             *accessLog
+	tracer                *trace.Tracer
+	listeners             *listeners
+	debugEndpoints        bool
+	metrics               *metrics.Registry
+	pathPrefix            string
+	// Base URLs of the ACME and management interfaces used in URLs instead
+	// of the request's host, if set
+	externalURL           *url.URL
+	externalManagementURL *url.URL
+	cors                  *cors
+	keyPolicy             *keyPolicy
+	identifierPolicy      *identifierPolicy
+	postQuantum           PostQuantumConfig
+	csrPolicy             *csrPolicy
+	contactPolicy         *contactPolicy
+	revocation            *revocationPolicy
+	scheduledRevocation   ScheduledRevocationConfig
+	ari                   ARIConfig
+	retryAfter            RetryAfterConfig
+	star                  starLimits
+	finalizer             *finalizer
+	issuanceRate          *issuanceRate
+	compressed            map[string]bool
 }
 
 const ToSURL = "data:text/plain,Do%20what%20thou%20wilt"
 
 func New(
 	log *log.Logger,
+	clk clock.Clock,
 	db *db.MemoryStore,
 	va *va.VAImpl,
 	ca *ca.CAImpl,
 	strict, requireEAB bool) WebFrontEndImpl {
-	// Seed rand from the current time so test environments don't always have
-	// the same nonce rejection and sleep time patterns.
-	rand.Seed(time.Now().UnixNano())
-
-	// Read the % of good nonces that should be rejected as bad nonces from the
-	// environment
-	nonceErrPercentVal := os.Getenv(badNonceEnvVar)
-	var nonceErrPercent int
-
-	// Parse the env var value as a base 10 int - if there isn't an error, use it
-	// as the wfe nonceErrPercent
-	if val, err := strconv.ParseInt(nonceErrPercentVal, 10, 0); err == nil {
-		nonceErrPercent = int(val)
-	} else {
-		// Otherwise just use the default
-		nonceErrPercent = defaultNonceReject
-	}
-
-	// If the value is out of the range just clip it sensibly
-	if nonceErrPercent < 0 {
-		nonceErrPercent = 0
-	} else if nonceErrPercent > 100 {
-		nonceErrPercent = 99
-	}
-	log.Printf("Configured to reject %d%% of good nonces", nonceErrPercent)
-
-	// Get authz reuse percent from the environment
 	authzReusePercent := defaultAuthzReuse
-	if val, err := strconv.ParseInt(os.Getenv(authzReuseEnvVar), 10, 0); err == nil &&
-		val >= 0 && val <= 100 {
-		authzReusePercent = int(val)
-	}
-	log.Printf("Configured to attempt authz reuse for each identifier %d%% of the time",
-		authzReusePercent)
-
-	// Read the number of orders per page that should be returned.
-	ordersPerPageVal := os.Getenv(ordersPerPageEnvVar)
-	var ordersPerPage int
-
-	// Parse the env var value as a base 10 int - if there isn't an error, use it
-	// as the wfe nonceErrPercent
-	if val, err := strconv.ParseInt(ordersPerPageVal, 10, 0); err == nil && val > 0 {
-		ordersPerPage = int(val)
-	} else {
-		// Otherwise just use the default
-		ordersPerPage = defaultOrdersPerPage
-	}
-	log.Printf("Configured to show %d orders per page", ordersPerPage)
-
 	return WebFrontEndImpl{
-		log:               log,
-		db:                db,
-		nonce:             newNonceMap(),
-		nonceErrPercent:   nonceErrPercent,
-		authzReusePercent: authzReusePercent,
-		ordersPerPage:     ordersPerPage,
-		va:                va,
-		ca:                ca,
-		strict:            strict,
-		requireEAB:        requireEAB,
-	}
+		log:              log,
+		clk:              clk,
+		db:               db,
+		nonce:            newNonceMap(clk),
+		nonceErrPercent:  defaultNonceReject,
+		authzReuse:       &authzReuse{policy: AuthzReusePolicy{Percent: &authzReusePercent}},
+		ordersPerPage:    defaultOrdersPerPage,
+		va:               va,
+		ca:               ca,
+		strict:           newStrictness(StrictConfig{Enabled: strict}),
+		requireEAB:       &eabRequirement{required: requireEAB},
+		directoryMeta:    DirectoryMeta{TermsOfService: ToSURL},
+		tos:              &termsOfService{url: ToSURL},
+		offered:          &offeredChallenges{offered: OfferedChallenges{}.withDefaults()},
+		rateLimiter:      newRateLimiter(clk),
+		finalizer:        &finalizer{},
+		gcStats:          &gcStats{},
+		issuanceRate:     newIssuanceRate(),
+		listeners:        &listeners{listening: make(map[string]bool)},
+		cors:             defaultCORS(),
+		keyPolicy:        newKeyPolicy(KeyPolicy{}),
+		identifierPolicy: &identifierPolicy{},
+		csrPolicy:        &csrPolicy{},
+		contactPolicy:    newContactPolicy(ContactPolicy{}),
+		revocation:       newRevocationPolicy(RevocationConfig{}),
+		ari:              ARIConfig{WindowStart: ariWindowStart, WindowEnd: ariWindowEnd},
+		star:             newSTARLimits(STARConfig{}),
+	}
+}
+
+// Clone returns a copy of the WFE sharing its state: the store, the VA and the
+// CA, nonces, rate limit counters, the finalize workers, the audit log and the
+// listeners. The setters of the copy don't affect the WFE, except for
+// SetFinalize, so that a reloaded configuration can be applied to a copy while
+// the WFE keeps serving requests, and the copy's handlers can then replace the
+// WFE's.
+func (wfe *WebFrontEndImpl) Clone() *WebFrontEndImpl {
+	clone := *wfe
+	return &clone
 }
 
 func (wfe *WebFrontEndImpl) HandleFunc(
@@ -249,11 +313,34 @@ func (wfe *WebFrontEndImpl) HandleFunc(
 	defaultHandler := http.StripPrefix(pattern,
 		&topHandler{
 			wfe: wfeHandlerFunc(func(ctx context.Context, response http.ResponseWriter, request *http.Request) {
+				// Assign an ID to the request and log it in the access log, if
+				// configured
+				request = startRequest(response, request)
+				ctx = core.WithRequestID(ctx, core.RequestID(request.Context()))
+				// Compress the response if configured and accepted by the
+				// client, before the logs record it
+				var finishCompression func()
+				response, finishCompression = wfe.startCompression(pattern, response, request)
+				defer finishCompression()
+				var finishAccess func()
+				response, finishAccess = wfe.startAccessLog(pattern, response, request)
+				defer finishAccess()
+
+				// Trace the request and record it in the audit log, if configured
+				var endSpan, finishAudit func()
+				response, request, endSpan = wfe.startSpan(pattern, response, request)
+				defer endSpan()
+				response, request, finishAudit = wfe.startAudit(pattern, response, request)
+				defer finishAudit()
+
 				// Process CORS as necessary. If it's a CORS preflight, no further processing may occur.
 				if wfe.processCORS(request, response, methodsMap) {
 					return
 				}
 
+				// Nonce rejection rules match the endpoint of the request
+				request = withEndpoint(request, pattern)
+
 				// Modern ACME only sends a Replay-Nonce in responses to GET/HEAD
 				// requests to the dedicated newNonce endpoint, or in replies to POST
 				// requests that consumed a nonce.
@@ -277,58 +364,25 @@ func (wfe *WebFrontEndImpl) HandleFunc(
 					return
 				}
 
-				wfe.log.Printf("%s %s -> calling handler()\n", request.Method, pattern)
+				wfe.log.Printf("%s%s %s -> calling handler()\n",
+					core.RequestLogPrefix(ctx), request.Method, pattern)
 
 				// TODO(@cpu): Configurable request timeout
 				timeout := 1 * time.Minute
 				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				if fault := wfe.pickFault(pattern); fault != "" {
+					wfe.injectFault(fault, response, request, func(response http.ResponseWriter) {
+						handler(ctx, response, request)
+					})
+					return
+				}
 				handler(ctx, response, request)
-				cancel()
 			},
 			)})
 	mux.Handle(pattern, defaultHandler)
 }
 
-// processCORS reads and writes all necessary request and response headers in order to
-// enable use by CORS-aware user agents. If the request is a CORS preflight request, the
-// function returns true, in which case no further data may be written to the response.
-func (wfe *WebFrontEndImpl) processCORS(request *http.Request, response http.ResponseWriter,
-	allowedMethodsMap map[string]bool) bool {
-	// 6.1.1, 6.2.1. No Origin header means CORS is not relevant.
-	// 6.1.2, 6.2.2. Origin's value is not processed because it always matches.
-	if request.Header.Get("Origin") == "" {
-		return false
-	}
-
-	// 6.2. Request is a CORS preflight
-	if request.Method == http.MethodOptions {
-		// 6.2.3, 6.2.5. -Request-Method must be present and must be a match for one of the allowed methods
-		method := request.Header.Get("Access-Control-Request-Method")
-		if _, allowed := allowedMethodsMap[method]; method == "" || !allowed {
-			return false
-		}
-		// 6.2.4, 6.2.6. -Request-Headers i not processed because it always matches.
-		// 6.2.7. Send -Allow-Origin, without -Allow-Credentials support.
-		response.Header().Set("Access-Control-Allow-Origin", "*")
-		// 6.2.8. Send -Max-Age.
-		response.Header().Set("Access-Control-Max-Age", "5")
-		// 6.2.9. -Allow-Methods is not sent because ACME only uses simple methods.
-		// 6.2.10. -Allow-Headers required for "Content-Type: application/jose+json"
-		response.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		// Terminate the request
-		response.WriteHeader(http.StatusNoContent)
-		return true
-	}
-
-	// 6.1. Otherwise, request is a CORS simple or actual request.
-	// 6.1.3. Send -Allow-Origin, without Allow-Credentials support.
-	response.Header().Set("Access-Control-Allow-Origin", "*")
-	// 6.1.4. Send -Expose-Headers for the response headers ACME uses.
-	response.Header().Set("Access-Control-Expose-Headers", "Link, Replay-Nonce, Location")
-	// Continue processing the request
-	return false
-}
-
 func (wfe *WebFrontEndImpl) HandleManagementFunc(
 	mux *http.ServeMux,
 	pattern string,
@@ -337,6 +391,13 @@ func (wfe *WebFrontEndImpl) HandleManagementFunc(
 }
 
 func (wfe *WebFrontEndImpl) sendError(prob *acme.ProblemDetails, response http.ResponseWriter) {
+	// Problems include the ID of the request, which was set as a response
+	// header when the request was received
+	if requestID := response.Header().Get(requestIDHeader); requestID != "" {
+		withID := *prob
+		withID.RequestID = requestID
+		prob = &withID
+	}
 	problemDoc, err := marshalIndent(prob)
 	if err != nil {
 		problemDoc = []byte("{\"detail\": \"Problem marshaling error message.\"}")
@@ -348,7 +409,7 @@ func (wfe *WebFrontEndImpl) sendError(prob *acme.ProblemDetails, response http.R
 }
 
 type certGetter func(no int) *core.Certificate
-type keyGetter func(no int) *rsa.PrivateKey
+type keyGetter func(no int) crypto.Signer
 
 func (wfe *WebFrontEndImpl) handleCert(
 	certGet certGetter,
@@ -419,13 +480,27 @@ func (wfe *WebFrontEndImpl) handleKey(
 			response.Header().Add("Link", link(path, "alternate"))
 		}
 
-		// Write main response
-		var buf bytes.Buffer
+		// Write main response. RSA keys are PKCS#1 encoded, other keys PKCS#8.
+		var block *pem.Block
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			block = &pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+			}
+		} else {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				wfe.sendError(acme.InternalErrorProblem("unable to encode private key"), response)
+				return
+			}
+			block = &pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: der,
+			}
+		}
 
-		err = pem.Encode(&buf, &pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: x509.MarshalPKCS1PrivateKey(key),
-		})
+		var buf bytes.Buffer
+		err = pem.Encode(&buf, block)
 		if err != nil {
 			wfe.sendError(acme.InternalErrorProblem("unable to encode private key to PEM"), response)
 			return
@@ -494,6 +569,18 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 	wfe.HandleFunc(m, DirectoryPath, wfe.Directory, http.MethodGet, http.MethodPost)
 	// Note for noncePath: http.MethodGet also implies http.MethodHead
 	wfe.HandleFunc(m, noncePath, wfe.Nonce, http.MethodGet, http.MethodPost)
+	// Note for starCertPath: unauthenticated GET is only allowed when the
+	// recurrent order permits it
+	wfe.HandleFunc(m, starCertPath, wfe.StarCertificate, http.MethodGet, http.MethodPost)
+	// Note for certPath: unauthenticated GET is only allowed for delegated
+	// certificates
+	wfe.HandleFunc(m, certPath, wfe.Certificate, http.MethodGet, http.MethodPost)
+	// Note for unpausePath: the self-service unpause page isn't an ACME
+	// resource, its POST requests aren't JWS
+	wfe.HandleFunc(m, unpausePath, wfe.Unpause, http.MethodGet, http.MethodPost)
+
+	// GET only handlers
+	wfe.HandleFunc(m, renewalInfoPath, wfe.RenewalInfo, http.MethodGet)
 
 	// POST only handlers
 	wfe.HandleFunc(m, newAccountPath, wfe.NewAccount, http.MethodPost)
@@ -502,11 +589,16 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 	wfe.HandleFunc(m, acctPath, wfe.UpdateAccount, http.MethodPost)
 	wfe.HandleFunc(m, keyRolloverPath, wfe.KeyRollover, http.MethodPost)
 	wfe.HandleFunc(m, revokeCertPath, wfe.RevokeCert, http.MethodPost)
-	wfe.HandleFunc(m, certPath, wfe.Certificate, http.MethodPost)
 	wfe.HandleFunc(m, orderPath, wfe.Order, http.MethodPost)
 	wfe.HandleFunc(m, authzPath, wfe.Authz, http.MethodPost)
 	wfe.HandleFunc(m, challengePath, wfe.Challenge, http.MethodPost)
 	wfe.HandleFunc(m, ordersPath, wfe.ListOrders, http.MethodPost)
+	wfe.HandleFunc(m, delegationsPath, wfe.ListDelegations, http.MethodPost)
+	wfe.HandleFunc(m, delegationPath, wfe.Delegation, http.MethodPost)
+
+	// Health and readiness probes aren't ACME resources
+	wfe.HandleManagementFunc(m, healthzPath, wfe.handleHealthz)
+	wfe.HandleManagementFunc(m, readyzPath, wfe.handleReadyz)
 
 	return m
 }
@@ -514,13 +606,56 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 // ManagementHandler handles the endpoints exposed on the management interface that is configured
 // by the `managementListenAddress` parameter in Pebble JSON config file.
 func (wfe *WebFrontEndImpl) ManagementHandler() http.Handler {
+	// The management endpoints use the management interface's external URL
+	wfe = wfe.Clone()
+	wfe.externalURL = wfe.externalManagementURL
 	m := http.NewServeMux()
 	// GET only handlers
 	wfe.HandleManagementFunc(m, RootCertPath, wfe.handleCert(wfe.ca.GetRootCert, RootCertPath))
 	wfe.HandleManagementFunc(m, rootKeyPath, wfe.handleKey(wfe.ca.GetRootKey, rootKeyPath))
 	wfe.HandleManagementFunc(m, intermediateCertPath, wfe.handleCert(wfe.ca.GetIntermediateCert, intermediateCertPath))
 	wfe.HandleManagementFunc(m, intermediateKeyPath, wfe.handleKey(wfe.ca.GetIntermediateKey, intermediateKeyPath))
+	wfe.HandleManagementFunc(m, trustBundlePath, wfe.handleTrustBundle)
 	wfe.HandleManagementFunc(m, certStatusBySerial, wfe.handleCertStatusBySerial)
+	wfe.HandleManagementFunc(m, certsByNamePath, wfe.handleCertsByName)
+	wfe.HandleManagementFunc(m, certDetailsBySerial, wfe.handleCertDetailsBySerial)
+	wfe.HandleManagementFunc(m, ordersByAccountPath, wfe.handleOrdersByAccount)
+	wfe.HandleManagementFunc(m, chainsPath, wfe.handleChains)
+	wfe.HandleManagementFunc(m, ctLogsPath, wfe.handleCTLogs)
+	// POST only handlers
+	wfe.HandleManagementFunc(m, addChainPath, wfe.handleAddChain)
+	wfe.HandleManagementFunc(m, setDefaultChainPath, wfe.handleSetDefaultChain)
+	wfe.HandleManagementFunc(m, rotateIntermediatePath, wfe.handleRotateIntermediate)
+	wfe.HandleManagementFunc(m, resetRateLimitsPath, wfe.handleResetRateLimits)
+	wfe.HandleManagementFunc(m, revokeCertAdminPath, wfe.handleRevokeCert)
+	wfe.HandleManagementFunc(m, revocationSchedulePath, wfe.handleScheduledRevocations)
+	wfe.HandleManagementFunc(m, ariResponsesPath, wfe.handleARIResponses)
+	wfe.HandleManagementFunc(m, reactivateAccountPath, wfe.handleReactivateAccount)
+	wfe.HandleManagementFunc(m, expireAuthzPath, wfe.handleExpireAuthz)
+	wfe.HandleManagementFunc(m, invalidateChallPath, wfe.handleInvalidateChallenge)
+	wfe.HandleManagementFunc(m, failOrderPath, wfe.handleFailOrder)
+	wfe.HandleManagementFunc(m, unexpirePath, wfe.handleUnexpire)
+	wfe.HandleManagementFunc(m, clockPath, wfe.handleClock)
+	wfe.HandleManagementFunc(m, gcStatsPath, wfe.handleGCStats)
+	wfe.HandleManagementFunc(m, statsPath, wfe.handleStats)
+	wfe.HandleManagementFunc(m, issuanceRatePath, wfe.handleIssuanceRate)
+	wfe.HandleManagementFunc(m, preauthorizePath, wfe.handlePreauthorize)
+	wfe.HandleManagementFunc(m, nonceRejectionsPath, wfe.handleNonceRejections)
+	wfe.HandleManagementFunc(m, pausedAccountsPath, wfe.handlePausedAccounts)
+	wfe.HandleManagementFunc(m, eabKeysPath, wfe.handleEABKeys)
+	wfe.HandleManagementFunc(m, disableEABKeyPath, wfe.handleDisableEABKey)
+	wfe.HandleManagementFunc(m, rotateEABKeyPath, wfe.handleRotateEABKey)
+	wfe.HandleManagementFunc(m, eabRequiredPath, wfe.handleExternalAccountRequired)
+	wfe.HandleManagementFunc(m, termsOfServicePath, wfe.handleTermsOfService)
+	wfe.HandleManagementFunc(m, offeredChallengesPath, wfe.handleOfferedChallenges)
+	wfe.HandleManagementFunc(m, authzReusePath, wfe.handleAuthzReuse)
+	wfe.HandleManagementFunc(m, blockedDomainsPath, wfe.handleBlockedDomains)
+	wfe.HandleManagementFunc(m, healthzPath, wfe.handleHealthz)
+	wfe.HandleManagementFunc(m, readyzPath, wfe.handleReadyz)
+	wfe.handleDebug(m)
+	if wfe.metrics != nil {
+		m.Handle(metricsPath, wfe.metrics)
+	}
 	return m
 }
 
@@ -530,11 +665,12 @@ func (wfe *WebFrontEndImpl) Directory(
 	request *http.Request) {
 
 	directoryEndpoints := map[string]string{
-		"newNonce":   noncePath,
-		"newAccount": newAccountPath,
-		"newOrder":   newOrderPath,
-		"revokeCert": revokeCertPath,
-		"keyChange":  keyRolloverPath,
+		"newNonce":    noncePath,
+		"newAccount":  newAccountPath,
+		"newOrder":    newOrderPath,
+		"revokeCert":  revokeCertPath,
+		"keyChange":   keyRolloverPath,
+		"renewalInfo": renewalInfoPath,
 	}
 
 	// RFC 8555 §6.3 says the server's directory endpoint should support
@@ -570,10 +706,7 @@ func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory m
 	for k, v := range directory {
 		relativeDir[k] = wfe.relativeEndpoint(request, v)
 	}
-	relativeDir["meta"] = map[string]interface{}{
-		"termsOfService":          ToSURL,
-		"externalAccountRequired": wfe.requireEAB,
-	}
+	relativeDir["meta"] = wfe.directoryMetaObject()
 
 	directoryJSON, err := marshalIndent(relativeDir)
 	// This should never happen since we are just marshaling known strings
@@ -604,7 +737,117 @@ func (wfe *WebFrontEndImpl) relativeEndpoint(request *http.Request, endpoint str
 		host = "localhost"
 	}
 
-	return (&url.URL{Scheme: proto, Host: host, Path: endpoint}).String()
+	basePath := ""
+	if wfe.externalURL != nil {
+		proto, host, basePath = wfe.externalURL.Scheme, wfe.externalURL.Host, wfe.externalBasePath()
+	}
+
+	return (&url.URL{Scheme: proto, Host: host, Path: basePath + wfe.pathPrefix + endpoint}).String()
+}
+
+// externalBasePath returns the path of the external URL without a trailing
+// slash.
+func (wfe *WebFrontEndImpl) externalBasePath() string {
+	return strings.TrimSuffix(wfe.externalURL.Path, "/")
+}
+
+// requestURL returns the URL a client sent the request to. It is the external
+// URL with the path of the request, which the reverse proxy in front of Pebble
+// may or may not have stripped the path of the external URL from.
+func (wfe *WebFrontEndImpl) requestURL(request *http.Request) string {
+	if wfe.externalURL == nil {
+		// NOTE(@cpu): ACME **REQUIRES** HTTPS and Pebble is hardcoded to offer the
+		// API over HTTPS.
+		return (&url.URL{Scheme: "https", Host: request.Host, Path: request.RequestURI}).String()
+	}
+	basePath := wfe.externalBasePath()
+	path := request.RequestURI
+	if basePath != "" && (path == basePath || strings.HasPrefix(path, basePath+"/")) {
+		path = strings.TrimPrefix(path, basePath)
+	}
+	return (&url.URL{Scheme: wfe.externalURL.Scheme, Host: wfe.externalURL.Host, Path: basePath + path}).String()
+}
+
+// SetExternalURLs sets the base URLs of the ACME and management interfaces,
+// e.g. "https://acme.example.com/pebble", that the URLs of resources use
+// instead of the host of the requests when Pebble is behind a reverse proxy.
+// Empty URLs keep using the host of the requests. It must be called before
+// the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetExternalURLs(acmeURL, managementURL string) error {
+	var err error
+	if wfe.externalURL, err = parseExternalURL(acmeURL); err != nil {
+		return err
+	}
+	if wfe.externalManagementURL, err = parseExternalURL(managementURL); err != nil {
+		return err
+	}
+	if wfe.externalURL != nil {
+		wfe.log.Printf("Using external URL %s for ACME resources", acmeURL)
+	}
+	return nil
+}
+
+func parseExternalURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external URL %q: %s", rawURL, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid external URL %q: expected an http or https URL with a host and "+
+			"an optional path", rawURL)
+	}
+	return u, nil
+}
+
+// SetPathPrefix sets the path prefix the handlers are served under, e.g.
+// "/tenant", so that the URLs of resources include it. The prefix must be
+// stripped from requests before they are passed to the handlers.
+func (wfe *WebFrontEndImpl) SetPathPrefix(prefix string) {
+	wfe.pathPrefix = prefix
+}
+
+// IsEndpointPath returns whether the path is the path of an ACME or
+// management endpoint, or a parent of one, e.g. "/roots".
+func IsEndpointPath(path string) bool {
+	for _, endpoint := range []string{
+		DirectoryPath, noncePath, newAccountPath, acctPath, newOrderPath,
+		orderPath, orderFinalizePath, authzPath, challengePath, certPath,
+		starCertPath, revokeCertPath, keyRolloverPath, ordersPath,
+		delegationsPath, delegationPath, renewalInfoPath, RootCertPath,
+		rootKeyPath, intermediateCertPath, intermediateKeyPath, trustBundlePath,
+		certStatusBySerial, chainsPath, addChainPath, setDefaultChainPath,
+		rotateIntermediatePath, resetRateLimitsPath, ctLogsPath, clockPath,
+		gcStatsPath, nonceRejectionsPath, revokeCertAdminPath,
+		revocationSchedulePath, ariResponsesPath, reactivateAccountPath,
+		unpausePath, pausedAccountsPath, eabKeysPath, disableEABKeyPath,
+		rotateEABKeyPath, eabRequiredPath, termsOfServicePath,
+		offeredChallengesPath, authzReusePath, blockedDomainsPath,
+		certsByNamePath, certDetailsBySerial, ordersByAccountPath, statsPath,
+		issuanceRatePath, preauthorizePath, expireAuthzPath,
+		invalidateChallPath, failOrderPath, unexpirePath, healthzPath,
+		readyzPath, debugPprofPath, debugRuntimePath, metricsPath,
+	} {
+		if path == strings.TrimSuffix(endpoint, "/") || strings.HasPrefix(endpoint, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublicManagementPath returns whether the path is the path of a management
+// endpoint serving public data, the root and intermediate certificates that
+// certificate URLs may point to, the trust bundle and the health probes, which
+// don't require management authentication.
+func IsPublicManagementPath(path string) bool {
+	for _, prefix := range []string{RootCertPath, intermediateCertPath, trustBundlePath} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return path == healthzPath || path == readyzPath
 }
 
 func (wfe *WebFrontEndImpl) Nonce(
@@ -664,6 +907,12 @@ func (wfe *WebFrontEndImpl) parseJWS(body string) (*jose.JSONWebSignature, error
 			"JWS \"signatures\" field not allowed. Only the \"signature\" field should contain a signature")
 	}
 
+	if wfe.postQuantum.AccountKeys {
+		if parsedJWS, err := parseMLDSAJWS(body); parsedJWS != nil || err != nil {
+			return parsedJWS, err
+		}
+	}
+
 	parsedJWS, err := jose.ParseSigned(body)
 	if err != nil {
 		return nil, errors.New("Parse error reading JWS")
@@ -717,7 +966,8 @@ func (wfe *WebFrontEndImpl) extractJWK(_ *http.Request, jws *jose.JSONWebSignatu
 	if key == nil {
 		return nil, acme.MalformedProblem("No JWK in JWS header")
 	}
-	if !key.Valid() {
+	// ML-DSA keys are validated when they are parsed
+	if core.MLDSAAlgorithm(key.Key) == "" && !key.Valid() {
 		return nil, acme.MalformedProblem("Invalid JWK in JWS header")
 	}
 	if header.KeyID != "" {
@@ -757,7 +1007,7 @@ func (wfe *WebFrontEndImpl) validPOST(request *http.Request) *acme.ProblemDetail
 			`missing Content-Type header on POST. ` +
 				`Content-Type must be "application/jose+json"`)
 	}
-	if contentType := request.Header.Get("Content-Type"); contentType != expectedJWSContentType {
+	if contentType := request.Header.Get("Content-Type"); !wfe.strict.validContentType(contentType) {
 		return acme.UnsupportedMediaTypeProblem(
 			`Invalid Content-Type header on POST. ` +
 				`Content-Type must be "application/jose+json"`)
@@ -769,7 +1019,7 @@ func (wfe *WebFrontEndImpl) validPOST(request *http.Request) *acme.ProblemDetail
 
 	// Per 6.4.1  "Replay-Nonce" clients should not send a Replay-Nonce header in
 	// the HTTP request, it needs to be part of the signed JWS request body
-	if _, present := request.Header["Replay-Nonce"]; present {
+	if _, present := request.Header["Replay-Nonce"]; present && wfe.strict.validateJWSHeaders {
 		return acme.MalformedProblem("HTTP requests should NOT contain Replay-Nonce header. Use JWS nonce field")
 	}
 
@@ -837,6 +1087,7 @@ func (wfe *WebFrontEndImpl) verifyPOST(
 	if prob != nil {
 		return nil, prob
 	}
+	auditKey(request, pubKey)
 
 	result, prob := wfe.verifyJWS(pubKey, parsedJWS, request)
 	if prob != nil {
@@ -851,11 +1102,17 @@ func (wfe *WebFrontEndImpl) verifyPOST(
 func (wfe *WebFrontEndImpl) verifyJWSSignatureAndAlgorithm(
 	pubKey *jose.JSONWebKey,
 	parsedJWS *jose.JSONWebSignature) ([]byte, *acme.ProblemDetails) {
-	if prob := checkAlgorithm(pubKey, parsedJWS); prob != nil {
+	if prob := wfe.keyPolicy.checkAlgorithm(pubKey, parsedJWS, wfe.postQuantum.AccountKeys); prob != nil {
 		return nil, prob
 	}
 
-	payload, err := parsedJWS.Verify(pubKey)
+	var payload []byte
+	var err error
+	if core.MLDSAAlgorithm(pubKey.Key) != "" {
+		payload, err = verifyMLDSAJWS(pubKey, parsedJWS)
+	} else {
+		payload, err = parsedJWS.Verify(pubKey)
+	}
 	if err != nil {
 		return nil, acme.MalformedProblem(fmt.Sprintf("JWS verification error: %s", err))
 	}
@@ -892,34 +1149,35 @@ func (wfe *WebFrontEndImpl) verifyJWS(
 		return nil, acme.BadNonceProblem("JWS has no anti-replay nonce")
 	}
 
-	// Roll a random number between 0 and 100.
-	nonceRoll := rand.Intn(100)
-	// If the nonce is not valid OR if the nonceRoll was less than the
-	// nonceErrPercent, fail with an error
-	if !wfe.nonce.validNonce(nonce) || nonceRoll < wfe.nonceErrPercent {
-		return nil, acme.BadNonceProblem(fmt.Sprintf(
-			"JWS has an invalid anti-replay nonce: %s", nonce))
+	// The nonce rejection rules match the account signing the request, if any
+	var accountID string
+	if existingAcct, err := wfe.db.GetAccountByKey(pubKey.Key); err == nil && existingAcct != nil {
+		accountID = existingAcct.ID
 	}
-
-	expectedURL := url.URL{
-		// NOTE(@cpu): ACME **REQUIRES** HTTPS and Pebble is hardcoded to offer the
-		// API over HTTPS.
-		Scheme: "https",
-		Host:   request.Host,
-		Path:   request.RequestURI,
+	err := wfe.nonce.useNonce(
+		nonce, wfe.nonceLifetime, wfe.nonceRejections, requestEndpoint(request), accountID)
+	// Roll a random number between 0 and 100. If the nonceRoll was less than
+	// the nonceErrPercent, reject a valid nonce
+	if err == nil && random.Intn(100) < wfe.nonceErrPercent {
+		err = errNonceUnknown
+	}
+	if err != nil {
+		return nil, acme.BadNonceProblem(fmt.Sprintf("%s: %s", err, nonce))
 	}
-	if expectedURL.String() != headerURL {
+
+	expectedURL := wfe.requestURL(request)
+	if !wfe.strict.matchingURL(headerURL, expectedURL) {
 		return nil, acme.MalformedProblem(fmt.Sprintf(
 			"JWS header parameter 'url' incorrect. Expected %q, got %q",
-			expectedURL.String(), headerURL))
+			expectedURL, headerURL))
 	}
 
-	// In -strict mode, verify that any JWS body that is valid JSON doesn't
+	// In strict mode, verify that any JWS body that is valid JSON doesn't
 	// include a non-empty "resource" field. This is a legacy artifiact from ACME
 	// v1. This won't catch an empty "resource" field but that would have been
 	// broken in ACMEv1 anyway and is hopefully less likely to occur in code that
 	// is updated for ACMEv2.
-	if wfe.strict {
+	if wfe.strict.rejectLegacyResource {
 		var bodyObj struct {
 			Resource string
 		}
@@ -937,62 +1195,6 @@ func (wfe *WebFrontEndImpl) verifyJWS(
 		jwk:       pubKey}, nil
 }
 
-// isASCII determines if every character in a string is encoded in
-// the ASCII character set.
-func isASCII(str string) bool {
-	for _, r := range str {
-		if r > unicode.MaxASCII {
-			return false
-		}
-	}
-	return true
-}
-
-func (wfe *WebFrontEndImpl) verifyContacts(acct acme.Account) *acme.ProblemDetails {
-	contacts := acct.Contact
-
-	// Providing no Contacts is perfectly acceptable
-	if len(contacts) == 0 {
-		return nil
-	}
-
-	if len(contacts) > maxContactsPerAcct {
-		return acme.MalformedProblem(fmt.Sprintf(
-			"too many contacts provided: %d > %d", len(contacts), maxContactsPerAcct))
-	}
-
-	for _, c := range contacts {
-		parsed, err := url.Parse(c)
-		if err != nil {
-			return acme.InvalidContactProblem(fmt.Sprintf("contact %q is invalid", c))
-		}
-		if parsed.Scheme != "mailto" {
-			return acme.UnsupportedContactProblem(fmt.Sprintf(
-				"contact method %q is not supported", parsed.Scheme))
-		}
-		email := parsed.Opaque
-		// An empty or omitted Contact array should be used instead of an empty contact
-		if email == "" {
-			return acme.InvalidContactProblem("empty contact email")
-		}
-		if !isASCII(email) {
-			return acme.InvalidContactProblem(fmt.Sprintf(
-				"contact email %q contains non-ASCII characters", email))
-		}
-		// NOTE(@cpu): ParseAddress may allow invalid emails since it supports RFC 5322
-		// display names. This is sufficient for Pebble because we don't intend to
-		// use the emails for anything and check this as a best effort for client
-		// developers to test invalid contact problems.
-		_, err = mail.ParseAddress(email)
-		if err != nil {
-			return acme.InvalidContactProblem(fmt.Sprintf(
-				"contact email %q is invalid", email))
-		}
-	}
-
-	return nil
-}
-
 func (wfe *WebFrontEndImpl) UpdateAccount(
 	ctx context.Context,
 	response http.ResponseWriter,
@@ -1005,8 +1207,9 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 
 	// updateAcctReq is the ACME account information submitted by the client
 	var updateAcctReq struct {
-		Contact []string `json:"contact"`
-		Status  string   `json:"status,omitempty"`
+		Contact   []string `json:"contact"`
+		Status    string   `json:"status,omitempty"`
+		ToSAgreed bool     `json:"termsOfServiceAgreed"`
 	}
 	var existingAcct *core.Account
 	if postData.postAsGet {
@@ -1016,10 +1219,10 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 			return
 		}
 	} else {
-		err := json.Unmarshal(postData.body, &updateAcctReq)
+		err := wfe.unmarshalBody(postData.body, &updateAcctReq)
 		if err != nil {
 			wfe.sendError(
-				acme.MalformedProblem("Error unmarshaling account update JSON body"), response)
+				acme.MalformedProblem("Error unmarshaling account update JSON body: "+err.Error()), response)
 			return
 		}
 		existingAcct, prob = wfe.getAcctByKey(postData.jwk)
@@ -1029,9 +1232,10 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 		}
 	}
 
-	// if this update contains no contacts or deactivated status,
-	// simply return the existing account and return early.
-	if updateAcctReq.Contact == nil && updateAcctReq.Status != acme.StatusDeactivated {
+	// if this update contains no contacts, deactivated status or agreement to
+	// the terms of service, simply return the existing account and return
+	// early.
+	if updateAcctReq.Contact == nil && updateAcctReq.Status != acme.StatusDeactivated && !updateAcctReq.ToSAgreed {
 		if !postData.postAsGet {
 			wfe.sendError(acme.MalformedProblem("Use POST-as-GET to retrieve account data instead of doing an empty update"), response)
 			return
@@ -1051,8 +1255,12 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 			Status:  existingAcct.Status,
 			Orders:  existingAcct.Orders,
 		},
-		Key: existingAcct.Key,
-		ID:  existingAcct.ID,
+		Key:                  existingAcct.Key,
+		ID:                   existingAcct.ID,
+		AgreedTermsOfService: existingAcct.AgreedTermsOfService,
+	}
+	if updateAcctReq.ToSAgreed {
+		newAcct.AgreedTermsOfService = wfe.termsOfServiceURL()
 	}
 
 	switch {
@@ -1079,6 +1287,9 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 			acme.MalformedProblem("Error storing updated account"), response)
 		return
 	}
+	if newAcct.Status == acme.StatusDeactivated {
+		wfe.deactivateAccountObjects(newAcct)
+	}
 
 	err = wfe.writeJSONResponse(response, http.StatusOK, newAcct)
 	if err != nil {
@@ -1087,6 +1298,23 @@ func (wfe *WebFrontEndImpl) UpdateAccount(
 	}
 }
 
+// SetWebhooks configures the webhooks notified of new orders and revoked
+// certificates. It must be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetWebhooks(webhooks *webhook.Notifier) {
+	wfe.webhooks = webhooks
+}
+
+// SetOrdersPerPage configures the number of orders listed per page of an
+// account's orders list. Zero keeps the default of three. It must be called
+// before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetOrdersPerPage(ordersPerPage int) {
+	if ordersPerPage <= 0 {
+		return
+	}
+	wfe.ordersPerPage = ordersPerPage
+	wfe.log.Printf("Configured to show %d orders per page", ordersPerPage)
+}
+
 func (wfe *WebFrontEndImpl) ListOrders(
 	ctx context.Context,
 	response http.ResponseWriter,
@@ -1164,11 +1392,15 @@ func (wfe *WebFrontEndImpl) verifyKeyRollover(
 	request *http.Request) *acme.ProblemDetails {
 	var innerContent struct {
 		Account string
-		OldKey  jose.JSONWebKey
+		OldKey  json.RawMessage
 	}
-	err := json.Unmarshal(innerPayload, &innerContent)
+	err := wfe.unmarshalBody(innerPayload, &innerContent)
 	if err != nil {
-		return acme.MalformedProblem("Error unmarshaling key roll-over inner JWS body")
+		return acme.MalformedProblem("Error unmarshaling key roll-over inner JWS body: " + err.Error())
+	}
+	oldKey, err := core.UnmarshalJWK(innerContent.OldKey)
+	if err != nil {
+		return acme.MalformedProblem("Error unmarshaling key roll-over inner JWS body: " + err.Error())
 	}
 
 	// Check account ID
@@ -1185,7 +1417,7 @@ func (wfe *WebFrontEndImpl) verifyKeyRollover(
 	}
 
 	// Verify inner key
-	if !keyDigestEquals(innerContent.OldKey, *existingAcct.Key) {
+	if !keyDigestEquals(oldKey, existingAcct.Key) {
 		return acme.MalformedProblem("Key roll-over inner JWS body JSON contains wrong old key")
 	}
 
@@ -1251,6 +1483,11 @@ func (wfe *WebFrontEndImpl) KeyRollover(
 		return
 	}
 
+	if prob := wfe.checkBlockedKey(newPubKey.Key); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	// Ok, now change account key
 	err = wfe.db.ChangeAccountKey(existingAcct, newPubKey)
 	if err != nil {
@@ -1283,10 +1520,10 @@ func (wfe *WebFrontEndImpl) NewAccount(
 
 	// newAcctReq is the ACME account information submitted by the client
 	var newAcctReq newAccountRequest
-	err := json.Unmarshal(postData.body, &newAcctReq)
+	err := wfe.unmarshalBody(postData.body, &newAcctReq)
 	if err != nil {
 		wfe.sendError(
-			acme.MalformedProblem("Error unmarshaling body JSON"), response)
+			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
 		return
 	}
 
@@ -1315,8 +1552,13 @@ func (wfe *WebFrontEndImpl) NewAccount(
 		return
 	}
 
-	if !newAcctReq.ToSAgreed {
-		response.Header().Add("Link", link(ToSURL, "terms-of-service"))
+	if prob := wfe.checkBlockedKey(postData.jwk.Key); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if !newAcctReq.ToSAgreed && !wfe.tosAgreement.Optional {
+		response.Header().Add("Link", wfe.termsOfServiceLink())
 		wfe.sendError(
 			acme.AgreementRequiredProblem(
 				"Provided account did not agree to the terms of service"),
@@ -1327,7 +1569,7 @@ func (wfe *WebFrontEndImpl) NewAccount(
 	// This function will return early with an empty keyID if no external account
 	// binding was given with the request. A request with an empty external
 	// account binding will error however if this is required by the server.
-	eab, prob := wfe.verifyEAB(newAcctReq, postData)
+	eab, eabKeyID, prob := wfe.verifyEAB(newAcctReq, postData)
 	if prob != nil {
 		wfe.sendError(prob, response)
 		return
@@ -1343,7 +1585,11 @@ func (wfe *WebFrontEndImpl) NewAccount(
 			// External account binding keyID may be nil which will be checked further on.
 			ExternalAccountBinding: eab,
 		},
-		Key: postData.jwk,
+		Key:                  postData.jwk,
+		ExternalAccountKeyID: eabKeyID,
+	}
+	if newAcctReq.ToSAgreed {
+		newAcct.AgreedTermsOfService = wfe.termsOfServiceURL()
 	}
 
 	// Verify that the contact information provided is supported & valid
@@ -1353,12 +1599,23 @@ func (wfe *WebFrontEndImpl) NewAccount(
 		return
 	}
 
+	if !wfe.checkNewAccountRateLimit(response, request) {
+		return
+	}
+
 	count, err := wfe.db.AddAccount(&newAcct)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error saving account"), response)
 		return
 	}
 	newAcct.Orders = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", ordersPath, newAcct.ID))
+	if len(wfe.delegations) > 0 {
+		if err := wfe.addDelegations(&newAcct); err != nil {
+			wfe.sendError(acme.InternalErrorProblem("Error saving account delegations"), response)
+			return
+		}
+		newAcct.Delegations = wfe.relativeEndpoint(request, delegationsPath+newAcct.ID)
+	}
 	wfe.log.Printf("There are now %d accounts in memory\n", count)
 
 	acctURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, newAcct.ID))
@@ -1380,92 +1637,133 @@ func isDNSCharacter(ch byte) bool {
 }
 
 /* TODO(@cpu): Pebble's validation of domain names is still pretty weak
- * compared to Boulder. We should consider adding:
- * 1) Checks for the # of labels, and the size of each label
- * 2) Checks against the Public Suffix List
- * 3) Checks against a configured domain blocklist
- * 4) Checks for malformed IDN, RLDH, etc
+ * compared to Boulder. Checks of labels, the Public Suffix List and configured
+ * allow and deny rules are left to the identifier policy, which allows all
+ * identifiers by default, and to the blocked domains of the store. We should
+ * consider adding:
+ * 1) Checks for malformed IDN, RLDH, etc
  */
 // verifyOrder checks that a new order is considered well formed. Light
 // validation is done on the order identifiers.
 func (wfe *WebFrontEndImpl) verifyOrder(order *core.Order) *acme.ProblemDetails {
-	// Lock the order for reading
-	order.RLock()
-	defer order.RUnlock()
-
 	// Shouldn't happen - defensive check
 	if order == nil {
 		return acme.InternalErrorProblem("Order is nil")
 	}
-	idents := order.Identifiers
+	idents := order.Snapshot().Identifiers
 	if len(idents) == 0 {
 		return acme.MalformedProblem("Order did not specify any identifiers")
 	}
+	if prob := wfe.verifyIdentifierCount(idents); prob != nil {
+		return prob
+	}
 	// Check that all of the identifiers in the new-order are DNS or IPaddress type
-	// Validity check of ipaddresses are done here.
+	// and allowed by the identifier policy, and collect the problems of every
+	// invalid identifier
+	var probs []acme.SubProblemDetails
+	rejected := true
 	for _, ident := range idents {
-		if ident.Type == acme.IdentifierIP {
-			if net.ParseIP(ident.Value) == nil {
-				return acme.MalformedProblem(fmt.Sprintf(
-					"Order included malformed IP type identifier value: %q\n",
-					ident.Value))
-			}
-			continue
+		prob := wfe.verifyIdentifier(ident)
+		if prob == nil {
+			prob = wfe.identifierPolicy.check(ident)
 		}
-		if ident.Type != acme.IdentifierDNS {
-			return acme.MalformedProblem(fmt.Sprintf(
-				"Order included unsupported type identifier: type %q, value %q",
-				ident.Type, ident.Value))
+		if prob == nil {
+			prob = wfe.checkBlockedDomain(ident)
 		}
+		if prob != nil {
+			probs = append(probs, acme.SubProblem(ident, prob))
+			rejected = rejected && prob.IsRejectedIdentifier()
+		}
+	}
+	if rejected {
+		return acme.IdentifiersProblem(acme.RejectedIdentifierProblem, "Order included rejected identifiers", probs)
+	}
+	return acme.IdentifiersProblem(acme.MalformedProblem, "Order included invalid identifiers", probs)
+}
 
-		rawDomain := ident.Value
-		if rawDomain == "" {
+// verifyIdentifier checks a single identifier of a new order. Validity check
+// of ipaddresses are done here.
+func (wfe *WebFrontEndImpl) verifyIdentifier(ident acme.Identifier) *acme.ProblemDetails {
+	if ident.Type == acme.IdentifierIP {
+		if net.ParseIP(ident.Value) == nil {
 			return acme.MalformedProblem(fmt.Sprintf(
-				"Order included DNS identifier with empty value"))
+				"Order included malformed IP type identifier value: %q\n",
+				ident.Value))
 		}
+		return nil
+	}
+	if ident.Type != acme.IdentifierDNS {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included unsupported type identifier: type %q, value %q",
+			ident.Type, ident.Value))
+	}
 
-		for _, ch := range []byte(rawDomain) {
-			if !isDNSCharacter(ch) {
-				return acme.MalformedProblem(fmt.Sprintf(
-					"Order included DNS identifier with a value containing an illegal character: %q",
-					ch))
-			}
+	if ident.AncestorDomain != "" {
+		if prob := wfe.verifyAncestorDomain(ident); prob != nil {
+			return prob
 		}
+	}
 
-		if len(rawDomain) > maxDNSIdentifierLength {
-			return acme.MalformedProblem(fmt.Sprintf(
-				"Order included DNS identifier that was longer than %d characters",
-				maxDNSIdentifierLength))
-		}
+	rawDomain := ident.Value
+	if rawDomain == "" {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included DNS identifier with empty value"))
+	}
 
-		if ip := net.ParseIP(rawDomain); ip != nil {
+	for _, ch := range []byte(rawDomain) {
+		if !isDNSCharacter(ch) {
 			return acme.MalformedProblem(fmt.Sprintf(
-				"Order included a DNS identifier with an IP address value: %q\n",
-				rawDomain))
+				"Order included DNS identifier with a value containing an illegal character: %q",
+				ch))
 		}
+	}
 
-		if strings.HasSuffix(rawDomain, ".") {
+	if len(rawDomain) > maxDNSIdentifierLength {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included DNS identifier that was longer than %d characters",
+			maxDNSIdentifierLength))
+	}
+	if prob := wfe.verifyIdentifierLength(rawDomain); prob != nil {
+		return prob
+	}
+
+	if ip := net.ParseIP(rawDomain); ip != nil {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included a DNS identifier with an IP address value: %q\n",
+			rawDomain))
+	}
+
+	if core.IsOnionName(rawDomain) {
+		if _, err := core.OnionPublicKey(strings.TrimPrefix(rawDomain, "*.")); err != nil {
 			return acme.MalformedProblem(fmt.Sprintf(
-				"Order included a DNS identifier with a value ending in a period: %q\n",
-				rawDomain))
+				"Order included an invalid onion name %q: %s", rawDomain, err))
 		}
+	}
 
-		// If there is a wildcard character in the ident value there should be only
-		// *one* instance
-		if strings.Count(rawDomain, "*") > 1 {
+	if strings.HasSuffix(rawDomain, ".") {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included a DNS identifier with a value ending in a period: %q\n",
+			rawDomain))
+	}
+
+	// If there is a wildcard character in the ident value there should be only
+	// *one* instance
+	if strings.Count(rawDomain, "*") > 1 {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Order included DNS type identifier with illegal wildcard value: "+
+				"too many wildcards %q",
+			rawDomain))
+	} else if strings.Count(rawDomain, "*") == 1 {
+		// If there is one wildcard character it should be the only character in
+		// the leftmost label.
+		if !strings.HasPrefix(rawDomain, "*.") {
 			return acme.MalformedProblem(fmt.Sprintf(
 				"Order included DNS type identifier with illegal wildcard value: "+
-					"too many wildcards %q",
+					"wildcard isn't leftmost prefix %q",
 				rawDomain))
-		} else if strings.Count(rawDomain, "*") == 1 {
-			// If there is one wildcard character it should be the only character in
-			// the leftmost label.
-			if !strings.HasPrefix(rawDomain, "*.") {
-				return acme.MalformedProblem(fmt.Sprintf(
-					"Order included DNS type identifier with illegal wildcard value: "+
-						"wildcard isn't leftmost prefix %q",
-					rawDomain))
-			}
+		}
+		if prob := wfe.verifyWildcard(rawDomain); prob != nil {
+			return prob
 		}
 	}
 	return nil
@@ -1474,31 +1772,53 @@ func (wfe *WebFrontEndImpl) verifyOrder(order *core.Order) *acme.ProblemDetails
 // makeAuthorizations populates an order with new authz's. The request parameter
 // is required to make the authz URL's absolute based on the request host
 func (wfe *WebFrontEndImpl) makeAuthorizations(order *core.Order, request *http.Request) error {
+	_, span := wfe.tracer.Start(request.Context(), "wfe.makeAuthorizations", trace.KindInternal)
+	defer span.End()
+
 	var auths []string
 	var authObs []*core.Authorization
+	// Identifiers may share an authorization when they are authorized by the
+	// same ancestor domain
+	seenAuthzs := make(map[*core.Authorization]bool)
+	ancestorAuthzs := make(map[string]*core.Authorization)
 
-	// Lock the order for reading
-	order.RLock()
+	snapshot := order.Snapshot()
 	// Add one authz for each name in the order's parsed CSR
-	for _, name := range order.Identifiers {
-		now := time.Now().UTC()
+	for _, name := range snapshot.Identifiers {
+		now := wfe.clk.Now().UTC()
 		expires := now.Add(pendingAuthzExpire)
 		ident := acme.Identifier{
 			Type:  name.Type,
 			Value: name.Value,
 		}
-		// If there is an existing valid authz for this identifier, we can reuse it
-		authz := wfe.db.FindValidAuthorization(order.AccountID, ident)
+		subdomainAuth := name.AncestorDomain != ""
+		var authz *core.Authorization
+		if subdomainAuth {
+			// The authz is for the ancestor domain and authorizes its subdomains
+			ident.Value = name.AncestorDomain
+			authz = ancestorAuthzs[ident.Value]
+			if authz == nil {
+				authz = wfe.db.FindValidSubdomainAuthorization(snapshot.AccountID, ident)
+			}
+		} else {
+			// If there is an existing valid authz for this identifier, or for one of
+			// its ancestors that allows subdomains, we can reuse it
+			authz = wfe.db.FindValidAuthorization(snapshot.AccountID, ident)
+			if authz == nil && ident.Type == acme.IdentifierDNS {
+				authz = wfe.findAncestorAuthorization(snapshot.AccountID, ident.Value)
+			}
+		}
 		// Otherwise create a new pending authz (and randomly not)
-		if authz == nil || rand.Intn(100) > wfe.authzReusePercent {
+		if authz == nil || (!seenAuthzs[authz] && !wfe.reuseAuthorization(name, authz)) {
 			authz = &core.Authorization{
 				ID:          newToken(),
 				ExpiresDate: expires,
 				Order:       order,
 				Authorization: acme.Authorization{
-					Status:     acme.StatusPending,
-					Identifier: ident,
-					Expires:    expires.UTC().Format(time.RFC3339),
+					Status:               acme.StatusPending,
+					Identifier:           ident,
+					Expires:              expires.UTC().Format(time.RFC3339),
+					SubdomainAuthAllowed: subdomainAuth,
 				},
 			}
 			authz.URL = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
@@ -1514,19 +1834,24 @@ func (wfe *WebFrontEndImpl) makeAuthorizations(order *core.Order, request *http.
 			}
 			wfe.log.Printf("There are now %d authorizations in the db\n", count)
 		}
+		if subdomainAuth {
+			ancestorAuthzs[ident.Value] = authz
+		}
+		if seenAuthzs[authz] {
+			continue
+		}
+		seenAuthzs[authz] = true
 
 		authzURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
 		auths = append(auths, authzURL)
 		authObs = append(authObs, authz)
 	}
-	// Unlock the order from reading
-	order.RUnlock()
-
-	// Lock the order for writing & update the order's authorizations
-	order.Lock()
-	order.Authorizations = auths
-	order.AuthorizationObjects = authObs
-	order.Unlock()
+	// Update the order's authorizations
+	order.Update(func(order *core.Order) {
+		order.Authorizations = auths
+		order.AuthorizationObjects = authObs
+	})
+	span.SetAttribute("pebble.authorizations", len(authObs))
 	return nil
 }
 
@@ -1546,6 +1871,9 @@ func (wfe *WebFrontEndImpl) makeChallenge(
 		},
 		Authz: authz,
 	}
+	if chalType == acme.ChallengeOnionCSR01 {
+		chal.Nonce = newToken()
+	}
 
 	// Add it to the in-memory database
 	_, err := wfe.db.AddChallenge(chal)
@@ -1559,25 +1887,29 @@ func (wfe *WebFrontEndImpl) makeChallenge(
 // is required to make the challenge URL's absolute based on the request host
 func (wfe *WebFrontEndImpl) makeChallenges(authz *core.Authorization, request *http.Request) error {
 	var chals []*core.Challenge
+	enabledChallenges, disabled := wfe.offeredChallengeTypes(authz)
 
-	// Authorizations for a wildcard identifier only get a DNS-01 challenges to
-	// match Boulder/Let's Encrypt wildcard issuance policy
-	if strings.HasPrefix(authz.Identifier.Value, "*.") {
-		chal, err := wfe.makeChallenge(acme.ChallengeDNS01, authz, request)
-		if err != nil {
-			return err
+	// Authorizations for onion names only get an onion-csr-01 challenge since
+	// Pebble can't reach onion services.
+	if authz.Identifier.Type == acme.IdentifierDNS && core.IsOnionName(authz.Identifier.Value) {
+		if !disabled[acme.ChallengeOnionCSR01] {
+			chal, err := wfe.makeChallenge(acme.ChallengeOnionCSR01, authz, request)
+			if err != nil {
+				return err
+			}
+			chals = []*core.Challenge{chal}
 		}
-		chals = []*core.Challenge{chal}
 	} else {
-		// IP addresses get HTTP-01 and TLS-ALPN challenges
-		var enabledChallenges []string
-		if authz.Identifier.Type == acme.IdentifierIP {
-			enabledChallenges = []string{acme.ChallengeHTTP01, acme.ChallengeTLSALPN01}
-		} else {
-			// Non-wildcard, non-IP identifier authorizations get all of the enabled challenge types
-			enabledChallenges = []string{acme.ChallengeHTTP01, acme.ChallengeTLSALPN01, acme.ChallengeDNS01}
-		}
+		// By default authorizations for a wildcard identifier only get a DNS-01
+		// challenges to match Boulder/Let's Encrypt wildcard issuance policy. The
+		// same applies to subdomain authorizations since only DNS-01 proves
+		// control of a whole domain namespace. IP addresses get HTTP-01 and
+		// TLS-ALPN challenges, and other identifiers get all of the enabled
+		// challenge types.
 		for _, chalType := range enabledChallenges {
+			if disabled[chalType] {
+				continue
+			}
 			chal, err := wfe.makeChallenge(chalType, authz, request)
 			if err != nil {
 				return err
@@ -1586,10 +1918,22 @@ func (wfe *WebFrontEndImpl) makeChallenges(authz *core.Authorization, request *h
 		}
 	}
 
-	// Lock the authorization for writing to update the challenges
-	authz.Lock()
-	authz.Challenges = chals
-	authz.Unlock()
+	// Additional challenge types are offered besides the built-in ones
+	for _, t := range wfe.challengeTypes {
+		if !t.offers(authz) || disabled[t.Name] {
+			continue
+		}
+		chal, err := wfe.makeChallenge(t.Name, authz, request)
+		if err != nil {
+			return err
+		}
+		chals = append(chals, chal)
+	}
+
+	// Update the authorization's challenges
+	authz.Update(func(authz *core.Authorization) {
+		authz.Challenges = chals
+	})
 	return nil
 }
 
@@ -1610,24 +1954,41 @@ func (wfe *WebFrontEndImpl) NewOrder(
 		wfe.sendError(prob, response)
 		return
 	}
+	if prob := wfe.checkTermsOfService(existingReg, response); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
 
 	// Unpack the order request body
 	var newOrder acme.Order
-	err := json.Unmarshal(postData.body, &newOrder)
+	err := wfe.unmarshalBody(postData.body, &newOrder)
 	if err != nil {
 		wfe.sendError(
 			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
 		return
 	}
+	auditIdentifiers(request, newOrder.Identifiers)
 
 	var orderDNSs []string
 	var orderIPs []net.IP
+	var malformedIPs []acme.Identifier
+	ancestorDomains := make(map[string]string)
 	for _, ident := range newOrder.Identifiers {
 		switch ident.Type {
 		case acme.IdentifierDNS:
 			orderDNSs = append(orderDNSs, ident.Value)
+			if ident.AncestorDomain != "" {
+				ancestorDomains[strings.ToLower(ident.Value)] = strings.ToLower(ident.AncestorDomain)
+			}
 		case acme.IdentifierIP:
-			orderIPs = append(orderIPs, net.ParseIP(ident.Value))
+			ip := net.ParseIP(ident.Value)
+			if ip == nil {
+				// Malformed IP addresses are kept as submitted for verifyOrder to
+				// report
+				malformedIPs = append(malformedIPs, ident)
+				continue
+			}
+			orderIPs = append(orderIPs, ip)
 		default:
 			wfe.sendError(acme.MalformedProblem(
 				fmt.Sprintf("Order includes unknown identifier type %s", ident.Type)), response)
@@ -1638,12 +1999,17 @@ func (wfe *WebFrontEndImpl) NewOrder(
 	orderIPs = uniqueIPs(orderIPs)
 	var uniquenames []acme.Identifier
 	for _, name := range orderDNSs {
-		uniquenames = append(uniquenames, acme.Identifier{Value: name, Type: acme.IdentifierDNS})
+		uniquenames = append(uniquenames, acme.Identifier{
+			Value:          name,
+			Type:           acme.IdentifierDNS,
+			AncestorDomain: ancestorDomains[name],
+		})
 	}
 	for _, ip := range orderIPs {
 		uniquenames = append(uniquenames, acme.Identifier{Value: ip.String(), Type: acme.IdentifierIP})
 	}
-	expires := time.Now().AddDate(0, 0, 1)
+	uniquenames = append(uniquenames, malformedIPs...)
+	expires := wfe.clk.Now().AddDate(0, 0, 1)
 	order := &core.Order{
 		ID:        newToken(),
 		AccountID: existingReg.ID,
@@ -1655,16 +2021,79 @@ func (wfe *WebFrontEndImpl) NewOrder(
 			Identifiers: uniquenames,
 			NotBefore:   newOrder.NotBefore,
 			NotAfter:    newOrder.NotAfter,
+			AutoRenewal: newOrder.AutoRenewal,
+			Profile:     newOrder.Profile,
+			Replaces:    newOrder.Replaces,
 		},
 		ExpiresDate: expires,
 	}
 
+	// Orders that don't select a profile use the default profile, if the CA
+	// offers one
+	if order.Profile == "" && wfe.ca.GetProfile(ca.DefaultProfile) != nil {
+		order.Profile = ca.DefaultProfile
+	}
+	if order.Profile != "" && wfe.ca.GetProfile(order.Profile) == nil {
+		wfe.sendError(acme.InvalidProfileProblem(fmt.Sprintf(
+			"Order requested unknown profile %q", order.Profile)), response)
+		return
+	}
+
+	// Verify the auto-renewal schedule of ACME STAR recurrent orders, or the
+	// requested validity period of other orders
+	if order.IsRecurrent() {
+		if prob := wfe.verifyAutoRenewal(order); prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	} else if prob := wfe.verifyValidity(order); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	// Verify the details of the order before creating authorizations
 	if err := wfe.verifyOrder(order); err != nil {
 		wfe.sendError(err, response)
 		return
 	}
 
+	// Verify the certificate the order replaces, if any
+	if order.Replaces != "" {
+		if prob := wfe.verifyReplaces(order); prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	}
+
+	// Verify the STAR delegation the order was placed for, if any
+	if newOrder.Delegation != "" {
+		if prob := wfe.verifyDelegation(order, newOrder.Delegation); prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	}
+
+	// Verify the order is allowed by the policy of the External Account
+	// Binding key of the account, if any
+	if prob := wfe.checkEABOrderPolicy(existingReg, order); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	// Reject orders for identifiers the account is paused for
+	if prob := wfe.checkPaused(order, request); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if !wfe.checkPendingLimits(response, order) {
+		return
+	}
+
+	if !wfe.checkNewOrderRateLimit(response, existingReg.ID) {
+		return
+	}
+
 	// Create the authorizations for the order
 	err = wfe.makeAuthorizations(order, request)
 	if err != nil {
@@ -1682,6 +2111,11 @@ func (wfe *WebFrontEndImpl) NewOrder(
 	}
 	wfe.log.Printf("Added order %q to the db\n", order.ID)
 	wfe.log.Printf("There are now %d orders in the db\n", count)
+	wfe.webhooks.Notify(webhook.OrderCreated, webhook.OrderData{
+		ID:          order.ID,
+		AccountID:   order.AccountID,
+		Identifiers: order.Identifiers,
+	})
 
 	// Get the stored order back from the DB. The memorystore will set the order's
 	// status for us.
@@ -1704,13 +2138,11 @@ func (wfe *WebFrontEndImpl) NewOrder(
 func (wfe *WebFrontEndImpl) orderForDisplay(
 	order *core.Order,
 	request *http.Request) acme.Order {
-	// Lock the order for reading
-	order.RLock()
-	defer order.RUnlock()
+	snapshot := order.Snapshot()
 
-	// Copy the initial OrderRequest from the internal order object to mutate and
-	// use as the result.
-	result := order.Order
+	// Copy the initial OrderRequest from a snapshot of the internal order object
+	// to mutate and use as the result.
+	result := snapshot.Order
 
 	// Randomize the order of the order authorization URLs as well as the order's
 	// identifiers. ACME draft Section 7.4 "Applying for Certificate Issuance"
@@ -1718,23 +2150,36 @@ func (wfe *WebFrontEndImpl) orderForDisplay(
 	//   Clients SHOULD NOT make any assumptions about the sort order of
 	//   "identifiers" or "authorizations" elements in the returned order
 	//   object.
-	rand.Shuffle(len(result.Authorizations), func(i, j int) {
+	random.Shuffle(len(result.Authorizations), func(i, j int) {
 		result.Authorizations[i], result.Authorizations[j] = result.Authorizations[j], result.Authorizations[i]
 	})
-	rand.Shuffle(len(result.Identifiers), func(i, j int) {
+	random.Shuffle(len(result.Identifiers), func(i, j int) {
 		result.Identifiers[i], result.Identifiers[j] = result.Identifiers[j], result.Identifiers[i]
 	})
 
 	// Populate a finalization URL for this order
 	result.Finalize = wfe.relativeEndpoint(request,
-		fmt.Sprintf("%s%s", orderFinalizePath, order.ID))
+		fmt.Sprintf("%s%s", orderFinalizePath, snapshot.ID))
+
+	if snapshot.DelegationObject != nil {
+		result.Delegation = wfe.relativeEndpoint(request, delegationPath+snapshot.DelegationObject.ID)
+	}
+
+	// Recurrent orders have a star-certificate URL that always serves the most
+	// recently issued certificate instead of a certificate URL
+	if snapshot.IsRecurrent() {
+		if snapshot.CertificateObject != nil {
+			result.StarCertificate = wfe.relativeEndpoint(request, starCertPath+snapshot.ID)
+		}
+		return result
+	}
 
 	// If the order has a cert ID then set the certificate URL by constructing
 	// a relative path based on the HTTP request & the cert ID
-	if order.CertificateObject != nil {
+	if snapshot.CertificateObject != nil {
 		result.Certificate = wfe.relativeEndpoint(
 			request,
-			certPath+order.CertificateObject.ID)
+			certPath+snapshot.CertificateObject.ID)
 	}
 
 	return result
@@ -1750,6 +2195,13 @@ func (wfe *WebFrontEndImpl) Order(
 		wfe.sendError(prob, response)
 		return
 	}
+
+	// A POST with a body is a request to cancel a recurrent order
+	if !postData.postAsGet {
+		wfe.cancelOrder(postData, response, request)
+		return
+	}
+
 	account, prob := wfe.validPOSTAsGET(postData)
 	if prob != nil {
 		wfe.sendError(prob, response)
@@ -1762,14 +2214,10 @@ func (wfe *WebFrontEndImpl) Order(
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
-	order.RLock()
-	orderAccountID := order.AccountID
-	defer order.RUnlock()
-
 	// If the request was authenticated we need to make sure that the
 	// authenticated account owns the order being requested
 	if account != nil {
-		if orderAccountID != account.ID {
+		if order.Snapshot().AccountID != account.ID {
 			response.WriteHeader(http.StatusForbidden)
 			wfe.sendError(acme.UnauthorizedProblem(
 				"Account that authenticated the request does not own the specified order"), response)
@@ -1779,6 +2227,7 @@ func (wfe *WebFrontEndImpl) Order(
 
 	// Prepare the order for display as JSON
 	orderReq := wfe.orderForDisplay(order, request)
+	wfe.setOrderRetryAfter(response, orderReq.Status)
 	err := wfe.writeJSONResponse(response, http.StatusOK, orderReq)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshaling order"), response)
@@ -1804,6 +2253,10 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 		wfe.sendError(prob, response)
 		return
 	}
+	if prob := wfe.checkTermsOfService(existingAcct, response); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
 
 	// Find the order specified by the order ID
 	orderID := strings.TrimPrefix(request.URL.Path, orderFinalizePath)
@@ -1815,16 +2268,15 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 		return
 	}
 
-	// Lock the order for reading the properties we need to check
-	existingOrder.RLock()
-	orderAccountID := existingOrder.AccountID
-	orderStatus := existingOrder.Status
-	orderExpires := existingOrder.ExpiresDate
-	orderIdentifiers := existingOrder.Identifiers
-	// And then immediately unlock it again - we don't defer() here because
-	// `maybeIssue` will also acquire a read lock and we call that before
-	// returning
-	existingOrder.RUnlock()
+	// Read the properties we need to check from a snapshot of the order
+	snapshot := existingOrder.Snapshot()
+	orderAccountID := snapshot.AccountID
+	orderStatus := snapshot.Status
+	orderExpires := snapshot.ExpiresDate
+	orderIdentifiers := snapshot.Identifiers
+	orderDelegation := snapshot.DelegationObject
+	orderProfile := snapshot.Profile
+	auditIdentifiers(request, orderIdentifiers)
 
 	if orderAccountID != existingAcct.ID {
 		response.WriteHeader(http.StatusForbidden)
@@ -1841,7 +2293,7 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 	}
 
 	// The existing order must not be expired
-	if orderExpires.Before(time.Now()) {
+	if orderExpires.Before(wfe.clk.Now()) {
 		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf(
 			"Order %q expired %s", orderID, orderExpires)), response)
 		return
@@ -1852,7 +2304,7 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 	var finalizeMessage struct {
 		CSR string
 	}
-	err := json.Unmarshal(postData.body, &finalizeMessage)
+	err := wfe.unmarshalBody(postData.body, &finalizeMessage)
 	if err != nil {
 		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
 			"Error unmarshaling finalize order request body: %s", err.Error())), response)
@@ -1873,6 +2325,26 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 		return
 	}
 
+	if prob := wfe.verifyCommonNameLength(parsedCSR.Subject.CommonName); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if prob := wfe.checkCSRKey(parsedCSR.PublicKey); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if prob := wfe.csrPolicy.checkCSR(parsedCSR); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if prob := wfe.checkBlockedKey(parsedCSR.PublicKey); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	// split order identifiers per types
 	var orderDNSs []string
 	var orderIPs []net.IP
@@ -1896,32 +2368,67 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 	csrDNSs := uniqueLowerNames(parsedCSR.DNSNames)
 	csrIPs := uniqueIPs(parsedCSR.IPAddresses)
 
-	// Check that the CSR has the same number of names as the initial order contained
-	if len(csrDNSs) != len(orderDNSs) {
-		wfe.sendError(acme.UnauthorizedProblem(
-			"Order includes different number of DNSnames identifiers than CSR specifies"), response)
-		return
+	// Check that the CSR's names match the order names exactly, collecting
+	// a subproblem for every missing or additional name
+	var probs []acme.SubProblemDetails
+	inCSR := make(map[string]bool, len(csrDNSs)+len(csrIPs))
+	for _, name := range csrDNSs {
+		inCSR[name] = true
 	}
-	if len(csrIPs) != len(orderIPs) {
-		wfe.sendError(acme.UnauthorizedProblem(
-			"Order includes different number of IP address identifiers than CSR specifies"), response)
+	for _, IP := range csrIPs {
+		inCSR[IP.String()] = true
+	}
+	inOrder := make(map[string]bool, len(orderDNSs)+len(orderIPs))
+	for _, name := range orderDNSs {
+		inOrder[name] = true
+		if !inCSR[name] {
+			probs = append(probs, acme.SubProblem(
+				acme.Identifier{Type: acme.IdentifierDNS, Value: name},
+				acme.UnauthorizedProblem(fmt.Sprintf("CSR is missing Order domain %q", name))))
+		}
+	}
+	for _, IP := range orderIPs {
+		inOrder[IP.String()] = true
+		if !inCSR[IP.String()] {
+			probs = append(probs, acme.SubProblem(
+				acme.Identifier{Type: acme.IdentifierIP, Value: IP.String()},
+				acme.UnauthorizedProblem(fmt.Sprintf("CSR is missing Order IP %q", IP))))
+		}
+	}
+	for _, name := range csrDNSs {
+		if !inOrder[name] {
+			probs = append(probs, acme.SubProblem(
+				acme.Identifier{Type: acme.IdentifierDNS, Value: name},
+				acme.UnauthorizedProblem(fmt.Sprintf("CSR includes domain %q that is not in the Order", name))))
+		}
+	}
+	for _, IP := range csrIPs {
+		if !inOrder[IP.String()] {
+			probs = append(probs, acme.SubProblem(
+				acme.Identifier{Type: acme.IdentifierIP, Value: IP.String()},
+				acme.UnauthorizedProblem(fmt.Sprintf("CSR includes IP %q that is not in the Order", IP))))
+		}
+	}
+	if prob := acme.IdentifiersProblem(
+		acme.UnauthorizedProblem, "CSR names do not match the Order identifiers", probs); prob != nil {
+		wfe.sendError(prob, response)
 		return
 	}
 
-	// Check that the CSR's names match the order names exactly
-	for i, name := range orderDNSs {
-		if name != csrDNSs[i] {
-			wfe.sendError(acme.UnauthorizedProblem(
-				fmt.Sprintf("CSR is missing Order domain %q", name)), response)
+	// Delegated orders must comply with the delegation's CSR template
+	if orderDelegation != nil {
+		if prob := verifyCSRTemplate(orderDelegation.CSRTemplate, parsedCSR); prob != nil {
+			wfe.sendError(prob, response)
 			return
 		}
 	}
-	for i, IP := range orderIPs {
-		if !csrIPs[i].Equal(IP) {
-			wfe.sendError(acme.UnauthorizedProblem(
-				fmt.Sprintf("CSR is missing Order IP %q", IP)), response)
-			return
-		}
+
+	// The order's profile may be configured to reject must-staple requests
+	profile := wfe.ca.GetProfile(orderProfile)
+	if profile != nil && profile.CSRMustStaple == ca.CSRMustStapleReject && ca.RequestsMustStaple(parsedCSR) {
+		wfe.sendError(acme.BadCSRProblem(fmt.Sprintf(
+			"CSR requests OCSP must-staple, which is not allowed by profile %q", orderProfile)), response)
+		return
 	}
 
 	// No account key signing RFC8555 Section 11.1
@@ -1931,24 +2438,23 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 		return
 	}
 
-	// Lock and update the order with the parsed CSR and the began processing
-	// state.
-	existingOrder.Lock()
-	existingOrder.ParsedCSR = parsedCSR
-	existingOrder.BeganProcessing = true
-	existingOrder.Unlock()
+	if !wfe.checkCertificatesRateLimit(response, orderDNSs) {
+		return
+	}
 
-	// Ask the CA to complete the order in a separate goroutine.
+	// Update the order with the parsed CSR and the began processing state, and
+	// set it to processing before displaying it to the user. The finalize
+	// workers ask the CA to complete it.
+	if !wfe.beginProcessing(detachRequest(request.Context()), response, existingOrder, parsedCSR) {
+		return
+	}
 	wfe.log.Printf("Order %s is fully authorized. Processing finalization", orderID)
-	go wfe.ca.CompleteOrder(existingOrder)
-
-	// Set the existingOrder to processing before displaying to the user
-	existingOrder.Status = acme.StatusProcessing
 
 	// Prepare the order for display as JSON
 	orderReq := wfe.orderForDisplay(existingOrder, request)
 	orderURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", orderPath, existingOrder.ID))
 	response.Header().Add("Location", orderURL)
+	wfe.setOrderRetryAfter(response, orderReq.Status)
 	err = wfe.writeJSONResponse(response, http.StatusOK, orderReq)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshaling order"), response)
@@ -1957,11 +2463,11 @@ func (wfe *WebFrontEndImpl) FinalizeOrder(
 }
 
 // prepAuthorizationForDisplay prepares the provided acme.Authorization for
-// display to an ACME client. It assumes the `authz` is already locked for
-// reading by the caller.
-func prepAuthorizationForDisplay(authz *core.Authorization) acme.Authorization {
+// display to an ACME client at time now.
+func prepAuthorizationForDisplay(authz *core.Authorization, now time.Time) acme.Authorization {
+	snapshot := authz.Snapshot()
 	// Copy the authz to mutate and return
-	result := authz.Authorization
+	result := snapshot.Authorization
 	identVal := result.Identifier.Value
 
 	// If the authorization identifier has a wildcard in the value, remove it and
@@ -1974,21 +2480,26 @@ func prepAuthorizationForDisplay(authz *core.Authorization) acme.Authorization {
 	// Build a list of plain acme.Challenges to display using the core.Challenge
 	// objects from the authorization.
 	chals := make([]acme.Challenge, 0)
-	for _, c := range authz.Challenges {
-		c.RLock()
+	for _, c := range snapshot.Challenges {
+		c := c.Snapshot()
 		// If the authz isn't pending then we need to filter the challenges displayed
 		// to only those that were used to make the authz valid || invalid.
 		if result.Status != acme.StatusPending && (c.Error == nil && c.Status != acme.StatusValid) {
 			continue
 		}
 		chals = append(chals, c.Challenge)
-		c.RUnlock()
 	}
 	result.Challenges = chals
 
+	// Pending and valid authorizations expire at their expiry date
+	if (result.Status == acme.StatusPending || result.Status == acme.StatusValid) &&
+		snapshot.ExpiresDate.Before(now) {
+		result.Status = acme.StatusExpired
+	}
+
 	// Randomize the order of the challenges in the returned authorization.
 	// Clients should not make any assumptions about the sort order.
-	rand.Shuffle(len(result.Challenges), func(i, j int) {
+	random.Shuffle(len(result.Challenges), func(i, j int) {
 		result.Challenges[i], result.Challenges[j] = result.Challenges[j], result.Challenges[i]
 	})
 
@@ -2014,12 +2525,9 @@ func (wfe *WebFrontEndImpl) Authz(
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
+	auditIdentifiers(request, []acme.Identifier{authz.Snapshot().Identifier})
 
-	authz.Lock()
-	defer authz.Unlock()
-	authz.Order.RLock()
-	orderAcctID := authz.Order.AccountID
-	authz.Order.RUnlock()
+	orderAcctID := authz.Order.Snapshot().AccountID
 
 	// If the postData is not a POST-as-GET, treat this as case A) and update
 	// the authorization based on the postData
@@ -2039,7 +2547,7 @@ func (wfe *WebFrontEndImpl) Authz(
 		var deactivateRequest struct {
 			Status string
 		}
-		err := json.Unmarshal(postData.body, &deactivateRequest)
+		err := wfe.unmarshalBody(postData.body, &deactivateRequest)
 		if err != nil {
 			wfe.sendError(acme.MalformedProblem(
 				fmt.Sprintf("Malformed authorization update: %s",
@@ -2053,7 +2561,9 @@ func (wfe *WebFrontEndImpl) Authz(
 					deactivateRequest.Status)), response)
 			return
 		}
-		authz.Status = acme.StatusDeactivated
+		authz.Update(func(authz *core.Authorization) {
+			authz.Status = acme.StatusDeactivated
+		})
 	} else {
 		// Otherwise this was a POST-as-GET request and we need to verify it
 		// accordingly and ensure the authorized account owns the authorization
@@ -2073,10 +2583,9 @@ func (wfe *WebFrontEndImpl) Authz(
 		}
 	}
 
-	err := wfe.writeJSONResponse(
-		response,
-		http.StatusOK,
-		prepAuthorizationForDisplay(authz))
+	authzReq := prepAuthorizationForDisplay(authz, wfe.clk.Now())
+	wfe.setAuthzRetryAfter(response, authzReq.Status)
+	err := wfe.writeJSONResponse(response, http.StatusOK, authzReq)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshaling authz"), response)
 		return
@@ -2102,6 +2611,7 @@ func (wfe *WebFrontEndImpl) Challenge(
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
+	auditIdentifiers(request, []acme.Identifier{chal.Authz.Snapshot().Identifier})
 
 	// If the post isn't a POST-as-GET its case A)
 	var account *core.Account
@@ -2117,18 +2627,14 @@ func (wfe *WebFrontEndImpl) Challenge(
 		}
 	}
 
-	// Lock the challenge for reading in order to write the response
-	chal.RLock()
-	defer chal.RUnlock()
-
-	if chal.Authz.Order.AccountID != account.ID {
+	if chal.Authz.Order.Snapshot().AccountID != account.ID {
 		response.WriteHeader(http.StatusUnauthorized)
 		wfe.sendError(acme.UnauthorizedProblem(
 			"Account authenticating request is not the owner of the challenge"), response)
 		return
 	}
 
-	err := wfe.writeJSONResponse(response, http.StatusOK, chal.Challenge)
+	err := wfe.writeJSONResponse(response, http.StatusOK, chal.Snapshot().Challenge)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshaling challenge"), response)
 		return
@@ -2156,9 +2662,7 @@ func (wfe *WebFrontEndImpl) getAcctByKey(key crypto.PublicKey) (*core.Account, *
 
 func (wfe *WebFrontEndImpl) validateChallengeUpdate(
 	chal *core.Challenge) (*core.Authorization, *acme.ProblemDetails) {
-	// Lock the challenge for reading to do validation
-	chal.RLock()
-	defer chal.RUnlock()
+	chal = chal.Snapshot()
 
 	// Check that the existing challenge is Pending
 	if chal.Status != acme.StatusPending {
@@ -2175,11 +2679,9 @@ func (wfe *WebFrontEndImpl) validateChallengeUpdate(
 // 2) not expired
 // 3) associated to an order
 // The associated order is returned when no problems are found to avoid needing
-// another RLock() for the caller to get the order pointer later.
+// another snapshot for the caller to get the order pointer later.
 func (wfe *WebFrontEndImpl) validateAuthzForChallenge(authz *core.Authorization) (*core.Order, *acme.ProblemDetails) {
-	// Lock the authz for reading
-	authz.RLock()
-	defer authz.RUnlock()
+	authz = authz.Snapshot()
 
 	ident := authz.Identifier
 	if ident.Type != acme.IdentifierDNS && ident.Type != acme.IdentifierIP {
@@ -2187,7 +2689,7 @@ func (wfe *WebFrontEndImpl) validateAuthzForChallenge(authz *core.Authorization)
 			fmt.Sprintf("Authorization identifier was type %s, only %s and %s are supported",
 				ident.Type, acme.IdentifierDNS, acme.IdentifierIP))
 	}
-	now := time.Now()
+	now := wfe.clk.Now()
 	if now.After(authz.ExpiresDate) {
 		return nil, acme.MalformedProblem(
 			fmt.Sprintf("Authorization expired %s",
@@ -2212,14 +2714,35 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		wfe.sendError(prob, response)
 		return
 	}
+	if prob := wfe.checkTermsOfService(existingAcct, response); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	chalID := strings.TrimPrefix(request.URL.Path, challengePath)
+	existingChal := wfe.db.GetChallengeByID(chalID)
+	if existingChal == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	chalType := existingChal.Snapshot().Type
 
 	// In strict mode we reject any challenge POST with a body other than `{}`.
 	// This matches RFC 8555 Section 7.5.1 and the ACME challenge types that
 	// Pebble has implemented. Per ACME errata 5729[0] it may not be true for
-	// extensions to ACME that add new challenge types.
+	// extensions to ACME that add new challenge types, like onion-csr-01 that
+	// carries a CSR in the POST body.
 	//
 	// [0]: https://www.rfc-editor.org/errata/eid5729
-	if wfe.strict && !bytes.Equal(postData.body, []byte("{}")) {
+	var onionCSR []byte
+	if chalType == acme.ChallengeOnionCSR01 {
+		onionCSR, prob = parseOnionCSRResponse(postData.body)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	} else if wfe.strict.exactChallengeBody && !bytes.Equal(postData.body, []byte("{}")) {
 		wfe.sendError(
 			acme.MalformedProblem(`challenge initiation POST JWS body was not "{}"`), response)
 		return
@@ -2229,10 +2752,10 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		var chalResp struct {
 			KeyAuthorization *string
 		}
-		err := json.Unmarshal(postData.body, &chalResp)
+		err := wfe.unmarshalBody(postData.body, &chalResp)
 		if err != nil {
 			wfe.sendError(
-				acme.MalformedProblem("Error unmarshaling body JSON"), response)
+				acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
 			return
 		}
 
@@ -2250,13 +2773,6 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		}
 	}
 
-	chalID := strings.TrimPrefix(request.URL.Path, challengePath)
-	existingChal := wfe.db.GetChallengeByID(chalID)
-	if existingChal == nil {
-		response.WriteHeader(http.StatusNotFound)
-		return
-	}
-
 	authz, prob := wfe.validateChallengeUpdate(existingChal)
 	if prob != nil {
 		wfe.sendError(prob, response)
@@ -2268,11 +2784,7 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		return
 	}
 
-	authz.RLock()
-	authz.Order.RLock()
-	orderAcctID := authz.Order.AccountID
-	authz.Order.RUnlock()
-	authz.RUnlock()
+	orderAcctID := authz.Order.Snapshot().AccountID
 
 	if orderAcctID != existingAcct.ID {
 		response.WriteHeader(http.StatusUnauthorized)
@@ -2287,12 +2799,9 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		return
 	}
 
-	// Lock the order for reading to check the expiry date
-	existingOrder.RLock()
-	expiry := existingOrder.ExpiresDate
-	existingOrder.RUnlock()
+	expiry := existingOrder.Snapshot().ExpiresDate
 
-	now := time.Now()
+	now := wfe.clk.Now()
 	if now.After(expiry) {
 		wfe.sendError(
 			acme.MalformedProblem(fmt.Sprintf("order expired %s",
@@ -2300,10 +2809,11 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		return
 	}
 
-	// Lock the authorization to get the identifier value
-	authz.RLock()
-	ident := authz.Identifier
-	authz.RUnlock()
+	ident := authz.Snapshot().Identifier
+	if prob := wfe.verifyWildcardChallenge(ident, chalType); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
 
 	// If the identifier value is for a wildcard domain then strip the wildcard
 	// prefix before dispatching the validation to ensure the base domain is
@@ -2312,14 +2822,19 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		ident.Value = strings.TrimPrefix(ident.Value, "*.")
 	}
 
+	// The VA verifies the CSR submitted for an onion-csr-01 challenge
+	if onionCSR != nil {
+		existingChal.Update(func(chal *core.Challenge) {
+			chal.OnionCSR = onionCSR
+		})
+	}
+
 	// Submit a validation job to the VA, this will be processed asynchronously
-	wfe.va.ValidateChallenge(ident, existingChal, existingAcct)
+	acctURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, existingAcct.ID))
+	wfe.va.ValidateChallenge(detachRequest(request.Context()), ident, existingChal, existingAcct, acctURL)
 
-	// Lock the challenge for reading in order to write the response
-	existingChal.RLock()
-	defer existingChal.RUnlock()
 	response.Header().Add("Link", link(existingChal.Authz.URL, "up"))
-	err := wfe.writeJSONResponse(response, http.StatusOK, existingChal.Challenge)
+	err := wfe.writeJSONResponse(response, http.StatusOK, existingChal.Snapshot().Challenge)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshaling challenge"), response)
 		return
@@ -2372,17 +2887,6 @@ func (wfe *WebFrontEndImpl) Certificate(
 	ctx context.Context,
 	response http.ResponseWriter,
 	request *http.Request) {
-	postData, prob := wfe.verifyPOST(request, wfe.lookupJWK)
-	if prob != nil {
-		wfe.sendError(prob, response)
-		return
-	}
-	acct, prob := wfe.validPOSTAsGET(postData)
-	if prob != nil {
-		wfe.sendError(prob, response)
-		return
-	}
-
 	serialAlt := strings.TrimPrefix(request.URL.Path, certPath)
 	serial, no, err := getAlternateNo(serialAlt)
 	if err != nil {
@@ -2395,25 +2899,40 @@ func (wfe *WebFrontEndImpl) Certificate(
 		return
 	}
 
-	if cert.AccountID != acct.ID {
-		response.WriteHeader(http.StatusUnauthorized)
+	if request.Method == http.MethodPost {
+		postData, prob := wfe.verifyPOST(request, wfe.lookupJWK)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		acct, prob := wfe.validPOSTAsGET(postData)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		if cert.AccountID != acct.ID {
+			response.WriteHeader(http.StatusUnauthorized)
+			wfe.sendError(acme.UnauthorizedProblem(
+				"Account authenticating request does not own certificate"), response)
+			return
+		}
+	} else if !cert.AllowGet || wfe.strict.forbidUnauthenticatedGET {
 		wfe.sendError(acme.UnauthorizedProblem(
-			"Account authenticating request does not own certificate"), response)
+			"Certificate does not allow unauthenticated GET requests"), response)
 		return
 	}
 
-	if no >= len(cert.IssuerChains) {
+	if no >= wfe.numberOfServedChains(cert) {
 		response.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	// Add links to alternate roots
 	basePath := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", certPath, serial))
-	addAlternateLinks(response, basePath, no, len(cert.IssuerChains))
+	addAlternateLinks(response, basePath, no, wfe.numberOfServedChains(cert))
 
-	response.Header().Set("Content-Type", "application/pem-certificate-chain; charset=utf-8")
-	response.WriteHeader(http.StatusOK)
-	_, _ = response.Write(cert.Chain(no))
+	cert, no = wfe.servedChain(cert, no)
+	wfe.writeCertificate(response, request, cert, no)
 }
 
 func (wfe *WebFrontEndImpl) writeJSONResponse(response http.ResponseWriter, status int, v interface{}) error {
@@ -2574,7 +3093,7 @@ func (wfe *WebFrontEndImpl) revokeCertByKeyID(
 				"The certificate being revoked is not associated with account %q",
 				existingAcct.ID))
 	}
-	return wfe.processRevocation(postData.body, authorizedToRevoke)
+	return wfe.processRevocation(postData.body, authorizedToRevoke, false)
 }
 
 func (wfe *WebFrontEndImpl) revokeCertByJWK(
@@ -2602,7 +3121,7 @@ func (wfe *WebFrontEndImpl) revokeCertByJWK(
 		return acme.UnauthorizedProblem(
 			"JWK embedded in revocation request must be the same public key as the cert to be revoked")
 	}
-	return wfe.processRevocation(postData.body, authorizedToRevoke)
+	return wfe.processRevocation(postData.body, authorizedToRevoke, true)
 }
 
 // authorizedToRevokeCert is a callback function that can be used to validate if
@@ -2613,25 +3132,26 @@ func (wfe *WebFrontEndImpl) revokeCertByJWK(
 // decision.
 type authorizedToRevokeCert func(*core.Certificate) *acme.ProblemDetails
 
+// processRevocation revokes the certificate of a revocation request. certKey is
+// whether the request is signed by the certificate key rather than an account
+// key.
 func (wfe *WebFrontEndImpl) processRevocation(
 	jwsBody []byte,
-	authorizedToRevoke authorizedToRevokeCert) *acme.ProblemDetails {
+	authorizedToRevoke authorizedToRevokeCert,
+	certKey bool) *acme.ProblemDetails {
 
 	// revokeCertReq is the ACME certificate information submitted by the client
 	var revokeCertReq struct {
 		Certificate string `json:"certificate"`
 		Reason      *uint  `json:"reason,omitempty"`
 	}
-	err := json.Unmarshal(jwsBody, &revokeCertReq)
+	err := wfe.unmarshalBody(jwsBody, &revokeCertReq)
 	if err != nil {
-		return acme.MalformedProblem("Error unmarshaling certificate revocation JSON body")
+		return acme.MalformedProblem("Error unmarshaling certificate revocation JSON body: " + err.Error())
 	}
 
-	if revokeCertReq.Reason != nil {
-		r := *revokeCertReq.Reason
-		if r == unusedRevocationReason || r > aACompromiseRevocationReason {
-			return acme.BadRevocationReasonProblem(fmt.Sprintf("Invalid revocation reason: %d", r))
-		}
+	if prob := wfe.revocation.checkReason(revokeCertReq.Reason, certKey); prob != nil {
+		return prob
 	}
 
 	derBytes, err := base64.RawURLEncoding.DecodeString(revokeCertReq.Certificate)
@@ -2655,40 +3175,56 @@ func (wfe *WebFrontEndImpl) processRevocation(
 		return prob
 	}
 
-	wfe.db.RevokeCertificate(&core.RevokedCertificate{
-		Certificate: cert,
-		RevokedAt:   time.Now(),
-		Reason:      revokeCertReq.Reason,
-	})
+	// Certificates of ACME STAR recurrent orders are short-lived and are not
+	// revoked. Clients should cancel the recurrent order instead.
+	if cert.Recurrent {
+		return acme.AutoRenewalRevocationNotSupportedProblem(
+			"Certificates issued for recurrent orders can not be revoked, cancel the order instead")
+	}
+
+	wfe.revokeCertificate(cert, revokeCertReq.Reason)
+	return nil
+}
+
+// checkBlockedKey returns a badPublicKey problem if the key is blocked because
+// a certificate for it was revoked for key compromise.
+func (wfe *WebFrontEndImpl) checkBlockedKey(key crypto.PublicKey) *acme.ProblemDetails {
+	blocked, err := wfe.db.IsKeyBlocked(key)
+	if err != nil {
+		return acme.BadPublicKeyProblem(fmt.Sprintf("Error checking the public key: %s", err))
+	}
+	if blocked {
+		return acme.BadPublicKeyProblem("Public key is forbidden, a certificate for it was revoked for key compromise")
+	}
 	return nil
 }
 
 // Verify the External Account Binding in the request and return the same JSON
-// object that was given in the request if successful. If no External Account
-// Binding was given then return nil however if it is required by the server
-// then error.
+// object that was given in the request and the ID of its key if successful. If
+// no External Account Binding was given then return nil however if it is
+// required by the server then error.
 func (wfe *WebFrontEndImpl) verifyEAB(
 	newAcctReq newAccountRequest,
-	outerPostData *authenticatedPOST) (*acme.JSONSigned, *acme.ProblemDetails) {
+	outerPostData *authenticatedPOST) (*acme.JSONSigned, string, *acme.ProblemDetails) {
 	if newAcctReq.ExternalAccountBinding == nil {
-		if wfe.requireEAB {
-			return nil, acme.ExternalAccountRequiredProblem(
+		if wfe.externalAccountRequired() {
+			return nil, "", acme.ExternalAccountRequiredProblem(
 				"ACME server policy requires newAccount requests must include a value for the 'externalAccountBinding' field")
 		}
 
-		return nil, nil
+		return nil, "", nil
 	}
 
 	//1.  Verify that the value of the field is a well-formed JWS
 	eabBytes, err := json.Marshal(newAcctReq.ExternalAccountBinding)
 	if err != nil {
-		return nil, acme.InternalErrorProblem(
+		return nil, "", acme.InternalErrorProblem(
 			fmt.Sprintf("failed to encode external account binding JSON structure: %s", err))
 	}
 
 	eab, err := jose.ParseSigned(string(eabBytes))
 	if err != nil {
-		return nil, acme.MalformedProblem(
+		return nil, "", acme.MalformedProblem(
 			fmt.Sprintf("failed to decode external account binding: %s", err))
 	}
 
@@ -2699,21 +3235,28 @@ func (wfe *WebFrontEndImpl) verifyEAB(
 	//-  The "url" field MUST be set to the same value as the outer JWS
 	keyID, prob := wfe.verifyEABPayloadHeader(eab, outerPostData)
 	if prob != nil {
-		return nil, prob
+		return nil, "", prob
 	}
 
 	//3.  Retrieve the MAC key corresponding to the key identifier in the
 	//    "kid" field
-	key, ok := wfe.db.GetExtenalAccountKeyByID(keyID)
-	if !ok {
-		return nil, acme.UnauthorizedProblem(
+	key := wfe.db.GetExternalAccountKeyByID(keyID)
+	if key == nil {
+		return nil, "", acme.UnauthorizedProblem(
 			"the field 'kid' references a key that is not known to the ACME server")
 	}
+	if key.Disabled {
+		return nil, "", acme.UnauthorizedProblem(
+			"the field 'kid' references a key that was disabled")
+	}
+	if prob := wfe.checkEABKeyPolicy(key); prob != nil {
+		return nil, "", prob
+	}
 
 	//4.  Verify that the MAC on the JWS verifies using that MAC key
-	payload, err := eab.Verify(key)
+	payload, err := eab.Verify(key.Key)
 	if err != nil {
-		return nil, acme.UnauthorizedProblem(
+		return nil, "", acme.UnauthorizedProblem(
 			fmt.Sprintf("external account binding JWS verification error: %s", err))
 	}
 
@@ -2721,12 +3264,12 @@ func (wfe *WebFrontEndImpl) verifyEAB(
 	//    used to verify the outer JWS (i.e., the "jwk" field of the outer
 	//    JWS)
 	if prob := wfe.verifyEABMatchesKey(payload, outerPostData.jwk); prob != nil {
-		return nil, prob
+		return nil, "", prob
 	}
 
 	wfe.log.Printf("Successful newAccount Binding with CA using kid %q", keyID)
 
-	return newAcctReq.ExternalAccountBinding, nil
+	return newAcctReq.ExternalAccountBinding, keyID, nil
 }
 
 // verifyEABPayloadHeader will verify the protected header object of the
@@ -2771,8 +3314,7 @@ func (wfe *WebFrontEndImpl) verifyEABPayloadHeader(innerJWS *jose.JSONWebSignatu
 // Binding object contains the same key that was used to sign the full
 // newAccount request JSON.
 func (wfe *WebFrontEndImpl) verifyEABMatchesKey(payload []byte, jwk *jose.JSONWebKey) *acme.ProblemDetails {
-	var payloadJWK *jose.JSONWebKey
-	err := json.Unmarshal(payload, &payloadJWK)
+	payloadJWK, err := core.UnmarshalJWK(payload)
 	if err != nil {
 		return acme.MalformedProblem(
 			fmt.Sprintf("external account binding JWK payload malformed: %s", err))
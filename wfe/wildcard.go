@@ -0,0 +1,62 @@
+package wfe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// WildcardPolicy configures policies for wildcard identifiers stricter than
+// Pebble's default, which issues certificates for wildcard identifiers of any
+// depth.
+type WildcardPolicy struct {
+	// Reject newOrder requests for wildcard identifiers
+	Disallow bool
+	// Reject attempts of challenges other than dns-01 for wildcard identifiers,
+	// which can be offered with OfferedChallenges
+	DNS01Only bool
+	// Maximum number of labels of the domain a wildcard identifier covers, e.g.
+	// 2 for "*.example.com". Zero means no limit.
+	MaxLabels int
+}
+
+// SetWildcardPolicy configures the policies for wildcard identifiers. It must
+// be called before the WFE starts serving requests.
+func (wfe *WebFrontEndImpl) SetWildcardPolicy(policy WildcardPolicy) error {
+	if policy.MaxLabels < 0 {
+		return fmt.Errorf("wildcard max labels must not be negative")
+	}
+	wfe.wildcardPolicy = policy
+	return nil
+}
+
+// verifyWildcard checks a wildcard DNS identifier of a new order against the
+// wildcard policy.
+func (wfe *WebFrontEndImpl) verifyWildcard(rawDomain string) *acme.ProblemDetails {
+	if wfe.wildcardPolicy.Disallow {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"Order included wildcard identifier %q, which this CA doesn't issue certificates for",
+			rawDomain))
+	}
+	base := strings.TrimPrefix(rawDomain, "*.")
+	if labels := strings.Count(base, ".") + 1; wfe.wildcardPolicy.MaxLabels > 0 &&
+		labels > wfe.wildcardPolicy.MaxLabels {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"Order included wildcard identifier %q covering a domain of %d labels, more than %d",
+			rawDomain, labels, wfe.wildcardPolicy.MaxLabels))
+	}
+	return nil
+}
+
+// verifyWildcardChallenge checks that the type of a challenge for a wildcard
+// identifier may be attempted.
+func (wfe *WebFrontEndImpl) verifyWildcardChallenge(ident acme.Identifier, chalType string) *acme.ProblemDetails {
+	if !wfe.wildcardPolicy.DNS01Only || !strings.HasPrefix(ident.Value, "*.") ||
+		chalType == acme.ChallengeDNS01 {
+		return nil
+	}
+	return acme.UnauthorizedProblem(fmt.Sprintf(
+		"Wildcard identifier %q can only be validated with %s challenges, not %s",
+		ident.Value, acme.ChallengeDNS01, chalType))
+}